@@ -0,0 +1,178 @@
+package pogreb
+
+import (
+	"context"
+	"sync"
+)
+
+type labelContextKey struct{}
+
+// WithLabel returns a context attributing the operations performed with it to the label, e.g. a crawl job ID.
+// Labels are accounted by the context methods of a DB opened with Options.Accounting,
+// the operations performed without a label are attributed to the empty label.
+func WithLabel(ctx context.Context, label string) context.Context {
+	return context.WithValue(ctx, labelContextKey{}, label)
+}
+
+// LabelFromContext returns the label set by WithLabel.
+// The returned bool is false if the context has no label.
+func LabelFromContext(ctx context.Context) (string, bool) {
+	label, ok := ctx.Value(labelContextKey{}).(string)
+	return label, ok
+}
+
+// Usage holds the operations and the stored bytes attributed to a label.
+type Usage struct {
+	Puts    int64 // Number of keys written.
+	Gets    int64 // Number of key lookups.
+	Deletes int64 // Number of keys deleted.
+
+	// Bytes is the size of the records written to the datalog less the records of the deleted keys.
+	// A record stores the key as it's written, with its namespace, or its fingerprint, and the encryption overhead.
+	Bytes int64
+}
+
+// accounting tracks the usage of each label. A nil accounting discards usage.
+type accounting struct {
+	mu     sync.Mutex
+	labels map[string]*Usage
+}
+
+func newAccounting(opts *Options) *accounting {
+	if !opts.Accounting {
+		return nil
+	}
+	return &accounting{labels: map[string]*Usage{}}
+}
+
+// add attributes usage to the label of the context, or to the empty label if it has none.
+func (a *accounting) add(ctx context.Context, usage Usage) {
+	if a == nil {
+		return
+	}
+	label, _ := LabelFromContext(ctx)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	u := a.labels[label]
+	if u == nil {
+		u = &Usage{}
+		a.labels[label] = u
+	}
+	u.Puts += usage.Puts
+	u.Gets += usage.Gets
+	u.Deletes += usage.Deletes
+	u.Bytes += usage.Bytes
+}
+
+// snapshot returns the usage of each label, nil if accounting is disabled.
+func (a *accounting) snapshot() map[string]Usage {
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	usage := make(map[string]Usage, len(a.labels))
+	for label, u := range a.labels {
+		usage[label] = *u
+	}
+	return usage
+}
+
+// load restores the usage persisted in the DB meta.
+func (a *accounting) load(usage map[string]Usage) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for label, u := range usage {
+		u := u
+		a.labels[label] = &u
+	}
+}
+
+// recordSize returns the size of the datalog record storing the key.
+func recordSize(key []byte) int64 {
+	if isLargeKey(key) {
+		return int64(largeKeyHeaderSize + len(key) + 4)
+	}
+	return int64(encodedRecordSize(uint32(len(key))))
+}
+
+// recordSize returns the size of the record storing the key with the current options of the datalog,
+// including the encryption tag and the record flags.
+func (dl *datalog) recordSize(key []byte) int64 {
+	size := recordSize(key)
+	if dl.aead != nil {
+		size += encryptionTagSize
+	}
+	if dl.opts.RecordFlags {
+		size += recordFlagsSize
+	}
+	return size
+}
+
+// PutContext is like Put, attributing the write to the label of the context.
+// Writes are accounted by the write chain, writes of the methods without a context are attributed to the empty label.
+func (db *DB) PutContext(ctx context.Context, key []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	key, err := db.checkKey(key)
+	if err != nil {
+		return err
+	}
+	return db.putChecked(ctx, key)
+}
+
+// HasContext is like Has, attributing the lookup to the label of the context.
+func (db *DB) HasContext(ctx context.Context, key []byte) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	found, err := db.Has(key)
+	if err != nil {
+		return false, err
+	}
+	db.accounting.add(ctx, Usage{Gets: 1})
+	return found, nil
+}
+
+// HasOrPutContext is like HasOrPut, attributing the lookup and the write, if the key was inserted,
+// to the label of the context.
+func (db *DB) HasOrPutContext(ctx context.Context, key []byte) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	key, err := db.checkKey(key)
+	if err != nil {
+		return false, err
+	}
+	found, err := db.hasOrPutChecked(ctx, key)
+	if err != nil {
+		return false, err
+	}
+	db.accounting.add(ctx, Usage{Gets: 1})
+	return found, nil
+}
+
+// Usage returns the usage of each label. The usage is persisted in the DB meta by Close and checkpoints,
+// the usage recorded since the last of them is lost if the process crashes.
+// It returns nil unless the DB was opened with Options.Accounting.
+func (db *DB) Usage() map[string]Usage {
+	return db.accounting.snapshot()
+}
+
+// LabelUsage returns the usage of the label, see Usage.
+func (db *DB) LabelUsage(label string) Usage {
+	a := db.accounting
+	if a == nil {
+		return Usage{}
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if u := a.labels[label]; u != nil {
+		return *u
+	}
+	return Usage{}
+}
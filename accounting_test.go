@@ -0,0 +1,103 @@
+package pogreb
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/domaincrawler/pogreb/internal/assert"
+)
+
+func TestAccounting(t *testing.T) {
+	db, err := createTestDB(&Options{Accounting: true})
+	assert.Nil(t, err)
+
+	jobA := WithLabel(context.Background(), "job-a")
+	jobB := WithLabel(context.Background(), "job-b")
+	assert.Nil(t, db.PutContext(jobA, []byte("abc")))
+	assert.Nil(t, db.PutContext(jobA, []byte("de")))
+	_, err = db.HasContext(jobA, []byte("abc"))
+	assert.Nil(t, err)
+	found, err := db.HasOrPutContext(jobB, []byte("abc"))
+	assert.Nil(t, err)
+	assert.Equal(t, true, found)
+	found, err = db.HasOrPutContext(jobB, []byte("f"))
+	assert.Nil(t, err)
+	assert.Equal(t, false, found)
+
+	// Operations without a label are attributed to the empty label, whichever method writes the keys.
+	assert.Nil(t, db.PutContext(context.Background(), []byte("g")))
+	assert.Nil(t, db.Put([]byte("h")))
+	_, err = db.Import(strings.NewReader("i\nh\n"), nil)
+	assert.Nil(t, err)
+	done := make(chan error, 1)
+	db.PutAsync([]byte("j"), func(err error) { done <- err })
+	assert.Nil(t, <-done)
+
+	assert.Equal(t, Usage{Puts: 2, Gets: 1, Bytes: 9 + 8}, db.LabelUsage("job-a"))
+	assert.Equal(t, map[string]Usage{
+		"job-a": {Puts: 2, Gets: 1, Bytes: 17},
+		"job-b": {Puts: 1, Gets: 2, Bytes: 7},
+		"":      {Puts: 4, Bytes: 28},
+	}, db.Usage())
+	assert.Equal(t, Usage{}, db.LabelUsage("job-c"))
+
+	ctx, cancel := context.WithCancel(jobA)
+	cancel()
+	assert.Equal(t, context.Canceled, db.PutContext(ctx, []byte("i")))
+	assert.Nil(t, db.Close())
+}
+
+func TestAccountingStoredSize(t *testing.T) {
+	job := WithLabel(context.Background(), "job")
+	opts := &Options{Accounting: true, StoreFingerprintsOnly: true, FingerprintSize: 8, EncryptionKey: bytes.Repeat([]byte{1}, 16)}
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+
+	// The accounted records store the fingerprint of the key, its namespace and the encryption tag.
+	assert.Nil(t, db.PutContext(job, []byte("https://example.com/a")))
+	ns := db.Namespace("ns")
+	assert.Nil(t, ns.Put([]byte("https://example.com/b")))
+	assert.Equal(t, Usage{Puts: 1, Bytes: 2 + 8 + 4 + encryptionTagSize}, db.LabelUsage("job"))
+	assert.Equal(t, Usage{Puts: 1, Bytes: 2 + 4 + 8 + 4 + encryptionTagSize}, db.LabelUsage(""))
+	assert.Equal(t, db.LabelUsage("job").Bytes+db.LabelUsage("").Bytes, int64(atomic.LoadUint64(&db.datalog.numBytes)))
+
+	// Deletes subtract the records of the deleted keys.
+	assert.Nil(t, db.DeleteContext(job, []byte("https://example.com/a")))
+	assert.Nil(t, db.DeleteContext(job, []byte("https://example.com/missing")))
+	assert.Equal(t, Usage{Puts: 1, Deletes: 1}, db.LabelUsage("job"))
+	n, err := db.DeleteFunc(func(key []byte) bool { return true })
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, Usage{Puts: 1, Deletes: 1}, db.LabelUsage(""))
+
+	// The usage is persisted in the DB meta.
+	assert.Nil(t, db.PutContext(job, []byte("https://example.com/c")))
+	want := db.Usage()
+	assert.Nil(t, db.Close())
+	opts.FileSystem = testFS
+	db, err = Open(testDBName, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, want, db.Usage())
+	assert.Nil(t, db.PutContext(job, []byte("https://example.com/d")))
+	assert.Equal(t, int64(3), db.LabelUsage("job").Puts)
+	assert.Nil(t, db.Close())
+
+	// Opening the DB without accounting discards the usage.
+	db, err = Open(testDBName, &Options{FileSystem: testFS, StoreFingerprintsOnly: true, FingerprintSize: 8,
+		EncryptionKey: opts.EncryptionKey})
+	assert.Nil(t, err)
+	assert.Nil(t, db.Usage())
+	assert.Nil(t, db.Close())
+}
+
+func TestAccountingDisabled(t *testing.T) {
+	db, err := createTestDB(nil)
+	assert.Nil(t, err)
+	assert.Nil(t, db.PutContext(WithLabel(context.Background(), "job-a"), []byte("abc")))
+	assert.Nil(t, db.Usage())
+	assert.Equal(t, Usage{}, db.LabelUsage("job-a"))
+	assert.Nil(t, db.Close())
+}
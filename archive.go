@@ -0,0 +1,288 @@
+package pogreb
+
+import (
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/domaincrawler/pogreb/fs"
+	"github.com/domaincrawler/pogreb/internal/errors"
+)
+
+// archiveCacheExt is the extension of the local copies of archived segments fetched by lookups.
+const archiveCacheExt = ".arc"
+
+var (
+	errNoArchiver       = errors.New("Options.Archiver is not set")
+	errArchivedReadOnly = errors.New("archived segments are read-only")
+)
+
+// Archiver stores sealed segments in cold storage, e.g. an object store.
+// Implementations must be safe for concurrent use by multiple goroutines.
+type Archiver interface {
+	// Archive stores the contents of the segment under the name.
+	// The segment is removed from the local file system only after Archive returns successfully.
+	Archive(name string, r io.Reader) error
+
+	// Fetch returns the contents of the segment stored by Archive.
+	Fetch(name string) (io.ReadCloser, error)
+}
+
+// ArchiveSegments hands sealed segments to Options.Archiver and replaces their local files with stubs.
+// Lookups that need a record from an archived segment fetch the segment and keep a local copy until the DB is closed.
+// Recovery and Verify fetch every archived segment, compaction skips them.
+// It returns the number of archived segments. Calling it from Options.EventHandler on SegmentSealed
+// archives segments as soon as they rotate.
+func (db *DB) ArchiveSegments() (int, error) {
+	if db.opts.Archiver == nil {
+		return 0, errNoArchiver
+	}
+	if db.opts.ReadOnly {
+		return 0, errReadOnly
+	}
+	// Compaction and truncation remove segments.
+	if !atomic.CompareAndSwapInt32(&db.compactionRunning, 0, 1) {
+		return 0, ErrBusy
+	}
+	defer atomic.StoreInt32(&db.compactionRunning, 0)
+
+	db.mu.RLock()
+	var segments []*segment
+	for _, seg := range db.datalog.segmentsBySequenceID() {
+		if seg.meta.Full && seg != db.datalog.curSeg && !seg.archived() {
+			segments = append(segments, seg)
+		}
+	}
+	db.mu.RUnlock()
+
+	for i, seg := range segments {
+		if err := db.archiveSegment(seg); err != nil {
+			return i, errors.Wrapf(err, "archiving segment %s", seg.name)
+		}
+	}
+	return len(segments), nil
+}
+
+func (db *DB) archiveSegment(seg *segment) error {
+	// Full segments aren't modified, they are read without holding the locks.
+	if err := db.opts.Archiver.Archive(seg.name, io.NewSectionReader(seg.File, 0, seg.size)); err != nil {
+		return err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.datalog.mu.Lock()
+	defer db.datalog.mu.Unlock()
+	// The header is marked first, a crash before the truncation leaves a complete local file, which is used as is.
+	if err := seg.setArchived(seg.size); err != nil {
+		return err
+	}
+	if err := seg.Sync(); err != nil {
+		return err
+	}
+	if err := seg.File.Truncate(headerSize); err != nil {
+		return err
+	}
+	if err := seg.Sync(); err != nil {
+		return err
+	}
+	if err := seg.File.Close(); err != nil {
+		return err
+	}
+	seg.File = newArchivedFile(db.opts, seg.name, seg.size)
+	db.opts.Logger.Logf(LogInfo, "archived segment %s", seg.name)
+	return nil
+}
+
+// setArchived rewrites the header, marking the file as the stub of an archived segment of the size.
+func (f *file) setArchived(size int64) error {
+	h := newHeader()
+	h.flags = f.flags | headerFlagArchived
+	h.checksum = f.checksum
+	h.archivedSize = size
+	h.nonce = f.nonce
+	h.keyCheck = f.keyCheck
+	h.stats = f.stats
+	data, err := h.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteAt(data, 0); err != nil {
+		return err
+	}
+	f.flags = h.flags
+	f.archivedSize = size
+	return nil
+}
+
+// archived returns true if the segment data is in the archive.
+func (seg *segment) archived() bool {
+	_, ok := seg.File.(*archivedFile)
+	return ok
+}
+
+// anyArchived returns true if any of the segments is archived.
+func anyArchived(segments []*segment) bool {
+	for _, seg := range segments {
+		if seg.archived() {
+			return true
+		}
+	}
+	return false
+}
+
+// openArchivedStub replaces the opened stub of an archived segment with a file fetching the segment on demand.
+// Files marked as archived which weren't truncated yet are used as is.
+func openArchivedStub(opts *Options, name string, f *file) error {
+	if f.flags&headerFlagArchived == 0 || f.size >= f.archivedSize {
+		return nil
+	}
+	if opts.Archiver == nil {
+		return errors.Wrapf(errNoArchiver, "opening archived segment %s", name)
+	}
+	if err := f.File.Close(); err != nil {
+		return err
+	}
+	f.File = newArchivedFile(opts, name, f.archivedSize)
+	f.size = f.archivedSize
+	return nil
+}
+
+// archivedFile is a read-only segment file, fetched from the archive into a local copy on the first read.
+type archivedFile struct {
+	opts  *Options
+	name  string
+	size  int64
+	mu    sync.Mutex
+	local fs.File // Local copy, nil until fetched.
+	pos   int64   // Offset of Read before the segment is fetched.
+}
+
+func newArchivedFile(opts *Options, name string, size int64) *archivedFile {
+	return &archivedFile{opts: opts, name: name, size: size}
+}
+
+// fetch returns the local copy of the segment, fetching it from the archive if needed.
+func (f *archivedFile) fetch() (fs.File, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.local != nil {
+		return f.local, nil
+	}
+	r, err := f.opts.Archiver.Fetch(f.name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "fetching archived segment %s", f.name)
+	}
+	defer r.Close()
+	cacheName := f.name + archiveCacheExt
+	local, err := f.opts.FileSystem.OpenFile(cacheName, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0640)
+	if err != nil {
+		return nil, err
+	}
+	n, err := io.Copy(local, r)
+	if err == nil && n != f.size {
+		err = errors.Wrapf(ErrCorrupted, "archived segment %s has %d bytes, expected %d", f.name, n, f.size)
+	}
+	if err == nil {
+		_, err = local.Seek(f.pos, io.SeekStart)
+	}
+	if err != nil {
+		_ = local.Close()
+		_ = f.opts.FileSystem.Remove(cacheName)
+		return nil, err
+	}
+	f.opts.Logger.Logf(LogInfo, "fetched archived segment %s", f.name)
+	f.local = local
+	return local, nil
+}
+
+func (f *archivedFile) Read(p []byte) (int, error) {
+	local, err := f.fetch()
+	if err != nil {
+		return 0, err
+	}
+	return local.Read(p)
+}
+
+func (f *archivedFile) ReadAt(p []byte, off int64) (int, error) {
+	local, err := f.fetch()
+	if err != nil {
+		return 0, err
+	}
+	return local.ReadAt(p, off)
+}
+
+func (f *archivedFile) Slice(start int64, end int64) ([]byte, error) {
+	local, err := f.fetch()
+	if err != nil {
+		return nil, err
+	}
+	return local.Slice(start, end)
+}
+
+func (f *archivedFile) Seek(offset int64, whence int) (int64, error) {
+	f.mu.Lock()
+	local := f.local
+	if local == nil {
+		// Seeking doesn't fetch the segment, e.g. when it's opened.
+		defer f.mu.Unlock()
+		switch whence {
+		case io.SeekStart:
+			f.pos = offset
+		case io.SeekCurrent:
+			f.pos += offset
+		case io.SeekEnd:
+			f.pos = f.size + offset
+		}
+		return f.pos, nil
+	}
+	f.mu.Unlock()
+	return local.Seek(offset, whence)
+}
+
+func (f *archivedFile) Write(p []byte) (int, error) {
+	return 0, errArchivedReadOnly
+}
+
+func (f *archivedFile) WriteAt(p []byte, off int64) (int, error) {
+	return 0, errArchivedReadOnly
+}
+
+func (f *archivedFile) Truncate(size int64) error {
+	return errArchivedReadOnly
+}
+
+func (f *archivedFile) Sync() error {
+	return nil
+}
+
+func (f *archivedFile) Stat() (os.FileInfo, error) {
+	return archivedFileInfo{f}, nil
+}
+
+// Close removes the local copy of the segment.
+func (f *archivedFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.local == nil {
+		return nil
+	}
+	err := f.local.Close()
+	f.local = nil
+	if rerr := f.opts.FileSystem.Remove(f.name + archiveCacheExt); err == nil {
+		err = rerr
+	}
+	return err
+}
+
+type archivedFileInfo struct {
+	f *archivedFile
+}
+
+func (fi archivedFileInfo) Name() string       { return fi.f.name }
+func (fi archivedFileInfo) Size() int64        { return fi.f.size }
+func (fi archivedFileInfo) Mode() os.FileMode  { return 0640 }
+func (fi archivedFileInfo) ModTime() time.Time { return time.Time{} }
+func (fi archivedFileInfo) IsDir() bool        { return false }
+func (fi archivedFileInfo) Sys() interface{}   { return nil }
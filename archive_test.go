@@ -0,0 +1,135 @@
+package pogreb
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/domaincrawler/pogreb/internal/assert"
+)
+
+type memArchiver struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	fetches []string
+}
+
+func newMemArchiver() *memArchiver {
+	return &memArchiver{objects: map[string][]byte{}}
+}
+
+func (a *memArchiver) Archive(name string, r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.objects[name] = data
+	return nil
+}
+
+func (a *memArchiver) Fetch(name string) (io.ReadCloser, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	data, ok := a.objects[name]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	a.fetches = append(a.fetches, name)
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (a *memArchiver) takeFetches() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	fetches := a.fetches
+	a.fetches = nil
+	return fetches
+}
+
+func TestArchiveSegments(t *testing.T) {
+	arc := newMemArchiver()
+	opts := &Options{
+		maxSegmentSize: 1024,
+		Archiver:       arc,
+	}
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+	// 73 records of 7 bytes fill the first segment.
+	for i := 0; i < 75; i++ {
+		assert.Nil(t, db.Put([]byte{byte(i)}))
+	}
+	n, err := db.ArchiveSegments()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, headerSize+73*7, len(arc.objects[segmentName(0, 1)]))
+	fi, err := testFS.Stat(filepath.Join(testDBName, segmentName(0, 1)))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(headerSize), fi.Size())
+
+	// Segments are archived once.
+	n, err = db.ArchiveSegments()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+
+	// Keys in the current segment don't fetch archived segments.
+	has, err := db.Has([]byte{74})
+	assert.Nil(t, err)
+	assert.Equal(t, true, has)
+	assert.Equal(t, 0, len(arc.takeFetches()))
+
+	has, err = db.Has([]byte{1})
+	assert.Nil(t, err)
+	assert.Equal(t, true, has)
+	has, err = db.Has([]byte{2})
+	assert.Nil(t, err)
+	assert.Equal(t, true, has)
+	assert.Equal(t, []string{segmentName(0, 1)}, arc.takeFetches())
+	assert.Nil(t, db.Close())
+	assert.Equal(t, false, fileExists(filepath.Join(testDBName, segmentName(0, 1)+archiveCacheExt)))
+
+	// Databases with archived segments require the archiver.
+	_, err = Open(testDBName, &Options{FileSystem: testFS})
+	assert.NotNil(t, err)
+
+	db, err = Open(testDBName, &Options{FileSystem: testFS, Archiver: arc})
+	assert.Nil(t, err)
+	for i := 0; i < 75; i++ {
+		has, err := db.Has([]byte{byte(i)})
+		assert.Nil(t, err)
+		assert.Equal(t, true, has)
+	}
+	assert.Equal(t, []string{segmentName(0, 1)}, arc.takeFetches())
+	assert.Nil(t, db.Close())
+}
+
+func TestArchiveSegmentsRecovery(t *testing.T) {
+	arc := newMemArchiver()
+	db, err := createTestDB(&Options{maxSegmentSize: 1024, Archiver: arc})
+	assert.Nil(t, err)
+	for i := 0; i < 75; i++ {
+		assert.Nil(t, db.Put([]byte{byte(i)}))
+	}
+	_, err = db.ArchiveSegments()
+	assert.Nil(t, err)
+	simulateCrash(t, db)
+
+	// Recovery reads the archived segment.
+	db, err = Open(testDBName, &Options{FileSystem: testFS, maxSegmentSize: 1024, Archiver: arc})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(75), db.Count())
+	assert.Equal(t, []string{segmentName(0, 1)}, arc.takeFetches())
+	assert.Nil(t, db.Close())
+}
+
+func TestArchiveSegmentsNoArchiver(t *testing.T) {
+	db, err := createTestDB(nil)
+	assert.Nil(t, err)
+	_, err = db.ArchiveSegments()
+	assert.Equal(t, errNoArchiver, err)
+	assert.Nil(t, db.Close())
+}
@@ -0,0 +1,127 @@
+package pogreb
+
+import (
+	"sync"
+)
+
+const (
+	asyncQueueSize   = 1024 // Maximum number of queued asynchronous writes.
+	maxAsyncBatchLen = 1024 // Maximum number of asynchronous writes committed with a single sync.
+)
+
+type asyncPut struct {
+	key  []byte
+	done func(error)
+}
+
+// asyncWriter is a goroutine writing keys queued by PutAsync.
+// Queued writes are applied in batches, each batch is made durable with a single sync.
+type asyncWriter struct {
+	mu      sync.RWMutex // Guards closed and sending to reqs.
+	once    sync.Once
+	reqs    chan asyncPut
+	closed  bool
+	stopped chan struct{}
+}
+
+func (aw *asyncWriter) start(db *DB) {
+	aw.once.Do(func() {
+		aw.reqs = make(chan asyncPut, asyncQueueSize)
+		aw.stopped = make(chan struct{})
+		go aw.run(db)
+	})
+}
+
+func (aw *asyncWriter) run(db *DB) {
+	defer close(aw.stopped)
+	batch := make([]asyncPut, 0, maxAsyncBatchLen)
+	for req := range aw.reqs {
+		batch = append(batch[:0], req)
+	drain:
+		for len(batch) < maxAsyncBatchLen {
+			select {
+			case req, ok := <-aw.reqs:
+				if !ok {
+					break drain
+				}
+				batch = append(batch, req)
+			default:
+				break drain
+			}
+		}
+		db.writeAsyncBatch(batch)
+	}
+}
+
+func (aw *asyncWriter) enqueue(db *DB, req asyncPut) {
+	aw.mu.RLock()
+	defer aw.mu.RUnlock()
+	if aw.closed {
+		req.done(errClosed)
+		return
+	}
+	aw.start(db)
+	aw.reqs <- req
+}
+
+// close stops accepting new writes and waits until the queued writes are committed.
+func (aw *asyncWriter) close() {
+	aw.mu.Lock()
+	aw.closed = true
+	started := aw.reqs != nil
+	if started {
+		close(aw.reqs)
+	}
+	aw.mu.Unlock()
+	if started {
+		<-aw.stopped
+	}
+}
+
+func (db *DB) writeAsyncBatch(batch []asyncPut) {
+	errs := make([]error, len(batch))
+	var syncErr error
+	func() {
+		db.mu.RLock()
+		defer db.mu.RUnlock()
+		written := false
+		for i, req := range batch {
+			h := db.hash(req.key)
+			shard := db.index.shard(h)
+			shard.mu.Lock()
+			errs[i] = db.write(shard, h, req.key)
+			shard.mu.Unlock()
+			if errs[i] == nil {
+				written = true
+			}
+		}
+		if written {
+			syncErr = db.sync()
+		}
+	}()
+	for i, req := range batch {
+		err := errs[i]
+		if err == nil {
+			err = syncErr
+		}
+		if err == nil {
+			db.writeChain.after(req.key)
+		}
+		req.done(err)
+	}
+}
+
+// PutAsync queues the key to be written by a background writer and returns immediately.
+// The done callback is invoked from the writer goroutine once the key is durably stored,
+// or with an error if the write fails. Queued writes are committed in batches sharing a single sync.
+// With the SyncNever policy batches aren't synced, the callback only reports that the key was written.
+// PutAsync blocks only when the write queue is full.
+func (db *DB) PutAsync(key []byte, done func(error)) {
+	key, err := db.checkKey(key)
+	if err != nil {
+		done(err)
+		return
+	}
+	db.metrics.Puts.Add(1)
+	db.asyncWriter.enqueue(db, asyncPut{key: cloneBytes(key), done: done})
+}
@@ -2,19 +2,28 @@ package pogreb
 
 import (
 	"encoding/binary"
+
+	"github.com/domaincrawler/pogreb/fs"
 )
 
 const (
-	bucketSize     = 512
-	slotsPerBucket = 42 // Maximum number of slots possible to fit in a 512-byte bucket.
+	bucketSize         = 512
+	slotsPerBucket     = 31 // Maximum number of slots possible to fit in a 512-byte bucket.
+	wideSlotsPerBucket = 25 // Maximum number of slots with 64-bit hashes possible to fit in a 512-byte bucket.
+	slotSize           = 16 // Size of an encoded slot.
+	wideSlotSize       = 20 // Size of an encoded slot with a 64-bit hash.
+
+	// A bucket ends with the offset of its overflow bucket and the checksum of the preceding bytes.
+	bucketNextOffset     = bucketSize - 12
+	bucketChecksumOffset = bucketSize - 4
 )
 
 // slot corresponds to a single item in the hash table.
 type slot struct {
-	hash      uint32
+	hash      uint64 // Only the low 32 bits are stored unless the index uses 64-bit hashes.
 	segmentID uint16
 	keySize   uint16
-	offset    uint32 // Offset of the record in a segment.
+	offset    uint64 // Offset of the record in a segment.
 }
 
 func (sl slot) kvSize() uint32 {
@@ -35,31 +44,89 @@ type bucketHandle struct {
 }
 
 func (b bucket) MarshalBinary() ([]byte, error) {
+	return b.marshal(false, ChecksumIEEE), nil
+}
+
+func (b *bucket) UnmarshalBinary(data []byte) error {
+	if !validBucket(data, ChecksumIEEE) {
+		return ErrCorrupted
+	}
+	b.unmarshal(data, false)
+	return nil
+}
+
+// marshal encodes the bucket, wideHash selects 64-bit slot hashes.
+func (b bucket) marshal(wideHash bool, checksum Checksum) []byte {
 	buf := make([]byte, bucketSize)
+	b.marshalTo(buf, wideHash, checksum)
+	return buf
+}
+
+// marshalTo encodes the bucket into buf, which must be bucketSize bytes long.
+func (b *bucket) marshalTo(buf []byte, wideHash bool, checksum Checksum) {
 	data := buf
-	for i := 0; i < slotsPerBucket; i++ {
+	for i := range data {
+		data[i] = 0
+	}
+	for i := 0; i < numSlots(wideHash); i++ {
 		sl := b.slots[i]
-		binary.LittleEndian.PutUint32(buf[:4], sl.hash)
+		if wideHash {
+			binary.LittleEndian.PutUint64(buf[:8], sl.hash)
+			buf = buf[wideSlotSize-slotSize:]
+		} else {
+			binary.LittleEndian.PutUint32(buf[:4], uint32(sl.hash))
+		}
 		binary.LittleEndian.PutUint16(buf[4:6], sl.segmentID)
 		binary.LittleEndian.PutUint16(buf[6:8], sl.keySize)
-		binary.LittleEndian.PutUint32(buf[8:12], sl.offset)
-		buf = buf[12:]
+		binary.LittleEndian.PutUint64(buf[8:16], sl.offset)
+		buf = buf[slotSize:]
 	}
-	binary.LittleEndian.PutUint64(buf, uint64(b.next))
-	return data, nil
+	binary.LittleEndian.PutUint64(data[bucketNextOffset:], uint64(b.next))
+	binary.LittleEndian.PutUint32(data[bucketChecksumOffset:], checksum.sum(data[:bucketChecksumOffset]))
 }
 
-func (b *bucket) UnmarshalBinary(data []byte) error {
-	for i := 0; i < slotsPerBucket; i++ {
-		_ = data[16] // bounds check hint to compiler; see golang.org/issue/14808
-		b.slots[i].hash = binary.LittleEndian.Uint32(data[:4])
+// validBucket returns true if the checksum of the encoded bucket matches.
+// Buckets which were never written, e.g. the buckets added by extending the main index, are all zeros.
+func validBucket(data []byte, checksum Checksum) bool {
+	sum := binary.LittleEndian.Uint32(data[bucketChecksumOffset:])
+	if sum == checksum.sum(data[:bucketChecksumOffset]) {
+		return true
+	}
+	if sum != 0 {
+		return false
+	}
+	for _, c := range data[:bucketChecksumOffset] {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (b *bucket) unmarshal(data []byte, wideHash bool) {
+	next := data[bucketNextOffset:]
+	for i := 0; i < numSlots(wideHash); i++ {
+		_ = data[20] // bounds check hint to compiler; see golang.org/issue/14808
+		if wideHash {
+			b.slots[i].hash = binary.LittleEndian.Uint64(data[:8])
+			data = data[wideSlotSize-slotSize:]
+		} else {
+			b.slots[i].hash = uint64(binary.LittleEndian.Uint32(data[:4]))
+		}
 		b.slots[i].segmentID = binary.LittleEndian.Uint16(data[4:6])
 		b.slots[i].keySize = binary.LittleEndian.Uint16(data[6:8])
-		b.slots[i].offset = binary.LittleEndian.Uint32(data[8:12])
-		data = data[12:]
+		b.slots[i].offset = binary.LittleEndian.Uint64(data[8:16])
+		data = data[slotSize:]
 	}
-	b.next = int64(binary.LittleEndian.Uint64(data))
-	return nil
+	b.next = int64(binary.LittleEndian.Uint64(next))
+}
+
+// numSlots returns the bucket capacity.
+func numSlots(wideHash bool) int {
+	if wideHash {
+		return wideSlotsPerBucket
+	}
+	return slotsPerBucket
 }
 
 //func (b *bucket) del(slotIdx int) {
@@ -76,18 +143,32 @@ func (b *bucketHandle) read() error {
 	if err != nil {
 		return err
 	}
-	return b.UnmarshalBinary(buf)
+	if !validBucket(buf, b.file.checksum) {
+		return &CorruptionError{Segment: b.file.name, Offset: b.offset, Reason: "bucket checksum mismatch"}
+	}
+	b.unmarshal(buf, b.wideHash())
+	return nil
 }
 
+// write writes the bucket. Files memory-mapped for writing are updated in place without a syscall.
 func (b *bucketHandle) write() error {
-	buf, err := b.MarshalBinary()
-	if err != nil {
-		return err
+	if mf, ok := b.file.File.(fs.WritableMmapFile); ok {
+		buf, err := mf.MutableSlice(b.offset, b.offset+int64(bucketSize))
+		if err != nil {
+			return err
+		}
+		b.marshalTo(buf, b.wideHash(), b.file.checksum)
+		return nil
 	}
-	_, err = b.file.WriteAt(buf, b.offset)
+	_, err := b.file.WriteAt(b.marshal(b.wideHash(), b.file.checksum), b.offset)
 	return err
 }
 
+// wideHash returns whether the bucket stores 64-bit slot hashes.
+func (b *bucketHandle) wideHash() bool {
+	return b.file.flags&headerFlagWideHash != 0
+}
+
 // slotWriter inserts and writes slots into a bucket.
 type slotWriter struct {
 	bucket      *bucketHandle
@@ -96,7 +177,7 @@ type slotWriter struct {
 }
 
 func (sw *slotWriter) insert(sl slot, idx *index) error {
-	if sw.slotIdx == slotsPerBucket {
+	if sw.slotIdx == idx.slotsPerBucket() {
 		// Bucket is full, create a new overflow bucket.
 		nextBucket, err := idx.createOverflowBucket()
 		if err != nil {
@@ -112,12 +193,12 @@ func (sw *slotWriter) insert(sl slot, idx *index) error {
 	return nil
 }
 
-func (sw *slotWriter) write() error {
+func (sw *slotWriter) write(idx *index) error {
 	// Write previous buckets first.
 	for i := len(sw.prevBuckets) - 1; i >= 0; i-- {
-		if err := sw.prevBuckets[i].write(); err != nil {
+		if err := idx.writeBucket(sw.prevBuckets[i]); err != nil {
 			return err
 		}
 	}
-	return sw.bucket.write()
+	return idx.writeBucket(sw.bucket)
 }
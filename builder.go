@@ -0,0 +1,217 @@
+package pogreb
+
+import (
+	"math/bits"
+	"sort"
+	"time"
+
+	"github.com/domaincrawler/pogreb/internal/errors"
+)
+
+// maxPresizeChunk is the maximum number of buckets appended to an index file at once.
+const maxPresizeChunk = 1 << 20
+
+var errBuilderNotEmpty = errors.New("builder requires a new database")
+
+// BuilderOptions holds the optional Builder parameters.
+type BuilderOptions struct {
+	// SortHashes makes Finish insert the keys into the index in the order of the index buckets,
+	// which writes the index sequentially. The slots of all keys are kept in memory, 16 bytes per key.
+	//
+	// Default: false, the segments are scanned and the keys are inserted in the order they were added.
+	SortHashes bool
+}
+
+// Builder bulk-loads keys into a new database.
+// Keys are appended to the datalog without updating the index. Finish builds the index in one pass,
+// presized for the number of added keys, which is several times faster than calling Put for every key.
+// Duplicate keys are indexed once. Builder methods aren't safe for concurrent use.
+type Builder struct {
+	db    *DB
+	opts  BuilderOptions
+	added uint64
+	slots []slot
+}
+
+// NewBuilder creates a new database at the path and returns a builder loading it.
+// If the process exits before Finish returns, the database is recovered when it's opened.
+func NewBuilder(path string, opts *Options, bopts *BuilderOptions) (*Builder, error) {
+	db, err := Open(path, opts)
+	if err != nil {
+		return nil, err
+	}
+	if db.opts.ReadOnly || db.Count() > 0 || db.datalog.curSeg.size > headerSize || len(db.datalog.segmentsBySequenceID()) > 1 {
+		_ = db.Close()
+		return nil, errBuilderNotEmpty
+	}
+	b := &Builder{db: db}
+	if bopts != nil {
+		b.opts = *bopts
+	}
+	return b, nil
+}
+
+// Add appends the key to the database. The key isn't visible until Finish returns.
+func (b *Builder) Add(key []byte) error {
+	db := b.db
+	key, err := db.checkKey(key)
+	if err != nil {
+		return err
+	}
+	db.mu.RLock()
+	segmentID, offset, err := db.datalog.put(key, 0)
+	db.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	db.metrics.Puts.Add(1)
+	b.added++
+	if b.opts.SortHashes {
+		b.slots = append(b.slots, slot{
+			hash:      db.hash(key),
+			segmentID: segmentID,
+			keySize:   slotKeySize(key),
+			offset:    offset,
+		})
+	}
+	db.writeChain.after(key)
+	return nil
+}
+
+// Finish builds the index and closes the database, which can be opened with Open afterwards.
+func (b *Builder) Finish() error {
+	db := b.db
+	start := time.Now()
+	if err := db.datalog.sync(); err != nil {
+		return err
+	}
+	var err error
+	db.mu.Lock()
+	if b.opts.SortHashes {
+		err = b.buildSorted()
+	} else {
+		err = b.buildFromSegments()
+	}
+	db.mu.Unlock()
+	if err != nil {
+		_ = db.Close()
+		return errors.Wrap(err, "building index")
+	}
+	db.opts.Logger.Logf(LogInfo, "built index of %d keys in %s", db.Count(), time.Since(start))
+	return db.Close()
+}
+
+// presize prepares the empty shards for the number of keys, so that the index isn't split while it's built.
+func (b *Builder) presize(numKeys func(shard int) uint64) error {
+	for i, shard := range b.db.index.shards {
+		if err := shard.presize(numKeys(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *Builder) buildFromSegments() error {
+	db := b.db
+	numShards := uint64(len(db.index.shards))
+	if err := b.presize(func(int) uint64 { return b.added / numShards }); err != nil {
+		return err
+	}
+	for _, seg := range db.datalog.segmentsBySequenceID() {
+		it, err := newSegmentIterator(seg)
+		if err != nil {
+			return err
+		}
+		for {
+			rec, err := it.next()
+			if err == ErrIterationDone {
+				break
+			}
+			if err != nil {
+				return errors.Wrapf(err, "reading segment %s", seg.name)
+			}
+			h := db.hash(rec.key)
+			sl := slot{
+				hash:      h,
+				segmentID: rec.segmentID,
+				keySize:   slotKeySize(rec.key),
+				offset:    rec.offset,
+			}
+			if err := db.put(db.index.shard(h), sl, rec.key); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (b *Builder) buildSorted() error {
+	db := b.db
+	shardSlots := make([][]slot, len(db.index.shards))
+	for _, sl := range b.slots {
+		i := 0
+		if len(shardSlots) > 1 {
+			i = shardIndex(sl.hash, len(shardSlots))
+		}
+		shardSlots[i] = append(shardSlots[i], sl)
+	}
+	b.slots = nil
+	if err := b.presize(func(i int) uint64 { return uint64(len(shardSlots[i])) }); err != nil {
+		return err
+	}
+	for i, shard := range db.index.shards {
+		slots := shardSlots[i]
+		sort.Slice(slots, func(i, j int) bool {
+			return shard.bucketIndex(slots[i].hash) < shard.bucketIndex(slots[j].hash)
+		})
+		for _, sl := range slots {
+			if err := db.index.put(shard, sl, b.matchSlotKey(sl)); err != nil {
+				return err
+			}
+		}
+		shardSlots[i] = nil
+	}
+	return nil
+}
+
+// matchSlotKey returns a function matching slots of the same key as the slot.
+// The key is read from the datalog when slot hashes collide.
+func (b *Builder) matchSlotKey(sl slot) matchKeyFunc {
+	dl := b.db.datalog
+	return func(cursl slot) (bool, error) {
+		if cursl.keySize != sl.keySize {
+			return false, nil
+		}
+		dl.mu.RLock()
+		key, err := dl.readKey(sl)
+		key = cloneBytes(key)
+		dl.mu.RUnlock()
+		if err != nil {
+			return true, err
+		}
+		return dl.keyEqual(cursl, key)
+	}
+}
+
+// presize grows the empty index to the number of buckets holding the number of keys without splitting.
+func (idx *index) presize(numKeys uint64) error {
+	perBucket := float64(idx.slotsPerBucket()) * idx.opts.IndexLoadFactor
+	want := uint64(float64(numKeys)/perBucket) + 1
+	if want > 1<<31 {
+		want = 1 << 31
+	}
+	for uint64(idx.numBuckets) < want {
+		n := want - uint64(idx.numBuckets)
+		if n > maxPresizeChunk {
+			n = maxPresizeChunk
+		}
+		if _, err := idx.main.extend(int64(n) * bucketSize); err != nil {
+			return err
+		}
+		idx.numBuckets += uint32(n)
+	}
+	// Linear hashing state of numBuckets = 2^level + splitBucketIdx.
+	idx.level = uint8(bits.Len32(idx.numBuckets) - 1)
+	idx.splitBucketIdx = idx.numBuckets - 1<<idx.level
+	return nil
+}
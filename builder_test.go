@@ -0,0 +1,65 @@
+package pogreb
+
+import (
+	"testing"
+
+	"github.com/domaincrawler/pogreb/internal/assert"
+)
+
+func TestBuilder(t *testing.T) {
+	for _, sortHashes := range []bool{false, true} {
+		t.Run("", func(t *testing.T) {
+			db, err := createTestDB(nil)
+			assert.Nil(t, err)
+			assert.Nil(t, db.Close())
+
+			opts := &Options{FileSystem: testFS, IndexShards: 2, maxSegmentSize: 64 << 10}
+			b, err := NewBuilder(testDBName, opts, &BuilderOptions{SortHashes: sortHashes})
+			assert.Nil(t, err)
+			n := 10000
+			for i := 0; i < n; i++ {
+				assert.Nil(t, b.Add(deleteTestKey(i)))
+			}
+			// Duplicates are indexed once.
+			assert.Nil(t, b.Add(deleteTestKey(0)))
+			assert.Nil(t, b.Finish())
+
+			db, err = Open(testDBName, &Options{FileSystem: testFS})
+			assert.Nil(t, err)
+			assert.Equal(t, false, db.OpenReport().Recovered)
+			assert.Equal(t, uint64(n), db.Count())
+			for i := 0; i < n; i++ {
+				assertHas(t, db, deleteTestKey(i), true)
+			}
+			assertHas(t, db, deleteTestKey(n), false)
+			assert.Nil(t, db.Put(deleteTestKey(n)))
+			assertHas(t, db, deleteTestKey(n), true)
+			assert.Nil(t, db.Close())
+		})
+	}
+}
+
+func TestBuilderNotEmpty(t *testing.T) {
+	db, err := createTestDB(nil)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte{1}))
+	assert.Nil(t, db.Close())
+	_, err = NewBuilder(testDBName, &Options{FileSystem: testFS}, nil)
+	assert.Equal(t, errBuilderNotEmpty, err)
+}
+
+func TestIndexPresize(t *testing.T) {
+	db, err := createTestDB(nil)
+	assert.Nil(t, err)
+	idx := db.index.shards[0]
+	assert.Nil(t, idx.presize(1000))
+	numBuckets := idx.numBuckets
+	assert.Equal(t, uint32(47), numBuckets)
+	assert.Equal(t, numBuckets, uint32(1)<<idx.level+idx.splitBucketIdx)
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(deleteTestKey(i)))
+	}
+	// The presized index isn't split.
+	assert.Equal(t, numBuckets, idx.numBuckets)
+	assert.Nil(t, db.Close())
+}
@@ -0,0 +1,79 @@
+package pogreb
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/domaincrawler/pogreb/internal/errors"
+)
+
+// canaryDomain prefixes the stored canary keys. Stored keys starting with a zero byte are reserved for namespaces,
+// whose names are never empty, so the canary keys can't collide with keys of the DB or of a namespace.
+const canaryDomain = "\x00\x00pogreb/canary\x00"
+
+var errCanaryMismatch = errors.New("canary key written and synced isn't found in the DB")
+
+// Health describes the results of the canary self-test.
+type Health struct {
+	CanaryChecks   int64     // Number of canary checks.
+	CanaryFailures int64     // Number of failed canary checks.
+	LastCanary     time.Time // Time of the last canary check, zero if no check ran.
+	LastCanaryErr  error     // Error of the last canary check, nil if it succeeded.
+}
+
+// Health returns the results of the canary self-test.
+func (db *DB) Health() Health {
+	db.canaryMu.Lock()
+	defer db.canaryMu.Unlock()
+	return db.health
+}
+
+// CheckCanary runs the canary self-test: it puts a reserved canary key, syncs the datalog,
+// checks that the DB has the key and deletes it. The key goes through the datalog and the index like any other key,
+// but isn't passed to the write chain, iterators running concurrently with the check may return it. A failure means the storage silently lost or corrupted written data.
+// The result is recorded in Health and Metrics. Options.CanaryInterval runs the check periodically.
+func (db *DB) CheckCanary() error {
+	if err := db.checkWritable(); err != nil {
+		return err
+	}
+	db.canaryMu.Lock()
+	defer db.canaryMu.Unlock()
+	db.canarySeq++
+	err := db.checkCanary(db.canarySeq)
+	db.health.CanaryChecks++
+	db.health.LastCanary = db.opts.Clock.Now()
+	db.health.LastCanaryErr = err
+	db.metrics.CanaryChecks.Add(1)
+	if err != nil {
+		db.health.CanaryFailures++
+		db.metrics.CanaryFailures.Add(1)
+	}
+	return err
+}
+
+func (db *DB) checkCanary(seq uint64) error {
+	key := make([]byte, len(canaryDomain)+16)
+	copy(key, canaryDomain)
+	binary.LittleEndian.PutUint64(key[len(canaryDomain):], db.epoch)
+	binary.LittleEndian.PutUint64(key[len(canaryDomain)+8:], seq)
+
+	if err := db.writeCommit(key); err != nil {
+		return errors.Wrap(err, "writing canary key")
+	}
+	err := db.datalog.sync()
+	if err != nil {
+		err = errors.Wrap(err, "syncing canary key")
+	} else {
+		var found bool
+		found, err = db.hasStored(key)
+		if err != nil {
+			err = errors.Wrap(err, "reading canary key")
+		} else if !found {
+			err = errCanaryMismatch
+		}
+	}
+	if _, delErr := db.deleteStored(key); delErr != nil && err == nil {
+		err = errors.Wrap(delErr, "deleting canary key")
+	}
+	return err
+}
@@ -0,0 +1,83 @@
+package pogreb
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/domaincrawler/pogreb/fs"
+	"github.com/domaincrawler/pogreb/internal/assert"
+)
+
+// lossyFS corrupts the canary keys written to the files, simulating storage that silently loses data.
+type lossyFS struct {
+	fs.FileSystem
+}
+
+func (fsys lossyFS) OpenFile(name string, flag int, perm os.FileMode) (fs.File, error) {
+	f, err := fsys.FileSystem.OpenFile(name, flag, perm)
+	if err != nil {
+		return f, err
+	}
+	return lossyFile{f}, nil
+}
+
+type lossyFile struct {
+	fs.File
+}
+
+func (f lossyFile) WriteAt(p []byte, off int64) (int, error) {
+	if i := bytes.Index(p, []byte(canaryDomain)); i >= 0 {
+		p = append([]byte(nil), p...)
+		p[i+len(canaryDomain)-2] ^= 0xff
+	}
+	return f.File.WriteAt(p, off)
+}
+
+func TestCheckCanary(t *testing.T) {
+	db, err := createTestDB(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, Health{}, db.Health())
+
+	assert.Nil(t, db.CheckCanary())
+	assert.Nil(t, db.CheckCanary())
+	h := db.Health()
+	assert.Equal(t, int64(2), h.CanaryChecks)
+	assert.Equal(t, int64(0), h.CanaryFailures)
+	assert.Nil(t, h.LastCanaryErr)
+	assert.Equal(t, false, h.LastCanary.IsZero())
+	assert.Equal(t, int64(2), db.Metrics().CanaryChecks.Value())
+
+	// The canary keys go through the datalog, but aren't left in the DB.
+	assert.Equal(t, uint64(0), db.Count())
+	assert.Equal(t, int64(2), db.Metrics().Dels.Value())
+	assert.Nil(t, db.Close())
+	db, err = Open(testDBName, &Options{FileSystem: testFS})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), db.Count())
+	assert.Nil(t, db.Close())
+}
+
+func TestCheckCanaryFailure(t *testing.T) {
+	db, err := createTestDB(&Options{FileSystem: lossyFS{testFS}})
+	assert.Nil(t, err)
+	err = db.CheckCanary()
+	assert.Equal(t, true, errors.Is(err, errCanaryMismatch))
+	h := db.Health()
+	assert.Equal(t, int64(1), h.CanaryFailures)
+	assert.Equal(t, err, h.LastCanaryErr)
+	assert.Equal(t, int64(1), db.Metrics().CanaryFailures.Value())
+	assert.Nil(t, db.Close())
+}
+
+func TestCanaryInterval(t *testing.T) {
+	clock := newManualClock()
+	db, err := createTestDB(&Options{Clock: clock, CanaryInterval: time.Minute})
+	assert.Nil(t, err)
+	waitFor(t, func() bool { return clock.numTickers() == 1 })
+	clock.advance(time.Minute)
+	waitFor(t, func() bool { return db.Health().CanaryChecks == 1 })
+	assert.Nil(t, db.Close())
+}
@@ -0,0 +1,189 @@
+package pogreb
+
+import (
+	"encoding/gob"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/domaincrawler/pogreb/fs"
+	"github.com/domaincrawler/pogreb/internal/errors"
+)
+
+const (
+	checkpointExt     = ".pck"
+	checkpointName    = "checkpoint" + metaExt
+	checkpointTmpName = checkpointName + ".tmp"
+)
+
+// checkpointMeta describes a consistent snapshot of the index.
+// Records written before the watermark (SequenceID, Offset) are present in the snapshot.
+type checkpointMeta struct {
+	Generation uint64
+	SequenceID uint64 // Sequence ID of the segment which was current when the checkpoint was taken.
+	Offset     uint64 // Size of the current segment when the checkpoint was taken.
+	Files      []string
+	Segments   map[string]segmentMeta
+}
+
+func checkpointFileName(name string, gen uint64) string {
+	return fmt.Sprintf("%s.%d%s", name, gen, checkpointExt)
+}
+
+// writeSyncedFile writes a database file and makes it durable. write is called after the file header is written.
+func writeSyncedFile(fsys fs.FileSystem, name string, write func(w io.Writer) error) error {
+	f, err := openFile(fsys, name, true)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// syncDir makes the files renamed into the database directory durable when the file system supports it.
+func syncDir(fsys fs.FileSystem) error {
+	if dfs, ok := fsys.(fs.DirSyncFileSystem); ok {
+		return dfs.SyncDir(".")
+	}
+	return nil
+}
+
+func writeGob(v interface{}) func(w io.Writer) error {
+	return func(w io.Writer) error {
+		return gob.NewEncoder(w).Encode(v)
+	}
+}
+
+// checkpoint takes a snapshot of the index, so that recovery only has to replay records written afterwards.
+func (db *DB) checkpoint() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// Every record referenced by the snapshot must be durable.
+	if err := db.datalog.sync(); err != nil {
+		return err
+	}
+	return db.writeCheckpoint(db.datalog.curSeg.sequenceID, uint64(db.datalog.curSeg.size))
+}
+
+// writeCheckpoint takes a snapshot of the index containing the records written before the watermark.
+// The index files are copied, the checkpoint becomes valid once its meta file is renamed into place.
+func (db *DB) writeCheckpoint(sequenceID uint64, offset uint64) error {
+	fsys := db.opts.FileSystem
+	cp := checkpointMeta{
+		Generation: db.checkpointGen + 1,
+		SequenceID: sequenceID,
+		Offset:     offset,
+		Segments:   make(map[string]segmentMeta),
+	}
+	for _, seg := range db.datalog.segmentsBySequenceID() {
+		cp.Segments[seg.name] = *seg.meta
+	}
+
+	snapshot := func(name string, write func(w io.Writer) error) error {
+		if err := writeSyncedFile(fsys, checkpointFileName(name, cp.Generation), write); err != nil {
+			return errors.Wrapf(err, "writing checkpoint of %s", name)
+		}
+		cp.Files = append(cp.Files, name)
+		return nil
+	}
+	copyFile := func(f *file) func(w io.Writer) error {
+		return func(w io.Writer) error {
+			_, err := io.Copy(w, io.NewSectionReader(f, headerSize, f.size-headerSize))
+			return err
+		}
+	}
+	for i, sh := range db.index.shards {
+		if err := sh.flush(); err != nil {
+			return err
+		}
+		mainName, overflowName, metaName := indexFileNames(i)
+		if err := snapshot(mainName, copyFile(sh.main)); err != nil {
+			return err
+		}
+		if err := snapshot(overflowName, copyFile(sh.overflow)); err != nil {
+			return err
+		}
+		if err := snapshot(metaName, writeGob(sh.meta())); err != nil {
+			return err
+		}
+	}
+	if err := snapshot(dbMetaName, writeGob(db.meta())); err != nil {
+		return err
+	}
+
+	if err := writeSyncedFile(fsys, checkpointTmpName, writeGob(cp)); err != nil {
+		return err
+	}
+	if err := fsys.Rename(checkpointTmpName, checkpointName); err != nil {
+		return err
+	}
+
+	prevGen := db.checkpointGen
+	db.checkpointGen = cp.Generation
+	if prevGen != 0 {
+		db.removeCheckpointFiles(cp.Files, prevGen)
+	}
+	return nil
+}
+
+func (db *DB) removeCheckpointFiles(names []string, gen uint64) {
+	for _, name := range names {
+		if err := db.opts.FileSystem.Remove(checkpointFileName(name, gen)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			db.opts.Logger.Logf(LogError, "error removing checkpoint file: %v", err)
+		}
+	}
+}
+
+// removeCheckpoint invalidates the last checkpoint.
+// It must be called before removing segments, which may be referenced by the index snapshot.
+func (db *DB) removeCheckpoint() error {
+	if db.checkpointGen == 0 {
+		return nil
+	}
+	fsys := db.opts.FileSystem
+	if err := fsys.Remove(checkpointName); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	var names []string
+	for i := range db.index.shards {
+		mainName, overflowName, metaName := indexFileNames(i)
+		names = append(names, mainName, overflowName, metaName)
+	}
+	db.removeCheckpointFiles(append(names, dbMetaName), db.checkpointGen)
+	db.checkpointGen = 0
+	return nil
+}
+
+// restoreCheckpoint replaces the backed up index files with the snapshot of the last checkpoint.
+// It returns nil if there is no valid checkpoint.
+func restoreCheckpoint(opts *Options) (*checkpointMeta, error) {
+	fsys := opts.FileSystem
+	cp := &checkpointMeta{}
+	if err := readGobFile(fsys, checkpointName+recoveryBackupExt, cp); err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			opts.Logger.Logf(LogWarn, "error reading checkpoint: %v", err)
+		}
+		return nil, nil
+	}
+	for _, name := range cp.Files {
+		if _, err := fsys.Stat(checkpointFileName(name, cp.Generation) + recoveryBackupExt); err != nil {
+			opts.Logger.Logf(LogWarn, "checkpoint %d is incomplete: %v", cp.Generation, err)
+			return nil, nil
+		}
+	}
+	for _, name := range cp.Files {
+		if err := fsys.Rename(checkpointFileName(name, cp.Generation)+recoveryBackupExt, name); err != nil {
+			return nil, err
+		}
+	}
+	opts.Logger.Logf(LogInfo, "restored index from checkpoint %d", cp.Generation)
+	return cp, nil
+}
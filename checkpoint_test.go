@@ -0,0 +1,84 @@
+package pogreb
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/domaincrawler/pogreb/internal/assert"
+)
+
+func TestCheckpoint(t *testing.T) {
+	db, err := createTestDB(&Options{IndexCheckpointInterval: time.Hour, IndexShards: 2})
+	assert.Nil(t, err)
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, db.Put([]byte{byte(i)}))
+	}
+	assert.Nil(t, db.checkpoint())
+	assert.Nil(t, db.checkpoint())
+	assert.Equal(t, uint64(2), db.checkpointGen)
+	assert.Equal(t, true, fileExists(filepath.Join(testDBName, checkpointName)))
+	assert.Equal(t, true, fileExists(filepath.Join(testDBName, checkpointFileName(indexMainName, 2))))
+	assert.Equal(t, false, fileExists(filepath.Join(testDBName, checkpointFileName(indexMainName, 1))))
+	for i := 10; i < 15; i++ {
+		assert.Nil(t, db.Put([]byte{byte(i)}))
+	}
+
+	// Corrupt the first record, which is covered by the checkpoint.
+	// A full index rebuild would truncate the segment.
+	_, err = db.datalog.curSeg.WriteAt([]byte{0xff}, int64(headerSize)+2)
+	assert.Nil(t, err)
+
+	simulateCrash(t, db)
+
+	db, err = Open(testDBName, &Options{FileSystem: testFS})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(15), db.Count())
+	for i := 10; i < 15; i++ {
+		has, err := db.Has([]byte{byte(i)})
+		assert.Nil(t, err)
+		assert.Equal(t, true, has)
+	}
+	assert.Equal(t, uint32(15), db.datalog.curSeg.meta.PutRecords)
+	assert.Equal(t, false, fileExists(filepath.Join(testDBName, checkpointName+recoveryBackupExt)))
+	assert.Nil(t, db.Close())
+}
+
+func TestCheckpointRemovedOnClose(t *testing.T) {
+	db, err := createTestDB(&Options{IndexCheckpointInterval: time.Hour})
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte{1}))
+	assert.Nil(t, db.checkpoint())
+	assert.Nil(t, db.Close())
+	assert.Equal(t, false, fileExists(filepath.Join(testDBName, checkpointName)))
+	assert.Equal(t, false, fileExists(filepath.Join(testDBName, checkpointFileName(dbMetaName, 1))))
+}
+
+func TestCheckpointCorrupted(t *testing.T) {
+	db, err := createTestDB(&Options{IndexCheckpointInterval: time.Hour})
+	assert.Nil(t, err)
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, db.Put([]byte{byte(i)}))
+	}
+	assert.Nil(t, db.checkpoint())
+	simulateCrash(t, db)
+
+	f, err := testFS.OpenFile(filepath.Join(testDBName, checkpointFileName(indexMainName, 1)), os.O_RDWR, 0)
+	assert.Nil(t, err)
+	_, err = f.WriteAt([]byte("corrupted"), headerSize+100)
+	assert.Nil(t, err)
+	assert.Nil(t, f.Close())
+
+	// The buckets of a restored checkpoint are verified, a corrupted one is rebuilt from the datalog.
+	db, err = Open(testDBName, &Options{FileSystem: testFS})
+	assert.Nil(t, err)
+	assert.Equal(t, true, db.OpenReport().IndexRebuilt)
+	assert.Equal(t, uint64(10), db.Count())
+	for i := 0; i < 10; i++ {
+		has, err := db.Has([]byte{byte(i)})
+		assert.Nil(t, err)
+		assert.Equal(t, true, has)
+	}
+	assert.Nil(t, db.Close())
+}
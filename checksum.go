@@ -0,0 +1,41 @@
+package pogreb
+
+import (
+	"hash/crc32"
+
+	"github.com/domaincrawler/pogreb/internal/hash"
+)
+
+// Checksum is the algorithm used to checksum segment records.
+type Checksum uint8
+
+const (
+	// ChecksumIEEE is CRC-32 with the IEEE polynomial.
+	ChecksumIEEE Checksum = iota + 1
+
+	// ChecksumCastagnoli is CRC-32 with the Castagnoli polynomial (CRC-32C).
+	// It's computed with dedicated CPU instructions on amd64 (SSE4.2), arm64 and s390x,
+	// which makes writes, segment iteration and recovery faster.
+	ChecksumCastagnoli
+
+	// ChecksumXXH3 is the lower 32 bits of the 64-bit XXH3 hash (xxHash v0.8).
+	// It doesn't need dedicated CPU instructions and is faster than CRC-32 for records of short keys.
+	// Segments using it can't be opened by older library versions.
+	ChecksumXXH3
+)
+
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+func (c Checksum) valid() bool {
+	return c == ChecksumIEEE || c == ChecksumCastagnoli || c == ChecksumXXH3
+}
+
+func (c Checksum) sum(data []byte) uint32 {
+	switch c {
+	case ChecksumCastagnoli:
+		return crc32.Checksum(data, castagnoliTable)
+	case ChecksumXXH3:
+		return uint32(hash.XXH3Sum64WithSeed(data, 0))
+	}
+	return crc32.ChecksumIEEE(data)
+}
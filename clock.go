@@ -0,0 +1,45 @@
+package pogreb
+
+import (
+	"time"
+)
+
+// Clock is a source of time. It can be replaced to drive the time of a DB deterministically,
+// e.g. to step through background tasks in tests or to run the DB in a simulation.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+
+	// Ticker returns a new Ticker delivering ticks every d. d is greater than zero.
+	Ticker(d time.Duration) Ticker
+}
+
+// Ticker delivers ticks of a Clock at intervals.
+type Ticker interface {
+	// C returns the channel on which the ticks are delivered.
+	C() <-chan time.Time
+
+	// Stop turns off the ticker. No more ticks are sent after Stop returns.
+	Stop()
+}
+
+// SystemClock is the Clock backed by the time package.
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) Ticker(d time.Duration) Ticker {
+	return systemTicker{time.NewTicker(d)}
+}
+
+type systemTicker struct {
+	*time.Ticker
+}
+
+func (t systemTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
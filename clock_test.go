@@ -0,0 +1,145 @@
+package pogreb
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/domaincrawler/pogreb/internal/assert"
+)
+
+// manualClock is a Clock advanced by the test.
+type manualClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	tickers []*manualTicker
+}
+
+type manualTicker struct {
+	c       chan time.Time
+	d       time.Duration
+	next    time.Time
+	stopped bool
+}
+
+func newManualClock() *manualClock {
+	return &manualClock{now: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *manualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *manualClock) Ticker(d time.Duration) Ticker {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &manualTicker{c: make(chan time.Time, 1), d: d, next: c.now.Add(d)}
+	c.tickers = append(c.tickers, t)
+	return &manualTickerHandle{clock: c, t: t}
+}
+
+// numTickers returns the number of running tickers.
+func (c *manualClock) numTickers() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for _, t := range c.tickers {
+		if !t.stopped {
+			n++
+		}
+	}
+	return n
+}
+
+// advance moves the clock forward, tickers due by the new time tick. Like time.Ticker, slow receivers miss ticks.
+func (c *manualClock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	for _, t := range c.tickers {
+		if t.stopped || t.next.After(c.now) {
+			continue
+		}
+		for !t.next.After(c.now) {
+			t.next = t.next.Add(t.d)
+		}
+		select {
+		case t.c <- c.now:
+		default:
+		}
+	}
+}
+
+type manualTickerHandle struct {
+	clock *manualClock
+	t     *manualTicker
+}
+
+func (h *manualTickerHandle) C() <-chan time.Time {
+	return h.t.c
+}
+
+func (h *manualTickerHandle) Stop() {
+	h.clock.mu.Lock()
+	defer h.clock.mu.Unlock()
+	h.t.stopped = true
+}
+
+// waitFor waits until the condition becomes true, background tasks run asynchronously.
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for condition")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestClockBackgroundSync(t *testing.T) {
+	clock := newManualClock()
+	fsys := &syncCountingFS{FileSystem: testFS}
+	db, err := createTestDB(&Options{
+		FileSystem:             fsys,
+		Clock:                  clock,
+		BackgroundSyncInterval: time.Hour,
+	})
+	assert.Nil(t, err)
+	waitFor(t, func() bool { return clock.numTickers() == 1 })
+	assert.Nil(t, db.Put([]byte{1}))
+	atomic.StoreInt32(&fsys.syncs, 0)
+
+	clock.advance(time.Minute)
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&fsys.syncs))
+
+	clock.advance(time.Hour)
+	waitFor(t, func() bool { return atomic.LoadInt32(&fsys.syncs) > 0 })
+
+	assert.Nil(t, db.Close())
+	assert.Equal(t, 0, clock.numTickers())
+}
+
+func TestClockIterationDeadline(t *testing.T) {
+	clock := newManualClock()
+	db, err := createTestDB(&Options{Clock: clock})
+	assert.Nil(t, err)
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, db.Put([]byte{byte(i)}))
+	}
+
+	it := db.ItemsWithQuota(IterationQuota{MaxDuration: time.Second})
+	_, err = it.Next()
+	assert.Nil(t, err)
+	clock.advance(time.Second)
+	_, err = it.Next()
+	assert.Nil(t, err)
+	clock.advance(time.Nanosecond)
+	_, err = it.Next()
+	assert.Equal(t, ErrQuotaExceeded, err)
+
+	assert.Nil(t, db.Close())
+}
@@ -0,0 +1,117 @@
+package pogreb
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/domaincrawler/pogreb/fs"
+	"github.com/domaincrawler/pogreb/internal/errors"
+)
+
+var errCloneNotEmpty = errors.New("clone destination isn't empty")
+
+// Clone writes a copy of the DB to a new database directory at the path, on the file system of the DB.
+// The copy is a consistent snapshot: writes wait until the files are copied.
+// Sealed segments are hard-linked when the file system implements fs.LinkFileSystem, otherwise all files are copied.
+// Sealed segments are never modified, except by ArchiveSegments, which must not be used
+// on databases sharing hard-linked segments. Archived segments are copied as stubs,
+// the clone has to be opened with the same Options.Archiver.
+// The clone is independent of the DB and can be opened with Open once Clone returns.
+func (db *DB) Clone(path string) error {
+	if db.snapshot != nil {
+		return errSingleFileUnsupported
+	}
+	if db.path == path {
+		return errCloneNotEmpty
+	}
+	root := fs.OSMMap
+	if db.openOpts != nil && db.openOpts.FileSystem != nil {
+		root = db.openOpts.FileSystem
+	}
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return err
+	}
+	dst := fs.Sub(root, path)
+	files, err := dst.ReadDir(".")
+	if err != nil {
+		return err
+	}
+	if len(files) > 0 {
+		return errCloneNotEmpty
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := db.sync(); err != nil {
+		return err
+	}
+	lfs, canLink := root.(fs.LinkFileSystem)
+	for _, seg := range db.datalog.segmentsBySequenceID() {
+		switch {
+		case seg.archived():
+			// Copy the stub, reading through the segment would fetch the archived data.
+			if err := copyRawFile(db.opts.FileSystem, dst, seg.name); err != nil {
+				return err
+			}
+		case canLink && seg.meta.Full && seg != db.datalog.curSeg:
+			if err := lfs.Link(filepath.Join(db.path, seg.name), filepath.Join(path, seg.name)); err != nil {
+				return errors.Wrapf(err, "linking segment %s", seg.name)
+			}
+		default:
+			if err := copyFileTo(dst, seg.name, seg.file); err != nil {
+				return err
+			}
+		}
+		if err := writeGobFile(dst, seg.name+metaExt, seg.meta); err != nil {
+			return err
+		}
+	}
+	for i, sh := range db.index.shards {
+		if err := sh.flush(); err != nil {
+			return err
+		}
+		mainName, overflowName, metaName := indexFileNames(i)
+		if err := copyFileTo(dst, mainName, sh.main); err != nil {
+			return err
+		}
+		if err := copyFileTo(dst, overflowName, sh.overflow); err != nil {
+			return err
+		}
+		if err := writeGobFile(dst, metaName, sh.meta()); err != nil {
+			return err
+		}
+	}
+	return writeGobFile(dst, dbMetaName, db.meta())
+}
+
+// copyFileTo writes the contents of the file, including the header, to a new file.
+func copyFileTo(fsys fs.FileSystem, name string, f *file) error {
+	return copyToNewFile(fsys, name, io.NewSectionReader(f, 0, f.size))
+}
+
+// copyRawFile copies a file between file systems as is.
+func copyRawFile(src fs.FileSystem, dst fs.FileSystem, name string) error {
+	f, err := src.OpenFile(name, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return copyToNewFile(dst, name, f)
+}
+
+func copyToNewFile(fsys fs.FileSystem, name string, r io.Reader) error {
+	f, err := fsys.OpenFile(name, os.O_CREATE|os.O_RDWR|os.O_TRUNC, os.FileMode(0640))
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		_ = f.Close()
+		return errors.Wrapf(err, "copying %s", name)
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,71 @@
+package pogreb
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/domaincrawler/pogreb/fs"
+	"github.com/domaincrawler/pogreb/internal/assert"
+)
+
+func TestClone(t *testing.T) {
+	path := testDBName + ".clone"
+	removeMergeSource(t, path)
+	defer removeMergeSource(t, path)
+	db, err := createTestDB(&Options{maxSegmentSize: 1024})
+	assert.Nil(t, err)
+	for i := 0; i < 200; i++ {
+		assert.Nil(t, db.Put(deleteTestKey(i)))
+	}
+	assert.Nil(t, db.Clone(path))
+	assert.Equal(t, errCloneNotEmpty, db.Clone(path))
+	assert.Nil(t, db.Put(deleteTestKey(200)))
+
+	clone, err := Open(path, &Options{FileSystem: testFS, maxSegmentSize: 1024})
+	assert.Nil(t, err)
+	assert.Equal(t, false, clone.OpenReport().Recovered)
+	assert.Equal(t, uint64(200), clone.Count())
+	for i := 0; i < 200; i++ {
+		assertHas(t, clone, deleteTestKey(i), true)
+	}
+	assertHas(t, clone, deleteTestKey(200), false)
+	assert.Nil(t, clone.Put(deleteTestKey(201)))
+	assertHas(t, db, deleteTestKey(201), false)
+
+	if testFS == fs.OS || testFS == fs.OSMMap {
+		// Sealed segments are shared.
+		name := db.datalog.segmentsBySequenceID()[0].name
+		fi1, err := os.Stat(filepath.Join(testDBName, name))
+		assert.Nil(t, err)
+		fi2, err := os.Stat(filepath.Join(path, name))
+		assert.Nil(t, err)
+		assert.Equal(t, true, os.SameFile(fi1, fi2))
+	}
+	assert.Nil(t, clone.Close())
+	assert.Nil(t, db.Close())
+}
+
+func TestCloneReopened(t *testing.T) {
+	path := testDBName + ".clone"
+	removeMergeSource(t, path)
+	defer removeMergeSource(t, path)
+	opts := &Options{maxSegmentSize: 1024}
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put(deleteTestKey(0)))
+	assert.Nil(t, db.Close())
+	db, err = Open(testDBName, opts)
+	assert.Nil(t, err)
+	defer db.Close()
+	for i := 1; i < 100; i++ {
+		assert.Nil(t, db.Put(deleteTestKey(i)))
+	}
+	assert.Nil(t, db.Clone(path))
+
+	// The index meta of the clone doesn't carry the watermark of the source.
+	clone, err := Open(path, &Options{FileSystem: testFS, maxSegmentSize: 1024})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(100), clone.Count())
+	assert.Nil(t, clone.Close())
+}
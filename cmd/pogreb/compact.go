@@ -0,0 +1,29 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/domaincrawler/pogreb"
+)
+
+// runCompact compacts the database, which must not be opened by another process.
+func runCompact(args []string) error {
+	flags := flag.NewFlagSet("compact", flag.ExitOnError)
+	path, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	db, err := pogreb.Open(path, nil)
+	if err != nil {
+		return err
+	}
+	cr, err := db.Compact()
+	if err != nil {
+		_ = db.Close()
+		return err
+	}
+	fmt.Printf("compacted %d segments, reclaimed %d records (%d bytes)\n",
+		cr.CompactedSegments, cr.ReclaimedRecords, cr.ReclaimedBytes)
+	return db.Close()
+}
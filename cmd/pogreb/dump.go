@@ -0,0 +1,36 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/domaincrawler/pogreb"
+)
+
+var exportFormats = map[string]pogreb.ExportFormat{
+	"lines":           pogreb.ExportLines,
+	"csv":             pogreb.ExportCSV,
+	"length-prefixed": pogreb.ExportLengthPrefixed,
+}
+
+// runDump writes the keys of the database to stdout.
+func runDump(args []string) error {
+	flags := flag.NewFlagSet("dump", flag.ExitOnError)
+	formatName := flags.String("format", "lines", "output format: lines, csv or length-prefixed")
+	path, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	format, ok := exportFormats[*formatName]
+	if !ok {
+		return fmt.Errorf("unknown format %q", *formatName)
+	}
+	db, err := pogreb.Open(path, &pogreb.Options{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	_, err = db.Export(os.Stdout, format)
+	return err
+}
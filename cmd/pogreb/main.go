@@ -0,0 +1,73 @@
+/*
+Command pogreb runs maintenance tasks on pogreb databases.
+
+Usage:
+
+	pogreb <command> [flags] <path>
+
+The commands are:
+
+	stats    print key counts, sizes and fragmentation
+	dump     write keys to stdout as lines, CSV or length-prefixed
+	verify   check record checksums and index entries
+	compact  compact the database
+	recover  salvage readable records and rebuild the index
+	serve    serve lookups from a read-only database over HTTP
+*/
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
+
+var commands = []command{
+	{name: "stats", usage: "print key counts, sizes and fragmentation", run: runStats},
+	{name: "dump", usage: "write keys to stdout as lines, CSV or length-prefixed", run: runDump},
+	{name: "verify", usage: "check record checksums and index entries", run: runVerify},
+	{name: "compact", usage: "compact the database", run: runCompact},
+	{name: "recover", usage: "salvage readable records and rebuild the index", run: runRecover},
+	{name: "serve", usage: "serve lookups from a read-only database over HTTP", run: runServe},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: pogreb <command> [flags] <path>\n\nThe commands are:\n\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "\t%-8s %s\n", cmd.name, cmd.usage)
+	}
+	os.Exit(2)
+}
+
+// parseFlags parses the command flags and returns the database path.
+func parseFlags(flags *flag.FlagSet, args []string) (string, error) {
+	if err := flags.Parse(args); err != nil {
+		return "", err
+	}
+	if flags.NArg() != 1 {
+		return "", fmt.Errorf("usage: pogreb %s [flags] <path>", flags.Name())
+	}
+	return flags.Arg(0), nil
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	for _, cmd := range commands {
+		if cmd.name == os.Args[1] {
+			if err := cmd.run(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "pogreb %s: %v\n", cmd.name, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+	usage()
+}
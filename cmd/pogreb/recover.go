@@ -0,0 +1,24 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/domaincrawler/pogreb"
+)
+
+// runRecover salvages the readable records of a corrupted database and rebuilds its index.
+func runRecover(args []string) error {
+	flags := flag.NewFlagSet("recover", flag.ExitOnError)
+	path, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	report, err := pogreb.Repair(path, nil)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("scanned %d segments, repaired %d segments, salvaged %d records, dropped %d records (%d bytes)\n",
+		report.Segments, report.RepairedSegments, report.Records, report.DroppedRecords, report.DroppedBytes)
+	return nil
+}
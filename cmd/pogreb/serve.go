@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/domaincrawler/pogreb"
+)
+
+// runServe opens the database read-only and serves lookups, e.g. to validate a backup against sampled production traffic.
+//
+// GET /has?key=<key> responds with "true" or "false".
+// POST /has with newline-separated keys in the body responds with a "true" or "false" line per key.
+// GET /count responds with the number of keys.
+func runServe(args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := flags.String("addr", "localhost:8080", "listen address")
+	path, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	db, err := pogreb.Open(path, &pogreb.Options{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	log.Printf("serving %s (%d keys) on %s", path, db.Count(), *addr)
+	return http.ListenAndServe(*addr, newServer(db))
+}
+
+func newServer(db *pogreb.DB) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/has", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			has, err := db.Has([]byte(r.URL.Query().Get("key")))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			fmt.Fprintln(w, has)
+		case http.MethodPost:
+			bw := bufio.NewWriter(w)
+			sc := bufio.NewScanner(r.Body)
+			sc.Buffer(nil, pogreb.MaxKeyLength+1)
+			for sc.Scan() {
+				has, err := db.Has(sc.Bytes())
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				fmt.Fprintln(bw, has)
+			}
+			if err := sc.Err(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			_ = bw.Flush()
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc("/count", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, db.Count())
+	})
+	return mux
+}
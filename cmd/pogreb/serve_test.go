@@ -0,0 +1,45 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/domaincrawler/pogreb"
+	"github.com/domaincrawler/pogreb/fs"
+	"github.com/domaincrawler/pogreb/internal/assert"
+)
+
+const testDBName = "test.db"
+
+func TestServe(t *testing.T) {
+	db, err := pogreb.Open(testDBName, &pogreb.Options{FileSystem: fs.Mem})
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte("https://example.com/")))
+	assert.Nil(t, db.Close())
+
+	db, err = pogreb.Open(testDBName, &pogreb.Options{FileSystem: fs.Mem, ReadOnly: true})
+	assert.Nil(t, err)
+	srv := httptest.NewServer(newServer(db))
+	defer srv.Close()
+
+	get := func(resp *http.Response, err error) string {
+		t.Helper()
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		body, err := io.ReadAll(resp.Body)
+		assert.Nil(t, err)
+		assert.Nil(t, resp.Body.Close())
+		return string(body)
+	}
+	assert.Equal(t, "true\n", get(http.Get(srv.URL+"/has?key=https://example.com/")))
+	assert.Equal(t, "false\n", get(http.Get(srv.URL+"/has?key=https://example.org/")))
+	assert.Equal(t, "1\n", get(http.Get(srv.URL+"/count")))
+
+	body := strings.NewReader("https://example.org/\nhttps://example.com/\n")
+	assert.Equal(t, "false\ntrue\n", get(http.Post(srv.URL+"/has", "text/plain", body)))
+
+	assert.Nil(t, db.Close())
+}
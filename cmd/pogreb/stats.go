@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/domaincrawler/pogreb"
+)
+
+// runStats prints key counts, sizes, fragmentation and index bucket statistics of the database.
+// Fragmentation is the share of datalog records which aren't referenced by the index,
+// it's computed by scanning all segments.
+func runStats(args []string) error {
+	flags := flag.NewFlagSet("stats", flag.ExitOnError)
+	path, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	db, err := pogreb.Open(path, &pogreb.Options{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return writeStats(os.Stdout, db)
+}
+
+func writeStats(w io.Writer, db *pogreb.DB) error {
+	size, err := db.FileSize()
+	if err != nil {
+		return err
+	}
+	report, err := db.Verify(context.Background())
+	if err != nil {
+		return err
+	}
+	var fragmentation float64
+	if report.Records > 0 {
+		fragmentation = 1 - float64(db.Count())/float64(report.Records)
+	}
+	index, err := db.IndexStats()
+	if err != nil {
+		return err
+	}
+	open := db.OpenReport()
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "keys\t%d\n", db.Count())
+	fmt.Fprintf(tw, "segments\t%d\n", report.Segments)
+	fmt.Fprintf(tw, "records\t%d\n", report.Records)
+	fmt.Fprintf(tw, "size\t%d\n", size)
+	fmt.Fprintf(tw, "fragmentation\t%.3f\n", fragmentation)
+	fmt.Fprintf(tw, "index shards\t%d\n", open.IndexShards)
+	fmt.Fprintf(tw, "index load factor\t%.3f\n", open.IndexLoadFactor)
+	fmt.Fprintf(tw, "index buckets\t%d\n", index.Buckets)
+	fmt.Fprintf(tw, "index overflow\t%d\n", index.OverflowBuckets)
+	fmt.Fprintf(tw, "index probe len\t%.3f\n", index.AverageProbeLength)
+	fmt.Fprintf(tw, "index memory\t%d\n", index.MemoryBytes)
+	return tw.Flush()
+}
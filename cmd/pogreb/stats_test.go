@@ -0,0 +1,37 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/domaincrawler/pogreb"
+	"github.com/domaincrawler/pogreb/fs"
+	"github.com/domaincrawler/pogreb/internal/assert"
+)
+
+func TestStats(t *testing.T) {
+	fsys := fs.Sub(fs.Mem, "stats.db")
+	files, err := fsys.ReadDir(".")
+	assert.Nil(t, err)
+	for _, file := range files {
+		_ = fsys.Remove(file.Name())
+	}
+	db, err := pogreb.Open("stats.db", &pogreb.Options{FileSystem: fs.Mem})
+	assert.Nil(t, err)
+	t.Cleanup(func() {
+		assert.Nil(t, db.Close())
+	})
+	assert.Nil(t, db.Put([]byte("a")))
+	assert.Nil(t, db.Put([]byte("b")))
+	assert.Nil(t, db.Put([]byte("a")))
+	assert.Nil(t, db.Put([]byte("b")))
+
+	buf := &bytes.Buffer{}
+	assert.Nil(t, writeStats(buf, db))
+	out := buf.String()
+	assert.Equal(t, true, strings.Contains(out, "keys               2\n"))
+	assert.Equal(t, true, strings.Contains(out, "records            4\n"))
+	assert.Equal(t, true, strings.Contains(out, "fragmentation      0.500\n"))
+	assert.Equal(t, true, strings.Contains(out, "index buckets      1\n"))
+}
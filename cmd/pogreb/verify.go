@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/domaincrawler/pogreb"
+)
+
+var errVerifyFailed = errors.New("verification failed")
+
+// runVerify checks the checksums of all records and the index entries pointing to them.
+func runVerify(args []string) error {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	path, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	db, err := pogreb.Open(path, &pogreb.Options{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return verify(os.Stdout, db)
+}
+
+func verify(w io.Writer, db *pogreb.DB) error {
+	report, err := db.Verify(context.Background())
+	if err != nil {
+		return err
+	}
+	for _, rec := range report.CorruptedRecords {
+		fmt.Fprintf(w, "corrupted record: segment %s offset %d: %v\n", rec.Segment, rec.Offset, rec.Err)
+	}
+	for _, e := range report.OrphanedEntries {
+		fmt.Fprintf(w, "orphaned index entry: segment %d offset %d: %s\n", e.SegmentID, e.Offset, e.Reason)
+	}
+	fmt.Fprintf(w, "segments %d, records %d, index entries %d, index keys %d\n",
+		report.Segments, report.Records, report.IndexEntries, report.IndexKeys)
+	if !report.OK() {
+		return errVerifyFailed
+	}
+	return nil
+}
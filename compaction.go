@@ -1,25 +1,25 @@
 package pogreb
 
 import (
+	"io"
+	"runtime"
 	"sync/atomic"
+	"time"
 
 	"github.com/domaincrawler/pogreb/internal/errors"
 )
 
-// promoteRecord writes the record to the current segment if the index still points to the record.
-// Otherwise it discards the record.
-func (db *DB) promoteRecord(rec record) (bool, error) {
-	hash := db.hash(rec.key)
-	it := db.index.newBucketIterator(db.index.bucketIndex(hash))
+// findRecordSlot returns the bucket and the slot index pointing to the record.
+// The returned bool is false if the index doesn't point to the record, i.e. the key was deleted or overwritten.
+func findRecordSlot(idx *index, hash uint64, rec record) (bucketHandle, int, bool, error) {
+	it := idx.newBucketIterator(idx.bucketIndex(hash))
 	for {
 		b, err := it.next()
 		if err == ErrIterationDone {
-			// Exhausted all buckets and the slot wasn't found.
-			// The key was deleted or overwritten. The record is safe to discard.
-			return true, nil
+			return bucketHandle{}, 0, false, nil
 		}
 		if err != nil {
-			return false, err
+			return bucketHandle{}, 0, false, err
 		}
 		for i := 0; i < slotsPerBucket; i++ {
 			sl := b.slots[i]
@@ -34,20 +34,53 @@ func (db *DB) promoteRecord(rec record) (bool, error) {
 				continue
 			}
 
-			// The record is in the index, write it to the current segment.
-			segmentID, offset, err := db.datalog.writeRecord(rec.data) // TODO: batch writes
-			if err != nil {
-				return false, err
-			}
-
-			// Update index.
-			b.slots[i].segmentID = segmentID
-			b.slots[i].offset = offset
-			return false, b.write()
+			return b, i, true, nil
 		}
 	}
 }
 
+// promoteRecord writes the record to the current segment if the index still points to the record.
+// Otherwise it discards the record. A live record of a key matching drop is discarded and the key is removed from the index.
+// The caller must hold the DB write lock.
+func (db *DB) promoteRecord(rec record, drop func(key []byte) bool) (bool, error) {
+	hash := db.hash(rec.key)
+	shard := db.index.shard(hash)
+	b, i, found, err := findRecordSlot(shard.index, hash, rec)
+	if err != nil {
+		return false, err
+	}
+	if !found {
+		// Exhausted all buckets and the slot wasn't found.
+		// The key was deleted or overwritten. The record is safe to discard.
+		return true, nil
+	}
+	if drop != nil && drop(rec.key) {
+		// The source segment is removed after the pass, no other put record of the key remains.
+		_, err := db.index.deleteFunc(shard, shard.bucketIndex(hash), func(sl slot) (bool, error) {
+			return sl.hash == hash && sl.offset == rec.offset && sl.segmentID == rec.segmentID, nil
+		})
+		db.deleted([][]byte{append([]byte(nil), rec.key...)})
+		return true, err
+	}
+
+	// The record is in the index, write it to the current segment.
+	// The key is re-encoded, the source segment may be using a different record format. The flags are kept.
+	segmentID, offset, err := db.datalog.put(rec.key, rec.flags) // TODO: batch writes
+	if err != nil {
+		return false, err
+	}
+	if atomic.LoadInt32(&db.subscriptions.active) > 0 {
+		db.datalog.recordRewrite(rec, segmentID, offset)
+	}
+	db.datalog.recordCopy(rec, segmentID)
+
+	// Update index.
+	b.slots[i].segmentID = segmentID
+	b.slots[i].keySize = slotKeySize(rec.key)
+	b.slots[i].offset = offset
+	return false, shard.writeBucket(&b)
+}
+
 // CompactionResult holds the compaction result.
 type CompactionResult struct {
 	CompactedSegments int
@@ -55,7 +88,9 @@ type CompactionResult struct {
 	ReclaimedBytes    int
 }
 
-func (db *DB) compact(sourceSeg *segment) (CompactionResult, error) {
+// compact copies the live records of the segment to the current segment and removes the segment.
+// Live records of keys matching drop are discarded, see DeleteWhere.
+func (db *DB) compact(sourceSeg *segment, drop func(key []byte) bool) (CompactionResult, error) {
 	cr := CompactionResult{}
 
 	db.mu.Lock()
@@ -66,21 +101,47 @@ func (db *DB) compact(sourceSeg *segment) (CompactionResult, error) {
 	if err != nil {
 		return cr, err
 	}
+	throttle := cpuThrottle{share: db.opts.CompactionCPUShare}
+	// Followers which haven't received the discarded delete records have to resynchronize,
+	// and so do all followers once keys are removed without delete records.
+	discarded, removed := false, false
+	if pred := drop; pred != nil {
+		drop = func(key []byte) bool {
+			if pred(key) {
+				removed = true
+				return true
+			}
+			return false
+		}
+	}
 	// Copy records from sourceSeg to the current segment.
 	for {
+		start := time.Now()
 		err := func() error {
 			db.mu.Lock()
 			defer db.mu.Unlock()
 			rec, err := it.next()
+			if (errors.Is(err, ErrCorrupted) || err == io.ErrUnexpectedEOF) && db.opts.CompactionSkipCorrupted {
+				next, err := db.skipCorrupted(sourceSeg, it.offset)
+				if err != nil {
+					return err
+				}
+				cr.ReclaimedBytes += int(next - it.offset)
+				removed = true
+				it, err = newSegmentIteratorAt(sourceSeg, next)
+				return err
+			}
 			if err != nil {
 				return err
 			}
-			//if rec.rtype == recordTypeDelete {
-			//	cr.ReclaimedRecords++
-			//	cr.ReclaimedBytes += len(rec.data)
-			//	return nil
-			//}
-			reclaimed, err := db.promoteRecord(rec)
+			if rec.rtype == recordTypeDelete {
+				// Older segments are compacted first, they hold no put records of the deleted key.
+				cr.ReclaimedRecords++
+				cr.ReclaimedBytes += len(rec.data)
+				discarded = true
+				return nil
+			}
+			reclaimed, err := db.promoteRecord(rec, drop)
 			if reclaimed {
 				cr.ReclaimedRecords++
 				cr.ReclaimedBytes += len(rec.data)
@@ -93,14 +154,100 @@ func (db *DB) compact(sourceSeg *segment) (CompactionResult, error) {
 		if err != nil {
 			return cr, err
 		}
+		if pause := throttle.add(time.Since(start)); pause > 0 {
+			time.Sleep(pause)
+		} else if throttle.share < 1 {
+			// Let goroutines waiting for the lock run before the next record.
+			runtime.Gosched()
+		}
 	}
 
 	db.mu.Lock()
 	defer db.mu.Unlock()
+	// The index snapshot may reference the removed segment.
+	if err := db.removeCheckpoint(); err != nil {
+		return cr, err
+	}
+	if removed {
+		// The horizon must be readable, followers resynchronizing from the tail don't start over.
+		if err := db.sync(); err != nil {
+			return cr, err
+		}
+		db.datalog.mu.RLock()
+		seq, size := db.datalog.curSeg.sequenceID, db.datalog.replicableSize(db.datalog.curSeg)
+		db.datalog.mu.RUnlock()
+		if err := db.raiseReplicationHorizon(seq, size); err != nil {
+			return cr, err
+		}
+	} else if discarded {
+		if err := db.raiseReplicationHorizon(sourceSeg.sequenceID+1, 0); err != nil {
+			return cr, err
+		}
+	}
+	db.events.emit(SegmentCompacted{
+		Name:             sourceSeg.name,
+		ReclaimedRecords: cr.ReclaimedRecords,
+		ReclaimedBytes:   cr.ReclaimedBytes,
+	})
 	err = db.datalog.removeSegment(sourceSeg)
 	return cr, err
 }
 
+// cpuThrottleQuantum is the amount of work accumulated by cpuThrottle before pausing.
+// Pausing after every record would make the sleep durations too short to be accurate.
+const cpuThrottleQuantum = 5 * time.Millisecond
+
+// cpuThrottle limits the CPU share of a loop by pausing in proportion to the time spent working.
+type cpuThrottle struct {
+	share float64
+	work  time.Duration // Work accumulated since the last pause.
+}
+
+// add accounts the work and returns how long to pause.
+func (t *cpuThrottle) add(work time.Duration) time.Duration {
+	if t.share >= 1 {
+		return 0
+	}
+	t.work += work
+	if t.work < cpuThrottleQuantum {
+		return 0
+	}
+	pause := time.Duration(float64(t.work) * (1 - t.share) / t.share)
+	t.work = 0
+	return pause
+}
+
+// skipCorrupted finds the next valid record after the corrupted data at the offset
+// and removes index entries pointing to the corrupted data. It returns the offset of the next valid record.
+// The caller must hold the DB write lock.
+func (db *DB) skipCorrupted(seg *segment, offset uint64) (uint64, error) {
+	data, err := seg.Slice(int64(offset), seg.size)
+	if err != nil {
+		return 0, err
+	}
+	next := uint64(len(data))
+	for p := uint64(1); p < uint64(len(data)); p++ {
+		if _, err := decodeRecordSize(data[p:], seg.file); err == nil {
+			next = p
+			break
+		}
+	}
+	next += offset
+	deleted, err := db.index.deleteSlots(seg.id, offset, next)
+	if err != nil {
+		return 0, err
+	}
+	db.metrics.CorruptedRecordsSkipped.Add(1)
+	db.metrics.Dels.Add(int64(deleted))
+	if deleted > 0 {
+		db.invalidation.invalidateAll()
+		db.countWatches.update(db.index.count())
+	}
+	db.opts.Logger.Logf(LogWarn, "skipped %d bytes of corrupted data in segment %s at offset %d, removed %d keys",
+		next-offset, seg.name, offset, deleted)
+	return next, nil
+}
+
 // pickForCompaction returns segments eligible for compaction.
 func (db *DB) pickForCompaction() []*segment {
 	segments := db.datalog.segmentsBySequenceID()
@@ -108,7 +255,7 @@ func (db *DB) pickForCompaction() []*segment {
 	for i := len(segments) - 1; i >= 0; i-- {
 		seg := segments[i]
 
-		if uint32(seg.size) < db.opts.compactionMinSegmentSize {
+		if seg.size < int64(db.opts.compactionMinSegmentSize) || seg.archived() {
 			continue
 		}
 
@@ -116,44 +263,197 @@ func (db *DB) pickForCompaction() []*segment {
 			continue
 		}
 
-		//if seg.meta.DeleteRecords > 0 {
-		//	// Delete records can be discarded only when older segments contain no put records
-		//	// for the corresponding keys.
-		//	// All segments older than the segment eligible for compaction have to be compacted.
-		//	return append(segments[:i+1], picked...)
-		//}
+		if seg.meta.DeleteRecords > 0 {
+			// Delete records can be discarded only when older segments contain no put records
+			// for the corresponding keys.
+			// All segments older than the segment eligible for compaction have to be compacted.
+			if anyArchived(segments[:i]) {
+				continue
+			}
+			return append(segments[:i+1], picked...)
+		}
 
 		picked = append([]*segment{seg}, picked...)
 	}
 	return picked
 }
 
+// liveBytes returns the size of the records of the segment that compaction would copy.
+func (db *DB) liveBytes(seg *segment) (int64, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	it, err := newSegmentIterator(seg)
+	if err != nil {
+		return 0, err
+	}
+	var live int64
+	for {
+		rec, err := it.next()
+		if err == ErrIterationDone {
+			return live, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		if rec.rtype == recordTypeDelete {
+			continue
+		}
+		hash := db.hash(rec.key)
+		shard := db.index.shard(hash)
+		shard.mu.RLock()
+		_, _, found, err := findRecordSlot(shard.index, hash, rec)
+		shard.mu.RUnlock()
+		if err != nil {
+			return 0, err
+		}
+		if found {
+			live += recordSize(rec.key)
+		}
+	}
+}
+
+// fitsCompactionBudget reports whether the live data of the segment can be copied
+// within Options.CompactionMaxTempBytes. It returns ErrDiskFull if the data doesn't fit into the free disk space.
+func (db *DB) fitsCompactionBudget(seg *segment) (bool, error) {
+	guard := db.datalog.diskSpace.fsys != nil
+	if db.opts.CompactionMaxTempBytes <= 0 && !guard {
+		return true, nil
+	}
+	live, err := db.liveBytes(seg)
+	if err != nil {
+		return false, err
+	}
+	if max := db.opts.CompactionMaxTempBytes; max > 0 && live > max {
+		db.opts.Logger.Logf(LogWarn, "compaction stopped before segment %s: %d bytes of live data exceed the limit of %d bytes",
+			seg.name, live, max)
+		return false, nil
+	}
+	if guard {
+		db.datalog.mu.Lock()
+		defer db.datalog.mu.Unlock()
+		g := &db.datalog.diskSpace
+		if err := g.check(); err != nil {
+			return false, errors.Wrap(err, "checking free disk space")
+		}
+		if g.free < g.min+uint64(live) {
+			return false, ErrDiskFull
+		}
+	}
+	return true, nil
+}
+
 // Compact compacts the DB. Deleted and overwritten items are discarded.
 // Returns an error if compaction is already in progress.
 func (db *DB) Compact() (CompactionResult, error) {
+	if db.metrics.CompactLatency != nil {
+		defer db.metrics.CompactLatency.since(time.Now())
+	}
 	cr := CompactionResult{}
+	if db.opts.ReadOnly {
+		return cr, errReadOnly
+	}
 
 	// Run only a single compaction at a time.
 	if !atomic.CompareAndSwapInt32(&db.compactionRunning, 0, 1) {
-		return cr, errBusy
+		return cr, ErrBusy
 	}
 	defer func() {
 		atomic.StoreInt32(&db.compactionRunning, 0)
 	}()
 
-	db.mu.RLock()
+	// Compaction copies records before it frees space, it doesn't start when the disk is almost full.
+	db.datalog.mu.Lock()
+	low, err := db.datalog.diskSpace.belowWatermark()
+	db.datalog.mu.Unlock()
+	if err != nil {
+		return cr, err
+	}
+	if low {
+		return cr, ErrDiskFull
+	}
+
+	db.mu.Lock()
 	segments := db.pickForCompaction()
-	db.mu.RUnlock()
+	db.mu.Unlock()
 
+	start := time.Now()
+	var processed int64
 	for _, seg := range segments {
-		segcr, err := db.compact(seg)
+		if fits, err := db.fitsCompactionBudget(seg); err != nil || !fits {
+			// Segments are compacted in order, delete records are discarded only after the older segments.
+			if err != nil {
+				return cr, errors.Wrapf(err, "compacting segment %s", seg.name)
+			}
+			break
+		}
+		processed += seg.size
+		segcr, err := db.compact(seg, nil)
 		if err != nil {
 			return cr, errors.Wrapf(err, "compacting segment %s", seg.name)
 		}
 		cr.CompactedSegments++
 		cr.ReclaimedRecords += segcr.ReclaimedRecords
 		cr.ReclaimedBytes += segcr.ReclaimedBytes
+		db.metrics.SegmentsCompacted.Add(1)
+		db.metrics.BytesReclaimed.Add(int64(segcr.ReclaimedBytes))
 	}
+	elapsed := time.Since(start)
+	db.metrics.CompactionSeconds.Add(elapsed.Seconds())
+	db.trackCompactionThroughput(processed, elapsed)
 
 	return cr, nil
 }
+
+// DeleteWhere removes the keys for which pred returns true and returns the number of removed keys.
+// Unlike DeleteFunc, it writes no delete records: it rewrites all segments oldest first like Compact,
+// copying the live records of the other keys to the current segment, which reclaims the space of the removed keys
+// in a single pass. pred must not modify the key or call methods of the DB.
+// Keys removed from the segment being rewritten can reappear after a crash, running DeleteWhere again removes them.
+// Returns ErrBusy if compaction is in progress.
+func (db *DB) DeleteWhere(pred func(key []byte) bool) (int, error) {
+	if err := db.checkWritable(); err != nil {
+		return 0, err
+	}
+	if !atomic.CompareAndSwapInt32(&db.compactionRunning, 0, 1) {
+		return 0, ErrBusy
+	}
+	defer func() {
+		atomic.StoreInt32(&db.compactionRunning, 0)
+	}()
+
+	db.datalog.mu.Lock()
+	low, err := db.datalog.diskSpace.belowWatermark()
+	db.datalog.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	if low {
+		return 0, ErrDiskFull
+	}
+
+	db.mu.Lock()
+	segments := db.datalog.segmentsBySequenceID()
+	db.mu.Unlock()
+	if anyArchived(segments) {
+		// The put records of archived segments would outlive the discarded delete records.
+		return 0, errArchivedReadOnly
+	}
+
+	deleted := 0
+	drop := func(key []byte) bool {
+		if pred(key) {
+			deleted++
+			return true
+		}
+		return false
+	}
+	for _, seg := range segments {
+		segcr, err := db.compact(seg, drop)
+		if err != nil {
+			return deleted, errors.Wrapf(err, "rewriting segment %s", seg.name)
+		}
+		db.metrics.SegmentsCompacted.Add(1)
+		db.metrics.BytesReclaimed.Add(int64(segcr.ReclaimedBytes))
+	}
+	return deleted, nil
+}
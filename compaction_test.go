@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/domaincrawler/pogreb/internal/assert"
+	"github.com/domaincrawler/pogreb/internal/errors"
 )
 
 func fileExists(name string) bool {
@@ -214,7 +215,7 @@ func TestCompaction(t *testing.T) {
 			return atomic.LoadInt32(&db.compactionRunning) == 1
 		})
 		_, err := db.Compact()
-		assert.Equal(t, errBusy, err)
+		assert.Equal(t, ErrBusy, err)
 		db.mu.Unlock()
 		wg.Wait()
 	})
@@ -244,3 +245,178 @@ func TestBackgroundCompaction(t *testing.T) {
 
 	assert.Nil(t, db.Close())
 }
+
+func TestPlanCompaction(t *testing.T) {
+	db, err := createTestDB(nil)
+	assert.Nil(t, err)
+
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, db.Put([]byte{byte(i)}))
+	}
+	// Overwrite 3 keys.
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, db.Put([]byte{byte(i)}))
+	}
+
+	// Segments are scanned without modifying them.
+	seg := db.datalog.curSeg
+	size := seg.size
+	plan := CompactionPlan{}
+	assert.Nil(t, db.planSegment(seg, size, &plan))
+	assert.Equal(t, CompactionPlan{ReclaimableRecords: 3, ReclaimableBytes: 21, LiveBytes: 70}, plan)
+	assert.Equal(t, size, seg.size)
+
+	// The plan matches the segments picked by the compaction.
+	plan, err = db.PlanCompaction()
+	assert.Nil(t, err)
+	assert.Equal(t, len(db.pickForCompaction()), len(plan.Segments))
+
+	atomic.StoreInt32(&db.compactionRunning, 1)
+	_, err = db.PlanCompaction()
+	assert.Equal(t, ErrBusy, err)
+	atomic.StoreInt32(&db.compactionRunning, 0)
+
+	db.trackCompactionThroughput(1000, time.Second)
+	assert.Equal(t, 2*time.Second, db.estimateCompactionDuration(2000))
+
+	assert.Nil(t, db.Close())
+}
+
+func TestCompactionSkipCorrupted(t *testing.T) {
+	opts := &Options{
+		maxSegmentSize: int64(headerSize + 5*encodedRecordSize(1)),
+		IndexShards:    2,
+	}
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, db.Put([]byte{byte(i)}))
+	}
+	seg := db.datalog.segmentsBySequenceID()[0]
+	// Corrupt the size of the third record, the iterator loses track of record boundaries.
+	_, err = seg.WriteAt([]byte{0xff}, int64(headerSize+2*encodedRecordSize(1)))
+	assert.Nil(t, err)
+
+	_, err = db.compact(seg, nil)
+	assert.NotNil(t, err)
+
+	db.opts.CompactionSkipCorrupted = true
+	cr, err := db.compact(seg, nil)
+	assert.Nil(t, err)
+	// The first two records were moved by the failed compaction.
+	assert.Equal(t, 2, cr.ReclaimedRecords)
+	assert.Equal(t, 3*int(encodedRecordSize(1)), cr.ReclaimedBytes)
+	assert.Equal(t, int64(1), db.Metrics().CorruptedRecordsSkipped.Value())
+	assert.Equal(t, uint64(9), db.Count())
+	for i := 0; i < 10; i++ {
+		has, err := db.Has([]byte{byte(i)})
+		assert.Nil(t, err)
+		assert.Equal(t, i != 2, has)
+	}
+	assert.Nil(t, db.Close())
+}
+
+func TestDeleteWhere(t *testing.T) {
+	opts := &Options{maxSegmentSize: int64(headerSize + 5*encodedRecordSize(1))}
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 20; i++ {
+		assert.Nil(t, db.Put([]byte{byte(i)}))
+	}
+	assert.Nil(t, db.Delete([]byte{19}))
+	n, err := db.DeleteWhere(func(key []byte) bool { return key[0]%2 == 0 })
+	assert.Nil(t, err)
+	assert.Equal(t, 10, n)
+	assert.Equal(t, uint64(9), db.Count())
+	for i := 0; i < 20; i++ {
+		assertHas(t, db, []byte{byte(i)}, i%2 == 1 && i != 19)
+	}
+	// No delete records are written, the rewritten segments hold only the kept keys.
+	var size int64
+	for _, seg := range db.datalog.segmentsBySequenceID() {
+		assert.Equal(t, uint32(0), seg.meta.DeleteRecords)
+		size += seg.size - headerSize
+	}
+	assert.Equal(t, int64(9*encodedRecordSize(1)), size)
+	assert.Nil(t, db.Close())
+
+	// Removed keys don't reappear after reopening.
+	db, err = Open(testDBName, &Options{FileSystem: testFS, maxSegmentSize: opts.maxSegmentSize})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(9), db.Count())
+	assertHas(t, db, []byte{0}, false)
+	assertHas(t, db, []byte{1}, true)
+	assert.Nil(t, db.Close())
+}
+
+func TestCPUThrottle(t *testing.T) {
+	unthrottled := cpuThrottle{share: 1}
+	assert.Equal(t, time.Duration(0), unthrottled.add(time.Second))
+
+	throttle := cpuThrottle{share: 0.25}
+	// Work below the quantum is accumulated.
+	assert.Equal(t, time.Duration(0), throttle.add(cpuThrottleQuantum/2))
+	assert.Equal(t, 3*cpuThrottleQuantum, throttle.add(cpuThrottleQuantum/2))
+	assert.Equal(t, time.Duration(0), throttle.add(time.Millisecond))
+}
+
+func TestCompactionCPUShare(t *testing.T) {
+	opts := &Options{
+		maxSegmentSize:     int64(headerSize + 5*encodedRecordSize(1)),
+		CompactionCPUShare: 0.5,
+	}
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, db.Put([]byte{byte(i)}))
+	}
+	assert.Nil(t, db.Put([]byte{0}))
+	cr, err := db.compact(db.datalog.segmentsBySequenceID()[0], nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, cr.ReclaimedRecords)
+	assert.Equal(t, uint64(10), db.Count())
+	assert.Nil(t, db.Close())
+
+	db, err = Open(testDBName, &Options{FileSystem: testFS, CompactionCPUShare: 2})
+	assert.Nil(t, err)
+	assert.Equal(t, float64(1), db.opts.CompactionCPUShare)
+	assert.Nil(t, db.Close())
+}
+
+func TestCompactionMaxTempBytes(t *testing.T) {
+	fsys := &freeSpaceFS{FileSystem: testFS, free: 10 << 20}
+	opts := &Options{
+		FileSystem:                 fsys,
+		MinFreeDiskBytes:           1 << 20,
+		CompactionMaxTempBytes:     100,
+		maxSegmentSize:             1024,
+		compactionMinSegmentSize:   520,
+		compactionMinFragmentation: -1,
+	}
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+	// Two full segments of 73 keys.
+	for i := 0; i < 150; i++ {
+		assert.Nil(t, db.Put([]byte{byte(i)}))
+	}
+	cr, err := db.Compact()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, cr.CompactedSegments)
+
+	// Overwritten keys leave 3 live records in the first segment.
+	for i := 0; i < 70; i++ {
+		assert.Nil(t, db.Put([]byte{byte(i)}))
+	}
+	atomic.StoreUint64(&fsys.free, 1<<20+10)
+	_, err = db.Compact()
+	assert.Equal(t, true, errors.Is(err, ErrDiskFull))
+
+	atomic.StoreUint64(&fsys.free, 10<<20)
+	cr, err = db.Compact()
+	assert.Nil(t, err)
+	assert.Equal(t, CompactionResult{CompactedSegments: 1, ReclaimedRecords: 70, ReclaimedBytes: 70 * 7}, cr)
+	for i := 0; i < 150; i++ {
+		assertHas(t, db, []byte{byte(i)}, true)
+	}
+	assert.Nil(t, db.Close())
+}
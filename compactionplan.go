@@ -0,0 +1,121 @@
+package pogreb
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/domaincrawler/pogreb/internal/errors"
+)
+
+// defaultCompactionThroughput is the assumed rate of processing segment data in bytes per second,
+// used to estimate the compaction duration before any compaction has run.
+const defaultCompactionThroughput = 64 << 20
+
+// CompactionPlan describes the work Compact would perform.
+type CompactionPlan struct {
+	// Segments holds the names of the segments selected for compaction.
+	Segments []string
+
+	// ReclaimableRecords is the number of deleted or overwritten records in the selected segments.
+	ReclaimableRecords int
+
+	// ReclaimableBytes is the size of deleted or overwritten records in the selected segments.
+	ReclaimableBytes int
+
+	// LiveBytes is the size of records which would be rewritten to the current segment.
+	LiveBytes int
+
+	// EstimatedDuration is based on the throughput of the last compaction.
+	EstimatedDuration time.Duration
+}
+
+// trackCompactionThroughput records the rate at which the last compaction processed segment data.
+func (db *DB) trackCompactionThroughput(processed int64, elapsed time.Duration) {
+	if processed == 0 || elapsed <= 0 {
+		return
+	}
+	throughput := int64(float64(processed) / elapsed.Seconds())
+	if throughput < 1 {
+		throughput = 1
+	}
+	atomic.StoreInt64(&db.compactionThroughput, throughput)
+}
+
+func (db *DB) estimateCompactionDuration(bytes int64) time.Duration {
+	throughput := atomic.LoadInt64(&db.compactionThroughput)
+	if throughput == 0 {
+		throughput = defaultCompactionThroughput
+	}
+	return time.Duration(float64(bytes) / float64(throughput) * float64(time.Second))
+}
+
+// planSegment scans the segment and counts records which are no longer referenced by the index.
+func (db *DB) planSegment(seg *segment, size int64, plan *CompactionPlan) error {
+	it, err := newSegmentIterator(seg)
+	if err != nil {
+		return err
+	}
+	// Stop at the size observed when the plan was started, the segment may be appended to concurrently.
+	for int64(it.offset) < size {
+		rec, err := it.next()
+		if err == ErrIterationDone {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		hash := db.hash(rec.key)
+		shard := db.index.shard(hash)
+		shard.mu.RLock()
+		_, _, live, err := findRecordSlot(shard.index, hash, rec)
+		shard.mu.RUnlock()
+		if err != nil {
+			return err
+		}
+		if live {
+			plan.LiveBytes += len(rec.data)
+		} else {
+			plan.ReclaimableRecords++
+			plan.ReclaimableBytes += len(rec.data)
+		}
+	}
+	return nil
+}
+
+// PlanCompaction returns the segments Compact would select and an estimate of its impact,
+// without modifying the DB. Segments are scanned to find the records which can be reclaimed.
+// Returns an error if compaction is in progress.
+func (db *DB) PlanCompaction() (CompactionPlan, error) {
+	plan := CompactionPlan{}
+
+	// The plan reads segments the same way as the compaction does, they can't run concurrently.
+	if !atomic.CompareAndSwapInt32(&db.compactionRunning, 0, 1) {
+		return plan, ErrBusy
+	}
+	defer func() {
+		atomic.StoreInt32(&db.compactionRunning, 0)
+	}()
+
+	db.mu.Lock()
+	segments := db.pickForCompaction()
+	sizes := make([]int64, len(segments))
+	for i, seg := range segments {
+		sizes[i] = seg.size
+	}
+	db.mu.Unlock()
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var processed int64
+	for i, seg := range segments {
+		if err := db.planSegment(seg, sizes[i], &plan); err != nil {
+			return plan, errors.Wrapf(err, "planning compaction of segment %s", seg.name)
+		}
+		plan.Segments = append(plan.Segments, seg.name)
+		processed += sizes[i]
+	}
+	plan.EstimatedDuration = db.estimateCompactionDuration(processed)
+
+	return plan, nil
+}
@@ -0,0 +1,123 @@
+package pogreb
+
+import (
+	"bytes"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/domaincrawler/pogreb/internal/errors"
+)
+
+// countPrefixSampleRecords is the number of live records CountPrefixApprox scans before it stops sampling segments.
+const countPrefixSampleRecords = 1 << 14
+
+var errPrefixFingerprints = errors.New("key prefixes aren't stored by databases storing fingerprints")
+
+// CountPrefix returns the number of keys starting with the prefix. It iterates all keys of the DB,
+// see CountPrefixApprox for an estimate.
+func (db *DB) CountPrefix(prefix []byte) (uint64, error) {
+	if db.fingerprintSize != 0 {
+		return 0, errPrefixFingerprints
+	}
+	var n uint64
+	it := db.Items()
+	for {
+		key, err := it.Next()
+		if err == ErrIterationDone {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		if bytes.HasPrefix(key, prefix) {
+			n++
+		}
+	}
+}
+
+// CountPrefixApprox estimates the number of keys starting with the prefix.
+// It scans the live records of randomly picked segments until it has seen about 16K keys,
+// and scales the share of the matching keys to the number of keys of the DB.
+// The count is exact if all segments were scanned. Keys written together are stored in the same segments,
+// the estimate of a prefix whose keys were written in bursts is less accurate.
+// Returns an error if compaction is in progress.
+func (db *DB) CountPrefixApprox(prefix []byte) (uint64, error) {
+	if db.fingerprintSize != 0 {
+		return 0, errPrefixFingerprints
+	}
+	// Segments are read the same way as the compaction does, they can't be scanned concurrently.
+	if !atomic.CompareAndSwapInt32(&db.compactionRunning, 0, 1) {
+		return 0, ErrBusy
+	}
+	defer func() {
+		atomic.StoreInt32(&db.compactionRunning, 0)
+	}()
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	segments := db.datalog.segmentsBySequenceID()
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	rnd.Shuffle(len(segments), func(i, j int) {
+		segments[i], segments[j] = segments[j], segments[i]
+	})
+	var live, matching uint64
+	scanned := 0
+	for _, seg := range segments {
+		if live >= countPrefixSampleRecords {
+			break
+		}
+		if seg.archived() {
+			// Reading an archived segment fetches it from the archive.
+			continue
+		}
+		if err := db.countSegmentPrefix(seg, prefix, &live, &matching); err != nil {
+			return 0, errors.Wrapf(err, "scanning segment %s", seg.name)
+		}
+		scanned++
+	}
+	if scanned == len(segments) || live == 0 {
+		return matching, nil
+	}
+	return uint64(float64(db.Count()) * float64(matching) / float64(live)), nil
+}
+
+// countSegmentPrefix counts the live records of the segment and the live records of keys starting with the prefix.
+// The caller must hold the DB read lock.
+func (db *DB) countSegmentPrefix(seg *segment, prefix []byte, live *uint64, matching *uint64) error {
+	db.datalog.mu.RLock()
+	size := seg.size
+	db.datalog.mu.RUnlock()
+	it, err := newSegmentIterator(seg)
+	if err != nil {
+		return err
+	}
+	// Stop at the size observed before the scan, the segment may be appended to concurrently.
+	for int64(it.offset) < size {
+		rec, err := it.next()
+		if err == ErrIterationDone {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if rec.rtype == recordTypeDelete {
+			continue
+		}
+		hash := db.hash(rec.key)
+		shard := db.index.shard(hash)
+		shard.mu.RLock()
+		_, _, found, err := findRecordSlot(shard.index, hash, rec)
+		shard.mu.RUnlock()
+		if err != nil {
+			return err
+		}
+		if found {
+			*live++
+			if bytes.HasPrefix(rec.key, prefix) {
+				*matching++
+			}
+		}
+	}
+	return nil
+}
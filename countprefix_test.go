@@ -0,0 +1,61 @@
+package pogreb
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/domaincrawler/pogreb/internal/assert"
+	"github.com/domaincrawler/pogreb/internal/errors"
+)
+
+func TestCountPrefix(t *testing.T) {
+	db, err := createTestDB(&Options{maxSegmentSize: 1024})
+	assert.Nil(t, err)
+	for i := 0; i < 300; i++ {
+		host := "a.example"
+		if i%3 == 0 {
+			host = "b.example"
+		}
+		assert.Nil(t, db.Put([]byte(fmt.Sprintf("%s/%d", host, i))))
+	}
+	assert.Nil(t, db.Delete([]byte("b.example/0")))
+	assert.Nil(t, db.Put([]byte("b.example/3")))
+
+	n, err := db.CountPrefix([]byte("b.example/"))
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(99), n)
+
+	// All segments hold fewer records than the sample, the count is exact.
+	n, err = db.CountPrefixApprox([]byte("b.example/"))
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(99), n)
+	n, err = db.CountPrefixApprox([]byte("c.example/"))
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), n)
+	assert.Nil(t, db.Close())
+
+	db, err = createTestDB(&Options{StoreFingerprintsOnly: true})
+	assert.Nil(t, err)
+	_, err = db.CountPrefix([]byte("b"))
+	assert.Equal(t, true, errors.Is(err, errPrefixFingerprints))
+	_, err = db.CountPrefixApprox([]byte("b"))
+	assert.Equal(t, true, errors.Is(err, errPrefixFingerprints))
+	assert.Nil(t, db.Close())
+}
+
+func TestCountPrefixApproxSample(t *testing.T) {
+	db, err := createTestDB(&Options{maxSegmentSize: 16 << 10})
+	assert.Nil(t, err)
+	for i := 0; i < 2*countPrefixSampleRecords; i++ {
+		key := fmt.Sprintf("b.example/%d", i)
+		if i%4 != 0 {
+			key = fmt.Sprintf("a.example/%d", i)
+		}
+		assert.Nil(t, db.Put([]byte(key)))
+	}
+	n, err := db.CountPrefixApprox([]byte("b.example/"))
+	assert.Nil(t, err)
+	want := float64(countPrefixSampleRecords / 2)
+	assert.Equal(t, true, float64(n) > 0.9*want && float64(n) < 1.1*want)
+	assert.Nil(t, db.Close())
+}
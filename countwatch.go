@@ -0,0 +1,78 @@
+package pogreb
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// countWatches calls the functions registered by WatchCount when the number of keys crosses their thresholds.
+type countWatches struct {
+	next    uint64 // Lowest threshold of the armed watches plus one, 0 if no watch is armed. Accessed atomically.
+	mu      sync.Mutex
+	watches []*countWatch
+}
+
+type countWatch struct {
+	threshold uint64
+	fn        func(count uint64)
+	reached   bool // The count is at or above the threshold, the watch fires again once it drops below.
+}
+
+// increased is called after the number of keys grew. It's a single atomic load unless a threshold is reached.
+func (cw *countWatches) increased(count uint64) {
+	if n := atomic.LoadUint64(&cw.next); n == 0 || count < n-1 {
+		return
+	}
+	cw.update(count)
+}
+
+// update fires watches with thresholds reached by the count and rearms watches with thresholds above it.
+func (cw *countWatches) update(count uint64) {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	var next uint64
+	for _, w := range cw.watches {
+		if !w.reached && count >= w.threshold {
+			w.reached = true
+			go w.fn(count)
+		} else if w.reached && count < w.threshold {
+			w.reached = false
+		}
+		if !w.reached && (next == 0 || w.threshold+1 < next) {
+			next = w.threshold + 1
+		}
+	}
+	atomic.StoreUint64(&cw.next, next)
+}
+
+func (cw *countWatches) add(w *countWatch, count uint64) {
+	cw.mu.Lock()
+	cw.watches = append(cw.watches, w)
+	cw.mu.Unlock()
+	cw.update(count)
+}
+
+func (cw *countWatches) remove(w *countWatch) {
+	cw.mu.Lock()
+	for i, cur := range cw.watches {
+		if cur == w {
+			cw.watches = append(cw.watches[:i], cw.watches[i+1:]...)
+			break
+		}
+	}
+	cw.mu.Unlock()
+}
+
+// WatchCount calls fn when the number of keys in the DB reaches the threshold,
+// e.g. to provision more capacity without polling Count.
+// fn is called again each time the count drops below the threshold, e.g. after DrainAndTruncate, and reaches it again.
+// It's called right away if the DB already holds threshold keys.
+// fn is called in its own goroutine with the count that reached the threshold.
+// WatchCount returns a function removing the watch.
+func (db *DB) WatchCount(threshold uint64, fn func(count uint64)) (stop func()) {
+	w := &countWatch{threshold: threshold, fn: fn}
+	db.countWatches.add(w, db.index.count())
+	return func() {
+		db.countWatches.remove(w)
+	}
+}
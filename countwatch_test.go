@@ -0,0 +1,58 @@
+package pogreb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/domaincrawler/pogreb/internal/assert"
+)
+
+func TestWatchCount(t *testing.T) {
+	db, err := createTestDB(nil)
+	assert.Nil(t, err)
+
+	fired := make(chan uint64, 10)
+	stop := db.WatchCount(3, func(count uint64) {
+		fired <- count
+	})
+	expectFired := func(count uint64) {
+		select {
+		case c := <-fired:
+			assert.Equal(t, count, c)
+		case <-time.After(5 * time.Second):
+			t.Fatal("watch didn't fire")
+		}
+	}
+
+	assert.Nil(t, db.Put([]byte{1}))
+	assert.Nil(t, db.Put([]byte{2}))
+	assert.Nil(t, db.Put([]byte{2}))
+	assert.Equal(t, 0, len(fired))
+	assert.Nil(t, db.Put([]byte{3}))
+	expectFired(3)
+	assert.Nil(t, db.Put([]byte{4}))
+
+	// The watch fires again after the count drops below the threshold.
+	assert.Nil(t, db.DrainAndTruncate(func(keys [][]byte) error { return nil }, 10))
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, db.Put([]byte{byte(i)}))
+	}
+	expectFired(3)
+
+	// Watches of reached thresholds fire right away.
+	db.WatchCount(2, func(count uint64) {
+		fired <- count
+	})
+	expectFired(3)
+
+	stop()
+	assert.Nil(t, db.DrainAndTruncate(func(keys [][]byte) error { return nil }, 10))
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, db.Put([]byte{byte(i)}))
+	}
+	expectFired(2)
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, 0, len(fired))
+
+	assert.Nil(t, db.Close())
+}
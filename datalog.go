@@ -1,37 +1,78 @@
 package pogreb
 
 import (
-	"fmt"
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"io"
 	"math"
 	"os"
 	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 
+	"github.com/domaincrawler/pogreb/fs"
 	"github.com/domaincrawler/pogreb/internal/errors"
 )
 
 const (
-	maxSegments = math.MaxInt16
+	// maxSegments is the number of distinct segment IDs.
+	// IDs of removed segments are reused by new segments.
+	maxSegments = math.MaxUint16 + 1
+
+	// maxSegmentSizeLimit is the largest size of a segment, the synced size of a segment takes 48 bits.
+	maxSegmentSizeLimit = 1<<48 - 1
 )
 
 // datalog is a write-ahead log.
 type datalog struct {
+	mu            sync.RWMutex // Serializes appends. Readers of segment data must hold the read lock.
 	opts          *Options
 	curSeg        *segment
 	segments      [maxSegments]*segment
+	idBound       int // IDs from the bound up are free, small databases don't scan the whole segment table.
 	maxSequenceID uint64
+	numWrites     uint64        // Number of written records. Accessed atomically.
+	numBytes      uint64        // Number of written bytes. Accessed atomically.
+	syncedBytes   uint64        // Number of written bytes at the time of the last sync. Accessed atomically.
+	watermark     syncWatermark // Number of durable records.
+	tail          *tailCache    // Nil if the tail cache is disabled.
+	synced        uint64        // ID and size of the current segment at the time of the last sync. Accessed atomically.
+	metrics       *Metrics
+	diskSpace     diskSpaceGuard
+	events        *eventDispatcher
+	generation    uint64      // Incremented by every change of the segment set. Accessed atomically.
+	truncatedGen  uint64      // Generation of the segment set emptied by the last truncation. Accessed atomically.
+	aead          cipher.AEAD // Encrypts the records of new segments, nil if encryption is disabled.
 }
 
-func openDatalog(opts *Options) (*datalog, error) {
+func openDatalog(opts *Options, metrics *Metrics, events *eventDispatcher) (*datalog, error) {
 	files, err := opts.FileSystem.ReadDir(".")
 	if err != nil {
 		return nil, err
 	}
 
 	dl := &datalog{
-		opts: opts,
+		opts:    opts,
+		metrics: metrics,
+		events:  events,
+	}
+	metrics.FreeSegmentIDs.Set(maxSegments)
+	dl.aead, err = newEncryptionAEAD(opts.EncryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	if !opts.ReadOnly {
+		dl.diskSpace, err = newDiskSpaceGuard(opts.FileSystem, opts.MinFreeDiskBytes)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if opts.TailCacheSize > 0 {
+		dl.tail = newTailCache(opts.TailCacheSize)
 	}
 
 	// Open existing segments.
@@ -47,12 +88,14 @@ func openDatalog(opts *Options) (*datalog, error) {
 		}
 		seg, err := dl.openSegment(name, id, seqID)
 		if err != nil {
+			// Close the segments opened so far, e.g. when an archived segment can't be opened.
+			dl.closeFiles()
 			return nil, errors.Wrapf(err, "opening segment %s", name)
 		}
 		if seg.sequenceID > dl.maxSequenceID {
 			dl.maxSequenceID = seg.sequenceID
 		}
-		dl.segments[seg.id] = seg
+		dl.setSegment(seg.id, seg)
 	}
 
 	if err := dl.swapSegment(); err != nil {
@@ -79,16 +122,52 @@ func parseSegmentName(name string) (uint16, uint64, error) {
 }
 
 func (dl *datalog) openSegment(name string, id uint16, seqID uint64) (*segment, error) {
-	f, err := openFile(dl.opts.FileSystem, name, false)
+	open := dl.opts.FileSystem.OpenFile
+	if dl.opts.UseMmap {
+		open = mmapOpenFunc(dl.opts.FileSystem)
+	}
+	if dfs, ok := dl.opts.FileSystem.(fs.DirectIOFileSystem); ok && dl.opts.DirectIO && !dl.opts.ReadOnly {
+		useMmap := dl.opts.UseMmap
+		open = func(name string, flag int, perm os.FileMode) (fs.File, error) {
+			return dfs.OpenDirectFile(name, flag, perm, useMmap)
+		}
+	}
+	if dl.opts.SyncPolicy == SyncAlways && writeThroughFlag != 0 {
+		open = withFlag(open, writeThroughFlag)
+	}
+	f, err := openFileWith(open, name, false)
 	if err != nil {
 		return nil, err
 	}
+	if err := openArchivedStub(dl.opts, name, f); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	if f.empty() && !dl.opts.ReadOnly && (f.flags&headerFlagLargeKeys == 0 || f.checksum != dl.opts.Checksum || f.encrypted() || dl.aead != nil ||
+		(f.recordFlagsSize() != 0) != dl.opts.RecordFlags) {
+		// New segments may store large-key records and use the configured checksum algorithm, encryption and record flags.
+		// Encrypted segments get a new nonce, records lost before a crash could have used the nonces of the offsets.
+		flags := f.flags | headerFlagLargeKeys
+		if dl.opts.RecordFlags {
+			flags |= headerFlagRecordFlags
+		} else {
+			flags &^= headerFlagRecordFlags
+		}
+		if err := f.setCipher(dl.aead, flags, dl.opts.Checksum); err != nil {
+			_ = f.Close()
+			return nil, err
+		}
+	} else if err := f.openCipher(dl.aead); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
 
 	meta := &segmentMeta{}
 	if !f.empty() {
 		metaName := name + metaExt
 		if err := readGobFile(dl.opts.FileSystem, metaName, &meta); err != nil {
-			logger.Printf("error reading segment meta %d: %v", id, err)
+			dl.opts.Logger.Logf(LogWarn, "error reading segment meta %d: %v", id, err)
 			// TODO: rebuild meta?
 		}
 	}
@@ -99,6 +178,22 @@ func (dl *datalog) openSegment(name string, id uint16, seqID uint64) (*segment,
 		sequenceID: seqID,
 		name:       name,
 		meta:       meta,
+		// The nonces of the offsets past the end of an encrypted segment might have been used
+		// by records lost in a crash, records are never appended to it again.
+		sealed: f.encrypted() && !f.empty(),
+	}
+	if seg.sealed && !dl.opts.ReadOnly {
+		meta.Full = true
+	}
+	seg.openStats()
+	if f.empty() && !dl.opts.ReadOnly {
+		if seg.createdAt.IsZero() {
+			seg.createdAt = dl.opts.Clock.Now()
+		}
+		if err := seg.writeStats(); err != nil {
+			_ = f.Close()
+			return nil, err
+		}
 	}
 
 	return seg, nil
@@ -112,18 +207,55 @@ func (dl *datalog) nextWritableSegmentID() (uint16, uint64, error) {
 			return uint16(id), dl.maxSequenceID, nil
 		}
 	}
-	return 0, 0, fmt.Errorf("number of segments exceeds %d", maxSegments)
+	return 0, 0, errors.Wrapf(ErrFull, "number of segments exceeds %d", maxSegments)
+}
+
+// setSegment stores the segment under the ID, a nil segment frees the ID.
+func (dl *datalog) setSegment(id uint16, seg *segment) {
+	if (dl.segments[id] == nil) != (seg == nil) {
+		delta := int64(1)
+		if seg == nil {
+			delta = -1
+		}
+		dl.metrics.Segments.Add(delta)
+		dl.metrics.FreeSegmentIDs.Add(-delta)
+	}
+	dl.segments[id] = seg
+	if seg != nil && int(id) >= dl.idBound {
+		dl.idBound = int(id) + 1
+	}
+	atomic.AddUint64(&dl.generation, 1)
+}
+
+// usedSegments returns the part of the segment table holding the segments, free IDs are nil.
+func (dl *datalog) usedSegments() []*segment {
+	return dl.segments[:dl.idBound]
 }
 
 func (dl *datalog) swapSegment() error {
 	// Pick unfilled segment.
-	for _, seg := range dl.segments {
-		if seg != nil && !seg.meta.Full {
-			dl.curSeg = seg
+	for _, seg := range dl.usedSegments() {
+		if seg != nil && !seg.meta.Full && !seg.sealed {
+			dl.setCurrentSegment(seg)
 			return nil
 		}
 	}
 
+	if dl.opts.ReadOnly {
+		// Nothing is appended, use the newest segment.
+		var newest *segment
+		for _, seg := range dl.usedSegments() {
+			if seg != nil && (newest == nil || seg.sequenceID > newest.sequenceID) {
+				newest = seg
+			}
+		}
+		if newest == nil {
+			return errors.New("no segments found")
+		}
+		dl.setCurrentSegment(newest)
+		return nil
+	}
+
 	// Create new segment.
 	id, seqID, err := dl.nextWritableSegmentID()
 	if err != nil {
@@ -136,22 +268,39 @@ func (dl *datalog) swapSegment() error {
 		return err
 	}
 
-	dl.segments[id] = seg
-	dl.curSeg = seg
+	dl.setSegment(id, seg)
+	dl.setCurrentSegment(seg)
+	dl.events.emit(SegmentCreated{Name: name, ID: id, SequenceID: seqID})
 
 	return nil
 }
 
+// setCurrentSegment makes the segment the one appended to, the tail cache moves to the segment.
+func (dl *datalog) setCurrentSegment(seg *segment) {
+	if dl.curSeg != nil {
+		dl.curSeg.tail = nil
+	}
+	dl.curSeg = seg
+	seg.current = dl.opts.Clock.Now()
+	if dl.tail != nil {
+		dl.tail.reset(seg.size)
+		seg.tail = dl.tail
+	}
+}
+
 func (dl *datalog) removeSegment(seg *segment) error {
-	dl.segments[seg.id] = nil
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+
+	dl.setSegment(seg.id, nil)
 
 	if err := seg.Close(); err != nil {
 		return err
 	}
 
 	// Remove segment meta from FS.
-	metaName := seg.name + segmentExt
-	if err := dl.opts.FileSystem.Remove(metaName); err != nil && !os.IsNotExist(err) {
+	metaName := seg.name + metaExt
+	if err := dl.opts.FileSystem.Remove(metaName); err != nil && !errors.Is(err, os.ErrNotExist) {
 		return err
 	}
 
@@ -159,6 +308,7 @@ func (dl *datalog) removeSegment(seg *segment) error {
 	if err := dl.opts.FileSystem.Remove(seg.name); err != nil {
 		return err
 	}
+	dl.events.emit(SegmentDeleted{Name: seg.name})
 
 	return nil
 }
@@ -173,10 +323,38 @@ func (dl *datalog) removeSegment(seg *segment) error {
 //	return keyValue[:sl.keySize], keyValue[sl.keySize:], nil
 //}
 
+// readKey returns the key stored at the slot.
+// The returned slice may point to memory-mapped data, the caller must hold the read lock while using it.
 func (dl *datalog) readKey(sl slot) ([]byte, error) {
+	seg := dl.segments[sl.segmentID]
+	if sl.keySize == largeKeyMarker && seg.largeKeys() {
+		return seg.readLargeKey(sl.offset)
+	}
+	if seg.cipher != nil {
+		off := int64(sl.offset)
+		data, err := seg.slice(off, off+2+int64(sl.keySize)+encryptionTagSize)
+		if err != nil {
+			return nil, err
+		}
+		return seg.cipher.decrypt(data, sl.offset, 2)
+	}
 	off := int64(sl.offset) + 2
+	return seg.slice(off, off+int64(sl.keySize))
+}
+
+// keyEqual returns whether the key stored at the slot is equal to the key.
+func (dl *datalog) keyEqual(sl slot, key []byte) (bool, error) {
+	dl.mu.RLock()
+	defer dl.mu.RUnlock()
 	seg := dl.segments[sl.segmentID]
-	return seg.Slice(off, off+int64(sl.keySize))
+	if sl.keySize == largeKeyMarker && seg.largeKeys() {
+		return seg.largeKeyEqual(sl.offset, key)
+	}
+	slKey, err := dl.readKey(sl)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(key, slKey), nil
 }
 
 // trackDel updates segment's metadata for deleted or overwritten items.
@@ -186,46 +364,151 @@ func (dl *datalog) readKey(sl slot) ([]byte, error) {
 //	meta.DeletedBytes += encodedRecordSize(sl.kvSize())
 //}
 
-//func (dl *datalog) del(key []byte) error {
-//	rec := encodeDeleteRecord(key)
-//	_, _, err := dl.writeRecord(rec, recordTypeDelete)
-//	if err != nil {
-//		return err
-//	}
-//	// Compaction removes delete records, increment DeletedBytes.
-//	dl.curSeg.meta.DeletedBytes += uint32(len(rec))
-//	return nil
-//}
+func (dl *datalog) del(key []byte) error {
+	_, _, err := dl.writeRecord(encodeDeleteRecord(key, dl.opts.Checksum), 0, recordTypeDelete, true)
+	return err
+}
 
-func (dl *datalog) writeRecord(data []byte) (uint16, uint32, error) {
-	if dl.curSeg.meta.Full || dl.curSeg.size+int64(len(data)) > int64(dl.opts.maxSegmentSize) {
-		// Current segment is full, create a new one.
+// rotationDue returns whether the current segment has to be replaced before appending a record of the size.
+// Segments are rotated on reaching Options.SegmentTargetSize or Options.SegmentMaxAge
+// once they hold Options.SegmentMinRecords records, the maximum segment size is never exceeded.
+// Encrypted segments are limited to 4 GiB, see recordCipher.recordNonce.
+func (dl *datalog) rotationDue(size int) bool {
+	seg := dl.curSeg
+	if seg.meta.Full || seg.size+int64(size) > dl.opts.maxSegmentSize {
+		return true
+	}
+	if seg.cipher != nil && seg.size+int64(size) > math.MaxUint32 {
+		return true
+	}
+	if seg.meta.PutRecords == 0 || seg.meta.PutRecords < dl.opts.SegmentMinRecords {
+		return false
+	}
+	if dl.opts.SegmentTargetSize > 0 && seg.size >= dl.opts.SegmentTargetSize {
+		return true
+	}
+	return dl.opts.SegmentMaxAge > 0 && dl.opts.Clock.Now().Sub(seg.current) >= dl.opts.SegmentMaxAge
+}
+
+// writeRecord appends the encoded record followed by the flags, if the segment stores them, to the current segment.
+// Large-key records can only be written to segments created with support for them.
+func (dl *datalog) writeRecord(data []byte, flags uint16, rtype recordType, largeKey bool) (uint16, uint64, error) {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	size := len(data)
+	if dl.aead != nil {
+		size += encryptionTagSize
+	}
+	if dl.opts.RecordFlags {
+		size += recordFlagsSize
+	}
+	if err := dl.diskSpace.reserve(size); err != nil {
+		return 0, 0, err
+	}
+	if dl.rotationDue(size) || (largeKey && !dl.curSeg.largeKeys()) || dl.curSeg.checksum != dl.opts.Checksum ||
+		dl.curSeg.encrypted() != (dl.aead != nil) || (dl.curSeg.recordFlagsSize() != 0) != dl.opts.RecordFlags {
+		// Current segment is full or it can't store the record, sync it and create a new one.
+		// Only the current segment is synced afterwards, unsynced records would otherwise be left behind.
 		dl.curSeg.meta.Full = true
+		if err := dl.curSeg.writeStats(); err != nil {
+			return 0, 0, err
+		}
+		if err := dl.curSeg.Sync(); err != nil {
+			return 0, 0, err
+		}
+		dl.metrics.FsyncCount.Add(1)
+		dl.metrics.SegmentRotations.Add(1)
+		dl.metrics.RotatedSegmentBytes.Add(dl.curSeg.size)
+		dl.events.emit(SegmentSealed{Name: dl.curSeg.name, Size: dl.curSeg.size})
 		if err := dl.swapSegment(); err != nil {
 			return 0, 0, err
 		}
 	}
+	if dl.tail != nil {
+		// Drop records synced since the last write.
+		if id, size := dl.loadSynced(); id == dl.curSeg.id {
+			dl.tail.trim(size)
+		}
+	}
+	if dl.curSeg.cipher != nil {
+		data = dl.curSeg.cipher.encryptRecord(data, uint64(dl.curSeg.size), dl.curSeg.largeKeys(), dl.curSeg.checksum)
+	}
+	if dl.curSeg.recordFlagsSize() != 0 {
+		data = appendRecordFlags(data, flags)
+	}
 	off, err := dl.curSeg.append(data)
 	if err != nil {
 		return 0, 0, err
 	}
-	dl.curSeg.meta.PutRecords++
-	return dl.curSeg.id, uint32(off), nil
+	if dl.tail != nil {
+		dl.tail.append(off, data)
+	}
+	if rtype == recordTypeDelete {
+		dl.curSeg.meta.DeleteRecords++
+	} else {
+		dl.curSeg.meta.PutRecords++
+	}
+	atomic.AddUint64(&dl.numWrites, 1)
+	atomic.AddUint64(&dl.numBytes, uint64(len(data)))
+	return dl.curSeg.id, uint64(off), nil
+}
+
+// loadSynced returns the ID and the size of the current segment at the time of the last sync.
+// The size takes the lower 48 bits of dl.synced, see maxSegmentSizeLimit.
+func (dl *datalog) loadSynced() (uint16, int64) {
+	synced := atomic.LoadUint64(&dl.synced)
+	return uint16(synced >> 48), int64(synced & maxSegmentSizeLimit)
 }
 
-func (dl *datalog) put(key []byte) (uint16, uint32, error) {
-	return dl.writeRecord(encodePutRecord(key))
+// put writes a put record of the key with the flags, which are dropped by segments without record flags.
+func (dl *datalog) put(key []byte, flags uint16) (uint16, uint64, error) {
+	return dl.writeRecord(encodeRecord(key, dl.opts.Checksum), flags, recordTypePut, isLargeKey(key))
 }
 
 func (dl *datalog) sync() error {
-	return dl.curSeg.Sync()
+	dl.mu.RLock()
+	defer dl.mu.RUnlock()
+	// Appends require the write lock, no records are written while syncing.
+	written := atomic.LoadUint64(&dl.numWrites)
+	writtenBytes := atomic.LoadUint64(&dl.numBytes)
+	size := dl.curSeg.size
+	if err := dl.curSeg.Sync(); err != nil {
+		return err
+	}
+	dl.metrics.FsyncCount.Add(1)
+	dl.events.emit(SyncCompleted{Segment: dl.curSeg.name, Size: size})
+	dl.watermark.advance(written)
+	atomic.StoreUint64(&dl.synced, uint64(dl.curSeg.id)<<48|uint64(size))
+	for {
+		synced := atomic.LoadUint64(&dl.syncedBytes)
+		if writtenBytes <= synced || atomic.CompareAndSwapUint64(&dl.syncedBytes, synced, writtenBytes) {
+			return nil
+		}
+	}
+}
+
+// unsyncedBytes returns the number of bytes written since the last sync.
+func (dl *datalog) unsyncedBytes() uint64 {
+	return atomic.LoadUint64(&dl.numBytes) - atomic.LoadUint64(&dl.syncedBytes)
 }
 
 func (dl *datalog) close() error {
-	for _, seg := range dl.segments {
+	// The final sync resolves the deferred writes, see PutDeferred.
+	if dl.opts.SyncPolicy == SyncNever {
+		dl.watermark.advance(atomic.LoadUint64(&dl.numWrites))
+	} else if err := dl.sync(); err != nil {
+		return err
+	}
+	dl.watermark.close()
+	for _, seg := range dl.usedSegments() {
 		if seg == nil {
 			continue
 		}
+		if !dl.opts.ReadOnly && !seg.archived() {
+			if err := seg.writeStats(); err != nil {
+				return err
+			}
+		}
 		if err := seg.Close(); err != nil {
 			return err
 		}
@@ -237,11 +520,48 @@ func (dl *datalog) close() error {
 	return nil
 }
 
+// closeFiles closes segment files without writing segment meta.
+func (dl *datalog) closeFiles() {
+	for _, seg := range dl.usedSegments() {
+		if seg != nil {
+			_ = seg.Close()
+		}
+	}
+}
+
+// newSegmentRangeIterator returns an iterator over the records of the segment from offset start to offset end.
+// It reads the file at offsets holding the datalog lock: the shared file offset used by the other iterators
+// isn't moved, and records are appended to the current segment concurrently.
+func (dl *datalog) newSegmentRangeIterator(f *segment, start uint64, end uint64) *segmentIterator {
+	r := io.NewSectionReader(lockedReaderAt{mu: &dl.mu, r: f.File}, int64(start), int64(end-start))
+	return &segmentIterator{
+		f:      f,
+		offset: start,
+		r:      bufio.NewReader(r),
+		buf:    make([]byte, 2),
+	}
+}
+
+// lockedReaderAt reads holding the read lock.
+type lockedReaderAt struct {
+	mu *sync.RWMutex
+	r  io.ReaderAt
+}
+
+func (lr lockedReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	lr.mu.RLock()
+	defer lr.mu.RUnlock()
+	return lr.r.ReadAt(p, off)
+}
+
 // segmentsBySequenceID returns segments ordered from oldest to newest.
 func (dl *datalog) segmentsBySequenceID() []*segment {
+	dl.mu.RLock()
+	defer dl.mu.RUnlock()
+
 	var segments []*segment
 
-	for _, seg := range dl.segments {
+	for _, seg := range dl.usedSegments() {
 		if seg == nil {
 			continue
 		}
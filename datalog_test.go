@@ -1,7 +1,14 @@
 package pogreb
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
 	"testing"
+	"time"
 
 	"github.com/domaincrawler/pogreb/internal/assert"
 )
@@ -18,7 +25,7 @@ func TestDatalog(t *testing.T) {
 	db, err := createTestDB(nil)
 	assert.Nil(t, err)
 
-	_, _, err = db.datalog.put([]byte{'1'})
+	_, _, err = db.datalog.put([]byte{'1'}, 0)
 	assert.Nil(t, err)
 	assert.Equal(t, &segmentMeta{PutRecords: 1}, db.datalog.segments[0].meta)
 	assert.Nil(t, db.datalog.segments[1])
@@ -28,7 +35,7 @@ func TestDatalog(t *testing.T) {
 
 	// Writing to a full file swaps it.
 	db.datalog.segments[0].meta.Full = true
-	_, _, err = db.datalog.put([]byte{'1'})
+	_, _, err = db.datalog.put([]byte{'1'}, 0)
 	assert.Nil(t, err)
 	assert.Equal(t, &segmentMeta{PutRecords: 1, Full: true}, db.datalog.segments[0].meta)
 	assert.Equal(t, &segmentMeta{PutRecords: 1}, db.datalog.segments[1].meta)
@@ -36,10 +43,200 @@ func TestDatalog(t *testing.T) {
 	sm = db.datalog.segmentsBySequenceID()
 	assert.Equal(t, []*segment{db.datalog.segments[0], db.datalog.segments[1]}, sm)
 
-	_, _, err = db.datalog.put([]byte{'1'})
+	_, _, err = db.datalog.put([]byte{'1'}, 0)
 	assert.Nil(t, err)
 	assert.Equal(t, &segmentMeta{PutRecords: 1, Full: true}, db.datalog.segments[0].meta)
 	assert.Equal(t, &segmentMeta{PutRecords: 2}, db.datalog.segments[1].meta)
 
 	assert.Nil(t, db.Close())
 }
+
+func TestRemoveSegment(t *testing.T) {
+	db, err := createTestDB(nil)
+	assert.Nil(t, err)
+	_, _, err = db.datalog.put([]byte{'1'}, 0)
+	assert.Nil(t, err)
+	db.datalog.segments[0].meta.Full = true
+	_, _, err = db.datalog.put([]byte{'2'}, 0)
+	assert.Nil(t, err)
+	// Close writes the segment metas.
+	assert.Nil(t, db.Close())
+	db, err = Open(testDBName, &Options{FileSystem: testFS})
+	assert.Nil(t, err)
+	defer db.Close()
+
+	seg := db.datalog.segments[0]
+	_, err = db.opts.FileSystem.Stat(seg.name + metaExt)
+	assert.Nil(t, err)
+	assert.Nil(t, db.datalog.removeSegment(seg))
+	_, err = db.opts.FileSystem.Stat(seg.name)
+	assert.Equal(t, true, os.IsNotExist(err))
+	_, err = db.opts.FileSystem.Stat(seg.name + metaExt)
+	assert.Equal(t, true, os.IsNotExist(err))
+}
+
+func TestDatalogChecksum(t *testing.T) {
+	opts := &Options{Checksum: ChecksumCastagnoli}
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte{1}))
+	assert.Equal(t, ChecksumCastagnoli, db.datalog.curSeg.checksum)
+	assert.Nil(t, db.Close())
+
+	// New records are written to a new segment using the new algorithm.
+	db, err = Open(testDBName, &Options{FileSystem: testFS})
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte{2}))
+	assert.Equal(t, 2, countSegments(t, db))
+	segments := db.datalog.segmentsBySequenceID()
+	assert.Equal(t, ChecksumCastagnoli, segments[0].checksum)
+	assert.Equal(t, ChecksumIEEE, segments[1].checksum)
+	assert.Nil(t, db.Close())
+
+	db, err = Open(testDBName, &Options{FileSystem: testFS, Checksum: ChecksumXXH3})
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte{3}))
+	assert.Equal(t, ChecksumXXH3, db.datalog.curSeg.checksum)
+
+	// Records of every segment are verified with their own algorithm.
+	simulateCrash(t, db)
+	db, err = Open(testDBName, &Options{FileSystem: testFS})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(3), db.Count())
+	report, err := db.Verify(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, true, report.OK())
+	assert.Equal(t, 3, report.Records)
+	assert.Nil(t, db.Close())
+}
+
+func TestDatalogSegmentIDs(t *testing.T) {
+	opts := &Options{maxSegmentSize: int64(headerSize + 2*encodedRecordSize(1))}
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, db.Put([]byte{byte(i)}))
+	}
+	assert.Equal(t, int64(3), db.Metrics().Segments.Value())
+	assert.Equal(t, int64(maxSegments-3), db.Metrics().FreeSegmentIDs.Value())
+
+	// IDs of removed segments are reused.
+	first := db.datalog.segmentsBySequenceID()[0]
+	assert.Nil(t, db.datalog.removeSegment(first))
+	assert.Equal(t, int64(2), db.Metrics().Segments.Value())
+	assert.Equal(t, int64(maxSegments-2), db.Metrics().FreeSegmentIDs.Value())
+	assert.Nil(t, db.Put([]byte{5}))
+	assert.Nil(t, db.Put([]byte{6}))
+	assert.Equal(t, first.id, db.datalog.curSeg.id)
+
+	// Writes fail with ErrFull once all IDs are taken.
+	var taken []uint16
+	for id, seg := range db.datalog.segments {
+		if seg == nil {
+			db.datalog.segments[id] = &segment{meta: &segmentMeta{Full: true}}
+			taken = append(taken, uint16(id))
+		}
+	}
+	assert.Nil(t, db.Put([]byte{7}))
+	err = db.Put([]byte{8})
+	assert.Equal(t, true, errors.Is(err, ErrFull))
+	for _, id := range taken {
+		db.datalog.segments[id] = nil
+	}
+	assert.Nil(t, db.Close())
+}
+
+func TestSegmentRotation(t *testing.T) {
+	putRecords := func(db *DB) []uint32 {
+		var counts []uint32
+		for _, meta := range db.datalog.segmentMetas() {
+			counts = append(counts, meta.PutRecords)
+		}
+		return counts
+	}
+	// Each record is 46 bytes long, the target is reached by the fifth record.
+	key := func(i int) []byte {
+		return []byte(fmt.Sprintf("%040d", i))
+	}
+
+	db, err := createTestDB(&Options{SegmentTargetSize: headerSize + 200})
+	assert.Nil(t, err)
+	for i := 0; i < 12; i++ {
+		assert.Nil(t, db.Put(key(i)))
+	}
+	assert.Equal(t, []uint32{5, 5, 2}, putRecords(db))
+	assert.Equal(t, int64(2), db.Metrics().SegmentRotations.Value())
+	assert.Equal(t, int64(2*(headerSize+5*46)), db.Metrics().RotatedSegmentBytes.Value())
+	assert.Nil(t, db.Close())
+
+	db, err = createTestDB(&Options{SegmentTargetSize: headerSize + 200, SegmentMinRecords: 8})
+	assert.Nil(t, err)
+	for i := 0; i < 12; i++ {
+		assert.Nil(t, db.Put(key(i)))
+	}
+	assert.Equal(t, []uint32{8, 4}, putRecords(db))
+	assert.Nil(t, db.Close())
+
+	clock := newManualClock()
+	db, err = createTestDB(&Options{SegmentMaxAge: time.Minute, Clock: clock})
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put(key(0)))
+	clock.advance(59 * time.Second)
+	assert.Nil(t, db.Put(key(1)))
+	clock.advance(time.Second)
+	assert.Nil(t, db.Put(key(2)))
+	assert.Equal(t, []uint32{2, 1}, putRecords(db))
+	// The age of the new segment starts when it becomes the current segment.
+	clock.advance(59 * time.Second)
+	assert.Nil(t, db.Put(key(3)))
+	assert.Equal(t, []uint32{2, 2}, putRecords(db))
+	assert.Nil(t, db.Close())
+}
+
+func TestSegmentSizeLimit(t *testing.T) {
+	assert.Equal(t, int64(math.MaxUint32), (&Options{}).copyWithDefaults(testDBName).maxSegmentSize)
+	opts := &Options{SegmentTargetSize: 8 << 30}
+	assert.Equal(t, int64(maxSegmentSizeLimit), opts.copyWithDefaults(testDBName).maxSegmentSize)
+
+	// Segments beyond 4 GiB are written only without encryption.
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+	size := db.datalog.curSeg.size
+	db.datalog.curSeg.size = math.MaxUint32 - 10
+	assert.Equal(t, false, db.datalog.rotationDue(100))
+	db.datalog.curSeg.size = size
+	assert.Nil(t, db.Close())
+
+	opts = &Options{SegmentTargetSize: 8 << 30, EncryptionKey: bytes.Repeat([]byte{7}, 16)}
+	db, err = createTestDB(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte{1}))
+	size = db.datalog.curSeg.size
+	db.datalog.curSeg.size = math.MaxUint32 - 10
+	assert.Equal(t, true, db.datalog.rotationDue(100))
+	db.datalog.curSeg.size = size
+	assert.Nil(t, db.Close())
+}
+
+func TestDirectIO(t *testing.T) {
+	opts := &Options{DirectIO: true, maxSegmentSize: 64 << 10}
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 10000; i++ {
+		assert.Nil(t, db.Put([]byte(fmt.Sprintf("key%d", i))))
+	}
+	for i := 0; i < 10000; i += 99 {
+		has, err := db.Has([]byte(fmt.Sprintf("key%d", i)))
+		assert.Nil(t, err)
+		assert.Equal(t, true, has)
+	}
+	assert.Nil(t, db.Close())
+
+	db, err = Open(testDBName, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(10000), db.Count())
+	has, err := db.Has([]byte("key9999"))
+	assert.Nil(t, err)
+	assert.Equal(t, true, has)
+	assert.Nil(t, db.Close())
+}
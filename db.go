@@ -6,6 +6,7 @@ import (
 	"math"
 	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/domaincrawler/pogreb/fs"
@@ -18,7 +19,7 @@ const (
 	MaxKeyLength = math.MaxUint16
 
 	// MaxKeys is the maximum numbers of keys in the DB.
-	MaxKeys = math.MaxUint32
+	MaxKeys = math.MaxUint64
 
 	metaExt    = ".pmt"
 	dbMetaName = "db" + metaExt
@@ -27,39 +28,131 @@ const (
 // DB represents the key-only storage.
 // All DB methods are safe for concurrent use by multiple goroutines.
 type DB struct {
-	mu                sync.RWMutex // Allows multiple database readers or a single writer.
-	opts              *Options
-	index             *index
-	datalog           *datalog
-	lock              fs.LockFile // Prevents opening multiple instances of the same database.
-	hashSeed          uint32
-	metrics           *Metrics
-	syncWrites        bool
-	cancelBgWorker    context.CancelFunc
-	closeWg           sync.WaitGroup
-	compactionRunning int32 // Prevents running compactions concurrently.
+	*dbState
+	handle *sharedHandle // Handle of a shared DB, nil if the DB isn't shared.
+}
+
+// dbState is the state of an open database, shared by the handles of a shared DB.
+type dbState struct {
+	mu                   sync.RWMutex // Held for reading by regular operations, held for writing by Close and compaction.
+	opts                 *Options
+	path                 string   // Path the DB was opened with.
+	openOpts             *Options // Options the DB was opened with, before applying the defaults.
+	index                *shardedIndex
+	datalog              *datalog
+	lock                 fs.LockFile // Prevents opening multiple instances of the same database.
+	epoch                uint64      // Fencing token incremented by every writable open.
+	hashSeed             uint32      // Random hash seed stored in the DB meta.
+	hashDomain           []byte      // Hash domain the DB was created with.
+	domainSeed           uint32      // Hash seed derived from the hash seed, the hash domain and the encryption key.
+	encryptedHashSeed    bool        // Whether domainSeed is derived from the encryption key.
+	domainMismatch       bool        // Options.HashDomain doesn't match the domain of the DB.
+	fingerprintSize      int         // Size of the stored key fingerprints, 0 if the DB stores keys.
+	format               uint32      // Format revision recorded in the DB meta.
+	minVersion           string      // Library version required by the recorded format revision.
+	metrics              *Metrics
+	syncWrites           bool
+	groupCommit          *groupCommitter // Batches syncs of concurrent writers, nil if group commit is disabled.
+	asyncWriter          asyncWriter     // Writes keys queued by PutAsync.
+	cancelBgWorker       context.CancelFunc
+	closeWg              sync.WaitGroup
+	budgetMu             sync.Mutex // Serializes inserts checked against a key budget by HasOrPutWithin.
+	compactionRunning    int32      // Prevents running compactions concurrently.
+	compactionThroughput int64      // Bytes per second processed by the last compaction. Accessed atomically.
+	checkpointGen        uint64     // Generation of the last index checkpoint, 0 if there is none. Guarded by mu.
+	sharedKey            sharedKey  // Key in the shared databases registry, zero if the DB isn't shared.
+	refs                 int        // Number of handles of a shared DB. Guarded by the sharedDBs lock.
+	invalidation         invalidationBus
+	countWatches         countWatches
+	openReport           OpenReport
+	events               *eventDispatcher
+	accounting           *accounting       // Nil unless Options.Accounting is set.
+	secondary            *secondaryIndexes // Nil unless Options.SecondaryIndexes is set.
+	writeChain           writeChain
+	manifestMu           sync.Mutex   // Serializes manifest publications.
+	manifest             manifestMeta // Last manifest published by the DB or, for a replica, applied by it.
+	canaryMu             sync.Mutex   // Serializes canary checks and guards health and canarySeq.
+	canarySeq            uint64
+	snapshot             *fs.MemSnapshot // File system of a single-file DB, saved by Sync and Close. Nil otherwise.
+	health               Health
+	replication          *replication
+	subscriptions        subscriptions
 }
 
 type dbMeta struct {
-	HashSeed uint32
+	HashSeed   uint32
+	HashDomain []byte
+	Version    string // Version of the library which last wrote the meta.
+	Format     uint32 // Revision of the database format.
+	MinVersion string // First library version supporting the format revision.
+
+	FingerprintSize int // Size of the key fingerprints stored instead of the keys, 0 if keys are stored as is.
+
+	EncryptedHashSeed bool // The index hashes keys with a seed derived from the encryption key.
+
+	Usage map[string]Usage // Usage of the labels, see Options.Accounting.
 }
 
 // Open opens or creates a new DB.
 // The DB must be closed after use, by calling Close method.
 func Open(path string, opts *Options) (*DB, error) {
-	opts = opts.copyWithDefaults(path)
+	if opts != nil && opts.Shared {
+		return openShared(path, opts)
+	}
+	return open(path, opts)
+}
 
-	if err := os.MkdirAll(path, 0755); err != nil {
+// open opens the DB, falling back to a read-only open if it's enabled and the file system is read-only.
+func open(path string, opts *Options) (*DB, error) {
+	if opts != nil && opts.SingleFile {
+		return openSingleFile(path, opts)
+	}
+	db, err := openDB(path, opts)
+	var rofsErr *ReadOnlyFSError
+	if err == nil || opts == nil || !opts.ReadOnlyFallback || opts.ReadOnly || !errors.As(err, &rofsErr) {
+		return db, err
+	}
+	roOpts := *opts
+	roOpts.ReadOnly = true
+	db, roErr := openDB(path, &roOpts)
+	if roErr != nil {
+		return nil, roErr
+	}
+	db.opts.Logger.Logf(LogWarn, "%v, opened read-only", err)
+	db.openReport.ReadOnlyFallback = true
+	return db, nil
+}
+
+func openDB(path string, srcOpts *Options) (*DB, error) {
+	opts := srcOpts.copyWithDefaults(path)
+	if opts.RecordFlags && opts.EncryptionKey != nil {
+		return nil, errRecordFlagsEncryption
+	}
+	if err := validateIndexOptions(opts); err != nil {
 		return nil, err
 	}
+	report := OpenReport{}
+	start := time.Now()
+	phaseStart := start
+	phase := func(d *time.Duration) {
+		now := time.Now()
+		*d += now.Sub(phaseStart)
+		phaseStart = now
+	}
+
+	if !opts.ReadOnly {
+		if err := os.MkdirAll(path, 0755); err != nil {
+			return nil, readOnlyFSError(path, err)
+		}
+	}
 
 	// Try to acquire a file lock.
 	lock, acquiredExistingLock, err := createLockFile(opts)
 	if err != nil {
-		if err == os.ErrExist {
-			err = errLocked
+		if errors.Is(err, os.ErrExist) {
+			err = ErrLocked
 		}
-		return nil, errors.Wrap(err, "creating lock file")
+		return nil, errors.Wrap(readOnlyFSError(path, err), "creating lock file")
 	}
 	clean := lock.Unlock
 	defer func() {
@@ -67,54 +160,200 @@ func Open(path string, opts *Options) (*DB, error) {
 			_ = clean()
 		}
 	}()
+	phase(&report.LockDuration)
+
+	format, err := checkCompatibility(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var epoch uint64
+	if opts.ReadOnly {
+		epoch, err = readEpoch(opts.FileSystem)
+	} else {
+		epoch, err = bumpEpoch(opts.FileSystem)
+	}
+	if err != nil {
+		return nil, errors.Wrap(readOnlyFSError(path, err), "updating epoch")
+	}
+
+	replication, err := openReplication(opts.FileSystem)
+	if err != nil {
+		return nil, err
+	}
 
-	if acquiredExistingLock {
+	if opts.repair != nil {
+		// Salvage records and rebuild the index from scratch.
+		if err := repairSegments(opts, opts.repair); err != nil {
+			return nil, err
+		}
+	}
+
+	truncated, err := completeTruncation(opts)
+	if err != nil {
+		return nil, err
+	}
+	recovery := acquiredExistingLock || opts.repair != nil || truncated
+	report.Recovered = recovery
+	if recovery {
 		// Lock file already existed, but the process managed to acquire it.
-		// It means the database wasn't closed properly.
+		// It means the database wasn't closed properly or it's being repaired.
 		// Start recovery process.
-		if err := backupNonsegmentFiles(opts.FileSystem); err != nil {
+		if err := backupNonsegmentFiles(opts); err != nil {
 			return nil, err
 		}
 	}
+	phase(&report.RecoveryDuration)
+
+	var cp *checkpointMeta
+	if acquiredExistingLock && opts.repair == nil {
+		cp, err = restoreCheckpoint(opts)
+		if err != nil {
+			return nil, errors.Wrap(err, "restoring checkpoint")
+		}
+		report.CheckpointRestored = cp != nil
+	}
+
+	// The index published with the manifest is at least as new as the manifest.
+	manifest, err := readManifest(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// An index written before the DB was encrypted is rehashed with a seed derived from the key.
+	rehash, err := needsEncryptedHashSeed(opts)
+	if err != nil {
+		return nil, err
+	}
 
-	index, err := openIndex(opts)
+	// A checkpoint restored after an unclean shutdown is verified, a clean shutdown leaves intact buckets.
+	opts.verifyIndex = cp != nil
+	index, err := openShardedIndex(opts)
+	if err == nil && rehash && index.count() != 0 {
+		index.closeFiles()
+		err = errIndexSeed
+	}
+	if err == nil && !recovery && !opts.ReadOnly {
+		if err = index.checkWatermark(opts.FileSystem); err != nil {
+			index.closeFiles()
+		}
+	}
+	if err == nil {
+		// Only the index meta written by Close matches the segments, the meta copied by Clone doesn't.
+		index.setWatermark(datalogWatermark{})
+	}
+	mismatch := errors.Is(err, ErrIndexMismatch) && opts.RebuildIndexOnMismatch
+	if err != nil && (indexNeedsRebuild(err) || mismatch) && !opts.ReadOnly {
+		// The datalog is the source of truth, a corrupted or outdated index is rebuilt from it.
+		opts.Logger.Logf(LogWarn, "rebuilding index: %v", err)
+		if err := backupNonsegmentFiles(opts); err != nil {
+			return nil, err
+		}
+		cp = nil
+		recovery = true
+		report.Recovered = true
+		report.CheckpointRestored = false
+		report.IndexRebuilt = true
+		index, err = openShardedIndex(opts)
+	}
 	if err != nil {
 		return nil, errors.Wrap(err, "opening index")
 	}
+	clean = func() error {
+		index.closeFiles()
+		return lock.Unlock()
+	}
+	phase(&report.IndexDuration)
 
-	datalog, err := openDatalog(opts)
+	metrics := newMetrics(opts)
+	events := newEventDispatcher(opts.EventHandler)
+	defer func() {
+		if clean != nil {
+			events.close()
+		}
+	}()
+	datalog, err := openDatalog(opts, metrics, events)
 	if err != nil {
 		return nil, errors.Wrap(err, "opening datalog")
 	}
+	clean = func() error {
+		datalog.closeFiles()
+		index.closeFiles()
+		return lock.Unlock()
+	}
+	report.fillSegmentStats(datalog)
+	phase(&report.DatalogDuration)
 
-	db := &DB{
+	acct := newAccounting(opts)
+	db := &DB{dbState: &dbState{
 		opts:       opts,
+		path:       path,
+		openOpts:   srcOpts,
 		index:      index,
 		datalog:    datalog,
 		lock:       lock,
-		metrics:    &Metrics{},
-		syncWrites: opts.BackgroundSyncInterval == -1,
+		epoch:      epoch,
+		metrics:    metrics,
+		events:     events,
+		accounting: acct,
+		secondary:  newSecondaryIndexes(opts),
+		writeChain: newWriteChain(opts, acct, datalog),
+		manifest:   manifest,
+		format:     format.Format,
+		minVersion: format.MinVersion,
+		syncWrites: opts.SyncPolicy == SyncAlways,
+
+		replication: replication,
+	}}
+	if db.syncWrites && opts.GroupCommitLatency > 0 {
+		db.groupCommit = newGroupCommitter(opts.GroupCommitLatency)
 	}
 	if index.count() == 0 {
 		// The index is empty, make a new hash seed.
-		seed, err := hash.RandSeed()
+		seed, err := newHashSeed(opts)
 		if err != nil {
 			return nil, err
 		}
 		db.hashSeed = seed
+		db.hashDomain = opts.HashDomain
+		db.encryptedHashSeed = opts.EncryptionKey != nil
 	} else {
 		if err := db.readMeta(); err != nil {
 			return nil, errors.Wrap(err, "reading db meta")
 		}
+		if opts.HashSeed != 0 && opts.HashSeed != db.hashSeed {
+			return nil, errHashSeedMismatch
+		}
+		if db.encryptedHashSeed && opts.EncryptionKey == nil {
+			return nil, errEncryptionKeyRequired
+		}
+	}
+	db.initHashDomain()
+	if err := db.initFingerprints(index.count() != 0); err != nil {
+		return nil, err
 	}
 
-	if acquiredExistingLock {
-		if err := db.recover(); err != nil {
+	if recovery {
+		if err := db.recover(cp, &report); err != nil {
 			return nil, errors.Wrap(err, "recovering")
 		}
 	}
+	phase(&report.RecoveryDuration)
+	metrics.RecoveryDuration.Set(report.RecoveryDuration.Seconds())
+	db.prefetch(&report)
+	if err := db.openSecondaryIndexes(recovery); err != nil {
+		return nil, err
+	}
+	report.fillIndexStats(index)
+	report.TotalDuration = time.Since(start)
+	db.openReport = report
+	if opts.LogOpenReport {
+		opts.Logger.Logf(LogInfo, "opened %s: %s", path, &report)
+	}
 
-	if db.opts.BackgroundSyncInterval > 0 || db.opts.BackgroundCompactionInterval > 0 {
+	if !db.opts.ReadOnly && (db.opts.SyncPolicy == SyncInterval || db.opts.IndexCheckpointInterval > 0 ||
+		db.opts.IndexFlushInterval > 0 || db.opts.BackgroundCompactionInterval > 0 || db.opts.CanaryInterval > 0) ||
+		db.opts.ReadOnly && db.opts.ManifestPollInterval > 0 {
 		db.startBackgroundWorker()
 	}
 
@@ -128,11 +367,28 @@ func cloneBytes(src []byte) []byte {
 	return dst
 }
 
-func (db *DB) writeMeta() error {
-	m := dbMeta{
-		HashSeed: db.hashSeed,
+func (db *DB) meta() dbMeta {
+	// A database written in a newer format keeps it, opening it with an older version doesn't convert it.
+	format, min := db.format, db.minVersion
+	if format < dbFormat {
+		format, min = dbFormat, minVersion(dbFormat)
+	}
+	return dbMeta{
+		HashSeed:   db.hashSeed,
+		HashDomain: db.hashDomain,
+		Version:    Version,
+		Format:     format,
+		MinVersion: min,
+
+		FingerprintSize:   db.fingerprintSize,
+		EncryptedHashSeed: db.encryptedHashSeed,
+
+		Usage: db.accounting.snapshot(),
 	}
-	return writeGobFile(db.opts.FileSystem, dbMetaName, m)
+}
+
+func (db *DB) writeMeta() error {
+	return writeGobFile(db.opts.FileSystem, dbMetaName, db.meta())
 }
 
 func (db *DB) readMeta() error {
@@ -141,19 +397,48 @@ func (db *DB) readMeta() error {
 		return err
 	}
 	db.hashSeed = m.HashSeed
+	db.hashDomain = m.HashDomain
+	db.fingerprintSize = m.FingerprintSize
+	db.encryptedHashSeed = m.EncryptedHashSeed
+	db.accounting.load(m.Usage)
 	return nil
 }
 
-func (db *DB) hash(data []byte) uint32 {
-	return hash.Sum32WithSeed(data, db.hashSeed)
+// newHashSeed returns the seed from the options or a random seed if it's not set.
+func newHashSeed(opts *Options) (uint32, error) {
+	if opts.HashSeed != 0 {
+		return opts.HashSeed, nil
+	}
+	return hash.RandSeed()
 }
 
-// newNullableTicker is a wrapper around time.NewTicker that allows creating a nil ticker.
+// initHashDomain derives the hash seed from the hash domain of the DB and the encryption key,
+// and checks whether the domain matches the domain the DB is opened with.
+// The DB keeps hashing with its own domain, so that compaction and recovery remain correct.
+func (db *DB) initHashDomain() {
+	db.domainSeed = db.hashSeed
+	if len(db.hashDomain) > 0 {
+		db.domainSeed = hash.Sum32WithSeed(db.hashDomain, db.hashSeed)
+	}
+	if db.encryptedHashSeed {
+		db.domainSeed = encryptedHashSeed(db.opts.EncryptionKey, db.domainSeed)
+	}
+	if !bytes.Equal(db.hashDomain, db.opts.HashDomain) {
+		db.domainMismatch = true
+		db.opts.Logger.Logf(LogWarn, "hash domain %q doesn't match the database hash domain, lookups will miss", db.opts.HashDomain)
+	}
+}
+
+func (db *DB) hash(data []byte) uint64 {
+	return db.index.hashAlgorithm().sum(data, db.domainSeed)
+}
+
+// newNullableTicker is a wrapper around Clock.Ticker that allows creating a nil ticker.
 // A nil ticker never ticks.
-func newNullableTicker(d time.Duration) (<-chan time.Time, func()) {
+func (db *DB) newNullableTicker(d time.Duration) (<-chan time.Time, func()) {
 	if d > 0 {
-		t := time.NewTicker(d)
-		return t.C, t.Stop
+		t := db.opts.Clock.Ticker(d)
+		return t.C(), t.Stop
 	}
 	return nil, func() {}
 }
@@ -166,25 +451,61 @@ func (db *DB) startBackgroundWorker() {
 	go func() {
 		defer db.closeWg.Done()
 
-		syncC, syncStop := newNullableTicker(db.opts.BackgroundSyncInterval)
+		var syncInterval time.Duration
+		if db.opts.SyncPolicy == SyncInterval {
+			syncInterval = db.opts.BackgroundSyncInterval
+		}
+		syncC, syncStop := db.newNullableTicker(syncInterval)
 		defer syncStop()
 
-		compactC, compactStop := newNullableTicker(db.opts.BackgroundCompactionInterval)
+		checkpointC, checkpointStop := db.newNullableTicker(db.opts.IndexCheckpointInterval)
+		defer checkpointStop()
+
+		compactC, compactStop := db.newNullableTicker(db.opts.BackgroundCompactionInterval)
 		defer compactStop()
 
+		flushC, flushStop := db.newNullableTicker(db.opts.IndexFlushInterval)
+		defer flushStop()
+
+		canaryC, canaryStop := db.newNullableTicker(db.opts.CanaryInterval)
+		defer canaryStop()
+
+		var pollInterval time.Duration
+		if db.opts.ReadOnly {
+			pollInterval = db.opts.ManifestPollInterval
+		}
+		pollC, pollStop := db.newNullableTicker(pollInterval)
+		defer pollStop()
+
 		for {
 			select {
 			case <-ctx.Done():
 				return
 			case <-syncC:
 				if err := db.Sync(); err != nil {
-					logger.Printf("error synchronizing database: %v", err)
+					db.opts.Logger.Logf(LogError, "error synchronizing database: %v", err)
+				}
+			case <-checkpointC:
+				if err := db.checkpoint(); err != nil {
+					db.opts.Logger.Logf(LogError, "error checkpointing index: %v", err)
+				}
+			case <-flushC:
+				if err := db.flushIndex(); err != nil {
+					db.opts.Logger.Logf(LogError, "error flushing index: %v", err)
 				}
 			case <-compactC:
 				if cr, err := db.Compact(); err != nil {
-					logger.Printf("error compacting database: %v", err)
+					db.opts.Logger.Logf(LogError, "error compacting database: %v", err)
 				} else if cr.CompactedSegments > 0 {
-					logger.Printf("compacted database: %+v", cr)
+					db.opts.Logger.Logf(LogInfo, "compacted database: %+v", cr)
+				}
+			case <-canaryC:
+				if err := db.CheckCanary(); err != nil {
+					db.opts.Logger.Logf(LogError, "canary check failed: %v", err)
+				}
+			case <-pollC:
+				if err := db.refresh(); err != nil {
+					db.opts.Logger.Logf(LogError, "error refreshing replica: %v", err)
 				}
 			}
 		}
@@ -192,141 +513,351 @@ func (db *DB) startBackgroundWorker() {
 }
 
 // Has returns true if the DB contains the given key.
+// The key is passed through the transform stage of the write chain first, as it is when it's written.
+// It always returns false when the DB is opened with a different Options.HashDomain.
 func (db *DB) Has(key []byte) (bool, error) {
+	key, ok := db.lookupKey(key)
+	if !ok {
+		return false, nil
+	}
+	return db.hasStored(key)
+}
+
+// lookupKey returns the stored key to look up: the key passed through the transform stage of the write chain,
+// or its fingerprint. Unlike checkKey it doesn't validate the key, it returns false if a transform rejects it.
+func (db *DB) lookupKey(key []byte) ([]byte, bool) {
+	key, ok := db.writeChain.transform(key)
+	if !ok {
+		return nil, false
+	}
+	return db.fingerprint(key), true
+}
+
+// hasStored returns true if the DB contains the key as it's stored, see fingerprint.
+func (db *DB) hasStored(key []byte) (bool, error) {
+	if db.metrics.HasLatency != nil {
+		defer db.metrics.HasLatency.since(time.Now())
+	}
+	if db.domainMismatch {
+		return false, nil
+	}
 	h := db.hash(key)
-	found := false
 	db.mu.RLock()
 	defer db.mu.RUnlock()
-	err := db.index.get(h, func(sl slot) (bool, error) {
-		if uint16(len(key)) != sl.keySize {
+	shard := db.index.shard(h)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	return db.has(shard, h, key)
+}
+
+// has returns true if the shard contains the given key. The caller must hold the shard lock.
+func (db *DB) has(shard *indexShard, h uint64, key []byte) (bool, error) {
+	shard.access.record(shard.bucketIndex(h))
+	if !shard.filter.contains(h) {
+		// The filter has no false negatives, no slot has the hash.
+		db.metrics.FilterNegatives.Add(1)
+		db.metrics.Gets.Add(1)
+		db.metrics.Misses.Add(1)
+		return false, nil
+	}
+	found := false
+	err := shard.get(h, func(sl slot) (bool, error) {
+		if slotKeySize(key) != sl.keySize {
+			db.metrics.HashCollisions.Add(1)
 			return false, nil
 		}
-		slKey, err := db.datalog.readKey(sl)
+		match, err := db.datalog.keyEqual(sl, key)
 		if err != nil {
 			return true, err
 		}
-		if bytes.Equal(key, slKey) {
-			found = true
-			return true, nil
+		if !match {
+			db.metrics.HashCollisions.Add(1)
 		}
-		return false, nil
+		found = match
+		return match, nil
 	})
 	if err != nil {
 		return false, err
 	}
+	db.metrics.Gets.Add(1)
+	if found {
+		db.metrics.Hits.Add(1)
+	} else {
+		db.metrics.Misses.Add(1)
+	}
 	return found, nil
 }
 
-func (db *DB) put(sl slot, key []byte) error {
-	return db.index.put(sl, func(cursl slot) (bool, error) {
-		if uint16(len(key)) != cursl.keySize {
+// put inserts the slot into the shard. The caller must hold the shard write lock.
+func (db *DB) put(shard *indexShard, sl slot, key []byte) error {
+	err := db.index.put(shard, sl, func(cursl slot) (bool, error) {
+		if slotKeySize(key) != cursl.keySize {
 			return false, nil
 		}
-		slKey, err := db.datalog.readKey(cursl)
+		match, err := db.datalog.keyEqual(cursl, key)
 		if err != nil {
 			return true, err
 		}
-		if bytes.Equal(key, slKey) {
-			return true, nil
-		}
-		return false, nil
+		return match, nil
 	})
+	if err != nil {
+		return err
+	}
+	db.countWatches.increased(db.index.count())
+	return nil
+}
+
+// write appends the key to the datalog and inserts it into the shard.
+// The caller must hold the shard write lock and call commit after releasing it.
+func (db *DB) write(shard *indexShard, h uint64, key []byte) error {
+	return db.writeFlags(shard, h, key, 0)
 }
 
+// writeFlags writes the key with the flags, see write.
+func (db *DB) writeFlags(shard *indexShard, h uint64, key []byte, flags uint16) error {
+	segID, offset, err := db.datalog.put(key, flags)
+	if err != nil {
+		return err
+	}
+
+	sl := slot{
+		hash:      h,
+		segmentID: segID,
+		keySize:   slotKeySize(key),
+		offset:    offset,
+	}
+
+	if err := db.put(shard, sl, key); err != nil {
+		return err
+	}
+	db.invalidation.invalidate(key)
+	db.secondary.add(key)
+	return nil
+}
+
+// commit makes previous writes durable if the DB is configured to sync every write
+// or enough data was written since the last sync.
+// It must be called after releasing the shard lock, so that concurrent writers can be committed as a group.
+func (db *DB) commit() error {
+	if db.syncWrites {
+		if db.groupCommit != nil {
+			return db.groupCommit.wait(db.datalog, atomic.LoadUint64(&db.datalog.numWrites))
+		}
+		return db.sync()
+	}
+	if db.opts.SyncEveryNBytes > 0 && db.datalog.unsyncedBytes() >= uint64(db.opts.SyncEveryNBytes) {
+		return db.sync()
+	}
+	return nil
+}
+
+// checkWritable returns an error if the DB can't be modified.
+func (db *DB) checkWritable() error {
+	if db.opts.ReadOnly {
+		return errReadOnly
+	}
+	if db.domainMismatch {
+		return errHashDomainMismatch
+	}
+	return nil
+}
+
+// checkKey passes the key through the write chain and returns the key to write, or its fingerprint,
+// or an error if the key can't be written to the DB.
+func (db *DB) checkKey(key []byte) ([]byte, error) {
+	return db.checkKeyIn(nil, key)
+}
+
+// checkKeyIn is checkKey for a key of the namespace with the prefix, see Namespace.
+func (db *DB) checkKeyIn(prefix []byte, key []byte) ([]byte, error) {
+	if err := db.checkWritable(); err != nil {
+		return nil, err
+	}
+	key, err := db.writeChain.before(key)
+	if err != nil {
+		return nil, err
+	}
+	return namespacedKey(prefix, db.fingerprint(key)), nil
+}
+
+// HasOrPut returns true if the DB contains the given key.
+// Otherwise it inserts the key and returns false.
 func (db *DB) HasOrPut(key []byte) (bool, error) {
-	if len(key) > MaxKeyLength {
-		return false, errKeyTooLarge
+	key, err := db.checkKey(key)
+	if err != nil {
+		return false, err
 	}
-	found := false
+	return db.hasOrPutChecked(context.Background(), key)
+}
+
+// hasOrPutChecked is HasOrPut for a key returned by checkKey, the write chain sees the context.
+func (db *DB) hasOrPutChecked(ctx context.Context, key []byte) (bool, error) {
+	found, err := db.hasOrPut(key)
+	if err != nil || found {
+		return found, err
+	}
+	db.writeChain.afterContext(ctx, key)
+	return false, nil
+}
+
+func (db *DB) hasOrPut(key []byte) (bool, error) {
 	h := db.hash(key)
-	db.mu.Lock()
-	defer db.mu.Unlock()
-	err := db.index.get(h, func(sl slot) (bool, error) {
-		if uint16(len(key)) != sl.keySize {
-			return false, nil
-		}
-		slKey, err := db.datalog.readKey(sl)
-		if err != nil {
-			return true, err
-		}
-		if bytes.Equal(key, slKey) {
-			found = true
-			return true, nil
-		}
-		return false, nil
-	})
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	shard := db.index.shard(h)
+	shard.mu.Lock()
+	found, err := db.has(shard, h, key)
+	if err == nil && !found {
+		err = db.write(shard, h, key)
+	}
+	shard.mu.Unlock()
 	if err != nil {
 		return false, err
 	}
-	if !found {
-		segID, offset, err := db.datalog.put(key)
-		if err != nil {
-			return false, err
-		}
-		sl := slot{
-			hash:      h,
-			segmentID: segID,
-			keySize:   uint16(len(key)),
-			offset:    offset,
-		}
+	if found {
+		return true, nil
+	}
+	return false, db.commit()
+}
 
-		if err := db.put(sl, key); err != nil {
-			return false, err
-		}
+// HasOrPutWithin returns true if the DB contains the given key.
+// Otherwise it inserts the key and returns false, unless the DB already contains maxKeys keys,
+// in which case the key isn't inserted and ErrFull is returned.
+// The membership check and the budget check are performed atomically.
+func (db *DB) HasOrPutWithin(key []byte, maxKeys uint64) (bool, error) {
+	key, err := db.checkKey(key)
+	if err != nil {
+		return false, err
+	}
+	found, err := db.hasOrPutWithin(key, maxKeys)
+	if err != nil || found {
+		return found, err
+	}
+	db.writeChain.after(key)
+	return false, nil
+}
 
-		if db.syncWrites {
-			return found, db.sync()
+func (db *DB) hasOrPutWithin(key []byte, maxKeys uint64) (bool, error) {
+	h := db.hash(key)
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	shard := db.index.shard(h)
+	shard.mu.Lock()
+	found, err := db.has(shard, h, key)
+	if err == nil && !found {
+		db.budgetMu.Lock()
+		if db.index.count() >= maxKeys {
+			err = ErrFull
+		} else {
+			err = db.write(shard, h, key)
 		}
+		db.budgetMu.Unlock()
 	}
-	return found, nil
+	shard.mu.Unlock()
+	if err != nil {
+		return false, err
+	}
+	if found {
+		return true, nil
+	}
+	return false, db.commit()
 }
 
 // Put sets the value for the given key. It updates the value for the existing key.
 func (db *DB) Put(key []byte) error {
-	if len(key) > MaxKeyLength {
-		return errKeyTooLarge
-	}
-	h := db.hash(key)
-	db.metrics.Puts.Add(1)
-	db.mu.Lock()
-	defer db.mu.Unlock()
-
-	segID, offset, err := db.datalog.put(key)
+	key, err := db.checkKey(key)
 	if err != nil {
 		return err
 	}
+	return db.putChecked(context.Background(), key)
+}
 
-	sl := slot{
-		hash:      h,
-		segmentID: segID,
-		keySize:   uint16(len(key)),
-		offset:    offset,
+// putChecked writes a key returned by checkKey, the write chain sees the context.
+func (db *DB) putChecked(ctx context.Context, key []byte) error {
+	if db.metrics.PutLatency != nil {
+		defer db.metrics.PutLatency.since(time.Now())
 	}
-
-	if err := db.put(sl, key); err != nil {
+	db.metrics.Puts.Add(1)
+	if err := db.writeCommit(key); err != nil {
 		return err
 	}
+	db.writeChain.afterContext(ctx, key)
+	return nil
+}
 
-	if db.syncWrites {
-		return db.sync()
+// writeCommit writes and commits a single key.
+func (db *DB) writeCommit(key []byte) error {
+	h := db.hash(key)
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	shard := db.index.shard(h)
+	shard.mu.Lock()
+	err := db.write(shard, h, key)
+	shard.mu.Unlock()
+	if err != nil {
+		return err
 	}
-	return nil
+	return db.commit()
 }
 
 // Close closes the DB.
+// A shared DB is closed when all handles opened by the process are closed, closing a handle again does nothing.
 func (db *DB) Close() error {
+	if db.handle != nil && !db.releaseShared() {
+		return nil
+	}
+	db.asyncWriter.close()
+	db.subscriptions.close()
 	if db.cancelBgWorker != nil {
 		db.cancelBgWorker()
 	}
 	db.closeWg.Wait()
 	db.mu.Lock()
-	defer db.mu.Unlock()
+	err := db.closeFiles()
+	db.mu.Unlock()
+	if db.handle != nil {
+		db.closedShared()
+	}
+	// Deliver the events emitted while closing after the locks are released.
+	db.events.close()
+	return err
+}
+
+// closeFiles shuts down the index and the datalog cleanly and releases the lock file.
+// The caller must hold the DB write lock.
+func (db *DB) closeFiles() error {
+	if db.opts.ReadOnly {
+		db.datalog.closeFiles()
+		db.index.closeFiles()
+		return nil
+	}
+	if err := db.publishManifest(); err != nil {
+		return err
+	}
+	// A clean shutdown doesn't need the checkpoint.
+	if err := db.removeCheckpoint(); err != nil {
+		return err
+	}
 	if err := db.writeMeta(); err != nil {
 		return err
 	}
+	if err := db.writeHotBuckets(); err != nil {
+		return err
+	}
+	if err := db.writeSecondaryIndexes(); err != nil {
+		return err
+	}
+	if err := db.writeReplication(); err != nil {
+		return errors.Wrap(err, "writing replication state")
+	}
 	if err := db.datalog.close(); err != nil {
 		return err
 	}
+	w, err := readDatalogWatermark(db.opts.FileSystem)
+	if err != nil {
+		return err
+	}
+	db.index.setWatermark(w)
 	if err := db.index.close(); err != nil {
 		return err
 	}
@@ -337,25 +868,70 @@ func (db *DB) Close() error {
 }
 
 func (db *DB) sync() error {
+	if db.opts.SyncPolicy == SyncNever || db.opts.ReadOnly {
+		return nil
+	}
 	return db.datalog.sync()
 }
 
 // Items returns a new ItemIterator.
 func (db *DB) Items() *ItemIterator {
-	return &ItemIterator{db: db}
+	return &ItemIterator{db: db, generation: atomic.LoadUint64(&db.datalog.generation)}
+}
+
+// ItemsWithQuota returns a new ItemIterator limited by the quota.
+func (db *DB) ItemsWithQuota(quota IterationQuota) *ItemIterator {
+	usage := quota.Usage
+	if usage == nil {
+		usage = &QuotaUsage{}
+	}
+	return &ItemIterator{
+		db:         db,
+		generation: atomic.LoadUint64(&db.datalog.generation),
+		quota:      &quota,
+		deadline:   db.opts.Clock.Now().Add(quota.MaxDuration),
+		usage:      usage,
+	}
 }
 
 // Sync commits the contents of the database to the backing FileSystem.
+// Index pages modified since the previous Sync are committed as well when the index files
+// are memory-mapped for writing.
 func (db *DB) Sync() error {
-	db.mu.Lock()
-	defer db.mu.Unlock()
-	return db.sync()
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if err := db.sync(); err != nil {
+		return err
+	}
+	if db.opts.SyncPolicy == SyncNever && !db.opts.ReadOnly {
+		// Nothing is synced, deferred writes are resolved once they're written to the files.
+		db.datalog.watermark.advance(atomic.LoadUint64(&db.datalog.numWrites))
+	}
+	if db.opts.SyncPolicy != SyncNever && !db.opts.ReadOnly {
+		if err := db.index.sync(); err != nil {
+			return err
+		}
+	}
+	if err := db.publishManifest(); err != nil {
+		return err
+	}
+	return db.saveSingleFile()
+}
+
+// HashSeed returns the hash seed of the DB.
+// Passing it in Options.HashSeed to other databases makes them place keys the same way.
+// The keys of an encrypted DB are placed the same way only by databases with the same encryption key.
+func (db *DB) HashSeed() uint32 {
+	return db.hashSeed
+}
+
+// RecordFlags returns true if the DB stores flags of the keys, see Options.RecordFlags.
+func (db *DB) RecordFlags() bool {
+	return db.opts.RecordFlags
 }
 
 // Count returns the number of keys in the DB.
-func (db *DB) Count() uint32 {
-	db.mu.RLock()
-	defer db.mu.RUnlock()
+func (db *DB) Count() uint64 {
 	return db.index.count()
 }
 
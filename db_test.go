@@ -1,18 +1,28 @@
 package pogreb
 
 import (
+	"context"
 	"encoding/binary"
 	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
+	"math"
 	"os"
 	"path/filepath"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
+	"testing/fstest"
+	"time"
 
 	"github.com/domaincrawler/pogreb/fs"
 	"github.com/domaincrawler/pogreb/internal/assert"
+	"github.com/domaincrawler/pogreb/internal/errors"
+	"github.com/domaincrawler/pogreb/internal/hash"
 )
 
 const (
@@ -69,21 +79,109 @@ func align512(n uint32) uint32 {
 }
 
 func TestBucketSize(t *testing.T) {
-	serializedSize := uint32(binary.Size(bucket{}))
-	if bucketSize != align512(serializedSize) {
-		t.Fatal("wrong bucketSize value", bucketSize)
+	testCases := []struct {
+		slotSize int
+		numSlots int
+	}{
+		{slotSize, slotsPerBucket},
+		{wideSlotSize, wideSlotsPerBucket},
 	}
-	if bucketSize-serializedSize > 32 {
-		t.Fatal("bucket is wasting too much space", bucketSize, serializedSize)
+	for _, tc := range testCases {
+		serializedSize := uint32(tc.slotSize*tc.numSlots + binary.Size(int64(0)) + binary.Size(uint32(0)))
+		if bucketSize != align512(serializedSize) {
+			t.Fatal("wrong bucketSize value", bucketSize)
+		}
+		if bucketSize-serializedSize > 32 {
+			t.Fatal("bucket is wasting too much space", bucketSize, serializedSize)
+		}
+	}
+	assert.Equal(t, wideSlotSize, binary.Size(slot{}))
+}
+
+func TestBucketOffsets(t *testing.T) {
+	// Slots hold 64-bit offsets of records in segments larger than 4 GiB.
+	for _, wideHash := range []bool{false, true} {
+		b := bucket{next: 1 << 33}
+		for i := 0; i < numSlots(wideHash); i++ {
+			b.slots[i] = slot{hash: uint64(i), segmentID: uint16(i), keySize: 1, offset: 1<<40 + uint64(i)}
+		}
+		got := bucket{}
+		got.unmarshal(b.marshal(wideHash, ChecksumIEEE), wideHash)
+		assert.Equal(t, b, got)
 	}
 }
 
+func TestIndexOlderFormat(t *testing.T) {
+	opts := &Options{FileSystem: testFS}
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, db.Put(deleteTestKey(i)))
+	}
+	assert.Nil(t, db.Close())
+
+	// Index files of version 4 hold 32-bit offsets, the index is rebuilt from the datalog.
+	for _, name := range []string{indexMainName, indexOverflowName} {
+		f, err := testFS.OpenFile(filepath.Join(testDBName, name), os.O_RDWR, 0)
+		assert.Nil(t, err)
+		var v [4]byte
+		binary.LittleEndian.PutUint32(v[:], 4)
+		_, err = f.WriteAt(v[:], 8)
+		assert.Nil(t, err)
+		assert.Nil(t, f.Close())
+	}
+	db, err = Open(testDBName, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, true, db.OpenReport().IndexRebuilt)
+	assert.Equal(t, uint32(formatVersion), db.index.shards[0].main.formatVersion)
+	assert.Equal(t, uint64(10), db.Count())
+	for i := 0; i < 10; i++ {
+		assertHas(t, db, deleteTestKey(i), true)
+	}
+	assert.Nil(t, db.Close())
+}
+
 func TestHeaderSize(t *testing.T) {
 	if headerSize != align512(uint32(binary.Size(header{}))) {
 		t.Fatal("wrong headerSize value", headerSize)
 	}
 }
 
+func TestHeaderFormatVersion(t *testing.T) {
+	h := newHeader()
+	data, err := h.MarshalBinary()
+	assert.Nil(t, err)
+	assert.Nil(t, (&header{}).UnmarshalBinary(data))
+
+	// Files written by older versions are readable.
+	binary.LittleEndian.PutUint32(data[8:12], 2)
+	assert.Nil(t, (&header{}).UnmarshalBinary(data))
+
+	binary.LittleEndian.PutUint32(data[8:12], formatVersion+1)
+	assert.NotNil(t, (&header{}).UnmarshalBinary(data))
+}
+
+func TestCountAboveUint32(t *testing.T) {
+	db, err := createTestDB(nil)
+	assert.Nil(t, err)
+	// Pretend the index already holds more keys than fit in 32 bits.
+	const numKeys = math.MaxUint32 + 10
+	db.index.shards[0].numKeys = numKeys
+	db.index.numKeys = numKeys
+	assert.Nil(t, db.Put([]byte("foo")))
+	assert.Equal(t, uint64(numKeys+1), db.Count())
+	assert.Nil(t, db.Close())
+
+	m := indexMeta{}
+	assert.Nil(t, readGobFile(testFS, filepath.Join(testDBName, indexMetaName), &m))
+	assert.Equal(t, uint64(numKeys+1), m.NumKeys)
+	// The buckets of a cleanly closed index aren't counted, the count is read from the meta.
+	db, err = Open(testDBName, &Options{FileSystem: testFS})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(numKeys+1), db.Count())
+	assert.Nil(t, db.Close())
+}
+
 func createTestDB(opts *Options) (*DB, error) {
 	if opts == nil {
 		opts = &Options{FileSystem: testFS}
@@ -123,18 +221,18 @@ func TestEmpty(t *testing.T) {
 //	assert.Nil(t, err)
 //	var i byte
 //	var n uint8 = 255
-//	assert.Equal(t, uint32(0), db.Count())
+//	assert.Equal(t, uint64(0), db.Count())
 //	for i = 0; i < n; i++ {
 //		if has, err := db.Has([]byte{i}); has || err != nil {
 //			t.Fatal(has, err)
 //		}
 //	}
 //	assert.Nil(t, db.Delete([]byte{128}))
-//	assert.Equal(t, uint32(0), db.Count())
+//	assert.Equal(t, uint64(0), db.Count())
 //	for i = 0; i < n; i++ {
 //		assert.Nil(t, db.Put([]byte{i}, []byte{i}))
 //	}
-//	assert.Equal(t, uint32(255), db.Count())
+//	assert.Equal(t, uint64(255), db.Count())
 //	assert.Equal(t, int64(n), db.Metrics().Puts.Value())
 //	assert.Nil(t, db.Sync())
 //
@@ -145,16 +243,16 @@ func TestEmpty(t *testing.T) {
 //	}
 //
 //	assert.Nil(t, db.Delete([]byte{128}))
-//	assert.Equal(t, uint32(254), db.Count())
+//	assert.Equal(t, uint64(254), db.Count())
 //	if has, err := db.Has([]byte{128}); has || err != nil {
 //		t.Fatal(has, err)
 //	}
 //	assert.Nil(t, db.Put([]byte{128}, []byte{128}))
-//	assert.Equal(t, uint32(255), db.Count())
+//	assert.Equal(t, uint64(255), db.Count())
 //
 //	verifyKeysAndClose := func(valueOffset uint8) {
 //		t.Helper()
-//		assert.Equal(t, uint32(255), db.Count())
+//		assert.Equal(t, uint64(255), db.Count())
 //		for i = 0; i < n; i++ {
 //			if has, err := db.Has([]byte{i}); !has || err != nil {
 //				t.Fatal(has, err)
@@ -210,7 +308,7 @@ func TestEmpty(t *testing.T) {
 //			t.Fatal(has, err)
 //		}
 //	}
-//	assert.Equal(t, uint32(0), db.Count())
+//	assert.Equal(t, uint64(0), db.Count())
 //	assert.Nil(t, db.Close())
 //}
 
@@ -262,20 +360,167 @@ func TestLock(t *testing.T) {
 //}
 
 func TestCorruptedIndex(t *testing.T) {
+	testCases := []struct {
+		name   string
+		file   string
+		offset int64
+	}{
+		{"meta", indexMetaName, 0},
+		{"main file", indexMainName, 0},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := &Options{FileSystem: testFS}
+			db, err := createTestDB(opts)
+			assert.Nil(t, err)
+			for i := 0; i < 10; i++ {
+				assert.Nil(t, db.Put(deleteTestKey(i)))
+			}
+			assert.Nil(t, db.Close())
+
+			f, err := testFS.OpenFile(filepath.Join(testDBName, tc.file), os.O_RDWR, 0)
+			assert.Nil(t, err)
+			_, err = f.WriteAt([]byte("corrupted"), tc.offset)
+			assert.Nil(t, err)
+			assert.Nil(t, f.Close())
+
+			// A read-only DB can't rebuild the index.
+			db, err = Open(testDBName, &Options{FileSystem: testFS, ReadOnly: true})
+			assert.Nil(t, db)
+			assert.NotNil(t, err)
+
+			db, err = Open(testDBName, opts)
+			assert.Nil(t, err)
+			assert.Equal(t, true, db.OpenReport().IndexRebuilt)
+			assert.Equal(t, uint64(10), db.Count())
+			for i := 0; i < 10; i++ {
+				assertHas(t, db, deleteTestKey(i), true)
+			}
+			assert.Nil(t, db.Close())
+		})
+	}
+}
+
+func TestCorruptedIndexBucket(t *testing.T) {
 	opts := &Options{FileSystem: testFS}
 	db, err := createTestDB(opts)
 	assert.Nil(t, err)
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, db.Put(deleteTestKey(i)))
+	}
 	assert.Nil(t, db.Close())
 
-	f, err := testFS.OpenFile(filepath.Join(testDBName, indexMetaName), os.O_RDWR, 0)
+	f, err := testFS.OpenFile(filepath.Join(testDBName, indexMainName), os.O_RDWR, 0)
 	assert.Nil(t, err)
-	_, err = f.Write([]byte("corrupted"))
+	_, err = f.WriteAt([]byte("corrupted"), headerSize+100)
 	assert.Nil(t, err)
 	assert.Nil(t, f.Close())
 
+	// The buckets of a cleanly closed index aren't read by Open, the checksum is checked by the lookup.
 	db, err = Open(testDBName, opts)
-	assert.Nil(t, db)
-	assert.NotNil(t, err)
+	assert.Nil(t, err)
+	assert.Equal(t, false, db.OpenReport().IndexRebuilt)
+	_, err = db.Has(deleteTestKey(0))
+	var corruption *CorruptionError
+	assert.Equal(t, true, errors.As(err, &corruption))
+	assert.Nil(t, db.Close())
+}
+
+func TestRebuildIndexOnMismatch(t *testing.T) {
+	opts := &Options{FileSystem: testFS, maxSegmentSize: 1024}
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(deleteTestKey(i)))
+	}
+	newest := db.datalog.curSeg
+	assert.Equal(t, true, newest.size > headerSize)
+	assert.Nil(t, db.Close())
+	// The segments are restored from an older backup.
+	assert.Nil(t, testFS.Remove(filepath.Join(testDBName, newest.name)))
+	assert.Nil(t, testFS.Remove(filepath.Join(testDBName, newest.name+metaExt)))
+
+	_, err = Open(testDBName, opts)
+	assert.Equal(t, true, errors.Is(err, ErrIndexMismatch))
+	assert.Equal(t, CodeMismatch, ErrorCodeOf(err))
+
+	var done, total int64
+	opts.RebuildIndexOnMismatch = true
+	opts.RecoveryProgress = func(d, t int64) {
+		done, total = d, t
+	}
+	db, err = Open(testDBName, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, true, db.OpenReport().IndexRebuilt)
+	assert.Equal(t, true, total > 0)
+	assert.Equal(t, total, done)
+	n := db.Count()
+	assert.Equal(t, true, n > 0 && n < 100)
+	for i := 0; i < int(n); i++ {
+		assertHas(t, db, deleteTestKey(i), true)
+	}
+	assert.Nil(t, db.Close())
+
+	// The rebuilt index matches the segments.
+	db, err = Open(testDBName, &Options{FileSystem: testFS, maxSegmentSize: 1024})
+	assert.Nil(t, err)
+	assert.Equal(t, false, db.OpenReport().IndexRebuilt)
+	assert.Equal(t, n, db.Count())
+	assert.Nil(t, db.Close())
+}
+
+func TestIndexLayoutOptions(t *testing.T) {
+	_, err := createTestDB(&Options{IndexLoadFactor: 1.5})
+	assert.Equal(t, errInvalidLoadFactor, err)
+	_, err = createTestDB(&Options{IndexBucketSlots: slotsPerBucket + 1})
+	assert.Equal(t, errInvalidBucketSlots, err)
+	_, err = createTestDB(&Options{IndexBucketSlots: wideSlotsPerBucket + 1, HashAlgorithm: HashXXH64})
+	assert.Equal(t, errInvalidBucketSlots, err)
+
+	opts := &Options{FileSystem: testFS, IndexBucketSlots: 4, IndexLoadFactor: 0.5}
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(deleteTestKey(i)))
+	}
+	idx := db.index.shards[0].index
+	assert.Equal(t, true, idx.numBuckets >= 100/2)
+	for i := uint32(0); i < idx.numBuckets; i++ {
+		b := bucketHandle{file: idx.main, offset: bucketOffset(i)}
+		assert.Nil(t, b.read())
+		assert.Equal(t, uint64(0), b.slots[4].offset)
+	}
+	assert.Nil(t, db.Close())
+
+	// Existing databases keep their layout.
+	db, err = Open(testDBName, &Options{FileSystem: testFS})
+	assert.Nil(t, err)
+	assert.Equal(t, 4, db.index.shards[0].slotsPerBucket())
+	assert.Nil(t, db.Close())
+
+	// The index is migrated to a different layout.
+	db, err = Open(testDBName, &Options{FileSystem: testFS, IndexBucketSlots: 8})
+	assert.Nil(t, err)
+	assert.Equal(t, true, db.OpenReport().IndexRebuilt)
+	assert.Equal(t, 8, db.index.shards[0].slotsPerBucket())
+	assert.Equal(t, uint64(100), db.Count())
+	for i := 0; i < 100; i++ {
+		assertHas(t, db, deleteTestKey(i), true)
+	}
+	assert.Nil(t, db.Close())
+}
+
+func TestCorruptedBucket(t *testing.T) {
+	db, err := createTestDB(nil)
+	assert.Nil(t, err)
+	defer db.Close()
+	assert.Nil(t, db.Put(deleteTestKey(0)))
+	_, err = db.index.shards[0].main.WriteAt([]byte{0xff}, headerSize)
+	assert.Nil(t, err)
+	_, err = db.Has(deleteTestKey(0))
+	var corruption *CorruptionError
+	assert.Equal(t, true, errors.As(err, &corruption))
+	assert.Equal(t, int64(headerSize), corruption.Offset)
 }
 
 func TestFileError(t *testing.T) {
@@ -307,13 +552,13 @@ func TestFileError(t *testing.T) {
 	})
 
 	t.Run("index error", func(t *testing.T) {
-		oldf := db.index.main.File
-		db.index.main.File = errf
+		oldf := db.index.shards[0].main.File
+		db.index.shards[0].main.File = errf
 
 		testDB(t)
 		assert.Equal(t, errfileError, db.index.close())
 
-		db.index.main.File = oldf
+		db.index.shards[0].main.File = oldf
 	})
 
 	errfs := &errfs{}
@@ -395,7 +640,7 @@ func BenchmarkBucket_UnmarshalBinary(b *testing.B) {
 		slots: [slotsPerBucket]slot{},
 	}
 	for i := 0; i < slotsPerBucket; i++ {
-		testBucket.slots[i].hash = uint32(i)
+		testBucket.slots[i].hash = uint64(i)
 		testBucket.slots[i].keySize = uint16(i + 1)
 	}
 	data, _ := testBucket.MarshalBinary()
@@ -408,3 +653,548 @@ func BenchmarkBucket_UnmarshalBinary(b *testing.B) {
 		}
 	}
 }
+
+type testBlocklist map[string]bool
+
+func (bl testBlocklist) Contains(key []byte) bool {
+	return bl[string(key)]
+}
+
+func TestBlocklist(t *testing.T) {
+	opts := &Options{
+		Blocklist: testBlocklist{"blocked": true},
+	}
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+
+	assert.Equal(t, ErrBlocked, db.Put([]byte("blocked")))
+	has, err := db.HasOrPut([]byte("blocked"))
+	assert.Equal(t, false, has)
+	assert.Equal(t, ErrBlocked, err)
+	has, err = db.Has([]byte("blocked"))
+	assert.Nil(t, err)
+	assert.Equal(t, false, has)
+
+	assert.Nil(t, db.Put([]byte("allowed")))
+	has, err = db.HasOrPut([]byte("allowed"))
+	assert.Nil(t, err)
+	assert.Equal(t, true, has)
+	assert.Equal(t, uint64(1), db.Count())
+
+	assert.Nil(t, db.Close())
+}
+
+func TestUseMmap(t *testing.T) {
+	opts := &Options{UseMmap: true}
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+	for i := byte(0); i < 128; i++ {
+		assert.Nil(t, db.Put([]byte{i}))
+	}
+	assert.Nil(t, db.Close())
+
+	db, err = Open(testDBName, opts)
+	assert.Nil(t, err)
+	for i := byte(0); i < 128; i++ {
+		has, err := db.Has([]byte{i})
+		assert.Nil(t, err)
+		assert.Equal(t, true, has)
+	}
+	assert.Nil(t, db.Close())
+}
+
+func TestShared(t *testing.T) {
+	opts := &Options{FileSystem: testFS, Shared: true}
+	db1, err := createTestDB(opts)
+	assert.Nil(t, err)
+	db2, err := Open(testDBName, opts)
+	assert.Nil(t, err)
+	if db1.dbState != db2.dbState {
+		t.Fatal("expected the same DB")
+	}
+
+	// Non-shared open fails while the DB is open.
+	db3, err := Open(testDBName, &Options{FileSystem: testFS})
+	assert.Nil(t, db3)
+	assert.NotNil(t, err)
+
+	assert.Nil(t, db1.Put([]byte{1}))
+	assert.Nil(t, db1.Close())
+	// Closing a handle again doesn't release the other handle.
+	assert.Nil(t, db1.Close())
+
+	// The DB is still open for the second handle.
+	has, err := db2.Has([]byte{1})
+	assert.Nil(t, err)
+	assert.Equal(t, true, has)
+	assert.Nil(t, db2.Close())
+
+	db, err := Open(testDBName, &Options{FileSystem: testFS})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), db.Count())
+	assert.Nil(t, db.Close())
+}
+
+func TestSharedFileSystems(t *testing.T) {
+	// Databases with the same path in different file systems aren't shared.
+	db1, err := Open(testDBName, &Options{FileSystem: fs.NewMem(), Shared: true})
+	assert.Nil(t, err)
+	db2, err := Open(testDBName, &Options{FileSystem: fs.NewMem(), Shared: true})
+	assert.Nil(t, err)
+	assert.Nil(t, db1.Put([]byte{1}))
+	assertHas(t, db2, []byte{1}, false)
+	assert.Nil(t, db1.Close())
+	assert.Nil(t, db2.Close())
+}
+
+// sliceFS is a FileSystem of a type that isn't comparable.
+type sliceFS struct {
+	fs.FileSystem
+	_ []byte
+}
+
+func TestSharedFileSystemNotComparable(t *testing.T) {
+	db, err := Open(testDBName, &Options{FileSystem: sliceFS{FileSystem: fs.NewMem()}, Shared: true})
+	assert.Nil(t, db)
+	assert.Equal(t, errSharedFileSystem, err)
+}
+
+func TestSharedCloseEventHandler(t *testing.T) {
+	// The handler receives the events emitted by Close while other shared databases are opened.
+	other := &Options{FileSystem: fs.NewMem(), Shared: true}
+	handled := make(chan error, 1)
+	opts := &Options{FileSystem: fs.NewMem(), Shared: true, EventHandler: func(e Event) {
+		if _, ok := e.(SyncCompleted); !ok {
+			return
+		}
+		db, err := Open(testDBName, other)
+		if err == nil {
+			err = db.Close()
+		}
+		select {
+		case handled <- err:
+		default:
+		}
+	}}
+	db, err := Open(testDBName, opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte{1}))
+	closed := make(chan error, 1)
+	go func() {
+		closed <- db.Close()
+	}()
+	select {
+	case err = <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close is blocked by the event handler")
+	}
+	assert.Nil(t, err)
+	assert.Nil(t, <-handled)
+
+	// The closed database can be opened again.
+	db, err = Open(testDBName, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), db.Count())
+	assert.Nil(t, db.Close())
+}
+
+func TestPutAsync(t *testing.T) {
+	db, err := createTestDB(nil)
+	assert.Nil(t, err)
+
+	const n = 2000
+	wg := sync.WaitGroup{}
+	wg.Add(n)
+	var errCount int32
+	for i := 0; i < n; i++ {
+		key := []byte{byte(i), byte(i >> 8)}
+		db.PutAsync(key, func(err error) {
+			if err != nil {
+				atomic.AddInt32(&errCount, 1)
+			}
+			wg.Done()
+		})
+	}
+	wg.Wait()
+	assert.Equal(t, int32(0), errCount)
+	assert.Equal(t, uint64(n), db.Count())
+
+	wg.Add(1)
+	db.PutAsync(make([]byte, MaxKeyLength+1), func(err error) {
+		assert.Equal(t, ErrKeyTooLarge, err)
+		wg.Done()
+	})
+	wg.Wait()
+
+	// Queued writes are committed on Close.
+	wg.Add(1)
+	db.PutAsync([]byte("last"), func(err error) {
+		if err != nil {
+			t.Error(err)
+		}
+		wg.Done()
+	})
+	assert.Nil(t, db.Close())
+	wg.Wait()
+
+	db.PutAsync([]byte("closed"), func(err error) {
+		assert.Equal(t, errClosed, err)
+	})
+
+	db, err = Open(testDBName, &Options{FileSystem: testFS})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(n+1), db.Count())
+	assert.Nil(t, db.Close())
+}
+
+func TestSyncPolicy(t *testing.T) {
+	testCases := []struct {
+		opts       Options
+		wantPolicy SyncPolicy
+		wantSyncs  int32
+	}{
+		{Options{}, SyncOSDefault, 0},
+		{Options{BackgroundSyncInterval: -1}, SyncAlways, 10},
+		{Options{BackgroundSyncInterval: time.Hour}, SyncInterval, 0},
+		{Options{SyncPolicy: SyncAlways}, SyncAlways, 10},
+		{Options{SyncPolicy: SyncNever, SyncEveryNBytes: 1}, SyncNever, 0},
+		{Options{SyncPolicy: SyncOSDefault, SyncEveryNBytes: 28}, SyncOSDefault, 2},
+	}
+	for _, tc := range testCases {
+		t.Run("", func(t *testing.T) {
+			fsys := &syncCountingFS{FileSystem: testFS}
+			opts := tc.opts
+			opts.FileSystem = fsys
+			db, err := createTestDB(&opts)
+			assert.Nil(t, err)
+			assert.Equal(t, tc.wantPolicy, db.opts.SyncPolicy)
+			atomic.StoreInt32(&fsys.syncs, 0)
+			// Each record is 7 bytes long.
+			for i := 0; i < 10; i++ {
+				assert.Nil(t, db.Put([]byte{byte(i)}))
+			}
+			assert.Equal(t, tc.wantSyncs, atomic.LoadInt32(&fsys.syncs))
+			assert.Nil(t, db.Sync())
+			if tc.wantPolicy == SyncNever {
+				assert.Equal(t, int32(0), atomic.LoadInt32(&fsys.syncs))
+			}
+			assert.Nil(t, db.Close())
+		})
+	}
+
+	opts := (&Options{SyncPolicy: SyncInterval}).copyWithDefaults(testDBName)
+	assert.Equal(t, defaultBackgroundSyncInterval, opts.BackgroundSyncInterval)
+}
+
+func TestWritableMmapIndex(t *testing.T) {
+	db, err := createTestDB(nil)
+	assert.Nil(t, err)
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put([]byte(fmt.Sprintf("key%d", i))))
+	}
+	_, mapped := db.index.shards[0].main.File.(fs.WritableMmapFile)
+	assert.Equal(t, testFS == fs.OSMMap && runtime.GOOS != "windows", mapped)
+	assert.Nil(t, db.Sync())
+	assert.Nil(t, db.Close())
+
+	// Buckets updated in the mapped memory are read back after reopening.
+	db, err = Open(testDBName, &Options{FileSystem: testFS})
+	assert.Nil(t, err)
+	for i := 0; i < 1000; i++ {
+		has, err := db.Has([]byte(fmt.Sprintf("key%d", i)))
+		assert.Nil(t, err)
+		assert.Equal(t, true, has)
+	}
+	assert.Nil(t, db.Close())
+}
+
+func TestHasOrPutWithin(t *testing.T) {
+	db, err := createTestDB(&Options{IndexShards: 4})
+	assert.Nil(t, err)
+
+	found, err := db.HasOrPutWithin([]byte{1}, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, false, found)
+	found, err = db.HasOrPutWithin([]byte{2}, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, false, found)
+
+	// The budget is exhausted, existing keys are still found.
+	found, err = db.HasOrPutWithin([]byte{3}, 2)
+	assert.Equal(t, ErrFull, err)
+	assert.Equal(t, false, found)
+	found, err = db.HasOrPutWithin([]byte{1}, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, true, found)
+	assert.Equal(t, uint64(2), db.Count())
+
+	// Concurrent inserts never exceed the budget.
+	wg := sync.WaitGroup{}
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := db.HasOrPutWithin([]byte{byte(i), 0}, 50); err != nil && err != ErrFull {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, uint64(50), db.Count())
+
+	assert.Nil(t, db.Close())
+}
+
+func TestHashDomain(t *testing.T) {
+	opts := &Options{HashDomain: []byte("crawl-a")}
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte{1}))
+	assert.Equal(t, hash.Sum32WithSeed([]byte("crawl-a"), db.hashSeed), db.domainSeed)
+	assert.Nil(t, db.Close())
+
+	// Opening with the wrong domain.
+	db, err = Open(testDBName, &Options{FileSystem: testFS, HashDomain: []byte("crawl-b")})
+	assert.Nil(t, err)
+	has, err := db.Has([]byte{1})
+	assert.Nil(t, err)
+	assert.Equal(t, false, has)
+	assert.Equal(t, errHashDomainMismatch, db.Put([]byte{2}))
+	assert.Nil(t, db.Close())
+
+	// The domain of the DB isn't changed.
+	db, err = Open(testDBName, opts)
+	assert.Nil(t, err)
+	has, err = db.Has([]byte{1})
+	assert.Nil(t, err)
+	assert.Equal(t, true, has)
+	assert.Nil(t, db.Close())
+}
+
+func TestHashSeed(t *testing.T) {
+	opts := &Options{HashSeed: 42}
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(42), db.HashSeed())
+	assert.Nil(t, db.Put([]byte{1}))
+	h := db.hash([]byte{1})
+	assert.Nil(t, db.Close())
+
+	// The seed is persisted, a zero seed keeps the stored one.
+	db, err = Open(testDBName, &Options{FileSystem: testFS})
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(42), db.HashSeed())
+	assert.Equal(t, h, db.hash([]byte{1}))
+	assert.Nil(t, db.Close())
+
+	_, err = Open(testDBName, &Options{FileSystem: testFS, HashSeed: 43})
+	assert.Equal(t, errHashSeedMismatch, err)
+
+	// Databases created with the same seed place keys the same way.
+	db, err = createTestDB(&Options{HashSeed: 42})
+	assert.Nil(t, err)
+	assert.Equal(t, h, db.hash([]byte{1}))
+	assert.Nil(t, db.Close())
+}
+
+func TestReadOnly(t *testing.T) {
+	db, err := createTestDB(nil)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte{1}))
+	assert.Nil(t, db.Close())
+
+	listFiles := func() map[string]int64 {
+		files, err := testFS.ReadDir(testDBName)
+		assert.Nil(t, err)
+		m := make(map[string]int64)
+		for _, f := range files {
+			m[f.Name()] = f.Size()
+		}
+		return m
+	}
+	files := listFiles()
+
+	opts := &Options{FileSystem: testFS, ReadOnly: true, BackgroundSyncInterval: time.Millisecond}
+	db, err = Open(testDBName, opts)
+	assert.Nil(t, err)
+	has, err := db.Has([]byte{1})
+	assert.Nil(t, err)
+	assert.Equal(t, true, has)
+	assert.Equal(t, uint64(1), db.Count())
+	assert.Equal(t, errReadOnly, db.Put([]byte{2}))
+	_, err = db.HasOrPut([]byte{2})
+	assert.Equal(t, errReadOnly, err)
+	_, err = db.Compact()
+	assert.Equal(t, errReadOnly, err)
+	assert.Nil(t, db.Sync())
+	assert.Nil(t, db.Close())
+	assert.Equal(t, files, listFiles())
+
+	// A database which wasn't closed properly can't be opened read-only.
+	db, err = Open(testDBName, &Options{FileSystem: testFS})
+	assert.Nil(t, err)
+	_, err = Open(testDBName, opts)
+	assert.NotNil(t, err)
+	assert.Nil(t, db.Close())
+}
+
+func TestReadOnlyIOFS(t *testing.T) {
+	db, err := createTestDB(nil)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte{1}))
+	assert.Nil(t, db.Close())
+
+	// Copy the database into an io/fs file system.
+	mapFS := fstest.MapFS{}
+	files, err := testFS.ReadDir(testDBName)
+	assert.Nil(t, err)
+	for _, fi := range files {
+		f, err := testFS.OpenFile(filepath.Join(testDBName, fi.Name()), os.O_RDONLY, 0)
+		assert.Nil(t, err)
+		data, err := ioutil.ReadAll(f)
+		assert.Nil(t, err)
+		assert.Nil(t, f.Close())
+		mapFS[testDBName+"/"+fi.Name()] = &fstest.MapFile{Data: data}
+	}
+
+	db, err = Open(testDBName, &Options{FileSystem: fs.FromIOFS(mapFS), ReadOnly: true})
+	assert.Nil(t, err)
+	has, err := db.Has([]byte{1})
+	assert.Nil(t, err)
+	assert.Equal(t, true, has)
+	assert.Nil(t, db.Close())
+}
+
+// erofsFS fails modifications like a file system mounted read-only.
+type erofsFS struct {
+	fs.FileSystem
+}
+
+func (fsys erofsFS) OpenFile(name string, flag int, perm os.FileMode) (fs.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC) != 0 {
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EROFS}
+	}
+	return fsys.FileSystem.OpenFile(name, flag, perm)
+}
+
+func (fsys erofsFS) CreateLockFile(name string, perm os.FileMode) (fs.LockFile, bool, error) {
+	return nil, false, &os.PathError{Op: "open", Path: name, Err: syscall.EROFS}
+}
+
+func TestReadOnlyFallback(t *testing.T) {
+	db, err := createTestDB(nil)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte{1}))
+	assert.Nil(t, db.Close())
+
+	_, err = Open(testDBName, &Options{FileSystem: erofsFS{testFS}})
+	var rofsErr *ReadOnlyFSError
+	assert.Equal(t, true, errors.As(err, &rofsErr))
+	assert.Equal(t, testDBName, rofsErr.Path)
+	assert.Equal(t, true, errors.Is(err, syscall.EROFS))
+	assert.Equal(t, CodeReadOnly, ErrorCodeOf(err))
+
+	db, err = Open(testDBName, &Options{FileSystem: erofsFS{testFS}, ReadOnlyFallback: true})
+	assert.Nil(t, err)
+	assert.Equal(t, true, db.OpenReport().ReadOnlyFallback)
+	has, err := db.Has([]byte{1})
+	assert.Nil(t, err)
+	assert.Equal(t, true, has)
+	assert.Equal(t, errReadOnly, db.Put([]byte{2}))
+	assert.Nil(t, db.Close())
+
+	// Databases on writable file systems aren't affected by the option.
+	db, err = Open(testDBName, &Options{FileSystem: testFS, ReadOnlyFallback: true})
+	assert.Nil(t, err)
+	assert.Equal(t, false, db.OpenReport().ReadOnlyFallback)
+	assert.Nil(t, db.Put([]byte{2}))
+	assert.Nil(t, db.Close())
+}
+
+func TestHashAlgorithm(t *testing.T) {
+	const numKeys = 5000
+	opts := &Options{HashAlgorithm: HashXXH64, IndexShards: 2}
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, HashXXH64, db.index.hashAlgorithm())
+	for i := 0; i < numKeys; i++ {
+		assert.Nil(t, db.Put([]byte(fmt.Sprintf("key%d", i))))
+	}
+	for _, sh := range db.index.shards {
+		if sh.main.flags&headerFlagWideHash == 0 || sh.overflow.flags&headerFlagWideHash == 0 {
+			t.Fatal("index files aren't marked as using 64-bit hashes")
+		}
+	}
+	// Slots store the full 64-bit hash.
+	h := db.hash([]byte("key0"))
+	if h>>32 == 0 {
+		t.Fatalf("expected a 64-bit hash; got %x", h)
+	}
+	assert.Nil(t, db.Close())
+
+	// The algorithm is fixed when the index is created.
+	db, err = Open(testDBName, &Options{FileSystem: testFS})
+	assert.Nil(t, err)
+	assert.Equal(t, HashXXH64, db.index.hashAlgorithm())
+	assert.Equal(t, uint64(numKeys), db.Count())
+	for i := 0; i < numKeys; i++ {
+		has, err := db.Has([]byte(fmt.Sprintf("key%d", i)))
+		assert.Nil(t, err)
+		assert.Equal(t, true, has)
+	}
+	has, err := db.Has([]byte("missing"))
+	assert.Nil(t, err)
+	assert.Equal(t, false, has)
+	report, err := db.Verify(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(report.OrphanedEntries))
+
+	// Recovery rebuilds the index with the algorithm from the options.
+	simulateCrash(t, db)
+	db, err = Open(testDBName, &Options{FileSystem: testFS, HashAlgorithm: HashXXH64})
+	assert.Nil(t, err)
+	assert.Equal(t, HashXXH64, db.index.hashAlgorithm())
+	assert.Equal(t, uint64(numKeys), db.Count())
+	has, err = db.Has([]byte("key42"))
+	assert.Nil(t, err)
+	assert.Equal(t, true, has)
+	assert.Nil(t, db.Close())
+
+	_, err = createTestDB(&Options{HashAlgorithm: 100})
+	assert.NotNil(t, err)
+}
+
+func TestMemSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot")
+	fsys, err := fs.OpenMemSnapshot(path, 0)
+	assert.Nil(t, err)
+	opts := &Options{FileSystem: fsys}
+	db, err := Open(testDBName, opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte("foo")))
+	assert.Nil(t, db.Sync())
+
+	// A snapshot of an open database is recovered.
+	assert.Nil(t, fsys.Save())
+	fsys2, err := fs.OpenMemSnapshot(path, 0)
+	assert.Nil(t, err)
+	db2, err := Open(testDBName, &Options{FileSystem: fsys2})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), db2.Count())
+	assert.Nil(t, db2.Close())
+
+	assert.Nil(t, db.Put([]byte("bar")))
+	assert.Nil(t, db.Close())
+	assert.Nil(t, fsys.Close())
+
+	fsys, err = fs.OpenMemSnapshot(path, 0)
+	assert.Nil(t, err)
+	db, err = Open(testDBName, &Options{FileSystem: fsys})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), db.Count())
+	has, err := db.Has([]byte("bar"))
+	assert.Nil(t, err)
+	assert.Equal(t, true, has)
+	assert.Nil(t, db.Close())
+	assert.Nil(t, fsys.Close())
+}
@@ -0,0 +1,168 @@
+package pogreb
+
+import "context"
+
+// deleteBatchBuckets is the number of index buckets DeleteFunc scans while holding a shard lock.
+const deleteBatchBuckets = 64
+
+// del removes the key from the shard and returns the number of removed slots.
+// When log is true, a delete record is written to the datalog before the slot is removed.
+// The caller must hold the shard write lock.
+func (db *DB) del(shard *indexShard, h uint64, key []byte, log bool) (int, error) {
+	return db.index.deleteFunc(shard, shard.bucketIndex(h), func(sl slot) (bool, error) {
+		if sl.hash != h || sl.keySize != slotKeySize(key) {
+			return false, nil
+		}
+		match, err := db.datalog.keyEqual(sl, key)
+		if err != nil || !match {
+			return false, err
+		}
+		if log {
+			if err := db.datalog.del(key); err != nil {
+				return false, err
+			}
+		}
+		return true, nil
+	})
+}
+
+// deleted updates the DB state after the keys were removed.
+func (db *DB) deleted(keys [][]byte) {
+	if len(keys) == 0 {
+		return
+	}
+	db.metrics.Dels.Add(int64(len(keys)))
+	for _, key := range keys {
+		db.invalidation.invalidate(key)
+	}
+	db.secondary.remove(keys)
+	db.countWatches.update(db.index.count())
+}
+
+// Delete removes the key from the DB. Deleting a missing key does nothing.
+// The key is passed through the transform stage of the write chain first, see Has.
+func (db *DB) Delete(key []byte) error {
+	return db.DeleteContext(context.Background(), key)
+}
+
+// DeleteContext is like Delete, attributing the deletion to the label of the context, see Options.Accounting.
+func (db *DB) DeleteContext(ctx context.Context, key []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	key, ok := db.lookupKey(key)
+	if !ok {
+		return db.checkWritable()
+	}
+	return db.deleteAccounted(ctx, key)
+}
+
+// deleteAccounted removes the key as it's stored and attributes the deletion to the label of the context.
+func (db *DB) deleteAccounted(ctx context.Context, key []byte) error {
+	removed, err := db.deleteStored(key)
+	if removed {
+		db.accounting.add(ctx, Usage{Deletes: 1, Bytes: -db.datalog.recordSize(key)})
+	}
+	return err
+}
+
+// deleteStored removes the key as it's stored, see fingerprint. It returns true if the key was removed.
+func (db *DB) deleteStored(key []byte) (bool, error) {
+	if err := db.checkWritable(); err != nil {
+		return false, err
+	}
+	h := db.hash(key)
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	shard := db.index.shard(h)
+	shard.mu.Lock()
+	n, err := db.del(shard, h, key, true)
+	if n > 0 {
+		db.deleted([][]byte{key})
+	}
+	shard.mu.Unlock()
+	if err != nil {
+		return n > 0, err
+	}
+	return n > 0, db.commit()
+}
+
+// deletedFunc updates the DB state after DeleteFunc removed the keys.
+func (db *DB) deletedFunc(keys [][]byte) {
+	db.deleted(keys)
+	if db.accounting == nil || len(keys) == 0 {
+		return
+	}
+	u := Usage{Deletes: int64(len(keys))}
+	for _, key := range keys {
+		u.Bytes -= db.datalog.recordSize(key)
+	}
+	db.accounting.add(context.Background(), u)
+}
+
+// DeleteFunc removes the keys for which pred returns true and returns the number of removed keys.
+//
+// The index is scanned in batches of buckets. Only writers of the shard being scanned are blocked, and only
+// while a batch is processed, which keeps the DB available during a long cleanup.
+// Keys written while DeleteFunc is running may or may not be passed to pred.
+// pred must not call DB methods. The deletions are attributed to the empty label, see Options.Accounting.
+func (db *DB) DeleteFunc(pred func(key []byte) bool) (int, error) {
+	if err := db.checkWritable(); err != nil {
+		return 0, err
+	}
+	deleted := 0
+	for _, shard := range db.index.shards {
+		for bucketIdx, more := uint32(0), true; more; {
+			var n int
+			var err error
+			n, bucketIdx, more, err = db.deleteBatch(shard, bucketIdx, pred)
+			deleted += n
+			if err != nil {
+				return deleted, err
+			}
+			if err := db.commit(); err != nil {
+				return deleted, err
+			}
+		}
+	}
+	return deleted, nil
+}
+
+// deleteBatch removes the matching keys of a batch of shard buckets starting at the bucket index.
+// It returns the number of removed keys, the index of the next bucket, and whether the shard has more buckets.
+func (db *DB) deleteBatch(shard *indexShard, start uint32, pred func(key []byte) bool) (int, uint32, bool, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	// Buckets split since the previous batch are appended to the shard, they are scanned by the following batches.
+	end := start + deleteBatchBuckets
+	if end > shard.numBuckets {
+		end = shard.numBuckets
+	}
+	var keys [][]byte
+	for bucketIdx := start; bucketIdx < end; bucketIdx++ {
+		_, err := db.index.deleteFunc(shard, bucketIdx, func(sl slot) (bool, error) {
+			db.datalog.mu.RLock()
+			key, err := db.datalog.readKey(sl)
+			if err == nil {
+				key = append([]byte(nil), key...)
+			}
+			db.datalog.mu.RUnlock()
+			if err != nil || !pred(key) {
+				return false, err
+			}
+			if err := db.datalog.del(key); err != nil {
+				return false, err
+			}
+			keys = append(keys, key)
+			return true, nil
+		})
+		if err != nil {
+			db.deletedFunc(keys)
+			return len(keys), end, false, err
+		}
+	}
+	db.deletedFunc(keys)
+	return len(keys), end, end < shard.numBuckets, nil
+}
@@ -0,0 +1,127 @@
+package pogreb
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/domaincrawler/pogreb/internal/assert"
+)
+
+func deleteTestKey(i int) []byte {
+	key := make([]byte, 4)
+	binary.BigEndian.PutUint32(key, uint32(i))
+	return key
+}
+
+func assertHas(t *testing.T, db *DB, key []byte, expected bool) {
+	t.Helper()
+	has, err := db.Has(key)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, has)
+}
+
+func TestDelete(t *testing.T) {
+	db, err := createTestDB(nil)
+	assert.Nil(t, err)
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, db.Put(deleteTestKey(i)))
+	}
+	assert.Nil(t, db.Delete(deleteTestKey(1)))
+	assert.Nil(t, db.Delete(deleteTestKey(10)))
+	assertHas(t, db, deleteTestKey(0), true)
+	assertHas(t, db, deleteTestKey(1), false)
+	assertHas(t, db, deleteTestKey(2), true)
+	assert.Equal(t, uint64(2), db.Count())
+	assert.Equal(t, int64(1), db.Metrics().Dels.Value())
+	assert.Nil(t, db.Close())
+
+	db, err = Open(testDBName, &Options{FileSystem: testFS})
+	assert.Nil(t, err)
+	assertHas(t, db, deleteTestKey(1), false)
+	assert.Equal(t, uint64(2), db.Count())
+
+	// Delete records are replayed by the recovery.
+	assert.Nil(t, db.Delete(deleteTestKey(2)))
+	assert.Nil(t, db.Put(deleteTestKey(1)))
+	simulateCrash(t, db)
+	db, err = Open(testDBName, &Options{FileSystem: testFS})
+	assert.Nil(t, err)
+	assertHas(t, db, deleteTestKey(0), true)
+	assertHas(t, db, deleteTestKey(1), true)
+	assertHas(t, db, deleteTestKey(2), false)
+	assert.Equal(t, uint64(2), db.Count())
+	assert.Nil(t, db.Close())
+}
+
+func TestDeleteFunc(t *testing.T) {
+	db, err := createTestDB(nil)
+	assert.Nil(t, err)
+	// Enough keys for several batches of buckets.
+	n := 20000
+	for i := 0; i < n; i++ {
+		assert.Nil(t, db.Put(deleteTestKey(i)))
+	}
+	assert.Equal(t, true, db.index.shards[0].numBuckets > deleteBatchBuckets)
+	deleted, err := db.DeleteFunc(func(key []byte) bool {
+		return binary.BigEndian.Uint32(key)%2 == 0
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, n/2, deleted)
+	assert.Equal(t, uint64(n/2), db.Count())
+	for i := 0; i < n; i++ {
+		assertHas(t, db, deleteTestKey(i), i%2 == 1)
+	}
+	simulateCrash(t, db)
+
+	db, err = Open(testDBName, &Options{FileSystem: testFS})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(n/2), db.Count())
+	for i := 0; i < n; i++ {
+		assertHas(t, db, deleteTestKey(i), i%2 == 1)
+	}
+	assert.Nil(t, db.Close())
+}
+
+func TestDeleteCompaction(t *testing.T) {
+	opts := &Options{
+		maxSegmentSize:             1024,
+		compactionMinSegmentSize:   520,
+		compactionMinFragmentation: -1, // Compact every segment.
+	}
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(deleteTestKey(i)))
+	}
+	deleted, err := db.DeleteFunc(func(key []byte) bool {
+		return binary.BigEndian.Uint32(key) < 90
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 90, deleted)
+	cr, err := db.Compact()
+	assert.Nil(t, err)
+	// Put and delete records of the deleted keys are discarded.
+	assert.Equal(t, 180, cr.ReclaimedRecords)
+	assert.Equal(t, uint64(10), db.Count())
+	simulateCrash(t, db)
+
+	db, err = Open(testDBName, &Options{FileSystem: testFS})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(10), db.Count())
+	for i := 0; i < 100; i++ {
+		assertHas(t, db, deleteTestKey(i), i >= 90)
+	}
+	assert.Nil(t, db.Close())
+}
+
+func TestDeleteReadOnly(t *testing.T) {
+	db, err := createTestDB(nil)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Close())
+	db, err = Open(testDBName, &Options{FileSystem: testFS, ReadOnly: true})
+	assert.Nil(t, err)
+	assert.Equal(t, errReadOnly, db.Delete([]byte{1}))
+	_, err = db.DeleteFunc(func(key []byte) bool { return true })
+	assert.Equal(t, errReadOnly, err)
+	assert.Nil(t, db.Close())
+}
@@ -0,0 +1,75 @@
+package pogreb
+
+import (
+	"github.com/domaincrawler/pogreb/fs"
+	"github.com/domaincrawler/pogreb/internal/errors"
+)
+
+// diskSpaceCheckBytes is the amount of data written between free space checks.
+// Close to the watermark the free space is checked before every write.
+const diskSpaceCheckBytes = 1 << 20
+
+// diskSpaceGuard rejects writes which would leave less than the minimum free disk space.
+// It's guarded by the datalog lock.
+type diskSpaceGuard struct {
+	fsys      fs.FreeSpaceFileSystem // Nil if the guard is disabled.
+	min       uint64
+	free      uint64 // Free space at the last check, minus the data written since.
+	unchecked uint64 // Bytes written since the last check.
+}
+
+func newDiskSpaceGuard(fsys fs.FileSystem, min uint64) (diskSpaceGuard, error) {
+	if min == 0 {
+		return diskSpaceGuard{}, nil
+	}
+	ffs, ok := fsys.(fs.FreeSpaceFileSystem)
+	if !ok {
+		return diskSpaceGuard{}, errors.New("file system doesn't report free disk space")
+	}
+	g := diskSpaceGuard{fsys: ffs, min: min}
+	if err := g.check(); err != nil {
+		return diskSpaceGuard{}, errors.Wrap(err, "checking free disk space")
+	}
+	return g, nil
+}
+
+// check measures the free space.
+func (g *diskSpaceGuard) check() error {
+	free, err := g.fsys.FreeSpace(".")
+	if err != nil {
+		return err
+	}
+	g.free = free
+	g.unchecked = 0
+	return nil
+}
+
+// reserve returns ErrDiskFull if writing n bytes would leave less than the minimum free space.
+func (g *diskSpaceGuard) reserve(n int) error {
+	if g.fsys == nil {
+		return nil
+	}
+	size := uint64(n)
+	if g.unchecked+size >= diskSpaceCheckBytes || g.free < g.min+size+diskSpaceCheckBytes {
+		if err := g.check(); err != nil {
+			return errors.Wrap(err, "checking free disk space")
+		}
+	}
+	if g.free < g.min+size {
+		return ErrDiskFull
+	}
+	g.free -= size
+	g.unchecked += size
+	return nil
+}
+
+// belowWatermark reports whether the free space is below the minimum, measuring it first.
+func (g *diskSpaceGuard) belowWatermark() (bool, error) {
+	if g.fsys == nil {
+		return false, nil
+	}
+	if err := g.check(); err != nil {
+		return false, errors.Wrap(err, "checking free disk space")
+	}
+	return g.free < g.min, nil
+}
@@ -0,0 +1,64 @@
+package pogreb
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"github.com/domaincrawler/pogreb/fs"
+	"github.com/domaincrawler/pogreb/internal/assert"
+)
+
+// freeSpaceFS reports a fixed amount of free disk space.
+type freeSpaceFS struct {
+	fs.FileSystem
+	free   uint64 // Accessed atomically.
+	checks int64  // Accessed atomically.
+}
+
+func (fsys *freeSpaceFS) FreeSpace(name string) (uint64, error) {
+	atomic.AddInt64(&fsys.checks, 1)
+	return atomic.LoadUint64(&fsys.free), nil
+}
+
+func TestMinFreeDiskBytes(t *testing.T) {
+	fsys := &freeSpaceFS{FileSystem: testFS, free: 10 << 20}
+	opts := &Options{FileSystem: fsys, MinFreeDiskBytes: 8 << 20}
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+
+	// Far from the watermark, the free space isn't checked before every write.
+	checks := atomic.LoadInt64(&fsys.checks)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put([]byte{byte(i)}))
+	}
+	assert.Equal(t, checks, atomic.LoadInt64(&fsys.checks))
+	_, err = db.Compact()
+	assert.Nil(t, err)
+
+	assert.Nil(t, db.Close())
+
+	// Close to the watermark, the free space is checked before every write.
+	atomic.StoreUint64(&fsys.free, 8<<20+10)
+	db, err = Open(testDBName, opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte("foo")))
+	assert.Equal(t, ErrDiskFull, db.Put([]byte("foobar")))
+	assert.Equal(t, CodeDiskFull, ErrorCodeOf(db.Put([]byte("foobar"))))
+	has, err := db.Has([]byte("foobar"))
+	assert.Nil(t, err)
+	assert.Equal(t, false, has)
+
+	atomic.StoreUint64(&fsys.free, 1<<20)
+	_, err = db.Compact()
+	assert.Equal(t, ErrDiskFull, err)
+
+	atomic.StoreUint64(&fsys.free, 10<<20)
+	assert.Nil(t, db.Put([]byte("foobar")))
+	assert.Nil(t, db.Close())
+
+	// The file system must report free space.
+	if _, ok := testFS.(fs.FreeSpaceFileSystem); !ok {
+		_, err = Open(testDBName, &Options{FileSystem: testFS, MinFreeDiskBytes: 1})
+		assert.NotNil(t, err)
+	}
+}
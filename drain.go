@@ -0,0 +1,88 @@
+package pogreb
+
+import (
+	"sync/atomic"
+)
+
+const defaultDrainBatchSize = 1024
+
+// Drain calls fn with batches of at most batchSize keys until every key in the DB is passed to fn.
+// Keys written concurrently may or may not be included. Drain stops at the first error returned by fn.
+// A non-positive batchSize selects the default batch size of 1024 keys.
+func (db *DB) Drain(fn func(keys [][]byte) error, batchSize int) error {
+	if batchSize <= 0 {
+		batchSize = defaultDrainBatchSize
+	}
+	batch := make([][]byte, 0, batchSize)
+	it := db.Items()
+	for {
+		key, err := it.Next()
+		if err == ErrIterationDone {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		batch = append(batch, key)
+		if len(batch) == batchSize {
+			if err := fn(batch); err != nil {
+				return err
+			}
+			batch = make([][]byte, 0, batchSize)
+		}
+	}
+	if len(batch) > 0 {
+		return fn(batch)
+	}
+	return nil
+}
+
+// DrainAndTruncate passes every key in the DB to fn like Drain and removes all keys afterwards.
+// The DB is locked for the whole operation, no key written concurrently is lost between the drain and the truncation.
+// When fn returns an error, the DB isn't truncated. The truncation is atomic, see Truncate.
+func (db *DB) DrainAndTruncate(fn func(keys [][]byte) error, batchSize int) error {
+	if db.opts.ReadOnly {
+		return errReadOnly
+	}
+	if batchSize <= 0 {
+		batchSize = defaultDrainBatchSize
+	}
+	// Compaction reads segments which are about to be removed.
+	if !atomic.CompareAndSwapInt32(&db.compactionRunning, 0, 1) {
+		return ErrBusy
+	}
+	defer atomic.StoreInt32(&db.compactionRunning, 0)
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// The write lock excludes all other operations, the index is read without shard locks.
+	it := &ItemIterator{db: db}
+	for _, shard := range db.index.shards {
+		for bucketIdx := uint32(0); bucketIdx < shard.numBuckets; bucketIdx++ {
+			if err := it.fetchItems(shard, bucketIdx, nil); err != nil {
+				return err
+			}
+			for len(it.queue) >= batchSize {
+				if err := db.drainBatch(fn, it, batchSize); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if len(it.queue) > 0 {
+		if err := db.drainBatch(fn, it, len(it.queue)); err != nil {
+			return err
+		}
+	}
+	return db.truncate()
+}
+
+func (db *DB) drainBatch(fn func(keys [][]byte) error, it *ItemIterator, n int) error {
+	batch := make([][]byte, n)
+	for i := range batch {
+		batch[i] = it.queue[i].key
+	}
+	it.queue = it.queue[n:]
+	return fn(batch)
+}
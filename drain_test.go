@@ -0,0 +1,70 @@
+package pogreb
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/domaincrawler/pogreb/internal/assert"
+)
+
+func TestDrain(t *testing.T) {
+	db, err := createTestDB(&Options{IndexShards: 2, maxSegmentSize: 1024})
+	assert.Nil(t, err)
+	key := func(i int) []byte {
+		k := make([]byte, 4)
+		binary.LittleEndian.PutUint32(k, uint32(i))
+		return k
+	}
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Put(key(i)))
+	}
+
+	drain := func(drainFn func(fn func(keys [][]byte) error, batchSize int) error) (map[string]bool, []int) {
+		seen := make(map[string]bool)
+		var sizes []int
+		err := drainFn(func(keys [][]byte) error {
+			sizes = append(sizes, len(keys))
+			for _, k := range keys {
+				seen[string(k)] = true
+			}
+			return nil
+		}, 200)
+		assert.Nil(t, err)
+		return seen, sizes
+	}
+
+	seen, sizes := drain(db.Drain)
+	assert.Equal(t, 500, len(seen))
+	assert.Equal(t, []int{200, 200, 100}, sizes)
+	assert.Equal(t, uint64(500), db.Count())
+
+	// Errors returned by fn stop DrainAndTruncate before the truncation.
+	errTest := errors.New("test")
+	assert.Equal(t, errTest, db.DrainAndTruncate(func(keys [][]byte) error {
+		return errTest
+	}, 0))
+	assert.Equal(t, uint64(500), db.Count())
+
+	seen, sizes = drain(db.DrainAndTruncate)
+	assert.Equal(t, 500, len(seen))
+	assert.Equal(t, []int{200, 200, 100}, sizes)
+	assert.Equal(t, uint64(0), db.Count())
+	assert.Equal(t, 1, countSegments(t, db))
+	has, err := db.Has(key(1))
+	assert.Nil(t, err)
+	assert.Equal(t, false, has)
+
+	assert.Nil(t, db.Put(key(1000)))
+	assert.Nil(t, db.Close())
+
+	db, err = Open(testDBName, &Options{FileSystem: testFS})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), db.Count())
+	has, err = db.Has(key(1000))
+	assert.Nil(t, err)
+	assert.Equal(t, true, has)
+	seen, _ = drain(db.Drain)
+	assert.Equal(t, map[string]bool{string(key(1000)): true}, seen)
+	assert.Nil(t, db.Close())
+}
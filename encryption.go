@@ -0,0 +1,212 @@
+package pogreb
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"math"
+	"os"
+
+	"github.com/domaincrawler/pogreb/internal/errors"
+)
+
+const (
+	encryptionNonceSize = 8  // Size of the random nonce prefix stored in the segment header.
+	encryptionTagSize   = 16 // Size of the authentication tag appended to the encrypted part of a record.
+
+	// keyCheckOffset is the record offset of the nonce sealing the key check value of a segment header.
+	// Segment sizes are 32-bit, no record starts at it.
+	keyCheckOffset = math.MaxUint32
+)
+
+var (
+	errInvalidEncryptionKey = errors.New("encryption key must be 16, 24 or 32 bytes long")
+
+	// errIndexSeed is returned by Open when the DB is encrypted, but its index hashes keys with a seed
+	// which isn't derived from the encryption key.
+	errIndexSeed = errors.New("index hash seed isn't derived from the encryption key")
+)
+
+// encryptedHashSeed derives the secret hash seed of an encrypted DB from the encryption key and the stored seed.
+// The index files hash keys with it, a key hash can't be tested against guessed keys without the encryption key.
+func encryptedHashSeed(key []byte, seed uint32) uint32 {
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write([]byte("pogreb index hash seed"))
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], seed)
+	_, _ = mac.Write(b[:])
+	return binary.LittleEndian.Uint32(mac.Sum(nil))
+}
+
+// needsEncryptedHashSeed returns true if the DB is opened with an encryption key,
+// but an existing DB meta doesn't derive the hash seed from it.
+func needsEncryptedHashSeed(opts *Options) (bool, error) {
+	if opts.EncryptionKey == nil || opts.ReadOnly {
+		return false, nil
+	}
+	m := dbMeta{}
+	if err := readGobFile(opts.FileSystem, dbMetaName, &m); err != nil {
+		// A new DB has no meta or an empty one.
+		if errors.Is(err, os.ErrNotExist) || errors.Is(err, io.EOF) {
+			return false, nil
+		}
+		return false, errors.Wrap(err, "reading db meta")
+	}
+	return !m.EncryptedHashSeed, nil
+}
+
+// newEncryptionAEAD returns the AES-GCM cipher of the key, or nil if encryption is disabled.
+func newEncryptionAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) == 0 {
+		return nil, nil
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errInvalidEncryptionKey
+	}
+	return cipher.NewGCM(block)
+}
+
+// recordCipher encrypts the records of a segment.
+// The nonce of a record consists of the random nonce of the segment and the record offset,
+// a segment must never be appended to at an offset which was written before, e.g. after a crash.
+//
+// Binary representation of an encrypted record:
+// +---------------+--------------------------+-------------+----------+
+// | Prefix        | Encrypted key            | Tag (16B)   | CRC (4B) |
+// +---------------+--------------------------+-------------+----------+
+// The prefix holds the key size fields, which are authenticated but not encrypted:
+// the key size of regular records, or the marker and the key size of large-key records,
+// whose digest is encrypted along with the key. The CRC covers the encrypted record.
+type recordCipher struct {
+	aead  cipher.AEAD
+	nonce [encryptionNonceSize]byte
+}
+
+// newRecordCipher returns the cipher of a new segment with a random nonce.
+func newRecordCipher(aead cipher.AEAD) (*recordCipher, error) {
+	c := &recordCipher{aead: aead}
+	if _, err := rand.Read(c.nonce[:]); err != nil {
+		return nil, errors.Wrap(err, "generating segment nonce")
+	}
+	return c, nil
+}
+
+// recordNonce returns the nonce of the record at the offset. Encrypted segments are limited to 4 GiB,
+// the offset fits in the 4 bytes following the segment nonce.
+func (c *recordCipher) recordNonce(offset uint64) []byte {
+	nonce := make([]byte, c.aead.NonceSize())
+	copy(nonce, c.nonce[:])
+	binary.LittleEndian.PutUint32(nonce[encryptionNonceSize:], uint32(offset))
+	return nonce
+}
+
+// keyCheck returns the value stored in the segment header, which verifies the key when the segment is opened.
+func (c *recordCipher) keyCheck() [encryptionTagSize]byte {
+	var check [encryptionTagSize]byte
+	copy(check[:], c.aead.Seal(nil, c.recordNonce(keyCheckOffset), nil, nil))
+	return check
+}
+
+func (c *recordCipher) verifyKey(check [encryptionTagSize]byte) error {
+	if _, err := c.aead.Open(nil, c.recordNonce(keyCheckOffset), check[:], nil); err != nil {
+		return errEncryptionKeyMismatch
+	}
+	return nil
+}
+
+// encryptedPrefixSize returns the size of the unencrypted fields of the record.
+func encryptedPrefixSize(data []byte, largeKeys bool) int {
+	if largeKeys && binary.LittleEndian.Uint16(data[:2]) == largeKeyMarker {
+		return 2 + 4
+	}
+	return 2
+}
+
+// encryptRecord encrypts the encoded record written at the offset and checksums the result.
+func (c *recordCipher) encryptRecord(data []byte, offset uint64, largeKeys bool, checksum Checksum) []byte {
+	p := encryptedPrefixSize(data, largeKeys)
+	out := make([]byte, p, len(data)+encryptionTagSize)
+	copy(out, data[:p])
+	out = c.aead.Seal(out, c.recordNonce(offset), data[p:len(data)-4], data[:p])
+	out = out[:len(out)+4]
+	binary.LittleEndian.PutUint32(out[len(out)-4:], checksum.sum(out[:len(out)-4]))
+	return out
+}
+
+// decrypt returns the plaintext of the encrypted part of the record at the offset.
+// The data holds the prefix of the record and the encrypted part, without the CRC.
+func (c *recordCipher) decrypt(data []byte, offset uint64, prefixSize int) ([]byte, error) {
+	plain, err := c.aead.Open(nil, c.recordNonce(offset), data[prefixSize:], data[:prefixSize])
+	if err != nil {
+		return nil, errors.Wrapf(ErrCorrupted, "record at offset %d failed authentication", offset)
+	}
+	return plain, nil
+}
+
+// decryptRecord returns the encoded record stored encrypted at the offset.
+func (c *recordCipher) decryptRecord(data []byte, offset uint64, largeKeys bool, checksum Checksum) ([]byte, error) {
+	p := encryptedPrefixSize(data, largeKeys)
+	plain, err := c.decrypt(data[:len(data)-4], offset, p)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, p+len(plain)+4)
+	copy(out, data[:p])
+	copy(out[p:], plain)
+	binary.LittleEndian.PutUint32(out[len(out)-4:], checksum.sum(out[:len(out)-4]))
+	return out, nil
+}
+
+// encrypted returns true if the records of the file are encrypted.
+func (f *file) encrypted() bool {
+	return f.flags&headerFlagEncrypted != 0
+}
+
+// recordOverhead returns the number of bytes added to every record of the file by the encryption.
+func (f *file) recordOverhead() uint32 {
+	if f.encrypted() {
+		return encryptionTagSize
+	}
+	return 0
+}
+
+// openCipher sets the cipher of an encrypted file, verifying the key against the header.
+func (f *file) openCipher(aead cipher.AEAD) error {
+	if !f.encrypted() {
+		return nil
+	}
+	if aead == nil {
+		return errEncryptionKeyRequired
+	}
+	c := &recordCipher{aead: aead, nonce: f.nonce}
+	if err := c.verifyKey(f.keyCheck); err != nil {
+		return err
+	}
+	f.cipher = c
+	return nil
+}
+
+// setCipher rewrites the header of an empty file, encrypting new records with a new nonce,
+// or storing them unencrypted if the cipher is nil.
+func (f *file) setCipher(aead cipher.AEAD, flags uint32, checksum Checksum) error {
+	f.cipher = nil
+	f.nonce = [encryptionNonceSize]byte{}
+	f.keyCheck = [encryptionTagSize]byte{}
+	flags &^= headerFlagEncrypted
+	if aead != nil {
+		c, err := newRecordCipher(aead)
+		if err != nil {
+			return err
+		}
+		f.cipher = c
+		f.nonce = c.nonce
+		f.keyCheck = c.keyCheck()
+		flags |= headerFlagEncrypted
+	}
+	return f.setHeader(flags, checksum)
+}
@@ -0,0 +1,158 @@
+package pogreb
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/domaincrawler/pogreb/internal/assert"
+	"github.com/domaincrawler/pogreb/internal/errors"
+)
+
+func encryptionTestKey(i int) []byte {
+	return []byte(fmt.Sprintf("https://example.com/users/%d", i))
+}
+
+func readTestFile(t *testing.T, name string) []byte {
+	t.Helper()
+	f, err := testFS.OpenFile(filepath.Join(testDBName, name), os.O_RDONLY, 0)
+	assert.Nil(t, err)
+	data, err := ioutil.ReadAll(f)
+	assert.Nil(t, err)
+	assert.Nil(t, f.Close())
+	return data
+}
+
+func TestEncryption(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 32)
+	opts := &Options{EncryptionKey: key, LargeKeys: true}
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(encryptionTestKey(i)))
+	}
+	largeKey := bytes.Repeat([]byte("https://example.com/"), MaxKeyLength/20+1)
+	assert.Nil(t, db.Put(largeKey))
+	assert.Nil(t, db.Delete(encryptionTestKey(0)))
+	assertHas(t, db, encryptionTestKey(1), true)
+	assertHas(t, db, largeKey, true)
+	assert.Nil(t, db.Close())
+
+	data := readTestFile(t, segmentName(0, 1))
+	assert.Equal(t, false, bytes.Contains(data, []byte("example.com")))
+
+	// Records are decrypted by lookups, iterators and the recovery.
+	db, err = Open(testDBName, opts)
+	assert.Nil(t, err)
+	assertHas(t, db, encryptionTestKey(0), false)
+	assertHas(t, db, encryptionTestKey(99), true)
+	assertHas(t, db, largeKey, true)
+	assert.Equal(t, uint64(100), db.Count())
+	n := 0
+	it := db.Items()
+	for {
+		k, err := it.Next()
+		if err == ErrIterationDone {
+			break
+		}
+		assert.Nil(t, err)
+		assert.Equal(t, true, bytes.HasPrefix(k, []byte("https://example.com/")))
+		n++
+	}
+	assert.Equal(t, 100, n)
+
+	// The segment written before reopening isn't appended to.
+	assert.Nil(t, db.Put(encryptionTestKey(100)))
+	assert.Equal(t, true, db.datalog.curSeg.id != 0)
+	simulateCrash(t, db)
+
+	// The recovery reads the keys from the encrypted records.
+	db, err = Open(testDBName, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(101), db.Count())
+	assertHas(t, db, encryptionTestKey(100), true)
+	assertHas(t, db, largeKey, true)
+	assert.Nil(t, db.Close())
+
+	_, err = Open(testDBName, &Options{FileSystem: testFS, LargeKeys: true})
+	assert.Equal(t, true, errors.Is(err, errEncryptionKeyRequired))
+	assert.Equal(t, CodeMismatch, ErrorCodeOf(err))
+	_, err = Open(testDBName, &Options{FileSystem: testFS, LargeKeys: true, EncryptionKey: bytes.Repeat([]byte{8}, 32)})
+	assert.Equal(t, true, errors.Is(err, errEncryptionKeyMismatch))
+	_, err = Open(testDBName, &Options{FileSystem: testFS, EncryptionKey: []byte{1}})
+	assert.Equal(t, true, errors.Is(err, errInvalidEncryptionKey))
+}
+
+func TestEncryptionExistingSegments(t *testing.T) {
+	opts := &Options{
+		FileSystem:                 testFS,
+		compactionMinSegmentSize:   512,
+		compactionMinFragmentation: -1, // Compact every segment.
+	}
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, db.Put(encryptionTestKey(i)))
+	}
+	assert.Nil(t, db.Close())
+
+	// The index is rehashed with the secret seed derived from the key.
+	opts.EncryptionKey = bytes.Repeat([]byte{7}, 16)
+	db, err = Open(testDBName, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, true, db.OpenReport().IndexRebuilt)
+	assert.Equal(t, true, db.encryptedHashSeed)
+	for i := 0; i < 10; i++ {
+		assertHas(t, db, encryptionTestKey(i), true)
+	}
+	for i := 10; i < 20; i++ {
+		assert.Nil(t, db.Put(encryptionTestKey(i)))
+	}
+	assert.Equal(t, false, db.datalog.segments[0].encrypted())
+	assert.Equal(t, true, db.datalog.curSeg.encrypted())
+
+	// Compaction rewrites the records of the unencrypted segment encrypted.
+	for i := 0; i < 9; i++ {
+		assert.Nil(t, db.Delete(encryptionTestKey(i)))
+	}
+	cr, err := db.Compact()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, cr.CompactedSegments)
+	for _, seg := range db.datalog.segmentsBySequenceID() {
+		assert.Equal(t, true, seg.encrypted())
+	}
+	for i := 9; i < 20; i++ {
+		assertHas(t, db, encryptionTestKey(i), true)
+	}
+	assert.Nil(t, db.Close())
+}
+
+func TestEncryptedHashSeed(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 32)
+	opts := &Options{EncryptionKey: key, HashSeed: 42}
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put(encryptionTestKey(1)))
+	assert.Equal(t, uint32(42), db.HashSeed())
+	assert.Equal(t, encryptedHashSeed(key, 42), db.domainSeed)
+	assert.Equal(t, false, db.hash(encryptionTestKey(1)) == db.index.hashAlgorithm().sum(encryptionTestKey(1), 42))
+	assert.Nil(t, db.Close())
+
+	// The meta stores the seed, the index can't be hashed without the key.
+	m := dbMeta{}
+	assert.Nil(t, readGobFile(testFS, filepath.Join(testDBName, dbMetaName), &m))
+	assert.Equal(t, uint32(42), m.HashSeed)
+	assert.Equal(t, true, m.EncryptedHashSeed)
+
+	db, err = Open(testDBName, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, false, db.OpenReport().IndexRebuilt)
+	assertHas(t, db, encryptionTestKey(1), true)
+	assert.Nil(t, db.Close())
+
+	// Another key derives another seed.
+	assert.Equal(t, false, encryptedHashSeed(bytes.Repeat([]byte{8}, 32), 42) == encryptedHashSeed(key, 42))
+}
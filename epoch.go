@@ -0,0 +1,62 @@
+package pogreb
+
+import (
+	"os"
+
+	"github.com/domaincrawler/pogreb/fs"
+	"github.com/domaincrawler/pogreb/internal/errors"
+)
+
+const (
+	epochName    = "epoch" + metaExt
+	epochTmpName = epochName + ".tmp"
+)
+
+// epochMeta is stored separately from the DB meta, which is discarded by the recovery.
+type epochMeta struct {
+	Epoch uint64
+}
+
+// readEpoch returns the stored epoch, 0 if the database has never been opened for writing.
+func readEpoch(fsys fs.FileSystem) (uint64, error) {
+	if _, err := fsys.Stat(epochName); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	m := epochMeta{}
+	if err := readGobFile(fsys, epochName, &m); err != nil {
+		return 0, err
+	}
+	return m.Epoch, nil
+}
+
+// bumpEpoch durably increments the stored epoch and returns the new value.
+func bumpEpoch(fsys fs.FileSystem) (uint64, error) {
+	epoch, err := readEpoch(fsys)
+	if err != nil {
+		return 0, err
+	}
+	epoch++
+	if err := writeSyncedFile(fsys, epochTmpName, writeGob(epochMeta{Epoch: epoch})); err != nil {
+		return 0, err
+	}
+	if err := fsys.Rename(epochTmpName, epochName); err != nil {
+		return 0, err
+	}
+	// An epoch lost in a power failure would be handed out again.
+	if err := syncDir(fsys); err != nil {
+		return 0, err
+	}
+	return epoch, nil
+}
+
+// Epoch returns the fencing token of the DB.
+// The epoch is persisted and incremented every time the database is opened for writing,
+// including opens which recover from a crash. An external coordinator can compare epochs
+// to reject writes made through a handle opened before a newer one.
+// Databases opened with Options.ReadOnly return the epoch of the last writable open.
+func (db *DB) Epoch() uint64 {
+	return db.epoch
+}
@@ -0,0 +1,59 @@
+package pogreb
+
+import (
+	"testing"
+
+	"github.com/domaincrawler/pogreb/fs"
+	"github.com/domaincrawler/pogreb/internal/assert"
+)
+
+// dirSyncFS counts the directory syncs.
+type dirSyncFS struct {
+	fs.FileSystem
+	syncs []string
+}
+
+func (fsys *dirSyncFS) SyncDir(name string) error {
+	fsys.syncs = append(fsys.syncs, name)
+	return nil
+}
+
+func TestEpoch(t *testing.T) {
+	opts := &Options{}
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), db.Epoch())
+	assert.Nil(t, db.Put([]byte{1}))
+	assert.Nil(t, db.Close())
+
+	db, err = Open(testDBName, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), db.Epoch())
+
+	// The epoch survives the recovery.
+	simulateCrash(t, db)
+	db, err = Open(testDBName, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(3), db.Epoch())
+	assert.Nil(t, db.Close())
+
+	// Read-only opens don't increment the epoch.
+	db, err = Open(testDBName, &Options{FileSystem: testFS, ReadOnly: true})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(3), db.Epoch())
+	assert.Nil(t, db.Close())
+
+	db, err = Open(testDBName, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(4), db.Epoch())
+	assert.Nil(t, db.Close())
+}
+
+func TestEpochSyncsDir(t *testing.T) {
+	fsys := &dirSyncFS{FileSystem: testFS}
+	removeMergeSource(t, testDBName)
+	db, err := Open(testDBName, &Options{FileSystem: fsys})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{testDBName}, fsys.syncs)
+	assert.Nil(t, db.Close())
+}
@@ -0,0 +1,128 @@
+package pogreb
+
+import (
+	"github.com/domaincrawler/pogreb/internal/errors"
+)
+
+// ErrorCode classifies errors returned by the DB for programmatic handling.
+// Errors keep their codes when wrapped, e.g. with fmt.Errorf and the %w verb.
+type ErrorCode int
+
+const (
+	// CodeOK means there is no error.
+	CodeOK ErrorCode = iota
+
+	// CodeUnknown is an error not originating from pogreb, e.g. an I/O error of the file system.
+	CodeUnknown
+
+	// CodeKeyTooLarge means the key or the external locator exceeds the maximum size, see ErrKeyTooLarge.
+	CodeKeyTooLarge
+
+	// CodeCorrupted means the database files are corrupted, see ErrCorrupted.
+	CodeCorrupted
+
+	// CodeLocked means the database is opened by another process, see ErrLocked.
+	CodeLocked
+
+	// CodeBusy means a conflicting operation, e.g. compaction, is already running, see ErrBusy.
+	CodeBusy
+
+	// CodeClosed means the database is closed.
+	CodeClosed
+
+	// CodeReadOnly means a write to a database opened with Options.ReadOnly,
+	// or a database on a read-only file system, see ReadOnlyFSError.
+	CodeReadOnly
+
+	// CodeFull means the database can't accept new keys, see ErrFull.
+	CodeFull
+
+	// CodeBlocked means the key is rejected by Options.Blocklist, see ErrBlocked.
+	CodeBlocked
+
+	// CodeMismatch means the database doesn't match the options it's opened with,
+	// e.g. Options.HashDomain, Options.HashSeed or Options.EncryptionKey, or the index doesn't match the datalog.
+	CodeMismatch
+
+	// CodeUnsupported means the database files are written in a format or with algorithms unknown to this version.
+	CodeUnsupported
+
+	// CodeIterationDone means there are no more items, see ErrIterationDone.
+	CodeIterationDone
+
+	// CodeQuotaExceeded means an iterator exceeded its IterationQuota, see ErrQuotaExceeded.
+	CodeQuotaExceeded
+
+	// CodeDiskFull means the free disk space is below Options.MinFreeDiskBytes, see ErrDiskFull.
+	CodeDiskFull
+)
+
+var errorCodes = []struct {
+	err  error
+	code ErrorCode
+}{
+	{ErrKeyTooLarge, CodeKeyTooLarge},
+	{errLocatorTooLarge, CodeKeyTooLarge},
+	{ErrCorrupted, CodeCorrupted},
+	{ErrLocked, CodeLocked},
+	{ErrBusy, CodeBusy},
+	{errClosed, CodeClosed},
+	{errReadOnly, CodeReadOnly},
+	{ErrFull, CodeFull},
+	{ErrBlocked, CodeBlocked},
+	{errHashDomainMismatch, CodeMismatch},
+	{errHashSeedMismatch, CodeMismatch},
+	{errEncryptionKeyRequired, CodeMismatch},
+	{errEncryptionKeyMismatch, CodeMismatch},
+	{errFingerprintMismatch, CodeMismatch},
+	{ErrIndexMismatch, CodeMismatch},
+	{errUnsupportedVersion, CodeUnsupported},
+	{errUnsupportedChecksum, CodeUnsupported},
+	{errUnsupportedHash, CodeUnsupported},
+	{errNewerFormat, CodeUnsupported},
+	{ErrIterationDone, CodeIterationDone},
+	{ErrQuotaExceeded, CodeQuotaExceeded},
+	{ErrDiskFull, CodeDiskFull},
+}
+
+// ErrorCodeOf returns the code of the error. The whole error chain is examined.
+func ErrorCodeOf(err error) ErrorCode {
+	if err == nil {
+		return CodeOK
+	}
+	var rofsErr *ReadOnlyFSError
+	if errors.As(err, &rofsErr) {
+		return CodeReadOnly
+	}
+	for _, ec := range errorCodes {
+		if errors.Is(err, ec.err) {
+			return ec.code
+		}
+	}
+	return CodeUnknown
+}
+
+var errorCodeNames = [...]string{
+	CodeOK:            "ok",
+	CodeUnknown:       "unknown",
+	CodeKeyTooLarge:   "key too large",
+	CodeCorrupted:     "corrupted",
+	CodeLocked:        "locked",
+	CodeBusy:          "busy",
+	CodeClosed:        "closed",
+	CodeReadOnly:      "read-only",
+	CodeFull:          "full",
+	CodeBlocked:       "blocked",
+	CodeMismatch:      "mismatch",
+	CodeUnsupported:   "unsupported",
+	CodeIterationDone: "iteration done",
+	CodeQuotaExceeded: "quota exceeded",
+	CodeDiskFull:      "disk full",
+}
+
+func (c ErrorCode) String() string {
+	if c < 0 || int(c) >= len(errorCodeNames) {
+		return "unknown"
+	}
+	return errorCodeNames[c]
+}
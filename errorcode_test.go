@@ -0,0 +1,43 @@
+package pogreb
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/domaincrawler/pogreb/internal/assert"
+	"github.com/domaincrawler/pogreb/internal/errors"
+)
+
+func TestErrorCodeOf(t *testing.T) {
+	assert.Equal(t, CodeOK, ErrorCodeOf(nil))
+	assert.Equal(t, CodeUnknown, ErrorCodeOf(io.EOF))
+	assert.Equal(t, CodeFull, ErrorCodeOf(ErrFull))
+	assert.Equal(t, CodeCorrupted, ErrorCodeOf(errors.Wrap(errors.Wrap(ErrCorrupted, "reading header"), "opening index")))
+	assert.Equal(t, CodeLocked, ErrorCodeOf(fmt.Errorf("opening: %w", errors.Wrap(ErrLocked, "creating lock file"))))
+	corruption := &CorruptionError{Segment: "0.psg", Offset: 512, Reason: "record checksum doesn't match"}
+	assert.Equal(t, CodeCorrupted, ErrorCodeOf(errors.Wrap(corruption, "compacting")))
+	assert.Equal(t, "0.psg is corrupted at offset 512: record checksum doesn't match", corruption.Error())
+	assert.Equal(t, "read-only", CodeReadOnly.String())
+	for _, ec := range errorCodes {
+		if ec.code.String() == "unknown" {
+			t.Fatalf("code %d has no name", ec.code)
+		}
+	}
+
+	db, err := createTestDB(nil)
+	assert.Nil(t, err)
+	err = db.Put(make([]byte, MaxKeyLength+1))
+	assert.Equal(t, CodeKeyTooLarge, ErrorCodeOf(err))
+	assert.Equal(t, true, errors.Is(err, ErrKeyTooLarge))
+	assert.Nil(t, db.Close())
+
+	// Errors returned by Open keep their codes.
+	lock, _, err := testFS.CreateLockFile(filepath.Join(testDBName, lockName), 0644)
+	assert.Nil(t, err)
+	_, err = Open(testDBName, &Options{FileSystem: testFS})
+	assert.Equal(t, CodeLocked, ErrorCodeOf(err))
+	assert.Equal(t, true, errors.Is(err, ErrLocked))
+	assert.Nil(t, lock.Unlock())
+}
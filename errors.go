@@ -1,13 +1,86 @@
 package pogreb
 
 import (
+	"strconv"
+
 	"github.com/domaincrawler/pogreb/internal/errors"
 )
 
+// Errors returned by the DB. They may be wrapped with additional context, use errors.Is to check for them.
+var (
+	// ErrKeyTooLarge is returned when the key exceeds MaxKeyLength, or MaxLargeKeyLength with Options.LargeKeys.
+	ErrKeyTooLarge = errors.New("key is too large")
+
+	// ErrCorrupted is returned when the database files are corrupted.
+	ErrCorrupted = errors.New("database is corrupted")
+
+	// ErrLocked is returned by Open when the database is opened by another process.
+	ErrLocked = errors.New("database is locked")
+
+	// ErrBusy is returned when a conflicting operation, e.g. compaction, is already running.
+	ErrBusy = errors.New("database is busy")
+)
+
 var (
-	errKeyTooLarge = errors.New("key is too large")
-	errFull        = errors.New("database is full")
-	errCorrupted   = errors.New("database is corrupted")
-	errLocked      = errors.New("database is locked")
-	errBusy        = errors.New("database is busy")
+	errClosed   = errors.New("database is closed")
+	errReadOnly = errors.New("database is read-only")
+
+	errHashDomainMismatch = errors.New("hash domain mismatch")
+	errHashSeedMismatch   = errors.New("hash seed doesn't match the database")
+
+	errEncryptionKeyRequired = errors.New("segment is encrypted, Options.EncryptionKey is required")
+	errEncryptionKeyMismatch = errors.New("encryption key doesn't match the segment")
+
+	errUnsupportedVersion  = errors.New("unsupported file format version")
+	errUnsupportedChecksum = errors.New("unsupported checksum algorithm")
+	errUnsupportedHash     = errors.New("unsupported hash algorithm")
+	errNewerFormat         = errors.New("database format is newer than supported")
 )
+
+// ErrBlocked is returned by Put and HasOrPut when the key is rejected by Options.Blocklist.
+// Write interceptors can return it, or an error wrapping it, to reject keys the same way.
+var ErrBlocked = errors.New("key is blocked")
+
+// ErrFull is returned when the DB can't accept new keys,
+// either because it reached MaxKeys or because the key budget passed to HasOrPutWithin is exhausted.
+var ErrFull = errors.New("database is full")
+
+// ErrDiskFull is returned by writes and Compact when the free disk space is below Options.MinFreeDiskBytes.
+var ErrDiskFull = errors.New("not enough free disk space")
+
+// ErrIndexMismatch is returned by Open when the index doesn't match the datalog segments:
+// the number of keys disagrees with the index buckets, or the segments changed since the index was closed.
+// Options.RebuildIndexOnMismatch rebuilds the index instead.
+var ErrIndexMismatch = errors.New("index doesn't match the datalog")
+
+// ReadOnlyFSError is returned by Open when the database can't be opened for writing,
+// because the file system is mounted read-only or the database directory isn't writable.
+// Setting Options.ReadOnly, or Options.ReadOnlyFallback, opens the database for reading instead.
+type ReadOnlyFSError struct {
+	Path string // Path of the database.
+	Err  error  // Error returned by the file system.
+}
+
+func (e *ReadOnlyFSError) Error() string {
+	return "database " + e.Path + " is on a read-only file system: " + e.Err.Error()
+}
+
+func (e *ReadOnlyFSError) Unwrap() error {
+	return e.Err
+}
+
+// CorruptionError describes corrupted data of a database file.
+// It wraps ErrCorrupted, errors.Is(err, ErrCorrupted) reports true for it.
+type CorruptionError struct {
+	Segment string // Name of the corrupted file, usually a datalog segment.
+	Offset  int64  // Offset of the corrupted record or header in the file.
+	Reason  string // Description of the corruption.
+}
+
+func (e *CorruptionError) Error() string {
+	return e.Segment + " is corrupted at offset " + strconv.FormatInt(e.Offset, 10) + ": " + e.Reason
+}
+
+func (e *CorruptionError) Unwrap() error {
+	return ErrCorrupted
+}
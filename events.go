@@ -0,0 +1,149 @@
+package pogreb
+
+import (
+	"sync"
+	"time"
+)
+
+// Event is a lifecycle event passed to Options.EventHandler.
+// It is one of SegmentCreated, SegmentSealed, SegmentCompacted, SegmentDeleted,
+// RecoveryStarted, RecoveryFinished and SyncCompleted.
+type Event interface {
+	event()
+}
+
+// SegmentCreated is emitted when a new datalog segment is created.
+// Segment names are relative to the database directory.
+type SegmentCreated struct {
+	Name       string
+	ID         uint16
+	SequenceID uint64
+}
+
+// SegmentSealed is emitted when the current segment is full and replaced by another segment.
+// The segment is synced and never written again, it can be copied, e.g. uploaded to cold storage.
+type SegmentSealed struct {
+	Name string
+	Size int64
+}
+
+// SegmentCompacted is emitted when compaction moved the live records out of a segment,
+// before the segment is deleted.
+type SegmentCompacted struct {
+	Name             string
+	ReclaimedRecords int
+	ReclaimedBytes   int
+}
+
+// SegmentDeleted is emitted when a segment is removed, by compaction or by DrainAndTruncate.
+type SegmentDeleted struct {
+	Name string
+}
+
+// RecoveryStarted is emitted when Open starts rebuilding the index,
+// because the database wasn't closed properly or it's being repaired.
+type RecoveryStarted struct {
+	Segments int // Number of segments to scan.
+}
+
+// RecoveryFinished is emitted when the recovery completed successfully.
+type RecoveryFinished struct {
+	RecordsReplayed int64
+	Duration        time.Duration
+}
+
+// SyncCompleted is emitted when the records written to the current segment were synced.
+type SyncCompleted struct {
+	Segment string
+	Size    int64 // Size of the segment synced.
+}
+
+func (SegmentCreated) event()   {}
+func (SegmentSealed) event()    {}
+func (SegmentCompacted) event() {}
+func (SegmentDeleted) event()   {}
+func (RecoveryStarted) event()  {}
+func (RecoveryFinished) event() {}
+func (SyncCompleted) event()    {}
+
+// eventDispatcher delivers events to the handler in the order they were emitted, on its own goroutine.
+// Events are emitted while DB locks are held, the handler is free to call DB methods.
+// A nil dispatcher discards events.
+type eventDispatcher struct {
+	handler func(Event)
+	mu      sync.Mutex
+	queue   []Event
+	closed  bool
+	wake    chan struct{}
+	done    chan struct{}
+}
+
+func newEventDispatcher(handler func(Event)) *eventDispatcher {
+	if handler == nil {
+		return nil
+	}
+	d := &eventDispatcher{
+		handler: handler,
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	go d.run()
+	return d
+}
+
+func (d *eventDispatcher) emit(e Event) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return
+	}
+	d.queue = append(d.queue, e)
+	d.mu.Unlock()
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (d *eventDispatcher) run() {
+	defer close(d.done)
+	for range d.wake {
+		for {
+			d.mu.Lock()
+			queue, closed := d.queue, d.closed
+			d.queue = nil
+			d.mu.Unlock()
+			if len(queue) == 0 {
+				if closed {
+					return
+				}
+				break
+			}
+			for _, e := range queue {
+				d.handler(e)
+			}
+		}
+	}
+}
+
+// close delivers the queued events and stops the dispatcher.
+func (d *eventDispatcher) close() {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return
+	}
+	d.closed = true
+	d.mu.Unlock()
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+	<-d.done
+}
@@ -0,0 +1,86 @@
+package pogreb
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/domaincrawler/pogreb/internal/assert"
+)
+
+type eventRecorder struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (r *eventRecorder) handle(e Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, e)
+}
+
+// take returns the recorded events and forgets them.
+func (r *eventRecorder) take() []Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	events := r.events
+	r.events = nil
+	return events
+}
+
+func TestEventHandler(t *testing.T) {
+	rec := &eventRecorder{}
+	opts := &Options{
+		maxSegmentSize:             1024,
+		compactionMinSegmentSize:   520,
+		compactionMinFragmentation: -1, // Compact every segment.
+		EventHandler:               rec.handle,
+	}
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+	// 73 records of 7 bytes fill the first segment.
+	for i := 0; i < 75; i++ {
+		assert.Nil(t, db.Put([]byte{byte(i)}))
+	}
+	assert.Nil(t, db.Sync())
+	_, err = db.Compact()
+	assert.Nil(t, err)
+	assert.Nil(t, db.Close())
+
+	assert.Equal(t, []Event{
+		SegmentCreated{Name: segmentName(0, 1), ID: 0, SequenceID: 1},
+		SegmentSealed{Name: segmentName(0, 1), Size: headerSize + 73*7},
+		SegmentCreated{Name: segmentName(1, 2), ID: 1, SequenceID: 2},
+		SyncCompleted{Segment: segmentName(1, 2), Size: headerSize + 2*7},
+		// Compaction copies the records of the first segment to the current segment, filling it.
+		SegmentSealed{Name: segmentName(1, 2), Size: headerSize + 73*7},
+		SegmentCreated{Name: segmentName(2, 3), ID: 2, SequenceID: 3},
+		SegmentCompacted{Name: segmentName(0, 1)},
+		SegmentDeleted{Name: segmentName(0, 1)},
+	}, rec.take()[:8])
+}
+
+func TestEventHandlerRecovery(t *testing.T) {
+	db, err := createTestDB(nil)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte{1}))
+	assert.Nil(t, db.Put([]byte{2}))
+	simulateCrash(t, db)
+
+	rec := &eventRecorder{}
+	db, err = Open(testDBName, &Options{FileSystem: testFS, EventHandler: rec.handle})
+	assert.Nil(t, err)
+	assert.Nil(t, db.Close())
+	var started, finished int
+	for _, e := range rec.take() {
+		switch e := e.(type) {
+		case RecoveryStarted:
+			started++
+			assert.Equal(t, 1, e.Segments)
+		case RecoveryFinished:
+			finished++
+			assert.Equal(t, int64(2), e.RecordsReplayed)
+		}
+	}
+	assert.Equal(t, 1, started)
+	assert.Equal(t, 1, finished)
+}
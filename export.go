@@ -0,0 +1,87 @@
+package pogreb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/csv"
+	"io"
+
+	"github.com/domaincrawler/pogreb/internal/errors"
+)
+
+// ExportFormat sets how keys are encoded in the output of Export.
+type ExportFormat int
+
+const (
+	// ExportLines writes one key per line, terminated by '\n'.
+	// Keys containing line breaks can't be told apart, ExportCSV or ExportLengthPrefixed should be used instead.
+	ExportLines ExportFormat = iota
+
+	// ExportCSV writes one key per line as a single CSV field, quoted when needed.
+	ExportCSV
+
+	// ExportLengthPrefixed writes keys prefixed with their length encoded as an unsigned varint,
+	// the encoding read by Import with ImportLengthPrefixed.
+	ExportLengthPrefixed
+)
+
+var errUnknownExportFormat = errors.New("unknown export format")
+
+// Export writes all keys to w in the format and returns the number of written keys.
+// Keys are written in the order of Items, keys written concurrently may or may not be exported.
+func (db *DB) Export(w io.Writer, format ExportFormat) (int64, error) {
+	bw := bufio.NewWriter(w)
+	var write func(key []byte) error
+	var cw *csv.Writer
+	switch format {
+	case ExportLines:
+		write = func(key []byte) error {
+			if _, err := bw.Write(key); err != nil {
+				return err
+			}
+			return bw.WriteByte('\n')
+		}
+	case ExportCSV:
+		cw = csv.NewWriter(bw)
+		record := make([]string, 1)
+		write = func(key []byte) error {
+			record[0] = string(key)
+			return cw.Write(record)
+		}
+	case ExportLengthPrefixed:
+		var sizeBuf [binary.MaxVarintLen64]byte
+		write = func(key []byte) error {
+			n := binary.PutUvarint(sizeBuf[:], uint64(len(key)))
+			if _, err := bw.Write(sizeBuf[:n]); err != nil {
+				return err
+			}
+			_, err := bw.Write(key)
+			return err
+		}
+	default:
+		return 0, errUnknownExportFormat
+	}
+
+	var count int64
+	it := db.Items()
+	for {
+		key, err := it.Next()
+		if err == ErrIterationDone {
+			break
+		}
+		if err != nil {
+			return count, err
+		}
+		if err := write(key); err != nil {
+			return count, errors.Wrap(err, "writing key")
+		}
+		count++
+	}
+	if cw != nil {
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			return count, errors.Wrap(err, "writing key")
+		}
+	}
+	return count, bw.Flush()
+}
@@ -0,0 +1,55 @@
+package pogreb
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/domaincrawler/pogreb/internal/assert"
+)
+
+func TestExport(t *testing.T) {
+	db, err := createTestDB(nil)
+	assert.Nil(t, err)
+	keys := []string{"a", "b,c", `d"e`}
+	for _, key := range keys {
+		assert.Nil(t, db.Put([]byte(key)))
+	}
+
+	export := func(format ExportFormat) string {
+		t.Helper()
+		buf := &bytes.Buffer{}
+		n, err := db.Export(buf, format)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(len(keys)), n)
+		return buf.String()
+	}
+	sortedLines := func(s string) []string {
+		lines := strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+		sort.Strings(lines)
+		return lines
+	}
+
+	assert.Equal(t, keys, sortedLines(export(ExportLines)))
+	assert.Equal(t, []string{`"b,c"`, `"d""e"`, "a"}, sortedLines(export(ExportCSV)))
+
+	// Length-prefixed output can be imported.
+	data := export(ExportLengthPrefixed)
+	assert.Equal(t, 3+1+3+3, len(data))
+	assert.Nil(t, db.Close())
+	db, err = createTestDB(nil)
+	assert.Nil(t, err)
+	progress, err := db.Import(strings.NewReader(data), &ImportOptions{Format: ImportLengthPrefixed})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(keys)), progress.Records)
+	for _, key := range keys {
+		has, err := db.Has([]byte(key))
+		assert.Nil(t, err)
+		assert.Equal(t, true, has)
+	}
+
+	_, err = db.Export(&bytes.Buffer{}, ExportFormat(-1))
+	assert.Equal(t, errUnknownExportFormat, err)
+	assert.Nil(t, db.Close())
+}
@@ -0,0 +1,203 @@
+package pogreb
+
+import (
+	"bytes"
+	"os"
+
+	"github.com/domaincrawler/pogreb/fs"
+	"github.com/domaincrawler/pogreb/internal/errors"
+)
+
+// MaxLocator is the maximum value of an external record locator.
+const MaxLocator = 1<<63 - 1
+
+var errLocatorTooLarge = errors.New("locator is too large")
+
+// ReadExternalKeyFunc returns the key of the externally stored record identified by the locator.
+type ReadExternalKeyFunc func(locator uint64) ([]byte, error)
+
+// ExternalIndex is a hash index of records stored outside of pogreb.
+// It maps keys to opaque locators (e.g. file offsets) supplied by the user, only the hash table index is stored.
+// All ExternalIndex methods are safe for concurrent use by multiple goroutines.
+type ExternalIndex struct {
+	opts     *Options
+	index    *shardedIndex
+	lock     fs.LockFile
+	hashSeed uint32
+	readKey  ReadExternalKeyFunc
+}
+
+// OpenExternalIndex opens or creates a new ExternalIndex.
+//
+// The index stores only key hashes, readKey is used to fetch keys from the external storage
+// to resolve hash collisions. When readKey is nil, keys with equal hashes are indistinguishable.
+//
+// The index doesn't have a write-ahead log to recover from.
+// If the index wasn't closed properly, has a corrupted header or was written in an older format,
+// it's reset and has to be repopulated from the external storage.
+// A corrupted bucket is reported by the lookups reading it as a CorruptionError.
+// The index must be closed after use, by calling Close method.
+func OpenExternalIndex(path string, opts *Options, readKey ReadExternalKeyFunc) (*ExternalIndex, error) {
+	opts = opts.copyWithDefaults(path)
+	if err := validateIndexOptions(opts); err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return nil, err
+	}
+
+	lock, acquiredExistingLock, err := createLockFile(opts)
+	if err != nil {
+		if errors.Is(err, os.ErrExist) {
+			err = ErrLocked
+		}
+		return nil, errors.Wrap(err, "creating lock file")
+	}
+	clean := lock.Unlock
+	defer func() {
+		if clean != nil {
+			_ = clean()
+		}
+	}()
+
+	if acquiredExistingLock {
+		opts.Logger.Logf(LogWarn, "external index wasn't closed properly, resetting index...")
+		if err := backupNonsegmentFiles(opts); err != nil {
+			return nil, err
+		}
+		if err := removeRecoveryBackupFiles(opts); err != nil {
+			return nil, err
+		}
+	}
+
+	index, err := openShardedIndex(opts)
+	if err != nil && indexNeedsRebuild(err) {
+		opts.Logger.Logf(LogWarn, "external index is corrupted or outdated, resetting index: %v", err)
+		if err := backupNonsegmentFiles(opts); err != nil {
+			return nil, err
+		}
+		if err := removeRecoveryBackupFiles(opts); err != nil {
+			return nil, err
+		}
+		index, err = openShardedIndex(opts)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "opening index")
+	}
+	clean = func() error {
+		index.closeFiles()
+		return lock.Unlock()
+	}
+
+	ei := &ExternalIndex{
+		opts:    opts,
+		index:   index,
+		lock:    lock,
+		readKey: readKey,
+	}
+	if index.count() == 0 {
+		seed, err := newHashSeed(opts)
+		if err != nil {
+			return nil, err
+		}
+		ei.hashSeed = seed
+	} else {
+		m := dbMeta{}
+		if err := readGobFile(opts.FileSystem, dbMetaName, &m); err != nil {
+			return nil, errors.Wrap(err, "reading index meta")
+		}
+		if opts.HashSeed != 0 && opts.HashSeed != m.HashSeed {
+			return nil, errHashSeedMismatch
+		}
+		ei.hashSeed = m.HashSeed
+	}
+
+	clean = nil
+	return ei, nil
+}
+
+// locatorSlot encodes the locator into the offset field of the slot normally pointing to a datalog record.
+// The highest bit of the offset is always set, a zero offset marks an empty slot.
+func locatorSlot(h uint64, locator uint64) slot {
+	return slot{
+		hash:   h,
+		offset: locator | 1<<63,
+	}
+}
+
+func slotLocator(sl slot) uint64 {
+	return sl.offset &^ (1 << 63)
+}
+
+func (ei *ExternalIndex) matchKey(key []byte) matchKeyFunc {
+	return func(sl slot) (bool, error) {
+		if ei.readKey == nil {
+			return true, nil
+		}
+		slKey, err := ei.readKey(slotLocator(sl))
+		if err != nil {
+			return true, err
+		}
+		return bytes.Equal(key, slKey), nil
+	}
+}
+
+// Has returns the locator of the record with the given key.
+// The returned bool is false if the index doesn't contain the key.
+func (ei *ExternalIndex) Has(key []byte) (uint64, bool, error) {
+	h := ei.index.hashAlgorithm().sum(key, ei.hashSeed)
+	shard := ei.index.shard(h)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	var locator uint64
+	found := false
+	match := ei.matchKey(key)
+	err := shard.get(h, func(sl slot) (bool, error) {
+		ok, err := match(sl)
+		if ok && err == nil {
+			locator = slotLocator(sl)
+			found = true
+		}
+		return ok, err
+	})
+	if err != nil {
+		return 0, false, err
+	}
+	return locator, found, nil
+}
+
+// Put sets the locator for the given key. It updates the locator for the existing key.
+func (ei *ExternalIndex) Put(key []byte, locator uint64) error {
+	if locator > MaxLocator {
+		return errLocatorTooLarge
+	}
+	h := ei.index.hashAlgorithm().sum(key, ei.hashSeed)
+	shard := ei.index.shard(h)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	return ei.index.put(shard, locatorSlot(h, locator), ei.matchKey(key))
+}
+
+// Count returns the number of keys in the index.
+func (ei *ExternalIndex) Count() uint64 {
+	return ei.index.count()
+}
+
+// Close closes the index.
+func (ei *ExternalIndex) Close() error {
+	for _, sh := range ei.index.shards {
+		sh.mu.Lock()
+		defer sh.mu.Unlock()
+	}
+	m := dbMeta{
+		HashSeed: ei.hashSeed,
+	}
+	if err := writeGobFile(ei.opts.FileSystem, dbMetaName, m); err != nil {
+		return err
+	}
+	if err := ei.index.close(); err != nil {
+		return err
+	}
+	return ei.lock.Unlock()
+}
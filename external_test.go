@@ -0,0 +1,119 @@
+package pogreb
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/domaincrawler/pogreb/internal/assert"
+)
+
+func createTestExternalIndex(opts *Options, readKey ReadExternalKeyFunc) (*ExternalIndex, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	if opts.FileSystem == nil {
+		opts.FileSystem = testFS
+	}
+	files, err := testFS.ReadDir(testDBName)
+	if err == nil {
+		for _, file := range files {
+			_ = testFS.Remove(filepath.Join(testDBName, file.Name()))
+		}
+	}
+	return OpenExternalIndex(testDBName, opts, readKey)
+}
+
+func TestLocatorSlot(t *testing.T) {
+	for _, loc := range []uint64{0, 1, 1<<31 - 1, 1 << 31, 1<<47 + 5, MaxLocator} {
+		sl := locatorSlot(1, loc)
+		if sl.offset == 0 {
+			t.Fatalf("empty slot for locator %d", loc)
+		}
+		assert.Equal(t, loc, slotLocator(sl))
+	}
+}
+
+func TestExternalIndex(t *testing.T) {
+	records := map[uint64][]byte{}
+	for i := uint64(0); i < 500; i++ {
+		records[i*1000] = []byte(fmt.Sprintf("key%d", i))
+	}
+	readKey := func(loc uint64) ([]byte, error) {
+		return records[loc], nil
+	}
+	opts := &Options{FileSystem: testFS}
+	ei, err := createTestExternalIndex(opts, readKey)
+	assert.Nil(t, err)
+	for loc, key := range records {
+		assert.Nil(t, ei.Put(key, loc))
+	}
+	assert.Equal(t, errLocatorTooLarge, ei.Put([]byte("foo"), MaxLocator+1))
+	assert.Equal(t, uint64(500), ei.Count())
+	assert.Nil(t, ei.Close())
+
+	ei, err = OpenExternalIndex(testDBName, opts, readKey)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(500), ei.Count())
+	for loc, key := range records {
+		gotLoc, found, err := ei.Has(key)
+		assert.Nil(t, err)
+		assert.Equal(t, true, found)
+		assert.Equal(t, loc, gotLoc)
+	}
+	_, found, err := ei.Has([]byte("missing"))
+	assert.Nil(t, err)
+	assert.Equal(t, false, found)
+
+	// Updating an existing key.
+	records[1] = records[0]
+	assert.Nil(t, ei.Put(records[0], 1))
+	assert.Equal(t, uint64(500), ei.Count())
+	gotLoc, found, err := ei.Has(records[0])
+	assert.Nil(t, err)
+	assert.Equal(t, true, found)
+	assert.Equal(t, uint64(1), gotLoc)
+	assert.Nil(t, ei.Close())
+
+	// Simulate crash.
+	assert.Nil(t, touchFile(testFS, filepath.Join(testDBName, lockName)))
+	ei, err = OpenExternalIndex(testDBName, opts, readKey)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), ei.Count())
+	for loc, key := range records {
+		assert.Nil(t, ei.Put(key, loc))
+	}
+	assert.Nil(t, ei.Close())
+
+	// A corrupted bucket is detected by the lookup.
+	f, err := testFS.OpenFile(filepath.Join(testDBName, indexMainName), os.O_RDWR, 0)
+	assert.Nil(t, err)
+	_, err = f.WriteAt([]byte("corrupted"), headerSize)
+	assert.Nil(t, err)
+	assert.Nil(t, f.Close())
+	ei, err = OpenExternalIndex(testDBName, opts, readKey)
+	assert.Nil(t, err)
+	var corruption *CorruptionError
+	var corrupted bool
+	for _, key := range records {
+		if _, _, err := ei.Has(key); err != nil {
+			assert.Equal(t, true, errors.As(err, &corruption))
+			corrupted = true
+		}
+	}
+	assert.Equal(t, true, corrupted)
+	assert.Nil(t, ei.Close())
+
+	// A corrupted index header is reset.
+	f, err = testFS.OpenFile(filepath.Join(testDBName, indexMainName), os.O_RDWR, 0)
+	assert.Nil(t, err)
+	_, err = f.WriteAt([]byte("corrupted"), 0)
+	assert.Nil(t, err)
+	assert.Nil(t, f.Close())
+	ei, err = OpenExternalIndex(testDBName, opts, readKey)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), ei.Count())
+	assert.Nil(t, ei.Close())
+}
@@ -11,16 +11,54 @@ import (
 // When stored in a file system, the file starts with a header.
 type file struct {
 	fs.File
-	size int64
+	name          string
+	size          int64
+	formatVersion uint32                    // Format version from the header.
+	flags         uint32                    // Header flags.
+	checksum      Checksum                  // Record checksum algorithm from the header.
+	archivedSize  int64                     // Size of the archived segment from the header of a stub.
+	nonce         [encryptionNonceSize]byte // Record nonce prefix from the header of an encrypted file.
+	keyCheck      [encryptionTagSize]byte   // Key check value from the header of an encrypted file.
+	stats         segmentStats              // Segment statistics from the header.
+	cipher        *recordCipher             // Decrypts the records of an encrypted file, set when the file is opened.
 }
 
+type openFileFunc func(name string, flag int, perm os.FileMode) (fs.File, error)
+
 func openFile(fsyst fs.FileSystem, name string, truncate bool) (*file, error) {
+	return openFileWith(fsyst.OpenFile, name, truncate)
+}
+
+// mmapOpenFunc returns the function opening memory-mapped files when the file system supports it.
+func mmapOpenFunc(fsyst fs.FileSystem) openFileFunc {
+	if mfs, ok := fsyst.(fs.MmapFileSystem); ok {
+		return mfs.OpenMmapFile
+	}
+	return fsyst.OpenFile
+}
+
+// writableMmapOpenFunc returns the function opening files memory-mapped for writing when the file system supports it.
+func writableMmapOpenFunc(fsyst fs.FileSystem) openFileFunc {
+	if wfs, ok := fsyst.(fs.WritableMmapFileSystem); ok {
+		return wfs.OpenWritableMmapFile
+	}
+	return fsyst.OpenFile
+}
+
+// withFlag returns the open function passing additional flags.
+func withFlag(open openFileFunc, extra int) openFileFunc {
+	return func(name string, flag int, perm os.FileMode) (fs.File, error) {
+		return open(name, flag|extra, perm)
+	}
+}
+
+func openFileWith(open openFileFunc, name string, truncate bool) (*file, error) {
 	flag := os.O_CREATE | os.O_RDWR
 	if truncate {
 		flag |= os.O_TRUNC
 	}
-	fi, err := fsyst.OpenFile(name, flag, os.FileMode(0640))
-	f := &file{}
+	fi, err := open(name, flag, os.FileMode(0640))
+	f := &file{name: name}
 	if err != nil {
 		return f, err
 	}
@@ -43,6 +81,9 @@ func openFile(fsyst fs.FileSystem, name string, truncate bool) (*file, error) {
 		}
 	} else {
 		if err := f.readHeader(); err != nil {
+			if err == ErrCorrupted {
+				err = &CorruptionError{Segment: name, Reason: "invalid file signature"}
+			}
 			return nil, err
 		}
 	}
@@ -55,6 +96,8 @@ func openFile(fsyst fs.FileSystem, name string, truncate bool) (*file, error) {
 
 func (f *file) writeHeader() error {
 	h := newHeader()
+	f.formatVersion = h.formatVersion
+	f.checksum = h.checksum
 	data, err := h.MarshalBinary()
 	if err != nil {
 		return err
@@ -71,19 +114,74 @@ func (f *file) readHeader() error {
 	if _, err := io.ReadFull(f, buf); err != nil {
 		return err
 	}
-	return h.UnmarshalBinary(buf)
+	if err := h.UnmarshalBinary(buf); err != nil {
+		return err
+	}
+	f.formatVersion = h.formatVersion
+	f.flags = h.flags
+	f.checksum = h.checksum
+	f.archivedSize = h.archivedSize
+	f.nonce = h.nonce
+	f.keyCheck = h.keyCheck
+	f.stats = h.stats
+	return nil
+}
+
+// setHeader rewrites the header with the flags and the checksum algorithm.
+// The encryption fields and the segment statistics of the file are kept.
+func (f *file) setHeader(flags uint32, checksum Checksum) error {
+	h := newHeader()
+	h.flags = flags
+	h.checksum = checksum
+	h.nonce = f.nonce
+	h.keyCheck = f.keyCheck
+	h.stats = f.stats
+	data, err := h.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteAt(data, 0); err != nil {
+		return err
+	}
+	f.formatVersion = h.formatVersion
+	f.flags = flags
+	f.checksum = checksum
+	return nil
+}
+
+// setStats rewrites the header with the segment statistics, the other fields of the header are kept.
+func (f *file) setStats(stats segmentStats) error {
+	h := &header{
+		signature:     signature,
+		formatVersion: f.formatVersion,
+		flags:         f.flags,
+		checksum:      f.checksum,
+		archivedSize:  f.archivedSize,
+		nonce:         f.nonce,
+		keyCheck:      f.keyCheck,
+		stats:         stats,
+	}
+	data, err := h.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteAt(data, 0); err != nil {
+		return err
+	}
+	f.stats = stats
+	return nil
 }
 
 func (f *file) empty() bool {
 	return f.size == int64(headerSize)
 }
 
-func (f *file) extend(size uint32) (int64, error) {
+func (f *file) extend(size int64) (int64, error) {
 	off := f.size
-	if err := f.Truncate(off + int64(size)); err != nil {
+	if err := f.Truncate(off + size); err != nil {
 		return 0, err
 	}
-	f.size += int64(size)
+	f.size += size
 	return off, nil
 }
 
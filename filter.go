@@ -0,0 +1,281 @@
+package pogreb
+
+import (
+	"encoding/binary"
+	"os"
+	"strconv"
+)
+
+const (
+	filterBucketSize = 4   // Number of fingerprints in a filter bucket.
+	filterMaxKicks   = 500 // Number of fingerprints relocated by an insertion before the filter is rebuilt larger.
+	filterMinBuckets = 64
+	filterLoadFactor = 0.9 // Load of a filter sized for the number of keys of the index.
+)
+
+// cuckooFilter is an approximate set of the slot hashes of an index, see Options.MembershipFilter.
+// A hash is stored as a 16-bit fingerprint in one of its two buckets, a lookup compares the fingerprints
+// of both buckets, which gives a false-positive probability of at most 2*filterBucketSize/2^16.
+// A hash of multiple slots is stored once per slot, so that removing one of the slots keeps the hash.
+// A nil cuckooFilter contains every hash.
+type cuckooFilter struct {
+	fps   []uint16 // Fingerprints of the buckets, 0 marks an empty entry.
+	mask  uint32   // Number of buckets minus one, the number of buckets is a power of two.
+	count uint64   // Number of stored fingerprints.
+	kicks uint32   // Number of relocated fingerprints, selects the entry evicted next.
+}
+
+// newCuckooFilter returns an empty filter sized for the number of hashes.
+func newCuckooFilter(capacity uint64) *cuckooFilter {
+	n := uint64(filterMinBuckets)
+	for float64(n*filterBucketSize)*filterLoadFactor < float64(capacity) {
+		n <<= 1
+	}
+	return &cuckooFilter{fps: make([]uint16, n*filterBucketSize), mask: uint32(n - 1)}
+}
+
+// filterMix scrambles the hash, the bits of slot hashes are also used to select index buckets and shards.
+func filterMix(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+// locate returns the fingerprint and the two buckets of the hash.
+func (f *cuckooFilter) locate(h uint64) (uint16, uint32, uint32) {
+	x := filterMix(h)
+	fp := uint16(x >> 48)
+	if fp == 0 {
+		fp = 1
+	}
+	i := uint32(x) & f.mask
+	return fp, i, f.altBucket(i, fp)
+}
+
+// altBucket returns the other bucket of the fingerprint stored in the bucket.
+func (f *cuckooFilter) altBucket(i uint32, fp uint16) uint32 {
+	return (i ^ uint32(filterMix(uint64(fp)))) & f.mask
+}
+
+func (f *cuckooFilter) bucket(i uint32) []uint16 {
+	return f.fps[i*filterBucketSize : (i+1)*filterBucketSize]
+}
+
+func (f *cuckooFilter) insertInto(i uint32, fp uint16) bool {
+	b := f.bucket(i)
+	for j := range b {
+		if b[j] == 0 {
+			b[j] = fp
+			return true
+		}
+	}
+	return false
+}
+
+func (f *cuckooFilter) bucketHas(i uint32, fp uint16) bool {
+	for _, v := range f.bucket(i) {
+		if v == fp {
+			return true
+		}
+	}
+	return false
+}
+
+// contains returns false if the hash was definitely not added to the filter.
+func (f *cuckooFilter) contains(h uint64) bool {
+	if f == nil {
+		return true
+	}
+	fp, i1, i2 := f.locate(h)
+	return f.bucketHas(i1, fp) || f.bucketHas(i2, fp)
+}
+
+// add inserts the hash. It returns false if the filter is too full,
+// the filter then misses one of the hashes added before and must be rebuilt.
+func (f *cuckooFilter) add(h uint64) bool {
+	if f == nil {
+		return true
+	}
+	fp, i1, i2 := f.locate(h)
+	f.count++
+	if f.insertInto(i1, fp) || f.insertInto(i2, fp) {
+		return true
+	}
+	i := i1
+	for n := 0; n < filterMaxKicks; n++ {
+		f.kicks++
+		j := i*filterBucketSize + f.kicks%filterBucketSize
+		fp, f.fps[j] = f.fps[j], fp
+		i = f.altBucket(i, fp)
+		if f.insertInto(i, fp) {
+			return true
+		}
+	}
+	return false
+}
+
+// remove deletes one copy of the hash.
+func (f *cuckooFilter) remove(h uint64) {
+	if f == nil {
+		return
+	}
+	fp, i1, i2 := f.locate(h)
+	for _, i := range [2]uint32{i1, i2} {
+		b := f.bucket(i)
+		for j := range b {
+			if b[j] == fp {
+				b[j] = 0
+				f.count--
+				return
+			}
+		}
+	}
+}
+
+// filterMeta is the contents of the filter file of an index shard, written by Close.
+type filterMeta struct {
+	NumKeys      uint64 // Number of keys of the index when the filter was written.
+	NumBuckets   uint32
+	Fingerprints []byte // Little-endian 16-bit fingerprints.
+}
+
+func (f *cuckooFilter) meta(numKeys uint64) filterMeta {
+	data := make([]byte, 2*len(f.fps))
+	for i, fp := range f.fps {
+		binary.LittleEndian.PutUint16(data[2*i:], fp)
+	}
+	return filterMeta{NumKeys: numKeys, NumBuckets: f.mask + 1, Fingerprints: data}
+}
+
+// filterFromMeta returns the filter stored in the file, or nil if it doesn't hold the keys of the index.
+func filterFromMeta(m filterMeta, numKeys uint64) *cuckooFilter {
+	n := uint64(m.NumBuckets)
+	if m.NumKeys != numKeys || n < filterMinBuckets || n&(n-1) != 0 || uint64(len(m.Fingerprints)) != 2*n*filterBucketSize {
+		return nil
+	}
+	f := &cuckooFilter{fps: make([]uint16, n*filterBucketSize), mask: uint32(n - 1)}
+	for i := range f.fps {
+		f.fps[i] = binary.LittleEndian.Uint16(m.Fingerprints[2*i:])
+		if f.fps[i] != 0 {
+			f.count++
+		}
+	}
+	if f.count != numKeys {
+		return nil
+	}
+	return f
+}
+
+// filterFileName returns the name of the filter file of the index shard.
+func filterFileName(shardID int) string {
+	if shardID == 0 {
+		return "filter" + metaExt
+	}
+	return "filter-" + strconv.Itoa(shardID) + metaExt
+}
+
+// openFilter loads the filter written by the last Close, or builds it from the index.
+// A writable index removes the file, it's outdated by the first write.
+func (idx *index) openFilter() error {
+	if idx.opts.MembershipFilter {
+		f, err := idx.readFilter()
+		if err != nil {
+			return err
+		}
+		idx.filter = f
+	}
+	if !idx.opts.ReadOnly {
+		if err := idx.opts.FileSystem.Remove(idx.filterName); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if idx.opts.MembershipFilter && idx.filter == nil {
+		return idx.rebuildFilter(idx.numKeys)
+	}
+	return nil
+}
+
+// readFilter returns the filter written by the last Close, or nil if there is no valid filter file.
+func (idx *index) readFilter() (*cuckooFilter, error) {
+	if _, err := idx.opts.FileSystem.Stat(idx.filterName); err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	m := filterMeta{}
+	if err := readGobFile(idx.opts.FileSystem, idx.filterName, &m); err != nil {
+		return nil, err
+	}
+	return filterFromMeta(m, idx.numKeys), nil
+}
+
+// rebuildFilter replaces the filter with a filter of the slot hashes of the index sized for at least the capacity.
+func (idx *index) rebuildFilter(capacity uint64) error {
+	for {
+		f := newCuckooFilter(capacity)
+		if uint64(len(f.fps)) > 16*(idx.numKeys+filterMinBuckets) {
+			// Too many slots share a hash for the buckets to hold their fingerprints.
+			idx.opts.Logger.Logf(LogWarn, "disabling the membership filter of %s, too many hash collisions", idx.metaName)
+			idx.filter = nil
+			return nil
+		}
+		ok, err := idx.fillFilter(f)
+		if err != nil {
+			return err
+		}
+		if ok {
+			idx.filter = f
+			return nil
+		}
+		// The filter of the next size up, see newCuckooFilter.
+		capacity = uint64(len(f.fps))
+	}
+}
+
+func (idx *index) fillFilter(f *cuckooFilter) (bool, error) {
+	for bucketIdx := uint32(0); bucketIdx < idx.numBuckets; bucketIdx++ {
+		it := idx.newBucketIterator(bucketIdx)
+		for {
+			b, err := it.next()
+			if err == ErrIterationDone {
+				break
+			}
+			if err != nil {
+				return false, err
+			}
+			for i := 0; i < numSlots(b.wideHash()); i++ {
+				sl := b.slots[i]
+				if sl.offset == 0 {
+					break
+				}
+				if !f.add(sl.hash) {
+					return false, nil
+				}
+			}
+		}
+	}
+	return true, nil
+}
+
+// filterAdd adds the hash of a new slot to the filter, rebuilding the filter twice as large when it's full.
+func (idx *index) filterAdd(h uint64) error {
+	f := idx.filter
+	if f == nil {
+		return nil
+	}
+	if f.add(h) && float64(f.count) <= filterLoadFactor*float64(len(f.fps)) {
+		return nil
+	}
+	return idx.rebuildFilter(2 * f.count)
+}
+
+func (idx *index) writeFilter() error {
+	if idx.filter == nil {
+		return nil
+	}
+	return writeGobFile(idx.opts.FileSystem, idx.filterName, idx.filter.meta(idx.numKeys))
+}
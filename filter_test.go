@@ -0,0 +1,122 @@
+package pogreb
+
+import (
+	"testing"
+
+	"github.com/domaincrawler/pogreb/internal/assert"
+)
+
+func TestCuckooFilter(t *testing.T) {
+	f := newCuckooFilter(0)
+	n := 0
+	for i := uint64(0); ; i++ {
+		if !f.add(i) {
+			break
+		}
+		n++
+	}
+	// The filter fills up close to its capacity.
+	assert.Equal(t, true, n > len(f.fps)*9/10)
+	f = newCuckooFilter(10000)
+	for i := uint64(0); i < 10000; i++ {
+		assert.Equal(t, true, f.add(i))
+	}
+	assert.Equal(t, true, f.add(7))
+	falsePositives := 0
+	for i := uint64(0); i < 10000; i++ {
+		assert.Equal(t, true, f.contains(i))
+		if f.contains(i + 1<<40) {
+			falsePositives++
+		}
+	}
+	assert.Equal(t, true, falsePositives < 10)
+
+	// A hash added twice is removed once per slot.
+	f.remove(7)
+	assert.Equal(t, true, f.contains(7))
+	f.remove(7)
+	f.remove(8)
+	assert.Equal(t, false, f.contains(7) && f.contains(8))
+	assert.Equal(t, uint64(9998), f.count)
+
+	var nilFilter *cuckooFilter
+	assert.Equal(t, true, nilFilter.contains(1))
+}
+
+func TestMembershipFilter(t *testing.T) {
+	opts := &Options{MembershipFilter: true, IndexShards: 2}
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 5000; i++ {
+		assert.Nil(t, db.Put(deleteTestKey(i)))
+	}
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Delete(deleteTestKey(i)))
+	}
+	for i := 0; i < 10000; i++ {
+		assertHas(t, db, deleteTestKey(i), i >= 100 && i < 5000)
+	}
+	// Nearly all missing keys are rejected by the filter.
+	assert.Equal(t, true, db.Metrics().FilterNegatives.Value() > 5000)
+	assert.Nil(t, db.Close())
+
+	// The filter is loaded from the files written by Close.
+	for i := range db.index.shards {
+		_, err := testFS.Stat(testDBName + "/" + filterFileName(i))
+		assert.Nil(t, err)
+	}
+	db, err = Open(testDBName, opts)
+	assert.Nil(t, err)
+	for _, sh := range db.index.shards {
+		assert.Equal(t, sh.numKeys, sh.filter.count)
+	}
+	assertHas(t, db, deleteTestKey(4999), true)
+	assert.Nil(t, db.DrainAndTruncate(func([][]byte) error { return nil }, 100))
+	assertHas(t, db, deleteTestKey(4999), false)
+	assert.Nil(t, db.Put(deleteTestKey(1)))
+	assertHas(t, db, deleteTestKey(1), true)
+	assert.Nil(t, db.Close())
+
+	// A DB opened without the filter removes the outdated files.
+	db, err = Open(testDBName, &Options{FileSystem: testFS, IndexShards: 2})
+	assert.Nil(t, err)
+	_, err = testFS.Stat(testDBName + "/" + filterFileName(0))
+	assert.NotNil(t, err)
+	assert.Nil(t, db.Put(deleteTestKey(2)))
+	assert.Nil(t, db.Close())
+	db, err = Open(testDBName, opts)
+	assert.Nil(t, err)
+	assertHas(t, db, deleteTestKey(2), true)
+	assert.Nil(t, db.Close())
+}
+
+func TestMembershipFilterCompaction(t *testing.T) {
+	opts := &Options{
+		MembershipFilter:           true,
+		compactionMinSegmentSize:   512,
+		compactionMinFragmentation: -1, // Compact every segment.
+	}
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(deleteTestKey(i)))
+	}
+	for i := 0; i < 900; i++ {
+		assert.Nil(t, db.Delete(deleteTestKey(i)))
+	}
+	_, err = db.Compact()
+	assert.Nil(t, err)
+	for i := 0; i < 1000; i++ {
+		assertHas(t, db, deleteTestKey(i), i >= 900)
+	}
+	simulateCrash(t, db)
+
+	// The filter is rebuilt from the recovered index.
+	db, err = Open(testDBName, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(100), db.index.shards[0].filter.count)
+	for i := 0; i < 1000; i++ {
+		assertHas(t, db, deleteTestKey(i), i >= 900)
+	}
+	assert.Nil(t, db.Close())
+}
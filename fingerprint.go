@@ -0,0 +1,56 @@
+package pogreb
+
+import (
+	"crypto/sha256"
+
+	"github.com/domaincrawler/pogreb/internal/errors"
+)
+
+const (
+	defaultFingerprintSize = 16
+	minFingerprintSize     = 8
+	maxFingerprintSize     = sha256.Size
+)
+
+var (
+	errInvalidFingerprintSize = errors.New("fingerprint size must be between 8 and 32 bytes")
+	errFingerprintMismatch    = errors.New("databases store different key fingerprints")
+)
+
+// initFingerprints sets the size of the key fingerprints stored by the DB, see Options.StoreFingerprintsOnly.
+// An existing non-empty DB keeps storing the fingerprints it was created with, which are read from the meta.
+func (db *DB) initFingerprints(existing bool) error {
+	if !db.opts.StoreFingerprintsOnly {
+		return nil
+	}
+	size := db.opts.FingerprintSize
+	if size < minFingerprintSize || size > maxFingerprintSize {
+		return errInvalidFingerprintSize
+	}
+	if existing && db.fingerprintSize != size {
+		return errFingerprintMismatch
+	}
+	db.fingerprintSize = size
+	return nil
+}
+
+// fingerprint returns the key as it is stored in the DB:
+// the truncated SHA-256 digest of the key if the DB stores fingerprints, or the key itself.
+func (db *DB) fingerprint(key []byte) []byte {
+	if db.fingerprintSize == 0 {
+		return key
+	}
+	sum := sha256.Sum256(key)
+	return sum[:db.fingerprintSize]
+}
+
+// lookupKeys returns the stored keys to look up, see lookupKey. It drops the keys rejected by a transform.
+func (db *DB) lookupKeys(keys [][]byte) [][]byte {
+	stored := make([][]byte, 0, len(keys))
+	for _, key := range keys {
+		if key, ok := db.lookupKey(key); ok {
+			stored = append(stored, key)
+		}
+	}
+	return stored
+}
@@ -0,0 +1,93 @@
+package pogreb
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/domaincrawler/pogreb/internal/assert"
+	"github.com/domaincrawler/pogreb/internal/errors"
+)
+
+func TestStoreFingerprintsOnly(t *testing.T) {
+	opts := &Options{StoreFingerprintsOnly: true}
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(encryptionTestKey(i)))
+	}
+	found, err := db.HasOrPut(encryptionTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, true, found)
+	assert.Nil(t, db.Delete(encryptionTestKey(0)))
+	assertHas(t, db, encryptionTestKey(0), false)
+	assertHas(t, db, encryptionTestKey(1), true)
+	all, err := db.HasAll([][]byte{encryptionTestKey(1), encryptionTestKey(99)})
+	assert.Nil(t, err)
+	assert.Equal(t, true, all)
+
+	// Items return the fingerprints.
+	it := db.Items()
+	for {
+		k, err := it.Next()
+		if err == ErrIterationDone {
+			break
+		}
+		assert.Nil(t, err)
+		assert.Equal(t, defaultFingerprintSize, len(k))
+	}
+	assert.Nil(t, db.Close())
+
+	data := readTestFile(t, segmentName(0, 1))
+	assert.Equal(t, false, bytes.Contains(data, []byte("example.com")))
+
+	// The mode is stored in the meta of the DB.
+	db, err = Open(testDBName, &Options{FileSystem: testFS})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(99), db.Count())
+	assertHas(t, db, encryptionTestKey(99), true)
+	assert.Nil(t, db.Put(encryptionTestKey(100)))
+	assert.Equal(t, uint64(100), db.Count())
+	assert.Nil(t, db.Close())
+
+	_, err = Open(testDBName, &Options{FileSystem: testFS, StoreFingerprintsOnly: true, FingerprintSize: 8})
+	assert.Equal(t, true, errors.Is(err, errFingerprintMismatch))
+	_, err = Open(testDBName, &Options{FileSystem: testFS, StoreFingerprintsOnly: true, FingerprintSize: 4})
+	assert.Equal(t, true, errors.Is(err, errInvalidFingerprintSize))
+}
+
+func TestStoreFingerprintsOnlyMerge(t *testing.T) {
+	plainPath, fpsPath := testDBName+".plain", testDBName+".fps"
+	defer removeMergeSource(t, plainPath)
+	defer removeMergeSource(t, fpsPath)
+	db, err := createTestDB(&Options{StoreFingerprintsOnly: true})
+	assert.Nil(t, err)
+	defer db.Close()
+	plain, err := Open(plainPath, &Options{FileSystem: testFS})
+	assert.Nil(t, err)
+	defer plain.Close()
+	fps, err := Open(fpsPath, &Options{FileSystem: testFS, StoreFingerprintsOnly: true})
+	assert.Nil(t, err)
+	defer fps.Close()
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, plain.Put(encryptionTestKey(i)))
+		assert.Nil(t, fps.Put(encryptionTestKey(i+5)))
+	}
+
+	// Keys are fingerprinted, fingerprints are copied as is.
+	res, err := db.MergeFrom(plain)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(10), res.Inserted)
+	res, err = db.MergeFrom(fps)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5), res.Inserted)
+	assert.Equal(t, uint64(15), db.Count())
+	assertHas(t, db, encryptionTestKey(14), true)
+
+	_, err = plain.MergeFrom(fps)
+	assert.Equal(t, true, errors.Is(err, errFingerprintMismatch))
+	err = plain.Diff(fps, func([]byte) error { return nil })
+	assert.Equal(t, true, errors.Is(err, errFingerprintMismatch))
+	n := 0
+	assert.Nil(t, fps.Intersect(db, func([]byte) error { n++; return nil }))
+	assert.Equal(t, 10, n)
+}
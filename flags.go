@@ -0,0 +1,240 @@
+package pogreb
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/domaincrawler/pogreb/internal/errors"
+)
+
+// recordFlagsSize is the size of the flags stored after every record of segments with headerFlagRecordFlags.
+const recordFlagsSize = 2
+
+var (
+	errRecordFlagsDisabled   = errors.New("record flags require Options.RecordFlags")
+	errRecordFlagsEncryption = errors.New("record flags can't be used with Options.EncryptionKey")
+)
+
+func appendRecordFlags(data []byte, flags uint16) []byte {
+	var buf [recordFlagsSize]byte
+	binary.LittleEndian.PutUint16(buf[:], flags)
+	return append(data, buf[:]...)
+}
+
+// patch overwrites the cached segment data at the offset.
+func (c *tailCache) patch(offset int64, data []byte) {
+	if offset < c.offset || offset+int64(len(data)) > c.offset+int64(len(c.data)) {
+		return
+	}
+	copy(c.data[offset-c.offset:], data)
+}
+
+// flagsOffset returns the offset of the flags of the put record the slot points to.
+// The caller must hold the datalog lock.
+func (seg *segment) flagsOffset(sl slot) (int64, error) {
+	off := int64(sl.offset)
+	if sl.keySize == largeKeyMarker && seg.largeKeys() {
+		sizeBuf, err := seg.slice(off+2, off+6)
+		if err != nil {
+			return 0, err
+		}
+		keySize := int64(binary.LittleEndian.Uint32(sizeBuf))
+		return off + largeKeyHeaderSize + keySize + int64(seg.recordOverhead()) + 4, nil
+	}
+	return off + int64(encodedRecordSize(uint32(sl.keySize))+seg.recordOverhead()), nil
+}
+
+// readFlags returns the flags of the record the slot points to, 0 if the segment doesn't store flags.
+func (dl *datalog) readFlags(sl slot) (uint16, error) {
+	dl.mu.RLock()
+	defer dl.mu.RUnlock()
+	return dl.readFlagsLocked(sl)
+}
+
+// readFlagsLocked is readFlags for callers holding the datalog lock.
+func (dl *datalog) readFlagsLocked(sl slot) (uint16, error) {
+	seg := dl.segments[sl.segmentID]
+	if seg.recordFlagsSize() == 0 {
+		return 0, nil
+	}
+	off, err := seg.flagsOffset(sl)
+	if err != nil {
+		return 0, err
+	}
+	data, err := seg.slice(off, off+recordFlagsSize)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint16(data), nil
+}
+
+// writeFlags overwrites the flags of the record the slot points to. It returns false if the segment
+// doesn't store flags or can't be modified, the record has to be written again with the flags then.
+// Flags of a segment other than the current segment are synced if every write is synced.
+func (dl *datalog) writeFlags(sl slot, flags uint16) (bool, error) {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	seg := dl.segments[sl.segmentID]
+	if seg.recordFlagsSize() == 0 || seg.archived() {
+		return false, nil
+	}
+	off, err := seg.flagsOffset(sl)
+	if err != nil {
+		return false, err
+	}
+	data := appendRecordFlags(nil, flags)
+	if _, err := seg.WriteAt(data, off); err != nil {
+		return false, err
+	}
+	if seg.tail != nil {
+		seg.tail.patch(off, data)
+	}
+	if dl.opts.SyncPolicy == SyncAlways && seg != dl.curSeg {
+		if err := seg.Sync(); err != nil {
+			return false, err
+		}
+		dl.metrics.FsyncCount.Add(1)
+	}
+	return true, nil
+}
+
+// findSlot returns the slot of the key. The caller must hold the shard lock.
+func (db *DB) findSlot(shard *indexShard, h uint64, key []byte) (slot, bool, error) {
+	var found slot
+	ok := false
+	err := shard.get(h, func(sl slot) (bool, error) {
+		if slotKeySize(key) != sl.keySize {
+			return false, nil
+		}
+		match, err := db.datalog.keyEqual(sl, key)
+		if err != nil || !match {
+			return err != nil, err
+		}
+		found, ok = sl, true
+		return true, nil
+	})
+	return found, ok, err
+}
+
+// PutWithFlags stores the key with the 16-bit flags, e.g. marking a URL as fetched or blocked by robots.txt.
+// The flags of a stored key are overwritten in place, the key isn't written again unless its record
+// was written without flags support, see Options.RecordFlags. Put and HasOrPut write keys with no flags.
+func (db *DB) PutWithFlags(key []byte, flags uint16) error {
+	if !db.opts.RecordFlags {
+		return errRecordFlagsDisabled
+	}
+	key, err := db.checkKey(key)
+	if err != nil {
+		return err
+	}
+	if db.metrics.PutLatency != nil {
+		defer db.metrics.PutLatency.since(time.Now())
+	}
+	db.metrics.Puts.Add(1)
+	written, err := db.putFlags(key, flags)
+	if err != nil {
+		return err
+	}
+	if written {
+		db.writeChain.after(key)
+	}
+	return nil
+}
+
+// putFlags updates the flags of the stored key or writes the key with the flags.
+// It returns true if the key was written.
+func (db *DB) putFlags(key []byte, flags uint16) (bool, error) {
+	h := db.hash(key)
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	shard := db.index.shard(h)
+	shard.mu.Lock()
+	sl, found, err := db.findSlot(shard, h, key)
+	written := false
+	if err == nil {
+		written, err = db.setFlags(shard, h, key, sl, found, flags)
+	}
+	shard.mu.Unlock()
+	if err != nil {
+		return false, err
+	}
+	return written, db.commit()
+}
+
+// setFlags overwrites the flags of the found slot of the key, or writes the key with the flags.
+// It returns true if the key was written. The caller must hold the shard write lock.
+func (db *DB) setFlags(shard *indexShard, h uint64, key []byte, sl slot, found bool, flags uint16) (bool, error) {
+	if found {
+		updated, err := db.datalog.writeFlags(sl, flags)
+		if err != nil || updated {
+			return false, err
+		}
+	}
+	return true, db.writeFlags(shard, h, key, flags)
+}
+
+// CompareAndSetFlags sets the flags of the key to the flags if they are equal to expect and returns true,
+// e.g. so that a single crawler worker claims a URL. It returns false if the flags differ or the key isn't stored.
+// The comparison and the update hold the shard write lock, they are atomic with respect to the other writes.
+func (db *DB) CompareAndSetFlags(key []byte, expect uint16, flags uint16) (bool, error) {
+	if !db.opts.RecordFlags {
+		return false, errRecordFlagsDisabled
+	}
+	if err := db.checkWritable(); err != nil {
+		return false, err
+	}
+	key, ok := db.lookupKey(key)
+	if !ok {
+		return false, nil
+	}
+	h := db.hash(key)
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	shard := db.index.shard(h)
+	shard.mu.Lock()
+	swapped, err := db.compareAndSetFlags(shard, h, key, expect, flags)
+	shard.mu.Unlock()
+	if err != nil || !swapped {
+		return false, err
+	}
+	return true, db.commit()
+}
+
+func (db *DB) compareAndSetFlags(shard *indexShard, h uint64, key []byte, expect uint16, flags uint16) (bool, error) {
+	sl, found, err := db.findSlot(shard, h, key)
+	if err != nil || !found {
+		return false, err
+	}
+	cur, err := db.datalog.readFlags(sl)
+	if err != nil || cur != expect {
+		return false, err
+	}
+	_, err = db.setFlags(shard, h, key, sl, true, flags)
+	return err == nil, err
+}
+
+// HasFlags returns true and the flags of the key if the DB contains the key.
+// Keys written without flags have no flags set.
+func (db *DB) HasFlags(key []byte) (bool, uint16, error) {
+	key, ok := db.lookupKey(key)
+	if !ok || db.domainMismatch {
+		return false, 0, nil
+	}
+	h := db.hash(key)
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	shard := db.index.shard(h)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	sl, found, err := db.findSlot(shard, h, key)
+	db.metrics.Gets.Add(1)
+	if err != nil || !found {
+		if err == nil {
+			db.metrics.Misses.Add(1)
+		}
+		return false, 0, err
+	}
+	db.metrics.Hits.Add(1)
+	flags, err := db.datalog.readFlags(sl)
+	return err == nil, flags, err
+}
@@ -0,0 +1,166 @@
+package pogreb
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+
+	"github.com/domaincrawler/pogreb/internal/assert"
+)
+
+const (
+	testFlagFetched = 1 << iota
+	testFlagRobotsBlocked
+)
+
+func assertFlags(t *testing.T, db *DB, key []byte, expected uint16) {
+	t.Helper()
+	has, flags, err := db.HasFlags(key)
+	assert.Nil(t, err)
+	assert.Equal(t, true, has)
+	assert.Equal(t, expected, flags)
+}
+
+func TestRecordFlags(t *testing.T) {
+	opts := &Options{RecordFlags: true, LargeKeys: true, TailCacheSize: 4096}
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+	large := bytes.Repeat([]byte{'l'}, MaxKeyLength+1)
+	assert.Nil(t, db.PutWithFlags([]byte("a"), testFlagFetched))
+	assert.Nil(t, db.PutWithFlags(large, testFlagRobotsBlocked))
+	assert.Nil(t, db.Put([]byte("b")))
+	assertFlags(t, db, []byte("a"), testFlagFetched)
+	assertFlags(t, db, large, testFlagRobotsBlocked)
+	assertFlags(t, db, []byte("b"), 0)
+	has, _, err := db.HasFlags([]byte("c"))
+	assert.Nil(t, err)
+	assert.Equal(t, false, has)
+
+	// Flags of stored keys are updated in place.
+	size := db.datalog.curSeg.size
+	assert.Nil(t, db.PutWithFlags([]byte("a"), testFlagFetched|testFlagRobotsBlocked))
+	assert.Nil(t, db.PutWithFlags(large, 0))
+	assert.Equal(t, size, db.datalog.curSeg.size)
+	assertFlags(t, db, []byte("a"), testFlagFetched|testFlagRobotsBlocked)
+	assertFlags(t, db, large, 0)
+
+	// Put writes the key again with no flags.
+	assert.Nil(t, db.Put([]byte("a")))
+	assertFlags(t, db, []byte("a"), 0)
+	assert.Nil(t, db.PutWithFlags([]byte("a"), testFlagFetched))
+	assert.Equal(t, uint64(3), db.Count())
+	assert.Nil(t, db.Close())
+
+	// Flags are read from the segments and kept by compaction.
+	db, err = Open(testDBName, opts)
+	assert.Nil(t, err)
+	assertFlags(t, db, []byte("a"), testFlagFetched)
+	assertFlags(t, db, large, 0)
+	db.opts.compactionMinSegmentSize = 0
+	db.opts.compactionMinFragmentation = -1
+	_, err = db.Compact()
+	assert.Nil(t, err)
+	assertFlags(t, db, []byte("a"), testFlagFetched)
+	assert.Nil(t, db.PutWithFlags([]byte("b"), testFlagRobotsBlocked))
+
+	// Recovery reads the flags.
+	simulateCrash(t, db)
+	db, err = Open(testDBName, opts)
+	assert.Nil(t, err)
+	assertFlags(t, db, []byte("a"), testFlagFetched)
+	assertFlags(t, db, []byte("b"), testFlagRobotsBlocked)
+	assert.Equal(t, uint64(3), db.Count())
+
+	// The iterator returns the flags of the keys.
+	flags := map[string]uint16{}
+	it := db.Items()
+	for {
+		key, f, err := it.NextWithFlags()
+		if err == ErrIterationDone {
+			break
+		}
+		assert.Nil(t, err)
+		flags[string(key)] = f
+	}
+	assert.Equal(t, map[string]uint16{"a": testFlagFetched, "b": testFlagRobotsBlocked, string(large): 0}, flags)
+	assert.Equal(t, true, db.RecordFlags())
+	assert.Nil(t, db.Close())
+}
+
+func TestRecordFlagsWithoutOption(t *testing.T) {
+	db, err := createTestDB(nil)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte("a")))
+	assert.Equal(t, errRecordFlagsDisabled, db.PutWithFlags([]byte("a"), testFlagFetched))
+	assertFlags(t, db, []byte("a"), 0)
+	assert.Nil(t, db.Close())
+
+	// Keys written before the option are written again with the flags.
+	db, err = Open(testDBName, &Options{FileSystem: testFS, RecordFlags: true})
+	assert.Nil(t, err)
+	assertFlags(t, db, []byte("a"), 0)
+	assert.Nil(t, db.PutWithFlags([]byte("a"), testFlagFetched))
+	assertFlags(t, db, []byte("a"), testFlagFetched)
+	assert.Equal(t, 2, countSegments(t, db))
+	assert.Nil(t, db.Close())
+
+	// Segments with flags are read without the option.
+	db, err = Open(testDBName, &Options{FileSystem: testFS})
+	assert.Nil(t, err)
+	assertFlags(t, db, []byte("a"), testFlagFetched)
+	assert.Nil(t, db.Put([]byte("b")))
+	assert.Equal(t, uint64(2), db.Count())
+	assert.Nil(t, db.Close())
+
+	_, err = Open(testDBName, &Options{FileSystem: testFS, RecordFlags: true, EncryptionKey: bytes.Repeat([]byte{1}, 32)})
+	assert.Equal(t, errRecordFlagsEncryption, err)
+}
+
+func TestCompareAndSetFlags(t *testing.T) {
+	db, err := createTestDB(&Options{RecordFlags: true})
+	assert.Nil(t, err)
+	const claimed = 1 << 2
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, db.Put([]byte{byte(i)}))
+	}
+	swapped, err := db.CompareAndSetFlags([]byte{10}, 0, claimed)
+	assert.Nil(t, err)
+	assert.Equal(t, false, swapped)
+
+	// Every key is claimed by exactly one worker.
+	wins := make(chan int, 10*8)
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				swapped, err := db.CompareAndSetFlags([]byte{byte(i)}, 0, claimed)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if swapped {
+					wins <- i
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(wins)
+	claims := map[int]int{}
+	for i := range wins {
+		claims[i]++
+	}
+	assert.Equal(t, 10, len(claims))
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, 1, claims[i])
+		assertFlags(t, db, []byte{byte(i)}, claimed)
+	}
+
+	swapped, err = db.CompareAndSetFlags([]byte{0}, claimed, testFlagFetched)
+	assert.Nil(t, err)
+	assert.Equal(t, true, swapped)
+	assertFlags(t, db, []byte{0}, testFlagFetched)
+	assert.Nil(t, db.Close())
+}
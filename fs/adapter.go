@@ -0,0 +1,318 @@
+package fs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	iofs "io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+)
+
+// ErrReadOnly is returned by modifications of a read-only file system.
+var ErrReadOnly = errors.New("file system is read-only")
+
+var errTruncateNotSupported = errors.New("truncate is not supported")
+
+// BasicFile is the minimal set of file methods required by Wrap.
+// Files implementing io.WriterAt, Stat, Sync or Truncate methods with the signatures of os.File use them,
+// otherwise they are emulated or, in the case of Truncate, unsupported.
+type BasicFile interface {
+	io.Closer
+	io.Reader
+	io.ReaderAt
+	io.Seeker
+	io.Writer
+}
+
+// Backend holds the operations of a file system adapted by Wrap.
+//
+// The fields match the method signatures of afero.Fs and billy.Filesystem, except OpenFile and ReadDir,
+// which need a one-line closure, e.g. for afero:
+//
+//	fs.Wrap(fs.Backend{
+//		OpenFile: func(name string, flag int, perm os.FileMode) (fs.BasicFile, error) {
+//			return afs.OpenFile(name, flag, perm)
+//		},
+//		Stat:    afs.Stat,
+//		Remove:  afs.Remove,
+//		Rename:  afs.Rename,
+//		ReadDir: func(name string) ([]os.FileInfo, error) { return afero.ReadDir(afs, name) },
+//	})
+type Backend struct {
+	OpenFile func(name string, flag int, perm os.FileMode) (BasicFile, error)
+	Stat     func(name string) (os.FileInfo, error)
+	Remove   func(name string) error
+	Rename   func(oldpath, newpath string) error
+	ReadDir  func(name string) ([]os.FileInfo, error)
+}
+
+// Wrap returns a FileSystem backed by the operations of a custom file system.
+// Lock files are emulated: they are regular files, which are exclusively locked only within the current process.
+func Wrap(b Backend) FileSystem {
+	return &wrapFS{b: b, locks: map[string]bool{}}
+}
+
+type wrapFS struct {
+	b     Backend
+	mu    sync.Mutex
+	locks map[string]bool // Lock files held by this process.
+}
+
+func (fs *wrapFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	f, err := fs.b.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &wrapFile{BasicFile: f, fs: fs, name: name}, nil
+}
+
+func (fs *wrapFS) Stat(name string) (os.FileInfo, error) {
+	return fs.b.Stat(name)
+}
+
+func (fs *wrapFS) Remove(name string) error {
+	return fs.b.Remove(name)
+}
+
+func (fs *wrapFS) Rename(oldpath, newpath string) error {
+	return fs.b.Rename(oldpath, newpath)
+}
+
+func (fs *wrapFS) ReadDir(name string) ([]os.FileInfo, error) {
+	return fs.b.ReadDir(name)
+}
+
+func (fs *wrapFS) CreateLockFile(name string, perm os.FileMode) (LockFile, bool, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.locks[name] {
+		return nil, false, os.ErrExist
+	}
+	_, err := fs.b.Stat(name)
+	exists := err == nil
+	f, err := fs.b.OpenFile(name, os.O_CREATE|os.O_RDWR, perm)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, false, err
+	}
+	fs.locks[name] = true
+	return &wrapLockFile{fs: fs, name: name}, exists, nil
+}
+
+type wrapLockFile struct {
+	fs   *wrapFS
+	name string
+}
+
+func (l *wrapLockFile) Unlock() error {
+	l.fs.mu.Lock()
+	defer l.fs.mu.Unlock()
+	delete(l.fs.locks, l.name)
+	return l.fs.b.Remove(l.name)
+}
+
+type wrapFile struct {
+	BasicFile
+	fs   *wrapFS
+	name string
+}
+
+func (f *wrapFile) WriteAt(p []byte, off int64) (int, error) {
+	if w, ok := f.BasicFile.(io.WriterAt); ok {
+		return w.WriteAt(p, off)
+	}
+	// Emulate WriteAt with Seek and Write, keeping the current offset.
+	cur, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := f.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+	n, err := f.Write(p)
+	if _, serr := f.Seek(cur, io.SeekStart); err == nil {
+		err = serr
+	}
+	return n, err
+}
+
+func (f *wrapFile) Stat() (os.FileInfo, error) {
+	if s, ok := f.BasicFile.(interface{ Stat() (os.FileInfo, error) }); ok {
+		return s.Stat()
+	}
+	return f.fs.b.Stat(f.name)
+}
+
+func (f *wrapFile) Sync() error {
+	if s, ok := f.BasicFile.(interface{ Sync() error }); ok {
+		return s.Sync()
+	}
+	return nil
+}
+
+func (f *wrapFile) Truncate(size int64) error {
+	if t, ok := f.BasicFile.(interface{ Truncate(int64) error }); ok {
+		return t.Truncate(size)
+	}
+	return errTruncateNotSupported
+}
+
+func (f *wrapFile) Slice(start int64, end int64) ([]byte, error) {
+	return sliceAt(f, start, end)
+}
+
+// FromIOFS returns a read-only FileSystem backed by an io/fs file system, e.g. embed.FS or fstest.MapFS.
+// It can be used to open databases with Options.ReadOnly. Names are converted to slash-separated paths.
+// Files that don't implement io.ReaderAt and io.Seeker are read into memory when opened.
+func FromIOFS(fsys iofs.FS) FileSystem {
+	return &ioFS{fsys: fsys}
+}
+
+type ioFS struct {
+	fsys iofs.FS
+}
+
+// ioName converts a file system name to an io/fs path.
+func ioName(name string) string {
+	name = path.Clean(filepath.ToSlash(name))
+	if name == "/" {
+		return "."
+	}
+	if len(name) > 0 && name[0] == '/' {
+		name = name[1:]
+	}
+	return name
+}
+
+func (fs *ioFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		// Files opened for writing fail only if they are missing, writes return an error.
+		// The database opens files with O_RDWR even when it only reads them.
+		if _, err := iofs.Stat(fs.fsys, ioName(name)); err != nil {
+			return nil, ErrReadOnly
+		}
+	}
+	f, err := fs.fsys.Open(ioName(name))
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	if rs, ok := f.(interface {
+		io.ReaderAt
+		io.ReadSeeker
+	}); ok {
+		return &ioFile{r: rs, c: f, fi: fi}, nil
+	}
+	data, err := io.ReadAll(f)
+	_ = f.Close()
+	if err != nil {
+		return nil, err
+	}
+	return &ioFile{r: bytes.NewReader(data), fi: fi}, nil
+}
+
+func (fs *ioFS) Stat(name string) (os.FileInfo, error) {
+	return iofs.Stat(fs.fsys, ioName(name))
+}
+
+func (fs *ioFS) Remove(name string) error {
+	return ErrReadOnly
+}
+
+func (fs *ioFS) Rename(oldpath, newpath string) error {
+	return ErrReadOnly
+}
+
+func (fs *ioFS) ReadDir(name string) ([]os.FileInfo, error) {
+	entries, err := iofs.ReadDir(fs.fsys, ioName(name))
+	if err != nil {
+		return nil, err
+	}
+	fis := make([]os.FileInfo, 0, len(entries))
+	for _, e := range entries {
+		fi, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		fis = append(fis, fi)
+	}
+	return fis, nil
+}
+
+func (fs *ioFS) CreateLockFile(name string, perm os.FileMode) (LockFile, bool, error) {
+	return nil, false, ErrReadOnly
+}
+
+type ioFile struct {
+	r interface {
+		io.ReaderAt
+		io.ReadSeeker
+	}
+	c  io.Closer // Nil if the file was read into memory.
+	fi os.FileInfo
+}
+
+func (f *ioFile) Close() error {
+	if f.c == nil {
+		return nil
+	}
+	return f.c.Close()
+}
+
+func (f *ioFile) Read(p []byte) (int, error) {
+	return f.r.Read(p)
+}
+
+func (f *ioFile) ReadAt(p []byte, off int64) (int, error) {
+	return f.r.ReadAt(p, off)
+}
+
+func (f *ioFile) Seek(offset int64, whence int) (int64, error) {
+	return f.r.Seek(offset, whence)
+}
+
+func (f *ioFile) Write(p []byte) (int, error) {
+	return 0, ErrReadOnly
+}
+
+func (f *ioFile) WriteAt(p []byte, off int64) (int, error) {
+	return 0, ErrReadOnly
+}
+
+func (f *ioFile) Stat() (os.FileInfo, error) {
+	return f.fi, nil
+}
+
+func (f *ioFile) Sync() error {
+	return nil
+}
+
+func (f *ioFile) Truncate(size int64) error {
+	return ErrReadOnly
+}
+
+func (f *ioFile) Slice(start int64, end int64) ([]byte, error) {
+	return sliceAt(f.r, start, end)
+}
+
+// sliceAt implements File.Slice with ReadAt.
+func sliceAt(r io.ReaderAt, start int64, end int64) ([]byte, error) {
+	buf := make([]byte, end-start)
+	n, err := r.ReadAt(buf, start)
+	if err == io.EOF && n == len(buf) {
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
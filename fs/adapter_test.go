@@ -0,0 +1,87 @@
+package fs
+
+import (
+	"io"
+	"os"
+	"testing"
+	"testing/fstest"
+
+	"github.com/domaincrawler/pogreb/internal/assert"
+)
+
+func osBackend(open func(name string, flag int, perm os.FileMode) (BasicFile, error)) Backend {
+	return Backend{
+		OpenFile: open,
+		Stat:     os.Stat,
+		Remove:   os.Remove,
+		Rename:   os.Rename,
+		ReadDir:  OS.ReadDir,
+	}
+}
+
+// basicFile hides the os.File methods not required by BasicFile.
+type basicFile struct {
+	BasicFile
+}
+
+func (f basicFile) Truncate(size int64) error {
+	return f.BasicFile.(*os.File).Truncate(size)
+}
+
+func TestWrapFS(t *testing.T) {
+	fsys := Wrap(osBackend(func(name string, flag int, perm os.FileMode) (BasicFile, error) {
+		return os.OpenFile(name, flag, perm)
+	}))
+	testFS(t, fsys)
+	testLockFile(t, fsys)
+	testLockFileAcquireExisting(t, fsys)
+}
+
+func TestWrapFSEmulated(t *testing.T) {
+	// WriteAt, Stat and Sync are emulated.
+	testFS(t, Wrap(osBackend(func(name string, flag int, perm os.FileMode) (BasicFile, error) {
+		f, err := os.OpenFile(name, flag, perm)
+		if err != nil {
+			return nil, err
+		}
+		return basicFile{f}, nil
+	})))
+}
+
+func TestFromIOFS(t *testing.T) {
+	fsys := FromIOFS(fstest.MapFS{
+		"db/a":     &fstest.MapFile{Data: []byte("0123456789")},
+		"db/sub/b": &fstest.MapFile{},
+	})
+
+	fis, err := fsys.ReadDir("db")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(fis))
+	fi, err := fsys.Stat("db/a")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(10), fi.Size())
+
+	f, err := fsys.OpenFile("db/a", os.O_RDWR, 0)
+	assert.Nil(t, err)
+	b, err := f.Slice(2, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("23456789"), b)
+	_, err = f.Slice(2, 11)
+	assert.Equal(t, io.EOF, err)
+	_, err = f.Seek(8, io.SeekStart)
+	assert.Nil(t, err)
+	b, err = io.ReadAll(f)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("89"), b)
+	_, err = f.Write([]byte("x"))
+	assert.Equal(t, ErrReadOnly, err)
+	assert.Nil(t, f.Close())
+
+	_, err = fsys.OpenFile("db/c", os.O_CREATE|os.O_RDWR, 0644)
+	assert.Equal(t, ErrReadOnly, err)
+	_, err = fsys.OpenFile("db/c", os.O_RDONLY, 0)
+	assert.NotNil(t, err)
+	assert.Equal(t, ErrReadOnly, fsys.Remove("db/a"))
+	_, _, err = fsys.CreateLockFile("db/lock", 0644)
+	assert.Equal(t, ErrReadOnly, err)
+}
@@ -0,0 +1,125 @@
+package fs
+
+import (
+	"os"
+)
+
+// DirectIOFileSystem is a FileSystem able to open files written with direct I/O.
+type DirectIOFileSystem interface {
+	FileSystem
+
+	// OpenDirectFile opens the file like OpenFile, or like OpenMmapFile when mmap is true.
+	// Writes to the file bypass the page cache where the OS and the file system support direct I/O,
+	// otherwise the file is written through the page cache.
+	OpenDirectFile(name string, flag int, perm os.FileMode, mmap bool) (File, error)
+}
+
+// directBlockSize is the alignment of offsets, sizes and memory addresses of direct writes.
+const directBlockSize = 4096
+
+// directBufferSize is the size of the aligned memory used to copy data for direct writes.
+const directBufferSize = 256 << 10
+
+// directWriter writes whole blocks with direct I/O and the partial last block through the page cache.
+// Readers of the file see the written data immediately, only the page of the partial block stays cached.
+type directWriter struct {
+	f       *os.File // The file opened for direct I/O.
+	buf     []byte   // Block-aligned memory.
+	tail    []byte   // Contents of the partial block preceding end.
+	end     int64    // Offset following the last write, -1 if tail has to be reloaded.
+	scratch []byte
+}
+
+// writeAt writes p at off. file is the same file opened without direct I/O.
+func (w *directWriter) writeAt(file *os.File, p []byte, off int64) (int, error) {
+	start := off - off%directBlockSize
+	if off != w.end {
+		// Not an append to the previous write. Reload the data preceding the offset in its block.
+		w.tail = w.tail[:off-start]
+		if len(w.tail) > 0 {
+			if _, err := file.ReadAt(w.tail, start); err != nil {
+				w.end = -1
+				return 0, err
+			}
+		}
+	}
+	w.end = -1
+	data := append(append(w.scratch[:0], w.tail...), p...)
+	w.scratch = data
+	aligned := int64(len(data)) / directBlockSize * directBlockSize
+	for written := int64(0); written < aligned; {
+		n := copy(w.buf, data[written:aligned])
+		if _, err := w.f.WriteAt(w.buf[:n], start+written); err != nil {
+			return 0, err
+		}
+		written += int64(n)
+	}
+	// The rest of the data is shorter than a block.
+	if bufferedOff := start + aligned; bufferedOff < off+int64(len(p)) {
+		if bufferedOff < off {
+			bufferedOff = off
+		}
+		if _, err := file.WriteAt(data[bufferedOff-start:], bufferedOff); err != nil {
+			return 0, err
+		}
+	}
+	w.tail = append(w.tail[:0], data[aligned:]...)
+	w.end = off + int64(len(p))
+	return len(p), nil
+}
+
+// invalidate makes the next write reload the partial block, e.g. after the file was truncated.
+func (w *directWriter) invalidate() {
+	w.end = -1
+}
+
+func (w *directWriter) close() error {
+	err := freeDirectBuffer(w.buf)
+	if cerr := w.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// openDirectWriter opens the file for direct I/O.
+// It returns nil if the platform or the file system don't support direct I/O.
+func openDirectWriter(name string, flag int) *directWriter {
+	if directFlag == 0 {
+		return nil
+	}
+	f, err := os.OpenFile(name, (flag&^(os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_EXCL))|os.O_WRONLY|directFlag, 0)
+	if err != nil {
+		return nil
+	}
+	buf, err := allocDirectBuffer(directBufferSize)
+	if err != nil {
+		_ = f.Close()
+		return nil
+	}
+	return &directWriter{
+		f:    f,
+		buf:  buf,
+		tail: make([]byte, 0, directBlockSize),
+		end:  -1,
+	}
+}
+
+func (fs *osFS) OpenDirectFile(name string, flag int, perm os.FileMode, mmap bool) (File, error) {
+	if mmap {
+		f, err := openMMapFile(name, flag, perm)
+		if err != nil {
+			return nil, err
+		}
+		f.direct = openDirectWriter(name, flag)
+		return f, nil
+	}
+	f, err := openFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &osFile{File: f, direct: openDirectWriter(name, flag)}, nil
+}
+
+func (fs *osMMapFS) OpenDirectFile(name string, flag int, perm os.FileMode, mmap bool) (File, error) {
+	return fs.osFS.OpenDirectFile(name, flag, perm, true)
+}
@@ -0,0 +1,15 @@
+//go:build !linux && !freebsd
+// +build !linux,!freebsd
+
+package fs
+
+// directFlag is zero on platforms without O_DIRECT, files are written through the page cache.
+const directFlag = 0
+
+func allocDirectBuffer(size int) ([]byte, error) {
+	return make([]byte, size), nil
+}
+
+func freeDirectBuffer(buf []byte) error {
+	return nil
+}
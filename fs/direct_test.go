@@ -0,0 +1,89 @@
+package fs
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/domaincrawler/pogreb/internal/assert"
+)
+
+// directFS opens all files for direct I/O.
+type directFS struct {
+	osFS
+	mmap bool
+}
+
+func (fs *directFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	return fs.OpenDirectFile(name, flag, perm, fs.mmap)
+}
+
+func TestDirectFS(t *testing.T) {
+	testFS(t, &directFS{})
+}
+
+func TestDirectMmapFS(t *testing.T) {
+	testFS(t, &directFS{mmap: true})
+}
+
+func isDirect(f File) bool {
+	switch f := f.(type) {
+	case *osFile:
+		return f.direct != nil
+	case *osMMapFile:
+		return f.direct != nil
+	}
+	return false
+}
+
+func TestDirectWrites(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for _, mmap := range []bool{false, true} {
+		name := filepath.Join(t.TempDir(), "test")
+		f, err := OS.(DirectIOFileSystem).OpenDirectFile(name, os.O_CREATE|os.O_RDWR, 0644, mmap)
+		assert.Nil(t, err)
+		if directFlag != 0 && !isDirect(f) {
+			t.Log("direct I/O isn't supported by the file system")
+		}
+
+		var expected []byte
+		write := func(p []byte, off int64) {
+			_, err := f.WriteAt(p, off)
+			assert.Nil(t, err)
+			if end := off + int64(len(p)); end > int64(len(expected)) {
+				expected = append(expected, make([]byte, end-int64(len(expected)))...)
+			}
+			copy(expected[off:], p)
+			b, err := f.Slice(0, int64(len(expected)))
+			assert.Nil(t, err)
+			if !bytes.Equal(expected, b) {
+				t.Fatalf("unexpected contents after writing %d bytes at %d", len(p), off)
+			}
+		}
+
+		// Appends of various sizes, crossing block boundaries.
+		for i := 0; i < 200; i++ {
+			p := make([]byte, rnd.Intn(3*directBlockSize))
+			rnd.Read(p)
+			write(p, int64(len(expected)))
+		}
+		// Overwrites in the middle of the file.
+		write([]byte("header"), 0)
+		write(bytes.Repeat([]byte{1}, directBlockSize+10), directBlockSize-5)
+
+		// Appends after truncation.
+		assert.Nil(t, f.Truncate(int64(len(expected)/2+7)))
+		expected = expected[:len(expected)/2+7]
+		write(bytes.Repeat([]byte{2}, 3*directBlockSize), int64(len(expected)))
+		write([]byte{3}, int64(len(expected)))
+
+		assert.Nil(t, f.Sync())
+		assert.Nil(t, f.Close())
+		data, err := ioutil.ReadFile(name)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, data)
+	}
+}
@@ -0,0 +1,19 @@
+//go:build linux || freebsd
+// +build linux freebsd
+
+package fs
+
+import (
+	"syscall"
+)
+
+const directFlag = syscall.O_DIRECT
+
+// allocDirectBuffer allocates page-aligned memory.
+func allocDirectBuffer(size int) ([]byte, error) {
+	return syscall.Mmap(-1, 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_ANON|syscall.MAP_PRIVATE)
+}
+
+func freeDirectBuffer(buf []byte) error {
+	return syscall.Munmap(buf)
+}
@@ -0,0 +1,17 @@
+//go:build openbsd
+// +build openbsd
+
+package fs
+
+import (
+	"os"
+	"syscall"
+)
+
+func (fs *osFS) FreeSpace(name string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(name, &st); err != nil {
+		return 0, &os.PathError{Op: "statfs", Path: name, Err: err}
+	}
+	return uint64(st.F_bavail) * uint64(st.F_bsize), nil
+}
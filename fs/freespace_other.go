@@ -0,0 +1,8 @@
+//go:build !linux && !darwin && !freebsd && !dragonfly && !openbsd && !windows
+// +build !linux,!darwin,!freebsd,!dragonfly,!openbsd,!windows
+
+package fs
+
+func (fs *osFS) FreeSpace(name string) (uint64, error) {
+	return 0, errFreeSpaceNotSupported
+}
@@ -0,0 +1,17 @@
+//go:build linux || darwin || freebsd || dragonfly
+// +build linux darwin freebsd dragonfly
+
+package fs
+
+import (
+	"os"
+	"syscall"
+)
+
+func (fs *osFS) FreeSpace(name string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(name, &st); err != nil {
+		return 0, &os.PathError{Op: "statfs", Path: name, Err: err}
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), nil
+}
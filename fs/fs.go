@@ -11,6 +11,8 @@ import (
 
 var (
 	errAppendModeNotSupported = errors.New("append mode is not supported")
+	errFreeSpaceNotSupported  = errors.New("free space reporting is not supported")
+	errLinkNotSupported       = errors.New("hard links are not supported")
 )
 
 // File is the interface compatible with os.File.
@@ -61,3 +63,57 @@ type FileSystem interface {
 	// CreateLockFile creates a lock file.
 	CreateLockFile(name string, perm os.FileMode) (LockFile, bool, error)
 }
+
+// MmapFileSystem is a FileSystem that is able to open memory-mapped files.
+type MmapFileSystem interface {
+	FileSystem
+
+	// OpenMmapFile opens the file with specified flag.
+	// Slice calls on the returned file read directly from mapped memory without making syscalls.
+	OpenMmapFile(name string, flag int, perm os.FileMode) (File, error)
+}
+
+// FreeSpaceFileSystem is a FileSystem able to report the available disk space.
+type FreeSpaceFileSystem interface {
+	FileSystem
+
+	// FreeSpace returns the number of bytes available to the current user on the volume containing the path.
+	FreeSpace(name string) (uint64, error)
+}
+
+// LinkFileSystem is a FileSystem able to create hard links.
+type LinkFileSystem interface {
+	FileSystem
+
+	// Link creates newname as a hard link to the oldname file.
+	Link(oldname, newname string) error
+}
+
+// DirSyncFileSystem is a FileSystem able to make the entries of a directory durable.
+type DirSyncFileSystem interface {
+	FileSystem
+
+	// SyncDir commits the entries of the directory, e.g. a file renamed into it.
+	SyncDir(name string) error
+}
+
+// WritableMmapFileSystem is a FileSystem able to open files memory-mapped for writing.
+type WritableMmapFileSystem interface {
+	FileSystem
+
+	// OpenWritableMmapFile opens the file with specified flag.
+	// The returned file implements WritableMmapFile where the OS supports writable memory mappings,
+	// otherwise it's opened like OpenFile.
+	OpenWritableMmapFile(name string, flag int, perm os.FileMode) (File, error)
+}
+
+// WritableMmapFile is a File memory-mapped for writing.
+type WritableMmapFile interface {
+	File
+
+	// MutableSlice returns the mapped memory holding the contents of the file from offset start to offset end.
+	// The end must not exceed the file size. Modifications of the slice are visible to readers of the file
+	// immediately, the modified pages are written to the disk by Sync.
+	// The slice is valid until the next call changing the file size.
+	MutableSlice(start int64, end int64) ([]byte, error)
+}
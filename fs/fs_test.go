@@ -317,6 +317,22 @@ func testFS(t *testing.T, fsys FileSystem) {
 		assert.Equal(t, "test2", fi.Name())
 	})
 
+	t.Run("Rename over open file", func(t *testing.T) {
+		dst, err := fsys.OpenFile("test2", os.O_RDWR, os.FileMode(0666))
+		assert.Nil(t, err)
+		src, err := fsys.OpenFile("test3", os.O_CREATE|os.O_RDWR|os.O_TRUNC, os.FileMode(0666))
+		assert.Nil(t, err)
+		_, err = src.Write(testData[:3])
+		assert.Nil(t, err)
+
+		assert.Nil(t, fsys.Rename("test3", "test2"))
+		assert.Nil(t, src.Close())
+		assert.Nil(t, dst.Close())
+		fi, err := fsys.Stat("test2")
+		assert.Nil(t, err)
+		assert.Equal(t, int64(3), fi.Size())
+	})
+
 	t.Run("ReadDir", func(t *testing.T) {
 		fis, err := fsys.ReadDir(".")
 		assert.Nil(t, err)
@@ -4,17 +4,36 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 )
 
 type memFS struct {
+	// mu protects the file table and file contents from concurrent snapshots.
+	// Reads of file contents don't take the lock, the database synchronizes them with writes.
+	mu    sync.RWMutex
 	files map[string]*memFile
 }
 
+func newMemFS() *memFS {
+	return &memFS{files: map[string]*memFile{}}
+}
+
 // Mem is a file system backed by memory.
-var Mem FileSystem = &memFS{files: map[string]*memFile{}}
+var Mem FileSystem = newMemFS()
+
+// NewMem returns an empty file system backed by memory. Unlike Mem, it isn't shared.
+func NewMem() FileSystem {
+	return newMemFS()
+}
 
 func (fs *memFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.openFile(name, flag, perm)
+}
+
+func (fs *memFS) openFile(name string, flag int, perm os.FileMode) (*memFile, error) {
 	if flag&os.O_APPEND != 0 {
 		// memFS doesn't support opening files in append-only mode.
 		// The database doesn't currently use O_APPEND.
@@ -23,6 +42,7 @@ func (fs *memFS) OpenFile(name string, flag int, perm os.FileMode) (File, error)
 	f := fs.files[name]
 	if f == nil || (flag&os.O_TRUNC) != 0 {
 		f = &memFile{
+			fs:   fs,
 			name: name,
 			perm: perm, // Perm is saved to return it in Mode, but don't do anything else with it yet.
 		}
@@ -36,16 +56,26 @@ func (fs *memFS) OpenFile(name string, flag int, perm os.FileMode) (File, error)
 	return f, nil
 }
 
+// OpenMmapFile opens the file with specified flag.
+// Memory files are always read directly from memory, it's equivalent to OpenFile.
+func (fs *memFS) OpenMmapFile(name string, flag int, perm os.FileMode) (File, error) {
+	return fs.OpenFile(name, flag, perm)
+}
+
 func (fs *memFS) CreateLockFile(name string, perm os.FileMode) (LockFile, bool, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
 	_, exists := fs.files[name]
-	_, err := fs.OpenFile(name, 0, perm)
+	f, err := fs.openFile(name, 0, perm)
 	if err != nil {
 		return nil, false, err
 	}
-	return fs.files[name], exists, nil
+	return f, exists, nil
 }
 
 func (fs *memFS) Stat(name string) (os.FileInfo, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
 	if f, ok := fs.files[name]; ok {
 		return f, nil
 	}
@@ -53,6 +83,8 @@ func (fs *memFS) Stat(name string) (os.FileInfo, error) {
 }
 
 func (fs *memFS) Remove(name string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
 	if _, ok := fs.files[name]; ok {
 		delete(fs.files, name)
 		return nil
@@ -61,6 +93,8 @@ func (fs *memFS) Remove(name string) error {
 }
 
 func (fs *memFS) Rename(oldpath, newpath string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
 	if f, ok := fs.files[oldpath]; ok {
 		delete(fs.files, oldpath)
 		fs.files[newpath] = f
@@ -71,6 +105,8 @@ func (fs *memFS) Rename(oldpath, newpath string) error {
 }
 
 func (fs *memFS) ReadDir(dir string) ([]os.FileInfo, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
 	dir = filepath.Clean(dir)
 	var fis []os.FileInfo
 	for name, f := range fs.files {
@@ -82,6 +118,7 @@ func (fs *memFS) ReadDir(dir string) ([]os.FileInfo, error) {
 }
 
 type memFile struct {
+	fs     *memFS
 	name   string
 	perm   os.FileMode
 	buf    []byte
@@ -102,7 +139,7 @@ func (f *memFile) Unlock() error {
 	if err := f.Close(); err != nil {
 		return err
 	}
-	return Mem.Remove(f.name)
+	return f.fs.Remove(f.name)
 }
 
 func (f *memFile) ReadAt(p []byte, off int64) (int, error) {
@@ -131,6 +168,8 @@ func (f *memFile) Read(p []byte) (int, error) {
 }
 
 func (f *memFile) WriteAt(p []byte, off int64) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
 	if f.closed {
 		return 0, os.ErrClosed
 	}
@@ -191,6 +230,8 @@ func (f *memFile) truncate(size int64) {
 }
 
 func (f *memFile) Truncate(size int64) error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
 	if f.closed {
 		return os.ErrClosed
 	}
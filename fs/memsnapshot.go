@@ -0,0 +1,228 @@
+package fs
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const memSnapshotSignature = "pogrebms"
+
+var errCorruptedSnapshot = errors.New("corrupted memory snapshot")
+
+// MemSnapshot is a file system backed by memory, which persists itself to a single snapshot file on disk.
+//
+// Writes don't touch the disk, the whole file system is written to the snapshot file
+// by Save, Close and, optionally, periodically in the background.
+// A snapshot taken while a database is open is equivalent to a crash:
+// the database is recovered when it's opened from the snapshot.
+type MemSnapshot struct {
+	*memFS
+	path string
+
+	saveMu sync.Mutex // Serializes saves.
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// OpenMemSnapshot creates a memory file system loaded from the snapshot file at path.
+// The file system is empty if the snapshot file doesn't exist.
+//
+// When interval is greater than zero, the file system is saved every interval in the background.
+// Background save errors are ignored, the next save is attempted at the next interval.
+// The file system must be closed after use, by calling Close method.
+func OpenMemSnapshot(path string, interval time.Duration) (*MemSnapshot, error) {
+	m := &MemSnapshot{
+		memFS: newMemFS(),
+		path:  path,
+	}
+	if err := m.load(); err != nil {
+		return nil, err
+	}
+	if interval > 0 {
+		m.stop = make(chan struct{})
+		m.done = make(chan struct{})
+		go m.run(interval)
+	}
+	return m, nil
+}
+
+func (m *MemSnapshot) run(interval time.Duration) {
+	defer close(m.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			_ = m.Save()
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+// Path returns the path of the snapshot file.
+func (m *MemSnapshot) Path() string {
+	return m.path
+}
+
+// snapshot encodes all files.
+//
+// The snapshot starts with an 8-byte signature followed by the number of files.
+// Each file is encoded as the name length, name, permission bits, size and contents.
+// The snapshot ends with a CRC-32 of the preceding bytes. All integers are little-endian.
+func (m *MemSnapshot) snapshot() []byte {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	names := make([]string, 0, len(m.files))
+	size := len(memSnapshotSignature) + 4 + 4
+	for name, f := range m.files {
+		names = append(names, name)
+		size += 2 + len(name) + 4 + 8 + int(f.size)
+	}
+	sort.Strings(names)
+	buf := make([]byte, size)
+	w := buf[copy(buf, memSnapshotSignature):]
+	binary.LittleEndian.PutUint32(w, uint32(len(names)))
+	w = w[4:]
+	for _, name := range names {
+		f := m.files[name]
+		binary.LittleEndian.PutUint16(w, uint16(len(name)))
+		w = w[2+copy(w[2:], name):]
+		binary.LittleEndian.PutUint32(w, uint32(f.perm))
+		binary.LittleEndian.PutUint64(w[4:], uint64(f.size))
+		w = w[12+copy(w[12:], f.buf[:f.size]):]
+	}
+	binary.LittleEndian.PutUint32(w, crc32.ChecksumIEEE(buf[:size-4]))
+	return buf
+}
+
+// Save writes the file system to the snapshot file.
+// The snapshot is written to a temporary file first, which replaces the previous snapshot when complete.
+func (m *MemSnapshot) Save() error {
+	m.saveMu.Lock()
+	defer m.saveMu.Unlock()
+	data := m.snapshot()
+	tmp := m.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, m.path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(m.path))
+}
+
+func (m *MemSnapshot) load() error {
+	f, err := os.Open(m.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	if len(data) < len(memSnapshotSignature)+8 || string(data[:len(memSnapshotSignature)]) != memSnapshotSignature {
+		return errCorruptedSnapshot
+	}
+	body := data[:len(data)-4]
+	if crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(data[len(data)-4:]) {
+		return errCorruptedSnapshot
+	}
+	r := &snapshotReader{data: body[len(memSnapshotSignature):]}
+	n := r.uint32()
+	for i := uint32(0); i < n; i++ {
+		name := string(r.bytes(int(r.uint16())))
+		perm := os.FileMode(r.uint32())
+		contents := r.bytes(int(r.uint64()))
+		if r.err != nil {
+			return r.err
+		}
+		m.files[name] = &memFile{
+			fs:     m.memFS,
+			name:   name,
+			perm:   perm,
+			buf:    append([]byte(nil), contents...),
+			size:   int64(len(contents)),
+			closed: true,
+		}
+	}
+	if r.err == nil && len(r.data) != 0 {
+		return errCorruptedSnapshot
+	}
+	return r.err
+}
+
+// Close stops the background saves and saves the file system.
+func (m *MemSnapshot) Close() error {
+	if m.stop != nil {
+		close(m.stop)
+		<-m.done
+		m.stop = nil
+	}
+	return m.Save()
+}
+
+type snapshotReader struct {
+	data []byte
+	err  error
+}
+
+func (r *snapshotReader) bytes(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(r.data) {
+		r.err = errCorruptedSnapshot
+		return nil
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *snapshotReader) uint16() uint16 {
+	b := r.bytes(2)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint16(b)
+}
+
+func (r *snapshotReader) uint32() uint32 {
+	b := r.bytes(4)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(b)
+}
+
+func (r *snapshotReader) uint64() uint64 {
+	b := r.bytes(8)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint64(b)
+}
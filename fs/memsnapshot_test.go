@@ -0,0 +1,96 @@
+package fs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/domaincrawler/pogreb/internal/assert"
+)
+
+func TestMemSnapshotFS(t *testing.T) {
+	m, err := OpenMemSnapshot(filepath.Join(t.TempDir(), "snapshot"), 0)
+	assert.Nil(t, err)
+	testFS(t, m)
+	testLockFile(t, m)
+	testLockFileAcquireExisting(t, m)
+	assert.Nil(t, m.Close())
+}
+
+func TestMemSnapshotSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot")
+	m, err := OpenMemSnapshot(path, 0)
+	assert.Nil(t, err)
+	f, err := m.OpenFile("db/a", os.O_CREATE|os.O_RDWR, 0640)
+	assert.Nil(t, err)
+	_, err = f.Write([]byte("foo"))
+	assert.Nil(t, err)
+	assert.Nil(t, touchFile(m, "db/empty"))
+
+	// Open files are saved too.
+	assert.Nil(t, m.Save())
+	_, err = f.Write([]byte("bar"))
+	assert.Nil(t, err)
+
+	m2, err := OpenMemSnapshot(path, 0)
+	assert.Nil(t, err)
+	fis, err := m2.ReadDir("db")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(fis))
+	f2, err := m2.OpenFile("db/a", os.O_RDWR, 0)
+	assert.Nil(t, err)
+	b, err := ioutil.ReadAll(f2)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("foo"), b)
+	fi, err := f2.Stat()
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0640), fi.Mode())
+	assert.Nil(t, f2.Close())
+
+	assert.Nil(t, f.Close())
+	assert.Nil(t, m.Close())
+	m2, err = OpenMemSnapshot(path, 0)
+	assert.Nil(t, err)
+	fi, err = m2.Stat("db/a")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(6), fi.Size())
+}
+
+func TestMemSnapshotBackgroundSave(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot")
+	m, err := OpenMemSnapshot(path, time.Millisecond)
+	assert.Nil(t, err)
+	assert.Nil(t, touchFile(m, "a"))
+	for i := 0; i < 1000; i++ {
+		if _, err := os.Stat(path); err == nil {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	m2, err := OpenMemSnapshot(path, 0)
+	assert.Nil(t, err)
+	_, err = m2.Stat("a")
+	assert.Nil(t, err)
+	assert.Nil(t, m.Close())
+}
+
+func TestMemSnapshotCorrupted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot")
+	m, err := OpenMemSnapshot(path, 0)
+	assert.Nil(t, err)
+	assert.Nil(t, touchFile(m, "a"))
+	assert.Nil(t, m.Close())
+
+	data, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	data[len(memSnapshotSignature)] ^= 1
+	assert.Nil(t, ioutil.WriteFile(path, data, 0644))
+	_, err = OpenMemSnapshot(path, 0)
+	assert.Equal(t, errCorruptedSnapshot, err)
+
+	assert.Nil(t, ioutil.WriteFile(path, []byte("foo"), 0644))
+	_, err = OpenMemSnapshot(path, 0)
+	assert.Equal(t, errCorruptedSnapshot, err)
+}
@@ -0,0 +1,639 @@
+package fs
+
+import (
+	"container/list"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ObjectInfo describes an object in an ObjectStore.
+type ObjectInfo struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// ObjectStore is a flat key-value blob storage, e.g. an S3 bucket.
+// Keys are slash-separated paths. Methods must return an error matching os.ErrNotExist for missing objects.
+type ObjectStore interface {
+	// Get reads n bytes of the object starting at the offset. A negative n reads until the end of the object.
+	Get(key string, off int64, n int64) ([]byte, error)
+
+	// Put creates or replaces the object.
+	Put(key string, data []byte) error
+
+	// Delete removes the object.
+	Delete(key string) error
+
+	// Stat returns the object info.
+	Stat(key string) (ObjectInfo, error)
+
+	// List returns all objects with keys starting with the prefix.
+	List(prefix string) ([]ObjectInfo, error)
+}
+
+const (
+	defaultObjectBlockSize = 1 << 20
+	defaultObjectCacheSize = 64 << 20
+)
+
+// ObjectOptions holds the optional parameters of an object storage file system.
+type ObjectOptions struct {
+	// Local is a file system buffering written files until they are uploaded by Sync or Close.
+	// Written files are kept in it after the upload and serve reads.
+	//
+	// Default: a new in-memory file system.
+	Local FileSystem
+
+	// BlockSize sets the size of ranges read from the object storage.
+	//
+	// Default: 1 MiB.
+	BlockSize int64
+
+	// CacheSize sets the maximum amount of memory used to cache read blocks.
+	//
+	// Default: 64 MiB.
+	CacheSize int64
+}
+
+// Object returns a file system backed by object storage.
+//
+// Files that are only read are never downloaded in full, reads fetch ranges of BlockSize bytes,
+// which are kept in an LRU cache. A file is copied to the local file system the first time it's written to,
+// and uploaded as a whole on Sync and Close.
+//
+// The object storage doesn't provide locking, only one process may open a database at a time.
+func Object(store ObjectStore, opts *ObjectOptions) FileSystem {
+	o := ObjectOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.Local == nil {
+		o.Local = newMemFS()
+	}
+	if o.BlockSize <= 0 {
+		o.BlockSize = defaultObjectBlockSize
+	}
+	if o.CacheSize <= 0 {
+		o.CacheSize = defaultObjectCacheSize
+	}
+	return &objectFS{
+		store:  store,
+		local:  o.Local,
+		blocks: newBlockCache(o.BlockSize, o.CacheSize),
+		files:  map[*objectFile]struct{}{},
+		locks:  map[string]bool{},
+	}
+}
+
+type objectFS struct {
+	store  ObjectStore
+	local  FileSystem
+	blocks *blockCache
+	mu     sync.Mutex // Guards open files, locks and names of open files.
+	files  map[*objectFile]struct{}
+	locks  map[string]bool
+}
+
+func objectKey(name string) string {
+	return strings.TrimPrefix(path.Clean(filepath.ToSlash(name)), "./")
+}
+
+// localExists returns whether the file is buffered in the local file system.
+func (fs *objectFS) localExists(name string) bool {
+	_, err := fs.local.Stat(name)
+	return err == nil
+}
+
+func (fs *objectFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	if flag&os.O_APPEND != 0 {
+		return nil, errAppendModeNotSupported
+	}
+	f := &objectFile{fs: fs, name: name}
+	if fs.localExists(name) {
+		lf, err := fs.local.OpenFile(name, flag, perm)
+		if err != nil {
+			return nil, err
+		}
+		fi, err := lf.Stat()
+		if err != nil {
+			_ = lf.Close()
+			return nil, err
+		}
+		f.local = lf
+		f.size = fi.Size()
+		f.dirty = flag&os.O_TRUNC != 0
+	} else {
+		info, err := fs.store.Stat(objectKey(name))
+		if err != nil && !isNotExist(err) {
+			return nil, err
+		}
+		exists := err == nil
+		if !exists && flag&os.O_CREATE == 0 {
+			return nil, os.ErrNotExist
+		}
+		if !exists || flag&os.O_TRUNC != 0 {
+			// Empty files are uploaded right away, making them visible to Stat and ReadDir.
+			if err := fs.store.Put(objectKey(name), nil); err != nil {
+				return nil, err
+			}
+			fs.blocks.invalidate(name)
+			lf, err := fs.local.OpenFile(name, os.O_CREATE|os.O_RDWR|os.O_TRUNC, perm)
+			if err != nil {
+				return nil, err
+			}
+			f.local = lf
+		} else {
+			f.size = info.Size
+		}
+	}
+	fs.mu.Lock()
+	fs.files[f] = struct{}{}
+	fs.mu.Unlock()
+	return f, nil
+}
+
+func isNotExist(err error) bool {
+	return errors.Is(err, os.ErrNotExist)
+}
+
+// localStat returns the info of an open file or a file written to through this file system.
+// The open files are checked first, their size may be ahead of the local copy.
+func (fs *objectFS) localStat(name string) (os.FileInfo, error) {
+	fs.mu.Lock()
+	for f := range fs.files {
+		if f.name == name {
+			fs.mu.Unlock()
+			return f.Stat()
+		}
+	}
+	fs.mu.Unlock()
+	fi, err := fs.local.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	return objectFileInfo{name: filepath.Base(name), size: fi.Size(), modTime: fi.ModTime()}, nil
+}
+
+func (fs *objectFS) Stat(name string) (os.FileInfo, error) {
+	if fi, err := fs.localStat(name); err == nil {
+		return fi, nil
+	}
+	info, err := fs.store.Stat(objectKey(name))
+	if err != nil {
+		return nil, err
+	}
+	return objectFileInfo{name: filepath.Base(name), size: info.Size, modTime: info.ModTime}, nil
+}
+
+func (fs *objectFS) Remove(name string) error {
+	if _, err := fs.Stat(name); err != nil {
+		return err
+	}
+	if fs.localExists(name) {
+		if err := fs.local.Remove(name); err != nil {
+			return err
+		}
+	}
+	fs.blocks.invalidate(name)
+	if err := fs.store.Delete(objectKey(name)); err != nil && !isNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (fs *objectFS) Rename(oldpath, newpath string) error {
+	if fs.localExists(oldpath) {
+		fs.mu.Lock()
+		for f := range fs.files {
+			if f.name == oldpath {
+				// Upload pending writes of the open file, the object storage has no rename.
+				fs.mu.Unlock()
+				if err := f.Sync(); err != nil {
+					return err
+				}
+				fs.mu.Lock()
+				break
+			}
+		}
+		fs.mu.Unlock()
+		if fs.localExists(newpath) {
+			if err := fs.local.Remove(newpath); err != nil {
+				return err
+			}
+		}
+		if err := fs.local.Rename(oldpath, newpath); err != nil {
+			return err
+		}
+	}
+	data, err := fs.store.Get(objectKey(oldpath), 0, -1)
+	if err != nil {
+		return err
+	}
+	if err := fs.store.Put(objectKey(newpath), data); err != nil {
+		return err
+	}
+	if err := fs.store.Delete(objectKey(oldpath)); err != nil && !isNotExist(err) {
+		return err
+	}
+	fs.blocks.invalidate(oldpath)
+	fs.blocks.invalidate(newpath)
+
+	fs.mu.Lock()
+	for f := range fs.files {
+		switch f.name {
+		case newpath:
+			// The replaced file is unlinked, it must not be uploaded anymore.
+			f.setName("")
+		case oldpath:
+			f.setName(newpath)
+		}
+	}
+	fs.mu.Unlock()
+	return nil
+}
+
+func (fs *objectFS) ReadDir(name string) ([]os.FileInfo, error) {
+	prefix := objectKey(name) + "/"
+	if prefix == "./" {
+		prefix = ""
+	}
+	objects, err := fs.store.List(prefix)
+	if err != nil {
+		return nil, err
+	}
+	var fis []os.FileInfo
+	for _, obj := range objects {
+		base := strings.TrimPrefix(obj.Key, prefix)
+		if strings.Contains(base, "/") {
+			continue
+		}
+		fi, err := fs.localStat(filepath.Join(name, base))
+		if err != nil {
+			fi = objectFileInfo{name: base, size: obj.Size, modTime: obj.ModTime}
+		}
+		fis = append(fis, fi)
+	}
+	return fis, nil
+}
+
+func (fs *objectFS) CreateLockFile(name string, perm os.FileMode) (LockFile, bool, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.locks[name] {
+		return nil, false, os.ErrExist
+	}
+	_, err := fs.store.Stat(objectKey(name))
+	if err != nil && !isNotExist(err) {
+		return nil, false, err
+	}
+	exists := err == nil
+	if !exists {
+		if err := fs.store.Put(objectKey(name), nil); err != nil {
+			return nil, false, err
+		}
+	}
+	fs.locks[name] = true
+	return &objectLockFile{fs: fs, name: name}, exists, nil
+}
+
+type objectLockFile struct {
+	fs   *objectFS
+	name string
+}
+
+func (l *objectLockFile) Unlock() error {
+	l.fs.mu.Lock()
+	delete(l.fs.locks, l.name)
+	l.fs.mu.Unlock()
+	return l.fs.Remove(l.name)
+}
+
+// objectFile is a file stored in an ObjectStore.
+// Reads of a file which wasn't written are served from the block cache.
+type objectFile struct {
+	fs     *objectFS
+	mu     sync.Mutex
+	name   string // Empty when the file was replaced by a rename.
+	local  File   // The local copy of the file, nil until the file is written.
+	size   int64
+	offset int64
+	dirty  bool // The local copy has changes not uploaded yet.
+	closed bool
+}
+
+func (f *objectFile) setName(name string) {
+	f.mu.Lock()
+	f.name = name
+	f.mu.Unlock()
+}
+
+// materialize copies the object to the local file system.
+// The caller must hold the file lock.
+func (f *objectFile) materialize() error {
+	if f.local != nil {
+		return nil
+	}
+	data, err := f.fs.store.Get(objectKey(f.name), 0, -1)
+	if err != nil {
+		return err
+	}
+	lf, err := f.fs.local.OpenFile(f.name, os.O_CREATE|os.O_RDWR|os.O_TRUNC, os.FileMode(0640))
+	if err != nil {
+		return err
+	}
+	if _, err := lf.WriteAt(data, 0); err != nil {
+		_ = lf.Close()
+		return err
+	}
+	f.local = lf
+	f.size = int64(len(data))
+	f.fs.blocks.invalidate(f.name)
+	return nil
+}
+
+func (f *objectFile) readAt(p []byte, off int64) (int, error) {
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	if f.local != nil {
+		return f.local.ReadAt(p, off)
+	}
+	if off >= f.size {
+		return 0, io.EOF
+	}
+	n := 0
+	for n < len(p) && off < f.size {
+		block, err := f.fs.blocks.get(f.fs.store, f.name, off/f.fs.blocks.blockSize)
+		if err != nil {
+			return n, err
+		}
+		start := off % f.fs.blocks.blockSize
+		if start >= int64(len(block)) {
+			break
+		}
+		c := copy(p[n:], block[start:])
+		n += c
+		off += int64(c)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *objectFile) ReadAt(p []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.readAt(p, off)
+}
+
+func (f *objectFile) Read(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n, err := f.readAt(p, f.offset)
+	f.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (f *objectFile) writeAt(p []byte, off int64) (int, error) {
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	if err := f.materialize(); err != nil {
+		return 0, err
+	}
+	n, err := f.local.WriteAt(p, off)
+	if off+int64(n) > f.size {
+		f.size = off + int64(n)
+	}
+	f.dirty = true
+	return n, err
+}
+
+func (f *objectFile) WriteAt(p []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.writeAt(p, off)
+}
+
+func (f *objectFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n, err := f.writeAt(p, f.offset)
+	f.offset += int64(n)
+	return n, err
+}
+
+func (f *objectFile) Seek(offset int64, whence int) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	switch whence {
+	case io.SeekEnd:
+		f.offset = f.size + offset
+	case io.SeekStart:
+		f.offset = offset
+	case io.SeekCurrent:
+		f.offset += offset
+	}
+	return f.offset, nil
+}
+
+func (f *objectFile) Stat() (os.FileInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return nil, os.ErrClosed
+	}
+	return objectFileInfo{name: filepath.Base(f.name), size: f.size, modTime: time.Now()}, nil
+}
+
+// upload writes the local copy to the object storage.
+// The caller must hold the file lock.
+func (f *objectFile) upload() error {
+	if !f.dirty || f.name == "" {
+		return nil
+	}
+	data := make([]byte, f.size)
+	if _, err := f.local.ReadAt(data, 0); err != nil && err != io.EOF {
+		return err
+	}
+	if err := f.fs.store.Put(objectKey(f.name), data); err != nil {
+		return err
+	}
+	f.dirty = false
+	return nil
+}
+
+func (f *objectFile) Sync() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return os.ErrClosed
+	}
+	if err := f.upload(); err != nil {
+		return err
+	}
+	if f.local != nil {
+		return f.local.Sync()
+	}
+	return nil
+}
+
+func (f *objectFile) Truncate(size int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return os.ErrClosed
+	}
+	if err := f.materialize(); err != nil {
+		return err
+	}
+	if err := f.local.Truncate(size); err != nil {
+		return err
+	}
+	f.size = size
+	f.dirty = true
+	return nil
+}
+
+func (f *objectFile) Slice(start int64, end int64) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return nil, os.ErrClosed
+	}
+	if end > f.size {
+		return nil, io.EOF
+	}
+	if f.local != nil {
+		return f.local.Slice(start, end)
+	}
+	buf := make([]byte, end-start)
+	if _, err := f.readAt(buf, start); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return buf, nil
+}
+
+func (f *objectFile) Close() error {
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		return os.ErrClosed
+	}
+	err := f.upload()
+	if f.local != nil {
+		if cerr := f.local.Close(); err == nil {
+			err = cerr
+		}
+	}
+	f.closed = true
+	f.mu.Unlock()
+	// The file system lock is taken after releasing the file lock, Rename takes them in the opposite order.
+	f.fs.mu.Lock()
+	delete(f.fs.files, f)
+	f.fs.mu.Unlock()
+	return err
+}
+
+type objectFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (fi objectFileInfo) Name() string       { return fi.name }
+func (fi objectFileInfo) Size() int64        { return fi.size }
+func (fi objectFileInfo) Mode() os.FileMode  { return os.FileMode(0640) }
+func (fi objectFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi objectFileInfo) IsDir() bool        { return false }
+func (fi objectFileInfo) Sys() interface{}   { return nil }
+
+type blockKey struct {
+	name  string
+	index int64
+}
+
+type blockEntry struct {
+	key  blockKey
+	data []byte
+}
+
+// blockCache is an LRU cache of object blocks.
+type blockCache struct {
+	blockSize int64
+	maxSize   int64
+	mu        sync.Mutex
+	size      int64
+	lru       *list.List // Front is the most recently used block.
+	blocks    map[blockKey]*list.Element
+}
+
+func newBlockCache(blockSize int64, maxSize int64) *blockCache {
+	return &blockCache{
+		blockSize: blockSize,
+		maxSize:   maxSize,
+		lru:       list.New(),
+		blocks:    map[blockKey]*list.Element{},
+	}
+}
+
+func (c *blockCache) get(store ObjectStore, name string, index int64) ([]byte, error) {
+	key := blockKey{name: name, index: index}
+	c.mu.Lock()
+	if el, ok := c.blocks[key]; ok {
+		c.lru.MoveToFront(el)
+		c.mu.Unlock()
+		return el.Value.(*blockEntry).data, nil
+	}
+	c.mu.Unlock()
+
+	data, err := store.Get(objectKey(name), index*c.blockSize, c.blockSize)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.blocks[key]; !ok {
+		c.blocks[key] = c.lru.PushFront(&blockEntry{key: key, data: data})
+		c.size += int64(len(data))
+	}
+	for c.size > c.maxSize && c.lru.Len() > 1 {
+		c.remove(c.lru.Back())
+	}
+	return data, nil
+}
+
+// remove evicts the block. The caller must hold the cache lock.
+func (c *blockCache) remove(el *list.Element) {
+	entry := c.lru.Remove(el).(*blockEntry)
+	delete(c.blocks, entry.key)
+	c.size -= int64(len(entry.data))
+}
+
+// invalidate evicts all blocks of the file.
+func (c *blockCache) invalidate(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for el := c.lru.Front(); el != nil; {
+		next := el.Next()
+		if el.Value.(*blockEntry).key.name == name {
+			c.remove(el)
+		}
+		el = next
+	}
+}
@@ -0,0 +1,148 @@
+package fs
+
+import (
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/domaincrawler/pogreb/internal/assert"
+)
+
+// memObjectStore is an ObjectStore backed by memory.
+type memObjectStore struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	gets    int
+}
+
+func newMemObjectStore() *memObjectStore {
+	return &memObjectStore{objects: map[string][]byte{}}
+}
+
+func (s *memObjectStore) Get(key string, off int64, n int64) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gets++
+	data, ok := s.objects[key]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	if off > int64(len(data)) {
+		off = int64(len(data))
+	}
+	end := int64(len(data))
+	if n >= 0 && off+n < end {
+		end = off + n
+	}
+	return append([]byte(nil), data[off:end]...), nil
+}
+
+func (s *memObjectStore) Put(key string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[key] = append([]byte(nil), data...)
+	return nil
+}
+
+func (s *memObjectStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.objects, key)
+	return nil
+}
+
+func (s *memObjectStore) Stat(key string) (ObjectInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.objects[key]
+	if !ok {
+		return ObjectInfo{}, os.ErrNotExist
+	}
+	return ObjectInfo{Key: key, Size: int64(len(data)), ModTime: time.Now()}, nil
+}
+
+func (s *memObjectStore) List(prefix string) ([]ObjectInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var infos []ObjectInfo
+	for key, data := range s.objects {
+		if strings.HasPrefix(key, prefix) {
+			infos = append(infos, ObjectInfo{Key: key, Size: int64(len(data))})
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Key < infos[j].Key })
+	return infos, nil
+}
+
+func TestObjectFS(t *testing.T) {
+	testFS(t, Object(newMemObjectStore(), nil))
+}
+
+func TestObjectLockFile(t *testing.T) {
+	testLockFile(t, Object(newMemObjectStore(), nil))
+}
+
+func TestObjectLockAcquireExisting(t *testing.T) {
+	testLockFileAcquireExisting(t, Object(newMemObjectStore(), nil))
+}
+
+func TestObjectReadThrough(t *testing.T) {
+	store := newMemObjectStore()
+	data := make([]byte, 100)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	assert.Nil(t, store.Put("db/seg", data))
+	assert.Nil(t, store.Put("db/sub/other", nil))
+
+	fsys := Object(store, &ObjectOptions{BlockSize: 16, CacheSize: 32})
+	f, err := fsys.OpenFile("db/seg", os.O_RDWR, 0)
+	assert.Nil(t, err)
+
+	// Reads fetch blocks, a read spanning two blocks fetches both.
+	b, err := f.Slice(10, 20)
+	assert.Nil(t, err)
+	assert.Equal(t, data[10:20], b)
+	assert.Equal(t, 2, store.gets)
+	b, err = f.Slice(12, 18)
+	assert.Nil(t, err)
+	assert.Equal(t, data[12:18], b)
+	assert.Equal(t, 2, store.gets)
+
+	// The cache holds two blocks, the least recently used block is evicted.
+	_, err = f.Slice(40, 41)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, store.gets)
+	_, err = f.Slice(10, 11)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, store.gets)
+
+	// Writes are buffered locally until Sync.
+	_, err = f.WriteAt([]byte{0xff}, 99)
+	assert.Nil(t, err)
+	assert.Equal(t, byte(99), store.objects["db/seg"][99])
+	assert.Nil(t, f.Sync())
+	assert.Equal(t, byte(0xff), store.objects["db/seg"][99])
+	assert.Nil(t, f.Close())
+
+	fis, err := fsys.ReadDir("db")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(fis))
+	assert.Equal(t, "seg", fis[0].Name())
+	assert.Equal(t, int64(100), fis[0].Size())
+
+	// Another file system instance reads the uploaded data.
+	fsys2 := Object(store, nil)
+	f, err = fsys2.OpenFile("db/seg", os.O_RDWR, 0)
+	assert.Nil(t, err)
+	b, err = f.Slice(98, 100)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{98, 0xff}, b)
+	assert.Nil(t, f.Close())
+
+	_, err = fsys2.OpenFile("db/missing", os.O_RDWR, 0)
+	assert.Equal(t, true, os.IsNotExist(err))
+}
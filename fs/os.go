@@ -11,13 +11,21 @@ type osFS struct{}
 var OS FileSystem = &osFS{}
 
 func (fs *osFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
-	f, err := os.OpenFile(name, flag, perm)
+	f, err := openFile(name, flag, perm)
 	if err != nil {
 		return nil, err
 	}
 	return &osFile{File: f}, nil
 }
 
+func (fs *osFS) OpenMmapFile(name string, flag int, perm os.FileMode) (File, error) {
+	f, err := openMMapFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
 func (fs *osFS) CreateLockFile(name string, perm os.FileMode) (LockFile, bool, error) {
 	return createLockFile(name, perm)
 }
@@ -30,8 +38,16 @@ func (fs *osFS) Remove(name string) error {
 	return os.Remove(name)
 }
 
+func (fs *osFS) Link(oldname, newname string) error {
+	return os.Link(oldname, newname)
+}
+
 func (fs *osFS) Rename(oldpath, newpath string) error {
-	return os.Rename(oldpath, newpath)
+	return rename(oldpath, newpath)
+}
+
+func (fs *osFS) SyncDir(name string) error {
+	return syncDir(name)
 }
 
 func (fs *osFS) ReadDir(name string) ([]os.FileInfo, error) {
@@ -40,6 +56,28 @@ func (fs *osFS) ReadDir(name string) ([]os.FileInfo, error) {
 
 type osFile struct {
 	*os.File
+	direct *directWriter // Nil if the file isn't written with direct I/O.
+}
+
+func (f *osFile) WriteAt(p []byte, off int64) (int, error) {
+	if f.direct != nil {
+		return f.direct.writeAt(f.File, p, off)
+	}
+	return f.File.WriteAt(p, off)
+}
+
+func (f *osFile) Truncate(size int64) error {
+	if f.direct != nil {
+		f.direct.invalidate()
+	}
+	return f.File.Truncate(size)
+}
+
+func (f *osFile) Close() error {
+	if f.direct != nil {
+		_ = f.direct.close()
+	}
+	return f.File.Close()
 }
 
 func (f *osFile) Slice(start int64, end int64) ([]byte, error) {
@@ -17,12 +17,24 @@ type osMMapFS struct {
 var OSMMap FileSystem = &osMMapFS{}
 
 func (fs *osMMapFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	f, err := openMMapFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func openMMapFile(name string, flag int, perm os.FileMode) (*osMMapFile, error) {
+	return openMMapFileWith(name, flag, perm, false)
+}
+
+func openMMapFileWith(name string, flag int, perm os.FileMode, writable bool) (*osMMapFile, error) {
 	if flag&os.O_APPEND != 0 {
 		// osMMapFS doesn't support opening files in append-only mode.
 		// The database doesn't currently use O_APPEND.
 		return nil, errAppendModeNotSupported
 	}
-	f, err := os.OpenFile(name, flag, perm)
+	f, err := openFile(name, flag, perm)
 	if err != nil {
 		return nil, err
 	}
@@ -33,8 +45,9 @@ func (fs *osMMapFS) OpenFile(name string, flag int, perm os.FileMode) (File, err
 	}
 
 	mf := &osMMapFile{
-		File: f,
-		size: stat.Size(),
+		File:     f,
+		size:     stat.Size(),
+		writable: writable,
 	}
 	if err := mf.mremap(); err != nil {
 		return nil, err
@@ -48,10 +61,18 @@ type osMMapFile struct {
 	offset   int64
 	size     int64
 	mmapSize int64
+	direct   *directWriter // Nil if the file isn't written with direct I/O.
+	writable bool          // The file is mapped for writing.
 }
 
 func (f *osMMapFile) WriteAt(p []byte, off int64) (int, error) {
-	n, err := f.File.WriteAt(p, off)
+	writeAt := f.File.WriteAt
+	if f.direct != nil {
+		writeAt = func(p []byte, off int64) (int, error) {
+			return f.direct.writeAt(f.File, p, off)
+		}
+	}
+	n, err := writeAt(p, off)
 	if err != nil {
 		return 0, err
 	}
@@ -115,7 +136,7 @@ func (f *osMMapFile) mmap(fileSize int64, mappingSize int64) error {
 		}
 	}
 
-	data, err := mmap(f.File, fileSize, mappingSize)
+	data, err := mmap(f.File, fileSize, mappingSize, f.writable)
 	if err != nil {
 		return err
 	}
@@ -159,5 +180,8 @@ func (f *osMMapFile) Close() error {
 	if err := f.munmap(); err != nil {
 		return err
 	}
+	if f.direct != nil {
+		_ = f.direct.close()
+	}
 	return f.File.Close()
 }
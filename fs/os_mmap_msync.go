@@ -0,0 +1,17 @@
+//go:build linux || darwin || freebsd || dragonfly || openbsd
+// +build linux darwin freebsd dragonfly openbsd
+
+package fs
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+func (f *osWritableMMapFile) msync(data []byte) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_MSYNC, uintptr(unsafe.Pointer(&data[0])), uintptr(len(data)), uintptr(syscall.MS_SYNC))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !freebsd && !dragonfly && !openbsd && !windows
+// +build !linux,!darwin,!freebsd,!dragonfly,!openbsd,!windows
+
+package fs
+
+// msync falls back to syncing the whole file on platforms without the msync system call.
+func (f *osWritableMMapFile) msync(data []byte) error {
+	return f.File.Sync()
+}
@@ -1,9 +1,44 @@
 package fs
 
 import (
+	"errors"
+	"os"
 	"testing"
 )
 
 func TestOSMMapFS(t *testing.T) {
 	testFS(t, OSMMap)
 }
+
+func TestOSOpenMmapFile(t *testing.T) {
+	testFS(t, &mmapOnlyFS{OS.(MmapFileSystem)})
+}
+
+// mmapOnlyFS opens all files using OpenMmapFile.
+type mmapOnlyFS struct {
+	MmapFileSystem
+}
+
+func (fs *mmapOnlyFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	f, err := fs.OpenMmapFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := f.(*osMMapFile); !ok {
+		return nil, errors.New("not a memory-mapped file")
+	}
+	return f, nil
+}
+
+// writableMmapFS opens all files memory-mapped for writing.
+type writableMmapFS struct {
+	osMMapFS
+}
+
+func (fs *writableMmapFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	return fs.OpenWritableMmapFile(name, flag, perm)
+}
+
+func TestOSWritableMmapFS(t *testing.T) {
+	testFS(t, &writableMmapFS{})
+}
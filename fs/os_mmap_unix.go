@@ -9,8 +9,12 @@ import (
 	"unsafe"
 )
 
-func mmap(f *os.File, fileSize int64, mappingSize int64) ([]byte, error) {
-	p, err := syscall.Mmap(int(f.Fd()), 0, int(mappingSize), syscall.PROT_READ, syscall.MAP_SHARED)
+func mmap(f *os.File, fileSize int64, mappingSize int64, writable bool) ([]byte, error) {
+	prot := syscall.PROT_READ
+	if writable {
+		prot |= syscall.PROT_WRITE
+	}
+	p, err := syscall.Mmap(int(f.Fd()), 0, int(mappingSize), prot, syscall.MAP_SHARED)
 	return p, err
 }
 
@@ -27,6 +31,9 @@ func madviceRandom(data []byte) error {
 }
 
 func (f *osMMapFile) Truncate(size int64) error {
+	if f.direct != nil {
+		f.direct.invalidate()
+	}
 	if err := f.File.Truncate(size); err != nil {
 		return err
 	}
@@ -9,7 +9,8 @@ import (
 	"unsafe"
 )
 
-func mmap(f *os.File, fileSize int64, mappingSize int64) ([]byte, error) {
+// mmap maps the file read-only, files aren't mapped for writing on Windows.
+func mmap(f *os.File, fileSize int64, mappingSize int64, writable bool) ([]byte, error) {
 	size := fileSize
 	low, high := uint32(size), uint32(size>>32)
 	fmap, err := syscall.CreateFileMapping(syscall.Handle(f.Fd()), nil, syscall.PAGE_READONLY, high, low, nil)
@@ -34,6 +35,9 @@ func madviceRandom(data []byte) error {
 }
 
 func (f *osMMapFile) Truncate(size int64) error {
+	if f.direct != nil {
+		f.direct.invalidate()
+	}
 	// Truncating a memory-mapped file fails on Windows. Unmap it first.
 	if err := f.munmap(); err != nil {
 		return err
@@ -0,0 +1,119 @@
+//go:build !windows
+// +build !windows
+
+package fs
+
+import (
+	"io"
+	"os"
+)
+
+// OpenWritableMmapFile opens a file memory-mapped for reading and writing.
+func (fs *osMMapFS) OpenWritableMmapFile(name string, flag int, perm os.FileMode) (File, error) {
+	mf, err := openMMapFileWith(name, flag, perm, true)
+	if err != nil {
+		return nil, err
+	}
+	return &osWritableMMapFile{osMMapFile: mf, pageSize: int64(os.Getpagesize())}, nil
+}
+
+// osWritableMMapFile tracks pages modified since the last sync, Sync flushes only them with msync.
+type osWritableMMapFile struct {
+	*osMMapFile
+	pageSize int64
+	dirty    []uint64 // Bitmap of modified pages.
+	resized  bool     // The file size changed since the last sync.
+}
+
+func (f *osWritableMMapFile) markDirty(start int64, end int64) {
+	for page := start / f.pageSize; page*f.pageSize < end; page++ {
+		word := int(page / 64)
+		for word >= len(f.dirty) {
+			f.dirty = append(f.dirty, 0)
+		}
+		f.dirty[word] |= 1 << uint(page%64)
+	}
+}
+
+func (f *osWritableMMapFile) MutableSlice(start int64, end int64) ([]byte, error) {
+	if end > f.size {
+		return nil, io.EOF
+	}
+	if f.data == nil {
+		return nil, os.ErrClosed
+	}
+	f.markDirty(start, end)
+	return f.data[start:end], nil
+}
+
+func (f *osWritableMMapFile) WriteAt(p []byte, off int64) (int, error) {
+	size := f.size
+	n, err := f.osMMapFile.WriteAt(p, off)
+	if f.size != size {
+		f.resized = true
+	}
+	f.markDirty(off, off+int64(n))
+	return n, err
+}
+
+func (f *osWritableMMapFile) Write(p []byte) (int, error) {
+	off, size := f.offset, f.size
+	n, err := f.osMMapFile.Write(p)
+	if f.size != size {
+		f.resized = true
+	}
+	f.markDirty(off, off+int64(n))
+	return n, err
+}
+
+func (f *osWritableMMapFile) Truncate(size int64) error {
+	if err := f.osMMapFile.Truncate(size); err != nil {
+		return err
+	}
+	f.resized = true
+	// Forget pages past the end of the file.
+	lastPage := (size + f.pageSize - 1) / f.pageSize
+	for page := lastPage; page < int64(len(f.dirty))*64; page++ {
+		f.dirty[page/64] &^= 1 << uint(page%64)
+	}
+	return nil
+}
+
+// Sync writes the modified pages with msync and commits the file size if it changed.
+func (f *osWritableMMapFile) Sync() error {
+	for page := int64(0); page < int64(len(f.dirty))*64; {
+		if f.dirty[page/64] == 0 {
+			page += 64 - page%64
+			continue
+		}
+		if f.dirty[page/64]&(1<<uint(page%64)) == 0 {
+			page++
+			continue
+		}
+		// Flush the run of consecutive dirty pages starting at the page.
+		end := page
+		for end < int64(len(f.dirty))*64 && f.dirty[end/64]&(1<<uint(end%64)) != 0 {
+			f.dirty[end/64] &^= 1 << uint(end%64)
+			end++
+		}
+		start, stop := page*f.pageSize, end*f.pageSize
+		if stop > f.size {
+			stop = f.size
+		}
+		if start < stop {
+			if err := f.msync(f.data[start:stop]); err != nil {
+				return err
+			}
+		}
+		page = end
+	}
+	if f.resized {
+		if err := f.File.Sync(); err != nil {
+			return err
+		}
+	}
+	f.resized = false
+	return nil
+}
+
+var _ WritableMmapFile = &osWritableMMapFile{}
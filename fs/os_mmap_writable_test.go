@@ -0,0 +1,61 @@
+//go:build !windows
+// +build !windows
+
+package fs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/domaincrawler/pogreb/internal/assert"
+)
+
+func TestOSWritableMmapFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pogreb")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "test")
+
+	f, err := OSMMap.(WritableMmapFileSystem).OpenWritableMmapFile(name, os.O_CREATE|os.O_RDWR, 0640)
+	assert.Nil(t, err)
+	defer f.Close()
+	mf, ok := f.(WritableMmapFile)
+	if !ok {
+		t.Skip("writable memory mappings are not supported")
+	}
+	wf := mf.(*osWritableMMapFile)
+	pageSize := int64(os.Getpagesize())
+	assert.Nil(t, mf.Truncate(4*pageSize))
+
+	_, err = mf.MutableSlice(0, 4*pageSize+1)
+	assert.NotNil(t, err)
+
+	// Modify the second and the third page.
+	buf, err := mf.MutableSlice(2*pageSize-1, 2*pageSize+1)
+	assert.Nil(t, err)
+	copy(buf, "ab")
+	assert.Equal(t, []uint64{0b110}, wf.dirty)
+
+	// The modification is visible to readers of the file.
+	data, err := ioutil.ReadFile(name)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("ab"), data[2*pageSize-1:2*pageSize+1])
+
+	_, err = mf.WriteAt([]byte{1}, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []uint64{0b111}, wf.dirty)
+
+	assert.Nil(t, mf.Sync())
+	assert.Equal(t, []uint64{0}, wf.dirty)
+	assert.Equal(t, false, wf.resized)
+
+	// Pages past the end of the file are forgotten by Truncate.
+	buf, err = mf.MutableSlice(3*pageSize, 3*pageSize+1)
+	assert.Nil(t, err)
+	buf[0] = 1
+	assert.Nil(t, mf.Truncate(pageSize))
+	assert.Equal(t, []uint64{0}, wf.dirty)
+	assert.Nil(t, mf.Sync())
+}
@@ -0,0 +1,14 @@
+//go:build windows
+// +build windows
+
+package fs
+
+import (
+	"os"
+)
+
+// OpenWritableMmapFile opens a memory-mapped file. Files aren't mapped for writing on Windows,
+// writes go through the file.
+func (fs *osMMapFS) OpenWritableMmapFile(name string, flag int, perm os.FileMode) (File, error) {
+	return fs.OpenMmapFile(name, flag, perm)
+}
@@ -1,6 +1,8 @@
 package fs
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -15,3 +17,49 @@ func TestOSLockFile(t *testing.T) {
 func TestOSLockAcquireExisting(t *testing.T) {
 	testLockFileAcquireExisting(t, OS)
 }
+
+func TestOSLink(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "a")
+	if err := os.WriteFile(name, []byte("foo"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Sub(OS, dir).(LinkFileSystem).Link("a", "b"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "b"))
+	if err != nil || string(data) != "foo" {
+		t.Fatal(string(data), err)
+	}
+	if err := Sub(Mem, ".").(LinkFileSystem).Link("a", "b"); err != errLinkNotSupported {
+		t.Fatal(err)
+	}
+}
+
+func TestOSSyncDir(t *testing.T) {
+	dir := t.TempDir()
+	if err := Sub(OS, dir).(DirSyncFileSystem).SyncDir("."); err != nil {
+		t.Fatal(err)
+	}
+	if err := Sub(Mem, ".").(DirSyncFileSystem).SyncDir("."); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOSFreeSpace(t *testing.T) {
+	free, err := OS.(FreeSpaceFileSystem).FreeSpace(".")
+	if err == errFreeSpaceNotSupported {
+		t.Skip(err)
+	}
+	if err != nil || free == 0 {
+		t.Fatal(free, err)
+	}
+	_, err = Sub(OS, ".").(FreeSpaceFileSystem).FreeSpace(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = Sub(Mem, ".").(FreeSpaceFileSystem).FreeSpace(".")
+	if err != errFreeSpaceNotSupported {
+		t.Fatal(err)
+	}
+}
@@ -25,3 +25,24 @@ func createLockFile(name string, perm os.FileMode) (LockFile, bool, error) {
 	}
 	return &osLockFile{f, name}, acquiredExisting, nil
 }
+
+func openFile(name string, flag int, perm os.FileMode) (*os.File, error) {
+	return os.OpenFile(name, flag, perm)
+}
+
+func rename(oldpath, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
+
+// syncDir makes the renames into the directory durable.
+func syncDir(name string) error {
+	d, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	if err := d.Sync(); err != nil {
+		_ = d.Close()
+		return err
+	}
+	return d.Close()
+}
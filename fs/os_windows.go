@@ -10,12 +10,19 @@ import (
 )
 
 var (
-	modkernel32    = syscall.NewLazyDLL("kernel32.dll")
-	procLockFileEx = modkernel32.NewProc("LockFileEx")
+	modkernel32            = syscall.NewLazyDLL("kernel32.dll")
+	procLockFileEx         = modkernel32.NewProc("LockFileEx")
+	procMoveFileEx         = modkernel32.NewProc("MoveFileExW")
+	procGetDiskFreeSpaceEx = modkernel32.NewProc("GetDiskFreeSpaceExW")
 )
 
 const (
 	errorLockViolation = 0x21
+
+	fileFlagWriteThrough    = 0x80000000
+	fsctlSetSparse          = 0x000900c4
+	movefileReplaceExisting = 0x1
+	movefileWriteThrough    = 0x8
 )
 
 func lockfile(f *os.File) error {
@@ -59,3 +66,100 @@ func createLockFile(name string, perm os.FileMode) (LockFile, bool, error) {
 	}
 	return &osLockFile{f, name}, acquiredExisting, nil
 }
+
+// openFile opens the file like os.OpenFile, with the following differences:
+//   - The file is opened with FILE_SHARE_DELETE, so that it can be renamed over or removed while it's open,
+//     matching the behavior on other platforms.
+//   - os.O_SYNC opens the file with FILE_FLAG_WRITE_THROUGH, writes bypass the disk write cache.
+//   - New files are marked sparse, so that extending a file with Truncate doesn't allocate disk space.
+func openFile(name string, flag int, perm os.FileMode) (*os.File, error) {
+	pathp, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	var access uint32
+	switch flag & (os.O_RDONLY | os.O_WRONLY | os.O_RDWR) {
+	case os.O_RDONLY:
+		access = syscall.GENERIC_READ
+	case os.O_WRONLY:
+		access = syscall.GENERIC_WRITE
+	case os.O_RDWR:
+		access = syscall.GENERIC_READ | syscall.GENERIC_WRITE
+	}
+	var createmode uint32
+	switch {
+	case flag&(os.O_CREATE|os.O_EXCL) == (os.O_CREATE | os.O_EXCL):
+		createmode = syscall.CREATE_NEW
+	case flag&(os.O_CREATE|os.O_TRUNC) == (os.O_CREATE | os.O_TRUNC):
+		createmode = syscall.CREATE_ALWAYS
+	case flag&os.O_CREATE == os.O_CREATE:
+		createmode = syscall.OPEN_ALWAYS
+	case flag&os.O_TRUNC == os.O_TRUNC:
+		createmode = syscall.TRUNCATE_EXISTING
+	default:
+		createmode = syscall.OPEN_EXISTING
+	}
+	var attrs uint32 = syscall.FILE_ATTRIBUTE_NORMAL
+	if flag&os.O_SYNC != 0 {
+		attrs |= fileFlagWriteThrough
+	}
+	_, statErr := os.Stat(name)
+	sharemode := uint32(syscall.FILE_SHARE_READ | syscall.FILE_SHARE_WRITE | syscall.FILE_SHARE_DELETE)
+	h, err := syscall.CreateFile(pathp, access, sharemode, nil, createmode, attrs, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	if os.IsNotExist(statErr) && access&syscall.GENERIC_WRITE != 0 {
+		// Sparse files aren't supported by all file systems, e.g. FAT. The file is usable either way.
+		var n uint32
+		_ = syscall.DeviceIoControl(h, fsctlSetSparse, nil, 0, nil, 0, &n, nil)
+	}
+	return os.NewFile(uintptr(h), name), nil
+}
+
+// syncDir does nothing, directories can't be synced on Windows. Renames made by rename are written through.
+func syncDir(name string) error {
+	return nil
+}
+
+// rename replaces newpath with oldpath, the rename is flushed to disk before returning.
+func rename(oldpath, newpath string) error {
+	from, err := syscall.UTF16PtrFromString(oldpath)
+	if err != nil {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: err}
+	}
+	to, err := syscall.UTF16PtrFromString(newpath)
+	if err != nil {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: err}
+	}
+	r1, _, err := syscall.Syscall(
+		procMoveFileEx.Addr(),
+		3,
+		uintptr(unsafe.Pointer(from)),
+		uintptr(unsafe.Pointer(to)),
+		uintptr(movefileReplaceExisting|movefileWriteThrough),
+	)
+	if r1 == 0 {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: err}
+	}
+	return nil
+}
+
+func (fs *osFS) FreeSpace(name string) (uint64, error) {
+	pathp, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return 0, &os.PathError{Op: "statfs", Path: name, Err: err}
+	}
+	var free uint64
+	r1, _, err := syscall.Syscall6(procGetDiskFreeSpaceEx.Addr(), 4,
+		uintptr(unsafe.Pointer(pathp)),
+		uintptr(unsafe.Pointer(&free)),
+		0,
+		0,
+		0,
+		0)
+	if r1 == 0 {
+		return 0, &os.PathError{Op: "statfs", Path: name, Err: err}
+	}
+	return free, nil
+}
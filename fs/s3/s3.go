@@ -0,0 +1,365 @@
+/*
+Package s3 implements fs.ObjectStore for Amazon S3 and S3-compatible object storage.
+
+Requests are signed with AWS Signature Version 4 and use path-style URLs,
+which are supported by S3 as well as MinIO, Ceph and other compatible servers.
+*/
+package s3
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/domaincrawler/pogreb/fs"
+)
+
+const (
+	timeFormat = "20060102T150405Z"
+	dateFormat = "20060102"
+	service    = "s3"
+
+	emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// Config holds the parameters of the object storage client.
+type Config struct {
+	// Endpoint is the base URL of the object storage service, e.g. "https://s3.us-east-1.amazonaws.com".
+	Endpoint string
+
+	// Region is the region used to sign requests.
+	//
+	// Default: "us-east-1".
+	Region string
+
+	// Bucket is the name of the bucket holding the objects.
+	Bucket string
+
+	// Prefix is prepended to all object keys, it allows storing multiple databases in one bucket.
+	Prefix string
+
+	// AccessKeyID and SecretAccessKey are the credentials used to sign requests.
+	// Requests are sent unsigned when AccessKeyID is empty.
+	AccessKeyID     string
+	SecretAccessKey string
+
+	// SessionToken is the token of temporary security credentials.
+	SessionToken string
+
+	// HTTPClient is used to send requests.
+	//
+	// Default: http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+// Store is an fs.ObjectStore backed by an S3 bucket.
+type Store struct {
+	cfg      Config
+	endpoint *url.URL
+	now      func() time.Time
+}
+
+var _ fs.ObjectStore = &Store{}
+
+// New returns a new Store.
+func New(cfg Config) (*Store, error) {
+	if cfg.Bucket == "" {
+		return nil, errors.New("s3: bucket is required")
+	}
+	endpoint, err := url.Parse(strings.TrimSuffix(cfg.Endpoint, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("s3: parsing endpoint: %w", err)
+	}
+	if endpoint.Scheme == "" || endpoint.Host == "" {
+		return nil, fmt.Errorf("s3: invalid endpoint %q", cfg.Endpoint)
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = http.DefaultClient
+	}
+	return &Store{cfg: cfg, endpoint: endpoint, now: time.Now}, nil
+}
+
+// Error is returned when the service responds with an error.
+type Error struct {
+	StatusCode int
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+}
+
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("s3: %s", http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("s3: %s: %s", e.Code, e.Message)
+}
+
+// Is reports missing objects as os.ErrNotExist.
+func (e *Error) Is(target error) bool {
+	return target == os.ErrNotExist && e.StatusCode == http.StatusNotFound
+}
+
+func (s *Store) objectURL(key string) *url.URL {
+	u := *s.endpoint
+	u.Path = u.Path + "/" + s.cfg.Bucket + "/" + s.cfg.Prefix + key
+	return &u
+}
+
+func (s *Store) do(method string, u *url.URL, header http.Header, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if body != nil {
+		req.ContentLength = int64(len(body))
+	}
+	s.sign(req, body)
+	resp, err := s.cfg.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		serr := &Error{StatusCode: resp.StatusCode}
+		if data, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<16)); err == nil && len(data) > 0 {
+			_ = xml.Unmarshal(data, serr)
+		}
+		return nil, serr
+	}
+	return resp, nil
+}
+
+// Get reads n bytes of the object starting at off. It reads until the end of the object when n is negative.
+func (s *Store) Get(key string, off int64, n int64) ([]byte, error) {
+	if n == 0 {
+		return nil, nil
+	}
+	header := http.Header{}
+	if n > 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+n-1))
+	} else if off > 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-", off))
+	}
+	resp, err := s.do(http.MethodGet, s.objectURL(key), header, nil)
+	if err != nil {
+		var serr *Error
+		if errors.As(err, &serr) && serr.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return ioutil.ReadAll(resp.Body)
+}
+
+// Put uploads the object, replacing the existing object with the same key.
+func (s *Store) Put(key string, data []byte) error {
+	if data == nil {
+		data = []byte{}
+	}
+	resp, err := s.do(http.MethodPut, s.objectURL(key), nil, data)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// Delete removes the object. Deleting a missing object isn't an error.
+func (s *Store) Delete(key string) error {
+	resp, err := s.do(http.MethodDelete, s.objectURL(key), nil, nil)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// Stat returns the object info.
+func (s *Store) Stat(key string) (fs.ObjectInfo, error) {
+	resp, err := s.do(http.MethodHead, s.objectURL(key), nil, nil)
+	if err != nil {
+		return fs.ObjectInfo{}, err
+	}
+	defer resp.Body.Close()
+	info := fs.ObjectInfo{Key: key, Size: resp.ContentLength}
+	if t, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		info.ModTime = t
+	}
+	return info, nil
+}
+
+type listResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// List returns the objects with keys starting with the prefix, sorted by key.
+func (s *Store) List(prefix string) ([]fs.ObjectInfo, error) {
+	var infos []fs.ObjectInfo
+	token := ""
+	for {
+		u := *s.endpoint
+		u.Path = u.Path + "/" + s.cfg.Bucket
+		q := url.Values{}
+		q.Set("list-type", "2")
+		q.Set("prefix", s.cfg.Prefix+prefix)
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+		u.RawQuery = q.Encode()
+		resp, err := s.do(http.MethodGet, &u, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		res := listResult{}
+		err = xml.NewDecoder(resp.Body).Decode(&res)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("s3: decoding list response: %w", err)
+		}
+		for _, c := range res.Contents {
+			infos = append(infos, fs.ObjectInfo{
+				Key:     strings.TrimPrefix(c.Key, s.cfg.Prefix),
+				Size:    c.Size,
+				ModTime: c.LastModified,
+			})
+		}
+		if !res.IsTruncated || res.NextContinuationToken == "" {
+			break
+		}
+		token = res.NextContinuationToken
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Key < infos[j].Key })
+	return infos, nil
+}
+
+func sha256Hex(data []byte) string {
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// escapePath encodes the URL path as required by Signature Version 4.
+func escapePath(path string) string {
+	var b strings.Builder
+	for _, c := range []byte(path) {
+		if c == '/' || c == '-' || c == '_' || c == '.' || c == '~' ||
+			('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// canonicalQuery encodes the query string as required by Signature Version 4.
+func canonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		vals := append([]string(nil), q[k]...)
+		sort.Strings(vals)
+		for _, v := range vals {
+			parts = append(parts, escapePath(k)+"="+strings.ReplaceAll(escapePath(v), "/", "%2F"))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// sign adds the Signature Version 4 authorization header to the request.
+func (s *Store) sign(req *http.Request, body []byte) {
+	if s.cfg.AccessKeyID == "" {
+		return
+	}
+	t := s.now().UTC()
+	payloadHash := emptyPayloadHash
+	if len(body) > 0 {
+		payloadHash = sha256Hex(body)
+	}
+	req.Header.Set("X-Amz-Date", t.Format(timeFormat))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.cfg.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.cfg.SessionToken)
+	}
+	req.Header.Set("Authorization", s.authorization(req, t, payloadHash))
+}
+
+func (s *Store) authorization(req *http.Request, t time.Time, payloadHash string) string {
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		lk := strings.ToLower(k)
+		if lk == "authorization" {
+			continue
+		}
+		headers[lk] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.Path
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		escapePath(path),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := t.Format(dateFormat) + "/" + s.cfg.Region + "/" + service + "/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + t.Format(timeFormat) + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+s.cfg.SecretAccessKey), t.Format(dateFormat))
+	key = hmacSHA256(key, s.cfg.Region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	return "AWS4-HMAC-SHA256 Credential=" + s.cfg.AccessKeyID + "/" + scope +
+		", SignedHeaders=" + signedHeaders + ", Signature=" + signature
+}
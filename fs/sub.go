@@ -23,6 +23,37 @@ func (fs *subFS) OpenFile(name string, flag int, perm os.FileMode) (File, error)
 	return fs.fsys.OpenFile(subName, flag, perm)
 }
 
+// OpenMmapFile opens a memory-mapped file if the parent file system implements MmapFileSystem.
+// Otherwise it falls back to OpenFile.
+func (fs *subFS) OpenMmapFile(name string, flag int, perm os.FileMode) (File, error) {
+	subName := filepath.Join(fs.root, name)
+	if mfs, ok := fs.fsys.(MmapFileSystem); ok {
+		return mfs.OpenMmapFile(subName, flag, perm)
+	}
+	return fs.fsys.OpenFile(subName, flag, perm)
+}
+
+// OpenDirectFile opens a file written with direct I/O if the parent file system implements DirectIOFileSystem.
+// Otherwise it falls back to OpenMmapFile or OpenFile.
+func (fs *subFS) OpenDirectFile(name string, flag int, perm os.FileMode, mmap bool) (File, error) {
+	if dfs, ok := fs.fsys.(DirectIOFileSystem); ok {
+		return dfs.OpenDirectFile(filepath.Join(fs.root, name), flag, perm, mmap)
+	}
+	if mmap {
+		return fs.OpenMmapFile(name, flag, perm)
+	}
+	return fs.OpenFile(name, flag, perm)
+}
+
+// OpenWritableMmapFile opens a file memory-mapped for writing if the parent file system implements
+// WritableMmapFileSystem. Otherwise it falls back to OpenFile.
+func (fs *subFS) OpenWritableMmapFile(name string, flag int, perm os.FileMode) (File, error) {
+	if wfs, ok := fs.fsys.(WritableMmapFileSystem); ok {
+		return wfs.OpenWritableMmapFile(filepath.Join(fs.root, name), flag, perm)
+	}
+	return fs.OpenFile(name, flag, perm)
+}
+
 func (fs *subFS) Stat(name string) (os.FileInfo, error) {
 	subName := filepath.Join(fs.root, name)
 	return fs.fsys.Stat(subName)
@@ -49,4 +80,37 @@ func (fs *subFS) CreateLockFile(name string, perm os.FileMode) (LockFile, bool,
 	return fs.fsys.CreateLockFile(subName, perm)
 }
 
-var _ FileSystem = &subFS{}
+// FreeSpace returns the available space if the parent file system implements FreeSpaceFileSystem.
+func (fs *subFS) FreeSpace(name string) (uint64, error) {
+	ffs, ok := fs.fsys.(FreeSpaceFileSystem)
+	if !ok {
+		return 0, errFreeSpaceNotSupported
+	}
+	return ffs.FreeSpace(filepath.Join(fs.root, name))
+}
+
+// Link creates a hard link if the parent file system implements LinkFileSystem.
+func (fs *subFS) Link(oldname, newname string) error {
+	lfs, ok := fs.fsys.(LinkFileSystem)
+	if !ok {
+		return errLinkNotSupported
+	}
+	return lfs.Link(filepath.Join(fs.root, oldname), filepath.Join(fs.root, newname))
+}
+
+// SyncDir syncs the directory if the parent file system implements DirSyncFileSystem,
+// other file systems have nothing to sync.
+func (fs *subFS) SyncDir(name string) error {
+	dfs, ok := fs.fsys.(DirSyncFileSystem)
+	if !ok {
+		return nil
+	}
+	return dfs.SyncDir(filepath.Join(fs.root, name))
+}
+
+var _ MmapFileSystem = &subFS{}
+var _ DirectIOFileSystem = &subFS{}
+var _ FreeSpaceFileSystem = &subFS{}
+var _ LinkFileSystem = &subFS{}
+var _ DirSyncFileSystem = &subFS{}
+var _ WritableMmapFileSystem = &subFS{}
@@ -0,0 +1,48 @@
+package pogreb
+
+import (
+	"sync"
+	"time"
+)
+
+// groupCommitter batches fsyncs of concurrent writers.
+// The first writer waiting for durability becomes the leader: it waits for the commit window to let other writers
+// queue up and then syncs the datalog on behalf of all of them.
+type groupCommitter struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	window  time.Duration
+	syncing bool // A leader is syncing the datalog.
+}
+
+func newGroupCommitter(window time.Duration) *groupCommitter {
+	gc := &groupCommitter{window: window}
+	gc.cond = sync.NewCond(&gc.mu)
+	return gc
+}
+
+// wait blocks until the datalog write with the given ticket is durable.
+func (gc *groupCommitter) wait(dl *datalog, ticket uint64) error {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	for dl.watermark.load() < ticket {
+		if gc.syncing {
+			gc.cond.Wait()
+			continue
+		}
+		gc.syncing = true
+		gc.mu.Unlock()
+
+		time.Sleep(gc.window)
+		err := dl.sync()
+
+		gc.mu.Lock()
+		gc.syncing = false
+		gc.cond.Broadcast()
+		if err != nil {
+			// One of the waiting writers retries the sync.
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package pogreb
+
+import (
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/domaincrawler/pogreb/fs"
+	"github.com/domaincrawler/pogreb/internal/assert"
+)
+
+// syncCountingFS counts Sync calls of opened files.
+type syncCountingFS struct {
+	fs.FileSystem
+	syncs int32
+}
+
+type syncCountingFile struct {
+	fs.File
+	syncs *int32
+}
+
+func (fsys *syncCountingFS) OpenFile(name string, flag int, perm os.FileMode) (fs.File, error) {
+	f, err := fsys.FileSystem.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &syncCountingFile{File: f, syncs: &fsys.syncs}, nil
+}
+
+func (f *syncCountingFile) Sync() error {
+	atomic.AddInt32(f.syncs, 1)
+	return f.File.Sync()
+}
+
+func TestGroupCommit(t *testing.T) {
+	fsys := &syncCountingFS{FileSystem: testFS}
+	opts := &Options{
+		FileSystem:             fsys,
+		BackgroundSyncInterval: -1,
+		GroupCommitLatency:     time.Millisecond,
+		IndexShards:            4,
+	}
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+	atomic.StoreInt32(&fsys.syncs, 0)
+
+	const numWriters = 32
+	wg := sync.WaitGroup{}
+	for i := 0; i < numWriters; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 4; j++ {
+				if err := db.Put([]byte{byte(i), byte(j)}); err != nil {
+					t.Error(err)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if syncs := atomic.LoadInt32(&fsys.syncs); syncs >= numWriters*4 {
+		t.Fatalf("expected writes to be synced in groups; got %d syncs", syncs)
+	}
+	assert.Equal(t, uint64(numWriters*4), db.Count())
+	assert.Equal(t, db.datalog.numWrites, db.datalog.watermark.load())
+	assert.Nil(t, db.Close())
+}
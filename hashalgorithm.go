@@ -0,0 +1,30 @@
+package pogreb
+
+import (
+	"github.com/domaincrawler/pogreb/internal/hash"
+)
+
+// HashAlgorithm is the hash function used by the index to locate keys.
+type HashAlgorithm uint8
+
+const (
+	// HashMurmur32 is the 32-bit MurmurHash3.
+	HashMurmur32 HashAlgorithm = iota + 1
+
+	// HashXXH64 is the 64-bit xxHash.
+	// Index slots store the full 64-bit hash, which makes false hash matches on lookup,
+	// each costing a key read from the datalog, practically impossible even with billions of keys.
+	// The wider slots reduce the number of slots per bucket from 31 to 25.
+	HashXXH64
+)
+
+func (a HashAlgorithm) valid() bool {
+	return a == HashMurmur32 || a == HashXXH64
+}
+
+func (a HashAlgorithm) sum(data []byte, seed uint32) uint64 {
+	if a == HashXXH64 {
+		return hash.Sum64WithSeed(data, uint64(seed))
+	}
+	return uint64(hash.Sum32WithSeed(data, seed))
+}
@@ -0,0 +1,86 @@
+package pogreb
+
+import (
+	"sort"
+)
+
+// probe is a lookup of one of the keys passed to lookupMulti.
+type probe struct {
+	key    []byte
+	hash   uint64
+	bucket uint32
+}
+
+// lookupMulti looks up the keys and calls visit with the result of each lookup until visit returns true.
+// It returns whether visit stopped the lookups.
+// Lookups are grouped by shard and ordered by bucket, so that neighboring buckets are read together.
+// Visit is called while the DB holds internal locks, it must not call DB methods.
+func (db *DB) lookupMulti(keys [][]byte, visit func(key []byte, found bool) bool) (bool, error) {
+	if db.domainMismatch {
+		// No key is found, see Has.
+		for _, key := range keys {
+			if visit(key, false) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	probes := make(map[*indexShard][]probe)
+	for _, key := range keys {
+		h := db.hash(key)
+		shard := db.index.shard(h)
+		probes[shard] = append(probes[shard], probe{key: key, hash: h})
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	for _, shard := range db.index.shards {
+		if len(probes[shard]) == 0 {
+			continue
+		}
+		stopped, err := db.lookupShard(shard, probes[shard], visit)
+		if err != nil || stopped {
+			return stopped, err
+		}
+	}
+	return false, nil
+}
+
+func (db *DB) lookupShard(shard *indexShard, probes []probe, visit func(key []byte, found bool) bool) (bool, error) {
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	for i := range probes {
+		probes[i].bucket = shard.bucketIndex(probes[i].hash)
+	}
+	sort.Slice(probes, func(i, j int) bool {
+		return probes[i].bucket < probes[j].bucket
+	})
+	for _, p := range probes {
+		found, err := db.has(shard, p.hash, p.key)
+		if err != nil {
+			return false, err
+		}
+		if visit(p.key, found) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// HasAny returns true if the DB contains any of the keys.
+// It returns as soon as one of the keys is found, the keys are looked up in the order of the index buckets.
+// The keys are passed through the transform stage of the write chain first, see Has.
+func (db *DB) HasAny(keys [][]byte) (bool, error) {
+	return db.lookupMulti(db.lookupKeys(keys), func(_ []byte, found bool) bool { return found })
+}
+
+// HasAll returns true if the DB contains all of the keys.
+// It returns as soon as one of the keys is missing, the keys are looked up in the order of the index buckets.
+func (db *DB) HasAll(keys [][]byte) (bool, error) {
+	stored := db.lookupKeys(keys)
+	if len(stored) < len(keys) {
+		// A key rejected by a transform is missing.
+		return false, nil
+	}
+	missing, err := db.lookupMulti(stored, func(_ []byte, found bool) bool { return !found })
+	return !missing && err == nil, err
+}
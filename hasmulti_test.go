@@ -0,0 +1,39 @@
+package pogreb
+
+import (
+	"testing"
+
+	"github.com/domaincrawler/pogreb/internal/assert"
+)
+
+func TestHasAnyAll(t *testing.T) {
+	db, err := createTestDB(&Options{IndexShards: 4})
+	assert.Nil(t, err)
+	var present, missing [][]byte
+	for i := 0; i < 100; i++ {
+		key := []byte{byte(i), 1}
+		assert.Nil(t, db.Put(key))
+		present = append(present, key)
+		missing = append(missing, []byte{byte(i), 2})
+	}
+
+	check := func(keys [][]byte, any bool, all bool) {
+		t.Helper()
+		got, err := db.HasAny(keys)
+		assert.Nil(t, err)
+		assert.Equal(t, any, got)
+		got, err = db.HasAll(keys)
+		assert.Nil(t, err)
+		assert.Equal(t, all, got)
+	}
+	check(nil, false, true)
+	check(present, true, true)
+	check(missing, false, false)
+	check(append(missing[:50:50], present[99]), true, false)
+
+	// HasAny stops at the first key found.
+	gets := db.Metrics().Gets.Value()
+	check(append([][]byte{}, present...), true, true)
+	assert.Equal(t, gets+1+100, db.Metrics().Gets.Value())
+	assert.Nil(t, db.Close())
+}
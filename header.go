@@ -6,10 +6,35 @@ import (
 )
 
 const (
-	formatVersion = 2 // File format version.
+	// File format version.
+	// Version 3 indexes store 64-bit key counts, which older versions can't read.
+	// Version 4 index buckets end with a checksum, older indexes are rebuilt from the datalog when opened.
+	// Version 5 index slots store 64-bit record offsets, older indexes are rebuilt from the datalog when opened.
+	formatVersion = 5
 	headerSize    = 512
 )
 
+// Header flags.
+const (
+	// headerFlagLargeKeys marks segments where the maximum key size value denotes a large-key record.
+	headerFlagLargeKeys = 1 << iota
+
+	// headerFlagWideHash marks index files with 64-bit slot hashes.
+	headerFlagWideHash
+
+	// headerFlagArchived marks stubs of segments handed to Options.Archiver.
+	// The header holds the size of the archived segment.
+	headerFlagArchived
+
+	// headerFlagEncrypted marks segments with records encrypted with Options.EncryptionKey.
+	// The header holds the nonce of the segment and the key check value.
+	headerFlagEncrypted
+
+	// headerFlagRecordFlags marks segments storing the flags of every record after its checksum,
+	// see Options.RecordFlags.
+	headerFlagRecordFlags
+)
+
 var (
 	signature = [8]byte{'p', 'o', 'g', 'r', 'e', 'b', '\x0e', '\xfd'}
 )
@@ -17,12 +42,28 @@ var (
 type header struct {
 	signature     [8]byte
 	formatVersion uint32
+	flags         uint32
+	checksum      Checksum                  // Record checksum algorithm of a segment.
+	archivedSize  int64                     // Size of the archived segment, set with headerFlagArchived.
+	nonce         [encryptionNonceSize]byte // Record nonce prefix, set with headerFlagEncrypted.
+	keyCheck      [encryptionTagSize]byte   // Verifies the encryption key, set with headerFlagEncrypted.
+	stats         segmentStats              // Statistics of a segment, zero in segments written before they were recorded.
+}
+
+// segmentStats describes the records of a segment. They're written to the header when the segment is created,
+// sealed and closed, see segment.writeStats.
+type segmentStats struct {
+	createdAt     int64  // Creation time in Unix nanoseconds.
+	records       uint64 // Number of put and delete records.
+	minSequenceID uint64 // Lowest sequence ID of the segments the records were first written to.
+	maxSequenceID uint64 // Highest sequence ID of the segments the records were first written to.
 }
 
 func newHeader() *header {
 	return &header{
 		signature:     signature,
 		formatVersion: formatVersion,
+		checksum:      ChecksumIEEE,
 	}
 }
 
@@ -30,14 +71,42 @@ func (h header) MarshalBinary() ([]byte, error) {
 	buf := make([]byte, headerSize)
 	copy(buf[:8], h.signature[:])
 	binary.LittleEndian.PutUint32(buf[8:12], h.formatVersion)
+	binary.LittleEndian.PutUint32(buf[12:16], h.flags)
+	buf[16] = byte(h.checksum)
+	binary.LittleEndian.PutUint64(buf[17:25], uint64(h.archivedSize))
+	copy(buf[25:33], h.nonce[:])
+	copy(buf[33:49], h.keyCheck[:])
+	binary.LittleEndian.PutUint64(buf[49:57], uint64(h.stats.createdAt))
+	binary.LittleEndian.PutUint64(buf[57:65], h.stats.records)
+	binary.LittleEndian.PutUint64(buf[65:73], h.stats.minSequenceID)
+	binary.LittleEndian.PutUint64(buf[73:81], h.stats.maxSequenceID)
 	return buf, nil
 }
 
 func (h *header) UnmarshalBinary(data []byte) error {
 	if !bytes.Equal(data[:8], signature[:]) {
-		return errCorrupted
+		return ErrCorrupted
 	}
 	copy(h.signature[:], data[:8])
 	h.formatVersion = binary.LittleEndian.Uint32(data[8:12])
+	if h.formatVersion > formatVersion {
+		return errUnsupportedVersion
+	}
+	h.flags = binary.LittleEndian.Uint32(data[12:16])
+	h.checksum = Checksum(data[16])
+	h.archivedSize = int64(binary.LittleEndian.Uint64(data[17:25]))
+	copy(h.nonce[:], data[25:33])
+	copy(h.keyCheck[:], data[33:49])
+	h.stats.createdAt = int64(binary.LittleEndian.Uint64(data[49:57]))
+	h.stats.records = binary.LittleEndian.Uint64(data[57:65])
+	h.stats.minSequenceID = binary.LittleEndian.Uint64(data[65:73])
+	h.stats.maxSequenceID = binary.LittleEndian.Uint64(data[73:81])
+	if h.checksum == 0 {
+		// Files written before the checksum algorithm was recorded.
+		h.checksum = ChecksumIEEE
+	}
+	if !h.checksum.valid() {
+		return errUnsupportedChecksum
+	}
 	return nil
 }
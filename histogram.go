@@ -0,0 +1,122 @@
+package pogreb
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultLatencyBuckets are the upper bounds of the latency histogram buckets used when Options.LatencyBuckets is nil.
+var DefaultLatencyBuckets = []time.Duration{
+	time.Microsecond,
+	5 * time.Microsecond,
+	10 * time.Microsecond,
+	50 * time.Microsecond,
+	100 * time.Microsecond,
+	500 * time.Microsecond,
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	5 * time.Second,
+}
+
+// Histogram counts operation latencies in buckets.
+// All Histogram methods are safe for concurrent use by multiple goroutines.
+type Histogram struct {
+	bounds []time.Duration // Sorted upper bounds, the last bucket is unbounded.
+	counts []uint64
+	sum    int64 // Nanoseconds.
+}
+
+func newHistogram(bounds []time.Duration) *Histogram {
+	bounds = append([]time.Duration(nil), bounds...)
+	sort.Slice(bounds, func(i, j int) bool { return bounds[i] < bounds[j] })
+	return &Histogram{
+		bounds: bounds,
+		counts: make([]uint64, len(bounds)+1),
+	}
+}
+
+// Observe records a latency.
+func (h *Histogram) Observe(d time.Duration) {
+	i := sort.Search(len(h.bounds), func(i int) bool { return d <= h.bounds[i] })
+	atomic.AddUint64(&h.counts[i], 1)
+	atomic.AddInt64(&h.sum, int64(d))
+}
+
+// since records the latency of an operation started at start.
+func (h *Histogram) since(start time.Time) {
+	h.Observe(time.Since(start))
+}
+
+// HistogramSnapshot holds the values of a Histogram at a point in time.
+type HistogramSnapshot struct {
+	// Bounds are the upper bounds of the buckets.
+	Bounds []time.Duration
+
+	// Counts holds the number of latencies of each bucket, it's one longer than Bounds:
+	// the last bucket counts the latencies greater than the last bound.
+	Counts []uint64
+
+	Count uint64        // Number of latencies recorded.
+	Sum   time.Duration // Sum of the latencies recorded.
+}
+
+// Snapshot returns the current values of the histogram.
+func (h *Histogram) Snapshot() HistogramSnapshot {
+	s := HistogramSnapshot{
+		Bounds: h.bounds,
+		Counts: make([]uint64, len(h.counts)),
+		Sum:    time.Duration(atomic.LoadInt64(&h.sum)),
+	}
+	for i := range h.counts {
+		s.Counts[i] = atomic.LoadUint64(&h.counts[i])
+		s.Count += s.Counts[i]
+	}
+	return s
+}
+
+// Quantile returns the upper bound of the bucket containing the q-quantile, e.g. 0.99 for p99.
+// The latencies of the last, unbounded bucket are reported as the last bound.
+func (s HistogramSnapshot) Quantile(q float64) time.Duration {
+	if s.Count == 0 || len(s.Bounds) == 0 {
+		return 0
+	}
+	rank := uint64(q * float64(s.Count))
+	if rank == 0 {
+		rank = 1
+	}
+	var n uint64
+	for i, c := range s.Counts[:len(s.Bounds)] {
+		n += c
+		if n >= rank {
+			return s.Bounds[i]
+		}
+	}
+	return s.Bounds[len(s.Bounds)-1]
+}
+
+// String returns the histogram as JSON, it implements expvar.Var.
+func (h *Histogram) String() string {
+	s := h.Snapshot()
+	var b strings.Builder
+	fmt.Fprintf(&b, `{"count": %d, "sum": %d, "buckets": {`, s.Count, s.Sum.Nanoseconds())
+	for i, c := range s.Counts {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		if i < len(s.Bounds) {
+			fmt.Fprintf(&b, `"%d": %d`, s.Bounds[i].Nanoseconds(), c)
+		} else {
+			fmt.Fprintf(&b, `"+Inf": %d`, c)
+		}
+	}
+	b.WriteString("}}")
+	return b.String()
+}
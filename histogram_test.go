@@ -0,0 +1,38 @@
+package pogreb
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/domaincrawler/pogreb/internal/assert"
+)
+
+func TestHistogram(t *testing.T) {
+	h := newHistogram([]time.Duration{10 * time.Millisecond, time.Millisecond})
+	s := h.Snapshot()
+	assert.Equal(t, []time.Duration{time.Millisecond, 10 * time.Millisecond}, s.Bounds)
+	assert.Equal(t, time.Duration(0), s.Quantile(0.99))
+
+	for i := 0; i < 98; i++ {
+		h.Observe(500 * time.Microsecond)
+	}
+	h.Observe(time.Millisecond)
+	h.Observe(time.Second)
+
+	s = h.Snapshot()
+	assert.Equal(t, []uint64{99, 0, 1}, s.Counts)
+	assert.Equal(t, uint64(100), s.Count)
+	assert.Equal(t, 98*500*time.Microsecond+time.Millisecond+time.Second, s.Sum)
+	assert.Equal(t, time.Millisecond, s.Quantile(0.5))
+	assert.Equal(t, time.Millisecond, s.Quantile(0.99))
+	assert.Equal(t, 10*time.Millisecond, s.Quantile(1))
+
+	var v struct {
+		Count   uint64
+		Buckets map[string]uint64
+	}
+	assert.Nil(t, json.Unmarshal([]byte(h.String()), &v))
+	assert.Equal(t, uint64(100), v.Count)
+	assert.Equal(t, map[string]uint64{"1000000": 99, "10000000": 0, "+Inf": 1}, v.Buckets)
+}
@@ -0,0 +1,197 @@
+package pogreb
+
+import (
+	"math"
+	"os"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	hotBucketsName = "hot" + metaExt
+
+	accessSketchDepth = 4  // Number of count-min sketch rows.
+	accessSketchBits  = 10 // Number of bits of a counter index in a count-min sketch row.
+	accessSketchWidth = 1 << accessSketchBits
+	accessCandidates  = 1 << 10 // Number of tracked hot bucket candidates per index shard.
+)
+
+// accessSketchSeeds select the counter of a bucket in each sketch row.
+var accessSketchSeeds = [accessSketchDepth]uint32{0x9e3779b1, 0x85ebca77, 0xc2b2ae3d, 0x27d4eb2f}
+
+// accessCounts approximates how often the buckets of an index shard are looked up.
+// Lookups are counted in a count-min sketch, which never underestimates the count of a bucket.
+// Buckets compete for the candidate slots by the low bits of their index, the slot keeps the bucket
+// with the highest count, which is reported by hotBuckets.
+// A nil accessCounts doesn't track accesses.
+type accessCounts struct {
+	sketch     [accessSketchDepth][accessSketchWidth]uint32
+	candidates [accessCandidates]uint32 // Bucket index plus one, 0 marks an empty slot.
+}
+
+func newAccessCounts(opts *Options) *accessCounts {
+	if opts.HotBuckets <= 0 && !opts.TrackAccessFrequency {
+		return nil
+	}
+	return &accessCounts{}
+}
+
+func accessSketchCell(row int, bucketIdx uint32) uint32 {
+	return (bucketIdx * accessSketchSeeds[row]) >> (32 - accessSketchBits)
+}
+
+func (ac *accessCounts) record(bucketIdx uint32) {
+	if ac == nil {
+		return
+	}
+	count := uint32(math.MaxUint32)
+	for row := range ac.sketch {
+		if c := atomic.AddUint32(&ac.sketch[row][accessSketchCell(row, bucketIdx)], 1); c < count {
+			count = c
+		}
+	}
+	// Racing lookups may replace a candidate with a colder bucket, the next lookup of the hot bucket restores it.
+	i := bucketIdx & (accessCandidates - 1)
+	cur := atomic.LoadUint32(&ac.candidates[i])
+	if cur != bucketIdx+1 && (cur == 0 || ac.estimate(cur-1) < count) {
+		atomic.StoreUint32(&ac.candidates[i], bucketIdx+1)
+	}
+}
+
+// estimate returns the approximate number of lookups of the bucket.
+func (ac *accessCounts) estimate(bucketIdx uint32) uint32 {
+	count := uint32(math.MaxUint32)
+	for row := range ac.sketch {
+		if c := atomic.LoadUint32(&ac.sketch[row][accessSketchCell(row, bucketIdx)]); c < count {
+			count = c
+		}
+	}
+	return count
+}
+
+// HotBucket identifies a frequently looked up index bucket, see DB.HotBuckets.
+type HotBucket struct {
+	Shard  int    // Index shard of the bucket.
+	Bucket uint32 // Index of the bucket in the shard.
+	Count  uint64 // Approximate number of lookups of the bucket since the DB was opened, never below the actual number.
+}
+
+// hotBucketsMeta is the contents of the hot buckets file.
+type hotBucketsMeta struct {
+	Buckets []HotBucket
+}
+
+// hotBuckets returns up to n of the most accessed buckets of the index.
+func (si *shardedIndex) hotBuckets(n int) []HotBucket {
+	var hot []HotBucket
+	for i, shard := range si.shards {
+		ac := shard.access
+		if ac == nil {
+			continue
+		}
+		for j := range ac.candidates {
+			if c := atomic.LoadUint32(&ac.candidates[j]); c != 0 {
+				hot = append(hot, HotBucket{Shard: i, Bucket: c - 1, Count: uint64(ac.estimate(c - 1))})
+			}
+		}
+	}
+	sort.Slice(hot, func(i, j int) bool {
+		return hot[i].Count > hot[j].Count
+	})
+	if len(hot) > n {
+		hot = hot[:n]
+	}
+	return hot
+}
+
+// HotBuckets returns up to n of the most looked up index buckets, ordered by the number of lookups.
+// Keys are assigned to buckets by their hash, a bucket which dominates the lookups holds hot keys.
+// It returns nil unless Options.TrackAccessFrequency or Options.HotBuckets is set.
+func (db *DB) HotBuckets(n int) []HotBucket {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.index.hotBuckets(n)
+}
+
+// writeHotBuckets records the most accessed buckets, which are prefetched when the DB is opened again.
+func (db *DB) writeHotBuckets() error {
+	if db.opts.HotBuckets <= 0 {
+		return nil
+	}
+	hot := db.index.hotBuckets(db.opts.HotBuckets)
+	if len(hot) == 0 {
+		return nil
+	}
+	return writeGobFile(db.opts.FileSystem, hotBucketsName, hotBucketsMeta{Buckets: hot})
+}
+
+// prefetchHotBuckets reads the buckets recorded by the last Close and the keys they point to,
+// loading the index and datalog pages of the hot keys into memory. It returns the number of buckets read.
+func (db *DB) prefetchHotBuckets() (int, error) {
+	if db.opts.HotBuckets <= 0 {
+		return 0, nil
+	}
+	if _, err := db.opts.FileSystem.Stat(hotBucketsName); err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	m := hotBucketsMeta{}
+	if err := readGobFile(db.opts.FileSystem, hotBucketsName, &m); err != nil {
+		return 0, err
+	}
+	if len(m.Buckets) > db.opts.HotBuckets {
+		m.Buckets = m.Buckets[:db.opts.HotBuckets]
+	}
+	n := 0
+	for _, hb := range m.Buckets {
+		// The index might have been rebuilt since the buckets were recorded.
+		if hb.Shard >= len(db.index.shards) || hb.Bucket >= db.index.shards[hb.Shard].numBuckets {
+			continue
+		}
+		if err := db.prefetchBucket(db.index.shards[hb.Shard], hb.Bucket); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+func (db *DB) prefetchBucket(shard *indexShard, bucketIdx uint32) error {
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	db.datalog.mu.RLock()
+	defer db.datalog.mu.RUnlock()
+	it := shard.newBucketIterator(bucketIdx)
+	for {
+		b, err := it.next()
+		if err == ErrIterationDone {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		for i := 0; i < slotsPerBucket; i++ {
+			sl := b.slots[i]
+			if sl.offset == 0 {
+				break
+			}
+			if _, err := db.datalog.readKey(sl); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// prefetch runs prefetchHotBuckets during Open. A failed prefetch doesn't prevent opening the DB.
+func (db *DB) prefetch(report *OpenReport) {
+	start := time.Now()
+	n, err := db.prefetchHotBuckets()
+	if err != nil {
+		db.opts.Logger.Logf(LogWarn, "prefetching hot buckets: %v", err)
+	}
+	report.HotBucketsPrefetched = n
+	report.PrefetchDuration = time.Since(start)
+}
@@ -0,0 +1,75 @@
+package pogreb
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/domaincrawler/pogreb/internal/assert"
+)
+
+func TestHotBuckets(t *testing.T) {
+	opts := &Options{HotBuckets: 2}
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(deleteTestKey(i)))
+	}
+	hotKey := deleteTestKey(7)
+	for i := 0; i < 10; i++ {
+		assertHas(t, db, hotKey, true)
+	}
+	assertHas(t, db, deleteTestKey(8), true)
+	h := db.hash(hotKey)
+	shardIdx := shardIndex(h, len(db.index.shards))
+	bucketIdx := db.index.shards[shardIdx].bucketIndex(h)
+	assert.Nil(t, db.Close())
+
+	m := hotBucketsMeta{}
+	assert.Nil(t, readGobFile(testFS, filepath.Join(testDBName, hotBucketsName), &m))
+	assert.Equal(t, true, len(m.Buckets) > 0 && len(m.Buckets) <= 2)
+	assert.Equal(t, shardIdx, m.Buckets[0].Shard)
+	assert.Equal(t, bucketIdx, m.Buckets[0].Bucket)
+
+	db, err = Open(testDBName, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, len(m.Buckets), db.OpenReport().HotBucketsPrefetched)
+	assert.Nil(t, db.Close())
+
+	// Without the option the recorded buckets are ignored.
+	db, err = Open(testDBName, &Options{FileSystem: testFS})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, db.OpenReport().HotBucketsPrefetched)
+	assert.Nil(t, db.Close())
+}
+
+func TestTrackAccessFrequency(t *testing.T) {
+	db, err := createTestDB(&Options{TrackAccessFrequency: true, IndexShards: 2})
+	assert.Nil(t, err)
+	for i := 0; i < 5000; i++ {
+		assert.Nil(t, db.Put(deleteTestKey(i)))
+	}
+	for i := 0; i < 5000; i++ {
+		assertHas(t, db, deleteTestKey(i), true)
+	}
+	hotKey := deleteTestKey(42)
+	for i := 0; i < 100; i++ {
+		assertHas(t, db, hotKey, true)
+	}
+	h := db.hash(hotKey)
+	hot := db.HotBuckets(3)
+	assert.Equal(t, 3, len(hot))
+	assert.Equal(t, shardIndex(h, 2), hot[0].Shard)
+	assert.Equal(t, db.index.shard(h).bucketIndex(h), hot[0].Bucket)
+	assert.Equal(t, true, hot[0].Count >= 101)
+	assert.Equal(t, true, hot[1].Count <= hot[0].Count)
+	assert.Nil(t, db.Close())
+
+	// The counts aren't recorded without Options.HotBuckets.
+	_, err = testFS.Stat(filepath.Join(testDBName, hotBucketsName))
+	assert.NotNil(t, err)
+	db, err = Open(testDBName, &Options{FileSystem: testFS})
+	assert.Nil(t, err)
+	assertHas(t, db, hotKey, true)
+	assert.Equal(t, 0, len(db.HotBuckets(3)))
+	assert.Nil(t, db.Close())
+}
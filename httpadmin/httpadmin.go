@@ -0,0 +1,172 @@
+/*
+Package httpadmin exposes the state of a pogreb database over HTTP for debugging and operations.
+
+The handler is meant to be mounted on an existing admin mux, which is not reachable publicly:
+
+	mux.Handle("/pogreb/", http.StripPrefix("/pogreb", httpadmin.Handler(db)))
+
+It serves the endpoints:
+
+	GET  /stats                 JSON with the number of keys, the size of the files, the health and the metrics.
+	GET  /metrics               The metrics in the Prometheus text exposition format.
+	POST /compact               Compacts the DB and responds with the JSON pogreb.CompactionResult.
+	POST /backup?path=<dir>     Writes a copy of the DB to the empty or missing directory, see pogreb.DB.Clone.
+	GET  /has?key=<key>         JSON reporting whether the key is stored, hex=<hex> looks up a binary key.
+
+Errors are responded as JSON objects with the error message and the pogreb.ErrorCode name.
+*/
+package httpadmin
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/domaincrawler/pogreb"
+)
+
+// Stats is the response of /stats.
+type Stats struct {
+	Count          uint64                  `json:"count"`
+	FileSize       int64                   `json:"file_size"`
+	Epoch          uint64                  `json:"epoch"`
+	CanaryChecks   int64                   `json:"canary_checks"`
+	CanaryFailures int64                   `json:"canary_failures"`
+	LastCanary     *time.Time              `json:"last_canary,omitempty"`
+	LastCanaryErr  string                  `json:"last_canary_error,omitempty"`
+	Metrics        map[string]float64      `json:"metrics"`
+	Usage          map[string]pogreb.Usage `json:"usage,omitempty"`
+}
+
+// HasResult is the response of /has.
+type HasResult struct {
+	Found bool `json:"found"`
+}
+
+// BackupResult is the response of /backup.
+type BackupResult struct {
+	Path     string  `json:"path"`
+	Duration float64 `json:"duration_seconds"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+	Code  string `json:"code"`
+}
+
+// Handler returns the admin handler of the DB.
+func Handler(db *pogreb.DB) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stats", method(http.MethodGet, func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, stats(db))
+	}))
+	mux.HandleFunc("/metrics", method(http.MethodGet, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		_ = db.Metrics().Collector().WritePrometheus(w)
+	}))
+	mux.HandleFunc("/compact", method(http.MethodPost, func(w http.ResponseWriter, r *http.Request) {
+		res, err := db.Compact()
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, res)
+	}))
+	mux.HandleFunc("/backup", method(http.MethodPost, func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.Query().Get("path")
+		if path == "" {
+			writeErrorStatus(w, http.StatusBadRequest, "missing path", pogreb.CodeUnknown)
+			return
+		}
+		start := time.Now()
+		if err := db.Clone(path); err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, BackupResult{Path: path, Duration: time.Since(start).Seconds()})
+	}))
+	mux.HandleFunc("/has", method(http.MethodGet, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		key := []byte(q.Get("key"))
+		if h := q.Get("hex"); h != "" {
+			var err error
+			if key, err = hex.DecodeString(h); err != nil {
+				writeErrorStatus(w, http.StatusBadRequest, "invalid hex key", pogreb.CodeUnknown)
+				return
+			}
+		}
+		found, err := db.Has(key)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, HasResult{Found: found})
+	}))
+	return mux
+}
+
+func stats(db *pogreb.DB) Stats {
+	s := Stats{Count: db.Count(), Epoch: db.Epoch(), Metrics: make(map[string]float64)}
+	// The size is left 0 if a file is removed concurrently, e.g. by compaction.
+	s.FileSize, _ = db.FileSize()
+	h := db.Health()
+	s.CanaryChecks, s.CanaryFailures = h.CanaryChecks, h.CanaryFailures
+	if !h.LastCanary.IsZero() {
+		s.LastCanary = &h.LastCanary
+	}
+	if h.LastCanaryErr != nil {
+		s.LastCanaryErr = h.LastCanaryErr.Error()
+	}
+	for _, sample := range db.Metrics().Collector().Samples() {
+		s.Metrics[sample.Name] = sample.Value
+	}
+	if usage := db.Usage(); len(usage) > 0 {
+		s.Usage = usage
+	}
+	return s
+}
+
+// method restricts the handler to the HTTP method.
+func method(m string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != m {
+			w.Header().Set("Allow", m)
+			writeErrorStatus(w, http.StatusMethodNotAllowed, "method not allowed", pogreb.CodeUnknown)
+			return
+		}
+		h(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeError responds with the error of the DB.
+func writeError(w http.ResponseWriter, err error) {
+	code := pogreb.ErrorCodeOf(err)
+	writeErrorStatus(w, httpStatus(code), err.Error(), code)
+}
+
+func writeErrorStatus(w http.ResponseWriter, status int, msg string, code pogreb.ErrorCode) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(errorResponse{Error: msg, Code: code.String()})
+}
+
+// httpStatus returns the HTTP status of the error code of the DB.
+func httpStatus(code pogreb.ErrorCode) int {
+	switch code {
+	case pogreb.CodeKeyTooLarge, pogreb.CodeBlocked:
+		return http.StatusBadRequest
+	case pogreb.CodeBusy, pogreb.CodeReadOnly, pogreb.CodeMismatch:
+		return http.StatusConflict
+	case pogreb.CodeClosed, pogreb.CodeLocked:
+		return http.StatusServiceUnavailable
+	case pogreb.CodeFull, pogreb.CodeDiskFull:
+		return http.StatusInsufficientStorage
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,77 @@
+package httpadmin
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/domaincrawler/pogreb"
+	"github.com/domaincrawler/pogreb/fs"
+	"github.com/domaincrawler/pogreb/internal/assert"
+)
+
+func TestHandler(t *testing.T) {
+	dir, err := os.MkdirTemp("", "pogreb-httpadmin")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	db, err := pogreb.Open(filepath.Join(dir, "test.db"), &pogreb.Options{FileSystem: fs.OS})
+	assert.Nil(t, err)
+	defer db.Close()
+	assert.Nil(t, db.Put([]byte("https://example.com/")))
+	assert.Nil(t, db.Put([]byte{0, 1}))
+
+	mux := http.NewServeMux()
+	mux.Handle("/pogreb/", http.StripPrefix("/pogreb", Handler(db)))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	call := func(method, path string, status int) []byte {
+		t.Helper()
+		req, err := http.NewRequest(method, srv.URL+"/pogreb"+path, nil)
+		assert.Nil(t, err)
+		resp, err := http.DefaultClient.Do(req)
+		assert.Nil(t, err)
+		body, err := io.ReadAll(resp.Body)
+		assert.Nil(t, err)
+		assert.Nil(t, resp.Body.Close())
+		assert.Equal(t, status, resp.StatusCode)
+		return body
+	}
+
+	var has HasResult
+	assert.Nil(t, json.Unmarshal(call("GET", "/has?key="+url.QueryEscape("https://example.com/"), http.StatusOK), &has))
+	assert.Equal(t, true, has.Found)
+	assert.Nil(t, json.Unmarshal(call("GET", "/has?hex=0001", http.StatusOK), &has))
+	assert.Equal(t, true, has.Found)
+	assert.Nil(t, json.Unmarshal(call("GET", "/has?key=missing", http.StatusOK), &has))
+	assert.Equal(t, false, has.Found)
+	call("GET", "/has?hex=zz", http.StatusBadRequest)
+
+	var stats Stats
+	assert.Nil(t, json.Unmarshal(call("GET", "/stats", http.StatusOK), &stats))
+	assert.Equal(t, uint64(2), stats.Count)
+	assert.Equal(t, float64(2), stats.Metrics["pogreb_puts"])
+	assert.Equal(t, true, strings.Contains(string(call("GET", "/metrics", http.StatusOK)), "pogreb_puts 2\n"))
+
+	var res pogreb.CompactionResult
+	call("GET", "/compact", http.StatusMethodNotAllowed)
+	assert.Nil(t, json.Unmarshal(call("POST", "/compact", http.StatusOK), &res))
+
+	backup := filepath.Join(dir, "backup.db")
+	var br BackupResult
+	assert.Nil(t, json.Unmarshal(call("POST", "/backup?path="+url.QueryEscape(backup), http.StatusOK), &br))
+	assert.Equal(t, backup, br.Path)
+	var e errorResponse
+	assert.Nil(t, json.Unmarshal(call("POST", "/backup?path="+url.QueryEscape(backup), http.StatusInternalServerError), &e))
+	assert.Equal(t, "clone destination isn't empty", e.Error)
+	call("POST", "/backup", http.StatusBadRequest)
+	clone, err := pogreb.Open(backup, &pogreb.Options{FileSystem: fs.OS})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), clone.Count())
+	assert.Nil(t, clone.Close())
+}
@@ -0,0 +1,380 @@
+package pogreb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/gob"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/domaincrawler/pogreb/internal/errors"
+)
+
+const (
+	defaultImportProgressInterval = time.Second
+	defaultImportCheckpointBytes  = 64 << 20
+
+	importBatchSize = 1024 // Maximum number of keys written by Import with a single commit.
+)
+
+// ImportFormat sets how keys are encoded in the input of Import.
+type ImportFormat int
+
+const (
+	// ImportLines reads one key per line. Line endings aren't part of the keys, empty lines are skipped.
+	ImportLines ImportFormat = iota
+
+	// ImportLengthPrefixed reads keys prefixed with their length encoded as an unsigned varint.
+	ImportLengthPrefixed
+)
+
+// ImportProgress describes the progress of Import.
+// Counts include the keys imported before the import was resumed.
+type ImportProgress struct {
+	Records    int64         // Number of valid keys read, inserted or duplicate.
+	Inserted   int64         // Number of keys which weren't in the DB.
+	Duplicates int64         // Number of keys skipped because they were already in the DB or earlier in the input.
+	Invalid    int64         // Number of keys skipped because they are too large or blocked by the write chain.
+	Bytes      int64         // Number of input bytes consumed, including the bytes skipped when resuming.
+	Elapsed    time.Duration // Time since the import was started or resumed.
+	Rate       float64       // Keys read per second since the import was started or resumed.
+}
+
+// ImportOptions holds the optional Import parameters.
+type ImportOptions struct {
+	// Format sets how keys are encoded in the input.
+	//
+	// Default: ImportLines.
+	Format ImportFormat
+
+	// Progress is called every ProgressInterval and once when the import completes.
+	Progress func(ImportProgress)
+
+	// ProgressInterval sets the amount of time between Progress calls, measured with Options.Clock.
+	//
+	// Default: 1 second.
+	ProgressInterval time.Duration
+
+	// CheckpointPath sets the path of a file, outside of the database directory, recording how much of the input
+	// was imported. When the file exists, Import resumes after the recorded input offset
+	// instead of starting from the beginning. The file is removed when the import completes.
+	// The input must be the same on every attempt.
+	//
+	// Default: "", no checkpoint.
+	CheckpointPath string
+
+	// CheckpointBytes sets the amount of input consumed between checkpoints.
+	// The DB is synced before each checkpoint, the keys covered by a checkpoint are durable.
+	//
+	// Default: 64 MiB.
+	CheckpointBytes int64
+}
+
+func (src *ImportOptions) copyWithDefaults() *ImportOptions {
+	opts := ImportOptions{}
+	if src != nil {
+		opts = *src
+	}
+	if opts.ProgressInterval <= 0 {
+		opts.ProgressInterval = defaultImportProgressInterval
+	}
+	if opts.CheckpointBytes <= 0 {
+		opts.CheckpointBytes = defaultImportCheckpointBytes
+	}
+	return &opts
+}
+
+// importCheckpoint is the contents of the import checkpoint file.
+type importCheckpoint struct {
+	Offset     int64
+	Records    int64
+	Inserted   int64
+	Duplicates int64
+	Invalid    int64
+}
+
+func readImportCheckpoint(path string) (importCheckpoint, error) {
+	cp := importCheckpoint{}
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return cp, nil
+		}
+		return cp, err
+	}
+	defer f.Close()
+	if err := gob.NewDecoder(f).Decode(&cp); err != nil {
+		return cp, errors.Wrapf(ErrCorrupted, "decoding import checkpoint %s: %v", path, err)
+	}
+	return cp, nil
+}
+
+// writeImportCheckpoint replaces the checkpoint file atomically.
+func writeImportCheckpoint(path string, cp importCheckpoint) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if err := gob.NewEncoder(f).Encode(cp); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	if d, err := os.Open(filepath.Dir(path)); err == nil {
+		_ = d.Sync()
+		_ = d.Close()
+	}
+	return nil
+}
+
+// countingReader counts the bytes consumed from the underlying reader.
+type countingReader struct {
+	r *bufio.Reader
+	n int64
+}
+
+func (cr *countingReader) ReadByte() (byte, error) {
+	b, err := cr.r.ReadByte()
+	if err == nil {
+		cr.n++
+	}
+	return b, err
+}
+
+// next returns the next key, or io.EOF when the input is exhausted.
+func (cr *countingReader) next(format ImportFormat) ([]byte, error) {
+	if format == ImportLengthPrefixed {
+		size, err := binary.ReadUvarint(cr)
+		if err != nil {
+			if err == io.ErrUnexpectedEOF {
+				return nil, errors.Wrap(ErrCorrupted, "reading key length")
+			}
+			return nil, err
+		}
+		if size > MaxLargeKeyLength {
+			// Skip the key, the following keys can still be read.
+			n, err := io.CopyN(io.Discard, cr.r, int64(size))
+			cr.n += n
+			if err == io.EOF {
+				return nil, errors.Wrap(ErrCorrupted, "reading key")
+			}
+			if err != nil {
+				return nil, err
+			}
+			return nil, ErrKeyTooLarge
+		}
+		key := make([]byte, size)
+		n, err := io.ReadFull(cr.r, key)
+		cr.n += int64(n)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, errors.Wrap(ErrCorrupted, "reading key")
+		}
+		return key, err
+	}
+	for {
+		line, err := cr.r.ReadBytes('\n')
+		cr.n += int64(len(line))
+		if err != nil && (err != io.EOF || len(line) == 0) {
+			return nil, err
+		}
+		if n := len(line); n > 0 && line[n-1] == '\n' {
+			line = line[:n-1]
+			if n := len(line); n > 0 && line[n-1] == '\r' {
+				line = line[:n-1]
+			}
+		}
+		if len(line) > 0 {
+			return line, nil
+		}
+		if err == io.EOF {
+			return nil, err
+		}
+	}
+}
+
+// importBatch writes the keys missing from the DB with a single commit and counts them in the progress.
+func (db *DB) importBatch(keys [][]byte, progress *ImportProgress) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	var inserted [][]byte
+	err := func() error {
+		db.mu.RLock()
+		defer db.mu.RUnlock()
+		for _, key := range keys {
+			h := db.hash(key)
+			shard := db.index.shard(h)
+			shard.mu.Lock()
+			found, err := db.has(shard, h, key)
+			if err == nil && !found {
+				err = db.write(shard, h, key)
+			}
+			shard.mu.Unlock()
+			if err != nil {
+				return err
+			}
+			progress.Records++
+			if found {
+				progress.Duplicates++
+				continue
+			}
+			db.metrics.Puts.Add(1)
+			progress.Inserted++
+			inserted = append(inserted, key)
+		}
+		return nil
+	}()
+	if err != nil || len(inserted) == 0 {
+		return err
+	}
+	if err := db.commit(); err != nil {
+		return err
+	}
+	for _, key := range inserted {
+		db.writeChain.after(key)
+	}
+	return nil
+}
+
+// Import puts the keys read from r until EOF and returns the final progress.
+// Keys already in the DB are skipped. Keys which can't be written, because they are too large or blocked,
+// are counted as invalid and skipped. Keys are written in batches, each batch is committed once.
+//
+// With ImportOptions.CheckpointPath an interrupted import can be resumed by calling Import again with the same input:
+// input already covered by the checkpoint is skipped, using Seek when r implements io.Seeker.
+// Keys imported after the last checkpoint are read again and counted as duplicates.
+func (db *DB) Import(r io.Reader, opts *ImportOptions) (ImportProgress, error) {
+	opts = opts.copyWithDefaults()
+	progress := ImportProgress{}
+	if err := db.checkWritable(); err != nil {
+		return progress, err
+	}
+
+	cp := importCheckpoint{}
+	if opts.CheckpointPath != "" {
+		var err error
+		if cp, err = readImportCheckpoint(opts.CheckpointPath); err != nil {
+			return progress, err
+		}
+	}
+	if cp.Offset > 0 {
+		var err error
+		if s, ok := r.(io.Seeker); ok {
+			_, err = s.Seek(cp.Offset, io.SeekCurrent)
+		} else {
+			_, err = io.CopyN(io.Discard, r, cp.Offset)
+		}
+		if err != nil {
+			return progress, errors.Wrap(err, "skipping imported input")
+		}
+		db.opts.Logger.Logf(LogInfo, "resuming import after %d keys at offset %d", cp.Records, cp.Offset)
+	}
+
+	cr := &countingReader{r: bufio.NewReader(r), n: cp.Offset}
+	progress.Records = cp.Records
+	progress.Inserted = cp.Inserted
+	progress.Duplicates = cp.Duplicates
+	progress.Invalid = cp.Invalid
+	progress.Bytes = cp.Offset
+	start := db.opts.Clock.Now()
+	lastProgress := start
+	lastCheckpoint := cp.Offset
+	update := func(now time.Time) {
+		progress.Bytes = cr.n
+		progress.Elapsed = now.Sub(start)
+		if secs := progress.Elapsed.Seconds(); secs > 0 {
+			progress.Rate = float64(progress.Records-cp.Records) / secs
+		}
+	}
+
+	batch := make([][]byte, 0, importBatchSize)
+	for {
+		key, err := cr.next(opts.Format)
+		if err == io.EOF {
+			break
+		}
+		if err == ErrKeyTooLarge {
+			progress.Invalid++
+			continue
+		}
+		if err != nil {
+			// Keys read before the error are imported.
+			if berr := db.importBatch(batch, &progress); berr != nil {
+				err = berr
+			}
+			update(db.opts.Clock.Now())
+			return progress, errors.Wrapf(err, "reading input at offset %d", cr.n)
+		}
+		key, err = db.checkKey(key)
+		if err != nil {
+			if err != ErrKeyTooLarge && !errors.Is(err, ErrBlocked) {
+				return progress, err
+			}
+			progress.Invalid++
+			continue
+		}
+		batch = append(batch, key)
+
+		checkpoint := opts.CheckpointPath != "" && cr.n-lastCheckpoint >= opts.CheckpointBytes
+		if len(batch) == importBatchSize || checkpoint {
+			if err := db.importBatch(batch, &progress); err != nil {
+				update(db.opts.Clock.Now())
+				return progress, errors.Wrapf(err, "importing keys before offset %d", cr.n)
+			}
+			batch = batch[:0]
+		}
+		if checkpoint {
+			if err := db.Sync(); err != nil {
+				return progress, err
+			}
+			cp := importCheckpoint{
+				Offset:     cr.n,
+				Records:    progress.Records,
+				Inserted:   progress.Inserted,
+				Duplicates: progress.Duplicates,
+				Invalid:    progress.Invalid,
+			}
+			if err := writeImportCheckpoint(opts.CheckpointPath, cp); err != nil {
+				return progress, errors.Wrap(err, "writing import checkpoint")
+			}
+			lastCheckpoint = cr.n
+		}
+		if opts.Progress != nil {
+			if now := db.opts.Clock.Now(); now.Sub(lastProgress) >= opts.ProgressInterval {
+				update(now)
+				opts.Progress(progress)
+				lastProgress = now
+			}
+		}
+	}
+	if err := db.importBatch(batch, &progress); err != nil {
+		update(db.opts.Clock.Now())
+		return progress, errors.Wrapf(err, "importing keys before offset %d", cr.n)
+	}
+
+	if err := db.Sync(); err != nil {
+		return progress, err
+	}
+	if opts.CheckpointPath != "" {
+		if err := os.Remove(opts.CheckpointPath); err != nil && !os.IsNotExist(err) {
+			return progress, err
+		}
+	}
+	update(db.opts.Clock.Now())
+	if opts.Progress != nil {
+		opts.Progress(progress)
+	}
+	return progress, nil
+}
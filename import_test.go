@@ -0,0 +1,144 @@
+package pogreb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/domaincrawler/pogreb/internal/assert"
+)
+
+// tickingReader advances the clock by a second for every read.
+type tickingReader struct {
+	r     io.Reader
+	clock *manualClock
+}
+
+func (r *tickingReader) Read(p []byte) (int, error) {
+	r.clock.advance(time.Second)
+	return r.r.Read(p)
+}
+
+func TestImportLines(t *testing.T) {
+	clock := newManualClock()
+	db, err := createTestDB(&Options{Clock: clock})
+	assert.Nil(t, err)
+
+	var calls []ImportProgress
+	input := &tickingReader{r: iotest.OneByteReader(bytes.NewReader([]byte("a\r\nbb\n\nccc"))), clock: clock}
+	progress, err := db.Import(input, &ImportOptions{
+		Progress:         func(p ImportProgress) { calls = append(calls, p) },
+		ProgressInterval: 3 * time.Second,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), progress.Records)
+	assert.Equal(t, int64(10), progress.Bytes)
+	assert.Equal(t, true, progress.Rate > 0)
+	assert.Equal(t, progress, calls[len(calls)-1])
+	assert.Equal(t, true, len(calls) > 1)
+	assert.Equal(t, uint64(3), db.Count())
+	for _, k := range []string{"a", "bb", "ccc"} {
+		has, err := db.Has([]byte(k))
+		assert.Nil(t, err)
+		assert.Equal(t, true, has)
+	}
+	assert.Nil(t, db.Close())
+}
+
+func TestImportLengthPrefixed(t *testing.T) {
+	db, err := createTestDB(nil)
+	assert.Nil(t, err)
+
+	var buf []byte
+	for _, k := range []string{"a", "", "b\nc"} {
+		var size [binary.MaxVarintLen64]byte
+		buf = append(buf, size[:binary.PutUvarint(size[:], uint64(len(k)))]...)
+		buf = append(buf, k...)
+	}
+	progress, err := db.Import(bytes.NewReader(buf), &ImportOptions{Format: ImportLengthPrefixed})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), progress.Records)
+	has, err := db.Has([]byte("b\nc"))
+	assert.Nil(t, err)
+	assert.Equal(t, true, has)
+
+	_, err = db.Import(bytes.NewReader(buf[:len(buf)-1]), &ImportOptions{Format: ImportLengthPrefixed})
+	assert.Equal(t, true, errors.Is(err, ErrCorrupted))
+	assert.Nil(t, db.Close())
+}
+
+func TestImportResume(t *testing.T) {
+	db, err := createTestDB(nil)
+	assert.Nil(t, err)
+
+	var input bytes.Buffer
+	for i := 0; i < 100; i++ {
+		input.WriteString("key")
+		input.WriteByte(byte('0' + i/10))
+		input.WriteByte(byte('0' + i%10))
+		input.WriteByte('\n')
+	}
+	cpPath := filepath.Join(t.TempDir(), "import.checkpoint")
+	opts := &ImportOptions{CheckpointPath: cpPath, CheckpointBytes: 20 * 6}
+
+	// The import dies after 70 keys.
+	failing := io.MultiReader(bytes.NewReader(input.Bytes()[:70*6]), iotest.ErrReader(errors.New("connection reset")))
+	progress, err := db.Import(failing, opts)
+	assert.NotNil(t, err)
+	assert.Equal(t, int64(70), progress.Records)
+	cp, err := readImportCheckpoint(cpPath)
+	assert.Nil(t, err)
+	assert.Equal(t, importCheckpoint{Offset: 60 * 6, Records: 60, Inserted: 60}, cp)
+
+	var first ImportProgress
+	opts.Progress = func(p ImportProgress) { first = p }
+	progress, err = db.Import(bytes.NewReader(input.Bytes()), opts)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(100), progress.Records)
+	assert.Equal(t, int64(100), progress.Inserted+progress.Duplicates)
+	assert.Equal(t, int64(10), progress.Duplicates) // Keys imported after the last checkpoint.
+	assert.Equal(t, int64(input.Len()), progress.Bytes)
+	assert.Equal(t, progress, first)
+	assert.Equal(t, uint64(100), db.Count())
+	_, err = os.Stat(cpPath)
+	assert.Equal(t, true, os.IsNotExist(err))
+
+	// Inputs that can't seek are skipped by reading.
+	assert.Nil(t, writeImportCheckpoint(cpPath, importCheckpoint{Offset: 95 * 6, Records: 95}))
+	progress, err = db.Import(iotest.OneByteReader(bytes.NewReader(input.Bytes())), opts)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(100), progress.Records)
+	assert.Nil(t, db.Close())
+}
+
+func TestImportDedup(t *testing.T) {
+	db, err := createTestDB(nil)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte("a")))
+
+	input := "a\nb\nc\nb\n" + strings.Repeat("x", MaxKeyLength+1) + "\nd\n"
+	progress, err := db.Import(strings.NewReader(input), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5), progress.Records)
+	assert.Equal(t, int64(3), progress.Inserted)
+	assert.Equal(t, int64(2), progress.Duplicates)
+	assert.Equal(t, int64(1), progress.Invalid)
+	assert.Equal(t, uint64(4), db.Count())
+
+	// Duplicates aren't written to the datalog.
+	size, err := db.FileSize()
+	assert.Nil(t, err)
+	_, err = db.Import(strings.NewReader(input), nil)
+	assert.Nil(t, err)
+	newSize, err := db.FileSize()
+	assert.Nil(t, err)
+	assert.Equal(t, size, newSize)
+	assert.Nil(t, db.Close())
+}
@@ -1,6 +1,12 @@
 package pogreb
 
 import (
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/domaincrawler/pogreb/fs"
 	"github.com/domaincrawler/pogreb/internal/errors"
 )
 
@@ -9,53 +15,162 @@ const (
 	indexMainName     = "main" + indexExt
 	indexOverflowName = "overflow" + indexExt
 	indexMetaName     = "index" + metaExt
-	loadFactor        = 0.7
+
+	defaultIndexLoadFactor = 0.7
+
+	// Version of the index files from which slots hold 64-bit record offsets.
+	// Buckets hold checksums since version 4.
+	indexWideOffsetVersion = 5
+)
+
+var (
+	// errIndexFormat is returned by openIndex when the index was written in a format without bucket checksums
+	// or 64-bit record offsets.
+	errIndexFormat = errors.New("index written in an older format")
+
+	// errIndexLayout is returned by openIndex when the bucket capacity of the index differs from Options.IndexBucketSlots.
+	errIndexLayout = errors.New("index bucket slots differ from Options.IndexBucketSlots")
+
+	errInvalidLoadFactor  = errors.New("index load factor must be greater than 0 and at most 1")
+	errInvalidBucketSlots = errors.New("index bucket slots exceed the capacity of a bucket")
 )
 
+// indexNeedsRebuild returns true if opening the index failed because the index files are corrupted,
+// outdated or have a different layout, Open then rebuilds the index from the datalog.
+func indexNeedsRebuild(err error) bool {
+	var corruption *CorruptionError
+	return errors.Is(err, errIndexFormat) || errors.Is(err, errIndexLayout) || errors.Is(err, errIndexSeed) ||
+		errors.As(err, &corruption)
+}
+
+// validateIndexOptions checks the options of the index layout.
+func validateIndexOptions(opts *Options) error {
+	if opts.IndexLoadFactor <= 0 || opts.IndexLoadFactor > 1 {
+		return errInvalidLoadFactor
+	}
+	if opts.IndexBucketSlots < 0 || opts.IndexBucketSlots > numSlots(opts.HashAlgorithm == HashXXH64) {
+		return errInvalidBucketSlots
+	}
+	if opts.IndexInMemory && opts.PublishManifest {
+		// Replicas read the index files.
+		return errIndexInMemoryManifest
+	}
+	return nil
+}
+
 // index is an on-disk linear hashing hash table.
 // It uses two files to store the hash table on disk - "main" and "overflow" index files.
 // Each index file holds an array of buckets.
 type index struct {
 	opts           *Options
+	metaName       string
+	filterName     string
 	main           *file   // Main index file.
 	overflow       *file   // Overflow index file.
 	freeBucketOffs []int64 // Offsets of freed buckets.
 	level          uint8   // Maximum number of buckets on a logarithmic scale.
-	numKeys        uint32  // Number of keys.
+	numKeys        uint64  // Number of keys.
 	numBuckets     uint32  // Number of buckets.
 	splitBucketIdx uint32  // Index of the bucket to split on next split.
+	numShards      int     // Total number of index shards in the DB.
+	writeBehind    *writeBehind
+	hashAlgorithm  HashAlgorithm
+	access         *accessCounts // Bucket lookup counts, nil unless Options.HotBuckets or TrackAccessFrequency is set.
+	filter         *cuckooFilter // Slot hashes, nil unless Options.MembershipFilter is set.
+	watermark      datalogWatermark
+	bucketSlots    int  // Bucket capacity, 0 if the buckets are filled up.
+	inMemory       bool // The index files are held in memory, see Options.IndexInMemory.
 }
 
 type indexMeta struct {
 	Level               uint8
-	NumKeys             uint32
+	NumKeys             uint64
 	NumBuckets          uint32
 	SplitBucketIndex    uint32
 	FreeOverflowBuckets []int64
+	NumShards           int
+	Watermark           datalogWatermark // Datalog the index was closed with, zero if unknown.
+	BucketSlots         int              // Bucket capacity set by Options.IndexBucketSlots, 0 if the buckets are filled up.
+}
+
+// datalogWatermark identifies the segments the index was written for: the sequence ID and the size of the newest segment file.
+type datalogWatermark struct {
+	SequenceID uint64
+	Size       int64
+}
+
+// readDatalogWatermark returns the watermark of the segment files.
+func readDatalogWatermark(fsys fs.FileSystem) (datalogWatermark, error) {
+	w := datalogWatermark{}
+	files, err := fsys.ReadDir(".")
+	if err != nil {
+		return w, err
+	}
+	for _, file := range files {
+		if filepath.Ext(file.Name()) != segmentExt {
+			continue
+		}
+		_, seqID, err := parseSegmentName(file.Name())
+		if err != nil {
+			return w, err
+		}
+		if seqID >= w.SequenceID {
+			w = datalogWatermark{SequenceID: seqID, Size: file.Size()}
+		}
+	}
+	return w, nil
 }
 
 // matchKeyFunc returns whether the slot matches the key sought.
 type matchKeyFunc func(slot) (bool, error)
 
-func openIndex(opts *Options) (*index, error) {
-	main, err := openFile(opts.FileSystem, indexMainName, false)
+// indexFileNames returns names of the main, overflow and meta files of the index shard.
+// The first shard uses the same file names as a non-sharded index.
+func indexFileNames(shardID int) (string, string, string) {
+	if shardID == 0 {
+		return indexMainName, indexOverflowName, indexMetaName
+	}
+	suffix := "-" + strconv.Itoa(shardID)
+	return "main" + suffix + indexExt, "overflow" + suffix + indexExt, "index" + suffix + metaExt
+}
+
+func openIndex(opts *Options, shardID int) (*index, error) {
+	mainName, overflowName, metaName := indexFileNames(shardID)
+	// Buckets are updated in place in the mapped memory, the modified pages are written by sync.
+	open := writableMmapOpenFunc(opts.FileSystem)
+	if opts.IndexInMemory {
+		mem := fs.NewMem()
+		for _, name := range []string{mainName, overflowName} {
+			if err := loadIndexFile(opts, mem, name); err != nil {
+				return nil, err
+			}
+		}
+		open = mem.OpenFile
+	}
+	main, err := openFileWith(open, mainName, false)
 	if err != nil {
 		return nil, errors.Wrap(err, "opening main index")
 	}
-	overflow, err := openFile(opts.FileSystem, indexOverflowName, false)
+	overflow, err := openFileWith(open, overflowName, false)
 	if err != nil {
 		_ = main.Close()
 		return nil, errors.Wrap(err, "opening overflow index")
 	}
 	idx := &index{
-		opts:       opts,
-		main:       main,
-		overflow:   overflow,
-		numBuckets: 1,
+		opts:        opts,
+		metaName:    metaName,
+		filterName:  filterFileName(shardID),
+		main:        main,
+		overflow:    overflow,
+		numBuckets:  1,
+		numShards:   opts.IndexShards,
+		writeBehind: newWriteBehind(opts),
+		access:      newAccessCounts(opts),
+		inMemory:    opts.IndexInMemory,
 	}
 	if main.empty() {
-		// Add an empty bucket.
-		if _, err = idx.main.extend(bucketSize); err != nil {
+		idx.bucketSlots = opts.IndexBucketSlots
+		if err := idx.init(opts.HashAlgorithm); err != nil {
 			_ = main.Close()
 			_ = overflow.Close()
 			return nil, err
@@ -63,25 +178,81 @@ func openIndex(opts *Options) (*index, error) {
 	} else if err := idx.readMeta(); err != nil {
 		_ = main.Close()
 		_ = overflow.Close()
+		if errors.Is(err, os.ErrNotExist) || err == io.EOF || err == io.ErrUnexpectedEOF {
+			// The meta is written when the index is closed.
+			err = &CorruptionError{Segment: metaName, Reason: "index meta is missing or truncated"}
+		}
 		return nil, errors.Wrap(err, "opening index meta")
+	} else if err := idx.verify(opts.verifyIndex); err != nil {
+		_ = main.Close()
+		_ = overflow.Close()
+		return nil, err
+	}
+	idx.hashAlgorithm = HashMurmur32
+	if main.flags&headerFlagWideHash != 0 {
+		idx.hashAlgorithm = HashXXH64
+	}
+	if !opts.ReadOnly && opts.IndexBucketSlots != 0 && opts.IndexBucketSlots != idx.slotsPerBucket() {
+		// The index is migrated to the new layout by rebuilding it.
+		_ = main.Close()
+		_ = overflow.Close()
+		if opts.IndexBucketSlots > numSlots(idx.hashAlgorithm == HashXXH64) {
+			return nil, errInvalidBucketSlots
+		}
+		return nil, errors.Wrapf(errIndexLayout, "index has %d slots per bucket, want %d", idx.slotsPerBucket(), opts.IndexBucketSlots)
+	}
+	if err := idx.openFilter(); err != nil {
+		_ = main.Close()
+		_ = overflow.Close()
+		return nil, errors.Wrap(err, "opening membership filter")
 	}
 	return idx, nil
 }
 
-func (idx *index) writeMeta() error {
-	m := indexMeta{
+// init initializes a new index: the hash width is recorded in the file headers and an empty bucket is added.
+func (idx *index) init(alg HashAlgorithm) error {
+	if !alg.valid() {
+		return errUnsupportedHash
+	}
+	if alg == HashXXH64 {
+		if err := idx.main.setHeader(headerFlagWideHash, idx.main.checksum); err != nil {
+			return err
+		}
+		if err := idx.overflow.setHeader(headerFlagWideHash, idx.overflow.checksum); err != nil {
+			return err
+		}
+	}
+	_, err := idx.main.extend(bucketSize)
+	return err
+}
+
+func (idx *index) meta() indexMeta {
+	return indexMeta{
 		Level:               idx.level,
 		NumKeys:             idx.numKeys,
 		NumBuckets:          idx.numBuckets,
 		SplitBucketIndex:    idx.splitBucketIdx,
 		FreeOverflowBuckets: idx.freeBucketOffs,
+		NumShards:           idx.numShards,
+		Watermark:           idx.watermark,
+		BucketSlots:         idx.bucketSlots,
 	}
-	return writeGobFile(idx.opts.FileSystem, indexMetaName, m)
+}
+
+// writeMeta durably writes the meta to a temporary file renamed into place,
+// a crash while the meta is written leaves the previous meta.
+func (idx *index) writeMeta() error {
+	fsys := idx.opts.FileSystem
+	tmpName := idx.metaName + ".tmp"
+	if err := writeSyncedFile(fsys, tmpName, writeGob(idx.meta())); err != nil {
+		return err
+	}
+	return fsys.Rename(tmpName, idx.metaName)
 }
 
 func (idx *index) readMeta() error {
 	m := indexMeta{}
-	if err := readGobFile(idx.opts.FileSystem, indexMetaName, &m); err != nil {
+	if err := readGobFile(idx.opts.FileSystem, idx.metaName, &m); err != nil {
 		return err
 	}
 	idx.level = m.Level
@@ -89,18 +260,87 @@ func (idx *index) readMeta() error {
 	idx.numBuckets = m.NumBuckets
 	idx.splitBucketIdx = m.SplitBucketIndex
 	idx.freeBucketOffs = m.FreeOverflowBuckets
+	idx.numShards = m.NumShards
+	idx.watermark = m.Watermark
+	idx.bucketSlots = m.BucketSlots
+	if idx.numShards == 0 {
+		// The index was created before sharding was introduced.
+		idx.numShards = 1
+	}
+	return nil
+}
+
+// verify checks the format version and the size of the index files. When scan is true, it also checks
+// the checksums of the buckets and the number of slots, free overflow buckets aren't read.
+//
+// The buckets are scanned only after an unclean shutdown, when the index is restored from a checkpoint,
+// otherwise the checksums are checked lazily when the buckets are read. Buckets are updated in place
+// rather than renamed into place: only a crash can tear a bucket write, and after a crash the index is restored
+// from a checkpoint, whose files are synced before the checkpoint is renamed into place, or rebuilt from
+// the datalog. The index meta is renamed into place by Close.
+func (idx *index) verify(scan bool) error {
+	if idx.main.formatVersion < indexWideOffsetVersion || idx.overflow.formatVersion < indexWideOffsetVersion {
+		return errIndexFormat
+	}
+	if idx.main.size < bucketOffset(idx.numBuckets) {
+		return &CorruptionError{Segment: idx.main.name, Offset: idx.main.size, Reason: "index is shorter than its buckets"}
+	}
+	if !scan {
+		return nil
+	}
+	var n uint64
+	for bucketIdx := uint32(0); bucketIdx < idx.numBuckets; bucketIdx++ {
+		it := idx.newBucketIterator(bucketIdx)
+		for {
+			b, err := it.next()
+			if err == ErrIterationDone {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			for i := 0; i < numSlots(b.wideHash()) && b.slots[i].offset != 0; i++ {
+				n++
+			}
+		}
+	}
+	// The index meta of a read-only DB may be older than the buckets updated by the writer.
+	if n != idx.numKeys && !idx.opts.ReadOnly {
+		return errors.Wrapf(ErrIndexMismatch, "index holds %d keys, its meta counts %d", n, idx.numKeys)
+	}
 	return nil
 }
 
-func (idx *index) bucketIndex(hash uint32) uint32 {
-	bidx := hash & ((1 << idx.level) - 1)
+// checkWatermark returns ErrIndexMismatch if the segment files changed since the index was closed.
+func (si *shardedIndex) checkWatermark(fsys fs.FileSystem) error {
+	want := si.shards[0].watermark
+	if want == (datalogWatermark{}) {
+		// The index was written before watermarks were recorded.
+		return nil
+	}
+	w, err := readDatalogWatermark(fsys)
+	if err != nil {
+		return err
+	}
+	if w != want {
+		return errors.Wrapf(ErrIndexMismatch, "newest segment is %d of %d bytes, the index was closed with %d of %d bytes",
+			w.SequenceID, w.Size, want.SequenceID, want.Size)
+	}
+	return nil
+}
+
+// bucketIndex maps the hash to a bucket. Only the low 32 bits of the hash are used.
+func (idx *index) bucketIndex(hash uint64) uint32 {
+	h := uint32(hash)
+	bidx := h & ((1 << idx.level) - 1)
 	if bidx < idx.splitBucketIdx {
-		return hash & ((1 << (idx.level + 1)) - 1)
+		return h & ((1 << (idx.level + 1)) - 1)
 	}
 	return bidx
 }
 
 type bucketIterator struct {
+	idx      *index
 	off      int64 // Offset of the next bucket.
 	f        *file // Current index file.
 	overflow *file // Overflow index file.
@@ -113,6 +353,7 @@ func bucketOffset(idx uint32) int64 {
 
 func (idx *index) newBucketIterator(startBucketIdx uint32) *bucketIterator {
 	return &bucketIterator{
+		idx:      idx,
 		off:      bucketOffset(startBucketIdx),
 		f:        idx.main,
 		overflow: idx.overflow,
@@ -124,7 +365,7 @@ func (it *bucketIterator) next() (bucketHandle, error) {
 		return bucketHandle{}, ErrIterationDone
 	}
 	b := bucketHandle{file: it.f, offset: it.off}
-	if err := b.read(); err != nil {
+	if err := it.idx.readBucket(&b); err != nil {
 		return bucketHandle{}, err
 	}
 	it.f = it.overflow
@@ -132,7 +373,7 @@ func (it *bucketIterator) next() (bucketHandle, error) {
 	return b, nil
 }
 
-func (idx *index) get(hash uint32, matchKey matchKeyFunc) error {
+func (idx *index) get(hash uint64, matchKey matchKeyFunc) error {
 	it := idx.newBucketIterator(idx.bucketIndex(hash))
 	for {
 		b, err := it.next()
@@ -171,7 +412,7 @@ func (idx *index) findInsertionBucket(newSlot slot, matchKey matchKeyFunc) (*slo
 		}
 		sw.bucket = &b
 		var i int
-		for i = 0; i < slotsPerBucket; i++ {
+		for i = 0; i < idx.slotsPerBucket(); i++ {
 			sl := b.slots[i]
 			if sl.offset == 0 {
 				// Found an empty slot.
@@ -202,7 +443,7 @@ func (idx *index) findInsertionBucket(newSlot slot, matchKey matchKeyFunc) (*slo
 
 func (idx *index) put(newSlot slot, matchKey matchKeyFunc) error {
 	if idx.numKeys == MaxKeys {
-		return errFull
+		return ErrFull
 	}
 	sw, overwritingExisting, err := idx.findInsertionBucket(newSlot, matchKey)
 	if err != nil {
@@ -211,14 +452,17 @@ func (idx *index) put(newSlot slot, matchKey matchKeyFunc) error {
 	if err := sw.insert(newSlot, idx); err != nil {
 		return err
 	}
-	if err := sw.write(); err != nil {
+	if err := sw.write(idx); err != nil {
 		return err
 	}
 	if overwritingExisting {
 		return nil
 	}
 	idx.numKeys++
-	if float64(idx.numKeys)/float64(idx.numBuckets*slotsPerBucket) > loadFactor {
+	if err := idx.filterAdd(newSlot.hash); err != nil {
+		return err
+	}
+	if float64(idx.numKeys)/(float64(idx.numBuckets)*float64(idx.slotsPerBucket())) > idx.opts.IndexLoadFactor {
 		if err := idx.split(); err != nil {
 			return err
 		}
@@ -226,40 +470,156 @@ func (idx *index) put(newSlot slot, matchKey matchKeyFunc) error {
 	return nil
 }
 
-//func (idx *index) delete(hash uint32, matchKey matchKeyFunc) error {
-//	it := idx.newBucketIterator(idx.bucketIndex(hash))
-//	for {
-//		b, err := it.next()
-//		if err == ErrIterationDone {
-//			return nil
-//		}
-//		if err != nil {
-//			return err
-//		}
-//		for i := 0; i < slotsPerBucket; i++ {
-//			sl := b.slots[i]
-//			if sl.offset == 0 {
-//				break
-//			}
-//			if hash != sl.hash {
-//				continue
-//			}
-//			match, err := matchKey(sl)
-//			if err != nil {
-//				return err
-//			}
-//			if !match {
-//				continue
-//			}
-//			b.del(i)
-//			if err := b.write(); err != nil {
-//				return err
-//			}
-//			idx.numKeys--
-//			return nil
-//		}
-//	}
-//}
+// deleteFunc removes the slots of the bucket chain for which del returns true and returns the number of removed slots.
+// A slot isn't removed when del returns an error, del isn't called for the following slots.
+// The remaining slots are packed towards the head of the chain, lookups and insertions stop at the first empty slot.
+func (idx *index) deleteFunc(bucketIdx uint32, del func(sl slot) (bool, error)) (int, error) {
+	var buckets []bucketHandle
+	var kept []slot
+	deleted := 0
+	firstChanged, unchanged := -1, 0 // First rewritten bucket and the number of slots preceding it.
+	var delErr error
+	var removed []uint64 // Hashes of the deleted slots.
+	it := idx.newBucketIterator(bucketIdx)
+	for {
+		b, err := it.next()
+		if err == ErrIterationDone {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		if firstChanged == -1 {
+			unchanged = len(kept)
+		}
+		for i := 0; i < numSlots(b.wideHash()); i++ {
+			sl := b.slots[i]
+			if sl.offset == 0 {
+				break
+			}
+			if delErr == nil {
+				var ok bool
+				ok, delErr = del(sl)
+				if ok && delErr == nil {
+					if firstChanged == -1 {
+						firstChanged = len(buckets)
+					}
+					deleted++
+					removed = append(removed, sl.hash)
+					continue
+				}
+			}
+			kept = append(kept, sl)
+		}
+		buckets = append(buckets, b)
+	}
+	if deleted == 0 {
+		return 0, delErr
+	}
+	kept = kept[unchanged:]
+	for i := firstChanged; i < len(buckets); i++ {
+		b := &buckets[i]
+		for j := 0; j < idx.slotsPerBucket(); j++ {
+			b.slots[j] = slot{}
+			if len(kept) > 0 {
+				b.slots[j] = kept[0]
+				kept = kept[1:]
+			}
+		}
+		if err := idx.writeBucket(b); err != nil {
+			return deleted, err
+		}
+	}
+	idx.numKeys -= uint64(deleted)
+	for _, h := range removed {
+		idx.filter.remove(h)
+	}
+	return deleted, delErr
+}
+
+// deleteSlots removes slots pointing to records of the segment between the start and end offsets.
+// Every bucket is scanned, the slots are found by their location since the keys are unknown.
+func (idx *index) deleteSlots(segmentID uint16, start uint64, end uint64) (int, error) {
+	var deleted int
+	for bucketIdx := uint32(0); bucketIdx < idx.numBuckets; bucketIdx++ {
+		it := idx.newBucketIterator(bucketIdx)
+		for {
+			b, err := it.next()
+			if err == ErrIterationDone {
+				break
+			}
+			if err != nil {
+				return deleted, err
+			}
+			n := 0
+			var removedHashes []uint64
+			for i := 0; i < slotsPerBucket; i++ {
+				sl := b.slots[i]
+				if sl.offset == 0 {
+					break
+				}
+				if sl.segmentID == segmentID && sl.offset >= start && sl.offset < end {
+					removedHashes = append(removedHashes, sl.hash)
+					continue
+				}
+				b.slots[n] = sl
+				n++
+			}
+			removed := 0
+			for i := n; i < slotsPerBucket && b.slots[i].offset != 0; i++ {
+				b.slots[i] = slot{}
+				removed++
+			}
+			if removed == 0 {
+				continue
+			}
+			if err := idx.writeBucket(&b); err != nil {
+				return deleted, err
+			}
+			idx.numKeys -= uint64(removed)
+			deleted += removed
+			for _, h := range removedHashes {
+				idx.filter.remove(h)
+			}
+		}
+	}
+	return deleted, nil
+}
+
+// slotsPerBucket returns the bucket capacity of the index.
+func (idx *index) slotsPerBucket() int {
+	if idx.bucketSlots > 0 {
+		return idx.bucketSlots
+	}
+	return numSlots(idx.hashAlgorithm == HashXXH64)
+}
+
+// truncate removes all keys, the index is reset to a single empty bucket.
+func (idx *index) truncate() error {
+	if idx.writeBehind != nil {
+		idx.writeBehind.dirty = make(map[bucketKey]bucket)
+	}
+	if err := idx.main.Truncate(headerSize); err != nil {
+		return err
+	}
+	idx.main.size = headerSize
+	if _, err := idx.main.extend(bucketSize); err != nil {
+		return err
+	}
+	if err := idx.overflow.Truncate(headerSize); err != nil {
+		return err
+	}
+	idx.overflow.size = headerSize
+	idx.freeBucketOffs = nil
+	idx.level = 0
+	idx.numKeys = 0
+	idx.numBuckets = 1
+	idx.splitBucketIdx = 0
+	if idx.filter != nil {
+		idx.filter = newCuckooFilter(0)
+	}
+	return nil
+}
 
 func (idx *index) createOverflowBucket() (*bucketHandle, error) {
 	var off int64
@@ -334,10 +694,10 @@ func (idx *index) split() error {
 
 	idx.freeOverflowBucket(overflowBuckets...)
 
-	if err := sw.write(); err != nil {
+	if err := sw.write(idx); err != nil {
 		return err
 	}
-	if err := updatedBucket.write(); err != nil {
+	if err := updatedBucket.write(idx); err != nil {
 		return err
 	}
 
@@ -345,10 +705,28 @@ func (idx *index) split() error {
 	return nil
 }
 
+// sync commits the index files. Only pages modified since the last sync are written
+// when the files are memory-mapped for writing.
+func (idx *index) sync() error {
+	if err := idx.main.Sync(); err != nil {
+		return err
+	}
+	return idx.overflow.Sync()
+}
+
 func (idx *index) close() error {
+	if err := idx.flush(); err != nil {
+		return err
+	}
+	if err := idx.store(); err != nil {
+		return err
+	}
 	if err := idx.writeMeta(); err != nil {
 		return err
 	}
+	if err := idx.writeFilter(); err != nil {
+		return err
+	}
 	if err := idx.main.Close(); err != nil {
 		return err
 	}
@@ -358,6 +736,6 @@ func (idx *index) close() error {
 	return nil
 }
 
-func (idx *index) count() uint32 {
+func (idx *index) count() uint64 {
 	return idx.numKeys
 }
@@ -0,0 +1,62 @@
+package pogreb
+
+import (
+	"io"
+
+	"github.com/domaincrawler/pogreb/fs"
+	"github.com/domaincrawler/pogreb/internal/errors"
+)
+
+var errIndexInMemoryManifest = errors.New("Options.IndexInMemory can't be used with Options.PublishManifest")
+
+// loadIndexFile copies the index file into the memory file system, see Options.IndexInMemory.
+// A missing index file is created on disk first.
+func loadIndexFile(opts *Options, mem fs.FileSystem, name string) error {
+	src, err := openFile(opts.FileSystem, name, false)
+	if err != nil {
+		return err
+	}
+	err = copyFileTo(mem, name, src)
+	if closeErr := src.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.Wrapf(err, "loading %s into memory", name)
+	}
+	return nil
+}
+
+// storeIndexFile writes the index file held in memory to a temporary file renamed into place,
+// a crash while the index is written leaves the previous file.
+func storeIndexFile(fsys fs.FileSystem, f *file) error {
+	tmpName := f.name + ".tmp"
+	if err := copyToNewFile(fsys, tmpName, io.NewSectionReader(f, 0, f.size)); err != nil {
+		return err
+	}
+	return fsys.Rename(tmpName, f.name)
+}
+
+// store writes the index files held in memory to disk.
+func (idx *index) store() error {
+	if !idx.inMemory || idx.opts.ReadOnly {
+		return nil
+	}
+	if err := storeIndexFile(idx.opts.FileSystem, idx.main); err != nil {
+		return errors.Wrap(err, "storing main index")
+	}
+	if err := storeIndexFile(idx.opts.FileSystem, idx.overflow); err != nil {
+		return errors.Wrap(err, "storing overflow index")
+	}
+	return nil
+}
+
+// memoryBytes returns the memory taken by the index files held in memory.
+func (si *shardedIndex) memoryBytes() int64 {
+	var n int64
+	for _, sh := range si.shards {
+		if sh.inMemory {
+			n += sh.main.size + sh.overflow.size
+		}
+	}
+	return n
+}
@@ -0,0 +1,76 @@
+package pogreb
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/domaincrawler/pogreb/internal/assert"
+)
+
+func indexFileSize(t *testing.T, name string) int64 {
+	t.Helper()
+	fi, err := testFS.Stat(filepath.Join(testDBName, name))
+	assert.Nil(t, err)
+	return fi.Size()
+}
+
+func TestIndexInMemory(t *testing.T) {
+	_, err := createTestDB(&Options{IndexInMemory: true, PublishManifest: true})
+	assert.Equal(t, errIndexInMemoryManifest, err)
+
+	opts := &Options{FileSystem: testFS, IndexInMemory: true}
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(deleteTestKey(i)))
+	}
+	assert.Nil(t, db.Sync())
+	// Only the headers of the new index files are on disk.
+	assert.Equal(t, int64(headerSize), indexFileSize(t, indexMainName))
+	assert.Equal(t, int64(headerSize), indexFileSize(t, indexOverflowName))
+	assert.Nil(t, db.Close())
+	mainSize, overflowSize := indexFileSize(t, indexMainName), indexFileSize(t, indexOverflowName)
+	assert.Equal(t, true, mainSize > headerSize)
+
+	db, err = Open(testDBName, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, false, db.OpenReport().Recovered)
+	assert.Equal(t, mainSize+overflowSize, db.OpenReport().IndexMemoryBytes)
+	for i := 0; i < 1000; i++ {
+		assertHas(t, db, deleteTestKey(i), true)
+	}
+	assert.Nil(t, db.Delete(deleteTestKey(0)))
+	assert.Nil(t, db.Close())
+
+	// The files written by Close are read without the option.
+	db, err = Open(testDBName, &Options{FileSystem: testFS})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), db.OpenReport().IndexMemoryBytes)
+	assert.Equal(t, uint64(999), db.Count())
+	assertHas(t, db, deleteTestKey(0), false)
+	assert.Nil(t, db.Close())
+}
+
+func TestIndexInMemoryCheckpoint(t *testing.T) {
+	opts := &Options{FileSystem: testFS, IndexInMemory: true, IndexCheckpointInterval: time.Hour}
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(deleteTestKey(i)))
+	}
+	assert.Nil(t, db.checkpoint())
+	for i := 100; i < 110; i++ {
+		assert.Nil(t, db.Put(deleteTestKey(i)))
+	}
+	simulateCrash(t, db)
+
+	db, err = Open(testDBName, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, true, db.OpenReport().CheckpointRestored)
+	assert.Equal(t, uint64(110), db.Count())
+	for i := 0; i < 110; i++ {
+		assertHas(t, db, deleteTestKey(i), true)
+	}
+	assert.Nil(t, db.Close())
+}
@@ -0,0 +1,90 @@
+package pogreb
+
+// IndexStats describes the size of the index and the distribution of the keys over its buckets, see DB.IndexStats.
+type IndexStats struct {
+	Shards              int     // Number of index shards.
+	Keys                uint64  // Number of keys.
+	Buckets             uint64  // Number of main buckets. Each key hash is assigned to a main bucket.
+	Splits              uint64  // Number of main buckets added by splitting a bucket when the index grew.
+	OverflowBuckets     uint64  // Number of overflow buckets chained to the main buckets.
+	FreeOverflowBuckets uint64  // Number of freed overflow buckets reused by the next inserts.
+	BucketSlots         int     // Number of slots filled in a bucket before an overflow bucket is chained.
+	LoadFactor          float64 // Number of keys divided by the number of slots of the main buckets.
+	// Average number of buckets read to find a key in the index, 1 if no key is held in an overflow bucket.
+	AverageProbeLength float64
+	MaxChainLength     int   // Largest number of buckets chained to a main bucket, including the main bucket.
+	MemoryBytes        int64 // Estimated memory taken by the index files and the membership filter.
+	// Occupancy[n] is the number of main buckets whose chain holds n keys. Counts past BucketSlots are a skewed
+	// distribution of the key hashes or a bucket capacity too low for the load factor.
+	Occupancy []uint64
+}
+
+// IndexStats walks all buckets of the index and returns its statistics,
+// e.g. to diagnose a poor distribution of the key hashes before changing Options.IndexBucketSlots.
+// Writes to a shard wait while its buckets are read.
+func (db *DB) IndexStats() (IndexStats, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	stats := IndexStats{Shards: len(db.index.shards)}
+	var probes uint64
+	for _, shard := range db.index.shards {
+		n, err := shard.addStats(&stats)
+		if err != nil {
+			return stats, err
+		}
+		probes += n
+	}
+	if stats.Keys > 0 {
+		stats.AverageProbeLength = float64(probes) / float64(stats.Keys)
+	}
+	if stats.Buckets > 0 && stats.BucketSlots > 0 {
+		stats.LoadFactor = float64(stats.Keys) / float64(stats.Buckets*uint64(stats.BucketSlots))
+	}
+	return stats, nil
+}
+
+// addStats adds the buckets of the shard to the statistics. It returns the total number of buckets read to find each key.
+func (sh *indexShard) addStats(stats *IndexStats) (uint64, error) {
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+	stats.Keys += sh.numKeys
+	stats.Buckets += uint64(sh.numBuckets)
+	stats.Splits += uint64(sh.numBuckets) - 1
+	stats.FreeOverflowBuckets += uint64(len(sh.freeBucketOffs))
+	stats.BucketSlots = sh.slotsPerBucket()
+	stats.MemoryBytes += sh.main.size + sh.overflow.size
+	if sh.filter != nil {
+		stats.MemoryBytes += int64(len(sh.filter.fps)) * 2
+	}
+	var probes uint64
+	for bucketIdx := uint32(0); bucketIdx < sh.numBuckets; bucketIdx++ {
+		keys, chain := 0, 0
+		it := sh.newBucketIterator(bucketIdx)
+		for {
+			b, err := it.next()
+			if err == ErrIterationDone {
+				break
+			}
+			if err != nil {
+				return probes, err
+			}
+			chain++
+			for i := 0; i < slotsPerBucket; i++ {
+				if b.slots[i].offset == 0 {
+					break
+				}
+				keys++
+				probes += uint64(chain)
+			}
+		}
+		stats.OverflowBuckets += uint64(chain - 1)
+		if chain > stats.MaxChainLength {
+			stats.MaxChainLength = chain
+		}
+		for len(stats.Occupancy) <= keys {
+			stats.Occupancy = append(stats.Occupancy, 0)
+		}
+		stats.Occupancy[keys]++
+	}
+	return probes, nil
+}
@@ -0,0 +1,61 @@
+package pogreb
+
+import (
+	"testing"
+
+	"github.com/domaincrawler/pogreb/internal/assert"
+)
+
+func TestIndexStats(t *testing.T) {
+	db, err := createTestDB(nil)
+	assert.Nil(t, err)
+	defer db.Close()
+	stats, err := db.IndexStats()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), stats.Buckets)
+	assert.Equal(t, []uint64{1}, stats.Occupancy)
+	assert.Equal(t, float64(0), stats.AverageProbeLength)
+
+	const n = 5000
+	for i := 0; i < n; i++ {
+		assert.Nil(t, db.Put(deleteTestKey(i)))
+	}
+	stats, err = db.IndexStats()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, stats.Shards)
+	assert.Equal(t, uint64(n), stats.Keys)
+	assert.Equal(t, db.index.shards[0].numBuckets, uint32(stats.Buckets))
+	assert.Equal(t, stats.Buckets-1, stats.Splits)
+	assert.Equal(t, slotsPerBucket, stats.BucketSlots)
+	assert.Equal(t, true, stats.LoadFactor > 0 && stats.LoadFactor <= 1)
+	assert.Equal(t, true, stats.AverageProbeLength >= 1)
+	assert.Equal(t, true, stats.MaxChainLength >= 1)
+	assert.Equal(t, true, stats.MemoryBytes >= int64(stats.Buckets)*bucketSize)
+	var buckets, keys uint64
+	for i, c := range stats.Occupancy {
+		buckets += c
+		keys += uint64(i) * c
+	}
+	assert.Equal(t, stats.Buckets, buckets)
+	assert.Equal(t, stats.Keys, keys)
+	if stats.OverflowBuckets == 0 {
+		assert.Equal(t, float64(1), stats.AverageProbeLength)
+	}
+}
+
+func TestIndexStatsOverflow(t *testing.T) {
+	// Small buckets fill up before they're split, keys are chained in overflow buckets.
+	db, err := createTestDB(&Options{IndexBucketSlots: 2})
+	assert.Nil(t, err)
+	defer db.Close()
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(deleteTestKey(i)))
+	}
+	stats, err := db.IndexStats()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, stats.BucketSlots)
+	assert.Equal(t, true, stats.OverflowBuckets > 0)
+	assert.Equal(t, true, stats.MaxChainLength > 1)
+	assert.Equal(t, true, stats.AverageProbeLength > 1)
+	assert.Equal(t, true, len(stats.Occupancy) > stats.BucketSlots+1)
+}
@@ -23,6 +23,16 @@ func New(text string) error {
 	return errors.New(text)
 }
 
+// Is reports whether any error in err's chain matches target.
+func Is(err, target error) bool {
+	return errors.Is(err, target)
+}
+
+// As finds the first error in err's chain that matches target, and if so, sets target to that error value.
+func As(err error, target interface{}) bool {
+	return errors.As(err, target)
+}
+
 // Wrap returns an error annotating err with an additional message.
 // Compatible with Go 1.13 error chains.
 func Wrap(cause error, message string) error {
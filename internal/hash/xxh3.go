@@ -0,0 +1,182 @@
+package hash
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+const (
+	prime32v1 uint64 = 0x9E3779B1
+	prime32v2 uint64 = 0x85EBCA77
+	prime32v3 uint64 = 0xC2B2AE3D
+
+	xxh3StripeLen         = 64
+	xxh3SecretConsumeRate = 8
+	xxh3MidSizeMax        = 240
+	xxh3SecretSizeMin     = 136
+)
+
+// xxh3Secret is the default secret of XXH3.
+var xxh3Secret = [192]byte{
+	0xb8, 0xfe, 0x6c, 0x39, 0x23, 0xa4, 0x4b, 0xbe, 0x7c, 0x01, 0x81, 0x2c, 0xf7, 0x21, 0xad, 0x1c,
+	0xde, 0xd4, 0x6d, 0xe9, 0x83, 0x90, 0x97, 0xdb, 0x72, 0x40, 0xa4, 0xa4, 0xb7, 0xb3, 0x67, 0x1f,
+	0xcb, 0x79, 0xe6, 0x4e, 0xcc, 0xc0, 0xe5, 0x78, 0x82, 0x5a, 0xd0, 0x7d, 0xcc, 0xff, 0x72, 0x21,
+	0xb8, 0x08, 0x46, 0x74, 0xf7, 0x43, 0x24, 0x8e, 0xe0, 0x35, 0x90, 0xe6, 0x81, 0x3a, 0x26, 0x4c,
+	0x3c, 0x28, 0x52, 0xbb, 0x91, 0xc3, 0x00, 0xcb, 0x88, 0xd0, 0x65, 0x8b, 0x1b, 0x53, 0x2e, 0xa3,
+	0x71, 0x64, 0x48, 0x97, 0xa2, 0x0d, 0xf9, 0x4e, 0x38, 0x19, 0xef, 0x46, 0xa9, 0xde, 0xac, 0xd8,
+	0xa8, 0xfa, 0x76, 0x3f, 0xe3, 0x9c, 0x34, 0x3f, 0xf9, 0xdc, 0xbb, 0xc7, 0xc7, 0x0b, 0x4f, 0x1d,
+	0x8a, 0x51, 0xe0, 0x4b, 0xcd, 0xb4, 0x59, 0x31, 0xc8, 0x9f, 0x7e, 0xc9, 0xd9, 0x78, 0x73, 0x64,
+	0xea, 0xc5, 0xac, 0x83, 0x34, 0xd3, 0xeb, 0xc3, 0xc5, 0x81, 0xa0, 0xff, 0xfa, 0x13, 0x63, 0xeb,
+	0x17, 0x0d, 0xdd, 0x51, 0xb7, 0xf0, 0xda, 0x49, 0xd3, 0x16, 0x55, 0x26, 0x29, 0xd4, 0x68, 0x9e,
+	0x2b, 0x16, 0xbe, 0x58, 0x7d, 0x47, 0xa1, 0xfc, 0x8f, 0xf8, 0xb8, 0xd1, 0x7a, 0xd0, 0x31, 0xce,
+	0x45, 0xcb, 0x3a, 0x8f, 0x95, 0x16, 0x04, 0x28, 0xaf, 0xd7, 0xfb, 0xca, 0xbb, 0x4b, 0x40, 0x7e,
+}
+
+// XXH3Sum64WithSeed is a port of XXH3_64bits_withSeed function.
+func XXH3Sum64WithSeed(data []byte, seed uint64) uint64 {
+	n := len(data)
+	switch {
+	case n == 0:
+		return avalanche64(seed ^ u64(xxh3Secret[56:]) ^ u64(xxh3Secret[64:]))
+	case n <= 3:
+		combo := uint32(data[0])<<16 | uint32(data[n>>1])<<24 | uint32(data[n-1]) | uint32(n)<<8
+		flip := uint64(u32(xxh3Secret[0:])^u32(xxh3Secret[4:])) + seed
+		return avalanche64(uint64(combo) ^ flip)
+	case n <= 8:
+		seed ^= uint64(bits.ReverseBytes32(uint32(seed))) << 32
+		input := uint64(u32(data[n-4:])) + uint64(u32(data))<<32
+		flip := (u64(xxh3Secret[8:]) ^ u64(xxh3Secret[16:])) - seed
+		return rrmxmx(input^flip, uint64(n))
+	case n <= 16:
+		flipLo := (u64(xxh3Secret[24:]) ^ u64(xxh3Secret[32:])) + seed
+		flipHi := (u64(xxh3Secret[40:]) ^ u64(xxh3Secret[48:])) - seed
+		lo := u64(data) ^ flipLo
+		hi := u64(data[n-8:]) ^ flipHi
+		return xxh3Avalanche(uint64(n) + bits.ReverseBytes64(lo) + hi + mulFold64(lo, hi))
+	case n <= 128:
+		acc := uint64(n) * prime64v1
+		if n > 32 {
+			if n > 64 {
+				if n > 96 {
+					acc += mix16(data[48:], xxh3Secret[96:], seed)
+					acc += mix16(data[n-64:], xxh3Secret[112:], seed)
+				}
+				acc += mix16(data[32:], xxh3Secret[64:], seed)
+				acc += mix16(data[n-48:], xxh3Secret[80:], seed)
+			}
+			acc += mix16(data[16:], xxh3Secret[32:], seed)
+			acc += mix16(data[n-32:], xxh3Secret[48:], seed)
+		}
+		acc += mix16(data, xxh3Secret[0:], seed)
+		acc += mix16(data[n-16:], xxh3Secret[16:], seed)
+		return xxh3Avalanche(acc)
+	case n <= xxh3MidSizeMax:
+		const startOffset, lastOffset = 3, 17
+		acc := uint64(n) * prime64v1
+		for i := 0; i < 8; i++ {
+			acc += mix16(data[16*i:], xxh3Secret[16*i:], seed)
+		}
+		acc = xxh3Avalanche(acc)
+		for i := 8; i < n/16; i++ {
+			acc += mix16(data[16*i:], xxh3Secret[16*(i-8)+startOffset:], seed)
+		}
+		acc += mix16(data[n-16:], xxh3Secret[xxh3SecretSizeMin-lastOffset:], seed)
+		return xxh3Avalanche(acc)
+	}
+	secret := xxh3Secret
+	if seed != 0 {
+		for i := 0; i < len(secret); i += 16 {
+			binary.LittleEndian.PutUint64(secret[i:], u64(xxh3Secret[i:])+seed)
+			binary.LittleEndian.PutUint64(secret[i+8:], u64(xxh3Secret[i+8:])-seed)
+		}
+	}
+	return xxh3HashLong(data, secret[:])
+}
+
+// xxh3HashLong hashes inputs longer than 240 bytes in blocks of stripes, scrambling the accumulators after every block.
+func xxh3HashLong(data, secret []byte) uint64 {
+	const lastAccStart, mergeAccsStart = 7, 11
+	acc := [8]uint64{prime32v3, prime64v1, prime64v2, prime64v3, prime64v4, prime32v2, prime64v5, prime32v1}
+	n := len(data)
+	stripesPerBlock := (len(secret) - xxh3StripeLen) / xxh3SecretConsumeRate
+	blockLen := xxh3StripeLen * stripesPerBlock
+	blocks := (n - 1) / blockLen
+	for b := 0; b < blocks; b++ {
+		for s := 0; s < stripesPerBlock; s++ {
+			accumulate512(&acc, data[b*blockLen+s*xxh3StripeLen:], secret[s*xxh3SecretConsumeRate:])
+		}
+		scramble(&acc, secret[len(secret)-xxh3StripeLen:])
+	}
+	stripes := (n - 1 - blockLen*blocks) / xxh3StripeLen
+	for s := 0; s < stripes; s++ {
+		accumulate512(&acc, data[blocks*blockLen+s*xxh3StripeLen:], secret[s*xxh3SecretConsumeRate:])
+	}
+	accumulate512(&acc, data[n-xxh3StripeLen:], secret[len(secret)-xxh3StripeLen-lastAccStart:])
+
+	h := uint64(n) * prime64v1
+	for i := 0; i < 4; i++ {
+		h += mulFold64(acc[2*i]^u64(secret[mergeAccsStart+16*i:]), acc[2*i+1]^u64(secret[mergeAccsStart+16*i+8:]))
+	}
+	return xxh3Avalanche(h)
+}
+
+func accumulate512(acc *[8]uint64, data, secret []byte) {
+	for i := 0; i < 8; i++ {
+		v := u64(data[8*i:])
+		key := v ^ u64(secret[8*i:])
+		acc[i^1] += v
+		acc[i] += (key & 0xFFFFFFFF) * (key >> 32)
+	}
+}
+
+func scramble(acc *[8]uint64, secret []byte) {
+	for i := 0; i < 8; i++ {
+		a := acc[i]
+		a ^= a >> 47
+		a ^= u64(secret[8*i:])
+		acc[i] = a * prime32v1
+	}
+}
+
+func mix16(data, secret []byte, seed uint64) uint64 {
+	lo := u64(data) ^ (u64(secret) + seed)
+	hi := u64(data[8:]) ^ (u64(secret[8:]) - seed)
+	return mulFold64(lo, hi)
+}
+
+// mulFold64 returns the 128-bit product of a and b with its halves xored.
+func mulFold64(a, b uint64) uint64 {
+	hi, lo := bits.Mul64(a, b)
+	return hi ^ lo
+}
+
+func xxh3Avalanche(h uint64) uint64 {
+	h ^= h >> 37
+	h *= 0x165667919E3779F9
+	return h ^ h>>32
+}
+
+func rrmxmx(h uint64, n uint64) uint64 {
+	h ^= bits.RotateLeft64(h, 49) ^ bits.RotateLeft64(h, 24)
+	h *= 0x9FB21C651E98DF25
+	h ^= (h >> 35) + n
+	h *= 0x9FB21C651E98DF25
+	return h ^ h>>28
+}
+
+// avalanche64 is the final mix of XXH64.
+func avalanche64(h uint64) uint64 {
+	h ^= h >> 33
+	h *= prime64v2
+	h ^= h >> 29
+	h *= prime64v3
+	return h ^ h>>32
+}
+
+func u64(b []byte) uint64 {
+	return binary.LittleEndian.Uint64(b)
+}
+
+func u32(b []byte) uint32 {
+	return binary.LittleEndian.Uint32(b)
+}
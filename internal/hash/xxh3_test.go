@@ -0,0 +1,80 @@
+package hash
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/domaincrawler/pogreb/internal/assert"
+)
+
+func TestXXH3Sum64WithSeed(t *testing.T) {
+	testCases := []struct {
+		in   []byte
+		seed uint64
+		out  uint64
+	}{
+		{
+			in:  nil,
+			out: 0x2d06800538d394c2,
+		},
+		{
+			in:  []byte("a"),
+			out: 0xe6c632b61e964e1f,
+		},
+		{
+			in:  []byte("abc"),
+			out: 0x78af5f94892f3950,
+		},
+		{
+			in:  []byte("Nobody inspects the spammish repetition"),
+			out: 0x6cb00603b5cc47e9,
+		},
+	}
+	for i, tc := range testCases {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			assert.Equal(t, tc.out, XXH3Sum64WithSeed(tc.in, tc.seed))
+		})
+	}
+}
+
+func TestXXH3Sum64WithSeedLengths(t *testing.T) {
+	// Every input length range is hashed by a different routine.
+	data := make([]byte, 2500)
+	for i := range data {
+		data[i] = byte(uint32(i) * 2654435761 >> 13)
+	}
+	testCases := []struct {
+		n       int
+		out     uint64
+		outSeed uint64
+	}{
+		{1, 0xc44bdff4074eecdb, 0x062b185e4e01441a},
+		{3, 0xa1c4a8259b827291, 0x2c0f411a2c50b127},
+		{4, 0xbb4e3d89ee0b271d, 0x6feef6740d50b0af},
+		{8, 0x79d02238b80e37b1, 0x9e68dfd280cfe66a},
+		{9, 0xf64cecc4271ff461, 0xe11e6253a093b0a0},
+		{16, 0x222e9aead6bddd51, 0x54d48b2367b853f2},
+		{17, 0x47aad6b375eb4bba, 0xd0c99ae6d4d79953},
+		{128, 0x421a9c905c6e66ba, 0xc63a7eb995d1461e},
+		{129, 0x9e2414800f83768a, 0xf1e03185164c9a4b},
+		{240, 0xb714c5fd22744964, 0xda8b158566cf41e0},
+		{241, 0xbc424a2c480dd281, 0xdbcb360abf2ca85d},
+		{1024, 0x1fd15e7d36f5e1bc, 0x249cd8f9ad0ea839},
+		{2500, 0x8d14accb80b1b109, 0xf32e1d620de515e0},
+	}
+	const seed = 0x9e3779b97f4a7c15
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%d", tc.n), func(t *testing.T) {
+			assert.Equal(t, tc.out, XXH3Sum64WithSeed(data[:tc.n], 0))
+			assert.Equal(t, tc.outSeed, XXH3Sum64WithSeed(data[:tc.n], seed))
+		})
+	}
+}
+
+func BenchmarkXXH3Sum64WithSeed(b *testing.B) {
+	data := []byte("pogreb_XXH3Sum64WithSeed_bench")
+	b.SetBytes(int64(len(data)))
+	for n := 0; n < b.N; n++ {
+		XXH3Sum64WithSeed(data, 0)
+	}
+}
@@ -0,0 +1,77 @@
+package hash
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+const (
+	prime64v1 uint64 = 11400714785074694791
+	prime64v2 uint64 = 14029467366897019727
+	prime64v3 uint64 = 1609587929392839161
+	prime64v4 uint64 = 9650029242287828579
+	prime64v5 uint64 = 2870177450012600261
+)
+
+// Sum64WithSeed is a port of XXH64 function.
+func Sum64WithSeed(data []byte, seed uint64) uint64 {
+	dlen := len(data)
+	var h uint64
+
+	if len(data) >= 32 {
+		v1 := seed + prime64v1 + prime64v2
+		v2 := seed + prime64v2
+		v3 := seed
+		v4 := seed - prime64v1
+		for len(data) >= 32 {
+			v1 = round64(v1, binary.LittleEndian.Uint64(data[0:8]))
+			v2 = round64(v2, binary.LittleEndian.Uint64(data[8:16]))
+			v3 = round64(v3, binary.LittleEndian.Uint64(data[16:24]))
+			v4 = round64(v4, binary.LittleEndian.Uint64(data[24:32]))
+			data = data[32:]
+		}
+		h = bits.RotateLeft64(v1, 1) + bits.RotateLeft64(v2, 7) + bits.RotateLeft64(v3, 12) + bits.RotateLeft64(v4, 18)
+		h = mergeRound64(h, v1)
+		h = mergeRound64(h, v2)
+		h = mergeRound64(h, v3)
+		h = mergeRound64(h, v4)
+	} else {
+		h = seed + prime64v5
+	}
+
+	h += uint64(dlen)
+
+	for len(data) >= 8 {
+		h ^= round64(0, binary.LittleEndian.Uint64(data[:8]))
+		h = bits.RotateLeft64(h, 27)*prime64v1 + prime64v4
+		data = data[8:]
+	}
+	if len(data) >= 4 {
+		h ^= uint64(binary.LittleEndian.Uint32(data[:4])) * prime64v1
+		h = bits.RotateLeft64(h, 23)*prime64v2 + prime64v3
+		data = data[4:]
+	}
+	for _, b := range data {
+		h ^= uint64(b) * prime64v5
+		h = bits.RotateLeft64(h, 11) * prime64v1
+	}
+
+	h ^= h >> 33
+	h *= prime64v2
+	h ^= h >> 29
+	h *= prime64v3
+	h ^= h >> 32
+
+	return h
+}
+
+func round64(acc, input uint64) uint64 {
+	acc += input * prime64v2
+	acc = bits.RotateLeft64(acc, 31)
+	return acc * prime64v1
+}
+
+func mergeRound64(acc, val uint64) uint64 {
+	acc ^= round64(0, val)
+	return acc*prime64v1 + prime64v4
+}
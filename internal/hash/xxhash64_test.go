@@ -0,0 +1,46 @@
+package hash
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/domaincrawler/pogreb/internal/assert"
+)
+
+func TestSum64WithSeed(t *testing.T) {
+	testCases := []struct {
+		in   []byte
+		seed uint64
+		out  uint64
+	}{
+		{
+			in:  nil,
+			out: 0xef46db3751d8e999,
+		},
+		{
+			in:  []byte("a"),
+			out: 0xd24ec4f1a98c6e5b,
+		},
+		{
+			in:  []byte("abc"),
+			out: 0x44bc2cf5ad770999,
+		},
+		{
+			in:  []byte("Nobody inspects the spammish repetition"),
+			out: 0xfbcea83c8a378bf1,
+		},
+	}
+	for i, tc := range testCases {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			assert.Equal(t, tc.out, Sum64WithSeed(tc.in, tc.seed))
+		})
+	}
+}
+
+func BenchmarkSum64WithSeed(b *testing.B) {
+	data := []byte("pogreb_Sum64WithSeed_bench")
+	b.SetBytes(int64(len(data)))
+	for n := 0; n < b.N; n++ {
+		Sum64WithSeed(data, 0)
+	}
+}
@@ -0,0 +1,78 @@
+package pogreb
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Invalidator is a cache kept on top of the DB, e.g. a negative lookup cache or a Bloom filter.
+// Invalidator methods are called synchronously by the writing goroutine while the DB holds internal locks,
+// they must be fast and must not call DB methods.
+type Invalidator interface {
+	// Invalidate is called after the key is written to or deleted from the DB.
+	Invalidate(key []byte)
+
+	// InvalidateAll is called after keys are removed from the DB,
+	// e.g. by DrainAndTruncate or by compaction discarding corrupted records.
+	InvalidateAll()
+}
+
+// invalidationBus broadcasts DB changes to registered caches.
+// It's shared by all handles of a shared DB, which keeps caches of every handle coherent.
+type invalidationBus struct {
+	mu   sync.Mutex   // Serializes subscription changes.
+	subs atomic.Value // []*invalidatorSub, replaced on every subscription change.
+}
+
+type invalidatorSub struct {
+	inv Invalidator
+}
+
+func (bus *invalidationBus) load() []*invalidatorSub {
+	subs, _ := bus.subs.Load().([]*invalidatorSub)
+	return subs
+}
+
+func (bus *invalidationBus) add(inv Invalidator) func() {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	sub := &invalidatorSub{inv: inv}
+	old := bus.load()
+	subs := make([]*invalidatorSub, len(old), len(old)+1)
+	copy(subs, old)
+	bus.subs.Store(append(subs, sub))
+	return func() {
+		bus.remove(sub)
+	}
+}
+
+func (bus *invalidationBus) remove(sub *invalidatorSub) {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	old := bus.load()
+	subs := make([]*invalidatorSub, 0, len(old))
+	for _, s := range old {
+		if s != sub {
+			subs = append(subs, s)
+		}
+	}
+	bus.subs.Store(subs)
+}
+
+func (bus *invalidationBus) invalidate(key []byte) {
+	for _, sub := range bus.load() {
+		sub.inv.Invalidate(key)
+	}
+}
+
+func (bus *invalidationBus) invalidateAll() {
+	for _, sub := range bus.load() {
+		sub.inv.InvalidateAll()
+	}
+}
+
+// AddInvalidator registers the cache to be notified about DB changes.
+// It returns a function unregistering the cache.
+func (db *DB) AddInvalidator(inv Invalidator) (remove func()) {
+	return db.invalidation.add(inv)
+}
@@ -0,0 +1,57 @@
+package pogreb
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/domaincrawler/pogreb/internal/assert"
+)
+
+// negativeCache remembers keys missing from the DB.
+type negativeCache struct {
+	mu      sync.Mutex
+	missing map[string]bool
+	resets  int
+}
+
+func (c *negativeCache) Invalidate(key []byte) {
+	c.mu.Lock()
+	delete(c.missing, string(key))
+	c.mu.Unlock()
+}
+
+func (c *negativeCache) InvalidateAll() {
+	c.mu.Lock()
+	c.missing = map[string]bool{}
+	c.resets++
+	c.mu.Unlock()
+}
+
+func TestInvalidator(t *testing.T) {
+	db, err := createTestDB(&Options{Shared: true})
+	assert.Nil(t, err)
+	db2, err := Open(testDBName, &Options{FileSystem: testFS, Shared: true})
+	assert.Nil(t, err)
+
+	c1 := &negativeCache{missing: map[string]bool{"a": true, "b": true}}
+	c2 := &negativeCache{missing: map[string]bool{"a": true}}
+	remove1 := db.AddInvalidator(c1)
+	db2.AddInvalidator(c2)
+
+	// Writes through any handle reach caches of all handles.
+	assert.Nil(t, db2.Put([]byte("a")))
+	assert.Equal(t, map[string]bool{"b": true}, c1.missing)
+	assert.Equal(t, map[string]bool{}, c2.missing)
+
+	assert.Nil(t, db.DrainAndTruncate(func(keys [][]byte) error { return nil }, 0))
+	assert.Equal(t, 1, c1.resets)
+	assert.Equal(t, 1, c2.resets)
+
+	remove1()
+	c1.missing["b"] = true
+	assert.Nil(t, db.Put([]byte("b")))
+	assert.Equal(t, map[string]bool{"b": true}, c1.missing)
+
+	assert.Nil(t, db2.Close())
+	assert.Nil(t, db.Close())
+}
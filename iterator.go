@@ -2,27 +2,151 @@ package pogreb
 
 import (
 	"errors"
+	"math/bits"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // ErrIterationDone is returned by ItemIterator.Next calls when there are no more items to return.
 var ErrIterationDone = errors.New("no more items in iterator")
 
+// ErrConcurrentModification is returned by ItemIterator.Next calls when the DB was truncated after the iterator
+// was created, e.g. by DrainAndTruncate. Keys returned by the iterator before the truncation were removed.
+var ErrConcurrentModification = errors.New("database was modified during iteration")
+
+// ErrQuotaExceeded is returned by ItemIterator.Next calls when the iterator exceeded its IterationQuota.
+var ErrQuotaExceeded = errors.New("iteration quota exceeded")
+
+// IterationQuota limits the amount of work done by an iterator, e.g. on behalf of an untrusted client.
+// Zero fields are unlimited.
+type IterationQuota struct {
+	// MaxKeys is the maximum number of keys returned by the iterator.
+	MaxKeys int64
+
+	// MaxBytes is the maximum total size of keys returned by the iterator.
+	MaxBytes int64
+
+	// MaxDuration is the maximum time since the iterator was created.
+	MaxDuration time.Duration
+
+	// Usage counts the keys and bytes returned by the iterators sharing it, MaxKeys and MaxBytes then limit
+	// them together, e.g. all iterators of a client connection. Nil counts the keys of the iterator alone.
+	Usage *QuotaUsage
+}
+
+// QuotaUsage counts the keys returned by the iterators sharing an IterationQuota. It's safe for concurrent use.
+type QuotaUsage struct {
+	mu       sync.Mutex
+	numKeys  int64
+	numBytes int64
+}
+
+// Keys returns the number of returned keys.
+func (u *QuotaUsage) Keys() int64 {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.numKeys
+}
+
+// Bytes returns the total size of returned keys.
+func (u *QuotaUsage) Bytes() int64 {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.numBytes
+}
+
+// take counts the key, or returns ErrQuotaExceeded if it would exceed the limits of the quota.
+func (u *QuotaUsage) take(q *IterationQuota, key []byte) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if (q.MaxKeys > 0 && u.numKeys >= q.MaxKeys) || (q.MaxBytes > 0 && u.numBytes+int64(len(key)) > q.MaxBytes) {
+		return ErrQuotaExceeded
+	}
+	u.numKeys++
+	u.numBytes += int64(len(key))
+	return nil
+}
+
 type item struct {
-	key []byte
+	key   []byte
+	flags uint16
 }
 
 // ItemIterator is an iterator over DB key-value pairs. It iterates the items in an unspecified order.
+//
+// The DB may be written and compacted during iteration. Every key stored in the DB for the whole iteration
+// is returned exactly once, keys put after the iterator was created may or may not be returned.
+// Truncating the DB during iteration makes Next fail with ErrConcurrentModification.
 type ItemIterator struct {
 	db            *DB
+	generation    uint64 // Generation of the segment set when the iterator was created.
+	shardIdx      int
 	nextBucketIdx uint32
+	shardState    iteratorShardState
 	queue         []item
 	mu            sync.Mutex
+	quota         *IterationQuota // Nil if the iterator is unlimited.
+	deadline      time.Time
+	usage         *QuotaUsage // Usage of the quota, nil if the iterator is unlimited.
+}
+
+// iteratorShardState records the number of buckets of the current shard when the iterator visited its buckets.
+//
+// Index splits concurrent with the iteration move keys from a bucket to a bucket appended to the index,
+// a bucket created by splitting bucket a is a + 2^L, where 2^L is larger than a.
+// The keys of a bucket are returned unless they were already returned with an ancestor bucket,
+// which is known from the number of buckets at the time the ancestor was visited.
+type iteratorShardState struct {
+	visits []bucketVisit // Visits changing the number of buckets since the previous visit, in visiting order.
+}
+
+type bucketVisit struct {
+	bucketIdx  uint32
+	numBuckets uint32
+}
+
+// visit records the visit of the bucket and returns the filter of its slots, nil if all slots are returned.
+func (st *iteratorShardState) visit(bucketIdx uint32, numBuckets uint32) func(sl slot) bool {
+	if n := len(st.visits); n == 0 || st.visits[n-1].numBuckets != numBuckets {
+		st.visits = append(st.visits, bucketVisit{bucketIdx: bucketIdx, numBuckets: numBuckets})
+	}
+	if len(st.visits) == 1 {
+		// No splits since the iterator reached the shard.
+		return nil
+	}
+	return func(sl slot) bool {
+		for a := bucketIdx; a > 0; {
+			a -= 1 << (bits.Len32(a) - 1) // Parent bucket.
+			if bucketIndexFor(sl.hash, st.numBucketsAt(a)) == a {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// numBucketsAt returns the number of buckets when the bucket was visited.
+func (st *iteratorShardState) numBucketsAt(bucketIdx uint32) uint32 {
+	i := sort.Search(len(st.visits), func(i int) bool { return st.visits[i].bucketIdx > bucketIdx })
+	return st.visits[i-1].numBuckets
+}
+
+// bucketIndexFor maps the hash to a bucket of an index with numBuckets buckets.
+func bucketIndexFor(hash uint64, numBuckets uint32) uint32 {
+	level := uint8(bits.Len32(numBuckets) - 1)
+	idx := index{level: level, splitBucketIdx: numBuckets - 1<<level}
+	return idx.bucketIndex(hash)
 }
 
 // fetchItems adds items to the iterator queue from a bucket located at nextBucketIdx.
-func (it *ItemIterator) fetchItems(nextBucketIdx uint32) error {
-	bit := it.db.index.newBucketIterator(nextBucketIdx)
+// Slots rejected by keep are skipped, a nil keep accepts all slots.
+// The caller must hold the shard lock.
+func (it *ItemIterator) fetchItems(shard *indexShard, nextBucketIdx uint32, keep func(sl slot) bool) error {
+	it.db.datalog.mu.RLock()
+	defer it.db.datalog.mu.RUnlock()
+	bit := shard.newBucketIterator(nextBucketIdx)
 	for {
 		b, err := bit.next()
 		if err == ErrIterationDone {
@@ -37,37 +161,92 @@ func (it *ItemIterator) fetchItems(nextBucketIdx uint32) error {
 				// No more items in the bucket.
 				break
 			}
+			if keep != nil && !keep(sl) {
+				continue
+			}
 			key, err := it.db.datalog.readKey(sl)
 			if err != nil {
 				return err
 			}
-			key = cloneBytes(key)
-			it.queue = append(it.queue, item{key: key})
+			flags, err := it.db.datalog.readFlagsLocked(sl)
+			if err != nil {
+				return err
+			}
+			it.queue = append(it.queue, item{key: cloneBytes(key), flags: flags})
 		}
 	}
 }
 
+// fetchShardItems adds items from the next bucket of the current shard to the iterator queue.
+// It moves to the next shard when all buckets of the current shard are fetched.
+func (it *ItemIterator) fetchShardItems() error {
+	shard := it.db.index.shards[it.shardIdx]
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	if it.nextBucketIdx >= shard.numBuckets {
+		it.shardIdx++
+		it.nextBucketIdx = 0
+		it.shardState = iteratorShardState{}
+		return nil
+	}
+	bucketIdx := it.nextBucketIdx
+	keep := it.shardState.visit(bucketIdx, shard.numBuckets)
+	if err := it.fetchItems(shard, bucketIdx, keep); err != nil {
+		return err
+	}
+	it.nextBucketIdx++
+	return nil
+}
+
+// takeQuota counts the key returned next, or returns ErrQuotaExceeded if it would exceed the iterator quota.
+func (it *ItemIterator) takeQuota(key []byte) error {
+	if it.quota == nil {
+		return nil
+	}
+	if it.quota.MaxDuration > 0 && it.db.opts.Clock.Now().After(it.deadline) {
+		return ErrQuotaExceeded
+	}
+	return it.usage.take(it.quota, key)
+}
+
 // Next returns the next key-value pair if available, otherwise it returns ErrIterationDone error.
+// An iterator created by ItemsWithQuota returns ErrQuotaExceeded once the next key would exceed the quota.
 func (it *ItemIterator) Next() ([]byte, error) {
+	key, _, err := it.NextWithFlags()
+	return key, err
+}
+
+// NextWithFlags is like Next, it returns the flags of the key as well, see Options.RecordFlags.
+func (it *ItemIterator) NextWithFlags() ([]byte, uint16, error) {
 	it.mu.Lock()
 	defer it.mu.Unlock()
 
+	if it.quota != nil && it.quota.MaxDuration > 0 && it.db.opts.Clock.Now().After(it.deadline) {
+		return nil, 0, ErrQuotaExceeded
+	}
+
 	it.db.mu.RLock()
 	defer it.db.mu.RUnlock()
 
+	if atomic.LoadUint64(&it.db.datalog.truncatedGen) > it.generation {
+		return nil, 0, ErrConcurrentModification
+	}
+
 	// The iterator queue is empty and we have more buckets to check.
-	for len(it.queue) == 0 && it.nextBucketIdx < it.db.index.numBuckets {
-		if err := it.fetchItems(it.nextBucketIdx); err != nil {
-			return nil, err
+	for len(it.queue) == 0 && it.shardIdx < len(it.db.index.shards) {
+		if err := it.fetchShardItems(); err != nil {
+			return nil, 0, err
 		}
-		it.nextBucketIdx++
 	}
 
 	if len(it.queue) > 0 {
 		item := it.queue[0]
+		if err := it.takeQuota(item.key); err != nil {
+			return nil, 0, err
+		}
 		it.queue = it.queue[1:]
-		return item.key, nil
+		return item.key, item.flags, nil
 	}
 
-	return nil, ErrIterationDone
+	return nil, 0, ErrIterationDone
 }
@@ -1,7 +1,9 @@
 package pogreb
 
 import (
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/domaincrawler/pogreb/internal/assert"
 )
@@ -59,3 +61,111 @@ func TestIterator(t *testing.T) {
 
 	assert.Nil(t, db.Close())
 }
+
+func TestIteratorQuota(t *testing.T) {
+	db, err := createTestDB(nil)
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put([]byte{byte(i), 0}))
+	}
+
+	countKeys := func(it *ItemIterator) (int, error) {
+		n := 0
+		for {
+			_, err := it.Next()
+			if err != nil {
+				return n, err
+			}
+			n++
+		}
+	}
+
+	n, err := countKeys(db.ItemsWithQuota(IterationQuota{}))
+	assert.Equal(t, ErrIterationDone, err)
+	assert.Equal(t, 100, n)
+
+	it := db.ItemsWithQuota(IterationQuota{MaxKeys: 10})
+	n, err = countKeys(it)
+	assert.Equal(t, ErrQuotaExceeded, err)
+	assert.Equal(t, 10, n)
+	_, err = it.Next()
+	assert.Equal(t, ErrQuotaExceeded, err)
+
+	n, err = countKeys(db.ItemsWithQuota(IterationQuota{MaxBytes: 21}))
+	assert.Equal(t, ErrQuotaExceeded, err)
+	assert.Equal(t, 10, n)
+
+	it = db.ItemsWithQuota(IterationQuota{MaxKeys: 200, MaxDuration: time.Nanosecond})
+	time.Sleep(time.Millisecond)
+	n, err = countKeys(it)
+	assert.Equal(t, ErrQuotaExceeded, err)
+	assert.Equal(t, 0, n)
+	assert.Equal(t, CodeQuotaExceeded, ErrorCodeOf(err))
+
+	// Iterators sharing the usage are limited together.
+	usage := &QuotaUsage{}
+	n, err = countKeys(db.ItemsWithQuota(IterationQuota{MaxKeys: 150, Usage: usage}))
+	assert.Equal(t, ErrIterationDone, err)
+	assert.Equal(t, 100, n)
+	n, err = countKeys(db.ItemsWithQuota(IterationQuota{MaxKeys: 150, Usage: usage}))
+	assert.Equal(t, ErrQuotaExceeded, err)
+	assert.Equal(t, 50, n)
+	assert.Equal(t, int64(150), usage.Keys())
+	assert.Equal(t, int64(300), usage.Bytes())
+
+	assert.Nil(t, db.Close())
+}
+
+func TestIteratorConcurrentWrites(t *testing.T) {
+	db, err := createTestDB(nil)
+	assert.Nil(t, err)
+	const n = 2000
+	for i := 0; i < n; i++ {
+		assert.Nil(t, db.Put([]byte(fmt.Sprintf("key%d", i))))
+	}
+
+	// Writes between Next calls split the buckets already visited and the buckets not visited yet.
+	seen := map[string]int{}
+	it := db.Items()
+	next := n
+	for {
+		key, err := it.Next()
+		if err == ErrIterationDone {
+			break
+		}
+		assert.Nil(t, err)
+		seen[string(key)]++
+		for j := 0; j < 5 && next < 5*n; j++ {
+			assert.Nil(t, db.Put([]byte(fmt.Sprintf("key%d", next))))
+			next++
+		}
+	}
+	assert.Equal(t, true, db.index.shards[0].numBuckets > 2*n/slotsPerBucket)
+	for i := 0; i < n; i++ {
+		assert.Equal(t, 1, seen[fmt.Sprintf("key%d", i)])
+	}
+	for k, c := range seen {
+		if c != 1 {
+			t.Fatalf("key %s returned %d times", k, c)
+		}
+	}
+	assert.Nil(t, db.Close())
+}
+
+func TestIteratorTruncated(t *testing.T) {
+	db, err := createTestDB(nil)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte{1}))
+	it := db.Items()
+	assert.Nil(t, db.DrainAndTruncate(func(keys [][]byte) error { return nil }, 0))
+	_, err = it.Next()
+	assert.Equal(t, ErrConcurrentModification, err)
+
+	// Iterators created after the truncation aren't affected.
+	assert.Nil(t, db.Put([]byte{2}))
+	it = db.Items()
+	key, err := it.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{2}, key)
+	assert.Nil(t, db.Close())
+}
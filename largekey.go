@@ -0,0 +1,123 @@
+package pogreb
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"math"
+)
+
+// MaxLargeKeyLength is the maximum size of a key in bytes when Options.LargeKeys is enabled.
+const MaxLargeKeyLength = 1 << 24
+
+const (
+	// largeKeyMarker is stored in the key size field of large-key records and slots.
+	largeKeyMarker = math.MaxUint16
+
+	largeKeyDigestSize = sha256.Size
+
+	// Size of the large-key record fields preceding the key: marker, key size and digest.
+	largeKeyHeaderSize = 2 + 4 + largeKeyDigestSize
+
+	// deleteRecordFlag is set in the key size field of delete records.
+	deleteRecordFlag = 1 << 31
+)
+
+// Binary representation of a large-key record:
+// +-------------+---------------+---------------+------------------+----------+
+// | Marker (2B) | Key Size (4B) | Digest (32B)  | Key              | CRC (4B) |
+// +-------------+---------------+---------------+------------------+----------+
+// Keys at least largeKeyMarker bytes long are stored as large-key records.
+// Delete records of keys of any size use the same layout, with deleteRecordFlag set in the key size.
+// The index slot only holds the marker instead of the key size,
+// the size and the digest are compared before the full key is verified.
+
+func isLargeKey(key []byte) bool {
+	return len(key) >= largeKeyMarker
+}
+
+// slotKeySize returns the key size stored in the index slot.
+func slotKeySize(key []byte) uint16 {
+	if isLargeKey(key) {
+		return largeKeyMarker
+	}
+	return uint16(len(key))
+}
+
+func encodeLargeKeyRecord(key []byte, c Checksum) []byte {
+	return encodeLargeKeyLayout(key, 0, c)
+}
+
+// encodeDeleteRecord encodes the record removing the key.
+// Delete records can only be written to segments created with support for large keys.
+func encodeDeleteRecord(key []byte, c Checksum) []byte {
+	return encodeLargeKeyLayout(key, deleteRecordFlag, c)
+}
+
+func encodeLargeKeyLayout(key []byte, flags uint32, c Checksum) []byte {
+	size := largeKeyHeaderSize + len(key) + 4
+	data := make([]byte, size)
+	binary.LittleEndian.PutUint16(data[:2], largeKeyMarker)
+	binary.LittleEndian.PutUint32(data[2:6], uint32(len(key))|flags)
+	digest := sha256.Sum256(key)
+	copy(data[6:largeKeyHeaderSize], digest[:])
+	copy(data[largeKeyHeaderSize:], key)
+	checksum := c.sum(data[:size-4])
+	binary.LittleEndian.PutUint32(data[size-4:], checksum)
+	return data
+}
+
+// encodeRecord encodes the key as a regular or a large-key record.
+func encodeRecord(key []byte, c Checksum) []byte {
+	if isLargeKey(key) {
+		return encodeLargeKeyRecord(key, c)
+	}
+	return encodePutRecord(key, c)
+}
+
+// readLargeKey returns the key of the large-key record at the offset.
+// The caller must hold the datalog read lock.
+func (seg *segment) readLargeKey(offset uint64) ([]byte, error) {
+	off := int64(offset) + 2
+	sizeBuf, err := seg.slice(off, off+4)
+	if err != nil {
+		return nil, err
+	}
+	keySize := int64(binary.LittleEndian.Uint32(sizeBuf))
+	if seg.cipher != nil {
+		data, err := seg.slice(int64(offset), int64(offset)+largeKeyHeaderSize+keySize+encryptionTagSize)
+		if err != nil {
+			return nil, err
+		}
+		plain, err := seg.cipher.decrypt(data, offset, 2+4)
+		if err != nil {
+			return nil, err
+		}
+		return plain[largeKeyDigestSize:], nil
+	}
+	off = int64(offset) + largeKeyHeaderSize
+	return seg.slice(off, off+keySize)
+}
+
+// largeKeyEqual compares the key with the large-key record at the offset.
+// The key size and the digest are compared first, a full key comparison verifies the match.
+func (seg *segment) largeKeyEqual(offset uint64, key []byte) (bool, error) {
+	off := int64(offset) + 2
+	hdr, err := seg.slice(off, off+4+largeKeyDigestSize)
+	if err != nil {
+		return false, err
+	}
+	if binary.LittleEndian.Uint32(hdr[:4]) != uint32(len(key)) {
+		return false, nil
+	}
+	// The digest of an encrypted record is encrypted along with the key.
+	digest := sha256.Sum256(key)
+	if seg.cipher == nil && !bytes.Equal(hdr[4:], digest[:]) {
+		return false, nil
+	}
+	slKey, err := seg.readLargeKey(offset)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(key, slKey), nil
+}
@@ -0,0 +1,93 @@
+package pogreb
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/domaincrawler/pogreb/internal/assert"
+)
+
+func TestLargeKeys(t *testing.T) {
+	opts := &Options{FileSystem: testFS, LargeKeys: true}
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+
+	large := bytes.Repeat([]byte{'a'}, MaxKeyLength+100)
+	// Differs only in the last byte.
+	large2 := append(bytes.Repeat([]byte{'a'}, MaxKeyLength+99), 'b')
+	// Keys of the maximum regular key size are stored as large keys.
+	maxRegular := bytes.Repeat([]byte{'c'}, MaxKeyLength)
+	keys := [][]byte{large, large2, maxRegular, []byte("short")}
+	for _, k := range keys {
+		assert.Nil(t, db.Put(k))
+	}
+	assert.Equal(t, ErrKeyTooLarge, db.Put(make([]byte, MaxLargeKeyLength+1)))
+
+	check := func() {
+		t.Helper()
+		assert.Equal(t, uint64(len(keys)), db.Count())
+		for _, k := range keys {
+			has, err := db.Has(k)
+			assert.Nil(t, err)
+			assert.Equal(t, true, has)
+		}
+		has, err := db.Has(large[:MaxKeyLength+99])
+		assert.Nil(t, err)
+		assert.Equal(t, false, has)
+
+		found := map[string]bool{}
+		it := db.Items()
+		for {
+			key, err := it.Next()
+			if err == ErrIterationDone {
+				break
+			}
+			assert.Nil(t, err)
+			found[string(key)] = true
+		}
+		for _, k := range keys {
+			assert.Equal(t, true, found[string(k)])
+		}
+	}
+	check()
+
+	// Recovery rebuilds the index from large-key records.
+	simulateCrash(t, db)
+	db, err = Open(testDBName, opts)
+	assert.Nil(t, err)
+	check()
+	assert.Nil(t, db.Close())
+}
+
+func TestLargeKeysDisabled(t *testing.T) {
+	db, err := createTestDB(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, ErrKeyTooLarge, db.Put(make([]byte, MaxKeyLength+1)))
+	key := bytes.Repeat([]byte{1}, MaxKeyLength)
+	assert.Nil(t, db.Put(key))
+	has, err := db.Has(key)
+	assert.Nil(t, err)
+	assert.Equal(t, true, has)
+	assert.Nil(t, db.Close())
+}
+
+func TestLargeKeysLegacySegment(t *testing.T) {
+	db, err := createTestDB(nil)
+	assert.Nil(t, err)
+	// Segments created by older versions don't support large-key records.
+	assert.Nil(t, db.datalog.curSeg.setHeader(0, ChecksumIEEE))
+	assert.Nil(t, db.Put([]byte{1}))
+	key := bytes.Repeat([]byte{1}, MaxKeyLength)
+	assert.Nil(t, db.Put(key))
+	assert.Equal(t, 2, countSegments(t, db))
+
+	simulateCrash(t, db)
+	db, err = Open(testDBName, &Options{FileSystem: testFS})
+	assert.Nil(t, err)
+	for _, k := range [][]byte{{1}, key} {
+		has, err := db.Has(k)
+		assert.Nil(t, err)
+		assert.Equal(t, true, has)
+	}
+	assert.Nil(t, db.Close())
+}
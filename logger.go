@@ -8,8 +8,66 @@ import (
 var logger = log.New(os.Stderr, "pogreb: ", 0)
 
 // SetLogger sets the global logger.
+// It receives the messages of databases opened without Options.Logger.
 func SetLogger(l *log.Logger) {
 	if l != nil {
 		logger = l
 	}
 }
+
+// LogLevel is the severity of a log message.
+type LogLevel int
+
+const (
+	// LogInfo is the level of messages reporting the progress of recovery, compaction and other maintenance.
+	LogInfo LogLevel = iota
+
+	// LogWarn is the level of messages reporting conditions handled by the DB, e.g. corrupted data being discarded.
+	LogWarn
+
+	// LogError is the level of messages reporting failures of background tasks.
+	LogError
+)
+
+var logLevelNames = [...]string{
+	LogInfo:  "info",
+	LogWarn:  "warn",
+	LogError: "error",
+}
+
+func (l LogLevel) String() string {
+	if l < 0 || int(l) >= len(logLevelNames) {
+		return "unknown"
+	}
+	return logLevelNames[l]
+}
+
+// Logger receives log messages of a DB.
+type Logger interface {
+	// Logf logs a message of the level. Arguments are handled in the manner of fmt.Printf.
+	Logf(level LogLevel, format string, args ...interface{})
+}
+
+// StdLogger returns a Logger writing messages of all levels to the standard library logger.
+func StdLogger(l *log.Logger) Logger {
+	return stdLogger{l: l}
+}
+
+type stdLogger struct {
+	l *log.Logger // Nil for the global logger.
+}
+
+func (sl stdLogger) Logf(level LogLevel, format string, args ...interface{}) {
+	l := sl.l
+	if l == nil {
+		l = logger
+	}
+	l.Printf(format, args...)
+}
+
+// NopLogger is a Logger discarding all messages.
+var NopLogger Logger = nopLogger{}
+
+type nopLogger struct{}
+
+func (nopLogger) Logf(level LogLevel, format string, args ...interface{}) {}
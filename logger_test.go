@@ -0,0 +1,55 @@
+package pogreb
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"sync"
+	"testing"
+
+	"github.com/domaincrawler/pogreb/internal/assert"
+)
+
+// recordingLogger stores the messages logged to it.
+type recordingLogger struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func (l *recordingLogger) Logf(level LogLevel, format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.messages = append(l.messages, level.String()+": "+fmt.Sprintf(format, args...))
+}
+
+func TestLogger(t *testing.T) {
+	db, err := createTestDB(&Options{HashDomain: []byte("a")})
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte{1}))
+	assert.Nil(t, db.Close())
+
+	l := &recordingLogger{}
+	db, err = Open(testDBName, &Options{FileSystem: testFS, HashDomain: []byte("b"), Logger: l})
+	assert.Nil(t, err)
+	assert.Nil(t, db.Close())
+	assert.Equal(t, []string{`warn: hash domain "b" doesn't match the database hash domain, lookups will miss`}, l.messages)
+
+	// Messages of databases opened without a logger are written to the global logger.
+	buf := &bytes.Buffer{}
+	prevLogger := logger
+	SetLogger(log.New(buf, "", 0))
+	defer SetLogger(prevLogger)
+	db, err = Open(testDBName, &Options{FileSystem: testFS, HashDomain: []byte("b")})
+	assert.Nil(t, err)
+	assert.Nil(t, db.Close())
+	assert.Equal(t, "hash domain \"b\" doesn't match the database hash domain, lookups will miss\n", buf.String())
+
+	// NopLogger discards messages.
+	buf.Reset()
+	db, err = Open(testDBName, &Options{FileSystem: testFS, HashDomain: []byte("b"), Logger: NopLogger})
+	assert.Nil(t, err)
+	assert.Nil(t, db.Close())
+	assert.Equal(t, "", buf.String())
+
+	assert.Equal(t, "error", LogError.String())
+}
@@ -0,0 +1,143 @@
+package pogreb
+
+import (
+	"sync"
+
+	"github.com/domaincrawler/pogreb/internal/errors"
+)
+
+// logReadBatchSize is the size of the records a LogIterator reads from the datalog at once.
+const logReadBatchSize = 64 << 10
+
+// ErrLogDiscarded is returned by LogIterator.Next when records following the position were discarded:
+// compaction dropped delete records or keys removed by DeleteWhere, or the DB was truncated.
+// The reader has to resynchronize from the current keys, e.g. with Items, and continue from the tail.
+var ErrLogDiscarded = errors.New("records following the log position were discarded")
+
+var errInvalidLogPosition = errors.New("log position is past the end of its segment")
+
+// LogRecord is a record of the datalog.
+type LogRecord struct {
+	SequenceID uint64 // Sequence ID of the segment holding the record.
+	Offset     uint64 // Offset of the record in the segment.
+	Delete     bool   // The record deletes the key, otherwise it puts the key.
+	Key        []byte // Key as it's stored, see Options.StoreFingerprintsOnly.
+	Flags      uint16 // Flags the record was written with, see Options.RecordFlags.
+}
+
+// LogIterator reads the records of the datalog in the order they were written, see DB.ReadFrom.
+// It's safe for concurrent use.
+type LogIterator struct {
+	db         *DB
+	mu         sync.Mutex
+	sequenceID uint64
+	offset     uint64
+	queue      []LogRecord
+}
+
+// ReadFrom returns an iterator over the datalog records from the position: the sequence ID of a segment
+// and the offset of a record in it, as returned by LogRecord or LogIterator.Position, e.g. for change data capture.
+// ReadFrom(0, 0) starts at the oldest record.
+//
+// Compaction removes segments while they are read: the put records of the live keys are written again
+// at the tail, the other records of a removed segment not read yet are skipped, Next then returns
+// ErrLogDiscarded if they included deletes. Keys removed by DeleteWhere make Next return ErrLogDiscarded
+// for all positions before the removal. With the SyncAlways and SyncInterval policies records are
+// returned once they are synced. Flag updates made in place by PutWithFlags and CompareAndSetFlags
+// aren't records, they aren't returned.
+func (db *DB) ReadFrom(sequenceID uint64, offset uint64) *LogIterator {
+	return &LogIterator{db: db, sequenceID: sequenceID, offset: offset}
+}
+
+// LogPosition returns the position following the last record of the datalog which ReadFrom returns.
+// A reader resynchronizing after ErrLogDiscarded takes the position before reading the keys.
+func (db *DB) LogPosition() (uint64, uint64) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	db.datalog.mu.RLock()
+	defer db.datalog.mu.RUnlock()
+	return db.datalog.curSeg.sequenceID, db.datalog.replicableSize(db.datalog.curSeg)
+}
+
+// Next returns the next record. It returns ErrIterationDone once the iterator reached the tail of the datalog,
+// a later call returns the records written since.
+func (it *LogIterator) Next() (LogRecord, error) {
+	it.mu.Lock()
+	defer it.mu.Unlock()
+	if len(it.queue) == 0 {
+		if err := it.fetch(); err != nil {
+			return LogRecord{}, err
+		}
+		if len(it.queue) == 0 {
+			return LogRecord{}, ErrIterationDone
+		}
+	}
+	rec := it.queue[0]
+	it.queue = it.queue[1:]
+	return rec, nil
+}
+
+// Position returns the position of the next record, ReadFrom resumes the iteration from it.
+func (it *LogIterator) Position() (uint64, uint64) {
+	it.mu.Lock()
+	defer it.mu.Unlock()
+	if len(it.queue) > 0 {
+		return it.queue[0].SequenceID, it.queue[0].Offset
+	}
+	return it.sequenceID, it.offset
+}
+
+// fetch reads the records following the position into the queue, up to logReadBatchSize bytes.
+func (it *LogIterator) fetch() error {
+	db := it.db
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	r := db.replication
+	r.mu.Lock()
+	discarded := r.meta.behindHorizon(it.sequenceID, it.offset)
+	r.mu.Unlock()
+	if discarded {
+		return ErrLogDiscarded
+	}
+	segments := db.datalog.segmentsBySequenceID()
+	db.datalog.mu.RLock()
+	sizes := make([]uint64, len(segments))
+	for i, seg := range segments {
+		sizes[i] = db.datalog.replicableSize(seg)
+	}
+	db.datalog.mu.RUnlock()
+
+	read := uint64(0)
+	for i, seg := range segments {
+		if seg.sequenceID < it.sequenceID {
+			continue
+		}
+		if seg.sequenceID > it.sequenceID || it.offset < headerSize {
+			it.sequenceID, it.offset = seg.sequenceID, headerSize
+		}
+		if it.offset > sizes[i] {
+			return errInvalidLogPosition
+		}
+		iter := db.datalog.newSegmentRangeIterator(seg, it.offset, sizes[i])
+		for iter.offset < sizes[i] && read < logReadBatchSize {
+			offset := iter.offset
+			rec, err := iter.next()
+			if err != nil {
+				return errors.Wrapf(err, "reading segment %s", seg.name)
+			}
+			read += iter.offset - offset
+			it.queue = append(it.queue, LogRecord{
+				SequenceID: seg.sequenceID,
+				Offset:     offset,
+				Delete:     rec.rtype == recordTypeDelete,
+				Key:        rec.key,
+				Flags:      rec.flags,
+			})
+		}
+		it.offset = iter.offset
+		if read >= logReadBatchSize {
+			break
+		}
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package pogreb
+
+import (
+	"testing"
+
+	"github.com/domaincrawler/pogreb/internal/assert"
+)
+
+func readLog(t *testing.T, it *LogIterator) []LogRecord {
+	t.Helper()
+	var recs []LogRecord
+	for {
+		rec, err := it.Next()
+		if err == ErrIterationDone {
+			return recs
+		}
+		assert.Nil(t, err)
+		recs = append(recs, rec)
+	}
+}
+
+func TestReadFrom(t *testing.T) {
+	db, err := createTestDB(&Options{maxSegmentSize: 1024})
+	assert.Nil(t, err)
+	defer db.Close()
+	for i := 0; i < 300; i++ {
+		if i == 100 {
+			assert.Nil(t, db.Delete(deleteTestKey(5)))
+		}
+		assert.Nil(t, db.Put(deleteTestKey(i)))
+	}
+
+	it := db.ReadFrom(0, 0)
+	recs := readLog(t, it)
+	assert.Equal(t, 301, len(recs))
+	del := recs[100]
+	assert.Equal(t, LogRecord{SequenceID: del.SequenceID, Offset: del.Offset, Delete: true, Key: deleteTestKey(5)}, del)
+	recs = append(recs[:100], recs[101:]...)
+	for i, rec := range recs {
+		assert.Equal(t, deleteTestKey(i), rec.Key)
+		assert.Equal(t, false, rec.Delete)
+	}
+	assert.Equal(t, true, recs[299].SequenceID > del.SequenceID)
+
+	// The iterator continues with the records written since, a new iterator resumes from the position.
+	assert.Nil(t, db.Put(deleteTestKey(1000)))
+	seq, off := it.Position()
+	recs = readLog(t, it)
+	assert.Equal(t, 1, len(recs))
+	assert.Equal(t, LogRecord{SequenceID: seq, Offset: off, Key: deleteTestKey(1000)}, recs[0])
+	recs = readLog(t, db.ReadFrom(seq, off))
+	assert.Equal(t, 1, len(recs))
+	assert.Equal(t, deleteTestKey(1000), recs[0].Key)
+
+	_, err = db.ReadFrom(seq, off+1<<20).Next()
+	assert.Equal(t, errInvalidLogPosition, err)
+
+	// Rewriting segments without discarding records keeps the readers going, the live keys are read again.
+	_, err = db.DeleteWhere(func(key []byte) bool { return false })
+	assert.Nil(t, err)
+	assert.Equal(t, 300, len(readLog(t, it)))
+
+	// Readers behind discarded records have to resynchronize.
+	_, err = db.DeleteWhere(func(key []byte) bool { return key[3] == 1 })
+	assert.Nil(t, err)
+	_, err = it.Next()
+	assert.Equal(t, ErrLogDiscarded, err)
+	tail := db.ReadFrom(db.LogPosition())
+	assert.Nil(t, db.Put(deleteTestKey(2000)))
+	recs = readLog(t, tail)
+	assert.Equal(t, 1, len(recs))
+	assert.Equal(t, deleteTestKey(2000), recs[0].Key)
+}
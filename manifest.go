@@ -0,0 +1,99 @@
+package pogreb
+
+import (
+	"os"
+
+	"github.com/domaincrawler/pogreb/internal/errors"
+)
+
+const manifestName = "manifest" + metaExt
+
+// manifestMeta identifies the state of the index published by a writer for read-only replicas.
+// The index and DB meta files are published before the manifest.
+type manifestMeta struct {
+	Epoch   uint64 // Epoch of the writer.
+	Version uint64 // Incremented by every publication of the writer.
+}
+
+// writeFileAtomic replaces the gob file, readers see either the old or the new contents.
+func (db *DB) writeFileAtomic(name string, v interface{}) error {
+	tmp := name + ".tmp"
+	if err := writeSyncedFile(db.opts.FileSystem, tmp, writeGob(v)); err != nil {
+		return err
+	}
+	return db.opts.FileSystem.Rename(tmp, name)
+}
+
+// publishManifest writes the index meta of every shard and a new manifest, making the keys written
+// so far visible to replicas opened with Options.ManifestPollInterval.
+// The caller must hold the DB read lock.
+func (db *DB) publishManifest() error {
+	if !db.opts.PublishManifest || db.opts.ReadOnly {
+		return nil
+	}
+	db.manifestMu.Lock()
+	defer db.manifestMu.Unlock()
+	for i, sh := range db.index.shards {
+		// The records referenced by the published index must be readable by other processes.
+		sh.mu.Lock()
+		err := sh.flush()
+		m := sh.meta()
+		sh.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		_, _, metaName := indexFileNames(i)
+		if err := db.writeFileAtomic(metaName, m); err != nil {
+			return errors.Wrap(err, "publishing index meta")
+		}
+	}
+	if err := db.writeFileAtomic(dbMetaName, db.meta()); err != nil {
+		return errors.Wrap(err, "publishing db meta")
+	}
+	db.manifest.Epoch = db.epoch
+	db.manifest.Version++
+	return db.writeFileAtomic(manifestName, db.manifest)
+}
+
+// readManifest returns the last manifest published by the writer, or an empty manifest if there is none.
+func readManifest(opts *Options) (manifestMeta, error) {
+	m := manifestMeta{}
+	if !opts.ReadOnly || opts.ManifestPollInterval <= 0 {
+		return m, nil
+	}
+	if err := readGobFile(opts.FileSystem, manifestName, &m); err != nil && !os.IsNotExist(err) {
+		return m, errors.Wrap(err, "reading manifest")
+	}
+	return m, nil
+}
+
+// refresh reopens the index and the datalog of a replica if the writer published a new manifest.
+func (db *DB) refresh() error {
+	m, err := readManifest(db.opts)
+	if err != nil {
+		return err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if m == db.manifest {
+		return nil
+	}
+	index, err := openShardedIndex(db.opts)
+	if err != nil {
+		return errors.Wrap(err, "reopening index")
+	}
+	datalog, err := openDatalog(db.opts, db.metrics, db.events)
+	if err != nil {
+		index.closeFiles()
+		return errors.Wrap(err, "reopening datalog")
+	}
+	db.index.closeFiles()
+	db.datalog.closeFiles()
+	db.index = index
+	db.datalog = datalog
+	db.manifest = m
+	db.invalidation.invalidateAll()
+	db.secondary.invalidate()
+	db.countWatches.update(index.count())
+	return nil
+}
@@ -0,0 +1,51 @@
+package pogreb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/domaincrawler/pogreb/fs"
+	"github.com/domaincrawler/pogreb/internal/assert"
+	"github.com/domaincrawler/pogreb/internal/errors"
+)
+
+func TestManifestReplica(t *testing.T) {
+	if testFS == fs.Mem {
+		t.Skip("memory files can't be opened by two databases")
+	}
+	db, err := createTestDB(&Options{PublishManifest: true})
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(deleteTestKey(i)))
+	}
+	assert.Nil(t, db.Sync())
+
+	// Without polling the database in use can't be opened.
+	_, err = Open(testDBName, &Options{FileSystem: testFS, ReadOnly: true})
+	assert.Equal(t, true, errors.Is(err, ErrLocked))
+
+	replica, err := Open(testDBName, &Options{FileSystem: testFS, ReadOnly: true, ManifestPollInterval: time.Hour})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(100), replica.Count())
+	assertHas(t, replica, deleteTestKey(99), true)
+
+	for i := 100; i < 1000; i++ {
+		assert.Nil(t, db.Put(deleteTestKey(i)))
+	}
+	// Keys aren't visible before they are published.
+	assert.Nil(t, replica.refresh())
+	assert.Equal(t, uint64(100), replica.Count())
+
+	assert.Nil(t, db.Sync())
+	assert.Nil(t, replica.refresh())
+	assert.Equal(t, uint64(1000), replica.Count())
+	for i := 0; i < 1000; i++ {
+		assertHas(t, replica, deleteTestKey(i), true)
+	}
+
+	assert.Nil(t, db.Put(deleteTestKey(1000)))
+	assert.Nil(t, db.Close())
+	assert.Nil(t, replica.refresh())
+	assertHas(t, replica, deleteTestKey(1000), true)
+	assert.Nil(t, replica.Close())
+}
@@ -0,0 +1,112 @@
+package pogreb
+
+import (
+	"github.com/domaincrawler/pogreb/internal/errors"
+)
+
+var errMergeSelf = errors.New("can't merge a database into itself")
+
+// MergeResult describes the keys merged by MergeFrom and Merge.
+type MergeResult struct {
+	Keys       int64 // Number of keys read from the source databases.
+	Inserted   int64 // Number of keys which weren't in the destination.
+	Duplicates int64 // Number of keys skipped because they were already in the destination.
+	Invalid    int64 // Number of keys skipped because they are too large or blocked by the write chain.
+}
+
+func (r *MergeResult) add(progress ImportProgress) {
+	r.Keys += progress.Records + progress.Invalid
+	r.Inserted += progress.Inserted
+	r.Duplicates += progress.Duplicates
+	r.Invalid += progress.Invalid
+}
+
+// MergeFrom inserts the keys of the other database which aren't in the DB.
+// Keys are written in batches like Import: each batch is committed once and the DB is synced at the end.
+// The other database is read with an iterator, it can be used concurrently and can be read-only.
+// The fingerprints stored by a database with Options.StoreFingerprintsOnly are copied as is,
+// bypassing the write chain, into a DB storing fingerprints of the same size.
+func (db *DB) MergeFrom(other *DB) (MergeResult, error) {
+	res := MergeResult{}
+	if other == db {
+		return res, errMergeSelf
+	}
+	if err := db.checkWritable(); err != nil {
+		return res, err
+	}
+	if other.fingerprintSize != 0 && other.fingerprintSize != db.fingerprintSize {
+		return res, errFingerprintMismatch
+	}
+	progress := ImportProgress{}
+	batch := make([][]byte, 0, importBatchSize)
+	it := other.Items()
+	for {
+		key, err := it.Next()
+		if err == ErrIterationDone {
+			break
+		}
+		if err != nil {
+			res.add(progress)
+			return res, errors.Wrap(err, "reading source")
+		}
+		if other.fingerprintSize == 0 {
+			key, err = db.checkKey(key)
+		}
+		if err != nil {
+			if err != ErrKeyTooLarge && !errors.Is(err, ErrBlocked) {
+				res.add(progress)
+				return res, err
+			}
+			progress.Invalid++
+			continue
+		}
+		batch = append(batch, key)
+		if len(batch) == importBatchSize {
+			if err := db.importBatch(batch, &progress); err != nil {
+				res.add(progress)
+				return res, err
+			}
+			batch = batch[:0]
+		}
+	}
+	err := db.importBatch(batch, &progress)
+	res.add(progress)
+	if err != nil {
+		return res, err
+	}
+	return res, db.Sync()
+}
+
+// Merge unions the keys of the source databases into the destination database, which is created if it doesn't exist.
+// The destination is opened with opts, the sources are opened read-only on the same file system.
+// Databases are hashed with different seeds, the keys of the sources are rehashed and written to new segments.
+func Merge(dstPath string, opts *Options, srcPaths ...string) (MergeResult, error) {
+	res := MergeResult{}
+	dst, err := Open(dstPath, opts)
+	if err != nil {
+		return res, errors.Wrapf(err, "opening %s", dstPath)
+	}
+	srcOpts := &Options{ReadOnly: true, LargeKeys: true}
+	if opts != nil {
+		srcOpts.FileSystem = opts.FileSystem
+		srcOpts.Logger = opts.Logger
+	}
+	for _, path := range srcPaths {
+		src, err := Open(path, srcOpts)
+		if err != nil {
+			_ = dst.Close()
+			return res, errors.Wrapf(err, "opening %s", path)
+		}
+		r, err := dst.MergeFrom(src)
+		res.Keys += r.Keys
+		res.Inserted += r.Inserted
+		res.Duplicates += r.Duplicates
+		res.Invalid += r.Invalid
+		_ = src.Close()
+		if err != nil {
+			_ = dst.Close()
+			return res, errors.Wrapf(err, "merging %s", path)
+		}
+	}
+	return res, dst.Close()
+}
@@ -0,0 +1,80 @@
+package pogreb
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/domaincrawler/pogreb/internal/assert"
+)
+
+// createMergeSource creates a database at the path holding the keys with indexes in [from, to).
+func createMergeSource(t *testing.T, path string, from int, to int) {
+	removeMergeSource(t, path)
+	db, err := Open(path, &Options{FileSystem: testFS})
+	assert.Nil(t, err)
+	for i := from; i < to; i++ {
+		assert.Nil(t, db.Put(deleteTestKey(i)))
+	}
+	assert.Nil(t, db.Close())
+}
+
+func removeMergeSource(t *testing.T, path string) {
+	files, err := testFS.ReadDir(path)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		assert.Nil(t, testFS.Remove(filepath.Join(path, file.Name())))
+	}
+	_ = testFS.Remove(path)
+}
+
+func TestMerge(t *testing.T) {
+	db, err := createTestDB(nil)
+	assert.Nil(t, err)
+	for i := 0; i < 50; i++ {
+		assert.Nil(t, db.Put(deleteTestKey(i)))
+	}
+	assert.Nil(t, db.Close())
+
+	src1, src2 := testDBName+".src1", testDBName+".src2"
+	createMergeSource(t, src1, 25, 100)
+	defer removeMergeSource(t, src1)
+	createMergeSource(t, src2, 90, 200)
+	defer removeMergeSource(t, src2)
+
+	res, err := Merge(testDBName, &Options{FileSystem: testFS}, src1, src2)
+	assert.Nil(t, err)
+	assert.Equal(t, MergeResult{Keys: 185, Inserted: 150, Duplicates: 35}, res)
+
+	db, err = Open(testDBName, &Options{FileSystem: testFS})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(200), db.Count())
+	for i := 0; i < 200; i++ {
+		assertHas(t, db, deleteTestKey(i), true)
+	}
+	assert.Nil(t, db.Close())
+}
+
+func TestMergeFrom(t *testing.T) {
+	src := testDBName + ".src"
+	createMergeSource(t, src, 0, 10)
+	defer removeMergeSource(t, src)
+	other, err := Open(src, &Options{FileSystem: testFS, LargeKeys: true})
+	assert.Nil(t, err)
+	assert.Nil(t, other.Put(make([]byte, MaxKeyLength+1)))
+
+	db, err := createTestDB(&Options{Blocklist: testBlocklist{string(deleteTestKey(3)): true}})
+	assert.Nil(t, err)
+	res, err := db.MergeFrom(other)
+	assert.Nil(t, err)
+	assert.Equal(t, MergeResult{Keys: 11, Inserted: 9, Invalid: 2}, res)
+	assertHas(t, db, deleteTestKey(3), false)
+	assert.Equal(t, uint64(9), db.Count())
+
+	_, err = db.MergeFrom(db)
+	assert.Equal(t, errMergeSelf, err)
+	assert.Nil(t, db.Close())
+	assert.Nil(t, other.Close())
+}
@@ -1,8 +1,208 @@
 package pogreb
 
-import "expvar"
+import (
+	"expvar"
+	"fmt"
+	"io"
+)
 
 // Metrics holds the DB metrics.
 type Metrics struct {
-	Puts expvar.Int
+	Puts                    expvar.Int
+	Gets                    expvar.Int   // Number of key lookups, including the lookups made by HasOrPut.
+	Hits                    expvar.Int   // Number of lookups that found the key.
+	Misses                  expvar.Int   // Number of lookups that didn't find the key.
+	Dels                    expvar.Int   // Number of keys removed by Delete, DeleteFunc, DrainAndTruncate and by skipping corrupted records.
+	HashCollisions          expvar.Int   // Number of index slots with the hash of a looked up key, but a different key.
+	FilterNegatives         expvar.Int   // Number of lookups of missing keys answered by Options.MembershipFilter without reading the index.
+	CompactionSeconds       expvar.Float // Total time spent compacting segments.
+	SegmentsCompacted       expvar.Int   // Number of segments compacted.
+	BytesReclaimed          expvar.Int   // Number of bytes reclaimed by compaction.
+	FsyncCount              expvar.Int   // Number of datalog segment syncs.
+	RecoveryDuration        expvar.Float // Seconds spent repairing segments and rebuilding the index when the DB was opened.
+	CanaryChecks            expvar.Int   // Number of canary self-tests.
+	CanaryFailures          expvar.Int   // Number of failed canary self-tests.
+	CorruptedRecordsSkipped expvar.Int   // Number of corrupted records discarded by compaction.
+	Segments                expvar.Int   // Number of datalog segments.
+	FreeSegmentIDs          expvar.Int   // Number of segments that can be created before writes fail with ErrFull.
+	SegmentRotations        expvar.Int   // Number of times the current segment was replaced by a new segment.
+	RotatedSegmentBytes     expvar.Int   // Total size of the replaced segments, divided by SegmentRotations it is the average size.
+
+	// Replication counters, see DB.Replicate and DB.Follow. ReplicationState returns the state of the streams.
+	ReplicationFollowers      expvar.Int // Number of streams served to followers.
+	ReplicationBytesSent      expvar.Int // Number of bytes written to followers.
+	ReplicationRecordsApplied expvar.Int // Number of records received from a primary and applied.
+	ReplicationLagBytes       expvar.Int // Size of the records of the primary not yet applied by the follower.
+	ReplicationResyncs        expvar.Int // Number of snapshots the follower applied to resynchronize with a primary.
+
+	// Latency histograms of Put, Has and Compact calls, nil unless Options.DetailedMetrics is set.
+	PutLatency     *Histogram
+	HasLatency     *Histogram
+	CompactLatency *Histogram
+}
+
+func newMetrics(opts *Options) *Metrics {
+	m := &Metrics{}
+	if opts.DetailedMetrics {
+		m.PutLatency = newHistogram(opts.LatencyBuckets)
+		m.HasLatency = newHistogram(opts.LatencyBuckets)
+		m.CompactLatency = newHistogram(opts.LatencyBuckets)
+	}
+	return m
+}
+
+// metricHistogram describes a histogram exposed by MetricsCollector.
+type metricHistogram struct {
+	field string
+	name  string
+	help  string
+	h     *Histogram
+}
+
+func (m *Metrics) histograms() []metricHistogram {
+	var hs []metricHistogram
+	add := func(field, name, help string, h *Histogram) {
+		if h != nil {
+			hs = append(hs, metricHistogram{field: field, name: name, help: help, h: h})
+		}
+	}
+	add("PutLatency", "pogreb_put_latency_seconds", "Latency of Put calls.", m.PutLatency)
+	add("HasLatency", "pogreb_has_latency_seconds", "Latency of Has calls.", m.HasLatency)
+	add("CompactLatency", "pogreb_compact_latency_seconds", "Latency of Compact calls.", m.CompactLatency)
+	return hs
+}
+
+// metricVar describes a metric exposed by MetricsCollector.
+type metricVar struct {
+	field string // Name of the Metrics field, used as the expvar key.
+	name  string // Prometheus metric name.
+	help  string
+	v     expvar.Var
+	value func() float64
+}
+
+func (m *Metrics) vars() []metricVar {
+	intVar := func(field, name, help string, v *expvar.Int) metricVar {
+		return metricVar{field: field, name: name, help: help, v: v, value: func() float64 { return float64(v.Value()) }}
+	}
+	floatVar := func(field, name, help string, v *expvar.Float) metricVar {
+		return metricVar{field: field, name: name, help: help, v: v, value: v.Value}
+	}
+	return []metricVar{
+		intVar("Puts", "pogreb_puts", "Number of keys written.", &m.Puts),
+		intVar("Gets", "pogreb_gets", "Number of key lookups.", &m.Gets),
+		intVar("Hits", "pogreb_hits", "Number of lookups that found the key.", &m.Hits),
+		intVar("Misses", "pogreb_misses", "Number of lookups that didn't find the key.", &m.Misses),
+		intVar("Dels", "pogreb_dels", "Number of keys removed.", &m.Dels),
+		intVar("HashCollisions", "pogreb_hash_collisions", "Number of index slots with a matching hash but a different key.", &m.HashCollisions),
+		intVar("FilterNegatives", "pogreb_filter_negatives", "Number of lookups answered by the membership filter.", &m.FilterNegatives),
+		floatVar("CompactionSeconds", "pogreb_compaction_seconds", "Total time spent compacting segments.", &m.CompactionSeconds),
+		intVar("SegmentsCompacted", "pogreb_segments_compacted", "Number of segments compacted.", &m.SegmentsCompacted),
+		intVar("BytesReclaimed", "pogreb_bytes_reclaimed", "Number of bytes reclaimed by compaction.", &m.BytesReclaimed),
+		intVar("FsyncCount", "pogreb_fsyncs", "Number of datalog segment syncs.", &m.FsyncCount),
+		floatVar("RecoveryDuration", "pogreb_recovery_duration_seconds", "Time spent recovering the database when it was opened.", &m.RecoveryDuration),
+		intVar("CanaryChecks", "pogreb_canary_checks", "Number of canary self-tests.", &m.CanaryChecks),
+		intVar("CanaryFailures", "pogreb_canary_failures", "Number of failed canary self-tests.", &m.CanaryFailures),
+		intVar("CorruptedRecordsSkipped", "pogreb_corrupted_records_skipped", "Number of corrupted records discarded by compaction.", &m.CorruptedRecordsSkipped),
+		intVar("Segments", "pogreb_segments", "Number of datalog segments.", &m.Segments),
+		intVar("FreeSegmentIDs", "pogreb_free_segment_ids", "Number of segments that can be created before writes fail.", &m.FreeSegmentIDs),
+		intVar("SegmentRotations", "pogreb_segment_rotations", "Number of times the current segment was replaced by a new segment.", &m.SegmentRotations),
+		intVar("RotatedSegmentBytes", "pogreb_rotated_segment_bytes", "Total size of the replaced segments.", &m.RotatedSegmentBytes),
+		intVar("ReplicationFollowers", "pogreb_replication_followers", "Number of streams served to followers.", &m.ReplicationFollowers),
+		intVar("ReplicationBytesSent", "pogreb_replication_bytes_sent", "Number of bytes written to followers.", &m.ReplicationBytesSent),
+		intVar("ReplicationRecordsApplied", "pogreb_replication_records_applied", "Number of records received from a primary and applied.", &m.ReplicationRecordsApplied),
+		intVar("ReplicationLagBytes", "pogreb_replication_lag_bytes", "Size of the records of the primary not yet applied.", &m.ReplicationLagBytes),
+		intVar("ReplicationResyncs", "pogreb_replication_resyncs", "Number of snapshots applied to resynchronize with a primary.", &m.ReplicationResyncs),
+	}
+}
+
+// Collector returns a MetricsCollector exposing the metrics.
+func (m *Metrics) Collector() *MetricsCollector {
+	return &MetricsCollector{vars: m.vars(), histograms: m.histograms()}
+}
+
+// MetricSample is the current value of a metric, exposed as a Prometheus gauge.
+type MetricSample struct {
+	Name  string // Prometheus metric name, e.g. pogreb_puts.
+	Help  string
+	Value float64
+}
+
+// MetricsCollector exposes Metrics to expvar and Prometheus.
+//
+// pogreb doesn't depend on the Prometheus client, a prometheus.Collector is a thin wrapper around Samples:
+//
+//	func (c *collector) Describe(ch chan<- *prometheus.Desc) { prometheus.DescribeByCollect(c, ch) }
+//
+//	func (c *collector) Collect(ch chan<- prometheus.Metric) {
+//		for _, s := range c.mc.Samples() {
+//			desc := prometheus.NewDesc(s.Name, s.Help, nil, nil)
+//			ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, s.Value)
+//		}
+//	}
+//
+// The latency histograms enabled by Options.DetailedMetrics are returned by Histograms.
+type MetricsCollector struct {
+	vars       []metricVar
+	histograms []metricHistogram
+}
+
+// Publish registers the metrics with expvar as a map with the given name, keyed by the Metrics field names.
+// As expvar.Publish, it panics if the name is already registered.
+func (c *MetricsCollector) Publish(name string) {
+	m := new(expvar.Map)
+	for _, v := range c.vars {
+		m.Set(v.field, v.v)
+	}
+	for _, h := range c.histograms {
+		m.Set(h.field, h.h)
+	}
+	expvar.Publish(name, m)
+}
+
+// Samples returns the current values of the metrics.
+func (c *MetricsCollector) Samples() []MetricSample {
+	samples := make([]MetricSample, len(c.vars))
+	for i, v := range c.vars {
+		samples[i] = MetricSample{Name: v.name, Help: v.help, Value: v.value()}
+	}
+	return samples
+}
+
+// Histograms returns the current values of the latency histograms, keyed by the Prometheus metric name,
+// e.g. pogreb_put_latency_seconds.
+func (c *MetricsCollector) Histograms() map[string]HistogramSnapshot {
+	snapshots := make(map[string]HistogramSnapshot, len(c.histograms))
+	for _, h := range c.histograms {
+		snapshots[h.name] = h.h.Snapshot()
+	}
+	return snapshots
+}
+
+// WritePrometheus writes the metrics to w as gauges and the latency histograms as histograms
+// in the Prometheus text exposition format.
+func (c *MetricsCollector) WritePrometheus(w io.Writer) error {
+	for _, s := range c.Samples() {
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %g\n", s.Name, s.Help, s.Name, s.Name, s.Value); err != nil {
+			return err
+		}
+	}
+	for _, h := range c.histograms {
+		s := h.h.Snapshot()
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name); err != nil {
+			return err
+		}
+		var n uint64
+		for i, bound := range s.Bounds {
+			n += s.Counts[i]
+			if _, err := fmt.Fprintf(w, "%s_bucket{le=\"%g\"} %d\n", h.name, bound.Seconds(), n); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n%s_sum %g\n%s_count %d\n",
+			h.name, s.Count, h.name, s.Sum.Seconds(), h.name, s.Count); err != nil {
+			return err
+		}
+	}
+	return nil
 }
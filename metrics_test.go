@@ -0,0 +1,112 @@
+package pogreb
+
+import (
+	"bytes"
+	"expvar"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/domaincrawler/pogreb/internal/assert"
+)
+
+func TestMetrics(t *testing.T) {
+	opts := &Options{
+		maxSegmentSize:             1024,
+		compactionMinSegmentSize:   520,
+		compactionMinFragmentation: -1, // Compact every segment.
+	}
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+
+	for i := 0; i < 2; i++ {
+		for k := 0; k < 40; k++ {
+			assert.Nil(t, db.Put([]byte{byte(k)}))
+		}
+	}
+	for k := 0; k < 10; k++ {
+		has, err := db.Has([]byte{byte(k)})
+		assert.Nil(t, err)
+		assert.Equal(t, true, has)
+	}
+	has, err := db.Has([]byte{255})
+	assert.Nil(t, err)
+	assert.Equal(t, false, has)
+	assert.Nil(t, db.Sync())
+
+	m := db.Metrics()
+	assert.Equal(t, int64(80), m.Puts.Value())
+	assert.Equal(t, int64(11), m.Gets.Value())
+	assert.Equal(t, int64(10), m.Hits.Value())
+	assert.Equal(t, int64(1), m.Misses.Value())
+	assert.Equal(t, true, m.FsyncCount.Value() > 0)
+
+	cr, err := db.Compact()
+	assert.Nil(t, err)
+	assert.Equal(t, true, cr.CompactedSegments > 0)
+	assert.Equal(t, int64(cr.CompactedSegments), m.SegmentsCompacted.Value())
+	assert.Equal(t, int64(cr.ReclaimedBytes), m.BytesReclaimed.Value())
+	assert.Equal(t, true, m.CompactionSeconds.Value() > 0)
+
+	count := db.Count()
+	assert.Nil(t, db.DrainAndTruncate(func(keys [][]byte) error { return nil }, 0))
+	assert.Equal(t, int64(count), m.Dels.Value())
+
+	assert.Nil(t, db.Close())
+}
+
+func TestMetricsCollector(t *testing.T) {
+	m := &Metrics{}
+	m.Puts.Add(3)
+	m.CompactionSeconds.Add(1.5)
+	c := m.Collector()
+
+	values := map[string]float64{}
+	for _, s := range c.Samples() {
+		assert.Equal(t, true, s.Help != "")
+		values[s.Name] = s.Value
+	}
+	assert.Equal(t, float64(3), values["pogreb_puts"])
+	assert.Equal(t, 1.5, values["pogreb_compaction_seconds"])
+	assert.Equal(t, float64(0), values["pogreb_gets"])
+
+	buf := &bytes.Buffer{}
+	assert.Nil(t, c.WritePrometheus(buf))
+	assert.Equal(t, true, strings.Contains(buf.String(), "# TYPE pogreb_puts gauge\npogreb_puts 3\n"))
+
+	name := fmt.Sprintf("pogreb_test_%p", m)
+	c.Publish(name)
+	published := expvar.Get(name).(*expvar.Map)
+	assert.Equal(t, "3", published.Get("Puts").String())
+	m.Puts.Add(1)
+	assert.Equal(t, "4", published.Get("Puts").String())
+}
+
+func TestDetailedMetrics(t *testing.T) {
+	db, err := createTestDB(nil)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Metrics().PutLatency)
+	assert.Equal(t, 0, len(db.Metrics().Collector().Histograms()))
+	assert.Nil(t, db.Close())
+
+	db, err = createTestDB(&Options{DetailedMetrics: true, LatencyBuckets: []time.Duration{time.Hour}})
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte{1}))
+	assert.Nil(t, db.Put([]byte{2}))
+	_, err = db.Has([]byte{1})
+	assert.Nil(t, err)
+	_, err = db.Compact()
+	assert.Nil(t, err)
+
+	hs := db.Metrics().Collector().Histograms()
+	assert.Equal(t, []uint64{2, 0}, hs["pogreb_put_latency_seconds"].Counts)
+	assert.Equal(t, []uint64{1, 0}, hs["pogreb_has_latency_seconds"].Counts)
+	assert.Equal(t, []uint64{1, 0}, hs["pogreb_compact_latency_seconds"].Counts)
+
+	buf := &bytes.Buffer{}
+	assert.Nil(t, db.Metrics().Collector().WritePrometheus(buf))
+	assert.Equal(t, true, strings.Contains(buf.String(), "# TYPE pogreb_put_latency_seconds histogram\n"+
+		"pogreb_put_latency_seconds_bucket{le=\"3600\"} 2\npogreb_put_latency_seconds_bucket{le=\"+Inf\"} 2\n"))
+	assert.Nil(t, db.Close())
+}
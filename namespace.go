@@ -0,0 +1,164 @@
+package pogreb
+
+import (
+	"bytes"
+	"context"
+	"strings"
+
+	"github.com/domaincrawler/pogreb/internal/errors"
+)
+
+// namespaceMarker starts the stored keys of namespaces.
+const namespaceMarker = 0
+
+var errInvalidNamespace = errors.New("namespace name must be non-empty and must not contain zero bytes")
+
+// Namespace is a partition of the keys of a DB, e.g. one per crawl vertical, sharing the files of the DB.
+// Keys of different namespaces don't collide, every namespace can be counted, iterated and dropped on its own.
+//
+// A key of the namespace is stored as a zero byte, the namespace name, a zero byte and the key,
+// which must fit MaxKeyLength. The DB methods see the namespaced keys, e.g. DB.Items returns them and
+// DB.Count counts them, keys of the DB itself starting with a zero byte are reserved for namespaces
+// and the canary self-test, see CheckCanary.
+// With Options.StoreFingerprintsOnly the key is replaced by its fingerprint, the namespace is kept.
+// The Before hooks of the write chain see the key without the namespace, the After hooks see the stored key.
+type Namespace struct {
+	db     *DB
+	name   string
+	prefix []byte
+	err    error // Returned by all methods if the name is invalid.
+}
+
+// Namespace returns the namespace with the name. Namespaces don't need to be created,
+// a namespace exists while it holds keys. The methods of a namespace with an invalid name return an error.
+func (db *DB) Namespace(name string) *Namespace {
+	ns := &Namespace{db: db, name: name}
+	if name == "" || strings.IndexByte(name, 0) >= 0 {
+		ns.err = errInvalidNamespace
+		return ns
+	}
+	ns.prefix = make([]byte, 0, len(name)+2)
+	ns.prefix = append(ns.prefix, namespaceMarker)
+	ns.prefix = append(ns.prefix, name...)
+	ns.prefix = append(ns.prefix, namespaceMarker)
+	return ns
+}
+
+// namespacedKey returns the stored key of a key of the namespace with the prefix, a nil prefix is the DB itself.
+func namespacedKey(prefix []byte, key []byte) []byte {
+	if prefix == nil {
+		return key
+	}
+	k := make([]byte, len(prefix)+len(key))
+	copy(k, prefix)
+	copy(k[len(prefix):], key)
+	return k
+}
+
+// Name returns the name of the namespace.
+func (ns *Namespace) Name() string {
+	return ns.name
+}
+
+// Put adds the key to the namespace.
+func (ns *Namespace) Put(key []byte) error {
+	if ns.err != nil {
+		return ns.err
+	}
+	k, err := ns.db.checkKeyIn(ns.prefix, key)
+	if err != nil {
+		return err
+	}
+	return ns.db.putChecked(context.Background(), k)
+}
+
+// HasOrPut returns true if the namespace contains the key. Otherwise it adds the key and returns false.
+func (ns *Namespace) HasOrPut(key []byte) (bool, error) {
+	if ns.err != nil {
+		return false, ns.err
+	}
+	k, err := ns.db.checkKeyIn(ns.prefix, key)
+	if err != nil {
+		return false, err
+	}
+	return ns.db.hasOrPutChecked(context.Background(), k)
+}
+
+// Has returns true if the namespace contains the key.
+func (ns *Namespace) Has(key []byte) (bool, error) {
+	if ns.err != nil {
+		return false, ns.err
+	}
+	key, ok := ns.db.lookupKey(key)
+	if !ok {
+		return false, nil
+	}
+	return ns.db.hasStored(namespacedKey(ns.prefix, key))
+}
+
+// Delete removes the key from the namespace. Deleting a missing key does nothing.
+func (ns *Namespace) Delete(key []byte) error {
+	if ns.err != nil {
+		return ns.err
+	}
+	key, ok := ns.db.lookupKey(key)
+	if !ok {
+		return nil
+	}
+	return ns.db.deleteAccounted(context.Background(), namespacedKey(ns.prefix, key))
+}
+
+// Items returns a new iterator of the keys of the namespace, without the namespace.
+// It iterates all keys of the DB, skipping the keys of other namespaces.
+func (ns *Namespace) Items() *NamespaceIterator {
+	return &NamespaceIterator{it: ns.db.Items(), prefix: ns.prefix, err: ns.err}
+}
+
+// Count returns the number of keys in the namespace. It iterates all keys of the DB.
+func (ns *Namespace) Count() (uint64, error) {
+	var n uint64
+	it := ns.Items()
+	for {
+		_, err := it.Next()
+		if err == ErrIterationDone {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+}
+
+// Drop removes all keys of the namespace and returns the number of removed keys, see DB.DeleteFunc.
+func (ns *Namespace) Drop() (int, error) {
+	if ns.err != nil {
+		return 0, ns.err
+	}
+	return ns.db.DeleteFunc(func(key []byte) bool {
+		return bytes.HasPrefix(key, ns.prefix)
+	})
+}
+
+// NamespaceIterator iterates the keys of a namespace, see Namespace.Items.
+type NamespaceIterator struct {
+	it     *ItemIterator
+	prefix []byte
+	err    error
+}
+
+// Next returns the next key of the namespace, or ErrIterationDone once all keys were returned.
+func (it *NamespaceIterator) Next() ([]byte, error) {
+	if it.err != nil {
+		return nil, it.err
+	}
+	for {
+		key, err := it.it.Next()
+		if err != nil {
+			return nil, err
+		}
+		if bytes.HasPrefix(key, it.prefix) {
+			return key[len(it.prefix):], nil
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package pogreb
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/domaincrawler/pogreb/internal/assert"
+)
+
+func TestNamespace(t *testing.T) {
+	db, err := createTestDB(nil)
+	assert.Nil(t, err)
+	news, blogs := db.Namespace("news"), db.Namespace("blogs")
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, news.Put(deleteTestKey(i)))
+		if i%2 == 0 {
+			assert.Nil(t, blogs.Put(deleteTestKey(i)))
+		}
+	}
+	assert.Nil(t, db.Put(deleteTestKey(1)))
+	found, err := blogs.HasOrPut(deleteTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, false, found)
+	assert.Nil(t, news.Delete(deleteTestKey(0)))
+
+	has, err := news.Has(deleteTestKey(0))
+	assert.Nil(t, err)
+	assert.Equal(t, false, has)
+	has, err = blogs.Has(deleteTestKey(0))
+	assert.Nil(t, err)
+	assert.Equal(t, true, has)
+	has, err = db.Namespace("other").Has(deleteTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, false, has)
+	assert.Equal(t, uint64(99+51+1), db.Count())
+
+	n, err := news.Count()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(99), n)
+	n, err = blogs.Count()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(51), n)
+
+	// Iterators return the keys without the namespace.
+	it := blogs.Items()
+	for {
+		key, err := it.Next()
+		if err == ErrIterationDone {
+			break
+		}
+		assert.Nil(t, err)
+		assert.Equal(t, 4, len(key))
+		assert.Equal(t, false, bytes.HasPrefix(key, []byte("blogs")))
+	}
+
+	dropped, err := news.Drop()
+	assert.Nil(t, err)
+	assert.Equal(t, 99, dropped)
+	assert.Equal(t, uint64(52), db.Count())
+	assertHas(t, db, deleteTestKey(1), true)
+
+	_, err = db.Namespace("a\x00b").Count()
+	assert.Equal(t, errInvalidNamespace, err)
+	assert.Equal(t, errInvalidNamespace, db.Namespace("").Put(deleteTestKey(1)))
+	assert.Nil(t, db.Close())
+}
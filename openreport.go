@@ -0,0 +1,111 @@
+package pogreb
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// OpenReport describes what happened while the database was opened.
+type OpenReport struct {
+	// Recovered is true if the index was rebuilt from the datalog,
+	// because the database wasn't closed properly, Repair was called or the index couldn't be opened.
+	Recovered bool
+
+	// IndexRebuilt is true if the index files were corrupted, written in an older format
+	// or didn't match the datalog with Options.RebuildIndexOnMismatch, and the index was rebuilt from the datalog.
+	IndexRebuilt bool
+
+	// ReadOnlyFallback is true if the database was opened read-only by Options.ReadOnlyFallback,
+	// because the file system is read-only.
+	ReadOnlyFallback bool
+
+	// CheckpointRestored is true if the recovery resumed from an index checkpoint.
+	CheckpointRestored bool
+
+	// Segments is the number of datalog segments.
+	Segments int
+
+	// SegmentsScanned is the number of segments read by the recovery.
+	SegmentsScanned int
+
+	// RecordsReplayed is the number of records inserted into the index by the recovery.
+	RecordsReplayed int64
+
+	// IndexKeys is the number of keys in the index.
+	IndexKeys uint64
+
+	// IndexShards is the number of index shards.
+	IndexShards int
+
+	// IndexLoadFactor is the ratio of keys to the slots of the main index buckets.
+	IndexLoadFactor float64
+
+	// IndexFormatVersion is the format version of the index files.
+	IndexFormatVersion uint32
+
+	// IndexMemoryBytes is the size of the index loaded into memory by Options.IndexInMemory.
+	IndexMemoryBytes int64
+
+	// SegmentFormatVersions is the number of segments of each format version.
+	SegmentFormatVersions map[uint32]int
+
+	// HotBucketsPrefetched is the number of index buckets prefetched by Options.HotBuckets.
+	HotBucketsPrefetched int
+
+	// Time spent in each phase of Open.
+	LockDuration     time.Duration // Acquiring the lock file.
+	IndexDuration    time.Duration // Opening the index, including restoring a checkpoint.
+	DatalogDuration  time.Duration // Opening the datalog segments.
+	RecoveryDuration time.Duration // Repairing segments and rebuilding the index.
+	PrefetchDuration time.Duration // Prefetching the hot buckets.
+	TotalDuration    time.Duration
+}
+
+func (r *OpenReport) String() string {
+	versions := make([]string, 0, len(r.SegmentFormatVersions))
+	for v, n := range r.SegmentFormatVersions {
+		versions = append(versions, fmt.Sprintf("v%d:%d", v, n))
+	}
+	sort.Strings(versions)
+	return fmt.Sprintf("recovered=%t read_only_fallback=%t checkpoint=%t segments=%d scanned=%d replayed=%d "+
+		"keys=%d shards=%d load_factor=%.3f index_version=%d segment_versions=[%s] prefetched=%d "+
+		"lock=%s index=%s datalog=%s recovery=%s prefetch=%s total=%s",
+		r.Recovered, r.ReadOnlyFallback, r.CheckpointRestored, r.Segments, r.SegmentsScanned, r.RecordsReplayed,
+		r.IndexKeys, r.IndexShards, r.IndexLoadFactor, r.IndexFormatVersion, strings.Join(versions, " "),
+		r.HotBucketsPrefetched, r.LockDuration, r.IndexDuration, r.DatalogDuration, r.RecoveryDuration,
+		r.PrefetchDuration, r.TotalDuration)
+}
+
+// fillIndexStats sets the index fields of the report.
+func (r *OpenReport) fillIndexStats(si *shardedIndex) {
+	var slots float64
+	for _, sh := range si.shards {
+		slots += float64(sh.numBuckets) * float64(sh.slotsPerBucket())
+	}
+	r.IndexKeys = si.count()
+	r.IndexShards = len(si.shards)
+	if slots > 0 {
+		r.IndexLoadFactor = float64(r.IndexKeys) / slots
+	}
+	r.IndexFormatVersion = si.shards[0].main.formatVersion
+	r.IndexMemoryBytes = si.memoryBytes()
+}
+
+// fillSegmentStats sets the segment fields of the report.
+func (r *OpenReport) fillSegmentStats(dl *datalog) {
+	r.SegmentFormatVersions = map[uint32]int{}
+	for _, seg := range dl.usedSegments() {
+		if seg == nil {
+			continue
+		}
+		r.Segments++
+		r.SegmentFormatVersions[seg.formatVersion]++
+	}
+}
+
+// OpenReport returns the report of opening the database.
+func (db *DB) OpenReport() OpenReport {
+	return db.openReport
+}
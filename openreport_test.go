@@ -0,0 +1,48 @@
+package pogreb
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/domaincrawler/pogreb/internal/assert"
+)
+
+func TestOpenReport(t *testing.T) {
+	opts := &Options{maxSegmentSize: 1024}
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+	r := db.OpenReport()
+	assert.Equal(t, false, r.Recovered)
+	assert.Equal(t, 1, r.Segments)
+	assert.Equal(t, uint64(0), r.IndexKeys)
+	assert.Equal(t, uint32(formatVersion), r.IndexFormatVersion)
+	assert.Equal(t, map[uint32]int{formatVersion: 1}, r.SegmentFormatVersions)
+	assert.Equal(t, true, r.TotalDuration >= r.IndexDuration+r.DatalogDuration)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put([]byte{byte(i)}))
+	}
+	numSegments := countSegments(t, db)
+	simulateCrash(t, db)
+
+	buf := &bytes.Buffer{}
+	prevLogger := logger
+	SetLogger(log.New(buf, "", 0))
+	defer SetLogger(prevLogger)
+	opts.LogOpenReport = true
+	db, err = Open(testDBName, opts)
+	assert.Nil(t, err)
+	r = db.OpenReport()
+	assert.Equal(t, true, r.Recovered)
+	assert.Equal(t, false, r.CheckpointRestored)
+	assert.Equal(t, numSegments, r.Segments)
+	assert.Equal(t, numSegments, r.SegmentsScanned)
+	assert.Equal(t, int64(100), r.RecordsReplayed)
+	assert.Equal(t, uint64(100), r.IndexKeys)
+	assert.Equal(t, 1, r.IndexShards)
+	assert.Equal(t, float64(100)/float64(db.index.shards[0].numBuckets*slotsPerBucket), r.IndexLoadFactor)
+	assert.Equal(t, true, strings.Contains(buf.String(), "recovered=true"))
+	assert.Nil(t, db.Close())
+}
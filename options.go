@@ -2,32 +2,428 @@ package pogreb
 
 import (
 	"math"
+	"runtime"
 	"time"
 
 	"github.com/domaincrawler/pogreb/fs"
 )
 
+// SyncPolicy controls when the DB flushes written records to durable storage.
+type SyncPolicy int
+
+const (
+	// SyncAlways syncs after every write operation.
+	SyncAlways SyncPolicy = iota + 1
+
+	// SyncInterval syncs in the background every BackgroundSyncInterval.
+	SyncInterval
+
+	// SyncNever never syncs, explicit Sync calls and Close don't sync either.
+	// Unsynced records are lost if the operating system crashes. PutAsync callbacks are invoked once the keys
+	// are written, promises returned by PutDeferred are resolved by Sync and Close.
+	SyncNever
+
+	// SyncOSDefault doesn't sync automatically and leaves writing back dirty pages to the operating system.
+	// Sync calls still flush the data.
+	SyncOSDefault
+)
+
+const defaultBackgroundSyncInterval = time.Second
+
 // Options holds the optional DB parameters.
 type Options struct {
 	// BackgroundSyncInterval sets the amount of time between background Sync() calls.
 	//
 	// Setting the value to 0 disables the automatic background synchronization.
 	// Setting the value to -1 makes the DB call Sync() after every write operation.
+	//
+	// Default: 1 second when SyncPolicy is SyncInterval.
 	BackgroundSyncInterval time.Duration
 
+	// SyncPolicy sets when written records are synced.
+	//
+	// Default: derived from BackgroundSyncInterval, SyncAlways when it's -1,
+	// SyncInterval when it's positive and SyncOSDefault otherwise.
+	SyncPolicy SyncPolicy
+
+	// SyncEveryNBytes makes the DB sync once that many bytes were written since the last sync,
+	// in addition to the syncs performed according to SyncPolicy.
+	// It has no effect with the SyncAlways and SyncNever policies.
+	//
+	// Setting the value to 0 disables syncing based on the amount of written data.
+	SyncEveryNBytes int64
+
+	// GroupCommitLatency enables group commit when the DB syncs after every write (SyncPolicy is SyncAlways).
+	// Concurrent writers are batched: the first writer waits for GroupCommitLatency
+	// and then issues a single fsync on behalf of all writers queued during the window.
+	//
+	// Setting the value to 0 disables group commit, every write issues its own fsync.
+	GroupCommitLatency time.Duration
+
+	// IndexCheckpointInterval sets the amount of time between index checkpoints.
+	// A checkpoint stores a snapshot of the index together with the position in the write-ahead log,
+	// after a crash the recovery only replays records written after the last checkpoint
+	// instead of rebuilding the whole index.
+	//
+	// Setting the value to 0 disables checkpoints.
+	IndexCheckpointInterval time.Duration
+
+	// IndexFlushInterval enables write-behind index updates.
+	// Modified index buckets are kept in memory and written to the index files in batches every IndexFlushInterval,
+	// a bucket updated many times between flushes is written once.
+	// The write-ahead log remains the source of truth, after a crash the index is rebuilt from it.
+	//
+	// Setting the value to 0 disables write-behind, index updates are written immediately.
+	IndexFlushInterval time.Duration
+
+	// IndexMaxDirtyBuckets sets the maximum number of modified buckets an index shard keeps in memory,
+	// the buckets are flushed early when the limit is reached.
+	// It has no effect unless IndexFlushInterval is set.
+	//
+	// Default: 4096.
+	IndexMaxDirtyBuckets int
+
+	// RecoveryConcurrency sets the number of segments read in parallel when recovering the index after a crash.
+	//
+	// Default: runtime.GOMAXPROCS(0).
+	RecoveryConcurrency int
+
+	// RecoveryProgress is called periodically during the recovery after a crash and while the index is rebuilt.
+	// done is the number of bytes of segment data in the index so far and total is the size of all segments.
+	// A recovery resumed from a checkpoint starts with the data covered by the checkpoint done.
+	RecoveryProgress func(done, total int64)
+
+	// RebuildIndexOnMismatch makes Open rebuild the index from the datalog, instead of returning ErrIndexMismatch,
+	// when the index doesn't match the segments: the number of keys in the index meta disagrees with the buckets,
+	// or the segment files changed since the index was closed, e.g. segments restored from a backup.
+	// The rebuild is reported by OpenReport.IndexRebuilt and Options.RecoveryProgress.
+	// Index files failing the checksum verification are always rebuilt.
+	//
+	// Default: false.
+	RebuildIndexOnMismatch bool
+
+	// LogOpenReport logs the OpenReport when the database is opened.
+	LogOpenReport bool
+
 	// BackgroundCompactionInterval sets the amount of time between background Compact() calls.
 	//
 	// Setting the value to 0 disables the automatic background compaction.
 	BackgroundCompactionInterval time.Duration
 
+	// CanaryInterval sets the amount of time between background CheckCanary() calls,
+	// detecting storage that silently loses written data on long-running nodes.
+	//
+	// Setting the value to 0 disables the background canary checks.
+	CanaryInterval time.Duration
+
+	// MinFreeDiskBytes sets the amount of disk space that must remain free after appending a record.
+	// Writes return ErrDiskFull instead of filling the disk, and compactions don't start while the
+	// free space is below the watermark. It requires a FileSystem implementing fs.FreeSpaceFileSystem.
+	//
+	// Default: 0 (disabled).
+	MinFreeDiskBytes uint64
+
+	// Checksum sets the algorithm used to checksum records of new segments.
+	// The algorithm is stored in the segment header, existing segments keep their algorithm.
+	//
+	// Default: ChecksumIEEE.
+	Checksum Checksum
+
+	// EncryptionKey sets the AES key, 16, 24 or 32 bytes long, encrypting the records of new segments with AES-GCM.
+	// Every segment stores a random nonce in its header, existing segments keep their encryption,
+	// unencrypted segments are rewritten encrypted by compaction. Encrypted segments can't be opened without the key.
+	// The index files hold key hashes and record positions. The keys are hashed with a secret seed derived
+	// from the key, an existing index of a DB which wasn't encrypted is rebuilt with it when the DB is opened.
+	//
+	// Default: nil, records are stored unencrypted.
+	EncryptionKey []byte
+
+	// StoreFingerprintsOnly makes the DB store a fingerprint of every key, its truncated SHA-256 digest,
+	// instead of the key bytes, for membership tests which never need the keys back.
+	// Has, Put, Delete and the other methods taking keys fingerprint them, while Items, Drain, DeleteFunc,
+	// Diff, Intersect and the write interceptor's After hook see the stored fingerprints.
+	// Two different keys with the same fingerprint are the same key for the DB: a lookup of a missing key
+	// in a DB with n keys is a false positive with the probability of about n/2^(8*FingerprintSize),
+	// 3e-30 for a billion keys and the default size.
+	// The mode is fixed when the DB is created, an existing DB storing keys can't be opened with it.
+	StoreFingerprintsOnly bool
+
+	// FingerprintSize sets the size of the key fingerprints in bytes, from 8 to 32.
+	// Every byte less multiplies the false-positive probability by 256.
+	//
+	// Default: 16.
+	FingerprintSize int
+
+	// HashAlgorithm sets the hash function of the index.
+	// The algorithm is fixed when the index is created, the option is ignored for existing DBs.
+	//
+	// Default: HashMurmur32.
+	HashAlgorithm HashAlgorithm
+
+	// CompactionSkipCorrupted makes compaction discard corrupted records instead of failing.
+	// The scan resynchronizes on the next record with a valid checksum,
+	// index entries pointing to the discarded data are removed.
+	CompactionSkipCorrupted bool
+
+	// CompactionCPUShare limits the fraction of a CPU core used by compaction, from 0 to 1.
+	// Compaction sleeps in proportion to the time spent copying records,
+	// the DB lock is released while it sleeps, which leaves the CPU and the DB to latency-critical lookups.
+	//
+	// Default: 1, compaction isn't throttled.
+	CompactionCPUShare float64
+
+	// CompactionMaxTempBytes limits the amount of live data compaction copies from a segment
+	// before the segment is removed, which is the extra disk space compaction needs.
+	// Compaction measures the live data of each segment first and stops at the first segment exceeding the limit.
+	// With MinFreeDiskBytes compaction also stops with ErrDiskFull before a segment whose live data
+	// doesn't fit into the free space above the watermark.
+	//
+	// Default: 0, the copied data isn't limited.
+	CompactionMaxTempBytes int64
+
+	// IndexShards sets the number of index shards.
+	// Each shard has its own lock, writes of keys that belong to different shards proceed concurrently.
+	// The number of shards is fixed when the DB is created, the option is ignored for existing databases.
+	//
+	// Default: 1.
+	IndexShards int
+
+	// IndexLoadFactor sets the ratio of keys to the slots of the main index buckets above which a bucket is split.
+	// A lower load factor makes overflow buckets rare at the cost of a larger index, a higher one keeps the index small.
+	// Changing it for an existing DB takes effect as keys are added. It must be greater than 0 and at most 1.
+	//
+	// Default: 0.7.
+	IndexLoadFactor float64
+
+	// IndexBucketSlots sets the number of slots used in every 512-byte index bucket, at most 31, or 25 with HashXXH64.
+	// Fewer slots shorten the scan of a bucket and the chains of dense small keys at the cost of more buckets.
+	// Open rebuilds the index of an existing DB with a different number of slots from the datalog.
+	//
+	// Default: 0, all slots are used, existing databases keep their number of slots.
+	IndexBucketSlots int
+
+	// IndexInMemory loads the index files into memory when the DB is opened, lookups and writes don't do index I/O.
+	// The index is written to disk, replacing the files atomically, only by Close, and copied by index checkpoints.
+	// After a crash the index is recovered from the last checkpoint or rebuilt from the datalog.
+	// The index takes as much memory as its files, about 18 bytes per key, OpenReport.IndexMemoryBytes
+	// reports the size loaded by Open. It can't be used with PublishManifest.
+	//
+	// Default: false.
+	IndexInMemory bool
+
+	// DetailedMetrics enables the latency histograms of Metrics.
+	// Timing every operation has a small overhead, the histograms are disabled by default.
+	DetailedMetrics bool
+
+	// LatencyBuckets sets the upper bounds of the latency histogram buckets enabled by DetailedMetrics.
+	//
+	// Default: DefaultLatencyBuckets.
+	LatencyBuckets []time.Duration
+
+	// Logger receives the log messages of the DB.
+	//
+	// Default: the global logger set by SetLogger.
+	Logger Logger
+
+	// Accounting attributes operations and stored bytes to the labels set by WithLabel,
+	// passed to the context methods, e.g. PutContext. Usage returns the usage of each label.
+	// Keys written by any method are accounted at WriteStageAccounting of the write chain.
+	// The usage is persisted in the DB meta, opening the DB without Accounting discards it.
+	Accounting bool
+
+	// Archiver receives the segments archived by ArchiveSegments and fetches them back
+	// when a lookup needs a record from an archived segment.
+	//
+	// Default: nil, segments aren't archived. Databases with archived segments can't be opened without it.
+	Archiver Archiver
+
+	// EventHandler receives lifecycle events, e.g. SegmentSealed to upload segments to cold storage once they rotate.
+	// Events are delivered in order on a separate goroutine, the handler may call DB methods except Close.
+	// Close waits for the delivery of all pending events.
+	//
+	// Default: nil, events are discarded.
+	EventHandler func(e Event)
+
+	// Clock sets the source of time of background tasks, iteration deadlines and segment creation times.
+	// Durations reported in OpenReport and metrics are measured with the system clock.
+	//
+	// Default: SystemClock.
+	Clock Clock
+
 	// FileSystem sets the file system implementation.
 	//
 	// Default: fs.OSMMap.
 	FileSystem fs.FileSystem
 
-	maxSegmentSize             uint32
+	// UseMmap makes the DB memory-map segment files when the FileSystem implements fs.MmapFileSystem.
+	// Key comparisons during lookups then read directly from mapped memory instead of issuing read syscalls.
+	// It allows using memory-mapped segments together with a non-mmap file system such as fs.OS.
+	UseMmap bool
+
+	// DirectIO makes the DB write segments with direct I/O (O_DIRECT), bypassing the page cache,
+	// so that large sequential datalog writes don't evict cached index pages.
+	// Whole blocks are written directly, the partial last block of a segment is written through the page cache.
+	// It requires a FileSystem implementing fs.DirectIOFileSystem, such as fs.OS and fs.OSMMap,
+	// on a platform and a file system supporting direct I/O. Otherwise segments are written through the page cache.
+	DirectIO bool
+
+	// SegmentTargetSize sets the size at which the current segment is replaced by a new segment.
+	// The segment is replaced before the next write once it reaches the size,
+	// records aren't split and the last record may cross the target.
+	//
+	// A target above 4 GiB lifts the maximum segment size, except for encrypted segments.
+	//
+	// Default: 0, segments are replaced only when they reach the maximum segment size of 4 GiB.
+	SegmentTargetSize int64
+
+	// SegmentMinRecords sets the number of records the current segment must hold before
+	// SegmentTargetSize or SegmentMaxAge replace it. It prevents workloads with huge keys
+	// from producing many tiny segments, which bloat the segment table and slow down Open.
+	SegmentMinRecords uint32
+
+	// SegmentMaxAge sets the amount of time after which the current segment is replaced by a new segment
+	// on the next write. The age is measured with Options.Clock since the segment became the current segment.
+	//
+	// Setting the value to 0 disables age-based rotation.
+	SegmentMaxAge time.Duration
+
+	// TailCacheSize sets the maximum size of the in-memory copy of records written to the current segment
+	// since the last sync. Lookups of recently written keys are served from memory without reading the file,
+	// which helps when the FileSystem is slow.
+	//
+	// Setting the value to 0 disables the cache.
+	TailCacheSize int
+
+	// ReadOnly opens the database for reading only, e.g. to serve lookups from a backup.
+	// No files are created or modified, write methods and Compact return an error.
+	// The database must have been closed properly, a database with a lock file can't be opened read-only.
+	ReadOnly bool
+
+	// ReadOnlyFallback makes Open open the database as if ReadOnly was set
+	// when the database can't be opened for writing because of a ReadOnlyFSError,
+	// e.g. when a snapshot is mounted read-only for inspection. OpenReport.ReadOnlyFallback reports the fallback.
+	ReadOnlyFallback bool
+
+	// AllowNewerFormat opens databases written in a newer format by a newer version of the library,
+	// logging a warning instead of failing. Data the library doesn't understand may be misread.
+	//
+	// Default: false, Open returns an error with CodeUnsupported.
+	AllowNewerFormat bool
+
+	// Shared allows opening the same database multiple times within one process.
+	// Open calls with the same path and FileSystem return handles of the same DB, which is closed
+	// when every handle is closed.
+	// Options of the first Open call are used, options passed to subsequent calls are ignored.
+	// The FileSystem must be of a comparable type, e.g. a pointer.
+	Shared bool
+
+	// HashDomain is mixed into the hash seed when the DB is created, separating the key spaces of different datasets.
+	// When an existing DB is opened with a different domain, a warning is logged,
+	// lookups always miss and writes fail.
+	//
+	// Default: nil, no domain.
+	HashDomain []byte
+
+	// HashSeed sets the hash seed of a new DB, making the key placement deterministic,
+	// e.g. for reproducible tests or to shard keys consistently across databases.
+	// When an existing DB is opened with a non-zero seed that doesn't match the stored one, Open fails.
+	//
+	// Default: 0, a random seed.
+	HashSeed uint32
+
+	// LargeKeys allows storing keys larger than MaxKeyLength, up to MaxLargeKeyLength.
+	// Large keys are stored in the segment in full next to a fixed-size digest,
+	// the index holds the same amount of data as for the other keys.
+	LargeKeys bool
+
+	// Blocklist sets the set of keys rejected by Put and HasOrPut.
+	// Blocked keys are never stored, the write methods return ErrBlocked instead.
+	//
+	// Default: nil, all keys are accepted.
+	Blocklist Blocklist
+
+	// SecondaryIndexes maps index names to key extractors. For every index, the DB keeps a posting list in memory
+	// of the keys stored under every extracted value, e.g. the URLs of every registrable domain,
+	// which DB.Lookup iterates. Extractors see the keys as they are stored and can't be used with StoreFingerprintsOnly.
+	// Close writes the posting lists next to the index and Open loads them. After a crash, or a write by a DB opened
+	// without the option, Open rebuilds them by iterating all keys. Rename an index after changing its extractor.
+	//
+	// Default: nil, no secondary indexes.
+	SecondaryIndexes map[string]KeyExtractor
+
+	// RecordFlags stores 16-bit flags after every record of the segments created with the option, set by
+	// DB.PutWithFlags and read by DB.HasFlags. Updating the flags of a stored key overwrites them in place,
+	// the flags aren't covered by the record checksum. Records are 2 bytes larger, older library versions
+	// can't read the segments. It can't be used with EncryptionKey.
+	//
+	// Default: false.
+	RecordFlags bool
+
+	// MembershipFilter keeps a cuckoo filter of the key hashes of every index shard in memory,
+	// so that Has, HasAny and HasAll answer most lookups of missing keys without reading the index or the datalog.
+	// The filter never rejects a stored key, at most 0.013% of the missing keys pass it and are looked up as usual.
+	// It takes 2 to 5 bytes of memory per key, a full filter is rebuilt twice as large from the index.
+	// Close writes the filter next to the index and Open loads it. After a crash, or a write by a DB opened
+	// without the option, Open rebuilds it reading every index bucket.
+	//
+	// Default: false.
+	MembershipFilter bool
+
+	// TrackAccessFrequency counts the lookups of every index bucket in a count-min sketch of 16 KiB per index shard,
+	// DB.HotBuckets returns the most looked up buckets. The counts are approximate, but never too low.
+	// Options.HotBuckets tracks the lookups too.
+	TrackAccessFrequency bool
+
+	// HotBuckets sets the number of the most looked up index buckets recorded by Close.
+	// Open reads the recorded buckets and the keys they point to, loading their pages into memory,
+	// so that lookups of hot keys are fast right after a restart.
+	//
+	// Default: 0, bucket accesses aren't tracked.
+	HotBuckets int
+
+	// PublishManifest makes Sync, including the background sync, and Close publish the index state
+	// for read-only replicas opened by other processes with ManifestPollInterval.
+	PublishManifest bool
+
+	// ManifestPollInterval sets the amount of time between checks for a new index state
+	// published by a writer with PublishManifest. It requires ReadOnly and allows
+	// opening a database which is in use by the writer. When the writer publishes a new state,
+	// the replica reopens the index and the datalog, and keys written before the publication become visible.
+	//
+	// Default: 0, the replica isn't refreshed and Open fails with ErrLocked while the database is in use.
+	ManifestPollInterval time.Duration
+
+	// SingleFile stores the database in a single file at the path instead of a directory, for small databases,
+	// e.g. a few thousand keys, opened by short-lived processes. Open loads the file into memory, Sync and Close
+	// write it back, writes don't touch the disk in between. A file saved by Sync is recovered when opened.
+	// While the DB is open for writing, a lock file with the ".lock" suffix exists next to the file.
+	// FileSystem must not be set, Replace and Clone aren't supported.
+	//
+	// Default: false.
+	SingleFile bool
+
+	// WriteInterceptors sets the chain of interceptors applied to the keys written by Put, HasOrPut, HasOrPutWithin,
+	// PutAsync, PutDeferred, Import and Builder. Interceptors run ordered by their stage,
+	// interceptors of the same stage in the order they are listed.
+	// Interceptors of WriteStageTransform rewrite the keys looked up and deleted as well, by Has, HasAny, HasAll,
+	// HasFlags, CompareAndSetFlags, Delete and namespaced lookups. The other stages only run on writes.
+	//
+	// Default: nil, keys are only checked against MaxKeyLength and Blocklist.
+	WriteInterceptors []WriteInterceptor
+
+	maxSegmentSize             int64
 	compactionMinSegmentSize   uint32
 	compactionMinFragmentation float32
+	recoveryCheckpointBytes    int64         // Amount of data replayed by the recovery between checkpoints.
+	repair                     *RepairReport // Set by Repair, makes Open salvage segments and rebuild the index.
+	verifyIndex                bool          // Set by Open after an unclean shutdown, makes the index read all buckets.
+}
+
+// Blocklist is a membership set of keys that must not be stored in the DB, e.g. a Bloom filter.
+// Implementations must be safe for concurrent use by multiple goroutines.
+type Blocklist interface {
+	// Contains reports whether the key is blocked.
+	Contains(key []byte) bool
 }
 
 func (src *Options) copyWithDefaults(path string) *Options {
@@ -38,9 +434,61 @@ func (src *Options) copyWithDefaults(path string) *Options {
 	if opts.FileSystem == nil {
 		opts.FileSystem = fs.OSMMap
 	}
+	if opts.Clock == nil {
+		opts.Clock = SystemClock
+	}
+	if opts.Logger == nil {
+		opts.Logger = stdLogger{}
+	}
+	if opts.LatencyBuckets == nil {
+		opts.LatencyBuckets = DefaultLatencyBuckets
+	}
 	opts.FileSystem = fs.Sub(opts.FileSystem, path)
+	if opts.ReadOnly {
+		opts.FileSystem = readOnlyFS{FileSystem: opts.FileSystem, replica: opts.ManifestPollInterval > 0}
+	}
+	if opts.SyncPolicy == 0 {
+		switch {
+		case opts.BackgroundSyncInterval == -1:
+			opts.SyncPolicy = SyncAlways
+		case opts.BackgroundSyncInterval > 0:
+			opts.SyncPolicy = SyncInterval
+		default:
+			opts.SyncPolicy = SyncOSDefault
+		}
+	}
+	if opts.SyncPolicy == SyncInterval && opts.BackgroundSyncInterval <= 0 {
+		opts.BackgroundSyncInterval = defaultBackgroundSyncInterval
+	}
+	if opts.RecoveryConcurrency <= 0 {
+		opts.RecoveryConcurrency = runtime.GOMAXPROCS(0)
+	}
+	if opts.Checksum == 0 {
+		opts.Checksum = ChecksumIEEE
+	}
+	if opts.HashAlgorithm == 0 {
+		opts.HashAlgorithm = HashMurmur32
+	}
+	if opts.StoreFingerprintsOnly && opts.FingerprintSize == 0 {
+		opts.FingerprintSize = defaultFingerprintSize
+	}
+	if opts.IndexMaxDirtyBuckets <= 0 {
+		opts.IndexMaxDirtyBuckets = defaultIndexMaxDirtyBuckets
+	}
+	if opts.IndexShards <= 0 {
+		opts.IndexShards = 1
+	}
+	if opts.IndexLoadFactor == 0 {
+		opts.IndexLoadFactor = defaultIndexLoadFactor
+	}
 	if opts.maxSegmentSize == 0 {
 		opts.maxSegmentSize = math.MaxUint32
+		if opts.SegmentTargetSize > opts.maxSegmentSize {
+			opts.maxSegmentSize = maxSegmentSizeLimit
+		}
+	}
+	if opts.CompactionCPUShare <= 0 || opts.CompactionCPUShare > 1 {
+		opts.CompactionCPUShare = 1
 	}
 	if opts.compactionMinSegmentSize == 0 {
 		opts.compactionMinSegmentSize = 32 << 20
@@ -48,5 +496,8 @@ func (src *Options) copyWithDefaults(path string) *Options {
 	if opts.compactionMinFragmentation == 0 {
 		opts.compactionMinFragmentation = 0.5
 	}
+	if opts.recoveryCheckpointBytes == 0 {
+		opts.recoveryCheckpointBytes = 1 << 30
+	}
 	return &opts
 }
@@ -0,0 +1,344 @@
+/*
+Package parquet exports pogreb keys into Parquet files.
+
+The produced files contain a required "key" column and, for databases storing record flags, a required
+"flags" column. Columns are stored uncompressed with the PLAIN encoding and can be consumed directly
+by DuckDB, Spark and other Parquet readers.
+*/
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+
+	"github.com/domaincrawler/pogreb"
+)
+
+const (
+	magic     = "PAR1"
+	createdBy = "pogreb"
+
+	defaultRowGroupRows  = 1 << 20
+	defaultRowGroupBytes = 64 << 20
+)
+
+// Parquet physical types, encodings and repetition types.
+const (
+	typeInt32          = 1
+	typeByteArray      = 6
+	encodingPlain      = 0
+	encodingRLE        = 3
+	repetitionRequired = 0
+	convertedUTF8      = 0
+	convertedUint16    = 12
+	pageTypeData       = 0
+	codecUncompressed  = 0
+)
+
+// Options holds the optional Writer parameters.
+type Options struct {
+	// RowGroupRows sets the maximum number of rows in a row group.
+	//
+	// Default: 1048576.
+	RowGroupRows int
+
+	// RowGroupBytes sets the maximum size of key data buffered in memory before a row group is flushed.
+	//
+	// Default: 64 MiB.
+	RowGroupBytes int
+
+	// KeysAsString annotates the key column as a UTF-8 string instead of raw binary.
+	KeysAsString bool
+
+	// Flags adds a "flags" column holding the flags passed to WriteWithFlags, annotated as UINT_16.
+	// Export sets it when the DB stores record flags, see pogreb.Options.RecordFlags.
+	//
+	// Default: false, the file only has the key column.
+	Flags bool
+
+	// Start is the inclusive lower bound of the keys exported by Export, compared bytewise.
+	//
+	// Default: nil, keys aren't bounded from below.
+	Start []byte
+
+	// End is the exclusive upper bound of the keys exported by Export, compared bytewise.
+	//
+	// Default: nil, keys aren't bounded from above.
+	End []byte
+}
+
+// inRange reports whether the key is within the Start and End bounds.
+func (opts *Options) inRange(key []byte) bool {
+	if opts.Start != nil && bytes.Compare(key, opts.Start) < 0 {
+		return false
+	}
+	return opts.End == nil || bytes.Compare(key, opts.End) < 0
+}
+
+func (src *Options) copyWithDefaults() *Options {
+	opts := Options{}
+	if src != nil {
+		opts = *src
+	}
+	if opts.RowGroupRows <= 0 {
+		opts.RowGroupRows = defaultRowGroupRows
+	}
+	if opts.RowGroupBytes <= 0 {
+		opts.RowGroupBytes = defaultRowGroupBytes
+	}
+	return &opts
+}
+
+// columnChunk is a column of a row group, stored in a single data page.
+type columnChunk struct {
+	offset int64 // Offset of the data page.
+	size   int64 // Size of the data page including its header.
+}
+
+type rowGroup struct {
+	numRows int64
+	columns []columnChunk // The key column, followed by the flags column if Options.Flags is set.
+}
+
+// size returns the total size of the column chunks.
+func (rg rowGroup) size() int64 {
+	var size int64
+	for _, c := range rg.columns {
+		size += c.size
+	}
+	return size
+}
+
+// Writer writes keys to a Parquet file.
+// The file is complete only after Close is called.
+type Writer struct {
+	w         io.Writer
+	opts      *Options
+	offset    int64
+	page      []byte // PLAIN-encoded keys of the current row group.
+	flagsPage []byte // PLAIN-encoded flags of the current row group, empty unless Options.Flags is set.
+	pageRows  int
+	rowGroups []rowGroup
+	numRows   int64
+	err       error
+}
+
+// NewWriter returns a new Writer writing a Parquet file to w.
+func NewWriter(w io.Writer, opts *Options) *Writer {
+	return &Writer{
+		w:    w,
+		opts: opts.copyWithDefaults(),
+	}
+}
+
+func (pw *Writer) write(data []byte) error {
+	if pw.err != nil {
+		return pw.err
+	}
+	n, err := pw.w.Write(data)
+	pw.offset += int64(n)
+	pw.err = err
+	return err
+}
+
+// Write appends a key to the file, with no flags.
+func (pw *Writer) Write(key []byte) error {
+	return pw.WriteWithFlags(key, 0)
+}
+
+// WriteWithFlags appends a key and its flags to the file. The flags are dropped unless Options.Flags is set.
+func (pw *Writer) WriteWithFlags(key []byte, flags uint16) error {
+	if pw.err != nil {
+		return pw.err
+	}
+	if pw.offset == 0 {
+		if err := pw.write([]byte(magic)); err != nil {
+			return err
+		}
+	}
+	var size [4]byte
+	binary.LittleEndian.PutUint32(size[:], uint32(len(key)))
+	pw.page = append(pw.page, size[:]...)
+	pw.page = append(pw.page, key...)
+	if pw.opts.Flags {
+		binary.LittleEndian.PutUint32(size[:], uint32(flags))
+		pw.flagsPage = append(pw.flagsPage, size[:]...)
+	}
+	pw.pageRows++
+	pw.numRows++
+	if pw.pageRows >= pw.opts.RowGroupRows || len(pw.page) >= pw.opts.RowGroupBytes {
+		return pw.flushRowGroup()
+	}
+	return nil
+}
+
+// writePage writes a data page holding the values of the current row group.
+func (pw *Writer) writePage(page []byte) (columnChunk, error) {
+	tw := &thriftWriter{}
+	tw.i32(1, pageTypeData)
+	tw.i32(2, int32(len(page)))
+	tw.i32(3, int32(len(page)))
+	tw.beginStruct(5)
+	tw.i32(1, int32(pw.pageRows))
+	tw.i32(2, encodingPlain)
+	tw.i32(3, encodingRLE)
+	tw.i32(4, encodingRLE)
+	tw.endStruct()
+	tw.buf = append(tw.buf, 0)
+
+	c := columnChunk{
+		offset: pw.offset,
+		size:   int64(len(tw.buf) + len(page)),
+	}
+	if err := pw.write(tw.buf); err != nil {
+		return c, err
+	}
+	return c, pw.write(page)
+}
+
+func (pw *Writer) flushRowGroup() error {
+	if pw.pageRows == 0 {
+		return nil
+	}
+	rg := rowGroup{numRows: int64(pw.pageRows)}
+	pages := [][]byte{pw.page}
+	if pw.opts.Flags {
+		pages = append(pages, pw.flagsPage)
+	}
+	for _, page := range pages {
+		c, err := pw.writePage(page)
+		if err != nil {
+			return err
+		}
+		rg.columns = append(rg.columns, c)
+	}
+	pw.rowGroups = append(pw.rowGroups, rg)
+	pw.page = pw.page[:0]
+	pw.flagsPage = pw.flagsPage[:0]
+	pw.pageRows = 0
+	return nil
+}
+
+// column describes a column of the schema.
+type column struct {
+	name      string
+	typ       int32
+	converted int32 // -1 if the column isn't annotated.
+}
+
+// columns returns the columns of the file, in the order of the column chunks of row groups.
+func (pw *Writer) columns() []column {
+	key := column{name: "key", typ: typeByteArray, converted: -1}
+	if pw.opts.KeysAsString {
+		key.converted = convertedUTF8
+	}
+	columns := []column{key}
+	if pw.opts.Flags {
+		columns = append(columns, column{name: "flags", typ: typeInt32, converted: convertedUint16})
+	}
+	return columns
+}
+
+func (pw *Writer) fileMetadata() []byte {
+	tw := &thriftWriter{}
+	tw.i32(1, 1)
+
+	columns := pw.columns()
+	tw.listHeader(2, thriftStruct, 1+len(columns))
+	tw.beginListStruct()
+	tw.binary(4, []byte("schema"))
+	tw.i32(5, int32(len(columns)))
+	tw.endStruct()
+	for _, col := range columns {
+		tw.beginListStruct()
+		tw.i32(1, col.typ)
+		tw.i32(3, repetitionRequired)
+		tw.binary(4, []byte(col.name))
+		if col.converted >= 0 {
+			tw.i32(6, col.converted)
+		}
+		tw.endStruct()
+	}
+
+	tw.i64(3, pw.numRows)
+
+	tw.listHeader(4, thriftStruct, len(pw.rowGroups))
+	for _, rg := range pw.rowGroups {
+		tw.beginListStruct()
+		tw.listHeader(1, thriftStruct, len(rg.columns))
+		for i, c := range rg.columns {
+			tw.beginListStruct()
+			tw.i64(2, c.offset)
+			tw.beginStruct(3)
+			tw.i32(1, columns[i].typ)
+			tw.listI32(2, []int32{encodingPlain, encodingRLE})
+			tw.listBinary(3, []string{columns[i].name})
+			tw.i32(4, codecUncompressed)
+			tw.i64(5, rg.numRows)
+			tw.i64(6, c.size)
+			tw.i64(7, c.size)
+			tw.i64(9, c.offset)
+			tw.endStruct()
+			tw.endStruct()
+		}
+		tw.i64(2, rg.size())
+		tw.i64(3, rg.numRows)
+		tw.endStruct()
+	}
+
+	tw.binary(6, []byte(createdBy))
+	tw.buf = append(tw.buf, 0)
+	return tw.buf
+}
+
+// Close flushes buffered keys and writes the file footer.
+// It doesn't close the underlying io.Writer.
+func (pw *Writer) Close() error {
+	if pw.offset == 0 {
+		if err := pw.write([]byte(magic)); err != nil {
+			return err
+		}
+	}
+	if err := pw.flushRowGroup(); err != nil {
+		return err
+	}
+	meta := pw.fileMetadata()
+	if err := pw.write(meta); err != nil {
+		return err
+	}
+	var size [4]byte
+	binary.LittleEndian.PutUint32(size[:], uint32(len(meta)))
+	if err := pw.write(size[:]); err != nil {
+		return err
+	}
+	return pw.write([]byte(magic))
+}
+
+// Export writes the keys stored in the DB within the Start and End bounds of opts to w as a Parquet file.
+// The flags of the keys are exported as well if the DB stores record flags.
+// The DB iterates keys in an unspecified order, the bounds filter the keys but don't sort them.
+// It returns the number of exported keys.
+func Export(w io.Writer, db *pogreb.DB, opts *Options) (int64, error) {
+	pw := NewWriter(w, opts)
+	if db.RecordFlags() {
+		pw.opts.Flags = true
+	}
+	it := db.Items()
+	for {
+		key, flags, err := it.NextWithFlags()
+		if err == pogreb.ErrIterationDone {
+			break
+		}
+		if err != nil {
+			return pw.numRows, err
+		}
+		if !pw.opts.inRange(key) {
+			continue
+		}
+		if err := pw.WriteWithFlags(key, flags); err != nil {
+			return pw.numRows, err
+		}
+	}
+	return pw.numRows, pw.Close()
+}
@@ -0,0 +1,325 @@
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/domaincrawler/pogreb"
+	"github.com/domaincrawler/pogreb/fs"
+	"github.com/domaincrawler/pogreb/internal/assert"
+)
+
+const testDBName = "test.db"
+
+func openTestDB(t *testing.T, opts *pogreb.Options) *pogreb.DB {
+	t.Helper()
+	fsys := fs.Sub(fs.Mem, testDBName)
+	files, err := fsys.ReadDir(".")
+	assert.Nil(t, err)
+	for _, file := range files {
+		_ = fsys.Remove(file.Name())
+	}
+	if opts == nil {
+		opts = &pogreb.Options{}
+	}
+	opts.FileSystem = fs.Mem
+	db, err := pogreb.Open(testDBName, opts)
+	assert.Nil(t, err)
+	return db
+}
+
+func checkFile(t *testing.T, data []byte) {
+	t.Helper()
+	assert.Equal(t, magic, string(data[:4]))
+	assert.Equal(t, magic, string(data[len(data)-4:]))
+	metaSize := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	if metaSize <= 0 || metaSize > len(data)-12 {
+		t.Fatalf("invalid footer size %d", metaSize)
+	}
+}
+
+func TestExportEmpty(t *testing.T) {
+	db := openTestDB(t, nil)
+	buf := &bytes.Buffer{}
+	n, err := Export(buf, db, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), n)
+	checkFile(t, buf.Bytes())
+	assert.Nil(t, db.Close())
+}
+
+func TestExport(t *testing.T) {
+	db := openTestDB(t, nil)
+	keys := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		k := fmt.Sprintf("https://example.com/%d", i)
+		keys[k] = true
+		assert.Nil(t, db.Put([]byte(k)))
+	}
+	buf := &bytes.Buffer{}
+	n, err := Export(buf, db, &Options{RowGroupRows: 30})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(100), n)
+	data := buf.Bytes()
+	checkFile(t, data)
+
+	// Every key is PLAIN-encoded in one of the data pages.
+	for k := range keys {
+		enc := make([]byte, 4+len(k))
+		binary.LittleEndian.PutUint32(enc, uint32(len(k)))
+		copy(enc[4:], k)
+		if !bytes.Contains(data, enc) {
+			t.Fatalf("key %s not found", k)
+		}
+	}
+	assert.Nil(t, db.Close())
+}
+
+func TestWriterRowGroups(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf, &Options{RowGroupRows: 2})
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, w.Write([]byte{byte(i)}))
+	}
+	assert.Nil(t, w.Close())
+	assert.Equal(t, 3, len(w.rowGroups))
+	assert.Equal(t, int64(5), w.numRows)
+	checkFile(t, buf.Bytes())
+}
+
+// thriftReader decodes the subset of the Thrift compact protocol written by thriftWriter.
+type thriftReader struct {
+	t    *testing.T
+	data []byte
+	pos  int
+}
+
+func (tr *thriftReader) varint() uint64 {
+	v, n := binary.Uvarint(tr.data[tr.pos:])
+	if n <= 0 {
+		tr.t.Fatalf("invalid varint at %d", tr.pos)
+	}
+	tr.pos += n
+	return v
+}
+
+func (tr *thriftReader) int() int64 {
+	v := tr.varint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+// readStruct calls fn for every field of a struct, fn must consume the field value by calling value.
+func (tr *thriftReader) readStruct(fn func(id int16, typ byte)) {
+	var lastID int16
+	for {
+		b := tr.data[tr.pos]
+		tr.pos++
+		if b == 0 {
+			return
+		}
+		typ := b & 0x0f
+		id := lastID + int16(b>>4)
+		if b>>4 == 0 {
+			id = int16(tr.int())
+		}
+		lastID = id
+		fn(id, typ)
+	}
+}
+
+// readList returns the element type and the size of a list.
+func (tr *thriftReader) readList() (byte, int) {
+	b := tr.data[tr.pos]
+	tr.pos++
+	size := int(b >> 4)
+	if size == 15 {
+		size = int(tr.varint())
+	}
+	return b & 0x0f, size
+}
+
+func (tr *thriftReader) skip(typ byte) {
+	switch typ {
+	case thriftI32, thriftI64:
+		tr.varint()
+	case thriftBinary:
+		tr.pos += int(tr.varint())
+	case thriftList:
+		elemType, size := tr.readList()
+		for i := 0; i < size; i++ {
+			tr.skip(elemType)
+		}
+	case thriftStruct:
+		tr.readStruct(func(id int16, typ byte) { tr.skip(typ) })
+	default:
+		tr.t.Fatalf("unexpected thrift type %d", typ)
+	}
+}
+
+// readPage returns the number of values and the values of the data page at offset.
+func readPage(t *testing.T, data []byte, offset int64) (int, []byte) {
+	tr := &thriftReader{t: t, data: data, pos: int(offset)}
+	var pageSize, numValues int
+	tr.readStruct(func(id int16, typ byte) {
+		switch id {
+		case 3:
+			pageSize = int(tr.int())
+		case 5:
+			tr.readStruct(func(id int16, typ byte) {
+				if id != 1 {
+					tr.skip(typ)
+					return
+				}
+				numValues = int(tr.int())
+			})
+		default:
+			tr.skip(typ)
+		}
+	})
+	return numValues, data[tr.pos : tr.pos+pageSize]
+}
+
+// readRows reads the keys and the flags back from the data pages listed in the file footer,
+// in the order they were written. The flags are nil if the file has no flags column.
+func readRows(t *testing.T, data []byte) ([]string, []uint16) {
+	t.Helper()
+	checkFile(t, data)
+	metaSize := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	tr := &thriftReader{t: t, data: data[:len(data)-8], pos: len(data) - 8 - metaSize}
+	var offsets [2][]int64 // Offsets of the key and flags pages.
+	var numRows int64
+	tr.readStruct(func(id int16, typ byte) {
+		switch id {
+		case 3:
+			numRows = tr.int()
+		case 4:
+			_, numGroups := tr.readList()
+			for i := 0; i < numGroups; i++ {
+				tr.readStruct(func(id int16, typ byte) {
+					if id != 1 {
+						tr.skip(typ)
+						return
+					}
+					_, numColumns := tr.readList()
+					assert.Equal(t, true, numColumns == 1 || numColumns == 2)
+					for col := 0; col < numColumns; col++ {
+						tr.readStruct(func(id int16, typ byte) {
+							if id != 3 {
+								tr.skip(typ)
+								return
+							}
+							tr.readStruct(func(id int16, typ byte) {
+								if id != 9 {
+									tr.skip(typ)
+									return
+								}
+								offsets[col] = append(offsets[col], tr.int())
+							})
+						})
+					}
+				})
+			}
+		default:
+			tr.skip(typ)
+		}
+	})
+
+	var keys []string
+	for _, offset := range offsets[0] {
+		numValues, page := readPage(t, data, offset)
+		for i := 0; i < numValues; i++ {
+			n := binary.LittleEndian.Uint32(page)
+			keys = append(keys, string(page[4:4+n]))
+			page = page[4+n:]
+		}
+		assert.Equal(t, 0, len(page))
+	}
+	assert.Equal(t, numRows, int64(len(keys)))
+	var flags []uint16
+	for _, offset := range offsets[1] {
+		numValues, page := readPage(t, data, offset)
+		assert.Equal(t, numValues*4, len(page))
+		for i := 0; i < numValues; i++ {
+			flags = append(flags, uint16(binary.LittleEndian.Uint32(page[4*i:])))
+		}
+	}
+	if flags != nil {
+		assert.Equal(t, len(keys), len(flags))
+	}
+	return keys, flags
+}
+
+// readKeys reads the keys back from the file, sorted.
+func readKeys(t *testing.T, data []byte) []string {
+	t.Helper()
+	keys, _ := readRows(t, data)
+	sort.Strings(keys)
+	return keys
+}
+
+func TestExportRange(t *testing.T) {
+	db := openTestDB(t, nil)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put([]byte(fmt.Sprintf("key%02d", i))))
+	}
+	var want []string
+	for i := 20; i < 50; i++ {
+		want = append(want, fmt.Sprintf("key%02d", i))
+	}
+
+	buf := &bytes.Buffer{}
+	n, err := Export(buf, db, &Options{RowGroupRows: 7, Start: []byte("key20"), End: []byte("key50")})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(want)), n)
+	assert.Equal(t, want, readKeys(t, buf.Bytes()))
+
+	// A single bound leaves the other side open.
+	buf.Reset()
+	n, err = Export(buf, db, &Options{Start: []byte("key95")})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5), n)
+	assert.Equal(t, []string{"key95", "key96", "key97", "key98", "key99"}, readKeys(t, buf.Bytes()))
+
+	buf.Reset()
+	n, err = Export(buf, db, &Options{End: []byte("key")})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), n)
+	assert.Equal(t, []string(nil), readKeys(t, buf.Bytes()))
+	assert.Nil(t, db.Close())
+}
+
+func TestExportFlags(t *testing.T) {
+	db := openTestDB(t, &pogreb.Options{RecordFlags: true})
+	want := map[string]uint16{}
+	for i := 0; i < 50; i++ {
+		k := fmt.Sprintf("key%02d", i)
+		want[k] = uint16(i * 1000)
+		assert.Nil(t, db.PutWithFlags([]byte(k), want[k]))
+	}
+
+	buf := &bytes.Buffer{}
+	n, err := Export(buf, db, &Options{RowGroupRows: 7})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(50), n)
+	keys, flags := readRows(t, buf.Bytes())
+	got := map[string]uint16{}
+	for i, k := range keys {
+		got[k] = flags[i]
+	}
+	assert.Equal(t, want, got)
+	assert.Nil(t, db.Close())
+
+	// Files of databases without record flags have no flags column.
+	db = openTestDB(t, nil)
+	assert.Nil(t, db.Put([]byte("a")))
+	buf.Reset()
+	_, err = Export(buf, db, nil)
+	assert.Nil(t, err)
+	keys, flags = readRows(t, buf.Bytes())
+	assert.Equal(t, []string{"a"}, keys)
+	assert.Equal(t, []uint16(nil), flags)
+	assert.Nil(t, db.Close())
+}
@@ -0,0 +1,103 @@
+package parquet
+
+import (
+	"encoding/binary"
+)
+
+// Thrift compact protocol field types.
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter encodes Parquet metadata structures using the Thrift compact protocol.
+type thriftWriter struct {
+	buf     []byte
+	lastIDs []int16 // Stack of last written field IDs for nested structs.
+	lastID  int16
+}
+
+func (tw *thriftWriter) varint(v uint64) {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	tw.buf = append(tw.buf, tmp[:n]...)
+}
+
+func zigzag(v int64) uint64 {
+	return uint64((v << 1) ^ (v >> 63))
+}
+
+func (tw *thriftWriter) fieldHeader(id int16, typ byte) {
+	delta := id - tw.lastID
+	if delta > 0 && delta <= 15 {
+		tw.buf = append(tw.buf, byte(delta)<<4|typ)
+	} else {
+		tw.buf = append(tw.buf, typ)
+		tw.varint(zigzag(int64(id)))
+	}
+	tw.lastID = id
+}
+
+func (tw *thriftWriter) i32(id int16, v int32) {
+	tw.fieldHeader(id, thriftI32)
+	tw.varint(zigzag(int64(v)))
+}
+
+func (tw *thriftWriter) i64(id int16, v int64) {
+	tw.fieldHeader(id, thriftI64)
+	tw.varint(zigzag(v))
+}
+
+func (tw *thriftWriter) binary(id int16, v []byte) {
+	tw.fieldHeader(id, thriftBinary)
+	tw.varint(uint64(len(v)))
+	tw.buf = append(tw.buf, v...)
+}
+
+func (tw *thriftWriter) listHeader(id int16, elemType byte, size int) {
+	tw.fieldHeader(id, thriftList)
+	if size < 15 {
+		tw.buf = append(tw.buf, byte(size)<<4|elemType)
+	} else {
+		tw.buf = append(tw.buf, 0xf0|elemType)
+		tw.varint(uint64(size))
+	}
+}
+
+// listI32 writes list elements without field headers.
+func (tw *thriftWriter) listI32(id int16, v []int32) {
+	tw.listHeader(id, thriftI32, len(v))
+	for _, x := range v {
+		tw.varint(zigzag(int64(x)))
+	}
+}
+
+func (tw *thriftWriter) listBinary(id int16, v []string) {
+	tw.listHeader(id, thriftBinary, len(v))
+	for _, x := range v {
+		tw.varint(uint64(len(x)))
+		tw.buf = append(tw.buf, x...)
+	}
+}
+
+// beginStruct starts a struct which is a field of the current struct.
+func (tw *thriftWriter) beginStruct(id int16) {
+	tw.fieldHeader(id, thriftStruct)
+	tw.beginListStruct()
+}
+
+// beginListStruct starts a struct which is an element of a list.
+func (tw *thriftWriter) beginListStruct() {
+	tw.lastIDs = append(tw.lastIDs, tw.lastID)
+	tw.lastID = 0
+}
+
+func (tw *thriftWriter) endStruct() {
+	tw.buf = append(tw.buf, 0) // Stop field.
+	n := len(tw.lastIDs) - 1
+	tw.lastID = tw.lastIDs[n]
+	tw.lastIDs = tw.lastIDs[:n]
+}
@@ -0,0 +1,230 @@
+package pogrebpb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/domaincrawler/pogreb"
+)
+
+// maxResponseSize is the maximum size of a response message, an Items response holds at least one key.
+const maxResponseSize = defaultMaxMessageSize + itemsBatchBytes
+
+var errIteratorClosed = errors.New("iterator closed")
+
+// Client calls the Pogreb service. It's safe for concurrent use by multiple goroutines,
+// the calls are multiplexed over the HTTP/2 connections of the http.Client.
+type Client struct {
+	url string
+	hc  *http.Client
+}
+
+// NewClient returns a client of the service at the base URL, e.g. "https://dedup:8443".
+// The http.Client must use HTTP/2, which the client of the net/http package does for https URLs
+// unless its Transport is customized without setting ForceAttemptHTTP2. A nil http.Client is http.DefaultClient.
+func NewClient(baseURL string, hc *http.Client) *Client {
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	return &Client{url: strings.TrimSuffix(baseURL, "/") + servicePath, hc: hc}
+}
+
+// call sends the request message and returns the response, whose body is positioned at the first response message.
+func (c *Client) call(ctx context.Context, method string, msg []byte) (*http.Response, error) {
+	var body bytes.Buffer
+	if err := writeMessage(&body, msg); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url+method, &body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", grpcContentType)
+	req.Header.Set("Te", "trailers")
+	resp, err := c.hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK || !isGRPCContentType(resp.Header.Get("Content-Type")) {
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("pogrebpb: unexpected response %s of %s", resp.Status, resp.Proto)
+	}
+	if status := resp.Header.Get(trailerStatus); status != "" {
+		// A trailers-only response of an error.
+		_ = resp.Body.Close()
+		return nil, errorFromTrailers(status, resp.Header.Get(trailerMessage), resp.Header.Get(trailerErrorCode))
+	}
+	return resp, nil
+}
+
+// status returns the error of the trailers of the response, which must have been read to the end.
+func status(resp *http.Response) error {
+	t := resp.Trailer
+	return errorFromTrailers(t.Get(trailerStatus), t.Get(trailerMessage), t.Get(trailerErrorCode))
+}
+
+// unary calls the method and returns the response message.
+func (c *Client) unary(method string, msg []byte) ([]byte, error) {
+	resp, err := c.call(context.Background(), method, msg)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	res, err := readMessage(resp.Body, maxResponseSize)
+	if err == io.EOF {
+		// The call failed before sending a response.
+		if err := status(resp); err != nil {
+			return nil, err
+		}
+		return nil, io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		return nil, err
+	}
+	if err := status(resp); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Put adds the key.
+func (c *Client) Put(key []byte) error {
+	_, err := c.unary("Put", encodeKeys([][]byte{key}))
+	return err
+}
+
+// Has returns true if the key is stored.
+func (c *Client) Has(key []byte) (bool, error) {
+	res, err := c.unary("Has", encodeKeys([][]byte{key}))
+	if err != nil {
+		return false, err
+	}
+	found, err := decodeUint(res)
+	return found != 0, err
+}
+
+// HasOrPut adds the key if it isn't stored, it returns true if the key was stored before.
+func (c *Client) HasOrPut(key []byte) (bool, error) {
+	found, err := c.HasOrPutBatch([][]byte{key})
+	if err != nil {
+		return false, err
+	}
+	return found[0], nil
+}
+
+// HasOrPutBatch adds the keys which aren't stored in a single call, it returns for every key
+// whether it was stored before. If an error is returned, the keys before the failed key may have been added.
+func (c *Client) HasOrPutBatch(keys [][]byte) ([]bool, error) {
+	res, err := c.unary("HasOrPut", encodeKeys(keys))
+	if err != nil {
+		return nil, err
+	}
+	found, err := decodeBools(res)
+	if err != nil {
+		return nil, err
+	}
+	if len(found) != len(keys) {
+		return nil, fmt.Errorf("pogrebpb: %d results for %d keys", len(found), len(keys))
+	}
+	return found, nil
+}
+
+// Count returns the number of keys, or 0 if the call fails, see CountKeys.
+func (c *Client) Count() uint64 {
+	n, _ := c.CountKeys()
+	return n
+}
+
+// CountKeys returns the number of keys.
+func (c *Client) CountKeys() (uint64, error) {
+	res, err := c.unary("Count", nil)
+	if err != nil {
+		return 0, err
+	}
+	return decodeUint(res)
+}
+
+// Close closes the idle connections of the http.Client.
+func (c *Client) Close() error {
+	c.hc.CloseIdleConnections()
+	return nil
+}
+
+// Iterator iterates over the keys streamed by the service.
+type Iterator struct {
+	c         *Client
+	batchSize uint32
+	resp      *http.Response
+	cancel    context.CancelFunc
+	keys      [][]byte
+	err       error
+}
+
+// Items returns an iterator over the keys, which are received in batches of at most batchSize keys.
+// The server chooses the size of the batches if batchSize is 0.
+func (c *Client) Items(batchSize uint32) *Iterator {
+	return &Iterator{c: c, batchSize: batchSize}
+}
+
+// Next returns the next key, or pogreb.ErrIterationDone when there are no more keys.
+func (it *Iterator) Next() ([]byte, error) {
+	for len(it.keys) == 0 {
+		if it.err != nil {
+			return nil, it.err
+		}
+		it.err = it.fetch()
+	}
+	key := it.keys[0]
+	it.keys = it.keys[1:]
+	return key, nil
+}
+
+// fetch reads the next response message, starting the call first.
+func (it *Iterator) fetch() error {
+	if it.resp == nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		resp, err := it.c.call(ctx, "Items", encodeUint(uint64(it.batchSize)))
+		if err != nil {
+			cancel()
+			return err
+		}
+		it.resp, it.cancel = resp, cancel
+	}
+	msg, err := readMessage(it.resp.Body, maxResponseSize)
+	if err == io.EOF {
+		err = status(it.resp)
+		it.Close()
+		if err != nil {
+			return err
+		}
+		return pogreb.ErrIterationDone
+	}
+	if err == nil {
+		it.keys, err = decodeKeys(msg)
+	}
+	if err != nil {
+		it.Close()
+	}
+	return err
+}
+
+// Close cancels the call of an unfinished iterator.
+func (it *Iterator) Close() {
+	if it.resp != nil {
+		it.cancel()
+		_ = it.resp.Body.Close()
+		it.resp = nil
+	}
+	it.keys = nil
+	if it.err == nil {
+		it.err = errIteratorClosed
+	}
+}
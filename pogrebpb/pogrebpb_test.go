@@ -0,0 +1,157 @@
+package pogrebpb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+
+	"github.com/domaincrawler/pogreb"
+	"github.com/domaincrawler/pogreb/fs"
+	"github.com/domaincrawler/pogreb/internal/assert"
+)
+
+func startTestServer(t *testing.T, path string, opts *ServerOptions) (*pogreb.DB, *Client, func()) {
+	t.Helper()
+	fsys := fs.Sub(fs.Mem, path)
+	files, err := fsys.ReadDir(".")
+	assert.Nil(t, err)
+	for _, file := range files {
+		_ = fsys.Remove(file.Name())
+	}
+	db, err := pogreb.Open(path, &pogreb.Options{FileSystem: fs.Mem})
+	assert.Nil(t, err)
+	srv := NewServer(db, opts)
+	ts := httptest.NewUnstartedServer(srv)
+	ts.Config.ConnContext = srv.ConnContext
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	c := NewClient(ts.URL, ts.Client())
+	return db, c, func() {
+		assert.Nil(t, c.Close())
+		ts.Close()
+		assert.Nil(t, db.Close())
+	}
+}
+
+func TestClient(t *testing.T) {
+	_, c, stop := startTestServer(t, "client.db", nil)
+	defer stop()
+
+	var store KeyStore = c
+	assert.Nil(t, store.Put([]byte("a")))
+	found, err := store.Has([]byte("a"))
+	assert.Nil(t, err)
+	assert.Equal(t, true, found)
+	found, err = store.HasOrPut([]byte("b"))
+	assert.Nil(t, err)
+	assert.Equal(t, false, found)
+	batch, err := c.HasOrPutBatch([][]byte{[]byte("a"), []byte("c"), []byte("c"), {}})
+	assert.Nil(t, err)
+	assert.Equal(t, []bool{true, false, true, false}, batch)
+	assert.Equal(t, uint64(4), store.Count())
+
+	// Errors of the DB keep their codes.
+	err = c.Put(make([]byte, pogreb.MaxKeyLength+1))
+	var e *Error
+	assert.Equal(t, true, errors.As(err, &e))
+	assert.Equal(t, InvalidArgument, e.Status)
+	assert.Equal(t, true, errors.Is(err, pogreb.ErrKeyTooLarge))
+	_, err = c.unary("Get", nil)
+	assert.Equal(t, true, errors.As(err, &e))
+	assert.Equal(t, Unimplemented, e.Status)
+}
+
+func TestClientItems(t *testing.T) {
+	db, c, stop := startTestServer(t, "items.db", &ServerOptions{MaxBatchKeys: 100, MaxConcurrentCalls: 1})
+	defer stop()
+	var want []string
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("https://example.com/%d", i)
+		want = append(want, key)
+		assert.Nil(t, db.Put([]byte(key)))
+	}
+
+	var got []string
+	it := c.Items(0)
+	for {
+		key, err := it.Next()
+		if err == pogreb.ErrIterationDone {
+			break
+		}
+		assert.Nil(t, err)
+		got = append(got, string(key))
+	}
+	sort.Strings(got)
+	sort.Strings(want)
+	assert.Equal(t, want, got)
+
+	// An abandoned stream releases its call slot.
+	it = c.Items(10)
+	_, err := it.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, 9, len(it.keys))
+	it.Close()
+	_, err = it.Next()
+	assert.Equal(t, errIteratorClosed, err)
+	assert.Equal(t, uint64(1000), c.Count())
+
+	_, err = c.HasOrPutBatch(make([][]byte, 101))
+	var e *Error
+	assert.Equal(t, true, errors.As(err, &e))
+	assert.Equal(t, ResourceExhausted, e.Status)
+}
+
+func TestConnQuota(t *testing.T) {
+	db, c, stop := startTestServer(t, "quota.db", &ServerOptions{ConnQuota: pogreb.IterationQuota{MaxKeys: 25}})
+	defer stop()
+	for i := 0; i < 20; i++ {
+		assert.Nil(t, db.Put([]byte(fmt.Sprintf("key%d", i))))
+	}
+
+	count := func() (int, error) {
+		n := 0
+		it := c.Items(0)
+		for {
+			_, err := it.Next()
+			if err != nil {
+				return n, err
+			}
+			n++
+		}
+	}
+	n, err := count()
+	assert.Equal(t, pogreb.ErrIterationDone, err)
+	assert.Equal(t, 20, n)
+
+	// The calls of the connection share the quota.
+	n, err = count()
+	var e *Error
+	assert.Equal(t, true, errors.As(err, &e))
+	assert.Equal(t, ResourceExhausted, e.Status)
+	assert.Equal(t, true, errors.Is(err, pogreb.ErrQuotaExceeded))
+	assert.Equal(t, 5, n)
+	n, err = count()
+	assert.Equal(t, pogreb.CodeQuotaExceeded, pogreb.ErrorCodeOf(err))
+	assert.Equal(t, 0, n)
+	assert.Equal(t, uint64(20), c.Count())
+}
+
+func TestServerRejectsHTTP1(t *testing.T) {
+	ts := httptest.NewServer(NewServer(nil, nil))
+	defer ts.Close()
+	resp, err := http.Post(ts.URL+servicePath+"Count", grpcContentType, bytes.NewReader(make([]byte, 5)))
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnsupportedMediaType, resp.StatusCode)
+}
+
+func TestStatusMessage(t *testing.T) {
+	msg := "key \"a\" 100% blocked\n\xff"
+	enc := encodeStatusMessage(msg)
+	assert.Equal(t, "key \"a\" 100%25 blocked%0A%FF", enc)
+	assert.Equal(t, msg, decodeStatusMessage(enc))
+}
@@ -0,0 +1,274 @@
+/*
+Package pogrebpb implements the gRPC service of pogreb.proto, giving remote processes,
+e.g. crawl workers of a cluster, access to a central database.
+
+Server is an http.Handler serving the service over HTTP/2, e.g. with http.Server.ServeTLS,
+and Client calls it with an http.Client. The messages are encoded without generated code,
+any gRPC client generated from pogreb.proto can call the server.
+*/
+package pogrebpb
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/domaincrawler/pogreb"
+	"github.com/domaincrawler/pogreb/server"
+)
+
+const (
+	servicePath = "/pogrebpb.Pogreb/"
+
+	defaultMaxMessageSize = pogreb.MaxLargeKeyLength + 64
+	defaultMaxBatchKeys   = 1 << 16
+	defaultItemsBatchSize = 1024
+	itemsBatchBytes       = 64 << 10 // Size of the message after which an Items response is sent.
+
+	grpcContentType       = "application/grpc"
+	grpcContentTypePrefix = grpcContentType + "+"
+)
+
+// KeyStore is the interface shared by a DB and its remote clients,
+// code written against it works with a local and a remote database.
+type KeyStore interface {
+	server.DB
+}
+
+var (
+	_ KeyStore = (*pogreb.DB)(nil)
+	_ KeyStore = (*server.Client)(nil)
+	_ KeyStore = (*Client)(nil)
+)
+
+// ServerOptions holds the limits of a Server.
+type ServerOptions struct {
+	// MaxConcurrentCalls is the number of calls executed concurrently, further calls wait
+	// until a call completes or their context is done.
+	//
+	// Default: unlimited.
+	MaxConcurrentCalls int
+
+	// MaxBatchKeys is the maximum number of keys of a HasOrPut call and of an Items response.
+	//
+	// Default: 65536.
+	MaxBatchKeys int
+
+	// MaxMessageSize is the maximum size of a request message.
+	//
+	// Default: the size of the largest key, see pogreb.MaxLargeKeyLength.
+	MaxMessageSize int
+
+	// ConnQuota limits the Items calls of a connection: MaxKeys and MaxBytes limit the keys returned
+	// by all calls of the connection together, MaxDuration limits every call.
+	// A call exceeding the quota fails with pogreb.ErrQuotaExceeded. The connections are tracked by
+	// Server.ConnContext, which must be set as the ConnContext of the http.Server, otherwise the quota
+	// limits every call on its own.
+	//
+	// Default: unlimited.
+	ConnQuota pogreb.IterationQuota
+}
+
+// connUsageKey is the context key of the quota usage of a connection.
+type connUsageKey struct{}
+
+// Server serves the Pogreb service of a DB. The DB isn't closed by the server.
+type Server struct {
+	db    *pogreb.DB
+	opts  ServerOptions
+	calls chan struct{} // Slots of the concurrent calls, nil if they are unlimited.
+}
+
+// NewServer returns a server of the DB. The options may be nil.
+func NewServer(db *pogreb.DB, opts *ServerOptions) *Server {
+	s := &Server{db: db}
+	if opts != nil {
+		s.opts = *opts
+	}
+	if s.opts.MaxBatchKeys <= 0 {
+		s.opts.MaxBatchKeys = defaultMaxBatchKeys
+	}
+	if s.opts.MaxMessageSize <= 0 {
+		s.opts.MaxMessageSize = defaultMaxMessageSize
+	}
+	if s.opts.MaxConcurrentCalls > 0 {
+		s.calls = make(chan struct{}, s.opts.MaxConcurrentCalls)
+	}
+	return s
+}
+
+// ConnContext returns the context of a new connection, which tracks the usage of ServerOptions.ConnQuota.
+// It must be set as the ConnContext of the http.Server.
+func (s *Server) ConnContext(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connUsageKey{}, &pogreb.QuotaUsage{})
+}
+
+// ServeHTTP serves a gRPC call. The request must use HTTP/2.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.ProtoMajor != 2 || r.Method != http.MethodPost || !isGRPCContentType(r.Header.Get("Content-Type")) {
+		http.Error(w, "gRPC requests must be HTTP/2 POST requests with the application/grpc content type",
+			http.StatusUnsupportedMediaType)
+		return
+	}
+	w.Header().Set("Content-Type", grpcContentType)
+	w.Header().Set("Trailer", trailerStatus+", "+trailerMessage+", "+trailerErrorCode)
+	w.WriteHeader(http.StatusOK)
+	s.finish(w, s.serveCall(r.Context(), w, r))
+}
+
+func isGRPCContentType(ct string) bool {
+	return ct == grpcContentType || strings.HasPrefix(ct, grpcContentTypePrefix)
+}
+
+// finish writes the status trailers of the call.
+func (s *Server) finish(w http.ResponseWriter, err error) {
+	if err == nil {
+		w.Header().Set(trailerStatus, "0")
+		return
+	}
+	e := errorOf(err)
+	w.Header().Set(trailerStatus, strconv.FormatUint(uint64(e.Status), 10))
+	w.Header().Set(trailerMessage, encodeStatusMessage(e.Message))
+	w.Header().Set(trailerErrorCode, strconv.Itoa(int(e.Code)))
+}
+
+// serveCall waits for a call slot, reads the request message and executes the method.
+func (s *Server) serveCall(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	if s.calls != nil {
+		select {
+		case s.calls <- struct{}{}:
+			defer func() { <-s.calls }()
+		case <-ctx.Done():
+			return &Error{Status: Canceled, Code: pogreb.CodeUnknown, Message: ctx.Err().Error()}
+		}
+	}
+	msg, err := readMessage(r.Body, s.opts.MaxMessageSize)
+	if err != nil {
+		status := InvalidArgument
+		switch err {
+		case errMessageTooLarge:
+			status = ResourceExhausted
+		case errCompressed:
+			status = Unimplemented
+		}
+		return &Error{Status: status, Code: pogreb.CodeUnknown, Message: err.Error()}
+	}
+	method := strings.TrimPrefix(r.URL.Path, servicePath)
+	if method == r.URL.Path {
+		return unimplemented(r.URL.Path)
+	}
+	switch method {
+	case "Put":
+		key, err := decodeKey(msg)
+		if err != nil {
+			return invalidArgument(err)
+		}
+		if err := s.db.Put(key); err != nil {
+			return err
+		}
+		return writeMessage(w, nil)
+	case "Has":
+		key, err := decodeKey(msg)
+		if err != nil {
+			return invalidArgument(err)
+		}
+		found, err := s.db.Has(key)
+		if err != nil {
+			return err
+		}
+		return writeMessage(w, encodeBool(found))
+	case "HasOrPut":
+		return s.hasOrPut(w, msg)
+	case "Count":
+		return writeMessage(w, encodeUint(s.db.Count()))
+	case "Items":
+		return s.items(ctx, w, msg)
+	}
+	return unimplemented(r.URL.Path)
+}
+
+func invalidArgument(err error) error {
+	return &Error{Status: InvalidArgument, Code: pogreb.CodeUnknown, Message: err.Error()}
+}
+
+func unimplemented(path string) error {
+	return &Error{Status: Unimplemented, Code: pogreb.CodeUnknown, Message: "unknown method " + path}
+}
+
+func (s *Server) hasOrPut(w io.Writer, msg []byte) error {
+	keys, err := decodeKeys(msg)
+	if err != nil {
+		return invalidArgument(err)
+	}
+	if len(keys) > s.opts.MaxBatchKeys {
+		return &Error{Status: ResourceExhausted, Code: pogreb.CodeUnknown,
+			Message: "batch of " + strconv.Itoa(len(keys)) + " keys exceeds " + strconv.Itoa(s.opts.MaxBatchKeys)}
+	}
+	found := make([]bool, len(keys))
+	for i, key := range keys {
+		if found[i], err = s.db.HasOrPut(key); err != nil {
+			return err
+		}
+	}
+	return writeMessage(w, encodeBools(found))
+}
+
+// items streams the keys, flushing every batch, the keys returned before the iteration fails are
+// sent as well. The flush blocks while the flow control window of the stream is exhausted, so the
+// iteration runs at the pace of the client.
+func (s *Server) items(ctx context.Context, w http.ResponseWriter, msg []byte) error {
+	batchSize, err := decodeUint(msg)
+	if err != nil {
+		return invalidArgument(err)
+	}
+	if batchSize == 0 {
+		batchSize = defaultItemsBatchSize
+	}
+	if batchSize > uint64(s.opts.MaxBatchKeys) {
+		batchSize = uint64(s.opts.MaxBatchKeys)
+	}
+	flusher, _ := w.(http.Flusher)
+	send := func(batch []byte) error {
+		if err := writeMessage(w, batch); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return ctx.Err()
+	}
+	quota := s.opts.ConnQuota
+	quota.Usage, _ = ctx.Value(connUsageKey{}).(*pogreb.QuotaUsage)
+	it := s.db.ItemsWithQuota(quota)
+	var batch []byte
+	n := uint64(0)
+	for {
+		key, err := it.Next()
+		if err == pogreb.ErrIterationDone {
+			break
+		}
+		if err != nil {
+			if n > 0 {
+				if err := send(batch); err != nil {
+					return err
+				}
+			}
+			return err
+		}
+		batch = appendKey(batch, key)
+		n++
+		if n == batchSize || len(batch) >= itemsBatchBytes {
+			if err := send(batch); err != nil {
+				return err
+			}
+			batch, n = batch[:0], 0
+		}
+	}
+	if n > 0 {
+		return send(batch)
+	}
+	return nil
+}
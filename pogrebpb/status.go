@@ -0,0 +1,127 @@
+package pogrebpb
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/domaincrawler/pogreb"
+	"github.com/domaincrawler/pogreb/server"
+)
+
+// Code is a gRPC status code.
+type Code uint32
+
+// gRPC status codes returned by the service.
+const (
+	OK                 Code = 0
+	Canceled           Code = 1
+	Unknown            Code = 2
+	InvalidArgument    Code = 3
+	ResourceExhausted  Code = 8
+	FailedPrecondition Code = 9
+	Unimplemented      Code = 12
+	Internal           Code = 13
+	Unavailable        Code = 14
+	DataLoss           Code = 15
+)
+
+// Trailers of a gRPC response. Pogreb-Error-Code holds the pogreb.ErrorCode of the error.
+const (
+	trailerStatus    = "Grpc-Status"
+	trailerMessage   = "Grpc-Message"
+	trailerErrorCode = "Pogreb-Error-Code"
+)
+
+// Error is an error status returned by the service.
+type Error struct {
+	Status  Code             // gRPC status code.
+	Code    pogreb.ErrorCode // Code of the error of the DB, CodeUnknown for errors of the service.
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("pogrebpb: status %d: %s", e.Status, e.Message)
+}
+
+// Unwrap returns the exported pogreb error of the code, so that errors.Is and pogreb.ErrorCodeOf
+// recognize remote errors, or nil if pogreb exports no error with the code.
+func (e *Error) Unwrap() error {
+	return (&server.Error{Code: e.Code}).Unwrap()
+}
+
+// statusOf returns the gRPC status code of the error of the DB.
+func statusOf(code pogreb.ErrorCode) Code {
+	switch code {
+	case pogreb.CodeKeyTooLarge, pogreb.CodeBlocked:
+		return InvalidArgument
+	case pogreb.CodeFull, pogreb.CodeDiskFull, pogreb.CodeQuotaExceeded:
+		return ResourceExhausted
+	case pogreb.CodeReadOnly, pogreb.CodeMismatch:
+		return FailedPrecondition
+	case pogreb.CodeBusy, pogreb.CodeLocked, pogreb.CodeClosed:
+		return Unavailable
+	case pogreb.CodeCorrupted:
+		return DataLoss
+	case pogreb.CodeUnsupported:
+		return Unimplemented
+	}
+	return Unknown
+}
+
+// errorOf returns the error status of the error, which may come from the DB.
+func errorOf(err error) *Error {
+	if e, ok := err.(*Error); ok {
+		return e
+	}
+	code := pogreb.ErrorCodeOf(err)
+	return &Error{Status: statusOf(code), Code: code, Message: err.Error()}
+}
+
+// errorFromTrailers returns the error of the status trailers, or nil if the status is OK.
+func errorFromTrailers(status, message, code string) error {
+	if status == "" {
+		return &Error{Status: Internal, Code: pogreb.CodeUnknown, Message: "missing grpc-status"}
+	}
+	s, err := strconv.ParseUint(status, 10, 32)
+	if err != nil {
+		return &Error{Status: Internal, Code: pogreb.CodeUnknown, Message: "malformed grpc-status " + status}
+	}
+	if s == uint64(OK) {
+		return nil
+	}
+	c, err := strconv.Atoi(code)
+	if err != nil {
+		c = int(pogreb.CodeUnknown)
+	}
+	return &Error{Status: Code(s), Code: pogreb.ErrorCode(c), Message: decodeStatusMessage(message)}
+}
+
+// encodeStatusMessage percent-encodes the bytes of the message which aren't printable ASCII, as gRPC requires.
+func encodeStatusMessage(msg string) string {
+	var b strings.Builder
+	for i := 0; i < len(msg); i++ {
+		c := msg[i]
+		if c < ' ' || c > '~' || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+func decodeStatusMessage(msg string) string {
+	var b strings.Builder
+	for i := 0; i < len(msg); i++ {
+		if msg[i] == '%' && i+2 < len(msg) {
+			if v, err := strconv.ParseUint(msg[i+1:i+3], 16, 8); err == nil {
+				b.WriteByte(byte(v))
+				i += 2
+				continue
+			}
+		}
+		b.WriteByte(msg[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,219 @@
+package pogrebpb
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Protocol buffers wire types.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// Every message of the service has its payload in field 1, see pogreb.proto.
+const payloadField = 1
+
+// messageHeaderSize is the size of the prefix of a gRPC message: the compression flag and the 4-byte big-endian size.
+const messageHeaderSize = 5
+
+var (
+	errTruncatedMessage = errors.New("truncated protocol buffers message")
+	errMessageTooLarge  = errors.New("message exceeds the maximum size")
+	errCompressed       = errors.New("compressed messages aren't supported")
+)
+
+func appendVarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+func appendTag(b []byte, field, wireType int) []byte {
+	return appendVarint(b, uint64(field)<<3|uint64(wireType))
+}
+
+// encodeKeys encodes the keys as the repeated bytes field, which is also the encoding of a single bytes field.
+func encodeKeys(keys [][]byte) []byte {
+	size := 0
+	for _, key := range keys {
+		size += 1 + binary.MaxVarintLen32 + len(key)
+	}
+	b := make([]byte, 0, size)
+	for _, key := range keys {
+		b = appendKey(b, key)
+	}
+	return b
+}
+
+// appendKey appends an element of the repeated bytes field.
+func appendKey(b, key []byte) []byte {
+	b = appendTag(b, payloadField, wireBytes)
+	b = appendVarint(b, uint64(len(key)))
+	return append(b, key...)
+}
+
+// encodeBools encodes the repeated bool field packed.
+func encodeBools(vs []bool) []byte {
+	b := appendTag(nil, payloadField, wireBytes)
+	b = appendVarint(b, uint64(len(vs)))
+	for _, v := range vs {
+		if v {
+			b = append(b, 1)
+		} else {
+			b = append(b, 0)
+		}
+	}
+	return b
+}
+
+// encodeUint encodes the integer or bool field, which is omitted if it's 0.
+func encodeUint(v uint64) []byte {
+	if v == 0 {
+		return nil
+	}
+	return appendVarint(appendTag(nil, payloadField, wireVarint), v)
+}
+
+func encodeBool(v bool) []byte {
+	if v {
+		return encodeUint(1)
+	}
+	return nil
+}
+
+// readFields calls fn for every field of the message. The value of a varint field is v,
+// the value of a length-delimited field is b, which is part of the message.
+func readFields(msg []byte, fn func(field, wireType int, v uint64, b []byte) error) error {
+	for len(msg) > 0 {
+		tag, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return errTruncatedMessage
+		}
+		msg = msg[n:]
+		field, wireType := int(tag>>3), int(tag&7)
+		var v uint64
+		var b []byte
+		switch wireType {
+		case wireVarint:
+			if v, n = binary.Uvarint(msg); n <= 0 {
+				return errTruncatedMessage
+			}
+			msg = msg[n:]
+		case wireFixed64, wireFixed32:
+			size := 8
+			if wireType == wireFixed32 {
+				size = 4
+			}
+			if len(msg) < size {
+				return errTruncatedMessage
+			}
+			msg = msg[size:]
+		case wireBytes:
+			size, n := binary.Uvarint(msg)
+			if n <= 0 || uint64(len(msg)-n) < size {
+				return errTruncatedMessage
+			}
+			b = msg[n : n+int(size)]
+			msg = msg[n+int(size):]
+		default:
+			return errors.New("unsupported protocol buffers wire type")
+		}
+		if err := fn(field, wireType, v, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func decodeKeys(msg []byte) ([][]byte, error) {
+	var keys [][]byte
+	err := readFields(msg, func(field, wireType int, _ uint64, b []byte) error {
+		if field == payloadField && wireType == wireBytes {
+			keys = append(keys, b)
+		}
+		return nil
+	})
+	return keys, err
+}
+
+// decodeKey returns the bytes field, the last one if the message repeats it.
+func decodeKey(msg []byte) ([]byte, error) {
+	keys, err := decodeKeys(msg)
+	if err != nil || len(keys) == 0 {
+		return nil, err
+	}
+	return keys[len(keys)-1], nil
+}
+
+// decodeBools decodes the repeated bool field, packed or not.
+func decodeBools(msg []byte) ([]bool, error) {
+	var vs []bool
+	err := readFields(msg, func(field, wireType int, v uint64, b []byte) error {
+		if field != payloadField {
+			return nil
+		}
+		switch wireType {
+		case wireVarint:
+			vs = append(vs, v != 0)
+		case wireBytes:
+			for len(b) > 0 {
+				v, n := binary.Uvarint(b)
+				if n <= 0 {
+					return errTruncatedMessage
+				}
+				vs = append(vs, v != 0)
+				b = b[n:]
+			}
+		}
+		return nil
+	})
+	return vs, err
+}
+
+func decodeUint(msg []byte) (uint64, error) {
+	var res uint64
+	err := readFields(msg, func(field, wireType int, v uint64, _ []byte) error {
+		if field == payloadField && wireType == wireVarint {
+			res = v
+		}
+		return nil
+	})
+	return res, err
+}
+
+// writeMessage writes the gRPC message: the uncompressed flag, the size and the message.
+func writeMessage(w io.Writer, msg []byte) error {
+	buf := make([]byte, messageHeaderSize, messageHeaderSize+len(msg))
+	binary.BigEndian.PutUint32(buf[1:], uint32(len(msg)))
+	_, err := w.Write(append(buf, msg...))
+	return err
+}
+
+// readMessage returns the next gRPC message, or io.EOF if the stream ended between messages.
+func readMessage(r io.Reader, maxSize int) ([]byte, error) {
+	var hdr [messageHeaderSize]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	if hdr[0] != 0 {
+		return nil, errCompressed
+	}
+	size := binary.BigEndian.Uint32(hdr[1:])
+	if uint64(size) > uint64(maxSize) {
+		return nil, errMessageTooLarge
+	}
+	msg := make([]byte, size)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return msg, nil
+}
@@ -0,0 +1,118 @@
+package pogreb
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// syncWatermark tracks the number of datalog records known to be durable.
+type syncWatermark struct {
+	synced uint64 // Accessed atomically.
+	mu     sync.Mutex
+	closed bool
+	ch     chan struct{} // Closed and replaced every time the watermark advances.
+}
+
+func (w *syncWatermark) load() uint64 {
+	return atomic.LoadUint64(&w.synced)
+}
+
+// changed returns a channel which is closed when the watermark advances or the datalog is closed.
+func (w *syncWatermark) changed() (<-chan struct{}, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.ch == nil {
+		w.ch = make(chan struct{})
+	}
+	return w.ch, w.closed
+}
+
+func (w *syncWatermark) notify() {
+	if w.ch != nil {
+		close(w.ch)
+		w.ch = nil
+	}
+}
+
+func (w *syncWatermark) advance(synced uint64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if synced <= w.load() {
+		return
+	}
+	atomic.StoreUint64(&w.synced, synced)
+	w.notify()
+}
+
+func (w *syncWatermark) close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.closed = true
+	w.notify()
+}
+
+// Promise is returned by PutDeferred. It's resolved once the deferred write is durable.
+type Promise struct {
+	watermark *syncWatermark
+	ticket    uint64
+}
+
+// Done returns true if the write is durable.
+func (p Promise) Done() bool {
+	return p.watermark.load() >= p.ticket
+}
+
+// Wait blocks until the write is durable or the context is done.
+// It returns an error if the DB is closed before the write is synced, e.g. when Close fails.
+func (p Promise) Wait(ctx context.Context) error {
+	for {
+		ch, closed := p.watermark.changed()
+		if p.Done() {
+			return nil
+		}
+		if closed {
+			return errClosed
+		}
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// PutDeferred writes the key without waiting for it to be synced, even if the DB syncs every write.
+// The returned Promise is resolved when the record is made durable by the next sync: an explicit Sync call,
+// the background sync, a sync performed on behalf of another write or the final sync of Close.
+// With the SyncNever policy nothing is synced, the promise is resolved by Sync and Close.
+// It allows pipelining many writes first and then awaiting their durability in bulk.
+func (db *DB) PutDeferred(key []byte) (Promise, error) {
+	key, err := db.checkKey(key)
+	if err != nil {
+		return Promise{}, err
+	}
+	db.metrics.Puts.Add(1)
+	p, err := db.writeDeferred(key)
+	if err != nil {
+		return Promise{}, err
+	}
+	db.writeChain.after(key)
+	return p, nil
+}
+
+func (db *DB) writeDeferred(key []byte) (Promise, error) {
+	h := db.hash(key)
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	shard := db.index.shard(h)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	if err := db.write(shard, h, key); err != nil {
+		return Promise{}, err
+	}
+	return Promise{
+		watermark: &db.datalog.watermark,
+		ticket:    atomic.LoadUint64(&db.datalog.numWrites),
+	}, nil
+}
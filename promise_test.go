@@ -0,0 +1,71 @@
+package pogreb
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/domaincrawler/pogreb/internal/assert"
+)
+
+func TestPutDeferred(t *testing.T) {
+	db, err := createTestDB(&Options{BackgroundSyncInterval: -1})
+	assert.Nil(t, err)
+
+	var promises []Promise
+	for i := 0; i < 10; i++ {
+		p, err := db.PutDeferred([]byte{byte(i)})
+		assert.Nil(t, err)
+		assert.Equal(t, false, p.Done())
+		promises = append(promises, p)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	assert.Equal(t, context.DeadlineExceeded, promises[0].Wait(ctx))
+	cancel()
+
+	// A synced write resolves all previous deferred writes.
+	assert.Nil(t, db.Put([]byte("synced")))
+	for _, p := range promises {
+		assert.Equal(t, true, p.Done())
+		assert.Nil(t, p.Wait(context.Background()))
+	}
+
+	// The final sync of Close resolves the remaining deferred writes.
+	p, err := db.PutDeferred([]byte("unsynced"))
+	assert.Nil(t, err)
+	assert.Equal(t, false, p.Done())
+	assert.Nil(t, db.Close())
+	assert.Nil(t, p.Wait(context.Background()))
+
+	_, err = db.PutDeferred(make([]byte, MaxKeyLength+1))
+	assert.Equal(t, ErrKeyTooLarge, err)
+}
+
+func TestPutDeferredSyncNever(t *testing.T) {
+	db, err := createTestDB(&Options{SyncPolicy: SyncNever})
+	assert.Nil(t, err)
+	p, err := db.PutDeferred([]byte{1})
+	assert.Nil(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	assert.Equal(t, context.DeadlineExceeded, p.Wait(ctx))
+	cancel()
+	assert.Nil(t, db.Sync())
+	assert.Nil(t, p.Wait(context.Background()))
+
+	p, err = db.PutDeferred([]byte{2})
+	assert.Nil(t, err)
+	assert.Nil(t, db.Close())
+	assert.Nil(t, p.Wait(context.Background()))
+}
+
+func TestPutDeferredBackgroundSync(t *testing.T) {
+	db, err := createTestDB(&Options{BackgroundSyncInterval: time.Millisecond})
+	assert.Nil(t, err)
+	p, err := db.PutDeferred([]byte{1})
+	assert.Nil(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	assert.Nil(t, p.Wait(ctx))
+	assert.Nil(t, db.Close())
+}
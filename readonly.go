@@ -0,0 +1,60 @@
+package pogreb
+
+import (
+	"os"
+	"syscall"
+
+	"github.com/domaincrawler/pogreb/fs"
+	"github.com/domaincrawler/pogreb/internal/errors"
+)
+
+// isReadOnlyFS returns whether the error means files can't be created or modified:
+// the file system is mounted read-only or access is denied.
+func isReadOnlyFS(err error) bool {
+	return errors.Is(err, syscall.EROFS) || errors.Is(err, os.ErrPermission) || errors.Is(err, fs.ErrReadOnly)
+}
+
+// readOnlyFSError returns a ReadOnlyFSError if the error means the file system is read-only.
+func readOnlyFSError(path string, err error) error {
+	if isReadOnlyFS(err) {
+		return &ReadOnlyFSError{Path: path, Err: err}
+	}
+	return err
+}
+
+// readOnlyFS opens files of the underlying file system for reading and rejects modifications.
+type readOnlyFS struct {
+	fs.FileSystem
+	replica bool // The database may be in use by a writer publishing manifests.
+}
+
+func (fsys readOnlyFS) OpenFile(name string, flag int, perm os.FileMode) (fs.File, error) {
+	// Missing files aren't created.
+	if _, err := fsys.FileSystem.Stat(name); err != nil {
+		return nil, err
+	}
+	return fsys.FileSystem.OpenFile(name, os.O_RDONLY, perm)
+}
+
+func (fsys readOnlyFS) Remove(name string) error {
+	return errReadOnly
+}
+
+func (fsys readOnlyFS) Rename(oldpath, newpath string) error {
+	return errReadOnly
+}
+
+// CreateLockFile doesn't create a lock file, it fails if the lock file exists, unless the DB is a replica.
+// An existing lock file means the database is in use or it wasn't closed properly.
+func (fsys readOnlyFS) CreateLockFile(name string, perm os.FileMode) (fs.LockFile, bool, error) {
+	if _, err := fsys.FileSystem.Stat(name); err == nil && !fsys.replica {
+		return nil, false, os.ErrExist
+	}
+	return nopLockFile{}, false, nil
+}
+
+type nopLockFile struct{}
+
+func (nopLockFile) Unlock() error {
+	return nil
+}
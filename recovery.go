@@ -3,16 +3,19 @@ package pogreb
 import (
 	"io"
 	"path/filepath"
+	"sync"
+	"time"
 
-	"github.com/domaincrawler/pogreb/fs"
+	"github.com/domaincrawler/pogreb/internal/errors"
 )
 
 const (
 	recoveryBackupExt = ".bac"
 )
 
-func backupNonsegmentFiles(fsys fs.FileSystem) error {
-	logger.Println("moving non-segment files...")
+func backupNonsegmentFiles(opts *Options) error {
+	fsys := opts.FileSystem
+	opts.Logger.Logf(LogInfo, "moving non-segment files...")
 
 	files, err := fsys.ReadDir(".")
 	if err != nil {
@@ -22,21 +25,23 @@ func backupNonsegmentFiles(fsys fs.FileSystem) error {
 	for _, file := range files {
 		name := file.Name()
 		ext := filepath.Ext(name)
-		if ext == segmentExt || name == lockName {
+		// Backups left by an interrupted recovery are kept. The epoch and the replication state survive the recovery.
+		if ext == segmentExt || ext == recoveryBackupExt || name == lockName || name == epochName || name == replicationName {
 			continue
 		}
 		dst := name + recoveryBackupExt
 		if err := fsys.Rename(name, dst); err != nil {
 			return err
 		}
-		logger.Printf("moved %s to %s", name, dst)
+		opts.Logger.Logf(LogInfo, "moved %s to %s", name, dst)
 	}
 
 	return nil
 }
 
-func removeRecoveryBackupFiles(fsys fs.FileSystem) error {
-	logger.Println("removing recovery backup files...")
+func removeRecoveryBackupFiles(opts *Options) error {
+	fsys := opts.FileSystem
+	opts.Logger.Logf(LogInfo, "removing recovery backup files...")
 
 	files, err := fsys.ReadDir(".")
 	if err != nil {
@@ -52,89 +57,192 @@ func removeRecoveryBackupFiles(fsys fs.FileSystem) error {
 		if err := fsys.Remove(name); err != nil {
 			return err
 		}
-		logger.Printf("removed %s", name)
+		opts.Logger.Logf(LogInfo, "removed %s", name)
 	}
 
 	return nil
 }
 
-// recoveryIterator iterates over records of all datalog segments in insertion order.
-// Corrupted segments are truncated to the last valid record.
-type recoveryIterator struct {
-	segments []*segment
-	segit    *segmentIterator
-}
+const recoveryBatchSize = 1024
 
-func newRecoveryIterator(segments []*segment) *recoveryIterator {
-	return &recoveryIterator{
-		segments: segments,
-	}
+// segmentScan delivers records of a segment read by a recovery worker.
+type segmentScan struct {
+	seg     *segment
+	offset  uint64 // Offset of the first record to read.
+	batches chan []record
+	err     error // Scanning error, set before batches is closed.
+	logger  Logger
 }
 
-func (it *recoveryIterator) next() (record, error) {
-	for {
-		if it.segit == nil {
-			if len(it.segments) == 0 {
-				return record{}, ErrIterationDone
-			}
-			var err error
-			it.segit, err = newSegmentIterator(it.segments[0])
-			if err != nil {
-				return record{}, err
-			}
-			it.segments = it.segments[1:]
+// scan reads the segment records in batches.
+// A corrupted segment is truncated to the last valid record.
+func (s *segmentScan) scan(done <-chan struct{}) error {
+	it, err := newSegmentIteratorAt(s.seg, s.offset)
+	if err != nil {
+		return err
+	}
+	send := func(batch []record) bool {
+		select {
+		case s.batches <- batch:
+			return true
+		case <-done:
+			return false
 		}
-		rec, err := it.segit.next()
-		if err == io.EOF || err == io.ErrUnexpectedEOF || err == errCorrupted {
+	}
+	batch := make([]record, 0, recoveryBatchSize)
+	for {
+		rec, err := it.next()
+		if err == io.EOF || err == io.ErrUnexpectedEOF || errors.Is(err, ErrCorrupted) {
 			// Truncate file to the last valid offset.
-			if err := it.segit.f.Truncate(int64(it.segit.offset)); err != nil {
-				return record{}, err
-			}
-			fi, fierr := it.segit.f.Stat()
-			if fierr != nil {
-				return record{}, fierr
+			if err := s.seg.Truncate(int64(it.offset)); err != nil {
+				return err
 			}
-			logger.Printf("truncated segment %s to offset %d", fi.Name(), it.segit.offset)
+			s.seg.size = int64(it.offset)
+			s.logger.Logf(LogWarn, "truncated segment %s to offset %d", s.seg.name, it.offset)
 			err = ErrIterationDone
 		}
 		if err == ErrIterationDone {
-			it.segit = nil
-			continue
+			break
 		}
 		if err != nil {
-			return record{}, err
+			return err
+		}
+		batch = append(batch, rec)
+		if len(batch) == recoveryBatchSize {
+			if !send(batch) {
+				return nil
+			}
+			batch = make([]record, 0, recoveryBatchSize)
 		}
-		return rec, nil
 	}
+	if len(batch) > 0 {
+		send(batch)
+	}
+	return nil
 }
 
-func (db *DB) recover() error {
-	logger.Println("started recovery")
-	logger.Println("rebuilding index...")
+// scanSegments reads segments concurrently, at most concurrency segments at a time.
+// Scans are started in the order of segments, which guarantees progress when they are consumed in the same order.
+// Closing done stops the scans, the returned function waits for them to exit.
+func scanSegments(scans []*segmentScan, concurrency int, done <-chan struct{}) (wait func()) {
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		sem := make(chan struct{}, concurrency)
+		for _, s := range scans {
+			select {
+			case sem <- struct{}{}:
+			case <-done:
+				return
+			}
+			wg.Add(1)
+			go func(s *segmentScan) {
+				defer wg.Done()
+				s.err = s.scan(done)
+				close(s.batches)
+				<-sem
+			}(s)
+		}
+	}()
+	return wg.Wait
+}
+
+// recover rebuilds the index from the datalog.
+// When the index was restored from a checkpoint, only records written after the checkpoint are replayed.
+// Segments are read in parallel, records are inserted into the index in the order they were written.
+// The partially rebuilt index is checkpointed periodically, so that an interrupted recovery can be resumed.
+func (db *DB) recover(cp *checkpointMeta, report *OpenReport) error {
+	db.opts.Logger.Logf(LogInfo, "started recovery")
+	start := time.Now()
 
 	segments := db.datalog.segmentsBySequenceID()
-	it := newRecoveryIterator(segments)
-	for {
-		rec, err := it.next()
-		if err == ErrIterationDone {
-			break
+	var scans []*segmentScan
+	var done, total int64
+	for _, seg := range segments {
+		total += seg.size - headerSize
+		s := &segmentScan{
+			seg:     seg,
+			offset:  headerSize,
+			batches: make(chan []record, 1),
+			logger:  db.opts.Logger,
 		}
-		if err != nil {
-			return err
+		if cp != nil && seg.sequenceID <= cp.SequenceID {
+			*seg.meta = cp.Segments[seg.name]
+			if seg.sequenceID < cp.SequenceID {
+				done += seg.size - headerSize
+				continue
+			}
+			s.offset = cp.Offset
+			done += int64(cp.Offset) - headerSize
 		}
+		scans = append(scans, s)
+	}
+	report.SegmentsScanned = len(scans)
+	db.events.emit(RecoveryStarted{Segments: len(scans)})
+	if cp != nil {
+		db.checkpointGen = cp.Generation
+		db.opts.Logger.Logf(LogInfo, "replaying records written after the checkpoint...")
+	} else {
+		db.opts.Logger.Logf(LogInfo, "rebuilding index...")
+	}
 
-		h := db.hash(rec.key)
-		meta := db.datalog.segments[rec.segmentID].meta
-		sl := slot{
-			hash:      h,
-			segmentID: rec.segmentID,
-			keySize:   uint16(len(rec.key)),
-			offset:    rec.offset,
+	progress := func() {
+		if db.opts.RecoveryProgress != nil {
+			db.opts.RecoveryProgress(done, total)
 		}
-		if err := db.put(sl, rec.key); err != nil {
-			return err
+	}
+	progress()
+
+	stop := make(chan struct{})
+	wait := scanSegments(scans, db.opts.RecoveryConcurrency, stop)
+	defer wait()
+	defer close(stop)
+
+	var sinceCheckpoint int64
+	for _, s := range scans {
+		meta := s.seg.meta
+		for batch := range s.batches {
+			for _, rec := range batch {
+				h := db.hash(rec.key)
+				if rec.rtype == recordTypeDelete {
+					if _, err := db.del(db.index.shard(h), h, rec.key, false); err != nil {
+						return err
+					}
+					meta.DeleteRecords++
+				} else {
+					sl := slot{
+						hash:      h,
+						segmentID: rec.segmentID,
+						keySize:   slotKeySize(rec.key),
+						offset:    rec.offset,
+					}
+					if err := db.put(db.index.shard(h), sl, rec.key); err != nil {
+						return err
+					}
+					meta.PutRecords++
+				}
+				report.RecordsReplayed++
+				done += int64(len(rec.data))
+				sinceCheckpoint += int64(len(rec.data))
+			}
+			if sinceCheckpoint >= db.opts.recoveryCheckpointBytes {
+				last := batch[len(batch)-1]
+				if err := db.recoveryCheckpoint(s.seg, last.offset+uint64(len(last.data))); err != nil {
+					return err
+				}
+				sinceCheckpoint = 0
+			}
+			progress()
 		}
-		meta.PutRecords++
+		if s.err != nil {
+			return errors.Wrapf(s.err, "reading segment %s", s.seg.name)
+		}
+	}
+	if done != total {
+		// Truncated segments.
+		done = total
+		progress()
 	}
 
 	// Mark all segments except the newest as full.
@@ -142,11 +250,32 @@ func (db *DB) recover() error {
 		segments[i].meta.Full = true
 	}
 
-	if err := removeRecoveryBackupFiles(db.opts.FileSystem); err != nil {
-		logger.Printf("error removing recovery backups files: %v", err)
+	if db.opts.IndexCheckpointInterval == 0 {
+		// Checkpoints taken during the recovery aren't needed anymore.
+		if err := db.removeCheckpoint(); err != nil {
+			return err
+		}
+	}
+
+	if err := removeRecoveryBackupFiles(db.opts); err != nil {
+		db.opts.Logger.Logf(LogError, "error removing recovery backups files: %v", err)
 	}
 
-	logger.Println("successfully recovered database")
+	db.opts.Logger.Logf(LogInfo, "successfully recovered database")
+	db.events.emit(RecoveryFinished{RecordsReplayed: report.RecordsReplayed, Duration: time.Since(start)})
 
 	return nil
 }
+
+// recoveryCheckpoint persists the recovery watermark together with the partially rebuilt index.
+// Records of the segment before the offset and of all older segments are in the index.
+func (db *DB) recoveryCheckpoint(seg *segment, offset uint64) error {
+	if err := seg.Sync(); err != nil {
+		return err
+	}
+	if err := db.writeCheckpoint(seg.sequenceID, offset); err != nil {
+		return errors.Wrap(err, "writing recovery checkpoint")
+	}
+	db.opts.Logger.Logf(LogInfo, "recovery checkpoint at segment %s offset %d", seg.name, offset)
+	return nil
+}
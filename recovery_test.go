@@ -1,5 +1,142 @@
 package pogreb
 
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/domaincrawler/pogreb/internal/assert"
+)
+
+// simulateCrash closes DB files without writing index and segment meta and leaves the lock file behind.
+func simulateCrash(t *testing.T, db *DB) {
+	t.Helper()
+	if db.cancelBgWorker != nil {
+		db.cancelBgWorker()
+	}
+	db.closeWg.Wait()
+	for _, seg := range db.datalog.segmentsBySequenceID() {
+		assert.Nil(t, seg.Close())
+	}
+	for _, sh := range db.index.shards {
+		assert.Nil(t, sh.main.Close())
+		assert.Nil(t, sh.overflow.Close())
+	}
+	assert.Nil(t, db.lock.Unlock())
+	assert.Nil(t, touchFile(testFS, filepath.Join(testDBName, lockName)))
+	db.events.close()
+}
+
+func TestRecoveryParallel(t *testing.T) {
+	opts := &Options{maxSegmentSize: 1024}
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put([]byte{byte(i), byte(i >> 8)}))
+	}
+	// Overwritten keys end up in newer segments.
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put([]byte{byte(i), byte(i >> 8)}))
+	}
+	numSegments := countSegments(t, db)
+	if numSegments < 4 {
+		t.Fatalf("expected multiple segments; got %d", numSegments)
+	}
+	// Partially written record.
+	_, err = db.datalog.curSeg.append([]byte{1, 0})
+	assert.Nil(t, err)
+	simulateCrash(t, db)
+
+	var lastDone, lastTotal int64
+	opts = &Options{
+		FileSystem:          testFS,
+		maxSegmentSize:      1024,
+		RecoveryConcurrency: 3,
+		RecoveryProgress: func(done, total int64) {
+			if done < lastDone {
+				t.Fatalf("progress went back from %d to %d", lastDone, done)
+			}
+			lastDone, lastTotal = done, total
+		},
+	}
+	db, err = Open(testDBName, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1000), db.Count())
+	assert.Equal(t, lastTotal, lastDone)
+	for i := 0; i < 1000; i++ {
+		has, err := db.Has([]byte{byte(i), byte(i >> 8)})
+		assert.Nil(t, err)
+		assert.Equal(t, true, has)
+	}
+
+	// The truncated segment is appended to after the last valid record.
+	assert.Nil(t, db.Put([]byte{1, 2, 3}))
+	assert.Nil(t, db.Close())
+	db, err = Open(testDBName, opts)
+	assert.Nil(t, err)
+	has, err := db.Has([]byte{1, 2, 3})
+	assert.Nil(t, err)
+	assert.Equal(t, true, has)
+	assert.Nil(t, db.Close())
+}
+
+func TestRecoveryResume(t *testing.T) {
+	opts := &Options{maxSegmentSize: 1024}
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put([]byte{byte(i), byte(i >> 8)}))
+	}
+	simulateCrash(t, db)
+
+	// Interrupt the recovery halfway through.
+	errInterrupted := errors.New("interrupted")
+	opts = &Options{
+		FileSystem:              testFS,
+		maxSegmentSize:          1024,
+		recoveryCheckpointBytes: 1000,
+		RecoveryProgress: func(done, total int64) {
+			if done > total/2 {
+				panic(errInterrupted)
+			}
+		},
+	}
+	func() {
+		defer func() {
+			assert.Equal(t, errInterrupted, recover())
+		}()
+		_, _ = Open(testDBName, opts)
+	}()
+	assert.Nil(t, touchFile(testFS, filepath.Join(testDBName, lockName)))
+
+	// The recovery is resumed from the last checkpoint.
+	var firstDone int64 = -1
+	opts.RecoveryProgress = func(done, total int64) {
+		if firstDone == -1 {
+			firstDone = done
+		}
+	}
+	db, err = Open(testDBName, opts)
+	assert.Nil(t, err)
+	if firstDone == 0 {
+		t.Fatal("recovery wasn't resumed")
+	}
+	assert.Equal(t, uint64(1000), db.Count())
+	for i := 0; i < 1000; i++ {
+		has, err := db.Has([]byte{byte(i), byte(i >> 8)})
+		assert.Nil(t, err)
+		assert.Equal(t, true, has)
+	}
+	var putRecords uint32
+	for _, seg := range db.datalog.segmentsBySequenceID() {
+		putRecords += seg.meta.PutRecords
+	}
+	assert.Equal(t, uint32(1000), putRecords)
+	// Checkpoints are disabled, the recovery checkpoint is removed.
+	assert.Equal(t, false, fileExists(filepath.Join(testDBName, checkpointName)))
+	assert.Nil(t, db.Close())
+}
+
 //import (
 //	"path/filepath"
 //	"testing"
@@ -76,7 +213,7 @@ package pogreb
 //			for i = 0; i < 128; i++ {
 //				assert.Nil(t, db.Put([]byte{i}, []byte{i}))
 //			}
-//			assert.Equal(t, uint32(128), db.Count())
+//			assert.Equal(t, uint64(128), db.Count())
 //			assert.Nil(t, db.Close())
 //
 //			// Simulate crash.
@@ -86,12 +223,12 @@ package pogreb
 //
 //			db, err = Open(testDBName, opts)
 //			assert.Nil(t, err)
-//			assert.Equal(t, uint32(128), db.Count())
+//			assert.Equal(t, uint64(128), db.Count())
 //			assert.Nil(t, db.Close())
 //
 //			db, err = Open(testDBName, opts)
 //			assert.Nil(t, err)
-//			assert.Equal(t, uint32(128), db.Count())
+//			assert.Equal(t, uint64(128), db.Count())
 //			for i = 0; i < 128; i++ {
 //				v, err := db.Get([]byte{i})
 //				assert.Nil(t, err)
@@ -108,7 +245,7 @@ package pogreb
 //	assert.Nil(t, err)
 //	assert.Nil(t, db.Put([]byte{1}))
 //	assert.Nil(t, db.Put([]byte{2}))
-//	assert.Equal(t, uint32(1), db.Count())
+//	assert.Equal(t, uint64(1), db.Count())
 //	assert.Nil(t, db.Close())
 //
 //	// Simulate crash.
@@ -117,7 +254,7 @@ package pogreb
 //	db, err = Open(testDBName, opts)
 //	assert.Nil(t, err)
 //
-//	assert.Equal(t, uint32(1), db.Count())
+//	assert.Equal(t, uint64(1), db.Count())
 //
 //	assert.Nil(t, db.Close())
 //}
@@ -172,7 +309,7 @@ package pogreb
 //	assert.Nil(t, err)
 //	assert.Equal(t, []byte{2}, v)
 //
-//	assert.Equal(t, uint32(2), db.Count())
+//	assert.Equal(t, uint64(2), db.Count())
 //
 //	assert.Nil(t, db.Close())
 //
@@ -182,7 +319,7 @@ package pogreb
 //	db, err = Open(testDBName, opts)
 //	assert.Nil(t, err)
 //
-//	assert.Equal(t, uint32(2), db.Count())
+//	assert.Equal(t, uint64(2), db.Count())
 //
 //	v, err = db.Get([]byte{1})
 //	assert.Nil(t, err)
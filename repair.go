@@ -0,0 +1,210 @@
+package pogreb
+
+import (
+	"encoding/binary"
+	"io"
+	"path/filepath"
+
+	"github.com/domaincrawler/pogreb/internal/errors"
+)
+
+const repairTmpExt = ".tmp"
+
+// RepairReport holds the result of Repair.
+type RepairReport struct {
+	Segments         int   // Number of scanned segments.
+	RepairedSegments int   // Number of segments rewritten without the corrupted data.
+	Records          int   // Number of salvaged records.
+	DroppedRecords   int   // Number of dropped records. A run of adjacent unreadable records is counted once.
+	DroppedBytes     int64 // Amount of dropped segment data in bytes.
+}
+
+// Repair salvages readable records of a corrupted DB.
+// Corrupted data found in segments is skipped, the scan resynchronizes on the next record with a valid checksum.
+// Segments with corrupted data are rewritten and the index is rebuilt from the salvaged records.
+// The DB must not be open while it's being repaired.
+func Repair(path string, opts *Options) (*RepairReport, error) {
+	report := &RepairReport{}
+	o := Options{}
+	if opts != nil {
+		o = *opts
+	}
+	o.Shared = false
+	o.ReadOnly = false
+	o.repair = report
+	db, err := open(path, &o)
+	if err != nil {
+		return report, err
+	}
+	return report, db.Close()
+}
+
+// decodeRecordSize returns the size of the valid record of the segment file at the start of data.
+func decodeRecordSize(data []byte, f *file) (uint32, error) {
+	if len(data) < 2 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	keySize := uint32(binary.LittleEndian.Uint16(data[:2]))
+	size := encodedRecordSize(keySize) + f.recordOverhead()
+	if keySize == largeKeyMarker && f.flags&headerFlagLargeKeys != 0 {
+		if len(data) < largeKeyHeaderSize {
+			return 0, io.ErrUnexpectedEOF
+		}
+		keySize = binary.LittleEndian.Uint32(data[2:6]) &^ deleteRecordFlag
+		if keySize > MaxLargeKeyLength {
+			return 0, ErrCorrupted
+		}
+		size = largeKeyHeaderSize + keySize + f.recordOverhead() + 4
+	}
+	if uint64(len(data)) < uint64(size) {
+		return 0, io.ErrUnexpectedEOF
+	}
+	checksum := binary.LittleEndian.Uint32(data[size-4 : size])
+	if checksum != f.checksum.sum(data[:size-4]) {
+		return 0, ErrCorrupted
+	}
+	size += f.recordFlagsSize()
+	if uint64(len(data)) < uint64(size) {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return size, nil
+}
+
+// appendRun appends the run of valid records read at the offset of the file to the repaired file.
+func appendRun(dst *file, src *file, run []byte, offset uint64) error {
+	if src.cipher == nil {
+		_, err := dst.append(run)
+		return err
+	}
+	largeKeys := src.flags&headerFlagLargeKeys != 0
+	for off := uint64(0); off < uint64(len(run)); {
+		size, err := decodeRecordSize(run[off:], src)
+		if err != nil {
+			return err
+		}
+		rec, err := src.cipher.decryptRecord(run[off:off+uint64(size)], offset+off, largeKeys, src.checksum)
+		if err != nil {
+			return err
+		}
+		rec = dst.cipher.encryptRecord(rec, uint64(dst.size), largeKeys, dst.checksum)
+		if _, err := dst.append(rec); err != nil {
+			return err
+		}
+		off += uint64(size)
+	}
+	return nil
+}
+
+// repairSegments salvages records of every segment. Segments with corrupted data are rewritten.
+func repairSegments(opts *Options, report *RepairReport) error {
+	files, err := opts.FileSystem.ReadDir(".")
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		name := file.Name()
+		if filepath.Ext(name) != segmentExt {
+			continue
+		}
+		if err := repairSegment(opts, name, report); err != nil {
+			return errors.Wrapf(err, "repairing segment %s", name)
+		}
+	}
+	return nil
+}
+
+func repairSegment(opts *Options, name string, report *RepairReport) error {
+	fsys := opts.FileSystem
+	f, err := openFile(fsys, name, false)
+	if err != nil {
+		return err
+	}
+	clean := f.Close
+	defer func() {
+		if clean != nil {
+			_ = clean()
+		}
+	}()
+	report.Segments++
+	aead, err := newEncryptionAEAD(opts.EncryptionKey)
+	if err != nil {
+		return err
+	}
+	if err := f.openCipher(aead); err != nil {
+		return err
+	}
+
+	data, err := f.Slice(headerSize, f.size)
+	if err != nil {
+		return err
+	}
+
+	// Valid records are collected as runs of adjacent records.
+	type run struct{ start, end uint64 }
+	var runs []run
+	var off uint64
+	corrupted := false
+	for off < uint64(len(data)) {
+		recSize, err := decodeRecordSize(data[off:], f)
+		size := uint64(recSize)
+		if err == nil {
+			if n := len(runs); n > 0 && runs[n-1].end == off {
+				runs[n-1].end += size
+			} else {
+				runs = append(runs, run{start: off, end: off + size})
+			}
+			report.Records++
+			off += size
+			continue
+		}
+
+		// Skip to the next valid record.
+		corrupted = true
+		report.DroppedRecords++
+		start := off
+		for off++; off < uint64(len(data)); off++ {
+			if _, err := decodeRecordSize(data[off:], f); err == nil {
+				break
+			}
+		}
+		report.DroppedBytes += int64(off - start)
+		opts.Logger.Logf(LogWarn, "dropped %d bytes of corrupted data in segment %s at offset %d", off-start, name, headerSize+start)
+	}
+
+	if !corrupted {
+		return nil
+	}
+
+	tmpName := name + repairTmpExt
+	tmp, err := openFile(fsys, tmpName, true)
+	if err != nil {
+		return err
+	}
+	if f.cipher == nil {
+		aead = nil
+	}
+	// Records of encrypted segments move to other offsets, they are encrypted again with a new nonce.
+	if err := tmp.setCipher(aead, f.flags, f.checksum); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	for _, r := range runs {
+		if err := appendRun(tmp, f, data[r.start:r.end], headerSize+r.start); err != nil {
+			_ = tmp.Close()
+			return err
+		}
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	clean = nil
+	if err := f.Close(); err != nil {
+		return err
+	}
+	report.RepairedSegments++
+	return fsys.Rename(tmpName, name)
+}
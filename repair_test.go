@@ -0,0 +1,54 @@
+package pogreb
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/domaincrawler/pogreb/internal/assert"
+)
+
+func TestRepair(t *testing.T) {
+	db, err := createTestDB(nil)
+	assert.Nil(t, err)
+	key := func(i int) []byte {
+		k := make([]byte, 4)
+		binary.LittleEndian.PutUint32(k, uint32(i))
+		return k
+	}
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(key(i)))
+	}
+	recordSize := int64(encodedRecordSize(4))
+	seg := db.datalog.curSeg
+	// Corrupt the key of the 50th record and leave a partially written record at the end.
+	_, err = seg.WriteAt([]byte{0xff}, headerSize+50*recordSize+3)
+	assert.Nil(t, err)
+	_, err = seg.append([]byte{4, 0, 1})
+	assert.Nil(t, err)
+	assert.Nil(t, db.Close())
+
+	report, err := Repair(testDBName, &Options{FileSystem: testFS})
+	assert.Nil(t, err)
+	assert.Equal(t, &RepairReport{
+		Segments:         1,
+		RepairedSegments: 1,
+		Records:          99,
+		DroppedRecords:   2,
+		DroppedBytes:     recordSize + 3,
+	}, report)
+
+	db, err = Open(testDBName, &Options{FileSystem: testFS})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(99), db.Count())
+	for i := 0; i < 100; i++ {
+		has, err := db.Has(key(i))
+		assert.Nil(t, err)
+		assert.Equal(t, i != 50, has)
+	}
+	assert.Nil(t, db.Close())
+
+	// Repairing an intact DB doesn't rewrite segments.
+	report, err = Repair(testDBName, &Options{FileSystem: testFS})
+	assert.Nil(t, err)
+	assert.Equal(t, &RepairReport{Segments: 1, Records: 99}, report)
+}
@@ -0,0 +1,137 @@
+package pogreb
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/domaincrawler/pogreb/fs"
+	"github.com/domaincrawler/pogreb/internal/errors"
+)
+
+const replacedSuffix = ".replaced"
+
+var (
+	errReplacementOpen = errors.New("replacement database is open or wasn't closed properly")
+	errReplaceShared   = errors.New("can't replace a shared database")
+)
+
+// Replace swaps the database directory at newPath in place of the database at activePath,
+// e.g. after rebuilding the database offline, and removes the replaced database.
+// The new database must be closed. If the active database is open, Replace fails with ErrLocked,
+// the handle can be switched to the new database with DB.Replace instead.
+//
+// The directories are swapped with two renames: the active database is moved aside and the new one moved in.
+// Opening activePath between the renames creates a new database, which makes the second rename fail,
+// the replaced database is then kept next to activePath with the ".replaced" suffix.
+// Replace works on directories of the operating system file system.
+func Replace(activePath, newPath string) error {
+	if err := swapDirs(activePath, newPath); err != nil {
+		return err
+	}
+	return os.RemoveAll(activePath + replacedSuffix)
+}
+
+// swapDirs moves the database at activePath aside, checking that it isn't open, and moves the database at newPath in.
+func swapDirs(activePath, newPath string) error {
+	if _, err := os.Stat(filepath.Join(newPath, lockName)); err == nil {
+		return errReplacementOpen
+	}
+	if _, err := os.Stat(filepath.Join(newPath, dbMetaName)); err != nil {
+		return errors.Wrapf(err, "checking replacement database %s", newPath)
+	}
+	old := activePath + replacedSuffix
+	if err := os.RemoveAll(old); err != nil {
+		return err
+	}
+	if err := os.Rename(activePath, old); err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+		// Nothing to replace.
+		return os.Rename(newPath, activePath)
+	}
+	// Moving the directory keeps the lock of a handle which is still open.
+	lock, _, err := fs.OS.CreateLockFile(filepath.Join(old, lockName), os.FileMode(0644))
+	if err != nil {
+		if rerr := os.Rename(old, activePath); rerr != nil {
+			return errors.Wrapf(rerr, "restoring %s", activePath)
+		}
+		if errors.Is(err, os.ErrExist) {
+			err = ErrLocked
+		}
+		return err
+	}
+	if err := lock.Unlock(); err != nil {
+		return err
+	}
+	if err := os.Rename(newPath, activePath); err != nil {
+		return errors.Wrapf(err, "moving %s to %s, the replaced database is kept at %s", newPath, activePath, old)
+	}
+	return nil
+}
+
+// Replace closes the files of the DB, swaps the database directory at newPath in place of the DB directory
+// like the Replace function and reopens the DB from the new files, with the options it was opened with.
+// Operations running concurrently wait until the DB is reopened. Metrics, event handlers, invalidators
+// and count watches are kept. Iterators created before Replace must not be used afterwards.
+// If the DB can't be reopened, the returned error is final and the DB must not be used.
+func (db *DB) Replace(newPath string) error {
+	if db.handle != nil {
+		return errReplaceShared
+	}
+	if db.snapshot != nil {
+		return errSingleFileUnsupported
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := db.closeFiles(); err != nil {
+		return err
+	}
+	swapErr := swapDirs(db.path, newPath)
+	// Reopen the current files if the swap didn't happen.
+	ndb, err := openDB(db.path, db.openOpts)
+	if err != nil {
+		return errors.Wrapf(err, "reopening %s", db.path)
+	}
+	db.adopt(ndb)
+	if swapErr != nil {
+		return swapErr
+	}
+	db.invalidation.invalidateAll()
+	return os.RemoveAll(db.path + replacedSuffix)
+}
+
+// adopt makes the DB use the files opened by the other DB, which is discarded.
+func (db *DB) adopt(other *DB) {
+	// The other DB doesn't run any goroutines, the DB keeps its own.
+	if other.cancelBgWorker != nil {
+		other.cancelBgWorker()
+		other.closeWg.Wait()
+	}
+	other.events.close()
+	other.datalog.metrics = db.metrics
+	other.datalog.events = db.events
+	db.index = other.index
+	db.datalog = other.datalog
+	db.lock = other.lock
+	db.epoch = other.epoch
+	db.hashSeed = other.hashSeed
+	db.hashDomain = other.hashDomain
+	db.domainSeed = other.domainSeed
+	db.encryptedHashSeed = other.encryptedHashSeed
+	db.domainMismatch = other.domainMismatch
+	db.fingerprintSize = other.fingerprintSize
+	db.secondary = other.secondary
+	db.format = other.format
+	db.minVersion = other.minVersion
+	db.checkpointGen = other.checkpointGen
+	db.openReport = other.openReport
+	// Streams served by Replicate resynchronize their followers with the adopted datalog.
+	other.replication.mu.Lock()
+	meta := other.replication.meta
+	other.replication.mu.Unlock()
+	db.replication.mu.Lock()
+	db.replication.meta = meta
+	db.replication.dirty = false
+	db.replication.mu.Unlock()
+}
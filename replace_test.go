@@ -0,0 +1,77 @@
+package pogreb
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/domaincrawler/pogreb/fs"
+	"github.com/domaincrawler/pogreb/internal/assert"
+)
+
+// createReplaceTestDB creates a database on the OS file system holding the keys with indexes in [from, to).
+func createReplaceTestDB(t *testing.T, path string, from int, to int) {
+	db, err := Open(path, &Options{FileSystem: fs.OS})
+	assert.Nil(t, err)
+	for i := from; i < to; i++ {
+		assert.Nil(t, db.Put(deleteTestKey(i)))
+	}
+	assert.Nil(t, db.Close())
+}
+
+func TestReplace(t *testing.T) {
+	dir := t.TempDir()
+	active, next := filepath.Join(dir, "active"), filepath.Join(dir, "next")
+	createReplaceTestDB(t, active, 0, 10)
+	createReplaceTestDB(t, next, 10, 30)
+
+	// An open database isn't replaced.
+	db, err := Open(active, &Options{FileSystem: fs.OS})
+	assert.Nil(t, err)
+	assert.Equal(t, ErrLocked, Replace(active, next))
+	assert.Equal(t, uint64(10), db.Count())
+	assert.Nil(t, db.Close())
+
+	assert.Nil(t, Replace(active, next))
+	_, err = os.Stat(next)
+	assert.Equal(t, true, os.IsNotExist(err))
+	_, err = os.Stat(active + replacedSuffix)
+	assert.Equal(t, true, os.IsNotExist(err))
+	db, err = Open(active, &Options{FileSystem: fs.OS})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(20), db.Count())
+	assertHas(t, db, deleteTestKey(10), true)
+	assertHas(t, db, deleteTestKey(0), false)
+
+	// An open replacement isn't moved in.
+	other, err := Open(next, &Options{FileSystem: fs.OS})
+	assert.Nil(t, err)
+	assert.Equal(t, errReplacementOpen, db.Replace(next))
+	assert.Equal(t, uint64(20), db.Count())
+	assert.Nil(t, other.Close())
+	assert.Nil(t, os.RemoveAll(next))
+	assert.Nil(t, db.Close())
+}
+
+func TestDBReplace(t *testing.T) {
+	dir := t.TempDir()
+	active, next := filepath.Join(dir, "active"), filepath.Join(dir, "next")
+	createReplaceTestDB(t, active, 0, 10)
+	createReplaceTestDB(t, next, 100, 150)
+
+	db, err := Open(active, &Options{FileSystem: fs.OS})
+	assert.Nil(t, err)
+	assertHas(t, db, deleteTestKey(0), true)
+	assert.Nil(t, db.Replace(next))
+	assert.Equal(t, uint64(50), db.Count())
+	assertHas(t, db, deleteTestKey(0), false)
+	assertHas(t, db, deleteTestKey(100), true)
+	assert.Nil(t, db.Put(deleteTestKey(0)))
+	assert.Nil(t, db.Close())
+
+	db, err = Open(active, &Options{FileSystem: fs.OS})
+	assert.Nil(t, err)
+	assert.Equal(t, false, db.OpenReport().Recovered)
+	assert.Equal(t, uint64(51), db.Count())
+	assert.Nil(t, db.Close())
+}
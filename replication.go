@@ -0,0 +1,800 @@
+package pogreb
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/domaincrawler/pogreb/fs"
+	"github.com/domaincrawler/pogreb/internal/errors"
+)
+
+const (
+	replicationName    = "replication" + metaExt
+	replicationTmpName = replicationName + ".tmp"
+
+	replicationVersion = 1
+
+	replicationBatchSize = 256 << 10 // Size of the records read from a segment for a frame.
+	// maxReplicationFrameSize fits a batch ending with the largest record.
+	maxReplicationFrameSize = replicationBatchSize + largeKeyHeaderSize + MaxLargeKeyLength + 64
+)
+
+// Frames of a replication stream, each frame is its 4-byte little-endian size, the frame type and the payload.
+const (
+	// replFrameHello starts the stream: the protocol version, the primary ID and the fingerprint size of the primary.
+	replFrameHello byte = iota + 1
+
+	// replFrameReset makes the follower remove its keys before the snapshot of the primary: the primary ID.
+	replFrameReset
+
+	// replFrameSnapshot holds keys of the snapshot of the primary, in the record encoding of replFrameRecords.
+	replFrameSnapshot
+
+	// replFrameRecords holds the records of a segment followed by the position after them and the lag:
+	// the sequence ID, the offset and the lag as uvarints, then the records, each a type byte,
+	// the 2-byte little-endian flags, the uvarint key size and the key.
+	replFrameRecords
+
+	// replFrameHeartbeat holds the position of the follower and the lag, like replFrameRecords with no records.
+	// The primary sends it once a snapshot is complete and periodically while the follower is caught up.
+	replFrameHeartbeat
+)
+
+var (
+	// replicationPollInterval is the time the primary waits for new records once the follower is caught up.
+	replicationPollInterval = 50 * time.Millisecond
+
+	// replicationHeartbeatInterval is the interval of the heartbeats of a caught up stream.
+	replicationHeartbeatInterval = time.Second
+
+	// replicationSaveInterval is the interval at which a follower makes its position durable.
+	replicationSaveInterval = time.Second
+)
+
+var (
+	errReplicationProtocol = errors.New("malformed replication stream")
+	errAlreadyFollowing    = errors.New("database is already following a primary")
+
+	// errResyncRequired is returned by readReplicationBatch when the follower needs a snapshot.
+	errResyncRequired = errors.New("follower must resynchronize")
+)
+
+// ReplicationPosition is a position in the datalog of a primary, see DB.Follow.
+// A follower which applied the records before the position resumes the stream at the position.
+type ReplicationPosition struct {
+	PrimaryID  uint64 // Random identifier of the primary, 0 if the follower hasn't received a stream.
+	SequenceID uint64 // Sequence ID of the segment of the next record.
+	Offset     uint64 // Offset of the next record in the segment, 0 is the first record.
+}
+
+// ReplicationState is the replication state of a DB, which may serve followers and follow a primary at once.
+// The counters are also exported by Metrics.
+type ReplicationState struct {
+	Followers int                 // Number of streams served by Replicate.
+	Following bool                // Follow is applying a stream.
+	Position  ReplicationPosition // Position of the primary applied by Follow.
+	LagBytes  int64               // Size of the records of the primary not yet applied, as of the last frame received.
+}
+
+// replicationMeta is the replication state persisted by the DB.
+type replicationMeta struct {
+	ID uint64 // Identifier of the DB as a primary, generated by the first Replicate call.
+	// Followers positioned before the horizon, the offset of the segment with the sequence ID,
+	// can't resume incrementally.
+	Horizon       uint64
+	HorizonOffset uint64
+	Position      ReplicationPosition
+}
+
+// behindHorizon returns true if records following the position were discarded.
+func (m *replicationMeta) behindHorizon(sequenceID uint64, offset uint64) bool {
+	return sequenceID < m.Horizon || (sequenceID == m.Horizon && offset < m.HorizonOffset)
+}
+
+// replication holds the replication state of a DB.
+type replication struct {
+	mu        sync.Mutex
+	meta      replicationMeta
+	dirty     bool // The position changed since the meta was written.
+	followers int
+	following bool
+	lagBytes  int64
+}
+
+// openReplication reads the replication state written by the DB.
+func openReplication(fsys fs.FileSystem) (*replication, error) {
+	r := &replication{}
+	if _, err := fsys.Stat(replicationName); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return r, nil
+		}
+		return nil, err
+	}
+	if err := readGobFile(fsys, replicationName, &r.meta); err != nil {
+		return nil, errors.Wrap(err, "reading replication state")
+	}
+	return r, nil
+}
+
+// write durably replaces the replication meta. The caller must hold r.mu.
+func (r *replication) write(fsys fs.FileSystem) error {
+	if err := writeSyncedFile(fsys, replicationTmpName, writeGob(r.meta)); err != nil {
+		return err
+	}
+	if err := fsys.Rename(replicationTmpName, replicationName); err != nil {
+		return err
+	}
+	r.dirty = false
+	return nil
+}
+
+// ReplicationState returns the replication state of the DB.
+func (db *DB) ReplicationState() ReplicationState {
+	r := db.replication
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return ReplicationState{
+		Followers: r.followers,
+		Following: r.following,
+		Position:  r.meta.Position,
+		LagBytes:  r.lagBytes,
+	}
+}
+
+// raiseReplicationHorizon makes followers positioned before the offset of the segment with the sequence ID
+// resynchronize. It's called when records followers may not have received are discarded: delete records
+// dropped by compaction and all records removed by Truncate. Keys removed without delete records,
+// by DeleteWhere or by skipping corrupted records, raise the horizon to the tail of the datalog,
+// followers which received their put records never learn about the removal otherwise.
+// The caller must hold the DB write lock.
+func (db *DB) raiseReplicationHorizon(sequenceID uint64, offset uint64) error {
+	if db.opts.ReadOnly {
+		return nil
+	}
+	r := db.replication
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if sequenceID < r.meta.Horizon || (sequenceID == r.meta.Horizon && offset <= r.meta.HorizonOffset) {
+		return nil
+	}
+	r.meta.Horizon, r.meta.HorizonOffset = sequenceID, offset
+	if err := r.write(db.opts.FileSystem); err != nil {
+		return errors.Wrap(err, "writing replication state")
+	}
+	return nil
+}
+
+// writeReplication writes the position of a follower. The caller must hold the DB write lock.
+func (db *DB) writeReplication() error {
+	r := db.replication
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.dirty {
+		return nil
+	}
+	return r.write(db.opts.FileSystem)
+}
+
+// replicationID returns the identifier of the DB as a primary, generating it on the first call.
+func (db *DB) replicationID() (uint64, error) {
+	r := db.replication
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.meta.ID != 0 {
+		return r.meta.ID, nil
+	}
+	if db.opts.ReadOnly {
+		return 0, errReadOnly
+	}
+	var b [8]byte
+	for r.meta.ID == 0 {
+		if _, err := rand.Read(b[:]); err != nil {
+			return 0, err
+		}
+		r.meta.ID = binary.LittleEndian.Uint64(b[:])
+	}
+	if err := r.write(db.opts.FileSystem); err != nil {
+		r.meta.ID = 0
+		return 0, errors.Wrap(err, "writing replication state")
+	}
+	return r.meta.ID, nil
+}
+
+func writeReplicationFrame(w *bufio.Writer, typ byte, payload []byte) error {
+	var hdr [5]byte
+	binary.LittleEndian.PutUint32(hdr[:4], uint32(len(payload)))
+	hdr[4] = typ
+	if _, err := w.Write(hdr[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+func readReplicationFrame(r *bufio.Reader) (byte, []byte, error) {
+	var hdr [5]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	size := binary.LittleEndian.Uint32(hdr[:4])
+	if size > maxReplicationFrameSize {
+		return 0, nil, errReplicationProtocol
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, nil, err
+	}
+	return hdr[4], payload, nil
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+func appendReplicationRecord(b []byte, rtype recordType, flags uint16, key []byte) []byte {
+	b = append(b, byte(rtype), byte(flags), byte(flags>>8))
+	b = appendUvarint(b, uint64(len(key)))
+	return append(b, key...)
+}
+
+func appendReplicationPosition(b []byte, pos ReplicationPosition, lag int64) []byte {
+	b = appendUvarint(b, pos.SequenceID)
+	b = appendUvarint(b, uint64(pos.Offset))
+	return appendUvarint(b, uint64(lag))
+}
+
+// replicationDecoder reads the fields of a frame payload.
+type replicationDecoder struct {
+	b   []byte
+	err error
+}
+
+func (d *replicationDecoder) uvarint() uint64 {
+	v, n := binary.Uvarint(d.b)
+	if n <= 0 {
+		d.err = errReplicationProtocol
+		d.b = nil
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *replicationDecoder) bytes(n uint64) []byte {
+	if uint64(len(d.b)) < n {
+		d.err = errReplicationProtocol
+		d.b = nil
+		return nil
+	}
+	v := d.b[:n]
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *replicationDecoder) position(id uint64) (ReplicationPosition, int64) {
+	seq := d.uvarint()
+	offset := d.uvarint()
+	lag := d.uvarint()
+	return ReplicationPosition{PrimaryID: id, SequenceID: seq, Offset: offset}, int64(lag)
+}
+
+// replicationRecord is a record of a replication frame.
+type replicationRecord struct {
+	rtype recordType
+	flags uint16
+	key   []byte
+}
+
+func (d *replicationDecoder) records() []replicationRecord {
+	var recs []replicationRecord
+	for len(d.b) > 0 && d.err == nil {
+		hdr := d.bytes(3)
+		size := d.uvarint()
+		key := d.bytes(size)
+		if d.err != nil {
+			break
+		}
+		if recordType(hdr[0]) != recordTypePut && recordType(hdr[0]) != recordTypeDelete {
+			d.err = errReplicationProtocol
+			break
+		}
+		recs = append(recs, replicationRecord{rtype: recordType(hdr[0]), flags: uint16(hdr[1]) | uint16(hdr[2])<<8, key: key})
+	}
+	return recs
+}
+
+// Replicate streams the records of the DB to a follower applying them with Follow, e.g. over a network connection,
+// until the context is done or writing to w fails. The stream starts at the position returned by
+// ReplicationPosition of the follower, which is a zero position for a new follower.
+//
+// Sealed segments are sent first, followed by the records of the current segment and then by new records
+// as they are written. With the SyncAlways and SyncInterval policies records are sent once they are synced.
+// A follower which can't resume incrementally receives a snapshot of the keys first: a new follower of a DB
+// whose compaction discarded delete records, a follower of another primary, and a follower which fell behind
+// a compaction discarding records it hasn't received, or a Truncate. Flags updated in place by PutWithFlags
+// and CompareAndSetFlags after a record was sent aren't replicated.
+//
+// Multiple streams can be served concurrently. The DB is read while it serves writes, compaction isn't blocked.
+func (db *DB) Replicate(ctx context.Context, w io.Writer, from ReplicationPosition) error {
+	id, err := db.replicationID()
+	if err != nil {
+		return err
+	}
+	r := db.replication
+	r.mu.Lock()
+	r.followers++
+	r.mu.Unlock()
+	db.metrics.ReplicationFollowers.Add(1)
+	defer func() {
+		r.mu.Lock()
+		r.followers--
+		r.mu.Unlock()
+		db.metrics.ReplicationFollowers.Add(-1)
+	}()
+
+	bw := bufio.NewWriter(&countingWriter{w: w, n: &db.metrics.ReplicationBytesSent})
+	hello := appendUvarint(nil, replicationVersion)
+	hello = appendUint64(hello, id)
+	hello = appendUvarint(hello, uint64(db.fingerprintSize))
+	if err := writeReplicationFrame(bw, replFrameHello, hello); err != nil {
+		return err
+	}
+	pos := from
+	if pos == (ReplicationPosition{}) {
+		// A new follower starts from the first segment.
+		pos.PrimaryID = id
+	}
+	heartbeat := time.Time{}
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		payload, next, lag, err := db.readReplicationBatch(pos)
+		if err == errResyncRequired {
+			// A snapshot interrupted by Truncate starts over, the position is behind the raised horizon.
+			if pos, err = db.replicateSnapshot(ctx, bw); err != nil && err != ErrConcurrentModification {
+				return err
+			}
+			heartbeat = time.Time{}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if payload != nil {
+			pos = next
+			if err := writeReplicationFrame(bw, replFrameRecords, payload); err != nil {
+				return err
+			}
+			if err := bw.Flush(); err != nil {
+				return err
+			}
+			continue
+		}
+		pos = next
+		if time.Since(heartbeat) >= replicationHeartbeatInterval {
+			heartbeat = time.Now()
+			if err := writeReplicationFrame(bw, replFrameHeartbeat, appendReplicationPosition(nil, pos, lag)); err != nil {
+				return err
+			}
+			if err := bw.Flush(); err != nil {
+				return err
+			}
+		}
+		t := time.NewTimer(replicationPollInterval)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// countingWriter adds the number of written bytes to the metric.
+type countingWriter struct {
+	w io.Writer
+	n interface{ Add(int64) }
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n.Add(int64(n))
+	return n, err
+}
+
+// replicationResyncDue returns true if a follower at the position may have missed discarded records,
+// or the position belongs to another primary. The caller must hold the DB lock.
+func (db *DB) replicationResyncDue(pos ReplicationPosition) bool {
+	r := db.replication
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return pos.PrimaryID != r.meta.ID || r.meta.behindHorizon(pos.SequenceID, pos.Offset)
+}
+
+// replicateSnapshot sends the keys of the DB, preceded by a reset, and returns the position of the datalog
+// before the keys were read. Records written during the iteration are sent again by the incremental stream.
+func (db *DB) replicateSnapshot(ctx context.Context, bw *bufio.Writer) (ReplicationPosition, error) {
+	db.mu.RLock()
+	r := db.replication
+	r.mu.Lock()
+	id := r.meta.ID
+	r.mu.Unlock()
+	db.datalog.mu.RLock()
+	pos := ReplicationPosition{PrimaryID: id, SequenceID: db.datalog.curSeg.sequenceID, Offset: db.datalog.replicableSize(db.datalog.curSeg)}
+	db.datalog.mu.RUnlock()
+	it := db.Items()
+	db.mu.RUnlock()
+
+	reset := appendUint64(nil, id)
+	if err := writeReplicationFrame(bw, replFrameReset, reset); err != nil {
+		return pos, err
+	}
+	var batch []byte
+	for {
+		if err := ctx.Err(); err != nil {
+			return pos, err
+		}
+		key, err := it.Next()
+		if err == ErrIterationDone {
+			break
+		}
+		if err != nil {
+			return pos, err
+		}
+		flags := uint16(0)
+		if db.opts.RecordFlags {
+			if _, flags, err = db.storedFlags(key); err != nil {
+				return pos, err
+			}
+		}
+		batch = appendReplicationRecord(batch, recordTypePut, flags, key)
+		if len(batch) >= replicationBatchSize {
+			if err := writeReplicationFrame(bw, replFrameSnapshot, batch); err != nil {
+				return pos, err
+			}
+			batch = batch[:0]
+		}
+	}
+	if len(batch) > 0 {
+		if err := writeReplicationFrame(bw, replFrameSnapshot, batch); err != nil {
+			return pos, err
+		}
+	}
+	if err := writeReplicationFrame(bw, replFrameHeartbeat, appendReplicationPosition(nil, pos, 0)); err != nil {
+		return pos, err
+	}
+	return pos, bw.Flush()
+}
+
+// storedFlags returns the flags of the key as it's stored, see HasFlags.
+func (db *DB) storedFlags(key []byte) (bool, uint16, error) {
+	h := db.hash(key)
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	shard := db.index.shard(h)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	sl, found, err := db.findSlot(shard, h, key)
+	if err != nil || !found {
+		return false, 0, err
+	}
+	flags, err := db.datalog.readFlags(sl)
+	return err == nil, flags, err
+}
+
+// replicableSize returns the size of the segment which can be replicated. Records of the current segment
+// are replicated once they are synced if the sync policy syncs writes, as soon as they are written otherwise.
+// The caller must hold the datalog lock.
+func (dl *datalog) replicableSize(seg *segment) uint64 {
+	if seg != dl.curSeg || dl.opts.SyncPolicy == SyncNever || dl.opts.SyncPolicy == SyncOSDefault {
+		return uint64(seg.size)
+	}
+	id, synced := dl.loadSynced()
+	if id != seg.id || synced < headerSize {
+		return headerSize
+	}
+	if synced > seg.size {
+		// The sync happened before a segment with the same ID was removed.
+		return uint64(seg.size)
+	}
+	return uint64(synced)
+}
+
+// readReplicationBatch reads the records following the position, up to replicationBatchSize bytes
+// of a single segment, and returns them encoded as a replFrameRecords payload, or nil if there are no records.
+// It also returns the position after the records and the remaining size of the datalog.
+// It returns errResyncRequired if the follower at the position can't resume incrementally.
+func (db *DB) readReplicationBatch(pos ReplicationPosition) ([]byte, ReplicationPosition, int64, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.replicationResyncDue(pos) {
+		return nil, pos, 0, errResyncRequired
+	}
+	segments := db.datalog.segmentsBySequenceID()
+	db.datalog.mu.RLock()
+	sizes := make([]uint64, len(segments))
+	for i, seg := range segments {
+		sizes[i] = db.datalog.replicableSize(seg)
+	}
+	db.datalog.mu.RUnlock()
+
+	next := pos
+	var recs []byte
+	var lag int64
+	for i, seg := range segments {
+		if seg.sequenceID < pos.SequenceID {
+			continue
+		}
+		start := uint64(headerSize)
+		if seg.sequenceID == pos.SequenceID && pos.Offset > headerSize {
+			start = pos.Offset
+		}
+		if start > sizes[i] {
+			// The position is past the end of its segment, the follower applied records the DB doesn't have.
+			return nil, pos, 0, errResyncRequired
+		}
+		if recs == nil && start < sizes[i] {
+			var err error
+			if recs, next.Offset, err = readReplicationRecords(db.datalog.newSegmentRangeIterator(seg, start, sizes[i]), sizes[i]); err != nil {
+				return nil, pos, 0, errors.Wrapf(err, "reading segment %s", seg.name)
+			}
+			next.SequenceID = seg.sequenceID
+			lag += int64(sizes[i] - next.Offset)
+			continue
+		}
+		if recs == nil {
+			// The segment is exhausted, the next one starts at its first record.
+			next = ReplicationPosition{PrimaryID: pos.PrimaryID, SequenceID: seg.sequenceID, Offset: start}
+			continue
+		}
+		lag += int64(sizes[i] - start)
+	}
+	if recs == nil {
+		return nil, next, lag, nil
+	}
+	payload := appendReplicationPosition(nil, next, lag)
+	return append(payload, recs...), next, lag, nil
+}
+
+// readReplicationRecords encodes the records of the range iterator, up to replicationBatchSize bytes,
+// and returns them with the offset following them.
+func readReplicationRecords(it *segmentIterator, end uint64) ([]byte, uint64, error) {
+	start := it.offset
+	var recs []byte
+	for it.offset < end && it.offset-start < replicationBatchSize {
+		rec, err := it.next()
+		if err != nil {
+			return nil, 0, err
+		}
+		recs = appendReplicationRecord(recs, rec.rtype, rec.flags, rec.key)
+	}
+	return recs, it.offset, nil
+}
+
+// ReplicationPosition returns the position of the primary up to which the DB applied the records
+// received by Follow, to resume the stream from.
+func (db *DB) ReplicationPosition() ReplicationPosition {
+	return db.ReplicationState().Position
+}
+
+// Follow applies a stream written by Replicate of a primary DB, e.g. read from a network connection,
+// until r returns io.EOF, which Follow returns as nil, or an error. The DB becomes a warm standby of the primary:
+// it must not be written otherwise while following, its keys are replaced when the stream begins with a snapshot.
+// The position of the applied records, see ReplicationPosition, is made durable periodically and by Close.
+// Both databases must store keys the same way, see Options.StoreFingerprintsOnly.
+func (db *DB) Follow(r io.Reader) error {
+	if err := db.checkWritable(); err != nil {
+		return err
+	}
+	repl := db.replication
+	repl.mu.Lock()
+	if repl.following {
+		repl.mu.Unlock()
+		return errAlreadyFollowing
+	}
+	repl.following = true
+	repl.mu.Unlock()
+	defer func() {
+		repl.mu.Lock()
+		repl.following = false
+		repl.mu.Unlock()
+	}()
+
+	br := bufio.NewReader(r)
+	typ, payload, err := readReplicationFrame(br)
+	if err != nil {
+		if err == io.EOF {
+			return nil
+		}
+		return err
+	}
+	d := &replicationDecoder{b: payload}
+	if typ != replFrameHello || d.uvarint() != replicationVersion {
+		return errReplicationProtocol
+	}
+	id := binary.LittleEndian.Uint64(d.bytes(8))
+	fingerprintSize := d.uvarint()
+	if d.err != nil {
+		return d.err
+	}
+	if int(fingerprintSize) != db.fingerprintSize {
+		return errFingerprintMismatch
+	}
+	saved := time.Now()
+	for {
+		typ, payload, err := readReplicationFrame(br)
+		if err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			if saveErr := db.saveReplicationPosition(); err == nil {
+				err = saveErr
+			}
+			return err
+		}
+		d := &replicationDecoder{b: payload}
+		switch typ {
+		case replFrameReset:
+			id = binary.LittleEndian.Uint64(d.bytes(8))
+			if d.err != nil {
+				return d.err
+			}
+			if err := db.replicationReset(id); err != nil {
+				return err
+			}
+		case replFrameSnapshot:
+			if err := db.applyReplicationRecords(d.records(), d); err != nil {
+				return err
+			}
+		case replFrameRecords, replFrameHeartbeat:
+			pos, lag := d.position(id)
+			if typ == replFrameRecords {
+				if err := db.applyReplicationRecords(d.records(), d); err != nil {
+					return err
+				}
+			}
+			if d.err != nil {
+				return d.err
+			}
+			repl.mu.Lock()
+			repl.meta.Position = pos
+			repl.lagBytes = lag
+			repl.dirty = true
+			repl.mu.Unlock()
+			db.metrics.ReplicationLagBytes.Set(lag)
+			if typ == replFrameHeartbeat || time.Since(saved) >= replicationSaveInterval {
+				saved = time.Now()
+				if err := db.saveReplicationPosition(); err != nil {
+					return err
+				}
+			}
+		default:
+			return errReplicationProtocol
+		}
+	}
+}
+
+// replicationReset removes the keys of the follower before a snapshot of the primary with the ID.
+func (db *DB) replicationReset(id uint64) error {
+	db.metrics.ReplicationResyncs.Add(1)
+	for {
+		err := db.Truncate()
+		if err != ErrBusy {
+			if err != nil {
+				return errors.Wrap(err, "truncating for a replication snapshot")
+			}
+			break
+		}
+		// A compaction of the follower is running.
+		time.Sleep(replicationPollInterval)
+	}
+	repl := db.replication
+	repl.mu.Lock()
+	// A snapshot interrupted before its end restarts when the follower reconnects.
+	repl.meta.Position = ReplicationPosition{PrimaryID: id}
+	repl.dirty = true
+	repl.mu.Unlock()
+	return db.saveReplicationPosition()
+}
+
+// saveReplicationPosition syncs the applied records and writes their position.
+func (db *DB) saveReplicationPosition() error {
+	if err := db.Sync(); err != nil {
+		return err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := db.writeReplication(); err != nil {
+		return errors.Wrap(err, "writing replication state")
+	}
+	return nil
+}
+
+// applyReplicationRecords writes the records received from the primary, which are keys as they are stored.
+func (db *DB) applyReplicationRecords(recs []replicationRecord, d *replicationDecoder) error {
+	if d.err != nil {
+		return d.err
+	}
+	var keys [][]byte // Inserted keys.
+	err := func() error {
+		db.mu.RLock()
+		defer db.mu.RUnlock()
+		for _, rec := range recs {
+			if len(rec.key) > MaxKeyLength && !db.opts.LargeKeys {
+				return ErrKeyTooLarge
+			}
+			h := db.hash(rec.key)
+			shard := db.index.shard(h)
+			shard.mu.Lock()
+			inserted, err := db.applyReplicationRecord(shard, h, rec)
+			shard.mu.Unlock()
+			if err != nil {
+				return err
+			}
+			if inserted {
+				keys = append(keys, rec.key)
+			}
+		}
+		return nil
+	}()
+	db.metrics.ReplicationRecordsApplied.Add(int64(len(recs)))
+	if err != nil {
+		return err
+	}
+	if err := db.commit(); err != nil {
+		return err
+	}
+	db.metrics.Puts.Add(int64(len(keys)))
+	for _, key := range keys {
+		db.writeChain.after(key)
+	}
+	return nil
+}
+
+// applyReplicationRecord writes the put record unless the key is stored with the same flags,
+// or deletes the key of the delete record. It returns true if the key was inserted.
+// The caller must hold the shard write lock.
+func (db *DB) applyReplicationRecord(shard *indexShard, h uint64, rec replicationRecord) (bool, error) {
+	if rec.rtype == recordTypeDelete {
+		n, err := db.del(shard, h, rec.key, true)
+		if n > 0 {
+			db.deleted([][]byte{rec.key})
+		}
+		return false, err
+	}
+	if !db.opts.RecordFlags {
+		rec.flags = 0
+	}
+	sl, found, err := db.findSlot(shard, h, rec.key)
+	if err != nil {
+		return false, err
+	}
+	if found {
+		if !db.opts.RecordFlags {
+			return false, nil
+		}
+		cur, err := db.datalog.readFlags(sl)
+		if err != nil || cur == rec.flags {
+			return false, err
+		}
+	}
+	written, err := db.setFlags(shard, h, rec.key, sl, found, rec.flags)
+	return written && !found, err
+}
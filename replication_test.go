@@ -0,0 +1,161 @@
+package pogreb
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/domaincrawler/pogreb/internal/assert"
+)
+
+// followSession streams a primary to a follower over a pipe.
+type followSession struct {
+	cancel    context.CancelFunc
+	replicate chan error
+	follow    chan error
+}
+
+func startFollowing(primary *DB, follower *DB) *followSession {
+	ctx, cancel := context.WithCancel(context.Background())
+	pr, pw := io.Pipe()
+	s := &followSession{cancel: cancel, replicate: make(chan error, 1), follow: make(chan error, 1)}
+	from := follower.ReplicationPosition()
+	go func() {
+		err := primary.Replicate(ctx, pw, from)
+		_ = pw.Close()
+		s.replicate <- err
+	}()
+	go func() {
+		err := follower.Follow(pr)
+		_ = pr.CloseWithError(err)
+		s.follow <- err
+	}()
+	return s
+}
+
+// stop ends the stream once the follower caught up with the keys.
+func (s *followSession) stop(t *testing.T, follower *DB, want []int) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for follower.Count() != uint64(len(want)) || follower.ReplicationState().LagBytes != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("follower has %d keys, want %d", follower.Count(), len(want))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	s.cancel()
+	assert.Equal(t, context.Canceled, <-s.replicate)
+	assert.Nil(t, <-s.follow)
+	for _, i := range want {
+		assertHas(t, follower, deleteTestKey(i), true)
+	}
+}
+
+// testFollowerPath returns the path of a follower DB in a directory removed when the test ends.
+func testFollowerPath(t *testing.T) string {
+	return filepath.Join(t.TempDir(), "follower")
+}
+
+func keyRange(from int, to int) []int {
+	var keys []int
+	for i := from; i < to; i++ {
+		keys = append(keys, i)
+	}
+	return keys
+}
+
+func TestReplication(t *testing.T) {
+	opts := &Options{maxSegmentSize: 1024}
+	primary, err := createTestDB(opts)
+	assert.Nil(t, err)
+	defer primary.Close()
+	followerPath := testFollowerPath(t)
+	followerOpts := &Options{FileSystem: testFS, maxSegmentSize: 1024}
+	follower, err := Open(followerPath, followerOpts)
+	assert.Nil(t, err)
+
+	for i := 0; i < 300; i++ {
+		assert.Nil(t, primary.Put(deleteTestKey(i)))
+	}
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, primary.Delete(deleteTestKey(i)))
+	}
+	s := startFollowing(primary, follower)
+	// Keys written while following are streamed.
+	for i := 300; i < 350; i++ {
+		assert.Nil(t, primary.Put(deleteTestKey(i)))
+	}
+	s.stop(t, follower, keyRange(10, 350))
+	assertHas(t, follower, deleteTestKey(0), false)
+	assert.Equal(t, int64(0), follower.metrics.ReplicationResyncs.Value())
+	assert.Equal(t, int64(0), primary.metrics.ReplicationFollowers.Value())
+	assert.Equal(t, true, primary.metrics.ReplicationBytesSent.Value() > 0)
+	pos := follower.ReplicationPosition()
+	assert.Equal(t, primary.replication.meta.ID, pos.PrimaryID)
+
+	// The follower resumes from the durable position.
+	assert.Nil(t, follower.Close())
+	for i := 350; i < 400; i++ {
+		assert.Nil(t, primary.Put(deleteTestKey(i)))
+	}
+	assert.Nil(t, primary.Delete(deleteTestKey(10)))
+	follower, err = Open(followerPath, followerOpts)
+	assert.Nil(t, err)
+	assert.Equal(t, pos, follower.ReplicationPosition())
+	s = startFollowing(primary, follower)
+	s.stop(t, follower, keyRange(11, 400))
+	assert.Equal(t, int64(0), follower.metrics.ReplicationResyncs.Value())
+	assert.Equal(t, int64(50+1), follower.metrics.ReplicationRecordsApplied.Value())
+
+	// Truncating the primary makes the follower apply a snapshot.
+	assert.Nil(t, primary.Truncate())
+	for i := 1000; i < 1010; i++ {
+		assert.Nil(t, primary.Put(deleteTestKey(i)))
+	}
+	s = startFollowing(primary, follower)
+	s.stop(t, follower, keyRange(1000, 1010))
+	assert.Equal(t, int64(1), follower.metrics.ReplicationResyncs.Value())
+	assertHas(t, follower, deleteTestKey(11), false)
+	assert.Nil(t, follower.Close())
+}
+
+func TestReplicationHorizon(t *testing.T) {
+	primary, err := createTestDB(&Options{maxSegmentSize: 1024})
+	assert.Nil(t, err)
+	defer primary.Close()
+	for i := 0; i < 400; i++ {
+		assert.Nil(t, primary.Put(deleteTestKey(i)))
+	}
+	_, err = primary.DeleteWhere(func(key []byte) bool {
+		return binary.BigEndian.Uint32(key) < 50
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, true, primary.replication.meta.Horizon > 0)
+
+	// A new follower can't replay the removal of the keys, it receives a snapshot.
+	followerPath := testFollowerPath(t)
+	follower, err := Open(followerPath, &Options{FileSystem: testFS})
+	assert.Nil(t, err)
+	s := startFollowing(primary, follower)
+	s.stop(t, follower, keyRange(50, 400))
+	assert.Equal(t, int64(1), follower.metrics.ReplicationResyncs.Value())
+	assertHas(t, follower, deleteTestKey(0), false)
+	assert.Nil(t, follower.Close())
+}
+
+func TestFollowFingerprintMismatch(t *testing.T) {
+	primary, err := createTestDB(nil)
+	assert.Nil(t, err)
+	defer primary.Close()
+	followerPath := testFollowerPath(t)
+	follower, err := Open(followerPath, &Options{FileSystem: testFS, StoreFingerprintsOnly: true})
+	assert.Nil(t, err)
+	s := startFollowing(primary, follower)
+	assert.Equal(t, errFingerprintMismatch, <-s.follow)
+	s.cancel()
+	<-s.replicate
+	assert.Nil(t, follower.Close())
+}
@@ -0,0 +1,131 @@
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+
+	"github.com/domaincrawler/pogreb"
+)
+
+const (
+	// maxBulkSize fits the largest key, see pogreb.MaxLargeKeyLength.
+	maxBulkSize = pogreb.MaxLargeKeyLength
+	maxArgs     = 1 << 20
+	maxLineSize = 64 << 10
+)
+
+var (
+	errProtocol     = errors.New("Protocol error")
+	errLineTooLarge = errors.New("Protocol error: too big inline request")
+)
+
+// readLine returns the next line without the terminating CRLF or LF.
+func readLine(r *bufio.Reader) ([]byte, error) {
+	var line []byte
+	for {
+		chunk, isPrefix, err := r.ReadLine()
+		if err != nil {
+			if err == io.EOF && len(line) > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		line = append(line, chunk...)
+		if len(line) > maxLineSize {
+			return nil, errLineTooLarge
+		}
+		if !isPrefix {
+			return line, nil
+		}
+	}
+}
+
+// readCommand returns the arguments of the next command: an array of bulk strings, as sent by clients,
+// or an inline command made of space-separated words, as typed in a telnet session.
+func readCommand(r *bufio.Reader) ([][]byte, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '*' {
+		return bytes.Fields(line), nil
+	}
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil || n > maxArgs {
+		return nil, errProtocol
+	}
+	args := make([][]byte, 0, n)
+	for i := 0; i < n; i++ {
+		hdr, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(hdr) == 0 || hdr[0] != '$' {
+			return nil, errProtocol
+		}
+		size, err := strconv.Atoi(string(hdr[1:]))
+		if err != nil || size < 0 || size > maxBulkSize {
+			return nil, errProtocol
+		}
+		arg := make([]byte, size+2)
+		if _, err := io.ReadFull(r, arg); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		if arg[size] != '\r' || arg[size+1] != '\n' {
+			return nil, errProtocol
+		}
+		args = append(args, arg[:size])
+	}
+	return args, nil
+}
+
+// writer writes RESP replies.
+type writer struct {
+	*bufio.Writer
+}
+
+func (w writer) simple(s string) {
+	w.WriteByte('+')
+	w.WriteString(s)
+	w.WriteString("\r\n")
+}
+
+func (w writer) error(msg string) {
+	w.WriteByte('-')
+	w.WriteString(msg)
+	w.WriteString("\r\n")
+}
+
+func (w writer) integer(n int64) {
+	w.WriteByte(':')
+	w.WriteString(strconv.FormatInt(n, 10))
+	w.WriteString("\r\n")
+}
+
+func (w writer) bool(v bool) {
+	if v {
+		w.integer(1)
+	} else {
+		w.integer(0)
+	}
+}
+
+func (w writer) bulk(b []byte) {
+	w.WriteByte('$')
+	w.WriteString(strconv.Itoa(len(b)))
+	w.WriteString("\r\n")
+	w.Write(b)
+	w.WriteString("\r\n")
+}
+
+func (w writer) array(n int) {
+	w.WriteByte('*')
+	w.WriteString(strconv.Itoa(n))
+	w.WriteString("\r\n")
+}
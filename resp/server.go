@@ -0,0 +1,432 @@
+/*
+Package resp serves a pogreb database over the Redis protocol (RESP), so that Redis clients and tools,
+e.g. redis-cli, can use it as a Redis set.
+
+The DB holds the members of a single set, whose name is given to New. The set supports
+SADD, SREM, SISMEMBER, SMISMEMBER, SCARD and SSCAN, the keyspace commands EXISTS, TYPE, DBSIZE and SCAN
+see the set as the only key. Other keys are empty sets: reading them returns empty results,
+writing them returns an error. PING, ECHO, SELECT 0, COMMAND and QUIT are supported for clients.
+
+SSCAN cursors hold an iterator of the connection, they are valid on the connection which started the iteration.
+A key is returned by an iteration exactly once if it's stored for the whole iteration, see pogreb.ItemIterator.
+*/
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/domaincrawler/pogreb"
+)
+
+const (
+	defaultScanCount = 10
+	maxScans         = 64 // Number of open SSCAN cursors of a connection, starting another one drops the oldest.
+)
+
+// ErrServerClosed is returned by Serve after Close.
+var ErrServerClosed = errors.New("server closed")
+
+// Options holds the limits of a Server.
+type Options struct {
+	// ConnQuota limits the SSCAN iterations of a connection: MaxKeys and MaxBytes limit the members returned
+	// by all iterations of the connection together, MaxDuration limits every iteration from its first call.
+	// A call exceeding the quota fails with an error reply.
+	//
+	// Default: unlimited.
+	ConnQuota pogreb.IterationQuota
+}
+
+// Server serves the set stored in a DB. The DB isn't closed by the server.
+type Server struct {
+	db        *pogreb.DB
+	set       []byte
+	opts      Options
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+// New returns a server of the DB, which holds the members of the set with the name. The options may be nil.
+func New(db *pogreb.DB, set string, opts *Options) *Server {
+	s := &Server{
+		db:        db,
+		set:       []byte(set),
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+	}
+	if opts != nil {
+		s.opts = *opts
+	}
+	return s
+}
+
+// ListenAndServe listens on the TCP address, e.g. "localhost:6379", and serves clients.
+func (s *Server) ListenAndServe(address string) error {
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts connections of the listener until Close is called, it then returns ErrServerClosed.
+// The listener is closed when Serve returns.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		_ = l.Close()
+		return ErrServerClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, l)
+		s.mu.Unlock()
+		_ = l.Close()
+	}()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			_ = conn.Close()
+			return ErrServerClosed
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+		go s.serveConn(conn)
+	}
+}
+
+// Close stops the listeners, closes the connections and waits for the commands in progress.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for l := range s.listeners {
+		_ = l.Close()
+	}
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return nil
+}
+
+// session is the state of a connection.
+type session struct {
+	scans    map[uint64]*pogreb.ItemIterator // Iterators of the SSCAN cursors.
+	nextScan uint64
+	quota    pogreb.IterationQuota // Quota of the connection, shared by its iterators.
+	quit     bool
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		_ = conn.Close()
+		s.wg.Done()
+	}()
+	r := bufio.NewReader(conn)
+	w := writer{bufio.NewWriter(conn)}
+	sess := &session{scans: make(map[uint64]*pogreb.ItemIterator), quota: s.opts.ConnQuota}
+	sess.quota.Usage = &pogreb.QuotaUsage{}
+	for !sess.quit {
+		args, err := readCommand(r)
+		if err != nil {
+			if err == errProtocol || err == errLineTooLarge {
+				w.error("ERR " + err.Error())
+				_ = w.Flush()
+			}
+			return
+		}
+		if len(args) > 0 {
+			s.execute(w, sess, args)
+		}
+		if r.Buffered() == 0 || sess.quit {
+			// Pipelined commands are answered together.
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// arity is the number of arguments of the commands including the name, negative numbers are minimums.
+var arity = map[string]int{
+	"PING":       -1,
+	"ECHO":       2,
+	"QUIT":       1,
+	"SELECT":     2,
+	"COMMAND":    -1,
+	"DBSIZE":     1,
+	"EXISTS":     -2,
+	"TYPE":       2,
+	"SCAN":       -2,
+	"SADD":       -3,
+	"SREM":       -3,
+	"SISMEMBER":  3,
+	"SMISMEMBER": -3,
+	"SCARD":      2,
+	"SSCAN":      -3,
+}
+
+func (s *Server) execute(w writer, sess *session, args [][]byte) {
+	name := strings.ToUpper(string(args[0]))
+	n, ok := arity[name]
+	if !ok {
+		w.error("ERR unknown command '" + string(args[0]) + "'")
+		return
+	}
+	if (n > 0 && len(args) != n) || (n < 0 && len(args) < -n) {
+		w.error("ERR wrong number of arguments for '" + strings.ToLower(name) + "' command")
+		return
+	}
+	switch name {
+	case "PING":
+		if len(args) > 1 {
+			w.bulk(args[1])
+		} else {
+			w.simple("PONG")
+		}
+	case "ECHO":
+		w.bulk(args[1])
+	case "QUIT":
+		sess.quit = true
+		w.simple("OK")
+	case "SELECT":
+		if string(args[1]) != "0" {
+			w.error("ERR DB index is out of range")
+			return
+		}
+		w.simple("OK")
+	case "COMMAND":
+		// Clients query the command table on connect, an empty table leaves them working without hints.
+		w.array(0)
+	case "DBSIZE":
+		w.bool(s.db.Count() > 0)
+	case "EXISTS":
+		n := int64(0)
+		for _, key := range args[1:] {
+			if s.isSet(key) && s.db.Count() > 0 {
+				n++
+			}
+		}
+		w.integer(n)
+	case "TYPE":
+		if s.isSet(args[1]) && s.db.Count() > 0 {
+			w.simple("set")
+		} else {
+			w.simple("none")
+		}
+	case "SCAN":
+		s.scanKeys(w, args[1:])
+	case "SADD", "SREM":
+		if !s.isSet(args[1]) {
+			w.error("ERR only the set '" + string(s.set) + "' is served")
+			return
+		}
+		if name == "SADD" {
+			s.add(w, args[2:])
+		} else {
+			s.remove(w, args[2:])
+		}
+	case "SISMEMBER", "SMISMEMBER":
+		members := args[2:]
+		if name == "SMISMEMBER" {
+			w.array(len(members))
+		}
+		for _, m := range members {
+			found := false
+			if s.isSet(args[1]) {
+				var err error
+				if found, err = s.db.Has(m); err != nil {
+					w.error("ERR " + err.Error())
+					continue
+				}
+			}
+			w.bool(found)
+		}
+	case "SCARD":
+		if s.isSet(args[1]) {
+			w.integer(int64(s.db.Count()))
+		} else {
+			w.integer(0)
+		}
+	case "SSCAN":
+		s.scanMembers(w, sess, args[1], args[2:])
+	}
+}
+
+func (s *Server) isSet(key []byte) bool {
+	return bytes.Equal(key, s.set)
+}
+
+// add replies with the number of added members.
+func (s *Server) add(w writer, members [][]byte) {
+	n := int64(0)
+	for _, m := range members {
+		found, err := s.db.HasOrPut(m)
+		if err != nil {
+			w.error("ERR " + err.Error())
+			return
+		}
+		if !found {
+			n++
+		}
+	}
+	w.integer(n)
+}
+
+// remove replies with the number of removed members.
+func (s *Server) remove(w writer, members [][]byte) {
+	n := int64(0)
+	for _, m := range members {
+		found, err := s.db.Has(m)
+		if err == nil && found {
+			err = s.db.Delete(m)
+			n++
+		}
+		if err != nil {
+			w.error("ERR " + err.Error())
+			return
+		}
+	}
+	w.integer(n)
+}
+
+// scanOptions are the options of SCAN and SSCAN.
+type scanOptions struct {
+	match []byte // Nil matches every key.
+	count int
+	typ   string // Empty matches every type.
+}
+
+func parseScanOptions(args [][]byte) (scanOptions, error) {
+	opts := scanOptions{count: defaultScanCount}
+	for i := 0; i < len(args); i += 2 {
+		if i+1 == len(args) {
+			return opts, errors.New("ERR syntax error")
+		}
+		switch strings.ToUpper(string(args[i])) {
+		case "MATCH":
+			opts.match = args[i+1]
+		case "COUNT":
+			n, err := strconv.Atoi(string(args[i+1]))
+			if err != nil || n < 1 {
+				return opts, errors.New("ERR value is not an integer or out of range")
+			}
+			opts.count = n
+		case "TYPE":
+			opts.typ = strings.ToLower(string(args[i+1]))
+		default:
+			return opts, errors.New("ERR syntax error")
+		}
+	}
+	return opts, nil
+}
+
+func (o scanOptions) matches(key []byte) bool {
+	return o.match == nil || match(o.match, key)
+}
+
+// scanKeys replies to SCAN, the keyspace holds only the set.
+func (s *Server) scanKeys(w writer, args [][]byte) {
+	if _, err := strconv.ParseUint(string(args[0]), 10, 64); err != nil {
+		w.error("ERR invalid cursor")
+		return
+	}
+	opts, err := parseScanOptions(args[1:])
+	if err != nil {
+		w.error(err.Error())
+		return
+	}
+	w.array(2)
+	w.bulk([]byte("0"))
+	if string(args[0]) == "0" && s.db.Count() > 0 && opts.matches(s.set) && (opts.typ == "" || opts.typ == "set") {
+		w.array(1)
+		w.bulk(s.set)
+		return
+	}
+	w.array(0)
+}
+
+// scanMembers replies to SSCAN with up to COUNT members and the cursor of the next call, 0 once the iteration is done.
+func (s *Server) scanMembers(w writer, sess *session, set []byte, args [][]byte) {
+	cursor, err := strconv.ParseUint(string(args[0]), 10, 64)
+	if err != nil {
+		w.error("ERR invalid cursor")
+		return
+	}
+	opts, err := parseScanOptions(args[1:])
+	if err != nil {
+		w.error(err.Error())
+		return
+	}
+	if !s.isSet(set) {
+		w.array(2)
+		w.bulk([]byte("0"))
+		w.array(0)
+		return
+	}
+	it := sess.scans[cursor]
+	if cursor == 0 {
+		it = s.db.ItemsWithQuota(sess.quota)
+	} else if it == nil {
+		w.error("ERR invalid cursor")
+		return
+	}
+	delete(sess.scans, cursor)
+	var members [][]byte
+	done := false
+	// As Redis, COUNT bounds the work of a call, the returned members are the matching ones.
+	for i := 0; i < opts.count; i++ {
+		key, err := it.Next()
+		if err == pogreb.ErrIterationDone {
+			done = true
+			break
+		}
+		if err != nil {
+			w.error("ERR " + err.Error())
+			return
+		}
+		if opts.matches(key) {
+			members = append(members, key)
+		}
+	}
+	next := uint64(0)
+	if !done {
+		sess.nextScan++
+		next = sess.nextScan
+		sess.scans[next] = it
+		delete(sess.scans, next-maxScans)
+	}
+	w.array(2)
+	w.bulk([]byte(strconv.FormatUint(next, 10)))
+	w.array(len(members))
+	for _, m := range members {
+		w.bulk(m)
+	}
+}
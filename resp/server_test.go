@@ -0,0 +1,209 @@
+package resp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/domaincrawler/pogreb"
+	"github.com/domaincrawler/pogreb/fs"
+	"github.com/domaincrawler/pogreb/internal/assert"
+)
+
+func openTestDB(t *testing.T, path string) *pogreb.DB {
+	t.Helper()
+	fsys := fs.Sub(fs.Mem, path)
+	files, err := fsys.ReadDir(".")
+	assert.Nil(t, err)
+	for _, file := range files {
+		_ = fsys.Remove(file.Name())
+	}
+	db, err := pogreb.Open(path, &pogreb.Options{FileSystem: fs.Mem})
+	assert.Nil(t, err)
+	return db
+}
+
+// testConn is a minimal RESP client.
+type testConn struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func (c *testConn) do(args ...string) interface{} {
+	c.t.Helper()
+	req := fmt.Sprintf("*%d\r\n", len(args))
+	for _, a := range args {
+		req += fmt.Sprintf("$%d\r\n%s\r\n", len(a), a)
+	}
+	_, err := io.WriteString(c.conn, req)
+	assert.Nil(c.t, err)
+	return c.reply()
+}
+
+// reply returns a simple string as is, an error as an error, an integer as int64, a bulk string as []byte
+// and an array as []interface{}.
+func (c *testConn) reply() interface{} {
+	c.t.Helper()
+	line, err := readLine(c.r)
+	assert.Nil(c.t, err)
+	rest := string(line[1:])
+	switch line[0] {
+	case '+':
+		return rest
+	case '-':
+		return fmt.Errorf("%s", rest)
+	case ':':
+		n, err := strconv.ParseInt(rest, 10, 64)
+		assert.Nil(c.t, err)
+		return n
+	case '$':
+		n, err := strconv.Atoi(rest)
+		assert.Nil(c.t, err)
+		b := make([]byte, n+2)
+		_, err = io.ReadFull(c.r, b)
+		assert.Nil(c.t, err)
+		return b[:n]
+	case '*':
+		n, err := strconv.Atoi(rest)
+		assert.Nil(c.t, err)
+		a := make([]interface{}, n)
+		for i := range a {
+			a[i] = c.reply()
+		}
+		return a
+	}
+	c.t.Fatalf("unexpected reply %q", line)
+	return nil
+}
+
+func TestServer(t *testing.T) {
+	db := openTestDB(t, "resp.db")
+	defer db.Close()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	srv := New(db, "seen", nil)
+	go func() {
+		_ = srv.Serve(l)
+	}()
+	defer srv.Close()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	assert.Nil(t, err)
+	defer conn.Close()
+	c := &testConn{t: t, conn: conn, r: bufio.NewReader(conn)}
+
+	assert.Equal(t, "PONG", c.do("PING"))
+	assert.Equal(t, int64(0), c.do("EXISTS", "seen"))
+	assert.Equal(t, "none", c.do("TYPE", "seen"))
+	assert.Equal(t, int64(2), c.do("SADD", "seen", "a", "b", "a"))
+	assert.Equal(t, int64(1), c.do("sadd", "seen", "b", "c"))
+	assert.Equal(t, int64(1), c.do("SISMEMBER", "seen", "a"))
+	assert.Equal(t, int64(0), c.do("SISMEMBER", "other", "a"))
+	assert.Equal(t, []interface{}{int64(1), int64(0)}, c.do("SMISMEMBER", "seen", "c", "d"))
+	assert.Equal(t, int64(3), c.do("SCARD", "seen"))
+	assert.Equal(t, int64(0), c.do("SCARD", "other"))
+	assert.Equal(t, int64(1), c.do("SREM", "seen", "c", "d"))
+	assert.Equal(t, "set", c.do("TYPE", "seen"))
+	assert.Equal(t, int64(1), c.do("DBSIZE"))
+	assert.Equal(t, []interface{}{[]byte("0"), []interface{}{[]byte("seen")}}, c.do("SCAN", "0", "MATCH", "s*"))
+	assert.Equal(t, []interface{}{[]byte("0"), []interface{}{}}, c.do("SCAN", "0", "MATCH", "x*"))
+	assert.Equal(t, fmt.Errorf("ERR only the set 'seen' is served"), c.do("SADD", "other", "a"))
+	assert.Equal(t, fmt.Errorf("ERR wrong number of arguments for 'scard' command"), c.do("SCARD"))
+	assert.Equal(t, fmt.Errorf("ERR unknown command 'GET'"), c.do("GET", "seen"))
+
+	// Inline commands and pipelining.
+	_, err = io.WriteString(conn, "PING\r\nSCARD seen\r\n")
+	assert.Nil(t, err)
+	assert.Equal(t, "PONG", c.reply())
+	assert.Equal(t, int64(2), c.reply())
+
+	var want []string
+	for i := 0; i < 100; i++ {
+		key := "https://example.com/" + strconv.Itoa(i)
+		want = append(want, key)
+		assert.Nil(t, db.Put([]byte(key)))
+	}
+	var got []string
+	cursor := "0"
+	for {
+		res := c.do("SSCAN", "seen", cursor, "MATCH", "https://*", "COUNT", "7").([]interface{})
+		for _, m := range res[1].([]interface{}) {
+			got = append(got, string(m.([]byte)))
+		}
+		if cursor = string(res[0].([]byte)); cursor == "0" {
+			break
+		}
+	}
+	sort.Strings(want)
+	sort.Strings(got)
+	assert.Equal(t, want, got)
+	assert.Equal(t, fmt.Errorf("ERR invalid cursor"), c.do("SSCAN", "seen", "12345"))
+
+	assert.Equal(t, "OK", c.do("QUIT"))
+	_, err = c.r.ReadByte()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestConnQuota(t *testing.T) {
+	db := openTestDB(t, "quota.db")
+	defer db.Close()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	srv := New(db, "seen", &Options{ConnQuota: pogreb.IterationQuota{MaxKeys: 25}})
+	go func() {
+		_ = srv.Serve(l)
+	}()
+	defer srv.Close()
+	dial := func() *testConn {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		assert.Nil(t, err)
+		return &testConn{t: t, conn: conn, r: bufio.NewReader(conn)}
+	}
+	c := dial()
+	defer c.conn.Close()
+	for i := 0; i < 20; i++ {
+		assert.Nil(t, db.Put([]byte(strconv.Itoa(i))))
+	}
+
+	res := c.do("SSCAN", "seen", "0", "COUNT", "20").([]interface{})
+	assert.Equal(t, 20, len(res[1].([]interface{})))
+	res = c.do("SSCAN", "seen", "0", "COUNT", "5").([]interface{})
+	assert.Equal(t, 5, len(res[1].([]interface{})))
+
+	// The iterations of the connection share the quota.
+	cursor := string(res[0].([]byte))
+	assert.Equal(t, fmt.Errorf("ERR iteration quota exceeded"), c.do("SSCAN", "seen", cursor))
+	assert.Equal(t, fmt.Errorf("ERR iteration quota exceeded"), c.do("SSCAN", "seen", "0"))
+	assert.Equal(t, int64(20), c.do("SCARD", "seen"))
+
+	// Another connection has a quota of its own.
+	c2 := dial()
+	defer c2.conn.Close()
+	res = c2.do("SSCAN", "seen", "0", "COUNT", "20").([]interface{})
+	assert.Equal(t, 20, len(res[1].([]interface{})))
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"*", "", true},
+		{"https://*/a", "https://example.com/x/a", true},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-c]llo", "hbllo", true},
+		{"h\\*llo", "h*llo", true},
+		{"h\\*llo", "hello", false},
+		{"*.com", "example.org", false},
+	}
+	for _, tc := range tests {
+		assert.Equal(t, tc.want, match([]byte(tc.pattern), []byte(tc.s)))
+	}
+}
@@ -0,0 +1,120 @@
+package pogreb
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/domaincrawler/pogreb/internal/errors"
+)
+
+const (
+	// sampleChainBuckets is the number of buckets of a bucket chain a sample is drawn from.
+	// Slots of longer chains aren't sampled, chains rarely overflow more than once before the bucket is split.
+	sampleChainBuckets = 2
+
+	// sampleMaxAttempts is the number of slot positions drawn per sampled key before Sample gives up.
+	sampleMaxAttempts = 64
+)
+
+var errInvalidSampleSize = errors.New("sample size must be positive")
+
+// samplePos is a slot position in the index.
+type samplePos struct {
+	shard  int
+	bucket uint32
+	slot   int
+}
+
+// Sample returns n random keys without a full scan: it draws slot positions uniformly
+// from all index buckets and returns the keys of the occupied slots, which makes every key equally likely
+// except for the rare keys of long overflow chains. The keys are distinct, all keys are returned if the DB
+// stores at most n keys. Keys are returned as they are stored, see Items.
+func (db *DB) Sample(n int) ([][]byte, error) {
+	if n <= 0 {
+		return nil, errInvalidSampleSize
+	}
+	if db.Count() <= uint64(n) {
+		return db.sampleAll()
+	}
+
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	seen := make(map[samplePos]bool, n)
+	keys := make([][]byte, 0, n)
+	for attempts := 0; len(keys) < n && attempts < sampleMaxAttempts*n; attempts++ {
+		key, pos, err := db.sampleSlot(rnd)
+		if err != nil {
+			return nil, err
+		}
+		if key == nil || seen[pos] {
+			continue
+		}
+		seen[pos] = true
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// sampleSlot reads the key of a random slot position, it returns a nil key if the slot is empty.
+func (db *DB) sampleSlot(rnd *rand.Rand) ([]byte, samplePos, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	numBuckets := make([]uint64, len(db.index.shards))
+	var total uint64
+	for i, sh := range db.index.shards {
+		sh.mu.RLock()
+		numBuckets[i] = uint64(sh.numBuckets)
+		sh.mu.RUnlock()
+		total += numBuckets[i]
+	}
+	// Every bucket is equally likely, shards with more keys have more buckets.
+	// Buckets are only added, the picked bucket still exists when the shard is locked.
+	pick := uint64(rnd.Int63n(int64(total)))
+	pos := samplePos{}
+	for i := range numBuckets {
+		if pick < numBuckets[i] {
+			pos.shard, pos.bucket = i, uint32(pick)
+			break
+		}
+		pick -= numBuckets[i]
+	}
+	shard := db.index.shards[pos.shard]
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	pos.slot = rnd.Intn(sampleChainBuckets * shard.slotsPerBucket())
+	it := shard.newBucketIterator(pos.bucket)
+	var b bucketHandle
+	for i := 0; i <= pos.slot/shard.slotsPerBucket(); i++ {
+		var err error
+		if b, err = it.next(); err == ErrIterationDone {
+			return nil, pos, nil
+		} else if err != nil {
+			return nil, pos, err
+		}
+	}
+	sl := b.slots[pos.slot%shard.slotsPerBucket()]
+	if sl.offset == 0 {
+		return nil, pos, nil
+	}
+	db.datalog.mu.RLock()
+	defer db.datalog.mu.RUnlock()
+	key, err := db.datalog.readKey(sl)
+	if err != nil {
+		return nil, pos, err
+	}
+	return append([]byte(nil), key...), pos, nil
+}
+
+func (db *DB) sampleAll() ([][]byte, error) {
+	var keys [][]byte
+	it := db.Items()
+	for {
+		key, err := it.Next()
+		if err == ErrIterationDone {
+			return keys, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+}
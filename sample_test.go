@@ -0,0 +1,36 @@
+package pogreb
+
+import (
+	"testing"
+
+	"github.com/domaincrawler/pogreb/internal/assert"
+)
+
+func TestSample(t *testing.T) {
+	db, err := createTestDB(&Options{IndexShards: 2})
+	assert.Nil(t, err)
+	_, err = db.Sample(0)
+	assert.Equal(t, errInvalidSampleSize, err)
+	keys, err := db.Sample(10)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(keys))
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(encryptionTestKey(i)))
+	}
+	keys, err = db.Sample(100)
+	assert.Nil(t, err)
+	assert.Equal(t, 100, len(keys))
+	seen := map[string]bool{}
+	for _, key := range keys {
+		assert.Equal(t, false, seen[string(key)])
+		seen[string(key)] = true
+		assertHas(t, db, key, true)
+	}
+
+	// Small DBs return all keys.
+	keys, err = db.Sample(2000)
+	assert.Nil(t, err)
+	assert.Equal(t, 1000, len(keys))
+	assert.Nil(t, db.Close())
+}
@@ -0,0 +1,313 @@
+package pogreb
+
+import (
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/domaincrawler/pogreb/internal/errors"
+)
+
+const secondaryIndexesName = "secondary" + metaExt
+
+var (
+	errUnknownSecondaryIndex      = errors.New("secondary index isn't configured in Options.SecondaryIndexes")
+	errSecondaryIndexFingerprints = errors.New("secondary indexes can't extract values from key fingerprints")
+)
+
+// KeyExtractor returns the value a secondary index maps the key to, e.g. the registrable domain of a URL,
+// or nil if the key isn't indexed. It must return the same value for a key every time it's called.
+type KeyExtractor func(key []byte) []byte
+
+// secondaryIndexes holds the posting lists of the secondary indexes of a DB, see Options.SecondaryIndexes.
+// Posting lists keep the keys as they are stored. Deleted keys are removed from the posting lists,
+// keys removed from the index without DB.deleted, e.g. by skipping corrupted records, are filtered out by Lookup.
+// A nil secondaryIndexes has no indexes.
+type secondaryIndexes struct {
+	mu      sync.Mutex
+	indexes map[string]*secondaryIndex
+	stale   bool       // The index was replaced, the posting lists are rebuilt by the next Lookup.
+	buildMu sync.Mutex // Serializes the rebuilds of stale posting lists.
+}
+
+type secondaryIndex struct {
+	extract  KeyExtractor
+	postings map[string]map[string]struct{} // Keys by extracted value.
+}
+
+// secondaryIndexesMeta is the contents of the secondary indexes file, written by Close.
+type secondaryIndexesMeta struct {
+	NumKeys  uint64                         // Number of keys of the index when the file was written.
+	Postings map[string]map[string][]string // Keys by extracted value by index name.
+}
+
+func newSecondaryIndexes(opts *Options) *secondaryIndexes {
+	if len(opts.SecondaryIndexes) == 0 {
+		return nil
+	}
+	s := &secondaryIndexes{indexes: make(map[string]*secondaryIndex, len(opts.SecondaryIndexes))}
+	for name, extract := range opts.SecondaryIndexes {
+		s.indexes[name] = &secondaryIndex{extract: extract, postings: make(map[string]map[string]struct{})}
+	}
+	return s
+}
+
+func (idx *secondaryIndex) add(key []byte) {
+	value := idx.extract(key)
+	if value == nil {
+		return
+	}
+	keys := idx.postings[string(value)]
+	if keys == nil {
+		keys = make(map[string]struct{})
+		idx.postings[string(value)] = keys
+	}
+	keys[string(key)] = struct{}{}
+}
+
+func (idx *secondaryIndex) remove(key []byte) {
+	value := idx.extract(key)
+	if value == nil {
+		return
+	}
+	keys := idx.postings[string(value)]
+	delete(keys, string(key))
+	if len(keys) == 0 {
+		delete(idx.postings, string(value))
+	}
+}
+
+// add inserts the written key into the posting lists.
+func (s *secondaryIndexes) add(key []byte) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, idx := range s.indexes {
+		idx.add(key)
+	}
+}
+
+// remove deletes the deleted keys from the posting lists.
+func (s *secondaryIndexes) remove(keys [][]byte) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, idx := range s.indexes {
+		for _, key := range keys {
+			idx.remove(key)
+		}
+	}
+}
+
+// clear empties the posting lists of a truncated DB.
+func (s *secondaryIndexes) clear() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, idx := range s.indexes {
+		idx.postings = make(map[string]map[string]struct{})
+	}
+}
+
+// invalidate makes the next Lookup rebuild the posting lists, after the index was replaced.
+func (s *secondaryIndexes) invalidate() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.stale = true
+	s.mu.Unlock()
+}
+
+// lookup returns the keys mapped to the value by the index.
+func (s *secondaryIndexes) lookup(name string, value []byte) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	idx := s.indexes[name]
+	if idx == nil {
+		return nil, errUnknownSecondaryIndex
+	}
+	keys := make([]string, 0, len(idx.postings[string(value)]))
+	for key := range idx.postings[string(value)] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// openSecondaryIndexes loads the posting lists written by the last Close, or builds them by iterating the DB.
+// A writable DB removes the file, it's outdated by the first write.
+func (db *DB) openSecondaryIndexes(recovered bool) error {
+	s := db.secondary
+	if s == nil {
+		// The posting lists are outdated by the writes of a DB without secondary indexes.
+		if !db.opts.ReadOnly {
+			if err := db.opts.FileSystem.Remove(secondaryIndexesName); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		return nil
+	}
+	if db.fingerprintSize != 0 {
+		return errSecondaryIndexFingerprints
+	}
+	loaded := false
+	if !recovered {
+		var err error
+		if loaded, err = db.readSecondaryIndexes(); err != nil {
+			return err
+		}
+	}
+	if !db.opts.ReadOnly {
+		if err := db.opts.FileSystem.Remove(secondaryIndexesName); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if loaded {
+		return nil
+	}
+	return db.rebuildSecondaryIndexes()
+}
+
+// readSecondaryIndexes loads the posting lists from the file if it holds all indexes for the keys of the DB.
+func (db *DB) readSecondaryIndexes() (bool, error) {
+	if _, err := db.opts.FileSystem.Stat(secondaryIndexesName); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	m := secondaryIndexesMeta{}
+	if err := readGobFile(db.opts.FileSystem, secondaryIndexesName, &m); err != nil {
+		return false, err
+	}
+	if m.NumKeys != db.index.count() {
+		return false, nil
+	}
+	for name := range db.secondary.indexes {
+		if _, ok := m.Postings[name]; !ok {
+			return false, nil
+		}
+	}
+	for name, idx := range db.secondary.indexes {
+		for value, keys := range m.Postings[name] {
+			set := make(map[string]struct{}, len(keys))
+			for _, key := range keys {
+				set[key] = struct{}{}
+			}
+			idx.postings[value] = set
+		}
+	}
+	return true, nil
+}
+
+// rebuildSecondaryIndexes replaces the posting lists with the posting lists of all keys of the DB.
+func (db *DB) rebuildSecondaryIndexes() error {
+	s := db.secondary
+	rebuilt := make(map[string]*secondaryIndex, len(s.indexes))
+	for name, idx := range s.indexes {
+		rebuilt[name] = &secondaryIndex{extract: idx.extract, postings: make(map[string]map[string]struct{})}
+	}
+	it := db.Items()
+	for {
+		key, err := it.Next()
+		if err == ErrIterationDone {
+			break
+		}
+		if err != nil {
+			return errors.Wrap(err, "building secondary indexes")
+		}
+		for _, idx := range rebuilt {
+			idx.add(key)
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.indexes = rebuilt
+	s.stale = false
+	return nil
+}
+
+// writeSecondaryIndexes writes the posting lists to the file loaded by the next Open.
+func (db *DB) writeSecondaryIndexes() error {
+	s := db.secondary
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stale {
+		return nil
+	}
+	m := secondaryIndexesMeta{NumKeys: db.index.count(), Postings: make(map[string]map[string][]string, len(s.indexes))}
+	for name, idx := range s.indexes {
+		postings := make(map[string][]string, len(idx.postings))
+		for value, keys := range idx.postings {
+			list := make([]string, 0, len(keys))
+			for key := range keys {
+				list = append(list, key)
+			}
+			postings[value] = list
+		}
+		m.Postings[name] = postings
+	}
+	return writeGobFile(db.opts.FileSystem, secondaryIndexesName, m)
+}
+
+// Lookup returns an iterator of the keys the secondary index maps to the value, see Options.SecondaryIndexes.
+// The iterator returns the keys stored when Lookup was called which weren't deleted since, in lexicographic order.
+func (db *DB) Lookup(indexName string, value []byte) *LookupIterator {
+	it := &LookupIterator{db: db}
+	s := db.secondary
+	if s == nil {
+		it.err = errUnknownSecondaryIndex
+		return it
+	}
+	s.buildMu.Lock()
+	s.mu.Lock()
+	stale := s.stale
+	s.mu.Unlock()
+	if stale {
+		if err := db.rebuildSecondaryIndexes(); err != nil {
+			s.buildMu.Unlock()
+			it.err = err
+			return it
+		}
+	}
+	s.buildMu.Unlock()
+	it.keys, it.err = s.lookup(indexName, value)
+	return it
+}
+
+// LookupIterator iterates the keys of a posting list of a secondary index, see DB.Lookup.
+type LookupIterator struct {
+	db   *DB
+	keys []string
+	err  error
+}
+
+// Next returns the next key, or ErrIterationDone once all keys were returned.
+func (it *LookupIterator) Next() ([]byte, error) {
+	if it.err != nil {
+		return nil, it.err
+	}
+	for len(it.keys) > 0 {
+		key := []byte(it.keys[0])
+		it.keys = it.keys[1:]
+		// The key may have been removed from the index without being removed from the posting list.
+		has, err := it.db.hasStored(key)
+		if err != nil {
+			return nil, err
+		}
+		if has {
+			return key, nil
+		}
+	}
+	return nil, ErrIterationDone
+}
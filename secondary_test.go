@@ -0,0 +1,102 @@
+package pogreb
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/domaincrawler/pogreb/internal/assert"
+	"github.com/domaincrawler/pogreb/internal/errors"
+)
+
+// testHostExtractor returns the host of a key of the form host/path.
+func testHostExtractor(key []byte) []byte {
+	i := bytes.IndexByte(key, '/')
+	if i < 0 {
+		return nil
+	}
+	return key[:i]
+}
+
+func lookupKeys(t *testing.T, db *DB, index string, value string) []string {
+	var keys []string
+	it := db.Lookup(index, []byte(value))
+	for {
+		key, err := it.Next()
+		if err == ErrIterationDone {
+			return keys
+		}
+		assert.Nil(t, err)
+		keys = append(keys, string(key))
+	}
+}
+
+func TestSecondaryIndexes(t *testing.T) {
+	opts := &Options{SecondaryIndexes: map[string]KeyExtractor{"host": testHostExtractor}}
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, db.Put([]byte(fmt.Sprintf("a.example/%d", i))))
+	}
+	assert.Nil(t, db.Put([]byte("b.example/0")))
+	assert.Nil(t, db.Put([]byte("unindexed")))
+	assert.Nil(t, db.Delete([]byte("a.example/0")))
+	_, err = db.DeleteFunc(func(key []byte) bool { return bytes.HasSuffix(key, []byte("/9")) })
+	assert.Nil(t, err)
+
+	want := []string{"a.example/1", "a.example/2", "a.example/3", "a.example/4",
+		"a.example/5", "a.example/6", "a.example/7", "a.example/8"}
+	assert.Equal(t, want, lookupKeys(t, db, "host", "a.example"))
+	assert.Equal(t, []string{"b.example/0"}, lookupKeys(t, db, "host", "b.example"))
+	assert.Equal(t, []string(nil), lookupKeys(t, db, "host", "c.example"))
+	_, err = db.Lookup("path", []byte("a")).Next()
+	assert.Equal(t, errUnknownSecondaryIndex, err)
+	assert.Nil(t, db.Close())
+
+	// Close writes the posting lists, Open loads them.
+	db, err = Open(testDBName, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, want, lookupKeys(t, db, "host", "a.example"))
+
+	// An index added later is built from the keys.
+	assert.Nil(t, db.Close())
+	opts.SecondaryIndexes["first"] = func(key []byte) []byte { return key[:1] }
+	db, err = Open(testDBName, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"b.example/0"}, lookupKeys(t, db, "first", "b"))
+	assert.Equal(t, 8, len(lookupKeys(t, db, "host", "a.example")))
+
+	assert.Nil(t, db.Truncate())
+	assert.Equal(t, []string(nil), lookupKeys(t, db, "host", "a.example"))
+	assert.Nil(t, db.Close())
+}
+
+func TestSecondaryIndexesRecovery(t *testing.T) {
+	defer removeMergeSource(t, testDBName+".fps")
+	opts := &Options{SecondaryIndexes: map[string]KeyExtractor{"host": testHostExtractor}}
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte("a.example/1")))
+	assert.Nil(t, db.Close())
+
+	// Writes of a DB without secondary indexes outdate the posting lists.
+	db, err = Open(testDBName, &Options{FileSystem: testFS})
+	assert.Nil(t, err)
+	assert.Nil(t, db.Delete([]byte("a.example/1")))
+	assert.Nil(t, db.Put([]byte("a.example/2")))
+	assert.Nil(t, db.Close())
+	db, err = Open(testDBName, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a.example/2"}, lookupKeys(t, db, "host", "a.example"))
+
+	assert.Nil(t, db.Put([]byte("a.example/3")))
+	simulateCrash(t, db)
+	db, err = Open(testDBName, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a.example/2", "a.example/3"}, lookupKeys(t, db, "host", "a.example"))
+	assert.Nil(t, db.Close())
+
+	_, err = Open(testDBName+".fps", &Options{FileSystem: testFS, StoreFingerprintsOnly: true,
+		SecondaryIndexes: opts.SecondaryIndexes})
+	assert.Equal(t, true, errors.Is(err, errSecondaryIndexFingerprints))
+}
@@ -4,8 +4,8 @@ import (
 	"bufio"
 	"encoding/binary"
 	"fmt"
-	"hash/crc32"
 	"io"
+	"time"
 )
 
 const (
@@ -20,6 +20,14 @@ type segment struct {
 	sequenceID uint64 // Logical monotonically increasing segment identifier.
 	name       string
 	meta       *segmentMeta
+	tail       *tailCache       // Recently written records, set only for the current segment.
+	current    time.Time        // Time the segment became the current segment.
+	sealed     bool             // Encrypted segment which had records when it was opened, it's never appended to.
+	rewritten  []rewrittenRange // Records copied by compaction while subscriptions were running, see Subscribe.
+	createdAt  time.Time        // Creation time, zero if the segment was created before it was recorded.
+	// Range of the sequence IDs of the segments the records were first written to, see SegmentInfo.
+	minSequenceID uint64
+	maxSequenceID uint64
 }
 
 func segmentName(id uint16, sequenceID uint64) string {
@@ -27,9 +35,9 @@ func segmentName(id uint16, sequenceID uint64) string {
 }
 
 type segmentMeta struct {
-	Full       bool
-	PutRecords uint32
-	//DeleteRecords uint32
+	Full          bool
+	PutRecords    uint32
+	DeleteRecords uint32
 	//DeletedKeys   uint32
 	//DeletedBytes  uint32
 }
@@ -42,24 +50,35 @@ func segmentMetaName(id uint16, sequenceID uint64) string {
 // +---------------+------------------+------------------+
 // | Key Size (2B) | Key              |         CRC (4B) |
 // +---------------+------------------+------------------+
+// Records of segments with headerFlagRecordFlags are followed by the 2-byte flags of the key,
+// which aren't covered by the checksum, so that updating them in place never invalidates the record.
 type record struct {
+	rtype     recordType
 	segmentID uint16
-	offset    uint32
+	offset    uint64
 	data      []byte
 	key       []byte
+	flags     uint16
 }
 
+type recordType int
+
+const (
+	recordTypePut recordType = iota
+	recordTypeDelete
+)
+
 func encodedRecordSize(kvSize uint32) uint32 {
 	// key size, key, crc32
 	return 2 + kvSize + 4
 }
 
-func encodePutRecord(key []byte) []byte {
+func encodePutRecord(key []byte, c Checksum) []byte {
 	size := encodedRecordSize(uint32(len(key)))
 	data := make([]byte, size)
 	binary.LittleEndian.PutUint16(data[:2], uint16(len(key)))
 	copy(data[2:], key)
-	checksum := crc32.ChecksumIEEE(data[:2+len(key)])
+	checksum := c.sum(data[:2+len(key)])
 	binary.LittleEndian.PutUint32(data[size-4:size], checksum)
 	return data
 }
@@ -67,23 +86,57 @@ func encodePutRecord(key []byte) []byte {
 // segmentIterator iterates over segment records.
 type segmentIterator struct {
 	f      *segment
-	offset uint32
+	offset uint64
 	r      *bufio.Reader
 	buf    []byte // kv size and crc32 reusable buffer.
 }
 
 func newSegmentIterator(f *segment) (*segmentIterator, error) {
-	if _, err := f.Seek(int64(headerSize), io.SeekStart); err != nil {
+	return newSegmentIteratorAt(f, headerSize)
+}
+
+// newSegmentIteratorAt returns an iterator starting at the record at the given offset.
+func newSegmentIteratorAt(f *segment, offset uint64) (*segmentIterator, error) {
+	if _, err := f.Seek(int64(offset), io.SeekStart); err != nil {
 		return nil, err
 	}
 	return &segmentIterator{
 		f:      f,
-		offset: headerSize,
+		offset: offset,
 		r:      bufio.NewReader(f),
 		buf:    make([]byte, 2),
 	}, nil
 }
 
+// slice returns the segment data from offset start to offset end.
+// Recently written data is served from the tail cache.
+func (seg *segment) slice(start int64, end int64) ([]byte, error) {
+	if seg.tail != nil {
+		if data, ok := seg.tail.slice(start, end); ok {
+			return data, nil
+		}
+	}
+	return seg.Slice(start, end)
+}
+
+// largeKeys returns true if the segment may contain large-key records.
+func (seg *segment) largeKeys() bool {
+	return seg.flags&headerFlagLargeKeys != 0
+}
+
+// recordFlagsSize returns the size of the flags following every record of the file.
+func (f *file) recordFlagsSize() uint32 {
+	if f.flags&headerFlagRecordFlags != 0 {
+		return recordFlagsSize
+	}
+	return 0
+}
+
+// corruption returns the error describing a corrupted record at the current offset.
+func (it *segmentIterator) corruption(reason string) error {
+	return &CorruptionError{Segment: it.f.name, Offset: int64(it.offset), Reason: reason}
+}
+
 func (it *segmentIterator) next() (record, error) {
 	// Read key and value size.
 	kvSizeBuf := it.buf
@@ -96,6 +149,9 @@ func (it *segmentIterator) next() (record, error) {
 
 	// Decode key size.
 	keySize := uint32(binary.LittleEndian.Uint16(kvSizeBuf[:2]))
+	if keySize == largeKeyMarker && it.f.largeKeys() {
+		return it.nextLargeKey()
+	}
 
 	//// Decode value size and record type.
 	//valueSize := binary.LittleEndian.Uint32(kvSizeBuf[2:])
@@ -104,7 +160,7 @@ func (it *segmentIterator) next() (record, error) {
 	//}
 
 	// Read key, value and checksum.
-	recordSize := encodedRecordSize(keySize)
+	recordSize := encodedRecordSize(keySize) + it.f.recordOverhead()
 	data := make([]byte, recordSize)
 	copy(data, kvSizeBuf)
 	if _, err := io.ReadFull(it.r, data[2:]); err != nil {
@@ -113,17 +169,101 @@ func (it *segmentIterator) next() (record, error) {
 
 	// Verify checksum.
 	checksum := binary.LittleEndian.Uint32(data[len(data)-4:])
-	if checksum != crc32.ChecksumIEEE(data[:len(data)-4]) {
-		return record{}, errCorrupted
+	if checksum != it.f.checksum.sum(data[:len(data)-4]) {
+		return record{}, it.corruption("record checksum doesn't match")
+	}
+
+	key := data[2 : 2+keySize]
+	if it.f.cipher != nil {
+		var err error
+		if key, err = it.f.cipher.decrypt(data[:len(data)-4], it.offset, 2); err != nil {
+			return record{}, it.corruption("record authentication failed")
+		}
+	}
+
+	flags, err := it.readFlags()
+	if err != nil {
+		return record{}, err
+	}
+
+	offset := it.offset
+	it.offset += uint64(recordSize + it.f.recordFlagsSize())
+	rec := record{
+		segmentID: it.f.id,
+		offset:    offset,
+		data:      data,
+		key:       key,
+		flags:     flags,
+	}
+	return rec, nil
+}
+
+// readFlags reads the flags following the record, see headerFlagRecordFlags.
+func (it *segmentIterator) readFlags() (uint16, error) {
+	if it.f.recordFlagsSize() == 0 {
+		return 0, nil
+	}
+	if _, err := io.ReadFull(it.r, it.buf); err != nil {
+		if err == io.EOF {
+			// The record was written partially.
+			return 0, io.ErrUnexpectedEOF
+		}
+		return 0, err
+	}
+	return binary.LittleEndian.Uint16(it.buf), nil
+}
+
+func (it *segmentIterator) nextLargeKey() (record, error) {
+	hdr := make([]byte, largeKeyHeaderSize)
+	copy(hdr, it.buf)
+	if _, err := io.ReadFull(it.r, hdr[2:]); err != nil {
+		return record{}, err
+	}
+	keySize := binary.LittleEndian.Uint32(hdr[2:6])
+	rtype := recordTypePut
+	if keySize&deleteRecordFlag != 0 {
+		keySize &^= deleteRecordFlag
+		rtype = recordTypeDelete
+	}
+	if keySize > MaxLargeKeyLength {
+		return record{}, it.corruption(fmt.Sprintf("key size %d exceeds MaxLargeKeyLength", keySize))
+	}
+
+	recordSize := largeKeyHeaderSize + keySize + it.f.recordOverhead() + 4
+	data := make([]byte, recordSize)
+	copy(data, hdr)
+	if _, err := io.ReadFull(it.r, data[largeKeyHeaderSize:]); err != nil {
+		return record{}, err
+	}
+
+	checksum := binary.LittleEndian.Uint32(data[len(data)-4:])
+	if checksum != it.f.checksum.sum(data[:len(data)-4]) {
+		return record{}, it.corruption("record checksum doesn't match")
+	}
+
+	key := data[largeKeyHeaderSize : largeKeyHeaderSize+keySize]
+	if it.f.cipher != nil {
+		plain, err := it.f.cipher.decrypt(data[:len(data)-4], it.offset, 2+4)
+		if err != nil {
+			return record{}, it.corruption("record authentication failed")
+		}
+		key = plain[largeKeyDigestSize:]
+	}
+
+	flags, err := it.readFlags()
+	if err != nil {
+		return record{}, err
 	}
 
 	offset := it.offset
-	it.offset += recordSize
+	it.offset += uint64(recordSize + it.f.recordFlagsSize())
 	rec := record{
+		rtype:     rtype,
 		segmentID: it.f.id,
 		offset:    offset,
 		data:      data,
-		key:       data[2 : 2+keySize],
+		key:       key,
+		flags:     flags,
 	}
 	return rec, nil
 }
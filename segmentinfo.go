@@ -0,0 +1,94 @@
+package pogreb
+
+import (
+	"time"
+)
+
+// SegmentInfo describes a segment of the datalog, see DB.Segments.
+type SegmentInfo struct {
+	Name       string    // File name of the segment.
+	ID         uint16    // Physical segment identifier, reused once the segment is removed.
+	SequenceID uint64    // Logical segment identifier, increasing with every segment created.
+	Size       int64     // Size of the segment, including the header.
+	CreatedAt  time.Time // Time the segment was created, zero if it was created before the time was recorded.
+	Records    uint64    // Number of put and delete records.
+	// Range of the sequence IDs of the segments the records were first written to. Records copied by
+	// compaction keep the range of their source segment, the range of a segment which holds none is its
+	// sequence ID. Segments created before the range was recorded report their sequence ID.
+	MinSequenceID uint64
+	MaxSequenceID uint64
+	Full          bool // No more records are appended to the segment.
+	Archived      bool // The segment is held by Options.Archiver.
+}
+
+// Segments returns the segments of the datalog ordered by sequence ID, e.g. to find the segments
+// written before a time or the oldest records.
+func (db *DB) Segments() []SegmentInfo {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	segments := db.datalog.segmentsBySequenceID()
+	db.datalog.mu.RLock()
+	defer db.datalog.mu.RUnlock()
+	infos := make([]SegmentInfo, len(segments))
+	for i, seg := range segments {
+		infos[i] = SegmentInfo{
+			Name:          seg.name,
+			ID:            seg.id,
+			SequenceID:    seg.sequenceID,
+			Size:          seg.size,
+			CreatedAt:     seg.createdAt,
+			Records:       seg.records(),
+			MinSequenceID: seg.minSequenceID,
+			MaxSequenceID: seg.maxSequenceID,
+			Full:          seg.meta.Full,
+			Archived:      seg.archived(),
+		}
+	}
+	return infos
+}
+
+// records returns the number of records of the segment counted by its meta.
+func (seg *segment) records() uint64 {
+	return uint64(seg.meta.PutRecords) + uint64(seg.meta.DeleteRecords)
+}
+
+// openStats sets the statistics of the segment from the header.
+func (seg *segment) openStats() {
+	if seg.stats.createdAt != 0 {
+		seg.createdAt = time.Unix(0, seg.stats.createdAt)
+	}
+	seg.minSequenceID, seg.maxSequenceID = seg.stats.minSequenceID, seg.stats.maxSequenceID
+	if seg.minSequenceID == 0 {
+		seg.minSequenceID, seg.maxSequenceID = seg.sequenceID, seg.sequenceID
+	}
+}
+
+// writeStats rewrites the header if the statistics of the segment changed since they were written.
+func (seg *segment) writeStats() error {
+	stats := segmentStats{
+		records:       seg.records(),
+		minSequenceID: seg.minSequenceID,
+		maxSequenceID: seg.maxSequenceID,
+	}
+	if !seg.createdAt.IsZero() {
+		stats.createdAt = seg.createdAt.UnixNano()
+	}
+	if stats == seg.stats {
+		return nil
+	}
+	return seg.setStats(stats)
+}
+
+// recordCopy widens the sequence ID range of the segment with the ID to the range of the segment
+// compaction copied the record from.
+func (dl *datalog) recordCopy(rec record, segmentID uint16) {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	src, seg := dl.segments[rec.segmentID], dl.segments[segmentID]
+	if src.minSequenceID < seg.minSequenceID {
+		seg.minSequenceID = src.minSequenceID
+	}
+	if src.maxSequenceID > seg.maxSequenceID {
+		seg.maxSequenceID = src.maxSequenceID
+	}
+}
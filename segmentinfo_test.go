@@ -0,0 +1,62 @@
+package pogreb
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/domaincrawler/pogreb/internal/assert"
+)
+
+func TestSegments(t *testing.T) {
+	clock := newManualClock()
+	opts := &Options{maxSegmentSize: 1024, Clock: clock}
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+	created := clock.Now()
+	for i := 0; i < 150; i++ {
+		assert.Nil(t, db.Put(deleteTestKey(i)))
+	}
+	clock.advance(time.Hour)
+	for i := 150; i < 300; i++ {
+		assert.Nil(t, db.Put(deleteTestKey(i)))
+	}
+	segments := db.Segments()
+	assert.Equal(t, true, len(segments) > 2)
+	var records uint64
+	for i, seg := range segments {
+		if i > 0 {
+			assert.Equal(t, true, seg.SequenceID > segments[i-1].SequenceID)
+		}
+		assert.Equal(t, seg.SequenceID, seg.MinSequenceID)
+		assert.Equal(t, seg.SequenceID, seg.MaxSequenceID)
+		assert.Equal(t, i < len(segments)-1, seg.Full)
+		records += seg.Records
+	}
+	assert.Equal(t, uint64(300), records)
+	assert.Equal(t, true, segments[0].CreatedAt.Equal(created))
+	assert.Equal(t, true, segments[len(segments)-1].CreatedAt.Equal(created.Add(time.Hour)))
+
+	// The records copied by compaction keep the sequence IDs of their source segments.
+	_, err = db.DeleteWhere(func(key []byte) bool {
+		return binary.BigEndian.Uint32(key) < 10
+	})
+	assert.Nil(t, err)
+	segments = db.Segments()
+	last := segments[len(segments)-1]
+	assert.Equal(t, true, last.MinSequenceID < last.SequenceID)
+	assert.Equal(t, last.SequenceID, last.MaxSequenceID)
+
+	// The statistics are read from the segment headers.
+	assert.Nil(t, db.Close())
+	db, err = Open(testDBName, opts)
+	assert.Nil(t, err)
+	defer db.Close()
+	reopened := db.Segments()
+	assert.Equal(t, len(segments), len(reopened))
+	for i, seg := range reopened {
+		assert.Equal(t, true, seg.CreatedAt.Equal(segments[i].CreatedAt))
+		seg.CreatedAt = segments[i].CreatedAt
+		assert.Equal(t, segments[i], seg)
+	}
+}
@@ -0,0 +1,254 @@
+package server
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+
+	"github.com/domaincrawler/pogreb"
+)
+
+var (
+	errClientClosed     = errors.New("client closed")
+	errIteratorClosed   = errors.New("iterator closed")
+	errUnexpectedResult = errors.New("unexpected response")
+)
+
+// Client is a connection pool to a Server. It's safe for concurrent use by multiple goroutines,
+// every goroutine uses a connection of its own.
+type Client struct {
+	network string
+	address string
+	mu      sync.Mutex
+	idle    []*clientConn
+	closed  bool
+}
+
+type clientConn struct {
+	net.Conn
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+// Dial connects to the server listening on the network address.
+func Dial(network, address string) (*Client, error) {
+	c := &Client{network: network, address: address}
+	conn, err := c.dial()
+	if err != nil {
+		return nil, err
+	}
+	c.idle = append(c.idle, conn)
+	return c, nil
+}
+
+func (c *Client) dial() (*clientConn, error) {
+	conn, err := net.Dial(c.network, c.address)
+	if err != nil {
+		return nil, err
+	}
+	return &clientConn{Conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn)}, nil
+}
+
+// get returns an idle connection or a new one.
+func (c *Client) get() (*clientConn, error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, errClientClosed
+	}
+	if n := len(c.idle); n > 0 {
+		conn := c.idle[n-1]
+		c.idle = c.idle[:n-1]
+		c.mu.Unlock()
+		return conn, nil
+	}
+	c.mu.Unlock()
+	return c.dial()
+}
+
+// put returns the connection to the pool.
+func (c *Client) put(conn *clientConn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		_ = conn.Close()
+		return
+	}
+	c.idle = append(c.idle, conn)
+}
+
+// send writes the request.
+func (conn *clientConn) send(op byte, key []byte) error {
+	if err := writeFrame(conn.w, []byte{op}, key); err != nil {
+		return err
+	}
+	return conn.w.Flush()
+}
+
+// receive reads the next response frame.
+func (conn *clientConn) receive() (byte, []byte, error) {
+	payload, err := readFrame(conn.r)
+	if err != nil {
+		return 0, nil, err
+	}
+	return decodeResponse(payload)
+}
+
+// roundTrip sends the request and returns the result of the response.
+// A connection failing with a network error is discarded, the error of the DB leaves it usable.
+func (c *Client) roundTrip(op byte, key []byte) ([]byte, error) {
+	conn, err := c.get()
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.send(op, key); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	status, res, err := conn.receive()
+	var remote *Error
+	if err != nil && !errors.As(err, &remote) {
+		_ = conn.Close()
+		return nil, err
+	}
+	c.put(conn)
+	if err == nil && status != statusOK {
+		return nil, errUnexpectedResult
+	}
+	return res, err
+}
+
+func (c *Client) boolRoundTrip(op byte, key []byte) (bool, error) {
+	res, err := c.roundTrip(op, key)
+	if err != nil {
+		return false, err
+	}
+	if len(res) != 1 {
+		return false, errUnexpectedResult
+	}
+	return res[0] == 1, nil
+}
+
+// Put sets the key, see pogreb.DB.Put.
+func (c *Client) Put(key []byte) error {
+	_, err := c.roundTrip(opPut, key)
+	return err
+}
+
+// Has returns true if the DB contains the key, see pogreb.DB.Has.
+func (c *Client) Has(key []byte) (bool, error) {
+	return c.boolRoundTrip(opHas, key)
+}
+
+// HasOrPut returns true if the DB contains the key, otherwise it inserts the key, see pogreb.DB.HasOrPut.
+func (c *Client) HasOrPut(key []byte) (bool, error) {
+	return c.boolRoundTrip(opHasOrPut, key)
+}
+
+// Count returns the number of keys in the DB, or 0 if the request fails, see CountKeys.
+func (c *Client) Count() uint64 {
+	n, _ := c.CountKeys()
+	return n
+}
+
+// CountKeys returns the number of keys in the DB.
+func (c *Client) CountKeys() (uint64, error) {
+	res, err := c.roundTrip(opCount, nil)
+	if err != nil {
+		return 0, err
+	}
+	if len(res) != 8 {
+		return 0, errUnexpectedResult
+	}
+	return binary.BigEndian.Uint64(res), nil
+}
+
+// Items returns an iterator of the keys of the DB. The iterator uses a connection of its own
+// until it returns an error, it must be closed if it's abandoned earlier.
+func (c *Client) Items() *Iterator {
+	return &Iterator{c: c}
+}
+
+// Close closes the idle connections, the connections in use are closed when they are released.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	for _, conn := range c.idle {
+		_ = conn.Close()
+	}
+	c.idle = nil
+	return nil
+}
+
+// Iterator iterates the keys of a remote DB, see Client.Items.
+type Iterator struct {
+	c     *Client
+	conn  *clientConn
+	keys  [][]byte // Keys of the current batch.
+	err   error
+	begun bool
+}
+
+// Next returns the next key, or pogreb.ErrIterationDone once all keys were returned.
+func (it *Iterator) Next() ([]byte, error) {
+	for len(it.keys) == 0 {
+		if it.err != nil {
+			return nil, it.err
+		}
+		it.err = it.fetch()
+	}
+	key := it.keys[0]
+	it.keys = it.keys[1:]
+	return key, nil
+}
+
+// fetch reads the next batch of keys, sending the request first.
+func (it *Iterator) fetch() error {
+	if !it.begun {
+		it.begun = true
+		conn, err := it.c.get()
+		if err != nil {
+			return err
+		}
+		if err := conn.send(opItems, nil); err != nil {
+			_ = conn.Close()
+			return err
+		}
+		it.conn = conn
+	}
+	status, batch, err := it.conn.receive()
+	var remote *Error
+	if err != nil && !errors.As(err, &remote) {
+		it.Close()
+		return err
+	}
+	if err != nil || status == statusOK {
+		// The response is complete, the connection can be reused.
+		it.c.put(it.conn)
+		it.conn = nil
+		if err != nil {
+			return err
+		}
+		return pogreb.ErrIterationDone
+	}
+	if it.keys, err = readBatch(batch); err != nil {
+		it.Close()
+		return err
+	}
+	return nil
+}
+
+// Close releases the connection of an unfinished iterator.
+func (it *Iterator) Close() {
+	if it.conn != nil {
+		_ = it.conn.Close()
+		it.conn = nil
+	}
+	it.keys = nil
+	if it.err == nil {
+		it.err = errIteratorClosed
+	}
+}
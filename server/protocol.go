@@ -0,0 +1,146 @@
+package server
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/domaincrawler/pogreb"
+)
+
+// Request operations, the first byte of a request frame. The rest of the frame is the key.
+const (
+	opPut byte = iota + 1
+	opHas
+	opHasOrPut
+	opCount
+	opItems
+)
+
+// Response statuses, the first byte of a response frame.
+const (
+	// statusOK is followed by the result: a boolean byte for Has and HasOrPut, the 8-byte count for Count,
+	// nothing for Put and for the last frame of Items.
+	statusOK byte = iota
+
+	// statusError is followed by the error code byte and the error message.
+	statusError
+
+	// statusItems is followed by a batch of keys, each preceded by its uvarint size.
+	// Items responds with batches terminated by a statusOK frame.
+	statusItems
+)
+
+const (
+	// maxFrameSize fits the largest key, see pogreb.MaxLargeKeyLength.
+	maxFrameSize = 1 + pogreb.MaxLargeKeyLength + 64
+
+	// itemsBatchSize is the size of key data after which the server sends a batch of Items.
+	itemsBatchSize = 64 << 10
+)
+
+var errFrameTooLarge = errors.New("frame exceeds the maximum size")
+
+// writeFrame writes a frame: the 4-byte big-endian size of the payload and the payload made of the parts.
+func writeFrame(w *bufio.Writer, parts ...[]byte) error {
+	size := 0
+	for _, p := range parts {
+		size += len(p)
+	}
+	if size > maxFrameSize {
+		return errFrameTooLarge
+	}
+	var hdr [4]byte
+	binary.BigEndian.PutUint32(hdr[:], uint32(size))
+	if _, err := w.Write(hdr[:]); err != nil {
+		return err
+	}
+	for _, p := range parts {
+		if _, err := w.Write(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readFrame returns the payload of the next frame.
+func readFrame(r *bufio.Reader) ([]byte, error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(hdr[:])
+	if size > maxFrameSize {
+		return nil, errFrameTooLarge
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return payload, nil
+}
+
+// Error is an error returned by the DB of the server.
+type Error struct {
+	Code    pogreb.ErrorCode
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Unwrap returns the exported pogreb error of the code, so that errors.Is and pogreb.ErrorCodeOf
+// recognize remote errors, or nil if pogreb exports no error with the code.
+func (e *Error) Unwrap() error {
+	switch e.Code {
+	case pogreb.CodeKeyTooLarge:
+		return pogreb.ErrKeyTooLarge
+	case pogreb.CodeCorrupted:
+		return pogreb.ErrCorrupted
+	case pogreb.CodeLocked:
+		return pogreb.ErrLocked
+	case pogreb.CodeBusy:
+		return pogreb.ErrBusy
+	case pogreb.CodeFull:
+		return pogreb.ErrFull
+	case pogreb.CodeBlocked:
+		return pogreb.ErrBlocked
+	case pogreb.CodeIterationDone:
+		return pogreb.ErrIterationDone
+	case pogreb.CodeQuotaExceeded:
+		return pogreb.ErrQuotaExceeded
+	case pogreb.CodeDiskFull:
+		return pogreb.ErrDiskFull
+	}
+	return nil
+}
+
+func encodeError(err error) []byte {
+	msg := err.Error()
+	payload := make([]byte, 0, 2+len(msg))
+	payload = append(payload, statusError, byte(pogreb.ErrorCodeOf(err)))
+	return append(payload, msg...)
+}
+
+// decodeResponse returns the result of a response frame, or the error it holds.
+func decodeResponse(payload []byte) (byte, []byte, error) {
+	if len(payload) == 0 {
+		return 0, nil, errors.New("empty response")
+	}
+	switch payload[0] {
+	case statusOK, statusItems:
+		return payload[0], payload[1:], nil
+	case statusError:
+		if len(payload) < 2 {
+			return 0, nil, errors.New("truncated error response")
+		}
+		return 0, nil, &Error{Code: pogreb.ErrorCode(payload[1]), Message: string(payload[2:])}
+	}
+	return 0, nil, fmt.Errorf("unknown response status %d", payload[0])
+}
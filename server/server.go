@@ -0,0 +1,256 @@
+/*
+Package server shares a pogreb database between processes of a host.
+
+A Server exposes an open DB over a stream listener, e.g. a TCP or a unix socket, and a Client
+connecting to it implements Put, Has, HasOrPut, Count and iteration like the DB itself.
+The protocol is made of length-prefixed frames: every frame is the 4-byte big-endian size of the payload
+followed by the payload. A request is the operation byte followed by the key, a response is the status byte
+followed by the result. Requests of a connection are answered in order, the server handles connections concurrently.
+*/
+package server
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/domaincrawler/pogreb"
+)
+
+// ErrServerClosed is returned by Serve after Close.
+var ErrServerClosed = errors.New("server closed")
+
+// DB is the interface of the methods of *pogreb.DB implemented by Client,
+// code written against it works with a local and a remote database.
+type DB interface {
+	Put(key []byte) error
+	Has(key []byte) (bool, error)
+	HasOrPut(key []byte) (bool, error)
+	Count() uint64
+	Close() error
+}
+
+var (
+	_ DB = (*pogreb.DB)(nil)
+	_ DB = (*Client)(nil)
+)
+
+// Options holds the limits of a Server.
+type Options struct {
+	// ConnQuota limits the iterations of a connection: MaxKeys and MaxBytes limit the keys returned
+	// by all iterations of the connection together, MaxDuration limits every iteration.
+	// An iteration exceeding the quota fails with pogreb.ErrQuotaExceeded.
+	//
+	// Default: unlimited.
+	ConnQuota pogreb.IterationQuota
+}
+
+// Server serves requests for a DB. The DB isn't closed by the server.
+type Server struct {
+	db        *pogreb.DB
+	opts      Options
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+// New returns a server of the DB. The options may be nil.
+func New(db *pogreb.DB, opts *Options) *Server {
+	s := &Server{
+		db:        db,
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+	}
+	if opts != nil {
+		s.opts = *opts
+	}
+	return s
+}
+
+// ListenAndServe listens on the network address, e.g. "unix" and a socket path, and serves requests.
+func (s *Server) ListenAndServe(network, address string) error {
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts connections of the listener until Close is called, it then returns ErrServerClosed.
+// The listener is closed when Serve returns.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		_ = l.Close()
+		return ErrServerClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, l)
+		s.mu.Unlock()
+		_ = l.Close()
+	}()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			_ = conn.Close()
+			return ErrServerClosed
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+		go s.serveConn(conn)
+	}
+}
+
+// Close stops the listeners, closes the connections and waits for the requests in progress.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for l := range s.listeners {
+		_ = l.Close()
+	}
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return nil
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		_ = conn.Close()
+		s.wg.Done()
+	}()
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	quota := s.opts.ConnQuota
+	quota.Usage = &pogreb.QuotaUsage{}
+	for {
+		req, err := readFrame(r)
+		if err != nil {
+			// The client disconnected or sent a malformed frame.
+			return
+		}
+		if err := s.handle(w, req, quota); err != nil {
+			return
+		}
+		if r.Buffered() == 0 {
+			// Pipelined requests are answered together.
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// handle executes the request and writes the response, iterations are limited by the quota of the connection.
+func (s *Server) handle(w *bufio.Writer, req []byte, quota pogreb.IterationQuota) error {
+	if len(req) == 0 {
+		return errors.New("empty request")
+	}
+	op, key := req[0], req[1:]
+	switch op {
+	case opPut:
+		if err := s.db.Put(key); err != nil {
+			return writeFrame(w, encodeError(err))
+		}
+		return writeFrame(w, []byte{statusOK})
+	case opHas, opHasOrPut:
+		var found bool
+		var err error
+		if op == opHas {
+			found, err = s.db.Has(key)
+		} else {
+			found, err = s.db.HasOrPut(key)
+		}
+		if err != nil {
+			return writeFrame(w, encodeError(err))
+		}
+		res := []byte{statusOK, 0}
+		if found {
+			res[1] = 1
+		}
+		return writeFrame(w, res)
+	case opCount:
+		res := make([]byte, 9)
+		binary.BigEndian.PutUint64(res[1:], s.db.Count())
+		return writeFrame(w, res)
+	case opItems:
+		return s.items(w, quota)
+	}
+	return errors.New("unknown operation")
+}
+
+// items writes the keys of the DB in batches followed by the final frame,
+// or by an error frame once the iteration fails, e.g. when it exceeds the quota.
+func (s *Server) items(w *bufio.Writer, quota pogreb.IterationQuota) error {
+	it := s.db.ItemsWithQuota(quota)
+	batch := []byte{statusItems}
+	var size [binary.MaxVarintLen64]byte
+	for {
+		key, err := it.Next()
+		if err == pogreb.ErrIterationDone {
+			break
+		}
+		if err != nil {
+			if len(batch) > 1 {
+				if err := writeFrame(w, batch); err != nil {
+					return err
+				}
+			}
+			return writeFrame(w, encodeError(err))
+		}
+		if len(batch) > 1 && len(batch)+binary.MaxVarintLen64+len(key) > itemsBatchSize {
+			if err := writeFrame(w, batch); err != nil {
+				return err
+			}
+			batch = batch[:1]
+		}
+		batch = append(batch, size[:binary.PutUvarint(size[:], uint64(len(key)))]...)
+		batch = append(batch, key...)
+	}
+	if len(batch) > 1 {
+		if err := writeFrame(w, batch); err != nil {
+			return err
+		}
+	}
+	return writeFrame(w, []byte{statusOK})
+}
+
+// readBatch splits a batch of Items into keys.
+func readBatch(batch []byte) ([][]byte, error) {
+	var keys [][]byte
+	for len(batch) > 0 {
+		size, n := binary.Uvarint(batch)
+		if n <= 0 || uint64(len(batch)-n) < size {
+			return nil, io.ErrUnexpectedEOF
+		}
+		keys = append(keys, batch[n:n+int(size)])
+		batch = batch[n+int(size):]
+	}
+	return keys, nil
+}
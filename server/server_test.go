@@ -0,0 +1,158 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/domaincrawler/pogreb"
+	"github.com/domaincrawler/pogreb/fs"
+	"github.com/domaincrawler/pogreb/internal/assert"
+)
+
+func startTestServer(t *testing.T, path, network, address string, opts *Options) (*pogreb.DB, *Server, net.Listener) {
+	t.Helper()
+	fsys := fs.Sub(fs.Mem, path)
+	files, err := fsys.ReadDir(".")
+	assert.Nil(t, err)
+	for _, file := range files {
+		_ = fsys.Remove(file.Name())
+	}
+	db, err := pogreb.Open(path, &pogreb.Options{FileSystem: fs.Mem})
+	assert.Nil(t, err)
+	l, err := net.Listen(network, address)
+	assert.Nil(t, err)
+	srv := New(db, opts)
+	go func() {
+		_ = srv.Serve(l)
+	}()
+	return db, srv, l
+}
+
+func stopTestServer(t *testing.T, db *pogreb.DB, srv *Server) {
+	t.Helper()
+	assert.Nil(t, srv.Close())
+	assert.Nil(t, db.Close())
+}
+
+func TestClient(t *testing.T) {
+	db, srv, l := startTestServer(t, "client.db", "tcp", "127.0.0.1:0", nil)
+	defer stopTestServer(t, db, srv)
+	c, err := Dial("tcp", l.Addr().String())
+	assert.Nil(t, err)
+	defer c.Close()
+
+	var remote DB = c
+	assert.Nil(t, remote.Put([]byte("a")))
+	found, err := remote.HasOrPut([]byte("b"))
+	assert.Nil(t, err)
+	assert.Equal(t, false, found)
+	found, err = remote.HasOrPut([]byte("b"))
+	assert.Nil(t, err)
+	assert.Equal(t, true, found)
+	found, err = remote.Has([]byte("c"))
+	assert.Nil(t, err)
+	assert.Equal(t, false, found)
+	assert.Equal(t, uint64(2), remote.Count())
+
+	// Errors of the DB keep their codes.
+	err = c.Put(make([]byte, pogreb.MaxKeyLength+1))
+	assert.Equal(t, pogreb.CodeKeyTooLarge, pogreb.ErrorCodeOf(err))
+	assert.Nil(t, c.Put([]byte("c")))
+}
+
+func TestClientItems(t *testing.T) {
+	dir, err := os.MkdirTemp("", "pogreb-server")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	db, srv, _ := startTestServer(t, "items.db", "unix", filepath.Join(dir, "pogreb.sock"), nil)
+	defer stopTestServer(t, db, srv)
+	c, err := Dial("unix", filepath.Join(dir, "pogreb.sock"))
+	assert.Nil(t, err)
+	defer c.Close()
+
+	// Concurrent writers share the DB, the keys span multiple batches.
+	var want []string
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		for i := 0; i < 2000; i++ {
+			want = append(want, fmt.Sprintf("https://example.com/%d/%0100d", w, i))
+		}
+		wg.Add(1)
+		go func(keys []string) {
+			defer wg.Done()
+			for _, key := range keys {
+				if err := c.Put([]byte(key)); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(want[w*2000:])
+	}
+	wg.Wait()
+
+	var got []string
+	it := c.Items()
+	for {
+		key, err := it.Next()
+		if err == pogreb.ErrIterationDone {
+			break
+		}
+		assert.Nil(t, err)
+		got = append(got, string(key))
+	}
+	sort.Strings(got)
+	sort.Strings(want)
+	assert.Equal(t, want, got)
+
+	// An abandoned iterator releases its connection.
+	it = c.Items()
+	_, err = it.Next()
+	assert.Nil(t, err)
+	it.Close()
+	_, err = it.Next()
+	assert.Equal(t, errIteratorClosed, err)
+	assert.Equal(t, uint64(8000), c.Count())
+}
+
+func TestConnQuota(t *testing.T) {
+	db, srv, l := startTestServer(t, "quota.db", "tcp", "127.0.0.1:0", &Options{ConnQuota: pogreb.IterationQuota{MaxKeys: 25}})
+	defer stopTestServer(t, db, srv)
+	c, err := Dial("tcp", l.Addr().String())
+	assert.Nil(t, err)
+	defer c.Close()
+	for i := 0; i < 20; i++ {
+		assert.Nil(t, c.Put([]byte(fmt.Sprintf("key%d", i))))
+	}
+
+	count := func() (int, error) {
+		n := 0
+		it := c.Items()
+		for {
+			_, err := it.Next()
+			if err != nil {
+				return n, err
+			}
+			n++
+		}
+	}
+	n, err := count()
+	assert.Equal(t, pogreb.ErrIterationDone, err)
+	assert.Equal(t, 20, n)
+
+	// The iterations of the connection share the quota.
+	n, err = count()
+	assert.Equal(t, true, errors.Is(err, pogreb.ErrQuotaExceeded))
+	assert.Equal(t, 5, n)
+	n, err = count()
+	assert.Equal(t, pogreb.CodeQuotaExceeded, pogreb.ErrorCodeOf(err))
+	assert.Equal(t, 0, n)
+
+	// The quota doesn't limit other requests.
+	assert.Equal(t, uint64(20), c.Count())
+}
@@ -0,0 +1,63 @@
+package pogreb
+
+const setOpBatchSize = 1024 // Number of keys looked up in the other database at once by Diff and Intersect.
+
+// Diff calls fn for every key of the DB which the other database doesn't contain.
+// Keys of the DB are read with an iterator and looked up in the other database in batches,
+// in the order of its index buckets. If fn returns an error, Diff stops and returns it.
+// Fn is called without holding locks of either database.
+func (db *DB) Diff(other *DB, fn func(key []byte) error) error {
+	return db.setOp(other, false, fn)
+}
+
+// Intersect calls fn for every key of the DB which the other database contains too.
+// It reads the databases like Diff, iterating the DB, which should be the smaller one.
+func (db *DB) Intersect(other *DB, fn func(key []byte) error) error {
+	return db.setOp(other, true, fn)
+}
+
+// setOp calls fn for the keys of the DB whose presence in the other database is found.
+// Both databases must store keys the same way, see Options.StoreFingerprintsOnly.
+func (db *DB) setOp(other *DB, found bool, fn func(key []byte) error) error {
+	if db.fingerprintSize != other.fingerprintSize {
+		return errFingerprintMismatch
+	}
+	it := db.Items()
+	batch := make([][]byte, 0, setOpBatchSize)
+	var keys [][]byte
+	flush := func() error {
+		keys = keys[:0]
+		_, err := other.lookupMulti(batch, func(key []byte, ok bool) bool {
+			if ok == found {
+				keys = append(keys, key)
+			}
+			return false
+		})
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if err := fn(key); err != nil {
+				return err
+			}
+		}
+		batch = batch[:0]
+		return nil
+	}
+	for {
+		key, err := it.Next()
+		if err == ErrIterationDone {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		batch = append(batch, key)
+		if len(batch) == setOpBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return flush()
+}
@@ -0,0 +1,56 @@
+package pogreb
+
+import (
+	"errors"
+	"sort"
+	"testing"
+
+	"github.com/domaincrawler/pogreb/internal/assert"
+)
+
+func TestDiffIntersect(t *testing.T) {
+	path := testDBName + ".other"
+	createMergeSource(t, path, 1500, 3000)
+	defer removeMergeSource(t, path)
+	other, err := Open(path, &Options{FileSystem: testFS})
+	assert.Nil(t, err)
+	db, err := createTestDB(nil)
+	assert.Nil(t, err)
+	for i := 0; i < 2000; i++ {
+		assert.Nil(t, db.Put(deleteTestKey(i)))
+	}
+
+	collect := func(op func(*DB, func([]byte) error) error, other *DB) []string {
+		var keys []string
+		assert.Nil(t, op(other, func(key []byte) error {
+			keys = append(keys, string(key))
+			return nil
+		}))
+		sort.Strings(keys)
+		return keys
+	}
+	expected := func(from int, to int) []string {
+		var keys []string
+		for i := from; i < to; i++ {
+			keys = append(keys, string(deleteTestKey(i)))
+		}
+		sort.Strings(keys)
+		return keys
+	}
+	assert.Equal(t, expected(0, 1500), collect(db.Diff, other))
+	assert.Equal(t, expected(1500, 2000), collect(db.Intersect, other))
+	assert.Equal(t, expected(2000, 3000), collect(other.Diff, db))
+	assert.Equal(t, []string(nil), collect(db.Diff, db))
+
+	errStop := errors.New("stop")
+	n := 0
+	err = db.Diff(other, func(key []byte) error {
+		n++
+		return errStop
+	})
+	assert.Equal(t, errStop, err)
+	assert.Equal(t, 1, n)
+
+	assert.Nil(t, db.Close())
+	assert.Nil(t, other.Close())
+}
@@ -0,0 +1,163 @@
+package pogreb
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/domaincrawler/pogreb/internal/errors"
+)
+
+// shardedIndex partitions keys between multiple independent hash table indexes.
+// Each shard is protected by its own lock, which allows writes to different shards to proceed concurrently.
+type shardedIndex struct {
+	numKeys uint64 // Total number of keys in all shards. Accessed atomically, kept first for 64-bit alignment.
+	shards  []*indexShard
+}
+
+// indexShard is an index, plus the lock protecting it.
+type indexShard struct {
+	mu sync.RWMutex // Allows multiple shard readers or a single writer.
+	*index
+}
+
+func openShardedIndex(opts *Options) (*shardedIndex, error) {
+	first, err := openIndex(opts, 0)
+	if err != nil {
+		return nil, err
+	}
+	// The number of shards is fixed when the index is created.
+	si := &shardedIndex{
+		shards: make([]*indexShard, first.numShards),
+	}
+	si.shards[0] = &indexShard{index: first}
+	for i := 1; i < len(si.shards); i++ {
+		idx, err := openIndex(opts, i)
+		if err != nil {
+			si.shards = si.shards[:i]
+			si.closeFiles()
+			return nil, errors.Wrapf(err, "opening index shard %d", i)
+		}
+		idx.numShards = first.numShards
+		si.shards[i] = &indexShard{index: idx}
+	}
+	for _, sh := range si.shards {
+		si.numKeys += sh.numKeys
+	}
+	return si, nil
+}
+
+// shardIndex maps the hash to a shard.
+// The hash is scrambled first, otherwise shards would correlate with the bucket index,
+// which is derived from the low bits of the hash.
+// The high bits of 64-bit hashes are folded into the low bits.
+func shardIndex(hash uint64, numShards int) int {
+	h := uint32(hash^hash>>32) * 0x9e3779b1
+	return int((uint64(h) * uint64(numShards)) >> 32)
+}
+
+// shard returns the shard responsible for the hash.
+func (si *shardedIndex) shard(hash uint64) *indexShard {
+	if len(si.shards) == 1 {
+		return si.shards[0]
+	}
+	return si.shards[shardIndex(hash, len(si.shards))]
+}
+
+// hashAlgorithm returns the hash function of the index, it's the same for all shards.
+func (si *shardedIndex) hashAlgorithm() HashAlgorithm {
+	return si.shards[0].hashAlgorithm
+}
+
+// put inserts the slot into the shard. The caller must hold the shard write lock.
+func (si *shardedIndex) put(sh *indexShard, newSlot slot, matchKey matchKeyFunc) error {
+	if atomic.LoadUint64(&si.numKeys) == MaxKeys {
+		return ErrFull
+	}
+	numKeys := sh.numKeys
+	if err := sh.index.put(newSlot, matchKey); err != nil {
+		return err
+	}
+	if sh.numKeys > numKeys {
+		atomic.AddUint64(&si.numKeys, 1)
+	}
+	return nil
+}
+
+// deleteSlots removes slots pointing to records of the segment between the start and end offsets.
+// It returns the number of removed keys.
+func (si *shardedIndex) deleteSlots(segmentID uint16, start uint64, end uint64) (int, error) {
+	var deleted int
+	for _, sh := range si.shards {
+		n, err := sh.deleteSlots(segmentID, start, end)
+		if n > 0 {
+			deleted += n
+			atomic.AddUint64(&si.numKeys, ^uint64(n-1))
+		}
+		if err != nil {
+			return deleted, err
+		}
+	}
+	return deleted, nil
+}
+
+// deleteFunc removes the slots of the shard bucket chain for which del returns true.
+// It returns the number of removed keys. The caller must hold the shard write lock.
+func (si *shardedIndex) deleteFunc(sh *indexShard, bucketIdx uint32, del func(sl slot) (bool, error)) (int, error) {
+	n, err := sh.deleteFunc(bucketIdx, del)
+	if n > 0 {
+		atomic.AddUint64(&si.numKeys, ^uint64(n-1))
+	}
+	return n, err
+}
+
+// truncate removes all keys from all shards. The caller must hold the DB write lock.
+func (si *shardedIndex) truncate() error {
+	for _, sh := range si.shards {
+		if err := sh.truncate(); err != nil {
+			return err
+		}
+	}
+	atomic.StoreUint64(&si.numKeys, 0)
+	return nil
+}
+
+// sync commits the index files of all shards.
+func (si *shardedIndex) sync() error {
+	for _, sh := range si.shards {
+		sh.mu.Lock()
+		err := sh.sync()
+		sh.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (si *shardedIndex) count() uint64 {
+	return atomic.LoadUint64(&si.numKeys)
+}
+
+// closeFiles closes the index files without writing the index meta.
+// setWatermark records the datalog the index is closed with.
+func (si *shardedIndex) setWatermark(w datalogWatermark) {
+	for _, sh := range si.shards {
+		sh.watermark = w
+	}
+}
+
+func (si *shardedIndex) closeFiles() {
+	for _, sh := range si.shards {
+		_ = sh.main.Close()
+		_ = sh.overflow.Close()
+	}
+}
+
+func (si *shardedIndex) close() error {
+	for _, sh := range si.shards {
+		if err := sh.close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
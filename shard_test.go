@@ -0,0 +1,71 @@
+package pogreb
+
+import (
+	"encoding/binary"
+	"sync"
+	"testing"
+
+	"github.com/domaincrawler/pogreb/internal/assert"
+)
+
+func TestShardIndex(t *testing.T) {
+	counts := make([]int, 4)
+	for i := uint64(0); i < 1<<16; i++ {
+		counts[shardIndex(i, len(counts))]++
+	}
+	for _, c := range counts {
+		if c < (1<<16)/len(counts)*9/10 {
+			t.Fatalf("uneven shard distribution %v", counts)
+		}
+	}
+}
+
+func TestShardedIndex(t *testing.T) {
+	opts := &Options{IndexShards: 4}
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(db.index.shards))
+
+	const numWorkers = 8
+	const keysPerWorker = 256
+	wg := sync.WaitGroup{}
+	for w := 0; w < numWorkers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < keysPerWorker; i++ {
+				key := make([]byte, 4)
+				binary.LittleEndian.PutUint32(key, uint32(w*keysPerWorker+i))
+				if _, err := db.HasOrPut(key); err != nil {
+					t.Error(err)
+					return
+				}
+				if has, err := db.Has(key); !has || err != nil {
+					t.Error(has, err)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	assert.Equal(t, uint64(numWorkers*keysPerWorker), db.Count())
+	assert.Nil(t, db.Close())
+
+	// The number of shards is persisted.
+	db, err = Open(testDBName, &Options{FileSystem: testFS, IndexShards: 2})
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(db.index.shards))
+	assert.Equal(t, uint64(numWorkers*keysPerWorker), db.Count())
+	var n int
+	it := db.Items()
+	for {
+		_, err := it.Next()
+		if err == ErrIterationDone {
+			break
+		}
+		assert.Nil(t, err)
+		n++
+	}
+	assert.Equal(t, numWorkers*keysPerWorker, n)
+	assert.Nil(t, db.Close())
+}
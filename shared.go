@@ -0,0 +1,98 @@
+package pogreb
+
+import (
+	"path/filepath"
+	"reflect"
+	"sync"
+
+	"github.com/domaincrawler/pogreb/fs"
+	"github.com/domaincrawler/pogreb/internal/errors"
+)
+
+var errSharedFileSystem = errors.New("Options.Shared requires a FileSystem of a comparable type")
+
+// sharedKey identifies a shared database: the absolute path in the file system.
+type sharedKey struct {
+	fsys fs.FileSystem
+	path string
+}
+
+// sharedHandle is a handle of a shared DB returned by one Open call.
+type sharedHandle struct {
+	closed bool // Guarded by the sharedDBs lock.
+}
+
+// sharedDBs holds databases opened with Options.Shared.
+// Databases being closed are kept in closing until their files are closed, Open waits for them.
+var sharedDBs = struct {
+	sync.Mutex
+	dbs     map[sharedKey]*dbState
+	closing map[sharedKey]chan struct{}
+}{dbs: map[sharedKey]*dbState{}, closing: map[sharedKey]chan struct{}{}}
+
+// openShared returns a new handle of the database already opened in the process or opens a new one.
+func openShared(path string, opts *Options) (*DB, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	key := sharedKey{fsys: opts.FileSystem, path: abs}
+	if key.fsys == nil {
+		key.fsys = fs.OSMMap
+	}
+	// Comparing interfaces holding values of types that aren't comparable panics.
+	if !reflect.TypeOf(key.fsys).Comparable() {
+		return nil, errSharedFileSystem
+	}
+	sharedDBs.Lock()
+	defer sharedDBs.Unlock()
+	for {
+		closing, ok := sharedDBs.closing[key]
+		if !ok {
+			break
+		}
+		sharedDBs.Unlock()
+		<-closing
+		sharedDBs.Lock()
+	}
+	if state, ok := sharedDBs.dbs[key]; ok {
+		state.refs++
+		return &DB{dbState: state, handle: &sharedHandle{}}, nil
+	}
+	db, err := open(path, opts)
+	if err != nil {
+		return nil, err
+	}
+	db.handle = &sharedHandle{}
+	db.sharedKey = key
+	db.refs = 1
+	sharedDBs.dbs[key] = db.dbState
+	return db, nil
+}
+
+// releaseShared closes the handle and decrements the number of references to the shared database.
+// It returns true when the last reference is released and the database must be closed,
+// the caller must call closedShared once the files are closed.
+func (db *DB) releaseShared() bool {
+	sharedDBs.Lock()
+	defer sharedDBs.Unlock()
+	if db.handle.closed {
+		return false
+	}
+	db.handle.closed = true
+	db.refs--
+	if db.refs > 0 {
+		return false
+	}
+	delete(sharedDBs.dbs, db.sharedKey)
+	sharedDBs.closing[db.sharedKey] = make(chan struct{})
+	return true
+}
+
+// closedShared releases the Open calls waiting for the shared database to be closed.
+func (db *DB) closedShared() {
+	sharedDBs.Lock()
+	defer sharedDBs.Unlock()
+	close(sharedDBs.closing[db.sharedKey])
+	delete(sharedDBs.closing, db.sharedKey)
+}
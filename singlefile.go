@@ -0,0 +1,84 @@
+package pogreb
+
+import (
+	"os"
+
+	"github.com/domaincrawler/pogreb/fs"
+	"github.com/domaincrawler/pogreb/internal/errors"
+)
+
+const singleFileLockExt = ".lock"
+
+var (
+	errSingleFileFS          = errors.New("single-file databases can't use Options.FileSystem")
+	errSingleFileUnsupported = errors.New("operation isn't supported by single-file databases")
+)
+
+// openSingleFile opens the DB stored in the single file at the path, see Options.SingleFile.
+func openSingleFile(path string, opts *Options) (*DB, error) {
+	if opts.FileSystem != nil {
+		return nil, errSingleFileFS
+	}
+	var osLock fs.LockFile
+	if !opts.ReadOnly {
+		l, _, err := fs.OS.CreateLockFile(path+singleFileLockExt, os.FileMode(0644))
+		if err != nil {
+			if errors.Is(err, os.ErrExist) {
+				err = ErrLocked
+			}
+			return nil, errors.Wrap(err, "creating lock file")
+		}
+		osLock = l
+	}
+	snap, err := fs.OpenMemSnapshot(path, 0)
+	if err != nil {
+		if osLock != nil {
+			_ = osLock.Unlock()
+		}
+		return nil, errors.Wrapf(err, "loading %s", path)
+	}
+	memOpts := *opts
+	memOpts.SingleFile = false
+	memOpts.FileSystem = snap
+	db, err := open(".", &memOpts)
+	if err != nil {
+		if osLock != nil {
+			_ = osLock.Unlock()
+		}
+		return nil, err
+	}
+	db.snapshot = snap
+	db.lock = &singleFileLock{LockFile: db.lock, snapshot: snap, osLock: osLock}
+	return db, nil
+}
+
+// singleFileLock releases the lock of a single-file DB, which is released last by Close, and saves the file.
+type singleFileLock struct {
+	fs.LockFile                 // Lock file of the DB in the memory file system.
+	snapshot    *fs.MemSnapshot // Memory file system of the DB.
+	osLock      fs.LockFile     // Lock file next to the single file, nil if the DB is read-only.
+}
+
+func (l *singleFileLock) Unlock() error {
+	if err := l.LockFile.Unlock(); err != nil {
+		return err
+	}
+	if l.osLock == nil {
+		return nil
+	}
+	if err := l.snapshot.Save(); err != nil {
+		return errors.Wrapf(err, "saving %s", l.snapshot.Path())
+	}
+	return l.osLock.Unlock()
+}
+
+// saveSingleFile writes a writable single-file DB to its file.
+func (db *DB) saveSingleFile() error {
+	if db.snapshot == nil || db.opts.ReadOnly {
+		return nil
+	}
+	if err := db.snapshot.Save(); err != nil {
+		return errors.Wrapf(err, "saving %s", db.snapshot.Path())
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package pogreb
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/domaincrawler/pogreb/fs"
+	"github.com/domaincrawler/pogreb/internal/assert"
+	"github.com/domaincrawler/pogreb/internal/errors"
+)
+
+func TestSingleFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "seen.pdb")
+	opts := &Options{SingleFile: true}
+	db, err := Open(path, opts)
+	assert.Nil(t, err)
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(deleteTestKey(i)))
+	}
+	_, err = Open(path, opts)
+	assert.Equal(t, true, errors.Is(err, ErrLocked))
+	assert.Equal(t, true, errors.Is(db.Clone(filepath.Join(dir, "clone")), errSingleFileUnsupported))
+	assert.Nil(t, db.Close())
+
+	// Only the database file is left.
+	files, err := os.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(files))
+	assert.Equal(t, "seen.pdb", files[0].Name())
+
+	db, err = Open(path, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, false, db.OpenReport().Recovered)
+	assert.Equal(t, uint64(1000), db.Count())
+	assertHas(t, db, deleteTestKey(999), true)
+
+	// A file saved by Sync is recovered.
+	assert.Nil(t, db.Put(deleteTestKey(1000)))
+	assert.Nil(t, db.Sync())
+	assert.Nil(t, db.Put(deleteTestKey(1001)))
+	data, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Close())
+	assert.Nil(t, os.WriteFile(path, data, 0644))
+
+	db, err = Open(path, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, true, db.OpenReport().Recovered)
+	assertHas(t, db, deleteTestKey(1000), true)
+	assertHas(t, db, deleteTestKey(1001), false)
+	assert.Nil(t, db.Close())
+
+	db, err = Open(path, &Options{SingleFile: true, ReadOnly: true})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1001), db.Count())
+	assert.Nil(t, db.Close())
+
+	_, err = Open(path, &Options{SingleFile: true, FileSystem: fs.Mem})
+	assert.Equal(t, errSingleFileFS, err)
+}
@@ -0,0 +1,256 @@
+package pogreb
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	subscriptionBufferSize = 1024     // Number of keys buffered by a subscription channel.
+	subscriptionBatchSize  = 64 << 10 // Size of the records read from the datalog at once.
+)
+
+// subscriptionPollInterval is the time a subscription waits for new records once it has read the datalog tail.
+var subscriptionPollInterval = 10 * time.Millisecond
+
+// subscriptions tracks the goroutines delivering keys to the channels returned by Subscribe.
+type subscriptions struct {
+	mu     sync.Mutex
+	closed bool
+	stop   chan struct{} // Closed by close.
+	wg     sync.WaitGroup
+	active int32 // Number of running subscriptions. Accessed atomically.
+}
+
+// add registers a subscription and returns the channel closed when the DB is closed.
+func (s *subscriptions) add() (<-chan struct{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, errClosed
+	}
+	if s.stop == nil {
+		s.stop = make(chan struct{})
+	}
+	s.wg.Add(1)
+	atomic.AddInt32(&s.active, 1)
+	return s.stop, nil
+}
+
+func (s *subscriptions) done() {
+	atomic.AddInt32(&s.active, -1)
+	s.wg.Done()
+}
+
+// close stops the subscriptions and waits until their channels are closed.
+func (s *subscriptions) close() {
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		if s.stop != nil {
+			close(s.stop)
+		}
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+// rewrittenRange is a run of records copied to a segment by compaction from the segment with the source sequence ID.
+type rewrittenRange struct {
+	start      uint64
+	end        uint64
+	source     uint64
+	sourceLast uint64 // Offset of the last copied record in the source segment.
+}
+
+// recordRewrite marks the record compaction copied to the offset of the segment, so that subscriptions
+// don't deliver its key again. The caller must hold the DB write lock.
+func (dl *datalog) recordRewrite(rec record, segmentID uint16, offset uint64) {
+	src := dl.segments[rec.segmentID]
+	source, sourceOffset := src.sequenceID, rec.offset
+	if r := src.rewrittenAt(rec.offset); r != nil {
+		// The record was copied before, subscriptions deliver it if they haven't read the original record.
+		source, sourceOffset = r.source, r.sourceLast
+	}
+	dl.segments[segmentID].appendRewrite(offset, source, sourceOffset)
+}
+
+// appendRewrite marks the record ending at the segment size as copied from the record at the source offset
+// of the source segment.
+func (seg *segment) appendRewrite(offset uint64, source uint64, sourceOffset uint64) {
+	end := uint64(seg.size)
+	if n := len(seg.rewritten); n > 0 && seg.rewritten[n-1].end == offset && seg.rewritten[n-1].source == source {
+		seg.rewritten[n-1].end = end
+		seg.rewritten[n-1].sourceLast = sourceOffset
+		return
+	}
+	seg.rewritten = append(seg.rewritten, rewrittenRange{start: offset, end: end, source: source, sourceLast: sourceOffset})
+}
+
+// rewrittenAt returns the run of copied records holding the record at the offset, nil if a write wrote the record.
+func (seg *segment) rewrittenAt(offset uint64) *rewrittenRange {
+	i := sort.Search(len(seg.rewritten), func(i int) bool { return seg.rewritten[i].end > offset })
+	if i == len(seg.rewritten) || seg.rewritten[i].start > offset {
+		return nil
+	}
+	return &seg.rewritten[i]
+}
+
+// missedRange is a range of segments removed before a subscriber read them, to is exclusive.
+// The records of the first segment were read up to the offset.
+type missedRange struct {
+	from   uint64
+	to     uint64
+	offset uint64
+}
+
+// subscriber reads the keys written to the datalog after its position.
+type subscriber struct {
+	db         *DB
+	sequenceID uint64 // Sequence ID of the segment of the next record.
+	offset     uint64 // Offset of the next record.
+	// Segments removed before the subscriber read them. The records compaction copied from them
+	// are delivered, they may hold keys the subscriber hasn't seen.
+	missed []missedRange
+}
+
+// Subscribe returns a channel delivering the keys written after the call, e.g. so that a fetch scheduler
+// reacts to new URLs without polling the DB. Keys are read from the datalog tail in the order they were written:
+// a slow receiver doesn't block writers, the keys wait in the datalog. With the SyncAlways and SyncInterval
+// policies keys are delivered once they are synced.
+//
+// Every put record is delivered: a key written again by Put is delivered again, HasOrPut writes only new keys.
+// Keys are delivered as they are stored, see Options.StoreFingerprintsOnly. Records copied by compaction aren't
+// delivered unless the subscription hasn't read them before compaction removed their segment.
+//
+// The channel is closed when the context is done, when the DB is closed, or if reading the datalog fails,
+// the error is then logged.
+func (db *DB) Subscribe(ctx context.Context) (<-chan []byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	stop, err := db.subscriptions.add()
+	if err != nil {
+		return nil, err
+	}
+	db.mu.RLock()
+	db.datalog.mu.RLock()
+	sub := &subscriber{db: db, sequenceID: db.datalog.curSeg.sequenceID, offset: uint64(db.datalog.curSeg.size)}
+	db.datalog.mu.RUnlock()
+	db.mu.RUnlock()
+	ch := make(chan []byte, subscriptionBufferSize)
+	go func() {
+		defer db.subscriptions.done()
+		defer close(ch)
+		sub.run(ctx, stop, ch)
+	}()
+	return ch, nil
+}
+
+func (sub *subscriber) run(ctx context.Context, stop <-chan struct{}, ch chan<- []byte) {
+	for {
+		keys, err := sub.next()
+		if err != nil {
+			sub.db.opts.Logger.Logf(LogError, "error reading keys for a subscription: %v", err)
+			return
+		}
+		for _, key := range keys {
+			select {
+			case ch <- key:
+			case <-ctx.Done():
+				return
+			case <-stop:
+				return
+			}
+		}
+		if len(keys) > 0 {
+			continue
+		}
+		t := time.NewTimer(subscriptionPollInterval)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-stop:
+			t.Stop()
+			return
+		}
+	}
+}
+
+// missedRecords returns true if the subscriber may not have read some of the copied records before their segment was removed.
+func (sub *subscriber) missedRecords(r *rewrittenRange) bool {
+	for _, m := range sub.missed {
+		if r.source >= m.from && r.source < m.to {
+			return r.source > m.from || r.sourceLast >= m.offset
+		}
+	}
+	return false
+}
+
+// next returns the keys of the records following the position, up to subscriptionBatchSize bytes,
+// and advances the position. It returns no keys once the datalog tail is reached.
+func (sub *subscriber) next() ([][]byte, error) {
+	db := sub.db
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	segments := db.datalog.segmentsBySequenceID()
+	db.datalog.mu.RLock()
+	sizes := make([]uint64, len(segments))
+	for i, seg := range segments {
+		sizes[i] = db.datalog.replicableSize(seg)
+	}
+	db.datalog.mu.RUnlock()
+
+	var keys [][]byte
+	read := uint64(0)
+	found := false // The segment of the position exists.
+	for i, seg := range segments {
+		if seg.sequenceID < sub.sequenceID {
+			continue
+		}
+		if seg.sequenceID > sub.sequenceID {
+			m := missedRange{from: sub.sequenceID, to: seg.sequenceID, offset: sub.offset}
+			if found {
+				m = missedRange{from: sub.sequenceID + 1, to: seg.sequenceID}
+			}
+			if n := len(sub.missed); n > 0 && sub.missed[n-1].to == m.from && m.offset == 0 {
+				sub.missed[n-1].to = m.to
+			} else if m.from < m.to {
+				sub.missed = append(sub.missed, m)
+			}
+			sub.sequenceID, sub.offset = seg.sequenceID, headerSize
+		}
+		found = true
+		if sub.offset < headerSize {
+			sub.offset = headerSize
+		}
+		if sub.offset < sizes[i] {
+			it := db.datalog.newSegmentRangeIterator(seg, sub.offset, sizes[i])
+			for it.offset < sizes[i] && read < subscriptionBatchSize {
+				offset := it.offset
+				rec, err := it.next()
+				if err != nil {
+					return nil, err
+				}
+				read += it.offset - offset
+				if rec.rtype != recordTypePut {
+					continue
+				}
+				if r := seg.rewrittenAt(offset); r != nil && !sub.missedRecords(r) {
+					continue
+				}
+				keys = append(keys, rec.key)
+			}
+			sub.offset = it.offset
+			if read >= subscriptionBatchSize {
+				break
+			}
+		}
+	}
+	return keys, nil
+}
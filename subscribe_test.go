@@ -0,0 +1,107 @@
+package pogreb
+
+import (
+	"context"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/domaincrawler/pogreb/internal/assert"
+)
+
+func receiveKeys(t *testing.T, ch <-chan []byte, n int) []int {
+	t.Helper()
+	var keys []int
+	timeout := time.After(10 * time.Second)
+	for len(keys) < n {
+		select {
+		case key, ok := <-ch:
+			if !ok {
+				t.Fatalf("channel closed after %d keys", len(keys))
+			}
+			keys = append(keys, int(binary.BigEndian.Uint32(key)))
+		case <-timeout:
+			t.Fatalf("received %d keys, want %d", len(keys), n)
+		}
+	}
+	return keys
+}
+
+func assertNoKeys(t *testing.T, ch <-chan []byte) {
+	t.Helper()
+	select {
+	case key := <-ch:
+		t.Fatalf("unexpected key %x", key)
+	case <-time.After(5 * subscriptionPollInterval):
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	db, err := createTestDB(&Options{maxSegmentSize: 1024})
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(deleteTestKey(i)))
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, err := db.Subscribe(ctx)
+	assert.Nil(t, err)
+
+	// Keys written before the subscription and deletes aren't delivered.
+	for i := 100; i < 300; i++ {
+		assert.Nil(t, db.Put(deleteTestKey(i)))
+	}
+	assert.Nil(t, db.Delete(deleteTestKey(0)))
+	found, err := db.HasOrPut(deleteTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, true, found)
+	assert.Equal(t, keyRange(100, 300), receiveKeys(t, ch, 200))
+	assertNoKeys(t, ch)
+
+	// Records copied by compaction aren't delivered again.
+	_, err = db.DeleteWhere(func(key []byte) bool {
+		return binary.BigEndian.Uint32(key) < 50
+	})
+	assert.Nil(t, err)
+	assertNoKeys(t, ch)
+	assert.Nil(t, db.Put(deleteTestKey(1000)))
+	assert.Equal(t, []int{1000}, receiveKeys(t, ch, 1))
+
+	cancel()
+	_, ok := <-ch
+	assert.Equal(t, false, ok)
+
+	ch, err = db.Subscribe(context.Background())
+	assert.Nil(t, err)
+	assert.Nil(t, db.Close())
+	_, ok = <-ch
+	assert.Equal(t, false, ok)
+	_, err = db.Subscribe(context.Background())
+	assert.Equal(t, errClosed, err)
+}
+
+func TestSubscribeMissedSegments(t *testing.T) {
+	db, err := createTestDB(&Options{maxSegmentSize: 1024})
+	assert.Nil(t, err)
+	defer db.Close()
+	ch, err := db.Subscribe(context.Background())
+	assert.Nil(t, err)
+	// The channel and the batch fill up, the subscription stops reading until keys are received.
+	n := subscriptionBufferSize + subscriptionBatchSize/10 + 500
+	for i := 0; i < n; i++ {
+		assert.Nil(t, db.Put(deleteTestKey(i)))
+	}
+	time.Sleep(5 * subscriptionPollInterval)
+	// The segments not read yet are rewritten, the keys copied from them are delivered.
+	_, err = db.DeleteWhere(func(key []byte) bool { return false })
+	assert.Nil(t, err)
+	seen := make(map[int]bool)
+	timeout := time.After(10 * time.Second)
+	for len(seen) < n {
+		select {
+		case key := <-ch:
+			seen[int(binary.BigEndian.Uint32(key))] = true
+		case <-timeout:
+			t.Fatalf("received %d keys, want %d", len(seen), n)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package pogreb
+
+// tailCache holds a copy of the records written to the current segment since the last sync.
+// Lookups of recently written keys are served from memory instead of reading the segment file.
+// The cache is modified by writers holding the datalog write lock, readers must hold the read lock.
+type tailCache struct {
+	maxSize int
+	offset  int64 // Segment offset of the first cached byte.
+	data    []byte
+}
+
+func newTailCache(maxSize int) *tailCache {
+	return &tailCache{maxSize: maxSize}
+}
+
+// reset empties the cache, the next cached record starts at the offset.
+func (c *tailCache) reset(offset int64) {
+	c.offset = offset
+	c.data = c.data[:0]
+}
+
+// trim drops cached bytes before the offset.
+func (c *tailCache) trim(offset int64) {
+	n := offset - c.offset
+	if n <= 0 {
+		return
+	}
+	if n >= int64(len(c.data)) {
+		c.reset(offset)
+		return
+	}
+	c.data = c.data[:copy(c.data, c.data[n:])]
+	c.offset = offset
+}
+
+// append caches the record written to the segment at the offset.
+func (c *tailCache) append(offset int64, rec []byte) {
+	if len(rec) > c.maxSize {
+		c.reset(offset + int64(len(rec)))
+		return
+	}
+	if offset != c.offset+int64(len(c.data)) {
+		c.reset(offset)
+	}
+	if len(c.data)+len(rec) > c.maxSize {
+		// Drop the older half at once to amortize the cost of moving data.
+		c.trim(c.offset + int64(len(c.data)+len(rec)-c.maxSize/2))
+	}
+	c.data = append(c.data, rec...)
+}
+
+// slice returns the cached segment data from offset start to offset end.
+func (c *tailCache) slice(start int64, end int64) ([]byte, bool) {
+	if start < c.offset || end > c.offset+int64(len(c.data)) {
+		return nil, false
+	}
+	return c.data[start-c.offset : end-c.offset], true
+}
@@ -0,0 +1,93 @@
+package pogreb
+
+import (
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/domaincrawler/pogreb/fs"
+	"github.com/domaincrawler/pogreb/internal/assert"
+)
+
+// sliceCountingFS counts Slice calls of opened segment files.
+type sliceCountingFS struct {
+	fs.FileSystem
+	slices int32
+}
+
+type sliceCountingFile struct {
+	fs.File
+	slices *int32
+}
+
+func (fsys *sliceCountingFS) OpenFile(name string, flag int, perm os.FileMode) (fs.File, error) {
+	f, err := fsys.FileSystem.OpenFile(name, flag, perm)
+	if err != nil || filepath.Ext(name) != segmentExt {
+		return f, err
+	}
+	return &sliceCountingFile{File: f, slices: &fsys.slices}, nil
+}
+
+func (f *sliceCountingFile) Slice(start int64, end int64) ([]byte, error) {
+	atomic.AddInt32(f.slices, 1)
+	return f.File.Slice(start, end)
+}
+
+func TestTailCache(t *testing.T) {
+	c := newTailCache(10)
+	c.reset(100)
+	c.append(100, []byte{1, 2, 3, 4})
+	c.append(104, []byte{5, 6, 7, 8})
+	data, ok := c.slice(101, 107)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, []byte{2, 3, 4, 5, 6, 7}, data)
+
+	// The older half is dropped when the cache is full.
+	c.append(108, []byte{9, 10, 11, 12})
+	assert.Equal(t, int64(107), c.offset)
+	_, ok = c.slice(104, 108)
+	assert.Equal(t, false, ok)
+	data, ok = c.slice(108, 112)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, []byte{9, 10, 11, 12}, data)
+
+	c.trim(110)
+	_, ok = c.slice(109, 112)
+	assert.Equal(t, false, ok)
+
+	// Non-contiguous writes and records larger than the cache reset it.
+	c.append(200, []byte{1})
+	assert.Equal(t, int64(200), c.offset)
+	c.append(201, make([]byte, 11))
+	assert.Equal(t, 0, len(c.data))
+	assert.Equal(t, int64(212), c.offset)
+}
+
+func TestTailCacheLookups(t *testing.T) {
+	fsys := &sliceCountingFS{FileSystem: testFS}
+	db, err := createTestDB(&Options{FileSystem: fsys, TailCacheSize: 1 << 20})
+	assert.Nil(t, err)
+
+	has := func(key []byte) {
+		t.Helper()
+		found, err := db.Has(key)
+		assert.Nil(t, err)
+		assert.Equal(t, true, found)
+	}
+
+	assert.Nil(t, db.Put([]byte{1}))
+	atomic.StoreInt32(&fsys.slices, 0)
+	has([]byte{1})
+	assert.Equal(t, int32(0), atomic.LoadInt32(&fsys.slices))
+
+	// Synced records are dropped from the cache.
+	assert.Nil(t, db.Sync())
+	assert.Nil(t, db.Put([]byte{2}))
+	has([]byte{2})
+	assert.Equal(t, int32(0), atomic.LoadInt32(&fsys.slices))
+	has([]byte{1})
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fsys.slices))
+
+	assert.Nil(t, db.Close())
+}
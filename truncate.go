@@ -0,0 +1,132 @@
+package pogreb
+
+import (
+	"os"
+	"path/filepath"
+	"sync/atomic"
+
+	"github.com/domaincrawler/pogreb/fs"
+	"github.com/domaincrawler/pogreb/internal/errors"
+)
+
+const truncateBarrierName = "truncate" + metaExt
+
+var errTruncateInterrupted = errors.New("truncation was interrupted, the database must be opened for writing to complete it")
+
+// truncateBarrier is written by a truncation before it removes the segments.
+// Segments up to the sequence ID hold keys written before the truncation.
+type truncateBarrier struct {
+	SequenceID uint64
+}
+
+// Truncate removes all keys without closing the DB, e.g. to reset a frontier between crawl campaigns.
+// Every segment is removed and the index is reset to a single empty bucket.
+// The truncation is atomic: the DB durably records a barrier behind the segments written so far before removing them,
+// if the process crashes, Open completes the truncation. Iterators created before Truncate fail with
+// ErrConcurrentModification. It returns ErrBusy while a compaction is running.
+func (db *DB) Truncate() error {
+	if db.opts.ReadOnly {
+		return errReadOnly
+	}
+	// Compaction reads segments which are about to be removed.
+	if !atomic.CompareAndSwapInt32(&db.compactionRunning, 0, 1) {
+		return ErrBusy
+	}
+	defer atomic.StoreInt32(&db.compactionRunning, 0)
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.truncate()
+}
+
+// truncate removes all keys. The caller must hold the DB write lock.
+func (db *DB) truncate() error {
+	// The checkpoint references the removed segments.
+	if err := db.removeCheckpoint(); err != nil {
+		return err
+	}
+	// Followers can't resume from the removed segments.
+	if err := db.raiseReplicationHorizon(db.datalog.maxSequenceID+1, 0); err != nil {
+		return err
+	}
+	// Segments are removed before the index is reset, a crash leaves the index to be rebuilt from the segments
+	// remaining once Open removed the segments behind the barrier.
+	if err := writeSyncedFile(db.opts.FileSystem, truncateBarrierName, writeGob(truncateBarrier{SequenceID: db.datalog.maxSequenceID})); err != nil {
+		return errors.Wrap(err, "writing truncation barrier")
+	}
+	for _, seg := range db.datalog.segmentsBySequenceID() {
+		if err := db.datalog.removeSegment(seg); err != nil {
+			return err
+		}
+	}
+	db.datalog.mu.Lock()
+	// Iterators started before the truncation fail with ErrConcurrentModification.
+	atomic.StoreUint64(&db.datalog.truncatedGen, atomic.LoadUint64(&db.datalog.generation))
+	db.datalog.curSeg = nil
+	atomic.StoreUint64(&db.datalog.synced, 0)
+	err := db.datalog.swapSegment()
+	db.datalog.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	removed := db.index.count()
+	if err := db.index.truncate(); err != nil {
+		return err
+	}
+	if err := db.opts.FileSystem.Remove(truncateBarrierName); err != nil {
+		return err
+	}
+	db.metrics.Dels.Add(int64(removed))
+	db.invalidation.invalidateAll()
+	db.secondary.clear()
+	db.countWatches.update(0)
+	return nil
+}
+
+// completeTruncation removes the segments behind the barrier of an interrupted truncation.
+// It returns true if a truncation was interrupted, the index must then be rebuilt from the remaining segments.
+func completeTruncation(opts *Options) (bool, error) {
+	fsys := opts.FileSystem
+	if _, err := fsys.Stat(truncateBarrierName); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	if opts.ReadOnly {
+		return false, errTruncateInterrupted
+	}
+	b := truncateBarrier{}
+	if err := readGobFile(fsys, truncateBarrierName, &b); err != nil {
+		return false, errors.Wrap(err, "reading truncation barrier")
+	}
+	opts.Logger.Logf(LogInfo, "completing interrupted truncation of segments up to sequence %d", b.SequenceID)
+	if err := removeSegmentsBefore(fsys, b.SequenceID); err != nil {
+		return false, err
+	}
+	return true, fsys.Remove(truncateBarrierName)
+}
+
+// removeSegmentsBefore removes the segment files with sequence IDs up to the given one, and their meta files.
+func removeSegmentsBefore(fsys fs.FileSystem, sequenceID uint64) error {
+	files, err := fsys.ReadDir(".")
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		name := file.Name()
+		if filepath.Ext(name) != segmentExt {
+			continue
+		}
+		_, seqID, err := parseSegmentName(name)
+		if err != nil || seqID > sequenceID {
+			continue
+		}
+		if err := fsys.Remove(name + metaExt); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		if err := fsys.Remove(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package pogreb
+
+import (
+	"testing"
+
+	"github.com/domaincrawler/pogreb/fs"
+	"github.com/domaincrawler/pogreb/internal/assert"
+)
+
+func TestTruncate(t *testing.T) {
+	opts := &Options{maxSegmentSize: 1024}
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Put(deleteTestKey(i)))
+	}
+	it := db.Items()
+	assert.Nil(t, db.Truncate())
+	_, err = it.Next()
+	assert.Equal(t, ErrConcurrentModification, err)
+	assert.Equal(t, uint64(0), db.Count())
+	assertHas(t, db, deleteTestKey(1), false)
+	assert.Equal(t, 1, countSegments(t, db))
+	assert.Nil(t, db.Put(deleteTestKey(1000)))
+	_, err = testFS.Stat(testDBName + "/" + truncateBarrierName)
+	assert.NotNil(t, err)
+	assert.Nil(t, db.Close())
+
+	db, err = Open(testDBName, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), db.Count())
+	assertHas(t, db, deleteTestKey(1000), true)
+	assert.Nil(t, db.Close())
+}
+
+func TestTruncateInterrupted(t *testing.T) {
+	opts := &Options{maxSegmentSize: 1024}
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Put(deleteTestKey(i)))
+	}
+	// The process crashes once the barrier is written, keys written since are kept.
+	barrier := truncateBarrier{SequenceID: db.datalog.maxSequenceID}
+	assert.Nil(t, writeSyncedFile(db.opts.FileSystem, truncateBarrierName, writeGob(barrier)))
+	db.datalog.mu.Lock()
+	db.datalog.curSeg.meta.Full = true
+	assert.Nil(t, db.datalog.swapSegment())
+	db.datalog.mu.Unlock()
+	for i := 1000; i < 1010; i++ {
+		assert.Nil(t, db.Put(deleteTestKey(i)))
+	}
+	simulateCrash(t, db)
+
+	_, err = completeTruncation(&Options{FileSystem: fs.Sub(testFS, testDBName), ReadOnly: true})
+	assert.Equal(t, errTruncateInterrupted, err)
+
+	db, err = Open(testDBName, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, true, db.OpenReport().Recovered)
+	assert.Equal(t, uint64(10), db.Count())
+	assertHas(t, db, deleteTestKey(1), false)
+	assertHas(t, db, deleteTestKey(1009), true)
+	for _, seg := range db.datalog.segmentsBySequenceID() {
+		assert.Equal(t, true, seg.sequenceID > barrier.SequenceID)
+	}
+	assert.Nil(t, db.Close())
+}
@@ -0,0 +1,222 @@
+package pogreb
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/domaincrawler/pogreb/internal/errors"
+)
+
+const (
+	tuneWriteSize    = 4096
+	tuneReadFileSize = 16 << 20
+
+	tuneDefaultKeySize = 64
+
+	tuneLargeKeyCount      = 1 << 26 // Number of keys above which 32-bit hashes cause frequent false hash matches.
+	tuneCheckpointKeyCount = 1e7     // Number of keys above which rebuilding the index after a crash takes long.
+)
+
+// tuneBenchmarkDuration is the duration of each calibration benchmark run by Tune.
+var tuneBenchmarkDuration = 200 * time.Millisecond
+
+var errInvalidWorkload = errors.New("workload ratios must be between 0 and 1")
+
+// Workload describes the expected use of a database, see Tune.
+type Workload struct {
+	Keys      uint64  // Expected number of keys.
+	KeySize   int     // Average key size in bytes, 64 if not set.
+	ReadRatio float64 // Fraction of the operations which are lookups, from 0 to 1.
+	MissRatio float64 // Fraction of the lookups of keys which aren't stored, from 0 to 1.
+	Writers   int     // Number of goroutines writing concurrently, 1 if not set.
+	Durable   bool    // Written keys must be on durable storage when the write returns.
+}
+
+// TuneResult holds the measurements of the calibration benchmarks run by Tune and the recommended options.
+type TuneResult struct {
+	FsyncLatency   time.Duration             // Average duration of a 4 KiB append followed by fsync.
+	RandomReadIOPS float64                   // Random 4 KiB reads per second, served by the page cache if it holds the file.
+	HashRates      map[HashAlgorithm]float64 // Keys of Workload.KeySize hashed per second by each hash algorithm.
+	Options        *Options                  // Options recommended for the workload, unset fields keep their defaults.
+	Notes          []string                  // Reasons for the recommended settings.
+}
+
+// Tune runs short calibration benchmarks on the storage of the database path and on the CPU,
+// and recommends options for the workload. The benchmarks take under a second, their files are written
+// to a temporary directory next to the path, which is removed afterwards. The database isn't opened.
+func Tune(path string, w Workload) (*TuneResult, error) {
+	if w.ReadRatio < 0 || w.ReadRatio > 1 || w.MissRatio < 0 || w.MissRatio > 1 {
+		return nil, errInvalidWorkload
+	}
+	if w.KeySize <= 0 {
+		w.KeySize = tuneDefaultKeySize
+	}
+	if w.Writers <= 0 {
+		w.Writers = 1
+	}
+	parent := filepath.Dir(filepath.Clean(path))
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return nil, err
+	}
+	dir, err := os.MkdirTemp(parent, ".pogreb-tune-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	res := &TuneResult{}
+	if res.FsyncLatency, err = measureFsyncLatency(filepath.Join(dir, "fsync")); err != nil {
+		return nil, errors.Wrap(err, "measuring fsync latency")
+	}
+	if res.RandomReadIOPS, err = measureRandomReads(filepath.Join(dir, "reads")); err != nil {
+		return nil, errors.Wrap(err, "measuring random reads")
+	}
+	res.HashRates = map[HashAlgorithm]float64{
+		HashMurmur32: measureHashRate(HashMurmur32, w.KeySize),
+		HashXXH64:    measureHashRate(HashXXH64, w.KeySize),
+	}
+	res.recommend(w)
+	return res, nil
+}
+
+// measureFsyncLatency appends blocks to the file, syncing each of them.
+func measureFsyncLatency(name string) (time.Duration, error) {
+	f, err := os.Create(name)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	buf := make([]byte, tuneWriteSize)
+	n := 0
+	start := time.Now()
+	for n < 3 || time.Since(start) < tuneBenchmarkDuration {
+		if _, err := f.Write(buf); err != nil {
+			return 0, err
+		}
+		if err := f.Sync(); err != nil {
+			return 0, err
+		}
+		n++
+	}
+	return time.Since(start) / time.Duration(n), nil
+}
+
+// measureRandomReads writes the file and reads blocks at random offsets.
+func measureRandomReads(name string) (float64, error) {
+	f, err := os.Create(name)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	rnd := rand.New(rand.NewSource(1))
+	buf := make([]byte, tuneReadFileSize)
+	rnd.Read(buf)
+	if _, err := f.Write(buf); err != nil {
+		return 0, err
+	}
+	block := buf[:tuneWriteSize]
+	numBlocks := int64(tuneReadFileSize / tuneWriteSize)
+	n := 0
+	start := time.Now()
+	for time.Since(start) < tuneBenchmarkDuration {
+		if _, err := f.ReadAt(block, rnd.Int63n(numBlocks)*tuneWriteSize); err != nil {
+			return 0, err
+		}
+		n++
+	}
+	return float64(n) / time.Since(start).Seconds(), nil
+}
+
+// measureHashRate hashes keys of the size with the algorithm.
+func measureHashRate(alg HashAlgorithm, keySize int) float64 {
+	key := make([]byte, keySize)
+	var sink uint64
+	n := 0
+	start := time.Now()
+	for time.Since(start) < tuneBenchmarkDuration/4 {
+		for i := 0; i < 1024; i++ {
+			key[0] = byte(i)
+			sink += alg.sum(key, uint32(i))
+		}
+		n += 1024
+	}
+	_ = sink
+	return float64(n) / time.Since(start).Seconds()
+}
+
+// recommend sets the options for the workload from the measurements.
+func (r *TuneResult) recommend(w Workload) {
+	opts := &Options{}
+	note := func(format string, args ...interface{}) {
+		r.Notes = append(r.Notes, fmt.Sprintf(format, args...))
+	}
+
+	if w.Durable {
+		opts.SyncPolicy = SyncAlways
+		note("SyncPolicy: SyncAlways, every write waits for an fsync of %v", r.FsyncLatency)
+		if w.Writers > 1 {
+			// Waiting half an fsync lets the writers queued during the window share the next fsync.
+			latency := r.FsyncLatency / 2
+			if latency < 50*time.Microsecond {
+				latency = 50 * time.Microsecond
+			}
+			if latency > 2*time.Millisecond {
+				latency = 2 * time.Millisecond
+			}
+			opts.GroupCommitLatency = latency
+			note("GroupCommitLatency: %v, %d concurrent writers share fsyncs", latency, w.Writers)
+		}
+	} else {
+		// Background syncs take at most 1% of the time.
+		interval := 100 * r.FsyncLatency
+		if interval < defaultBackgroundSyncInterval {
+			interval = defaultBackgroundSyncInterval
+		}
+		opts.SyncPolicy = SyncInterval
+		opts.BackgroundSyncInterval = interval
+		note("SyncPolicy: SyncInterval every %v, writes made since the last sync are lost if the machine crashes", interval)
+	}
+
+	if w.Keys > tuneLargeKeyCount {
+		opts.HashAlgorithm = HashXXH64
+		note("HashAlgorithm: HashXXH64, with %d keys 32-bit hashes make %.1f%% of the lookups read a wrong key",
+			w.Keys, 100*float64(w.Keys)/(1<<32))
+	} else {
+		opts.HashAlgorithm = HashMurmur32
+		note("HashAlgorithm: HashMurmur32, more slots per index bucket, %.0f keys hashed per second",
+			r.HashRates[HashMurmur32])
+	}
+
+	if w.ReadRatio >= 0.5 && w.MissRatio >= 0.5 {
+		opts.MembershipFilter = true
+		note("MembershipFilter: enabled, %.0f%% of the lookups miss, the filter takes about %d MiB of memory",
+			100*w.MissRatio, 3*w.Keys>>20)
+	}
+
+	if w.Writers > 1 {
+		shards := w.Writers
+		if procs := runtime.GOMAXPROCS(0); shards > procs {
+			shards = procs
+		}
+		if shards > 1 {
+			opts.IndexShards = shards
+			note("IndexShards: %d, writes of different shards proceed concurrently", shards)
+		}
+	}
+
+	if w.ReadRatio < 0.5 {
+		opts.IndexFlushInterval = time.Second
+		note("IndexFlushInterval: 1s, buckets updated by multiple writes are written once, %.0f random reads per second",
+			r.RandomReadIOPS)
+	}
+
+	if w.Keys > tuneCheckpointKeyCount {
+		opts.IndexCheckpointInterval = 5 * time.Minute
+		note("IndexCheckpointInterval: 5m, the recovery after a crash replays the records written since the last checkpoint")
+	}
+	r.Options = opts
+}
@@ -0,0 +1,49 @@
+package pogreb
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/domaincrawler/pogreb/internal/assert"
+)
+
+func TestTune(t *testing.T) {
+	tuneBenchmarkDuration = 5 * time.Millisecond
+	defer func() { tuneBenchmarkDuration = 200 * time.Millisecond }()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "seen")
+
+	res, err := Tune(path, Workload{Keys: 1 << 30, ReadRatio: 0.95, MissRatio: 0.95, Writers: 4, Durable: true})
+	assert.Nil(t, err)
+	assert.Equal(t, true, res.FsyncLatency > 0)
+	assert.Equal(t, true, res.RandomReadIOPS > 0)
+	assert.Equal(t, true, res.HashRates[HashXXH64] > 0)
+	assert.Equal(t, SyncAlways, res.Options.SyncPolicy)
+	assert.Equal(t, true, res.Options.GroupCommitLatency > 0)
+	assert.Equal(t, HashXXH64, res.Options.HashAlgorithm)
+	assert.Equal(t, true, res.Options.MembershipFilter)
+	assert.Equal(t, time.Duration(0), res.Options.IndexFlushInterval)
+	assert.Equal(t, true, len(res.Notes) > 0)
+
+	res, err = Tune(path, Workload{Keys: 1000, ReadRatio: 0.1})
+	assert.Nil(t, err)
+	assert.Equal(t, SyncInterval, res.Options.SyncPolicy)
+	assert.Equal(t, HashMurmur32, res.Options.HashAlgorithm)
+	assert.Equal(t, false, res.Options.MembershipFilter)
+	assert.Equal(t, time.Second, res.Options.IndexFlushInterval)
+
+	// The recommended options open a database.
+	db, err := Open(path, res.Options)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Close())
+
+	// Only the database is left.
+	files, err := os.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(files))
+
+	_, err = Tune(path, Workload{ReadRatio: 2})
+	assert.Equal(t, errInvalidWorkload, err)
+}
@@ -0,0 +1,205 @@
+package pogreb
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"sync/atomic"
+
+	"github.com/domaincrawler/pogreb/internal/errors"
+)
+
+// CorruptedRecord describes a segment record which failed verification.
+// Records following a corrupted record in the same segment can't be read.
+type CorruptedRecord struct {
+	Segment string
+	Offset  int64
+	Err     error
+}
+
+// OrphanedEntry describes an index slot which doesn't point to a valid record.
+type OrphanedEntry struct {
+	SegmentID uint16
+	Offset    uint64
+	Hash      uint64
+	Reason    string
+}
+
+// VerifyReport holds the result of the DB verification.
+type VerifyReport struct {
+	Segments         int
+	Records          int
+	IndexEntries     int
+	IndexKeys        uint64 // Number of keys according to the index, must be equal to IndexEntries.
+	CorruptedRecords []CorruptedRecord
+	OrphanedEntries  []OrphanedEntry
+}
+
+// OK returns true if no problems were found.
+func (r *VerifyReport) OK() bool {
+	return len(r.CorruptedRecords) == 0 && len(r.OrphanedEntries) == 0 && uint64(r.IndexEntries) == r.IndexKeys
+}
+
+// verifySegment checks checksums of the segment records up to the size.
+func verifySegment(ctx context.Context, seg *segment, size int64, report *VerifyReport) error {
+	it, err := newSegmentIterator(seg)
+	if err != nil {
+		return err
+	}
+	for int64(it.offset) < size {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		_, err := it.next()
+		if err == ErrIterationDone {
+			return nil
+		}
+		if err == io.ErrUnexpectedEOF || errors.Is(err, ErrCorrupted) {
+			report.CorruptedRecords = append(report.CorruptedRecords, CorruptedRecord{
+				Segment: seg.name,
+				Offset:  int64(it.offset),
+				Err:     err,
+			})
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		report.Records++
+	}
+	return nil
+}
+
+// verifySlot checks that the slot points to a valid record of the key with the slot hash.
+// It returns the reason of the failure or an empty string. The caller must hold the datalog read lock.
+func (db *DB) verifySlot(sl slot) (string, error) {
+	seg := db.datalog.segments[sl.segmentID]
+	if seg == nil {
+		return "segment doesn't exist", nil
+	}
+	var keyOff, keySize int64
+	if sl.keySize == largeKeyMarker && seg.largeKeys() {
+		if int64(sl.offset)+largeKeyHeaderSize > seg.size {
+			return "offset is out of range", nil
+		}
+		sizeBuf, err := seg.slice(int64(sl.offset)+2, int64(sl.offset)+6)
+		if err != nil {
+			return "", err
+		}
+		keyOff, keySize = largeKeyHeaderSize, int64(binary.LittleEndian.Uint32(sizeBuf))
+	} else {
+		keyOff, keySize = 2, int64(sl.keySize)
+	}
+	end := int64(sl.offset) + keyOff + keySize + int64(seg.recordOverhead()) + 4
+	if int64(sl.offset) < headerSize || end > seg.size {
+		return "offset is out of range", nil
+	}
+	data, err := seg.slice(int64(sl.offset), end)
+	if err != nil {
+		return "", err
+	}
+	if binary.LittleEndian.Uint16(data[:2]) != sl.keySize {
+		return "key size doesn't match", nil
+	}
+	if binary.LittleEndian.Uint32(data[len(data)-4:]) != seg.checksum.sum(data[:len(data)-4]) {
+		return "record checksum doesn't match", nil
+	}
+	key := data[keyOff : keyOff+keySize]
+	if seg.cipher != nil {
+		prefixSize := encryptedPrefixSize(data, seg.largeKeys())
+		plain, err := seg.cipher.decrypt(data[:len(data)-4], sl.offset, prefixSize)
+		if err != nil {
+			return "record authentication failed", nil
+		}
+		key = plain[keyOff-int64(prefixSize):]
+	}
+	if db.hash(key) != sl.hash {
+		return "key hash doesn't match", nil
+	}
+	return "", nil
+}
+
+// verifyShard checks all slots of the index shard.
+func (db *DB) verifyShard(ctx context.Context, shard *indexShard, report *VerifyReport) error {
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	db.datalog.mu.RLock()
+	defer db.datalog.mu.RUnlock()
+	report.IndexKeys += shard.numKeys
+	for bucketIdx := uint32(0); bucketIdx < shard.numBuckets; bucketIdx++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		bit := shard.newBucketIterator(bucketIdx)
+		for {
+			b, err := bit.next()
+			if err == ErrIterationDone {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			for i := 0; i < slotsPerBucket; i++ {
+				sl := b.slots[i]
+				if sl.offset == 0 {
+					break
+				}
+				report.IndexEntries++
+				reason, err := db.verifySlot(sl)
+				if err != nil {
+					return err
+				}
+				if reason != "" {
+					report.OrphanedEntries = append(report.OrphanedEntries, OrphanedEntry{
+						SegmentID: sl.segmentID,
+						Offset:    sl.offset,
+						Hash:      sl.hash,
+						Reason:    reason,
+					})
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Verify checks the integrity of the DB.
+// It validates checksums of all segment records and checks that every index slot points to a valid record.
+// The DB isn't modified, problems are returned in the report.
+// Returns an error if compaction is in progress or the context is done.
+func (db *DB) Verify(ctx context.Context) (*VerifyReport, error) {
+	report := &VerifyReport{}
+
+	// Segments are read the same way as the compaction does, they can't run concurrently.
+	if !atomic.CompareAndSwapInt32(&db.compactionRunning, 0, 1) {
+		return report, ErrBusy
+	}
+	defer func() {
+		atomic.StoreInt32(&db.compactionRunning, 0)
+	}()
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	segments := db.datalog.segmentsBySequenceID()
+	sizes := make([]int64, len(segments))
+	db.datalog.mu.RLock()
+	for i, seg := range segments {
+		sizes[i] = seg.size
+	}
+	db.datalog.mu.RUnlock()
+
+	for i, seg := range segments {
+		report.Segments++
+		if err := verifySegment(ctx, seg, sizes[i], report); err != nil {
+			return report, err
+		}
+	}
+
+	for _, shard := range db.index.shards {
+		if err := db.verifyShard(ctx, shard, report); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
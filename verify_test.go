@@ -0,0 +1,47 @@
+package pogreb
+
+import (
+	"context"
+	"testing"
+
+	"github.com/domaincrawler/pogreb/internal/assert"
+	"github.com/domaincrawler/pogreb/internal/errors"
+)
+
+func TestVerify(t *testing.T) {
+	db, err := createTestDB(&Options{maxSegmentSize: 1024, IndexShards: 2, LargeKeys: true})
+	assert.Nil(t, err)
+	for i := 0; i < 200; i++ {
+		assert.Nil(t, db.Put([]byte{byte(i)}))
+	}
+	assert.Nil(t, db.Put(make([]byte, MaxKeyLength+1)))
+
+	report, err := db.Verify(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, true, report.OK())
+	assert.Equal(t, 201, report.Records)
+	assert.Equal(t, 201, report.IndexEntries)
+	assert.Equal(t, countSegments(t, db), report.Segments)
+
+	// Corrupt the checksum of the first record of the first segment.
+	seg := db.datalog.segmentsBySequenceID()[0]
+	_, err = seg.WriteAt([]byte{0}, int64(headerSize)+3)
+	assert.Nil(t, err)
+
+	report, err = db.Verify(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, false, report.OK())
+	corruption := &CorruptionError{Segment: seg.name, Offset: int64(headerSize), Reason: "record checksum doesn't match"}
+	assert.Equal(t, []CorruptedRecord{{Segment: seg.name, Offset: int64(headerSize), Err: corruption}}, report.CorruptedRecords)
+	assert.Equal(t, true, errors.Is(report.CorruptedRecords[0].Err, ErrCorrupted))
+	assert.Equal(t, 1, len(report.OrphanedEntries))
+	assert.Equal(t, "record checksum doesn't match", report.OrphanedEntries[0].Reason)
+	assert.Equal(t, seg.id, report.OrphanedEntries[0].SegmentID)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = db.Verify(ctx)
+	assert.Equal(t, context.Canceled, err)
+
+	assert.Nil(t, db.Close())
+}
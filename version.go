@@ -0,0 +1,61 @@
+package pogreb
+
+import (
+	"os"
+
+	"github.com/domaincrawler/pogreb/internal/errors"
+)
+
+// Version is the version of the library. It's recorded in the database meta on Close.
+const Version = "0.11.0"
+
+// dbFormat is the revision of the database format written by this version of the library.
+// It's incremented when older versions of the library would misread the database, e.g. on new record types.
+const dbFormat = 2
+
+// formatRevisions is the compatibility table of the database format revisions.
+// A database is opened only by library versions supporting its revision, the database meta records
+// the first version supporting it, so that older versions can report which version is required.
+var formatRevisions = []struct {
+	format     uint32
+	minVersion string
+	change     string
+}{
+	{0, "0.10.1", "databases without a recorded format"},
+	{1, "0.11.0", "delete records and archived segments"},
+	{2, "0.11.0", "record flags"},
+}
+
+// minVersion returns the first library version supporting the format revision.
+func minVersion(format uint32) string {
+	for _, r := range formatRevisions {
+		if r.format == format {
+			return r.minVersion
+		}
+	}
+	return ""
+}
+
+// checkCompatibility reads the database meta and returns an error if the database
+// was written in a format newer than supported, unless Options.AllowNewerFormat is set.
+// It returns the empty meta if the database is new.
+func checkCompatibility(opts *Options) (dbMeta, error) {
+	m := dbMeta{}
+	if err := readGobFile(opts.FileSystem, dbMetaName, &m); err != nil {
+		if os.IsNotExist(err) {
+			return m, nil
+		}
+		// The meta is validated again when it's needed.
+		return dbMeta{}, nil
+	}
+	if m.Format <= dbFormat {
+		return m, nil
+	}
+	err := errors.Wrapf(errNewerFormat, "database format %d written by pogreb %s requires pogreb %s, this is %s",
+		m.Format, m.Version, m.MinVersion, Version)
+	if !opts.AllowNewerFormat {
+		return m, err
+	}
+	opts.Logger.Logf(LogWarn, "opening with Options.AllowNewerFormat: %v", err)
+	return m, nil
+}
@@ -0,0 +1,44 @@
+package pogreb
+
+import (
+	"testing"
+
+	"github.com/domaincrawler/pogreb/internal/assert"
+)
+
+func TestVersionCompatibility(t *testing.T) {
+	db, err := createTestDB(nil)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte{1}))
+	assert.Nil(t, db.Close())
+
+	m := dbMeta{}
+	assert.Nil(t, readGobFile(db.opts.FileSystem, dbMetaName, &m))
+	assert.Equal(t, Version, m.Version)
+	assert.Equal(t, uint32(dbFormat), m.Format)
+	assert.Equal(t, minVersion(dbFormat), m.MinVersion)
+
+	// Simulate a database written by a newer version.
+	m.Version = "9.1.0"
+	m.Format = dbFormat + 1
+	m.MinVersion = "9.0.0"
+	assert.Nil(t, writeGobFile(db.opts.FileSystem, dbMetaName, m))
+
+	_, err = Open(testDBName, &Options{FileSystem: testFS})
+	assert.Equal(t, CodeUnsupported, ErrorCodeOf(err))
+	assert.Equal(t, "database format 3 written by pogreb 9.1.0 requires pogreb 9.0.0, this is "+Version+
+		": database format is newer than supported", err.Error())
+
+	db, err = Open(testDBName, &Options{FileSystem: testFS, AllowNewerFormat: true})
+	assert.Nil(t, err)
+	has, err := db.Has([]byte{1})
+	assert.Nil(t, err)
+	assert.Equal(t, true, has)
+	assert.Nil(t, db.Close())
+
+	// The newer format is kept.
+	assert.Nil(t, readGobFile(db.opts.FileSystem, dbMetaName, &m))
+	assert.Equal(t, Version, m.Version)
+	assert.Equal(t, uint32(dbFormat+1), m.Format)
+	assert.Equal(t, "9.0.0", m.MinVersion)
+}
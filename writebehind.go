@@ -0,0 +1,102 @@
+package pogreb
+
+import (
+	"sort"
+)
+
+const defaultIndexMaxDirtyBuckets = 4096
+
+// bucketKey identifies a bucket in one of the index files.
+type bucketKey struct {
+	f      *file
+	offset int64
+}
+
+// writeBehind buffers modified index buckets in memory.
+// Buckets are written to the index files in batches, a bucket updated multiple times between flushes is written once.
+// Unflushed updates are lost on a crash, the index is then rebuilt from the write-ahead log.
+type writeBehind struct {
+	dirty    map[bucketKey]bucket
+	maxDirty int
+}
+
+func newWriteBehind(opts *Options) *writeBehind {
+	if opts.IndexFlushInterval <= 0 {
+		return nil
+	}
+	return &writeBehind{
+		dirty:    make(map[bucketKey]bucket),
+		maxDirty: opts.IndexMaxDirtyBuckets,
+	}
+}
+
+// readBucket reads the bucket, the buffered version of the bucket takes precedence over the file contents.
+func (idx *index) readBucket(b *bucketHandle) error {
+	if idx.writeBehind != nil {
+		if dirty, ok := idx.writeBehind.dirty[bucketKey{f: b.file, offset: b.offset}]; ok {
+			b.bucket = dirty
+			return nil
+		}
+	}
+	return b.read()
+}
+
+// writeBucket writes the bucket to the index file or buffers it when write-behind is enabled.
+func (idx *index) writeBucket(b *bucketHandle) error {
+	wb := idx.writeBehind
+	if wb == nil {
+		return b.write()
+	}
+	wb.dirty[bucketKey{f: b.file, offset: b.offset}] = b.bucket
+	if len(wb.dirty) >= wb.maxDirty {
+		return idx.flush()
+	}
+	return nil
+}
+
+// flush writes buffered buckets to the index files.
+func (idx *index) flush() error {
+	wb := idx.writeBehind
+	if wb == nil || len(wb.dirty) == 0 {
+		return nil
+	}
+	keys := make([]bucketKey, 0, len(wb.dirty))
+	for k := range wb.dirty {
+		keys = append(keys, k)
+	}
+	// Write buckets in file order.
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].f != keys[j].f {
+			return keys[i].f == idx.main
+		}
+		return keys[i].offset < keys[j].offset
+	})
+	for _, k := range keys {
+		b := bucketHandle{bucket: wb.dirty[k], file: k.f, offset: k.offset}
+		if err := b.write(); err != nil {
+			return err
+		}
+		delete(wb.dirty, k)
+	}
+	return nil
+}
+
+// flush writes buffered index updates of all shards to the index files.
+func (si *shardedIndex) flush() error {
+	for _, sh := range si.shards {
+		sh.mu.Lock()
+		err := sh.flush()
+		sh.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flushIndex writes buffered index updates to the index files.
+func (db *DB) flushIndex() error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.index.flush()
+}
@@ -0,0 +1,71 @@
+package pogreb
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/domaincrawler/pogreb/internal/assert"
+)
+
+func TestIndexWriteBehind(t *testing.T) {
+	opts := &Options{IndexFlushInterval: time.Hour, IndexMaxDirtyBuckets: 16}
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+
+	key := func(i int) []byte {
+		k := make([]byte, 4)
+		binary.LittleEndian.PutUint32(k, uint32(i))
+		return k
+	}
+
+	assert.Nil(t, db.Put(key(0)))
+	shard := db.index.shards[0]
+	assert.Equal(t, 1, len(shard.writeBehind.dirty))
+
+	// The update isn't in the index file yet, but lookups see it.
+	b := bucketHandle{file: shard.main, offset: bucketOffset(0)}
+	assert.Nil(t, b.read())
+	assert.Equal(t, uint64(0), b.slots[0].offset)
+	has, err := db.Has(key(0))
+	assert.Nil(t, err)
+	assert.Equal(t, true, has)
+
+	assert.Nil(t, db.flushIndex())
+	assert.Equal(t, 0, len(shard.writeBehind.dirty))
+	assert.Nil(t, b.read())
+	assert.Equal(t, uint64(headerSize), b.slots[0].offset)
+
+	// Dirty buckets are flushed early once the limit is reached.
+	for i := 1; i < 1000; i++ {
+		assert.Nil(t, db.Put(key(i)))
+		if len(shard.writeBehind.dirty) >= opts.IndexMaxDirtyBuckets {
+			t.Fatalf("expected at most %d dirty buckets, got %d", opts.IndexMaxDirtyBuckets, len(shard.writeBehind.dirty))
+		}
+	}
+	assert.Nil(t, db.Close())
+
+	// Close flushes the buffered updates.
+	db, err = Open(testDBName, &Options{FileSystem: testFS})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1000), db.Count())
+	for i := 0; i < 1000; i++ {
+		has, err := db.Has(key(i))
+		assert.Nil(t, err)
+		assert.Equal(t, true, has)
+	}
+	assert.Nil(t, db.Close())
+
+	// Unflushed updates are recovered from the write-ahead log after a crash.
+	db, err = Open(testDBName, opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put(key(1000)))
+	simulateCrash(t, db)
+	db, err = Open(testDBName, &Options{FileSystem: testFS})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1001), db.Count())
+	has, err = db.Has(key(1000))
+	assert.Nil(t, err)
+	assert.Equal(t, true, has)
+	assert.Nil(t, db.Close())
+}
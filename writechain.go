@@ -0,0 +1,148 @@
+package pogreb
+
+import (
+	"context"
+	"sort"
+)
+
+// WriteStage orders the interceptors of the write chain.
+type WriteStage int
+
+const (
+	// WriteStageValidate rejects malformed keys.
+	WriteStageValidate WriteStage = iota
+
+	// WriteStageTransform rewrites keys, e.g. normalizes them.
+	// The key length check runs after this stage, on the transformed key.
+	// The interceptors of this stage transform the keys looked up and deleted as well, see DB.Has.
+	WriteStageTransform
+
+	// WriteStageBlocklist rejects unwanted keys. Options.Blocklist is the first interceptor of this stage.
+	WriteStageBlocklist
+
+	// WriteStageAccounting observes written keys, e.g. to count them. Options.Accounting is the first interceptor of this stage.
+	WriteStageAccounting
+
+	// WriteStageReplicate observes written keys to forward them, e.g. to a replica.
+	WriteStageReplicate
+)
+
+// WriteInterceptor is an element of the write chain set by Options.WriteInterceptors.
+// Its functions must be safe for concurrent use by multiple goroutines and must not call DB methods.
+type WriteInterceptor struct {
+	// Stage sets the position of the interceptor in the chain.
+	Stage WriteStage
+
+	// Before is called before the key is written. It returns the key to write, the given key or a transformed one,
+	// or an error rejecting the write, which is returned by the write method.
+	// Rejections wrapping ErrBlocked are counted as invalid keys by Import.
+	//
+	// Default: nil, the key is passed unchanged.
+	Before func(key []byte) ([]byte, error)
+
+	// After is called with the written key once the write method succeeded, without holding DB locks.
+	// It isn't called for keys which were already in the DB, except for Put and PutDeferred, which always write.
+	//
+	// Default: nil.
+	After func(key []byte)
+
+	builtin      bool                                  // Set for the built-in interceptors, which don't run on lookups.
+	afterContext func(ctx context.Context, key []byte) // Called instead of After with the context of the write.
+}
+
+// writeChain is the ordered list of interceptors applied to written keys, including the built-in key checks.
+type writeChain []WriteInterceptor
+
+func newWriteChain(opts *Options, acct *accounting, dl *datalog) writeChain {
+	chain := make(writeChain, 0, len(opts.WriteInterceptors)+3)
+	chain = append(chain, opts.WriteInterceptors...)
+	sort.SliceStable(chain, func(i, j int) bool {
+		return chain[i].Stage < chain[j].Stage
+	})
+	// The built-in checks are the last of the transform stage.
+	i := sort.Search(len(chain), func(i int) bool {
+		return chain[i].Stage > WriteStageTransform
+	})
+	builtin := []WriteInterceptor{{
+		Stage:   WriteStageTransform,
+		builtin: true,
+		Before: func(key []byte) ([]byte, error) {
+			if len(key) > MaxKeyLength && (!opts.LargeKeys || len(key) > MaxLargeKeyLength) {
+				return nil, ErrKeyTooLarge
+			}
+			return key, nil
+		},
+	}}
+	if opts.Blocklist != nil {
+		builtin = append(builtin, WriteInterceptor{
+			Stage:   WriteStageBlocklist,
+			builtin: true,
+			Before: func(key []byte) ([]byte, error) {
+				if opts.Blocklist.Contains(key) {
+					return nil, ErrBlocked
+				}
+				return key, nil
+			},
+		})
+	}
+	chain = append(chain[:i], append(builtin, chain[i:]...)...)
+	if acct != nil {
+		i = sort.Search(len(chain), func(i int) bool {
+			return chain[i].Stage >= WriteStageAccounting
+		})
+		account := WriteInterceptor{
+			Stage:   WriteStageAccounting,
+			builtin: true,
+			afterContext: func(ctx context.Context, key []byte) {
+				acct.add(ctx, Usage{Puts: 1, Bytes: dl.recordSize(key)})
+			},
+		}
+		chain = append(chain[:i], append(writeChain{account}, chain[i:]...)...)
+	}
+	return chain
+}
+
+// before runs the Before functions of the chain and returns the key to write.
+func (c writeChain) before(key []byte) ([]byte, error) {
+	for _, wi := range c {
+		if wi.Before == nil {
+			continue
+		}
+		var err error
+		if key, err = wi.Before(key); err != nil {
+			return nil, err
+		}
+	}
+	return key, nil
+}
+
+// transform runs the Before functions of the transform stage set by Options.WriteInterceptors and returns
+// the key to look up. It returns false if an interceptor rejects the key, which then can't be in the DB.
+func (c writeChain) transform(key []byte) ([]byte, bool) {
+	for _, wi := range c {
+		if wi.Stage != WriteStageTransform || wi.builtin || wi.Before == nil {
+			continue
+		}
+		var err error
+		if key, err = wi.Before(key); err != nil {
+			return nil, false
+		}
+	}
+	return key, true
+}
+
+// after runs the After functions of the chain for the written key.
+func (c writeChain) after(key []byte) {
+	c.afterContext(context.Background(), key)
+}
+
+// afterContext is after for a key written with the context, e.g. by PutContext.
+func (c writeChain) afterContext(ctx context.Context, key []byte) {
+	for _, wi := range c {
+		if wi.afterContext != nil {
+			wi.afterContext(ctx, key)
+		} else if wi.After != nil {
+			wi.After(key)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package pogreb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/domaincrawler/pogreb/internal/assert"
+)
+
+func TestWriteChain(t *testing.T) {
+	var mu sync.Mutex
+	var calls []string
+	record := func(call string) {
+		mu.Lock()
+		calls = append(calls, call)
+		mu.Unlock()
+	}
+	errInvalid := errors.New("invalid key")
+	opts := &Options{
+		Blocklist: testBlocklist{"blocked": true},
+		// Listed out of order, the chain orders interceptors by stage.
+		WriteInterceptors: []WriteInterceptor{
+			{
+				Stage: WriteStageReplicate,
+				After: func(key []byte) { record("replicate " + string(key)) },
+			},
+			{
+				Stage: WriteStageTransform,
+				Before: func(key []byte) ([]byte, error) {
+					record("transform")
+					return bytes.ToLower(key), nil
+				},
+			},
+			{
+				Stage: WriteStageAccounting,
+				After: func(key []byte) { record("account " + string(key)) },
+			},
+			{
+				Stage: WriteStageValidate,
+				Before: func(key []byte) ([]byte, error) {
+					record("validate")
+					if bytes.HasPrefix(key, []byte("-")) {
+						return nil, errInvalid
+					}
+					return key, nil
+				},
+			},
+		},
+	}
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+
+	assert.Nil(t, db.Put([]byte("FOO")))
+	assert.Equal(t, "validate transform account foo replicate foo", strings.Join(calls, " "))
+	assertHas(t, db, []byte("foo"), true)
+
+	// After isn't called for keys already in the DB.
+	calls = nil
+	found, err := db.HasOrPut([]byte("Foo"))
+	assert.Nil(t, err)
+	assert.Equal(t, true, found)
+	assert.Equal(t, "validate transform", strings.Join(calls, " "))
+
+	// A rejection stops the chain.
+	calls = nil
+	assert.Equal(t, errInvalid, db.Put([]byte("-bar")))
+	assert.Equal(t, "validate", strings.Join(calls, " "))
+
+	// The blocklist sees the transformed key.
+	calls = nil
+	_, err = db.HasOrPut([]byte("BLOCKED"))
+	assert.Equal(t, ErrBlocked, err)
+	assert.Equal(t, "validate transform", strings.Join(calls, " "))
+
+	calls = nil
+	done := make(chan error, 1)
+	db.PutAsync([]byte("ASYNC"), func(err error) { done <- err })
+	assert.Nil(t, <-done)
+	assert.Equal(t, "validate transform account async replicate async", strings.Join(calls, " "))
+	assertHas(t, db, []byte("async"), true)
+
+	calls = nil
+	progress, err := db.Import(strings.NewReader("Foo\nBAR\nBlocked\n"), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), progress.Inserted)
+	assert.Equal(t, int64(1), progress.Duplicates)
+	assert.Equal(t, int64(1), progress.Invalid)
+	assertHas(t, db, []byte("bar"), true)
+	assert.Equal(t, uint64(3), db.Count())
+	assert.Nil(t, db.Close())
+}
+
+func TestWriteChainImportRejection(t *testing.T) {
+	errOther := errors.New("other")
+	opts := &Options{
+		WriteInterceptors: []WriteInterceptor{{
+			Stage: WriteStageBlocklist,
+			Before: func(key []byte) ([]byte, error) {
+				switch string(key) {
+				case "blocked":
+					return nil, fmt.Errorf("custom blocklist: %w", ErrBlocked)
+				case "fail":
+					return nil, errOther
+				}
+				return key, nil
+			},
+		}},
+	}
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+	progress, err := db.Import(strings.NewReader("a\nblocked\nb\n"), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), progress.Inserted)
+	assert.Equal(t, int64(1), progress.Invalid)
+	_, err = db.Import(strings.NewReader("c\nfail\n"), nil)
+	assert.Equal(t, errOther, err)
+	assert.Nil(t, db.Close())
+}
+
+func TestWriteChainLookups(t *testing.T) {
+	var lookups int
+	opts := &Options{
+		RecordFlags: true,
+		Blocklist:   testBlocklist{"blocked": true},
+		WriteInterceptors: []WriteInterceptor{
+			{
+				Stage: WriteStageValidate,
+				Before: func(key []byte) ([]byte, error) {
+					lookups++
+					return key, nil
+				},
+			},
+			{
+				Stage: WriteStageTransform,
+				Before: func(key []byte) ([]byte, error) {
+					if len(key) == 0 {
+						return nil, errors.New("empty key")
+					}
+					return bytes.ToLower(key), nil
+				},
+			},
+		},
+	}
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte("Foo")))
+	assert.Nil(t, db.Namespace("ns").Put([]byte("Bar")))
+	lookups = 0
+
+	// Reads see the keys as they are written, the validate and blocklist stages don't run.
+	assertHas(t, db, []byte("FOO"), true)
+	found, err := db.HasAny([][]byte{[]byte("x"), []byte("fOO")})
+	assert.Nil(t, err)
+	assert.Equal(t, true, found)
+	found, err = db.HasAll([][]byte{[]byte("FOO"), []byte("foo")})
+	assert.Nil(t, err)
+	assert.Equal(t, true, found)
+	found, flags, err := db.HasFlags([]byte("FOO"))
+	assert.Nil(t, err)
+	assert.Equal(t, true, found)
+	assert.Equal(t, uint16(0), flags)
+	found, err = db.Namespace("ns").Has([]byte("BAR"))
+	assert.Nil(t, err)
+	assert.Equal(t, true, found)
+	assertHas(t, db, []byte("BLOCKED"), false)
+	assert.Equal(t, 0, lookups)
+
+	// A key rejected by a transform isn't in the DB.
+	assertHas(t, db, []byte{}, false)
+	found, err = db.HasAll([][]byte{[]byte("foo"), {}})
+	assert.Nil(t, err)
+	assert.Equal(t, false, found)
+
+	assert.Nil(t, db.Delete([]byte("FOO")))
+	assertHas(t, db, []byte("foo"), false)
+	assert.Nil(t, db.Namespace("ns").Delete([]byte("BAR")))
+	assert.Equal(t, uint64(0), db.Count())
+	assert.Nil(t, db.Close())
+}
@@ -0,0 +1,9 @@
+//go:build !windows
+// +build !windows
+
+package pogreb
+
+// writeThroughFlag is passed when opening segments of a DB syncing after every write.
+// Write-through is only used on Windows, elsewhere O_SYNC would make every write wait for the disk
+// and defeat group commit.
+const writeThroughFlag = 0
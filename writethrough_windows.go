@@ -0,0 +1,10 @@
+//go:build windows
+// +build windows
+
+package pogreb
+
+import "os"
+
+// writeThroughFlag is passed when opening segments of a DB syncing after every write.
+// fs.OS opens such files with FILE_FLAG_WRITE_THROUGH, which makes the syncs cheap.
+const writeThroughFlag = os.O_SYNC